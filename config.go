@@ -0,0 +1,200 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/tools"
+)
+
+// gospatialConfig holds the settings of the configuration file, which are
+// loaded at startup and can be overridden by the corresponding flags.
+type gospatialConfig struct {
+	fileName         string // the file that the settings were read from, if any
+	workingDirectory string
+	threads          int
+	memoryBudget     int64             // in bytes
+	outputFormat     string            // the extension added to output files, e.g. .tif
+	inputFormat      raster.RasterType // the format of input rasters, if not detected
+	compression      string            // none or deflate
+	rowsPerStrip     int               // of GeoTIFF outputs; 0 for 8 KB strips
+	reuseBuffers     bool              // reuse the cell buffers of rasters between tool runs
+}
+
+// configFileName returns the name of the configuration file, which is
+// $GOSPATIALRC or, failing that, .gospatialrc in the home directory.
+func configFileName() string {
+	if s := os.Getenv("GOSPATIALRC"); s != "" {
+		return s
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gospatialrc")
+}
+
+// readConfig reads a configuration file of 'key = value' lines, where lines
+// beginning with '#' are comments, e.g.
+//
+//	workingdirectory = /Users/john/data/
+//	threads = 4
+//	memorybudget = 8 GB
+//	outputformat = tif
+//	compression = deflate
+//	rowsperstrip = 16
+//	reusebuffers = true
+//
+// A missing file is not an error.
+func readConfig(fileName string) (gospatialConfig, error) {
+	cfg := gospatialConfig{}
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	defer f.Close()
+	cfg.fileName = fileName
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return cfg, fmt.Errorf("%s, line %d: expected 'key = value'", fileName, lineNum)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), "\"")
+		if err = cfg.set(key, value); err != nil {
+			return cfg, fmt.Errorf("%s, line %d: %v", fileName, lineNum, err)
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// set parses and assigns a single setting.
+func (cfg *gospatialConfig) set(key, value string) (err error) {
+	switch key {
+	case "workingdirectory", "cwd":
+		cfg.workingDirectory = value
+	case "threads":
+		if cfg.threads, err = strconv.Atoi(value); err != nil || cfg.threads < 0 {
+			return fmt.Errorf("invalid number of threads '%s'", value)
+		}
+	case "memorybudget", "maxmemory":
+		if cfg.memoryBudget, err = parseByteSize(value); err != nil {
+			return err
+		}
+	case "outputformat":
+		ext := strings.ToLower(value)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if rt, err := raster.DetermineRasterFormat("output" + ext); err != nil || rt == raster.RT_UnknownRaster {
+			return fmt.Errorf("unsupported output format '%s'", value)
+		}
+		cfg.outputFormat = ext
+	case "inputformat":
+		if strings.ToLower(value) == "auto" {
+			cfg.inputFormat = raster.RT_UnknownRaster
+		} else if cfg.inputFormat, err = raster.ParseRasterFormat(value); err != nil {
+			return err
+		}
+	case "compression":
+		value = strings.ToLower(value)
+		if value != "none" && value != "deflate" {
+			return fmt.Errorf("invalid compression '%s'; it must be none or deflate", value)
+		}
+		cfg.compression = value
+	case "rowsperstrip":
+		if cfg.rowsPerStrip, err = strconv.Atoi(value); err != nil || cfg.rowsPerStrip < 0 {
+			return fmt.Errorf("invalid rowsperstrip '%s'; it must be 0 or a positive number of rows", value)
+		}
+	case "reusebuffers":
+		if cfg.reuseBuffers, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid reusebuffers '%s'; it must be true or false", value)
+		}
+	default:
+		return fmt.Errorf("unrecognized setting '%s'", key)
+	}
+	return nil
+}
+
+// override sets the settings given by flags, which map the keys of settings
+// to the values of the corresponding command line flags, over those of the
+// configuration file. Blank values, i.e. flags that weren't given, are
+// ignored. It returns the errors of the invalid values.
+func (cfg *gospatialConfig) override(flags map[string]string) []error {
+	var errs []error
+	for key, value := range flags {
+		if value != "" {
+			if err := cfg.set(key, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// apply puts the settings into effect.
+func (cfg gospatialConfig) apply(tm *tools.PluginToolManager) {
+	if cfg.workingDirectory != "" {
+		changeWorkingDirectory(cfg.workingDirectory)
+	}
+	tm.NumThreads = cfg.threads
+	tm.MemoryBudget = cfg.memoryBudget
+	if cfg.memoryBudget > 0 {
+		// a soft limit; the garbage collector works harder as it is approached
+		debug.SetMemoryLimit(cfg.memoryBudget)
+	}
+	if cfg.outputFormat != "" {
+		raster.DefaultExtension = cfg.outputFormat
+	}
+	raster.InputFormat = cfg.inputFormat
+	raster.CompressOutput = cfg.compression == "deflate"
+	raster.RowsPerStrip = cfg.rowsPerStrip
+	if cfg.reuseBuffers {
+		// free buffers are held up to the memory budget
+		raster.Pool = raster.NewBufferPool(cfg.memoryBudget)
+	} else {
+		raster.Pool = nil
+	}
+}
+
+// parseByteSize parses a size in bytes with an optional unit, e.g. 512 MB or
+// 8GB. The units are powers of 1024, as in tools.FormatBytes.
+func parseByteSize(s string) (int64, error) {
+	units := []string{"TB", "GB", "MB", "KB", "B"}
+	multipliers := []float64{1 << 40, 1 << 30, 1 << 20, 1 << 10, 1}
+	str := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for i, unit := range units {
+		if strings.HasSuffix(str, unit) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit))
+			multiplier = multipliers[i]
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid memory size '%s'", s)
+	}
+	return int64(v * multiplier), nil
+}
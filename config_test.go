@@ -0,0 +1,152 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1000", 1000, true},
+		{"512B", 512, true},
+		{"2KB", 2 << 10, true},
+		{"512 MB", 512 << 20, true},
+		{"8GB", 8 << 30, true},
+		{"1TB", 1 << 40, true},
+		{"1.5 gb", 3 << 29, true},
+		{"  4 kb  ", 4 << 10, true},
+		{"", 0, false},
+		{"MB", 0, false},
+		{"lots", 0, false},
+		{"12 PB", 0, false},
+		{"-1GB", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.s)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.s, got, err, tt.want)
+		} else if !tt.ok && err == nil {
+			t.Errorf("parseByteSize(%q) = %d; want an error", tt.s, got)
+		}
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	tests := []struct {
+		key, value string
+		ok         bool
+		check      func(cfg gospatialConfig) bool
+	}{
+		{"workingdirectory", "/data", true, func(cfg gospatialConfig) bool { return cfg.workingDirectory == "/data" }},
+		{"cwd", "/data", true, func(cfg gospatialConfig) bool { return cfg.workingDirectory == "/data" }},
+		{"threads", "4", true, func(cfg gospatialConfig) bool { return cfg.threads == 4 }},
+		{"threads", "0", true, func(cfg gospatialConfig) bool { return cfg.threads == 0 }},
+		{"threads", "-1", false, nil},
+		{"threads", "four", false, nil},
+		{"memorybudget", "2GB", true, func(cfg gospatialConfig) bool { return cfg.memoryBudget == 2<<30 }},
+		{"maxmemory", "64 MB", true, func(cfg gospatialConfig) bool { return cfg.memoryBudget == 64<<20 }},
+		{"memorybudget", "a lot", false, nil},
+		{"outputformat", "tif", true, func(cfg gospatialConfig) bool { return cfg.outputFormat == ".tif" }},
+		{"outputformat", ".DEP", true, func(cfg gospatialConfig) bool { return cfg.outputFormat == ".dep" }},
+		{"outputformat", "xyz", false, nil},
+		{"inputformat", "auto", true, func(cfg gospatialConfig) bool { return cfg.inputFormat == raster.RT_UnknownRaster }},
+		{"inputformat", "geotiff", true, func(cfg gospatialConfig) bool { return cfg.inputFormat == raster.RT_GeoTiff }},
+		{"inputformat", "nonsense", false, nil},
+		{"compression", "Deflate", true, func(cfg gospatialConfig) bool { return cfg.compression == "deflate" }},
+		{"compression", "none", true, func(cfg gospatialConfig) bool { return cfg.compression == "none" }},
+		{"compression", "lzw", false, nil},
+		{"rowsperstrip", "16", true, func(cfg gospatialConfig) bool { return cfg.rowsPerStrip == 16 }},
+		{"rowsperstrip", "-16", false, nil},
+		{"reusebuffers", "true", true, func(cfg gospatialConfig) bool { return cfg.reuseBuffers }},
+		{"reusebuffers", "maybe", false, nil},
+		{"colour", "blue", false, nil},
+	}
+	for _, tt := range tests {
+		var cfg gospatialConfig
+		err := cfg.set(tt.key, tt.value)
+		if tt.ok && err != nil {
+			t.Errorf("set(%q, %q): %v", tt.key, tt.value, err)
+		} else if tt.ok && !tt.check(cfg) {
+			t.Errorf("set(%q, %q) gave %+v", tt.key, tt.value, cfg)
+		} else if !tt.ok && err == nil {
+			t.Errorf("set(%q, %q) = nil; want an error", tt.key, tt.value)
+		}
+	}
+}
+
+func writeTestConfig(t *testing.T, contents string) string {
+	fileName := filepath.Join(t.TempDir(), ".gospatialrc")
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadConfig(t *testing.T) {
+	fileName := writeTestConfig(t, `# GoSpatial settings
+
+Threads = 4
+memorybudget = "512 MB"
+  outputformat=tif
+	# compression = deflate
+reusebuffers = true
+`)
+	cfg, err := readConfig(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.fileName != fileName || cfg.threads != 4 || cfg.memoryBudget != 512<<20 ||
+		cfg.outputFormat != ".tif" || cfg.compression != "" || !cfg.reuseBuffers {
+		t.Errorf("readConfig gave %+v", cfg)
+	}
+
+	cfg, err = readConfig(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || cfg != (gospatialConfig{}) {
+		t.Errorf("readConfig of a missing file = %+v, %v; want an empty config", cfg, err)
+	}
+
+	for _, tt := range []struct{ contents, message string }{
+		{"threads = 4\nthreads 4\n", "line 2: expected 'key = value'"},
+		{"# comment\n\nthreads = -4\n", "line 3: invalid number of threads '-4'"},
+		{"colour = blue\n", "line 1: unrecognized setting 'colour'"},
+	} {
+		fileName := writeTestConfig(t, tt.contents)
+		if _, err := readConfig(fileName); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("readConfig(%q) error = %v; want %q", tt.contents, err, tt.message)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	cfg, err := readConfig(writeTestConfig(t, "threads = 4\nmemorybudget = 1GB\ncompression = deflate\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the flags that were given override the file; the blank ones don't
+	errs := cfg.override(map[string]string{"threads": "8", "memorybudget": "", "compression": "none",
+		"rowsperstrip": ""})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if cfg.threads != 8 || cfg.memoryBudget != 1<<30 || cfg.compression != "none" || cfg.rowsPerStrip != 0 {
+		t.Errorf("override gave %+v", cfg)
+	}
+
+	errs = cfg.override(map[string]string{"threads": "many", "rowsperstrip": "-1"})
+	if len(errs) != 2 {
+		t.Errorf("override of invalid flags gave errors %v; want 2", errs)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+// Package epsg provides a registry of commonly used EPSG coordinate
+// reference systems, including their names, units, and projection
+// parameters.
+package epsg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Code used by GeoTIFF and the EPSG registry to denote a user-defined value.
+const UserDefined = 32767
+
+// CoordinateSystemType distinguishes geographic and projected systems.
+type CoordinateSystemType int
+
+const (
+	Geographic CoordinateSystemType = iota
+	Projected
+)
+
+// Projection identifies the coordinate transformation method of a
+// projected coordinate reference system.
+type Projection int
+
+const (
+	NoProjection Projection = iota
+	TransverseMercator
+	Mercator
+	PseudoMercator
+	LambertConformalConic
+	AlbersEqualArea
+	LambertAzimuthalEqualArea
+	PolarStereographic
+)
+
+var projectionNames = map[Projection]string{
+	NoProjection:              "None",
+	TransverseMercator:        "Transverse_Mercator",
+	Mercator:                  "Mercator_1SP",
+	PseudoMercator:            "Popular_Visualisation_Pseudo_Mercator",
+	LambertConformalConic:     "Lambert_Conformal_Conic_2SP",
+	AlbersEqualArea:           "Albers_Conic_Equal_Area",
+	LambertAzimuthalEqualArea: "Lambert_Azimuthal_Equal_Area",
+	PolarStereographic:        "Polar_Stereographic",
+}
+
+func (p Projection) String() string {
+	if s, ok := projectionNames[p]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// Ellipsoid describes the reference ellipsoid of a geodetic datum.
+type Ellipsoid struct {
+	Code              int
+	Name              string
+	SemiMajorAxis     float64
+	InverseFlattening float64
+}
+
+// Parameters holds the projection parameters of a projected coordinate
+// reference system. Angles are in decimal degrees and distances are in
+// the linear units of the system.
+type Parameters struct {
+	LatitudeOfOrigin  float64
+	CentralMeridian   float64
+	ScaleFactor       float64
+	FalseEasting      float64
+	FalseNorthing     float64
+	StandardParallel1 float64
+	StandardParallel2 float64
+}
+
+// CRS describes a single EPSG coordinate reference system.
+type CRS struct {
+	Code           int
+	Name           string
+	Type           CoordinateSystemType
+	GeographicCode int // the base geographic CRS of a projected CRS
+	DatumCode      int
+	DatumName      string
+	Ellipsoid      Ellipsoid
+	UnitsCode      int
+	Units          string
+	Projection     Projection
+	Params         Parameters
+}
+
+// Returns true if the CRS is a geographic (lat/long) system.
+func (c CRS) IsGeographic() bool {
+	return c.Type == Geographic
+}
+
+func (c CRS) String() string {
+	return fmt.Sprintf("EPSG:%d %s", c.Code, c.Name)
+}
+
+// Lookup retrieves the CRS with the specified EPSG code.
+func Lookup(code int) (CRS, bool) {
+	c, ok := registry[code]
+	return c, ok
+}
+
+// Codes returns a sorted list of the EPSG codes contained in the registry.
+func Codes() []int {
+	ret := make([]int, 0, len(registry))
+	for code := range registry {
+		ret = append(ret, code)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Search returns the coordinate reference systems whose names contain
+// the specified string, ignoring case, sorted by EPSG code.
+func Search(name string) []CRS {
+	name = strings.ToLower(strings.TrimSpace(name))
+	ret := make([]CRS, 0)
+	for _, code := range Codes() {
+		c := registry[code]
+		if strings.Contains(strings.ToLower(c.Name), name) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// WKT returns an OGC well-known text description of the CRS.
+func (c CRS) WKT() string {
+	if c.IsGeographic() {
+		return c.geogcsWKT()
+	}
+	var params []string
+	addParam := func(name string, value float64) {
+		params = append(params, fmt.Sprintf("PARAMETER[\"%s\",%s]", name, formatFloat(value)))
+	}
+	p := c.Params
+	switch c.Projection {
+	case TransverseMercator, Mercator, PseudoMercator:
+		addParam("latitude_of_origin", p.LatitudeOfOrigin)
+		addParam("central_meridian", p.CentralMeridian)
+		addParam("scale_factor", p.ScaleFactor)
+	case LambertConformalConic, AlbersEqualArea:
+		addParam("standard_parallel_1", p.StandardParallel1)
+		addParam("standard_parallel_2", p.StandardParallel2)
+		addParam("latitude_of_origin", p.LatitudeOfOrigin)
+		addParam("central_meridian", p.CentralMeridian)
+	case LambertAzimuthalEqualArea:
+		addParam("latitude_of_center", p.LatitudeOfOrigin)
+		addParam("longitude_of_center", p.CentralMeridian)
+	case PolarStereographic:
+		addParam("latitude_of_origin", p.StandardParallel1)
+		addParam("central_meridian", p.CentralMeridian)
+		addParam("scale_factor", p.ScaleFactor)
+	}
+	addParam("false_easting", p.FalseEasting)
+	addParam("false_northing", p.FalseNorthing)
+
+	geog := c.geogcsWKT()
+	unitSize := 1.0
+	if c.UnitsCode == 9003 {
+		unitSize = 1200.0 / 3937.0
+	} else if c.UnitsCode == 9002 {
+		unitSize = 0.3048
+	}
+	return fmt.Sprintf("PROJCS[\"%s\",%s,PROJECTION[\"%s\"],%s,UNIT[\"%s\",%s,AUTHORITY[\"EPSG\",\"%d\"]],AUTHORITY[\"EPSG\",\"%d\"]]",
+		c.Name, geog, c.Projection.String(), strings.Join(params, ","),
+		c.Units, formatFloat(unitSize), c.UnitsCode, c.Code)
+}
+
+func (c CRS) geogcsWKT() string {
+	code := c.Code
+	name := c.Name
+	if !c.IsGeographic() {
+		code = c.GeographicCode
+		if g, ok := registry[code]; ok {
+			name = g.Name
+		}
+	}
+	e := c.Ellipsoid
+	return fmt.Sprintf("GEOGCS[\"%s\",DATUM[\"%s\",SPHEROID[\"%s\",%s,%s,AUTHORITY[\"EPSG\",\"%d\"]],AUTHORITY[\"EPSG\",\"%d\"]],PRIMEM[\"Greenwich\",0,AUTHORITY[\"EPSG\",\"8901\"]],UNIT[\"degree\",0.0174532925199433,AUTHORITY[\"EPSG\",\"9122\"]],AUTHORITY[\"EPSG\",\"%d\"]]",
+		name, c.DatumName, e.Name, formatFloat(e.SemiMajorAxis), formatFloat(e.InverseFlattening),
+		e.Code, c.DatumCode, code)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package epsg
+
+import (
+	"errors"
+	"math"
+)
+
+var UnsupportedProjectionError = errors.New("The projection of this coordinate reference system is not supported.")
+
+const degToRad = math.Pi / 180.0
+const radToDeg = 180.0 / math.Pi
+
+// Forward projects a geographic coordinate (in decimal degrees, on the
+// datum of the CRS) into the coordinates of the CRS. Geographic systems
+// return the coordinate unchanged.
+func (c CRS) Forward(lon, lat float64) (x, y float64, err error) {
+	switch c.Projection {
+	case NoProjection:
+		if c.IsGeographic() {
+			return lon, lat, nil
+		}
+	case TransverseMercator:
+		x, y = c.tmForward(lon, lat)
+		return x, y, nil
+	}
+	return 0, 0, UnsupportedProjectionError
+}
+
+// Inverse converts a coordinate of the CRS into a geographic coordinate in
+// decimal degrees. Geographic systems return the coordinate unchanged.
+func (c CRS) Inverse(x, y float64) (lon, lat float64, err error) {
+	switch c.Projection {
+	case NoProjection:
+		if c.IsGeographic() {
+			return x, y, nil
+		}
+	case TransverseMercator:
+		lon, lat = c.tmInverse(x, y)
+		return lon, lat, nil
+	}
+	return 0, 0, UnsupportedProjectionError
+}
+
+// Distance along the meridian from the equator to latitude phi (radians).
+// See Snyder (1987) Map Projections - A Working Manual, eq. 3-21.
+func meridianArc(a, e2, phi float64) float64 {
+	e4 := e2 * e2
+	e6 := e4 * e2
+	return a * ((1.0-e2/4.0-3.0*e4/64.0-5.0*e6/256.0)*phi -
+		(3.0*e2/8.0+3.0*e4/32.0+45.0*e6/1024.0)*math.Sin(2.0*phi) +
+		(15.0*e4/256.0+45.0*e6/1024.0)*math.Sin(4.0*phi) -
+		(35.0*e6/3072.0)*math.Sin(6.0*phi))
+}
+
+// Transverse Mercator forward equations (Snyder 1987, eq. 8-9 to 8-15).
+func (c CRS) tmForward(lon, lat float64) (x, y float64) {
+	a := c.Ellipsoid.SemiMajorAxis
+	f := 1.0 / c.Ellipsoid.InverseFlattening
+	e2 := f * (2.0 - f)
+	ep2 := e2 / (1.0 - e2)
+	k0 := c.Params.ScaleFactor
+
+	phi := lat * degToRad
+	phi0 := c.Params.LatitudeOfOrigin * degToRad
+	dLambda := (lon - c.Params.CentralMeridian) * degToRad
+
+	sinPhi := math.Sin(phi)
+	cosPhi := math.Cos(phi)
+	tanPhi := math.Tan(phi)
+	n := a / math.Sqrt(1.0-e2*sinPhi*sinPhi)
+	t := tanPhi * tanPhi
+	cc := ep2 * cosPhi * cosPhi
+	aa := dLambda * cosPhi
+	m := meridianArc(a, e2, phi)
+	m0 := meridianArc(a, e2, phi0)
+
+	x = k0*n*(aa+(1.0-t+cc)*math.Pow(aa, 3)/6.0+
+		(5.0-18.0*t+t*t+72.0*cc-58.0*ep2)*math.Pow(aa, 5)/120.0) + c.Params.FalseEasting
+	y = k0*(m-m0+n*tanPhi*(aa*aa/2.0+(5.0-t+9.0*cc+4.0*cc*cc)*math.Pow(aa, 4)/24.0+
+		(61.0-58.0*t+t*t+600.0*cc-330.0*ep2)*math.Pow(aa, 6)/720.0)) + c.Params.FalseNorthing
+	return x, y
+}
+
+// Transverse Mercator inverse equations (Snyder 1987, eq. 8-17 to 8-25).
+func (c CRS) tmInverse(x, y float64) (lon, lat float64) {
+	a := c.Ellipsoid.SemiMajorAxis
+	f := 1.0 / c.Ellipsoid.InverseFlattening
+	e2 := f * (2.0 - f)
+	e4 := e2 * e2
+	e6 := e4 * e2
+	ep2 := e2 / (1.0 - e2)
+	k0 := c.Params.ScaleFactor
+	phi0 := c.Params.LatitudeOfOrigin * degToRad
+
+	m := meridianArc(a, e2, phi0) + (y-c.Params.FalseNorthing)/k0
+	mu := m / (a * (1.0 - e2/4.0 - 3.0*e4/64.0 - 5.0*e6/256.0))
+	e1 := (1.0 - math.Sqrt(1.0-e2)) / (1.0 + math.Sqrt(1.0-e2))
+	phi1 := mu + (3.0*e1/2.0-27.0*math.Pow(e1, 3)/32.0)*math.Sin(2.0*mu) +
+		(21.0*e1*e1/16.0-55.0*math.Pow(e1, 4)/32.0)*math.Sin(4.0*mu) +
+		(151.0*math.Pow(e1, 3)/96.0)*math.Sin(6.0*mu) +
+		(1097.0*math.Pow(e1, 4)/512.0)*math.Sin(8.0*mu)
+
+	sinPhi1 := math.Sin(phi1)
+	cosPhi1 := math.Cos(phi1)
+	tanPhi1 := math.Tan(phi1)
+	c1 := ep2 * cosPhi1 * cosPhi1
+	t1 := tanPhi1 * tanPhi1
+	n1 := a / math.Sqrt(1.0-e2*sinPhi1*sinPhi1)
+	r1 := a * (1.0 - e2) / math.Pow(1.0-e2*sinPhi1*sinPhi1, 1.5)
+	d := (x - c.Params.FalseEasting) / (n1 * k0)
+
+	lat = phi1 - (n1*tanPhi1/r1)*(d*d/2.0-
+		(5.0+3.0*t1+10.0*c1-4.0*c1*c1-9.0*ep2)*math.Pow(d, 4)/24.0+
+		(61.0+90.0*t1+298.0*c1+45.0*t1*t1-252.0*ep2-3.0*c1*c1)*math.Pow(d, 6)/720.0)
+	lon = (d - (1.0+2.0*t1+c1)*math.Pow(d, 3)/6.0 +
+		(5.0-2.0*c1+28.0*t1-3.0*c1*c1+8.0*ep2+24.0*t1*t1)*math.Pow(d, 5)/120.0) / cosPhi1
+
+	return c.Params.CentralMeridian + lon*radToDeg, lat * radToDeg
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package epsg
+
+import "fmt"
+
+var (
+	ellipsoidWGS84      = Ellipsoid{7030, "WGS 84", 6378137.0, 298.257223563}
+	ellipsoidWGS72      = Ellipsoid{7043, "WGS 72", 6378135.0, 298.26}
+	ellipsoidGRS80      = Ellipsoid{7019, "GRS 1980", 6378137.0, 298.257222101}
+	ellipsoidClarke1866 = Ellipsoid{7008, "Clarke 1866", 6378206.4, 294.978698213898}
+	ellipsoidIntl1924   = Ellipsoid{7022, "International 1924", 6378388.0, 297.0}
+	ellipsoidAiry1830   = Ellipsoid{7001, "Airy 1830", 6377563.396, 299.3249646}
+	ellipsoidANS        = Ellipsoid{7003, "Australian National Spheroid", 6378160.0, 298.25}
+	ellipsoidBessel1841 = Ellipsoid{7004, "Bessel 1841", 6377397.155, 299.1528128}
+)
+
+var registry = make(map[int]CRS)
+
+func init() {
+	// geographic coordinate reference systems
+	addGeographic(4326, "WGS 84", 6326, "WGS_1984", ellipsoidWGS84)
+	addGeographic(4322, "WGS 72", 6322, "WGS_1972", ellipsoidWGS72)
+	addGeographic(4269, "NAD83", 6269, "North_American_Datum_1983", ellipsoidGRS80)
+	addGeographic(4267, "NAD27", 6267, "North_American_Datum_1927", ellipsoidClarke1866)
+	addGeographic(4617, "NAD83(CSRS)", 6140, "NAD83_Canadian_Spatial_Reference_System", ellipsoidGRS80)
+	addGeographic(4258, "ETRS89", 6258, "European_Terrestrial_Reference_System_1989", ellipsoidGRS80)
+	addGeographic(4230, "ED50", 6230, "European_Datum_1950", ellipsoidIntl1924)
+	addGeographic(4277, "OSGB 1936", 6277, "OSGB_1936", ellipsoidAiry1830)
+	addGeographic(4171, "RGF93", 6171, "Reseau_Geodesique_Francais_1993", ellipsoidGRS80)
+	addGeographic(4202, "AGD66", 6202, "Australian_Geodetic_Datum_1966", ellipsoidANS)
+	addGeographic(4203, "AGD84", 6203, "Australian_Geodetic_Datum_1984", ellipsoidANS)
+	addGeographic(4283, "GDA94", 6283, "Geocentric_Datum_of_Australia_1994", ellipsoidGRS80)
+	addGeographic(7844, "GDA2020", 1168, "Geocentric_Datum_of_Australia_2020", ellipsoidGRS80)
+	addGeographic(4167, "NZGD2000", 6167, "New_Zealand_Geodetic_Datum_2000", ellipsoidGRS80)
+	addGeographic(4674, "SIRGAS 2000", 6674, "Sistema_de_Referencia_Geocentrico_para_las_AmericaS_2000", ellipsoidGRS80)
+	addGeographic(4612, "JGD2000", 6612, "Japanese_Geodetic_Datum_2000", ellipsoidGRS80)
+	addGeographic(4148, "Hartebeesthoek94", 6148, "Hartebeesthoek94", ellipsoidWGS84)
+	addGeographic(4314, "DHDN", 6314, "Deutsches_Hauptdreiecksnetz", ellipsoidBessel1841)
+
+	// UTM zones
+	for zone := 1; zone <= 60; zone++ {
+		addUTM(32600+zone, "WGS 84", 4326, zone, true)
+		addUTM(32700+zone, "WGS 84", 4326, zone, false)
+		addUTM(32200+zone, "WGS 72", 4322, zone, true)
+		addUTM(32300+zone, "WGS 72", 4322, zone, false)
+	}
+	for zone := 1; zone <= 23; zone++ {
+		addUTM(26900+zone, "NAD83", 4269, zone, true)
+	}
+	for zone := 1; zone <= 22; zone++ {
+		addUTM(26700+zone, "NAD27", 4267, zone, true)
+	}
+	for zone := 28; zone <= 38; zone++ {
+		addUTM(25800+zone, "ETRS89", 4258, zone, true)
+		addUTM(23000+zone, "ED50", 4230, zone, true)
+	}
+	for zone := 48; zone <= 58; zone++ {
+		addTM(28300+zone, fmt.Sprintf("GDA94 / MGA zone %d", zone), 4283,
+			0.0, float64(zone*6-183), 0.9996, 500000.0, 10000000.0)
+	}
+	for zone := 46; zone <= 59; zone++ {
+		addTM(7800+zone, fmt.Sprintf("GDA2020 / MGA zone %d", zone), 7844,
+			0.0, float64(zone*6-183), 0.9996, 500000.0, 10000000.0)
+	}
+	for zone := 17; zone <= 25; zone++ {
+		addUTM(31960+zone, "SIRGAS 2000", 4674, zone, false)
+	}
+	csrsZones := map[int]int{3154: 7, 3155: 8, 3156: 9, 3157: 10, 2955: 11, 2956: 12,
+		2957: 13, 3158: 14, 3159: 15, 3160: 16, 2958: 17, 2959: 18, 2960: 19,
+		2961: 20, 2962: 21, 3761: 22}
+	for code, zone := range csrsZones {
+		addUTM(code, "NAD83(CSRS)", 4617, zone, true)
+	}
+
+	// other commonly used projected systems
+	addTM(27700, "OSGB 1936 / British National Grid", 4277, 49.0, -2.0, 0.9996012717, 400000.0, -100000.0)
+	addTM(2193, "NZGD2000 / New Zealand Transverse Mercator 2000", 4167, 0.0, 173.0, 0.9996, 1600000.0, 10000000.0)
+	addTM(31467, "DHDN / 3-degree Gauss-Kruger zone 3", 4314, 0.0, 9.0, 1.0, 3500000.0, 0.0)
+	addTM(31468, "DHDN / 3-degree Gauss-Kruger zone 4", 4314, 0.0, 12.0, 1.0, 4500000.0, 0.0)
+	addConic(3347, "NAD83 / Statistics Canada Lambert", 4269, LambertConformalConic, 63.390675, -91.8666666666667, 49.0, 77.0, 6200000.0, 3000000.0)
+	addConic(3978, "NAD83 / Canada Atlas Lambert", 4269, LambertConformalConic, 49.0, -95.0, 49.0, 77.0, 0.0, 0.0)
+	addConic(3161, "NAD83 / Ontario MNR Lambert", 4269, LambertConformalConic, 0.0, -85.0, 44.5, 53.5, 930000.0, 6430000.0)
+	addConic(2154, "RGF93 / Lambert-93", 4171, LambertConformalConic, 46.5, 3.0, 49.0, 44.0, 700000.0, 6600000.0)
+	addConic(5070, "NAD83 / Conus Albers", 4269, AlbersEqualArea, 23.0, -96.0, 29.5, 45.5, 0.0, 0.0)
+	addConic(3577, "GDA94 / Australian Albers", 4283, AlbersEqualArea, 0.0, 132.0, -18.0, -36.0, 0.0, 0.0)
+	addConic(3035, "ETRS89 / LAEA Europe", 4258, LambertAzimuthalEqualArea, 52.0, 10.0, 0.0, 0.0, 4321000.0, 3210000.0)
+
+	c := newProjected(3857, "WGS 84 / Pseudo-Mercator", 4326, PseudoMercator)
+	c.Params = Parameters{ScaleFactor: 1.0}
+	registry[c.Code] = c
+
+	c = newProjected(3395, "WGS 84 / World Mercator", 4326, Mercator)
+	c.Params = Parameters{ScaleFactor: 1.0}
+	registry[c.Code] = c
+
+	c = newProjected(3031, "WGS 84 / Antarctic Polar Stereographic", 4326, PolarStereographic)
+	c.Params = Parameters{LatitudeOfOrigin: -90.0, StandardParallel1: -71.0, ScaleFactor: 1.0}
+	registry[c.Code] = c
+
+	c = newProjected(3413, "WGS 84 / NSIDC Sea Ice Polar Stereographic North", 4326, PolarStereographic)
+	c.Params = Parameters{LatitudeOfOrigin: 90.0, CentralMeridian: -45.0, StandardParallel1: 70.0, ScaleFactor: 1.0}
+	registry[c.Code] = c
+}
+
+func addGeographic(code int, name string, datumCode int, datumName string, e Ellipsoid) {
+	registry[code] = CRS{Code: code, Name: name, Type: Geographic,
+		GeographicCode: code, DatumCode: datumCode, DatumName: datumName,
+		Ellipsoid: e, UnitsCode: 9122, Units: "degree"}
+}
+
+func newProjected(code int, name string, geographicCode int, projection Projection) CRS {
+	g := registry[geographicCode]
+	return CRS{Code: code, Name: name, Type: Projected,
+		GeographicCode: geographicCode, DatumCode: g.DatumCode, DatumName: g.DatumName,
+		Ellipsoid: g.Ellipsoid, UnitsCode: 9001, Units: "metre", Projection: projection}
+}
+
+func addUTM(code int, datumName string, geographicCode int, zone int, north bool) {
+	hemisphere := "N"
+	falseNorthing := 0.0
+	if !north {
+		hemisphere = "S"
+		falseNorthing = 10000000.0
+	}
+	name := fmt.Sprintf("%s / UTM zone %d%s", datumName, zone, hemisphere)
+	addTM(code, name, geographicCode, 0.0, float64(zone*6-183), 0.9996, 500000.0, falseNorthing)
+}
+
+func addTM(code int, name string, geographicCode int, latOrigin, centralMeridian,
+	scaleFactor, falseEasting, falseNorthing float64) {
+	c := newProjected(code, name, geographicCode, TransverseMercator)
+	c.Params = Parameters{LatitudeOfOrigin: latOrigin, CentralMeridian: centralMeridian,
+		ScaleFactor: scaleFactor, FalseEasting: falseEasting, FalseNorthing: falseNorthing}
+	registry[code] = c
+}
+
+func addConic(code int, name string, geographicCode int, projection Projection,
+	latOrigin, centralMeridian, stdParallel1, stdParallel2, falseEasting, falseNorthing float64) {
+	c := newProjected(code, name, geographicCode, projection)
+	c.Params = Parameters{LatitudeOfOrigin: latOrigin, CentralMeridian: centralMeridian,
+		StandardParallel1: stdParallel1, StandardParallel2: stdParallel2,
+		FalseEasting: falseEasting, FalseNorthing: falseNorthing}
+	registry[code] = c
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package epsg
+
+import (
+	"errors"
+	"math"
+)
+
+var InvalidCoordinateError = errors.New("The coordinate is outside of the valid range of longitude and latitude.")
+
+// UTMZone returns the UTM zone number containing a geographic coordinate,
+// including the special zones around Norway and Svalbard.
+func UTMZone(lon, lat float64) int {
+	// normalize the longitude to [-180, 180)
+	lon = math.Mod(lon+180.0, 360.0)
+	if lon < 0 {
+		lon += 360.0
+	}
+	lon -= 180.0
+
+	zone := int(math.Floor((lon+180.0)/6.0)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+
+	if lat >= 56.0 && lat < 64.0 && lon >= 3.0 && lon < 12.0 {
+		zone = 32
+	} else if lat >= 72.0 && lat < 84.0 {
+		if lon >= 0.0 && lon < 9.0 {
+			zone = 31
+		} else if lon >= 9.0 && lon < 21.0 {
+			zone = 33
+		} else if lon >= 21.0 && lon < 33.0 {
+			zone = 35
+		} else if lon >= 33.0 && lon < 42.0 {
+			zone = 37
+		}
+	}
+	return zone
+}
+
+// UTMCode returns the EPSG code of the UTM zone containing a geographic
+// coordinate. The geographicCode is the EPSG code of the datum of the
+// coordinate; where the registry contains a UTM system based on that
+// datum it is used, otherwise the WGS 84 UTM system is returned.
+func UTMCode(geographicCode int, lon, lat float64) (int, error) {
+	if lat < -80.0 || lat > 84.0 || lon < -180.0 || lon > 360.0 {
+		return 0, InvalidCoordinateError
+	}
+	zone := UTMZone(lon, lat)
+	north := lat >= 0.0
+
+	candidates := make([]int, 0)
+	switch geographicCode {
+	case 4269:
+		if north {
+			candidates = append(candidates, 26900+zone)
+		}
+	case 4267:
+		if north {
+			candidates = append(candidates, 26700+zone)
+		}
+	case 4258:
+		if north {
+			candidates = append(candidates, 25800+zone)
+		}
+	case 4230:
+		if north {
+			candidates = append(candidates, 23000+zone)
+		}
+	case 4283:
+		if !north {
+			candidates = append(candidates, 28300+zone)
+		}
+	case 7844:
+		if !north {
+			candidates = append(candidates, 7800+zone)
+		}
+	case 4322:
+		if north {
+			candidates = append(candidates, 32200+zone)
+		} else {
+			candidates = append(candidates, 32300+zone)
+		}
+	}
+	for _, code := range candidates {
+		if c, ok := registry[code]; ok && c.GeographicCode == geographicCode {
+			return code, nil
+		}
+	}
+
+	if north {
+		return 32600 + zone, nil
+	}
+	return 32700 + zone, nil
+}
@@ -1,20 +1,33 @@
 package lidar
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 var println = fmt.Println
 var bo = binary.LittleEndian
 
+var NotLasFileError = errors.New("The file is not a LAS file")
+var UnsupportedPointFormatError = errors.New("Unsupported LAS point record format")
+
+// LazFileError is returned for LAZ files, whose point records are compressed
+// with LASzip, which can't be read.
+var LazFileError = errors.New("LAZ files are not supported; decompress the file to LAS first, e.g. with laszip")
+
 type LasFile struct {
 	fileName    string
 	r           *os.File // io.ReaderAt
 	pointData   []PointData
 	gpsTimeData []GPSTime
+	vlrs        []Vlr
 	Header      LasHeader
 }
 
@@ -32,10 +45,21 @@ type ExtendedVlrHeader struct { // header of the extended variable length record
 	Description             string // 32 characters
 }
 
+// Vlr is a variable length record, or an extended variable length record
+// of LAS 1.4, and its data.
+type Vlr struct {
+	UserID      string
+	RecordID    uint16
+	Description string
+	Data        []byte
+}
+
 func CreateFromFile(fileName string) (*LasFile, error) {
 	var las LasFile
 	las.fileName = fileName
-	las.readFile()
+	if err := las.readFile(); err != nil {
+		return nil, err
+	}
 	return &las, nil
 }
 
@@ -53,27 +77,137 @@ func (las LasFile) GetPointTypeInfo() string {
 	return ""
 }
 
-func (las *LasFile) readFile() {
+func (las *LasFile) readFile() error {
 	// open the file
 	r, err := os.Open(las.fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	las.r = r
-	las.readHeader()
+	if err = las.readHeader(); err == nil {
+		err = las.readVLRs()
+	}
+	if err != nil {
+		r.Close()
+		return err
+	}
+	return nil
 }
 
-func (las *LasFile) readPointData() {
-	las.pointData = make([]PointData, las.Header.NumberPoints)
+// readVLRs reads the variable length records that follow the header and the
+// extended variable length records that follow the points.
+func (las *LasFile) readVLRs() error {
+	offset := int64(las.Header.HeaderSize)
+	for i := uint32(0); i < las.Header.NumberOfVLRs; i++ {
+		b := make([]byte, 54)
+		if _, err := las.r.ReadAt(b, offset); err != nil {
+			return errors.New("Error reading the variable length records")
+		}
+		v := Vlr{
+			UserID:      trimString(b[2:18]),
+			RecordID:    bo.Uint16(b[18:20]),
+			Description: trimString(b[22:54]),
+			Data:        make([]byte, bo.Uint16(b[20:22])),
+		}
+		if _, err := las.r.ReadAt(v.Data, offset+54); err != nil {
+			return errors.New("Error reading the variable length records")
+		}
+		las.vlrs = append(las.vlrs, v)
+		offset += 54 + int64(len(v.Data))
+	}
+
+	offset = int64(las.Header.StartOfFirstEVLR)
+	for i := uint32(0); i < las.Header.NumberOfEVLRs && offset > 0; i++ {
+		b := make([]byte, 60)
+		if _, err := las.r.ReadAt(b, offset); err != nil {
+			return errors.New("Error reading the extended variable length records")
+		}
+		length := bo.Uint64(b[20:28])
+		if length > 1<<30 {
+			return errors.New("Error reading the extended variable length records")
+		}
+		v := Vlr{
+			UserID:      trimString(b[2:18]),
+			RecordID:    bo.Uint16(b[18:20]),
+			Description: trimString(b[28:60]),
+			Data:        make([]byte, length),
+		}
+		if _, err := las.r.ReadAt(v.Data, offset+60); err != nil {
+			return errors.New("Error reading the extended variable length records")
+		}
+		las.vlrs = append(las.vlrs, v)
+		offset += 60 + int64(length)
+	}
+	return nil
+}
+
+func trimString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// ReadPointData reads the point records. They are otherwise read when a point
+// is first requested.
+func (las *LasFile) ReadPointData() error {
+	numPoints := int64(las.Header.NumberPoints)
 	pointRecLen := int64(las.Header.PointRecordLength)
-	initialOffset := int64(las.Header.OffsetToPoints)
-	for i := int64(0); i < int64(las.Header.NumberPoints); i++ {
-		offset := initialOffset + pointRecLen*i
-		las.r.Seek(offset, 0)
-		if err := binary.Read(las.r, bo, &las.pointData[i]); err != nil {
-			panic(errors.New("Error reading point data"))
+	extended := las.Header.PointFormatID >= 6
+	minLen := int64(20)
+	if extended {
+		minLen = 30
+	}
+	if las.Header.PointFormatID > 10 {
+		return UnsupportedPointFormatError
+	}
+	if pointRecLen < minLen {
+		return errors.New("Error reading point data: the point records are too short")
+	}
+	// the legacy formats with GPS times
+	hasTime := extended || las.Header.PointFormatID == 1 || las.Header.PointFormatID >= 3
+
+	pointData := make([]PointData, numPoints)
+	var gpsTimeData []GPSTime
+	if hasTime {
+		gpsTimeData = make([]GPSTime, numPoints)
+	}
+	section := io.NewSectionReader(las.r, int64(las.Header.OffsetToPoints), numPoints*pointRecLen)
+	br := bufio.NewReaderSize(section, 1<<20)
+	b := make([]byte, pointRecLen)
+	for i := int64(0); i < numPoints; i++ {
+		if _, err := io.ReadFull(br, b); err != nil {
+			return errors.New("Error reading point data")
+		}
+		p := &pointData[i]
+		p.X = int32(bo.Uint32(b[0:4]))
+		p.Y = int32(bo.Uint32(b[4:8]))
+		p.Z = int32(bo.Uint32(b[8:12]))
+		p.Intensity = bo.Uint16(b[12:14])
+		if extended {
+			p.BitField = PointBitField(b[14]) | PointBitField(b[15]>>6)<<8
+			p.ClassField = ClassificationBitField(b[16]) | ClassificationBitField(b[15]&15)<<8
+			p.ScanAngle = float32(int16(bo.Uint16(b[18:20]))) * 0.006
+			p.UserData = b[17]
+			p.PointSourceID = bo.Uint16(b[20:22])
+			gpsTimeData[i] = GPSTime(math.Float64frombits(bo.Uint64(b[22:30])))
+		} else {
+			p.BitField = PointBitField(b[14]&7) | PointBitField(b[14]>>3&7)<<4 | PointBitField(b[14]>>6)<<8
+			p.ClassField = ClassificationBitField(b[15]&31) | ClassificationBitField(b[15]>>5)<<8
+			p.ScanAngle = float32(int8(b[16]))
+			p.UserData = b[17]
+			p.PointSourceID = bo.Uint16(b[18:20])
+			if hasTime {
+				gpsTimeData[i] = GPSTime(math.Float64frombits(bo.Uint64(b[20:28])))
+			}
 		}
 	}
+	las.pointData = pointData
+	las.gpsTimeData = gpsTimeData
+	return nil
+}
+
+func (las *LasFile) readPointData() {
+	if err := las.ReadPointData(); err != nil {
+		panic(err)
+	}
 }
 
 // GetFileName Returns the file name
@@ -81,6 +215,64 @@ func (las *LasFile) GetFileName() string {
 	return las.fileName
 }
 
+// GetVLRs returns the variable length records, followed by any extended
+// variable length records.
+func (las *LasFile) GetVLRs() []Vlr {
+	return las.vlrs
+}
+
+// GetEPSGCode returns the EPSG code of the projected or geographic coordinate
+// reference system in the GeoTIFF keys of the file, or zero if there is none.
+func (las *LasFile) GetEPSGCode() int {
+	for _, v := range las.vlrs {
+		if v.UserID != "LASF_Projection" || v.RecordID != 34735 || len(v.Data) < 8 {
+			continue
+		}
+		// a header of four shorts, followed by four shorts per key: the key
+		// id, the tag of its value (zero for values that are stored in the
+		// key itself), the count and the value
+		numKeys := int(bo.Uint16(v.Data[6:8]))
+		geographic := 0
+		for i := 1; i <= numKeys && 8*i+8 <= len(v.Data); i++ {
+			key := v.Data[8*i : 8*i+8]
+			if bo.Uint16(key[2:4]) != 0 {
+				continue
+			}
+			value := int(bo.Uint16(key[6:8]))
+			if value == 0 || value == 32767 { // undefined or user-defined
+				continue
+			}
+			switch bo.Uint16(key[0:2]) {
+			case 3072: // ProjectedCSTypeGeoKey
+				return value
+			case 2048: // GeographicTypeGeoKey
+				geographic = value
+			}
+		}
+		return geographic
+	}
+	return 0
+}
+
+// GetWKT returns the OGC well-known text of the coordinate reference system
+// of the file, or an empty string if there is none.
+func (las *LasFile) GetWKT() string {
+	for _, v := range las.vlrs {
+		if v.UserID == "LASF_Projection" && v.RecordID == 2112 {
+			return strings.TrimRight(string(v.Data), "\x00")
+		}
+	}
+	return ""
+}
+
+// GetPointData returns the record of a point
+func (las *LasFile) GetPointData(n int64) PointData {
+	if las.pointData == nil {
+		las.readPointData()
+	}
+	return las.pointData[n]
+}
+
 // GetPointXYZ returns the x,y,z coordinates of a point
 func (las *LasFile) GetPointXYZ(n int64) (X, Y, Z float64) {
 	if las.pointData == nil {
@@ -92,6 +284,18 @@ func (las *LasFile) GetPointXYZ(n int64) (X, Y, Z float64) {
 	return X, Y, Z
 }
 
+// GetPointGPSTime returns the GPS time of a point, or zero if the point
+// records don't have times
+func (las *LasFile) GetPointGPSTime(n int64) GPSTime {
+	if las.pointData == nil {
+		las.readPointData()
+	}
+	if las.gpsTimeData == nil {
+		return 0
+	}
+	return las.gpsTimeData[n]
+}
+
 // GetPointIntensity returns the intensity associated with a point
 func (las *LasFile) GetPointIntensity(n int64) uint16 {
 	if las.pointData == nil {
@@ -128,3 +332,10 @@ func (las *LasFile) PrintPointData(n int64) {
 func (las *LasFile) Close() error {
 	return las.r.Close()
 }
+
+// isLazFile reports whether a file is compressed with LASzip, which marks
+// the point record format with its two high bits.
+func (las *LasFile) isLazFile() bool {
+	return las.Header.PointFormatID&0xC0 != 0 ||
+		strings.ToLower(filepath.Ext(las.fileName)) == ".laz"
+}
@@ -8,7 +8,6 @@ import (
 	"io"
 	"math"
 	"reflect"
-	"strings"
 )
 
 type LasHeader struct {
@@ -30,8 +29,8 @@ type LasHeader struct {
 	NumberOfVLRs         uint32
 	PointFormatID        byte
 	PointRecordLength    uint16
-	NumberPoints         uint32
-	NumberPointsByReturn [7]uint32
+	NumberPoints         uint64 // the 64-bit count of LAS 1.4, or the legacy count
+	NumberPointsByReturn [15]uint64
 	XScaleFactor         float64
 	YScaleFactor         float64
 	ZScaleFactor         float64
@@ -45,13 +44,25 @@ type LasHeader struct {
 	MaxZ                 float64
 	MinZ                 float64
 	WaveformDataStart    uint64
+	StartOfFirstEVLR     uint64
+	NumberOfEVLRs        uint32
 }
 
-func (las *LasFile) readHeader() {
+func float64At(b []byte, offset int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
+}
+
+func (las *LasFile) readHeader() error {
 
-	b := make([]byte, 243)
-	if _, err := las.r.ReadAt(b[0:243], 0); err != nil && err != io.EOF {
-		panic(err)
+	// the header of LAS 1.0-1.2 is 227 bytes long, that of 1.3 235 bytes and
+	// that of 1.4 375 bytes
+	b := make([]byte, 375)
+	n, err := las.r.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < 227 || string(b[0:4]) != "LASF" {
+		return NotLasFileError
 	}
 
 	las.Header.FileSignature = string(b[0:4])
@@ -63,10 +74,8 @@ func (las *LasFile) readHeader() {
 	las.Header.ProjectID4 = binary.LittleEndian.Uint64(b[16:24])
 	las.Header.VersionMajor = b[24]
 	las.Header.VersionMinor = b[25]
-	las.Header.SystemID = string(b[26:58])
-	las.Header.SystemID = strings.Trim(las.Header.SystemID, " ")
-	las.Header.GeneratingSoftware = string(b[58:90])
-	las.Header.GeneratingSoftware = strings.Trim(las.Header.GeneratingSoftware, " ")
+	las.Header.SystemID = trimString(b[26:58])
+	las.Header.GeneratingSoftware = trimString(b[58:90])
 	las.Header.FileCreationDay = binary.LittleEndian.Uint16(b[90:92])
 	las.Header.FileCreationYear = binary.LittleEndian.Uint16(b[92:94])
 	las.Header.HeaderSize = binary.LittleEndian.Uint16(b[94:96])
@@ -74,50 +83,48 @@ func (las *LasFile) readHeader() {
 	las.Header.NumberOfVLRs = binary.LittleEndian.Uint32(b[100:104])
 	las.Header.PointFormatID = b[104]
 	las.Header.PointRecordLength = binary.LittleEndian.Uint16(b[105:107])
-	las.Header.NumberPoints = binary.LittleEndian.Uint32(b[107:111])
+	las.Header.NumberPoints = uint64(binary.LittleEndian.Uint32(b[107:111]))
 
-	offset := 111
-	var numReturns int
-	if las.Header.VersionMajor == 1 && (las.Header.VersionMinor <= 3) {
-		numReturns = 5
-	} else if las.Header.VersionMajor == 1 && las.Header.VersionMinor > 3 {
-		numReturns = 7
-	} else {
-		panic(errors.New("Unsupported LAS file type"))
+	if las.Header.VersionMajor != 1 || las.Header.VersionMinor > 4 {
+		return errors.New("Unsupported LAS file type")
+	}
+	if las.isLazFile() {
+		return LazFileError
 	}
 
-	for i := 0; i < numReturns; i++ {
-		las.Header.NumberPointsByReturn[i] = binary.LittleEndian.Uint32(b[offset : offset+4])
+	// the legacy counts of the first five returns
+	offset := 111
+	for i := 0; i < 5; i++ {
+		las.Header.NumberPointsByReturn[i] = uint64(binary.LittleEndian.Uint32(b[offset : offset+4]))
 		offset += 4
 	}
 
-	las.Header.XScaleFactor = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.YScaleFactor = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.ZScaleFactor = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.XOffset = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.YOffset = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.ZOffset = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MaxX = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MinX = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MaxY = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MinY = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MaxZ = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	las.Header.MinZ = math.Float64frombits(binary.LittleEndian.Uint64(b[offset : offset+8]))
-	offset += 8
-	if las.Header.VersionMajor == 1 && las.Header.VersionMinor == 3 {
-		las.Header.WaveformDataStart = binary.LittleEndian.Uint64(b[offset : offset+8])
+	las.Header.XScaleFactor = float64At(b, 131)
+	las.Header.YScaleFactor = float64At(b, 139)
+	las.Header.ZScaleFactor = float64At(b, 147)
+	las.Header.XOffset = float64At(b, 155)
+	las.Header.YOffset = float64At(b, 163)
+	las.Header.ZOffset = float64At(b, 171)
+	las.Header.MaxX = float64At(b, 179)
+	las.Header.MinX = float64At(b, 187)
+	las.Header.MaxY = float64At(b, 195)
+	las.Header.MinY = float64At(b, 203)
+	las.Header.MaxZ = float64At(b, 211)
+	las.Header.MinZ = float64At(b, 219)
+	if las.Header.VersionMinor >= 3 && las.Header.HeaderSize >= 235 {
+		las.Header.WaveformDataStart = binary.LittleEndian.Uint64(b[227:235])
+	}
+	if las.Header.VersionMinor >= 4 && las.Header.HeaderSize >= 375 && n >= 375 {
+		las.Header.StartOfFirstEVLR = binary.LittleEndian.Uint64(b[235:243])
+		las.Header.NumberOfEVLRs = binary.LittleEndian.Uint32(b[243:247])
+		las.Header.NumberPoints = binary.LittleEndian.Uint64(b[247:255])
+		offset = 255
+		for i := 0; i < 15; i++ {
+			las.Header.NumberPointsByReturn[i] = binary.LittleEndian.Uint64(b[offset : offset+8])
+			offset += 8
+		}
 	}
+	return nil
 }
 
 func (h LasHeader) String() string {
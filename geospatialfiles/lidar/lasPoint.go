@@ -43,12 +43,15 @@ func (p PointRecord3) String() string {
 	return getPrintString(&p)
 }
 
+// PointData holds the fields that are common to the point record formats.
+// The return and classification fields of formats 0-5 are widened to those
+// of formats 6-10, which were introduced with LAS 1.4.
 type PointData struct {
 	X, Y, Z       int32
 	Intensity     uint16
 	BitField      PointBitField
 	ClassField    ClassificationBitField
-	ScanAngle     int8
+	ScanAngle     float32 // in degrees
 	UserData      byte
 	PointSourceID uint16
 }
@@ -66,26 +69,29 @@ func (p PointData) String() string {
 	return buffer.String()
 }
 
-type PointBitField uint8
+// PointBitField holds the return number (bits 0-3), the number of returns
+// (bits 4-7), the scan direction flag (bit 8) and the edge of flight line
+// flag (bit 9) of a point.
+type PointBitField uint16
 
 func (p *PointBitField) ReturnNumber() byte {
-	//return byte((*p >> 4) & 0x07)
-	return byte(*p & 7) //0x07)
+	return byte(*p & 15)
 }
 
 func (p *PointBitField) NumberOfReturns() byte {
-	//return byte((*p >> 3) & 0x07)
-	return byte((*p >> 3) & 7)
+	return byte((*p >> 4) & 15)
+}
+
+func (p *PointBitField) IsLastReturn() bool {
+	return p.ReturnNumber() >= p.NumberOfReturns()
 }
 
 func (p *PointBitField) ScanDirectionFlag() bool {
-	//return bool(((*p >> 6) & 0x01) == 1)
-	return bool(((*p >> 6) & 1) == 1)
+	return bool(((*p >> 8) & 1) == 1)
 }
 
 func (p *PointBitField) EdgeOfFlightline() bool {
-	//return bool(((*p >> 7) & 0x01) == 1)
-	return bool(((*p >> 7) & 1) == 1)
+	return bool(((*p >> 9) & 1) == 1)
 }
 
 func (p PointBitField) String() string {
@@ -103,11 +109,14 @@ func (p PointBitField) String() string {
 	return buffer.String()
 }
 
-type ClassificationBitField uint8
+// ClassificationBitField holds the class (bits 0-7) and the synthetic
+// (bit 8), key-point (bit 9), withheld (bit 10) and overlap (bit 11) flags of
+// a point. Only the extended point formats have overlap flags and classes
+// above 31.
+type ClassificationBitField uint16
 
 func (c *ClassificationBitField) ClassValue() byte {
-	//return byte((*c >> 3) & 0x1F)
-	return byte(*c & 15)
+	return byte(*c & 255)
 }
 
 func (c *ClassificationBitField) ClassString() string {
@@ -120,15 +129,19 @@ func (c *ClassificationBitField) ClassString() string {
 }
 
 func (c *ClassificationBitField) IsSynthetic() bool {
-	return bool(((*c >> 4) & 1) == 1)
+	return bool(((*c >> 8) & 1) == 1)
 }
 
 func (c *ClassificationBitField) IsKeyPoint() bool {
-	return bool(((*c >> 5) & 1) == 1)
+	return bool(((*c >> 9) & 1) == 1)
 }
 
 func (c *ClassificationBitField) IsWithheld() bool {
-	return bool(((*c >> 6) & 1) == 1)
+	return bool(((*c >> 10) & 1) == 1)
+}
+
+func (c *ClassificationBitField) IsOverlap() bool {
+	return bool(((*c >> 11) & 1) == 1)
 }
 
 func (c ClassificationBitField) String() string {
@@ -143,7 +156,9 @@ func (c ClassificationBitField) String() string {
 	buffer.WriteString(str)
 	str = fmt.Sprintf("IsKeyPoint bool = %v\n", c.IsKeyPoint())
 	buffer.WriteString(str)
-	str = fmt.Sprintf("IsWithheld bool = %v\n}", c.IsWithheld())
+	str = fmt.Sprintf("IsWithheld bool = %v\n", c.IsWithheld())
+	buffer.WriteString(str)
+	str = fmt.Sprintf("IsOverlap bool = %v\n}", c.IsOverlap())
 	buffer.WriteString(str)
 	return buffer.String()
 }
@@ -162,6 +177,12 @@ var classMap = map[byte]string{
 	10: "Reserved for ASPRS Definition",
 	11: "Reserved for ASPRS Definition",
 	12: "Overlap Points2",
+	13: "Wire - Guard (Shield)",
+	14: "Wire - Conductor (Phase)",
+	15: "Transmission Tower",
+	16: "Wire-structure Connector",
+	17: "Bridge Deck",
+	18: "High Noise",
 }
 
 type GPSTime float64
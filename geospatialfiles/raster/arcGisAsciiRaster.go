@@ -12,6 +12,7 @@ package raster
 import (
 	"bufio"
 	"encoding/binary"
+	"fmt"
 	"math"
 	"os"
 	"strconv"
@@ -26,6 +27,7 @@ type arcGisASCIIRaster struct {
 	minimumValue float64
 	maximumValue float64
 	config       *RasterConfig
+	headerOnly   bool // read only the header, leaving the data empty
 }
 
 func (r *arcGisASCIIRaster) InitializeRaster(fileName string,
@@ -43,6 +45,7 @@ func (r *arcGisASCIIRaster) InitializeRaster(fileName string,
 	r.header.west = west
 	r.header.cellCornerMode = true
 	r.header.cellSize = (east - west) / float64(r.header.columns)
+	r.header.cellSizeY = (north - south) / float64(r.header.rows)
 	r.header.nodata = config.NoDataValue
 
 	r.fileName = fileName
@@ -55,7 +58,7 @@ func (r *arcGisASCIIRaster) InitializeRaster(fileName string,
 	}
 
 	// initialize the data array
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	if config.InitialValue != 0 {
 		for i := range r.data {
 			r.data[i] = config.InitialValue
@@ -79,7 +82,7 @@ func (r *arcGisASCIIRaster) SetFileName(value string) (err error) {
 
 	r.fileName = value
 	// does the file exist?
-	if _, err = os.Stat(r.fileName); err == nil {
+	if FileExists(r.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -261,7 +264,9 @@ func (r *arcGisASCIIRaster) SetValue(index int, value float64) {
 //	// do nothing, this raster format does not support RGB colour.
 //}
 
-// Save the file
+// Save the file. Non-square cells are written with DX and DY in place of
+// CELLSIZE, as GDAL does, and 32-bit data with the shortest decimal that
+// reads back as the same float32 value.
 func (r *arcGisASCIIRaster) Save() (err error) {
 	// does the file already exist? If yes, delete it.
 	if _, err = os.Stat(r.fileName); err == nil {
@@ -270,48 +275,65 @@ func (r *arcGisASCIIRaster) Save() (err error) {
 		}
 	}
 
-	// write the header file
-	f, err := os.Create(r.fileName)
-	r.check(err)
+	f, err := createCompressed(r.fileName)
+	if err != nil {
+		return FileOpeningError
+	}
 	defer f.Close()
 	w := bufio.NewWriter(f)
-	var str string
-	str = "NCOLS         " + strconv.Itoa(r.header.columns)
-	_, err = w.WriteString(str + "\n")
-	r.check(err)
-	str = "NROWS         " + strconv.Itoa(r.header.rows)
-	w.WriteString(str + "\n")
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	// write the header
+	fmt.Fprintf(w, "NCOLS         %d\n", r.header.columns)
+	fmt.Fprintf(w, "NROWS         %d\n", r.header.rows)
 	if r.header.cellCornerMode {
-		str = "XLLCORNER     " + strconv.FormatFloat(r.header.west, 'f', -1, 64)
-		w.WriteString(str + "\n")
-		str = "YLLCORNER     " + strconv.FormatFloat(r.header.south, 'f', -1, 64)
-		w.WriteString(str + "\n")
+		fmt.Fprintf(w, "XLLCORNER     %s\n", formatFloat(r.header.west))
+		fmt.Fprintf(w, "YLLCORNER     %s\n", formatFloat(r.header.south))
+	} else {
+		fmt.Fprintf(w, "XLLCENTER     %s\n", formatFloat(r.header.west+r.header.cellSize/2.0))
+		fmt.Fprintf(w, "YLLCENTER     %s\n", formatFloat(r.header.south+r.header.cellSizeY/2.0))
+	}
+	if r.header.cellSizeY == 0 || math.Abs(r.header.cellSizeY-r.header.cellSize) <= 1e-9*r.header.cellSize {
+		fmt.Fprintf(w, "CELLSIZE      %s\n", formatFloat(r.header.cellSize))
 	} else {
-		str = "XLLCENTER     " + strconv.FormatFloat(r.header.west+r.header.cellSize/2.0, 'f', -1, 64)
-		w.WriteString(str + "\n")
-		str = "YLLCENTER     " + strconv.FormatFloat(r.header.south+r.header.cellSize/2.0, 'f', -1, 64)
-		w.WriteString(str + "\n")
+		fmt.Fprintf(w, "DX            %s\n", formatFloat(r.header.cellSize))
+		fmt.Fprintf(w, "DY            %s\n", formatFloat(r.header.cellSizeY))
+	}
+	fmt.Fprintf(w, "NODATA_VALUE  %s\n", formatFloat(r.header.nodata))
+
+	// write the data, a row per line
+	bitSize := 64
+	if r.config != nil && r.config.DataType == DT_FLOAT32 {
+		bitSize = 32
 	}
-	str = "CELLSIZE      " + strconv.FormatFloat(r.header.cellSize, 'f', -1, 64)
-	w.WriteString(str + "\n")
-	str = "NODATA_VALUE  " + strconv.FormatFloat(r.header.nodata, 'f', -1, 64)
-	w.WriteString(str + "\n")
+	buf := make([]byte, 0, 16*r.header.columns)
 	cellNum := 0
 	for row := 0; row < r.header.rows; row++ {
-		str = ""
+		buf = buf[:0]
 		for col := 0; col < r.header.columns; col++ {
-			str += strconv.FormatFloat(r.data[cellNum], 'f', -1, 64) + " "
+			if col > 0 {
+				buf = append(buf, ' ')
+			}
+			v := r.data[cellNum]
+			if v == r.header.nodata {
+				buf = strconv.AppendFloat(buf, v, 'f', -1, 64)
+			} else {
+				buf = strconv.AppendFloat(buf, v, 'f', -1, bitSize)
+			}
 			cellNum++
 		}
-		str = strings.TrimSpace(str) + "\n"
-		w.WriteString(str)
+		buf = append(buf, '\n')
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
 	}
 
-	w.Flush()
-
-	// write the data file
-
-	return nil
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 // Reads the file
@@ -321,89 +343,123 @@ func (r *arcGisASCIIRaster) ReadFile() error {
 		return FileReadingError
 	}
 
-	// read in the first six lines of the file
-	var xllcenter float64
-	var yllcenter float64
-	var xllcorner float64
-	var yllcorner float64
-
-	f, err := os.Open(r.fileName)
+	f, err := OpenDecompressed(r.fileName)
 	if err != nil {
 		return FileOpeningError
 	}
 	defer f.Close()
 
+	// the header lines are keyword-value pairs, in any order and case. The
+	// lower-left corner is that of the cell or its centre, NODATA_value is
+	// optional, and GDAL writes DX and DY in place of CELLSIZE for
+	// non-square cells.
+	var xll, yll float64
+	haveCorner, haveCentre := false, false
+	r.header.nodata = -32768.0 // used only if the header doesn't specify one
+	r.header.cellSize, r.header.cellSizeY = 0, 0
+
 	scanner := bufio.NewScanner(f)
-	lineNum := 0
+	scanner.Buffer(nil, maxLineLength) // a row of a wide grid is a long line
 	cellNum := 0
+	inData := false
 	for scanner.Scan() {
-		str := strings.ToLower(scanner.Text())
-		lineNum++
-		if lineNum <= 6 {
-			if strings.Contains(str, "ncols") {
-				s := strings.Fields(str)
-				r.header.columns, err = strconv.Atoi(s[len(s)-1])
-				r.check(err)
-				if r.header.rows > 0 {
-					r.header.numCells = r.header.columns * r.header.rows
-					r.data = make([]float64, r.header.numCells)
+		s := strings.Fields(scanner.Text())
+		if len(s) == 0 {
+			continue
+		}
+		if !inData {
+			key := strings.ToLower(s[0])
+			var value float64
+			isKey := true
+			switch key {
+			case "ncols", "nrows", "xllcorner", "yllcorner", "xllcenter", "yllcenter",
+				"cellsize", "dx", "dy", "nodata_value":
+				if len(s) < 2 {
+					return fmt.Errorf("The ASCII grid header line '%s' has no value.", scanner.Text())
+				}
+				if value, err = strconv.ParseFloat(s[1], 64); err != nil {
+					return fmt.Errorf("The ASCII grid header line '%s' has an invalid value.", scanner.Text())
 				}
-			} else if strings.Contains(str, "nrows") {
-				s := strings.Fields(str)
-				r.header.rows, err = strconv.Atoi(s[len(s)-1])
-				r.check(err)
-				if r.header.columns > 0 {
-					r.header.numCells = r.header.columns * r.header.rows
-					r.data = make([]float64, r.header.numCells)
+			default:
+				isKey = false
+			}
+			if isKey {
+				switch key {
+				case "ncols":
+					r.header.columns = int(value)
+				case "nrows":
+					r.header.rows = int(value)
+				case "xllcorner":
+					xll, haveCorner = value, true
+				case "yllcorner":
+					yll, haveCorner = value, true
+				case "xllcenter":
+					xll, haveCentre = value, true
+				case "yllcenter":
+					yll, haveCentre = value, true
+				case "cellsize":
+					r.header.cellSize, r.header.cellSizeY = value, value
+				case "dx":
+					r.header.cellSize = value
+				case "dy":
+					r.header.cellSizeY = value
+				case "nodata_value":
+					r.header.nodata = value
 				}
-			} else if strings.Contains(str, "nodata") {
-				s := strings.Fields(str)
-				r.header.nodata, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
-			} else if strings.Contains(str, "cellsize") {
-				s := strings.Fields(str)
-				r.header.cellSize, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
-			} else if strings.Contains(str, "xllcenter") {
-				s := strings.Fields(str)
-				xllcenter, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
-			} else if strings.Contains(str, "yllcenter") {
-				s := strings.Fields(str)
-				yllcenter, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
-			} else if strings.Contains(str, "xllcorner") {
-				s := strings.Fields(str)
-				xllcorner, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
-			} else if strings.Contains(str, "yllcorner") {
-				s := strings.Fields(str)
-				yllcorner, err = strconv.ParseFloat(s[len(s)-1], 64)
-				r.check(err)
+				continue
+			}
+			// it's the first data line
+			// a negative DY is that of a south-up grid
+			if r.header.cellSize <= 0 || r.header.cellSizeY == 0 {
+				return fmt.Errorf("The ASCII grid %s has no cell size.", r.fileName)
+			}
+			if r.headerOnly {
+				break
 			}
-		} else { // it's a data line
-			s := strings.Fields(str)
-			for _, v := range s {
-				r.data[cellNum], _ = strconv.ParseFloat(v, 64)
-				cellNum++
+			if err = r.allocateData(); err != nil {
+				return err
 			}
+			inData = true
+		}
+		for _, v := range s {
+			if cellNum < r.header.numCells {
+				if r.data[cellNum], err = strconv.ParseFloat(v, 64); err != nil {
+					return fmt.Errorf("The ASCII grid %s has an invalid value '%s'.", r.fileName, v)
+				}
+			}
+			cellNum++
 		}
 	}
-
-	//set the North, East, South, and West coodinates
-	if xllcorner != 0 {
-		r.header.cellCornerMode = true
-		r.header.east = xllcorner + float64(r.header.columns)*r.header.cellSize
-		r.header.west = xllcorner
-		r.header.south = yllcorner
-		r.header.north = yllcorner + float64(r.header.rows)*r.header.cellSize
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if r.headerOnly {
+		if err = checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+			return err
+		}
+		r.header.numCells = r.header.columns * r.header.rows
 	} else {
-		r.header.cellCornerMode = false
-		r.header.east = xllcenter - (0.5 * r.header.cellSize) + float64(r.header.columns)*r.header.cellSize
-		r.header.west = xllcenter - (0.5 * r.header.cellSize)
-		r.header.south = yllcenter - (0.5 * r.header.cellSize)
-		r.header.north = yllcenter - (0.5 * r.header.cellSize) + float64(r.header.rows)*r.header.cellSize
+		if !inData {
+			if err = r.allocateData(); err != nil {
+				return err
+			}
+		}
+		if cellNum != r.header.numCells {
+			return &DataSizeError{r.fileName, r.header.rows, r.header.columns,
+				int64(r.header.numCells), int64(cellNum), "values"}
+		}
+	}
+
+	// set the North, East, South, and West coodinates
+	r.header.cellCornerMode = haveCorner || !haveCentre
+	if !r.header.cellCornerMode {
+		xll -= 0.5 * r.header.cellSize
+		yll -= 0.5 * r.header.cellSizeY
 	}
+	r.header.west = xll
+	r.header.south = yll
+	r.header.east = xll + float64(r.header.columns)*r.header.cellSize
+	r.header.north = yll + float64(r.header.rows)*r.header.cellSizeY
 
 	return nil
 }
@@ -413,7 +469,8 @@ type arcGisASCIIRasterHeader struct {
 	columns        int
 	numCells       int
 	nodata         float64
-	cellSize       float64
+	cellSize       float64 // in the x direction
+	cellSizeY      float64
 	north          float64
 	south          float64
 	east           float64
@@ -421,8 +478,13 @@ type arcGisASCIIRasterHeader struct {
 	cellCornerMode bool
 }
 
-func (r *arcGisASCIIRaster) check(e error) {
-	if e != nil {
-		panic(e)
+// allocateData checks the dimensions read from the header and allocates the
+// data to be read.
+func (r *arcGisASCIIRaster) allocateData() error {
+	if err := checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+		return err
 	}
+	r.header.numCells = r.header.columns * r.header.rows
+	r.data = newCellBuffer(r.header.numCells)
+	return nil
 }
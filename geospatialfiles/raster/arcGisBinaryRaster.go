@@ -14,7 +14,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
@@ -35,6 +34,31 @@ type arcGisBinaryRaster struct {
 func (r *arcGisBinaryRaster) InitializeRaster(fileName string,
 	rows int, columns int, north float64, south float64,
 	east float64, west float64, config *RasterConfig) (err error) {
+	if err = r.initializeHeader(fileName, rows, columns, north, south, east, west, config); err != nil {
+		return err
+	}
+
+	// initialize the data array
+	r.data = make([]float32, r.header.numCells)
+	if config.InitialValue != 0 {
+		initVal := float32(config.InitialValue)
+		for i := range r.data {
+			r.data[i] = initVal
+		}
+	}
+
+	r.minimumValue = math.MaxFloat64
+	r.maximumValue = -math.MaxFloat64
+
+	return nil
+}
+
+// initializeHeader sets up the header of a new raster and deletes any existing
+// files of the same name, without allocating the data, which a BlockWriter
+// writes a row at a time.
+func (r *arcGisBinaryRaster) initializeHeader(fileName string,
+	rows int, columns int, north float64, south float64,
+	east float64, west float64, config *RasterConfig) (err error) {
 
 	r.config = config
 
@@ -69,18 +93,6 @@ func (r *arcGisBinaryRaster) InitializeRaster(fileName string,
 		return err
 	}
 
-	// initialize the data array
-	r.data = make([]float32, r.header.numCells)
-	if config.InitialValue != 0 {
-		initVal := float32(config.InitialValue)
-		for i := range r.data {
-			r.data[i] = initVal
-		}
-	}
-
-	r.minimumValue = math.MaxFloat64
-	r.maximumValue = -math.MaxFloat64
-
 	return nil
 }
 
@@ -94,19 +106,12 @@ func (r *arcGisBinaryRaster) SetFileName(value string) (err error) {
 	r.config = NewDefaultRasterConfig()
 
 	// sort out the names of the header and data files
-	ext := strings.ToLower(filepath.Ext(value))
-	if ext == ".flt" {
-		r.dataFile = value
-		r.header.fileName = strings.Replace(value, ext, ".hdr", -1)
-	} else if ext == ".hdr" {
-		r.header.fileName = value
-		r.dataFile = strings.Replace(value, ext, ".flt", -1)
-	} else {
-		return UnsupportedRasterFormatError
+	if err = r.setFileNames(value); err != nil {
+		return err
 	}
 
 	// does the file exist?
-	if _, err = os.Stat(r.header.fileName); err == nil {
+	if FileExists(r.header.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -122,6 +127,22 @@ func (r *arcGisBinaryRaster) SetFileName(value string) (err error) {
 	return nil
 }
 
+// setFileNames sets the names of the header and data files of this ArcGIS binary
+// raster from the name of either.
+func (r *arcGisBinaryRaster) setFileNames(value string) error {
+	ext := strings.ToLower(filepath.Ext(value))
+	if ext == ".flt" {
+		r.dataFile = value
+		r.header.fileName = strings.Replace(value, ext, ".hdr", -1)
+	} else if ext == ".hdr" {
+		r.header.fileName = value
+		r.dataFile = strings.Replace(value, ext, ".flt", -1)
+	} else {
+		return UnsupportedRasterFormatError
+	}
+	return nil
+}
+
 // Retrieve the RasterType of this Raster.
 func (r *arcGisBinaryRaster) RasterType() RasterType {
 	return RT_ArcGisBinaryRaster
@@ -253,7 +274,7 @@ func (r *arcGisBinaryRaster) Data() ([]float64, error) {
 		r.ReadFile()
 	}
 	// convert the float32 to a float64
-	retData := make([]float64, r.header.numCells)
+	retData := newCellBuffer(r.header.numCells)
 	for i, v := range r.data {
 		retData[i] = float64(v)
 	}
@@ -335,7 +356,13 @@ func (r *arcGisBinaryRaster) ReadFile() error {
 	}
 
 	// read the data file
-	bytedata, err := ioutil.ReadFile(r.dataFile)
+	bytedata, err := readFile(r.dataFile)
+	if err != nil {
+		return err
+	}
+	if err = checkDataSize(r.dataFile, r.header.rows, r.header.columns, 4, int64(len(bytedata))); err != nil {
+		return err
+	}
 	buf := bytes.NewReader(bytedata)
 	r.header.numCells = r.header.columns * r.header.rows
 	r.data = make([]float32, r.header.numCells)
@@ -365,7 +392,7 @@ func (h *arcGisBinaryRasterHeader) readHeaderFile() error {
 	if h.fileName == "" {
 		return errors.New("ArcGIS binary raster header file not set properly.")
 	}
-	content, err := ioutil.ReadFile(h.fileName)
+	content, err := readFile(h.fileName)
 	h.check(err)
 	str := strings.Replace(string(content), "\r\n", "\n", -1)
 	var xllcenter float64
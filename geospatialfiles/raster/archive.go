@@ -0,0 +1,168 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rasters, and the other files that are read with them, can be read from
+// within ZIP archives without extracting them to disk, by naming the member
+// after the archive as though the archive were a directory, e.g.
+// tiles.zip/tile_015.asc or tiles.zip/dem/tile_015.dep. The members that are
+// read with random access, e.g. GeoTIFFs, are decompressed into memory; the
+// ASCII grids are read as they are decompressed. Archives are read-only.
+
+// zipMember splits the name of a file within a ZIP archive into the name of
+// the archive and that of the member. ok is false for other files.
+func zipMember(fileName string) (archive, member string, ok bool) {
+	lower := strings.ToLower(fileName)
+	for i := 0; ; {
+		j := strings.Index(lower[i:], ".zip")
+		if j < 0 {
+			return "", "", false
+		}
+		end := i + j + len(".zip")
+		if end < len(fileName) && (fileName[end] == '/' || fileName[end] == '\\') {
+			if info, err := os.Stat(fileName[:end]); err == nil && info.Mode().IsRegular() {
+				return fileName[:end], filepath.ToSlash(fileName[end+1:]), true
+			}
+		}
+		i = end
+	}
+}
+
+// openZipMember opens a member of a ZIP archive. The archive is closed with
+// the member.
+func openZipMember(archive, member string) (io.ReadCloser, int64, error) {
+	z, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range z.File {
+		if f.Name == member {
+			rc, err := f.Open()
+			if err != nil {
+				z.Close()
+				return nil, 0, err
+			}
+			return &closers{rc, []io.Closer{rc, z}}, int64(f.UncompressedSize64), nil
+		}
+	}
+	z.Close()
+	return nil, 0, os.ErrNotExist
+}
+
+// closers is a reader whose Close closes a list of readers and files, e.g. a
+// decompressor and the file that it reads.
+type closers struct {
+	io.Reader
+	list []io.Closer
+}
+
+func (c *closers) Close() error {
+	var err error
+	for _, cl := range c.list {
+		if err2 := cl.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// FileExists reports whether a file exists, either on disk or within a ZIP
+// archive.
+func FileExists(fileName string) bool {
+	if archive, member, ok := zipMember(fileName); ok {
+		rc, _, err := openZipMember(archive, member)
+		if err != nil {
+			return false
+		}
+		rc.Close()
+		return true
+	}
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+// ListZipArchive returns the names of the files within a ZIP archive, in
+// order. A file is read by joining its name to that of the archive, e.g.
+// tiles.zip/tile_015.asc.
+func ListZipArchive(archive string) ([]string, error) {
+	z, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	ret := make([]string, 0, len(z.File))
+	for _, f := range z.File {
+		if !f.FileInfo().IsDir() {
+			ret = append(ret, f.Name)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// readFile returns the contents of a file, which may be within a ZIP archive.
+func readFile(fileName string) ([]byte, error) {
+	if archive, member, ok := zipMember(fileName); ok {
+		rc, size, err := openZipMember(archive, member)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		if size < 0 || size > 1<<30 {
+			size = 0 // the size is only a hint
+		}
+		b := bytes.NewBuffer(make([]byte, 0, size))
+		_, err = io.Copy(b, rc)
+		return b.Bytes(), err
+	}
+	return ioutil.ReadFile(fileName)
+}
+
+// fileReader is a file opened for reading with random access.
+type fileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// openFile opens a file, which may be within a ZIP archive, for reading
+// with random access, and returns its size. A member of an archive is
+// decompressed into memory.
+func openFile(fileName string) (fileReader, int64, error) {
+	if _, _, ok := zipMember(fileName); ok {
+		b, err := readFile(fileName)
+		if err != nil {
+			return nil, 0, err
+		}
+		return memoryFile{bytes.NewReader(b)}, int64(len(b)), nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import "math"
+
+// Bounds is a rectangular extent, in map units, e.g. that of a raster. A
+// bounds whose northern edge isn't above its southern edge, or whose eastern
+// edge isn't east of its western edge, is empty, as is the zero value.
+type Bounds struct {
+	North, South, East, West float64
+}
+
+// Bounds returns the extent of the raster.
+func (r *Raster) Bounds() Bounds {
+	return Bounds{r.North, r.South, r.East, r.West}
+}
+
+// IsEmpty returns true if the bounds have no area.
+func (b Bounds) IsEmpty() bool {
+	return !(b.North > b.South && b.East > b.West)
+}
+
+// Width returns the east-west extent of the bounds.
+func (b Bounds) Width() float64 {
+	return b.East - b.West
+}
+
+// Height returns the north-south extent of the bounds.
+func (b Bounds) Height() float64 {
+	return b.North - b.South
+}
+
+// Overlaps returns true if the two bounds share some area; bounds that only
+// touch along an edge don't overlap.
+func (b Bounds) Overlaps(other Bounds) bool {
+	return !b.Intersect(other).IsEmpty()
+}
+
+// Intersect returns the area shared by the two bounds, which is empty if
+// they don't overlap.
+func (b Bounds) Intersect(other Bounds) Bounds {
+	ret := Bounds{
+		North: math.Min(b.North, other.North),
+		South: math.Max(b.South, other.South),
+		East:  math.Min(b.East, other.East),
+		West:  math.Max(b.West, other.West),
+	}
+	if ret.IsEmpty() {
+		return Bounds{}
+	}
+	return ret
+}
+
+// Union returns the smallest bounds enclosing both bounds. An empty bounds
+// adds nothing to the other.
+func (b Bounds) Union(other Bounds) Bounds {
+	if b.IsEmpty() {
+		return other
+	}
+	if other.IsEmpty() {
+		return b
+	}
+	return Bounds{
+		North: math.Max(b.North, other.North),
+		South: math.Min(b.South, other.South),
+		East:  math.Max(b.East, other.East),
+		West:  math.Min(b.West, other.West),
+	}
+}
+
+// Contains returns true if the point is within the bounds or on their edge.
+func (b Bounds) Contains(x, y float64) bool {
+	return x >= b.West && x <= b.East && y >= b.South && y <= b.North
+}
+
+// ContainsBounds returns true if the other bounds are entirely within these
+// bounds. Empty bounds contain nothing.
+func (b Bounds) ContainsBounds(other Bounds) bool {
+	return !b.IsEmpty() && !other.IsEmpty() && other.West >= b.West && other.East <= b.East &&
+		other.South >= b.South && other.North <= b.North
+}
+
+// Expand returns the bounds grown by dx on the east and west and by dy on
+// the north and south, e.g. by a number of cells to buffer a raster, or
+// shrunk by negative distances.
+func (b Bounds) Expand(dx, dy float64) Bounds {
+	return Bounds{b.North + dy, b.South - dy, b.East + dx, b.West - dx}
+}
+
+// SnapToGrid returns the bounds grown outwards to the lines of a grid whose
+// cells are cellSizeX by cellSizeY and that has a corner at (x0, y0), e.g.
+// the north-west corner of a raster, so that a raster of the bounds is
+// aligned with it. An edge within a millionth of a cell of a grid line is
+// taken to be on it, so that rounding errors don't add a row or column.
+func (b Bounds) SnapToGrid(x0, y0, cellSizeX, cellSizeY float64) Bounds {
+	const tolerance = 1e-6
+	snap := func(v, origin, size float64, round func(float64) float64) float64 {
+		n := (v - origin) / size
+		if nearest := math.Floor(n + 0.5); math.Abs(n-nearest) < tolerance {
+			n = nearest
+		}
+		return origin + round(n)*size
+	}
+	return Bounds{
+		North: snap(b.North, y0, cellSizeY, math.Ceil),
+		South: snap(b.South, y0, cellSizeY, math.Floor),
+		East:  snap(b.East, x0, cellSizeX, math.Ceil),
+		West:  snap(b.West, x0, cellSizeX, math.Floor),
+	}
+}
+
+// Dimensions returns the numbers of rows and columns of cellSizeX by
+// cellSizeY cells that span the bounds, to the nearest whole number.
+func (b Bounds) Dimensions(cellSizeX, cellSizeY float64) (rows, columns int) {
+	return int(math.Floor(b.Height()/cellSizeY + 0.5)), int(math.Floor(b.Width()/cellSizeX + 0.5))
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import "sync"
+
+// Pool, if it isn't nil, supplies the cell buffers of the rasters that are
+// read and created, so that the buffers of rasters that are finished with can
+// be reused by later ones, e.g. by the successive steps of a pipeline, rather
+// than allocated afresh. This reduces the work of the garbage collector and
+// the peak memory, since a large buffer is otherwise only freed some time
+// after the raster using it has been dropped. It is nil by default.
+var Pool *BufferPool
+
+// BufferPool holds []float64 cell buffers for reuse. Buffers are handed out
+// by Get and become free again when they are returned by Put or when the step
+// that they were handed out in ends, after which the rasters using them must
+// no longer be used. A step runs from a call to Begin to the matching call to
+// End; buffers handed out outside of a step are never reclaimed. A free
+// buffer is only reused for a request of between 80% and 100% of its
+// capacity, so that small rasters don't pin large buffers. It is safe for
+// concurrent use.
+type BufferPool struct {
+	mu       sync.Mutex
+	free     [][]float64
+	inUse    [][]float64 // the buffers handed out in the open steps, in order
+	depth    int         // the number of open steps
+	maxFree  int64       // the maximum size of the free buffers, in bytes; unlimited if zero
+	numFree  int64       // the size of the free buffers, in bytes
+	reused   int
+	received int
+}
+
+// NewBufferPool returns a BufferPool that holds at most maxFree bytes of free
+// buffers, or any amount if maxFree is zero.
+func NewBufferPool(maxFree int64) *BufferPool {
+	return &BufferPool{maxFree: maxFree}
+}
+
+// Get returns a zeroed buffer of n values, reusing a free buffer if there is
+// one of a suitable size.
+func (p *BufferPool) Get(n int) []float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received++
+	best := -1
+	for i, buf := range p.free {
+		if c := cap(buf); c >= n && c <= n+n/4 && (best < 0 || c < cap(p.free[best])) {
+			best = i
+		}
+	}
+	var buf []float64
+	if best >= 0 {
+		buf = p.free[best][:n]
+		p.free = append(p.free[:best], p.free[best+1:]...)
+		p.numFree -= int64(cap(buf)) * 8
+		p.reused++
+		for i := range buf {
+			buf[i] = 0
+		}
+	} else {
+		buf = make([]float64, n)
+	}
+	if p.depth > 0 {
+		p.inUse = append(p.inUse, buf)
+	}
+	return buf
+}
+
+// Put returns a buffer obtained from Get within an open step to the pool
+// before the step ends. Other buffers are ignored.
+func (p *BufferPool) Put(buf []float64) {
+	if cap(buf) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, b := range p.inUse {
+		if cap(b) == cap(buf) && &b[:cap(b)][0] == &buf[:cap(buf)][0] {
+			p.inUse = append(p.inUse[:i], p.inUse[i+1:]...)
+			p.addFree(b)
+			return
+		}
+	}
+}
+
+// Begin starts a step, e.g. the run of a tool, and returns the mark to pass
+// to End. Steps may be nested, as when a tool runs other tools.
+func (p *BufferPool) Begin() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.depth++
+	return len(p.inUse)
+}
+
+// End ends the step begun with mark and frees the buffers handed out within
+// it that haven't been returned.
+func (p *BufferPool) End(mark int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.depth > 0 {
+		p.depth--
+	}
+	if mark < 0 || mark > len(p.inUse) {
+		return
+	}
+	for _, buf := range p.inUse[mark:] {
+		p.addFree(buf)
+	}
+	for i := mark; i < len(p.inUse); i++ {
+		p.inUse[i] = nil
+	}
+	p.inUse = p.inUse[:mark]
+}
+
+// Stats returns the number of buffers requested and the number of those that
+// were reused.
+func (p *BufferPool) Stats() (requested, reused int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.received, p.reused
+}
+
+// addFree adds a buffer to the free list, dropping the oldest free buffers if
+// they would exceed the maximum size.
+func (p *BufferPool) addFree(buf []float64) {
+	p.free = append(p.free, buf)
+	p.numFree += int64(cap(buf)) * 8
+	for p.maxFree > 0 && p.numFree > p.maxFree && len(p.free) > 0 {
+		p.numFree -= int64(cap(p.free[0])) * 8
+		p.free[0] = nil
+		p.free = p.free[1:]
+	}
+}
+
+// newCellBuffer returns a zeroed buffer of n cell values, from the Pool if
+// there is one.
+func newCellBuffer(n int) []float64 {
+	if Pool != nil {
+		return Pool.Get(n)
+	}
+	return make([]float64, n)
+}
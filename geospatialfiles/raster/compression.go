@@ -0,0 +1,84 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gzipFile is a gzip stream and the file that it is written to, which are
+// closed together.
+type gzipFile struct {
+	file *os.File
+	w    *gzip.Writer
+}
+
+func (g *gzipFile) Write(p []byte) (int, error) {
+	return g.w.Write(p)
+}
+
+func (g *gzipFile) Close() error {
+	err := g.w.Close()
+	if err2 := g.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// OpenDecompressed opens a file for reading, decompressing it if it is
+// gzipped, e.g. an ASCII grid or point file distributed as dem.asc.gz or
+// points.xyz.gz, whatever its name. The file may be within a ZIP archive.
+func OpenDecompressed(fileName string) (io.ReadCloser, error) {
+	rc, _, err := openDecompressed(fileName)
+	return rc, err
+}
+
+// openDecompressed is OpenDecompressed, and also reports whether the file is
+// gzipped.
+func openDecompressed(fileName string) (io.ReadCloser, bool, error) {
+	var rc io.ReadCloser
+	var err error
+	if archive, member, ok := zipMember(fileName); ok {
+		rc, _, err = openZipMember(archive, member)
+	} else {
+		rc, err = os.Open(fileName)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	br := bufio.NewReader(rc)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return &closers{br, []io.Closer{rc}}, false, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, true, err
+	}
+	return &closers{zr, []io.Closer{zr, rc}}, true, nil
+}
+
+// createCompressed creates a file for writing, compressing it if its name
+// ends with .gz.
+func createCompressed(fileName string) (io.WriteCloser, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if !isGzipName(fileName) {
+		return f, nil
+	}
+	return &gzipFile{file: f, w: gzip.NewWriter(f)}, nil
+}
+
+// isGzipName reports whether a file name has the extension .gz.
+func isGzipName(fileName string) bool {
+	return strings.ToLower(filepath.Ext(fileName)) == ".gz"
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns the name of the .prj sidecar file that accompanies a raster file,
+// e.g. DEM.prj for DEM.asc or the gzipped DEM.asc.gz.
+func PrjFileName(fileName string) string {
+	if isGzipName(fileName) {
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + ".prj"
+}
+
+// Returns true if the raster format has no native means of storing a
+// coordinate reference system, in which case a .prj sidecar file is used.
+func usesPrjSidecar(rt RasterType) bool {
+	switch rt {
+	case RT_ArcGisBinaryRaster, RT_ArcGisAsciiRaster, RT_GrassAsciiRaster:
+		return true
+	}
+	return false
+}
+
+// Parses an EPSG code from a string of the form "EPSG:26917". A value of
+// zero is returned if the string is not of this form.
+func ParseEPSGString(value string) int {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if !strings.HasPrefix(value, "EPSG:") {
+		return 0
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(value, "EPSG:")))
+	if err != nil || code < 0 {
+		return 0
+	}
+	return code
+}
+
+// Retrieves the EPSG code from the outermost AUTHORITY["EPSG","code"] node
+// of a WKT coordinate reference system string. A value of zero is returned
+// if no such node exists.
+func EPSGCodeFromWKT(wkt string) int {
+	if code := ParseEPSGString(wkt); code > 0 {
+		return code
+	}
+	// The outermost AUTHORITY node is the last one that appears before the
+	// final closing bracket.
+	upper := strings.ToUpper(wkt)
+	index := strings.LastIndex(upper, "AUTHORITY[")
+	if index < 0 {
+		return 0
+	}
+	str := upper[index+len("AUTHORITY["):]
+	if end := strings.Index(str, "]"); end >= 0 {
+		str = str[:end]
+	}
+	s := strings.Split(str, ",")
+	if len(s) != 2 || strings.Trim(strings.TrimSpace(s[0]), "\"") != "EPSG" {
+		return 0
+	}
+	code, err := strconv.Atoi(strings.Trim(strings.TrimSpace(s[1]), "\""))
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// Reads the coordinate reference system from a .prj sidecar file, if one
+// exists. The file may contain either WKT or an "EPSG:code" string.
+func readPrjFile(fileName string, config *RasterConfig) error {
+	prjFile := PrjFileName(fileName)
+	if !FileExists(prjFile) {
+		return nil
+	}
+	content, err := readFile(prjFile)
+	if err != nil {
+		return FileReadingError
+	}
+	str := strings.TrimSpace(string(content))
+	if code := ParseEPSGString(str); code > 0 {
+		config.EPSGCode = code
+		return nil
+	}
+	config.CoordinateRefSystemWKT = str
+	if config.EPSGCode == 0 {
+		config.EPSGCode = EPSGCodeFromWKT(str)
+	}
+	return nil
+}
+
+// Writes the coordinate reference system to a .prj sidecar file. Nothing is
+// written if the config does not contain a coordinate reference system. It
+// may also be used for non-raster outputs, e.g. vector files.
+func WritePrjFile(fileName string, config *RasterConfig) error {
+	var str string
+	if wkt := strings.TrimSpace(config.CoordinateRefSystemWKT); wkt != "" && wkt != "not specified" {
+		str = wkt
+	} else if config.EPSGCode > 0 {
+		str = "EPSG:" + strconv.Itoa(config.EPSGCode)
+	} else {
+		return nil
+	}
+	if err := ioutil.WriteFile(PrjFileName(fileName), []byte(str+"\n"), 0644); err != nil {
+		return FileWritingError
+	}
+	return nil
+}
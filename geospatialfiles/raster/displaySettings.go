@@ -0,0 +1,117 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DisplaySidecarExtension is appended to the name of a GeoTIFF to give the
+// name of the sidecar file that holds its display settings, e.g.
+// DEM.tif.display, since GeoTIFFs have no place for them. The file uses the
+// same 'Key:<tab>value' lines as a Whitebox GAT header.
+const DisplaySidecarExtension = ".display"
+
+// CopyDisplaySettings copies the settings that control how a raster is
+// displayed, i.e. its preferred palette, display range and palette
+// nonlinearity, from src. It is used for outputs that share the values and
+// units of an input, e.g. filled or resampled DEMs, so that the settings are
+// carried between formats. Settings that are not set in src are left
+// unchanged.
+func (c *RasterConfig) CopyDisplaySettings(src *RasterConfig) {
+	if src.PreferredPalette != "" && src.PreferredPalette != "not specified" {
+		c.PreferredPalette = src.PreferredPalette
+	}
+	if src.DisplayMinimum != math.MaxFloat64 {
+		c.DisplayMinimum = src.DisplayMinimum
+	}
+	if src.DisplayMaximum != -math.MaxFloat64 {
+		c.DisplayMaximum = src.DisplayMaximum
+	}
+	if src.PaletteNonlinearity > 0 {
+		c.PaletteNonlinearity = src.PaletteNonlinearity
+	}
+}
+
+// hasDisplaySettings reports whether any of the display settings differ
+// from the defaults.
+func (c *RasterConfig) hasDisplaySettings() bool {
+	return (c.PreferredPalette != "" && c.PreferredPalette != "not specified") ||
+		c.DisplayMinimum != math.MaxFloat64 || c.DisplayMaximum != -math.MaxFloat64 ||
+		(c.PaletteNonlinearity > 0 && c.PaletteNonlinearity != 1.0)
+}
+
+// writeDisplaySidecar writes the display settings of a raster to the sidecar
+// of fileName, or removes an existing sidecar if the settings are all
+// defaults.
+func writeDisplaySidecar(fileName string, c *RasterConfig) error {
+	sidecar := fileName + DisplaySidecarExtension
+	if !c.hasDisplaySettings() {
+		if _, err := os.Stat(sidecar); err == nil {
+			return os.Remove(sidecar)
+		}
+		return nil
+	}
+	f, err := os.Create(sidecar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if c.DisplayMinimum != math.MaxFloat64 {
+		w.WriteString("Display Min:\t" + strconv.FormatFloat(c.DisplayMinimum, 'f', -1, 64) + "\n")
+	}
+	if c.DisplayMaximum != -math.MaxFloat64 {
+		w.WriteString("Display Max:\t" + strconv.FormatFloat(c.DisplayMaximum, 'f', -1, 64) + "\n")
+	}
+	if c.PreferredPalette != "" && c.PreferredPalette != "not specified" {
+		w.WriteString("Preferred Palette:\t" + c.PreferredPalette + "\n")
+	}
+	if c.PaletteNonlinearity > 0 {
+		w.WriteString("Palette Nonlinearity:\t" + strconv.FormatFloat(c.PaletteNonlinearity, 'f', -1, 64) + "\n")
+	}
+	return w.Flush()
+}
+
+// readDisplaySidecar reads the display settings of a raster from the sidecar
+// of fileName, if there is one. Unrecognized and malformed lines are ignored.
+func readDisplaySidecar(fileName string, c *RasterConfig) error {
+	content, err := readFile(fileName + DisplaySidecarExtension)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, line := range strings.Split(strings.Replace(string(content), "\r\n", "\n", -1), "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "display min":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				c.DisplayMinimum = v
+			}
+		case "display max":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				c.DisplayMaximum = v
+			}
+		case "preferred palette":
+			c.PreferredPalette = strings.ToLower(value)
+		case "palette nonlinearity":
+			if v, err := strconv.ParseFloat(value, 64); err == nil && v > 0 {
+				c.PaletteNonlinearity = v
+			}
+		}
+	}
+	return nil
+}
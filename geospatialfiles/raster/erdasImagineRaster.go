@@ -0,0 +1,646 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Used to read an Erdas Imagine (.img) raster file. The format is a tree of
+// typed nodes, the Hierarchical File Architecture (HFA), whose raster layers
+// are stored as tiles. Only the first layer of a file is read, and its tiles
+// must be uncompressed and stored in the .img file itself rather than in an
+// external .ige file. The format is read-only.
+type erdasImagineRaster struct {
+	fileName     string
+	data         []float64
+	header       erdasImagineRasterHeader
+	minimumValue float64
+	maximumValue float64
+	config       *RasterConfig
+	metadata     []string
+	headerOnly   bool // read only the header, leaving the data empty
+}
+
+type erdasImagineRasterHeader struct {
+	rows        int
+	columns     int
+	numCells    int
+	blockWidth  int
+	blockHeight int
+	pixelType   int
+	nodata      float64
+	north       float64
+	south       float64
+	east        float64
+	west        float64
+}
+
+// The HFA pixel types, in the order of the Eimg_Layer pixelType enumeration.
+const (
+	hfa_u1 = iota
+	hfa_u2
+	hfa_u4
+	hfa_u8
+	hfa_s8
+	hfa_u16
+	hfa_s16
+	hfa_u32
+	hfa_s32
+	hfa_f32
+	hfa_f64
+	hfa_c64
+	hfa_c128
+)
+
+// the number of bits of each HFA pixel type
+var hfaPixelBits = []int{1, 2, 4, 8, 8, 16, 16, 32, 32, 32, 64, 64, 128}
+
+// hfaEntry is a node of the tree of an HFA file.
+type hfaEntry struct {
+	next     uint32
+	child    uint32
+	data     uint32
+	dataSize uint32
+	name     string
+	typeName string
+}
+
+func (r *erdasImagineRaster) InitializeRaster(fileName string,
+	rows int, columns int, north float64, south float64,
+	east float64, west float64, config *RasterConfig) (err error) {
+	return ReadOnlyRasterFormatError
+}
+
+// Retrieve the file name (.img) of this Erdas Imagine raster file.
+func (r *erdasImagineRaster) FileName() string {
+	return r.fileName
+}
+
+// Set the file name (.img) of this Erdas Imagine raster file and read it.
+func (r *erdasImagineRaster) SetFileName(value string) (err error) {
+	r.config = NewDefaultRasterConfig()
+
+	r.fileName = value
+	// does the file exist?
+	if FileExists(r.fileName) {
+		// yes it does; read the file
+		if err = r.ReadFile(); err != nil {
+			return err
+		}
+	} else {
+		return FileDoesNotExistError
+	}
+
+	r.minimumValue = math.MaxFloat64
+	r.maximumValue = -math.MaxFloat64
+	r.config.RasterFormat = RT_ErdasImagineRaster
+
+	return nil
+}
+
+// Retrieve the RasterType of this Raster.
+func (r *erdasImagineRaster) RasterType() RasterType {
+	return RT_ErdasImagineRaster
+}
+
+// Retrieve the number of rows this Erdas Imagine raster file.
+func (r *erdasImagineRaster) Rows() int {
+	return r.header.rows
+}
+
+// Sets the number of rows of this Erdas Imagine raster file.
+func (r *erdasImagineRaster) SetRows(value int) {
+	r.header.rows = value
+}
+
+// Retrieve the number of columns of this Erdas Imagine raster file.
+func (r *erdasImagineRaster) Columns() int {
+	return r.header.columns
+}
+
+// Sets the number of columns of this Erdas Imagine raster file.
+func (r *erdasImagineRaster) SetColumns(value int) {
+	r.header.columns = value
+}
+
+// Retrieve the raster's northern edge's coordinate
+func (r *erdasImagineRaster) North() float64 {
+	return r.header.north
+}
+
+// Retrieve the raster's southern edge's coordinate
+func (r *erdasImagineRaster) South() float64 {
+	return r.header.south
+}
+
+// Retrieve the raster's eastern edge's coordinate
+func (r *erdasImagineRaster) East() float64 {
+	return r.header.east
+}
+
+// Retrieve the raster's western edge's coordinate
+func (r *erdasImagineRaster) West() float64 {
+	return r.header.west
+}
+
+// Retrieve the raster's minimum value
+func (r *erdasImagineRaster) MinimumValue() float64 {
+	if r.minimumValue == math.MaxFloat64 {
+		r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	}
+	return r.minimumValue
+}
+
+// Retrieve the raster's minimum value
+func (r *erdasImagineRaster) MaximumValue() float64 {
+	if r.maximumValue == -math.MaxFloat64 {
+		r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	}
+	return r.maximumValue
+}
+
+func (r *erdasImagineRaster) findMinAndMaxVals() (minVal float64, maxVal float64) {
+	if r.data != nil && len(r.data) > 0 {
+		minVal = math.MaxFloat64
+		maxVal = -math.MaxFloat64
+		for _, v := range r.data {
+			if v != r.header.nodata {
+				if v > maxVal {
+					maxVal = v
+				}
+				if v < minVal {
+					minVal = v
+				}
+			}
+		}
+		return minVal, maxVal
+	} else {
+		return math.MaxFloat64, -math.MaxFloat64
+	}
+}
+
+// Sets the raster config
+func (r *erdasImagineRaster) SetRasterConfig(value *RasterConfig) {
+	r.config = value
+}
+
+// Retrieves the raster config
+func (r *erdasImagineRaster) GetRasterConfig() *RasterConfig {
+	return r.config
+}
+
+// Retrieve the NoData value used by this Erdas Imagine raster file.
+func (r *erdasImagineRaster) NoData() float64 {
+	return r.header.nodata
+}
+
+// Sets the NoData value used by this Erdas Imagine raster file.
+func (r *erdasImagineRaster) SetNoData(value float64) {
+	r.header.nodata = value
+}
+
+// Retrieve the byte order used by this Erdas Imagine raster file.
+func (r *erdasImagineRaster) ByteOrder() binary.ByteOrder {
+	return binary.LittleEndian
+}
+
+// Sets the byte order used by this Erdas Imagine raster file.
+func (r *erdasImagineRaster) SetByteOrder(value binary.ByteOrder) {
+	// Do nothing, HFA files are always little-endian.
+	// This method is simply present to satisfy the RasterData interface
+}
+
+// Retrieves the metadata for this raster
+func (r *erdasImagineRaster) MetadataEntries() []string {
+	return r.metadata
+}
+
+// Adds a metadata entry to this raster
+func (r *erdasImagineRaster) AddMetadataEntry(value string) {
+	r.metadata = append(r.metadata, value)
+}
+
+// Returns the data as a slice of float64 values
+func (r *erdasImagineRaster) Data() ([]float64, error) {
+	if len(r.data) == 0 {
+		if err := r.ReadFile(); err != nil {
+			return nil, err
+		}
+	}
+	return r.data, nil
+}
+
+// Sets the data from a slice of float64 values
+func (r *erdasImagineRaster) SetData(values []float64) {
+	if r.header.numCells == 0 {
+		r.header.numCells = r.header.rows * r.header.columns
+	}
+	if len(values) == r.header.numCells {
+		r.data = values
+	} else {
+		panic(DataSetError)
+	}
+}
+
+// Returns the value within data
+func (r *erdasImagineRaster) Value(index int) float64 {
+	return r.data[index]
+}
+
+// Sets the value of index within data
+func (r *erdasImagineRaster) SetValue(index int, value float64) {
+	r.data[index] = value
+}
+
+// Save the file. Erdas Imagine rasters are read-only.
+func (r *erdasImagineRaster) Save() (err error) {
+	return ReadOnlyRasterFormatError
+}
+
+// Reads the file
+func (r *erdasImagineRaster) ReadFile() error {
+	if r.fileName == "" {
+		return FileReadingError
+	}
+	f, fileSize, err := openFile(r.fileName)
+	if err != nil {
+		return FileOpeningError
+	}
+	defer f.Close()
+
+	// the header tag is followed by the position of the Ehfa_File record,
+	// which holds the position of the root of the tree
+	buf := make([]byte, 20)
+	if _, err = f.ReadAt(buf, 0); err != nil || !bytes.HasPrefix(buf, []byte("EHFA_HEADER_TAG")) {
+		return FileIsNotProperlyFormated
+	}
+	if _, err = f.ReadAt(buf[:12], int64(binary.LittleEndian.Uint32(buf[16:]))); err != nil {
+		return FileIsNotProperlyFormated
+	}
+	root, err := readHfaEntry(f, binary.LittleEndian.Uint32(buf[8:]))
+	if err != nil {
+		return err
+	}
+
+	// the first raster layer
+	var layer hfaEntry
+	for _, e := range hfaChildren(f, root) {
+		if e.typeName == "Eimg_Layer" {
+			layer = e
+			break
+		}
+	}
+	if layer.typeName == "" {
+		return fmt.Errorf("%s: the file contains no raster layers", r.fileName)
+	}
+	d, err := hfaEntryData(f, layer, 20)
+	if err != nil {
+		return err
+	}
+	// Eimg_Layer: width, height, layerType, pixelType, blockWidth, blockHeight
+	r.header.columns = int(binary.LittleEndian.Uint32(d[0:]))
+	r.header.rows = int(binary.LittleEndian.Uint32(d[4:]))
+	r.header.pixelType = int(binary.LittleEndian.Uint16(d[10:]))
+	r.header.blockWidth = int(binary.LittleEndian.Uint32(d[12:]))
+	r.header.blockHeight = int(binary.LittleEndian.Uint32(d[16:]))
+	if r.header.pixelType > hfa_f64 {
+		return fmt.Errorf("%s: complex pixel values are not supported", r.fileName)
+	}
+	if r.header.blockWidth <= 0 || r.header.blockHeight <= 0 {
+		return FileIsNotProperlyFormated
+	}
+	switch r.header.pixelType {
+	case hfa_u1, hfa_u2, hfa_u4, hfa_u8:
+		r.config.DataType = DT_UINT8
+	case hfa_s8:
+		r.config.DataType = DT_INT8
+	case hfa_u16:
+		r.config.DataType = DT_UINT16
+	case hfa_s16:
+		r.config.DataType = DT_INT16
+	case hfa_u32:
+		r.config.DataType = DT_UINT32
+	case hfa_s32:
+		r.config.DataType = DT_INT32
+	case hfa_f32:
+		r.config.DataType = DT_FLOAT32
+	case hfa_f64:
+		r.config.DataType = DT_FLOAT64
+	}
+
+	// without georeferencing, the cells are one unit in size
+	r.header.west, r.header.east = 0, float64(r.header.columns)
+	r.header.south, r.header.north = 0, float64(r.header.rows)
+	r.header.nodata = r.config.NoDataValue
+	var dms hfaEntry
+	for _, e := range hfaChildren(f, layer) {
+		switch e.name {
+		case "RasterDMS":
+			dms = e
+		case "ExternalRasterDMS":
+			return fmt.Errorf("%s: the raster data are in an external .ige file, which is not supported", r.fileName)
+		case "Map_Info":
+			if err = r.readMapInfo(f, e); err != nil {
+				return err
+			}
+		case "Eimg_NonInitializedValue":
+			if err = r.readNoData(f, e); err != nil {
+				return err
+			}
+		case "Projection":
+			r.readProjection(f, e)
+		}
+	}
+	if dms.typeName == "" {
+		return fmt.Errorf("%s: the raster layer has no data", r.fileName)
+	}
+
+	if err = checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+		return err
+	}
+	r.header.numCells = r.header.rows * r.header.columns
+	if r.headerOnly {
+		return nil
+	}
+	r.data = newCellBuffer(r.header.numCells)
+
+	// Edms_State: numvirtualblocks, numobjectsperblock, nextobjectnum,
+	// compressionType, then the blockinfo array of Edms_VirtualBlockInfo
+	// records of fileCode, offset, size, logvalid and compressionType
+	d, err = hfaEntryData(f, dms, 22)
+	if err != nil {
+		return err
+	}
+	blocksPerRow := (r.header.columns + r.header.blockWidth - 1) / r.header.blockWidth
+	blocksPerColumn := (r.header.rows + r.header.blockHeight - 1) / r.header.blockHeight
+	numBlocks := int(binary.LittleEndian.Uint32(d[14:]))
+	if numBlocks < blocksPerRow*blocksPerColumn || len(d) < 22+14*blocksPerRow*blocksPerColumn {
+		return FileIsNotProperlyFormated
+	}
+	blockPixels := r.header.blockWidth * r.header.blockHeight
+	blockBytes := (blockPixels*hfaPixelBits[r.header.pixelType] + 7) / 8
+	block := make([]byte, blockBytes)
+	for b := 0; b < blocksPerRow*blocksPerColumn; b++ {
+		info := d[22+14*b:]
+		offset := int64(binary.LittleEndian.Uint32(info[2:]))
+		valid := binary.LittleEndian.Uint16(info[10:]) != 0
+		compressed := binary.LittleEndian.Uint16(info[12:]) != 0
+		if valid && compressed {
+			return fmt.Errorf("%s: compressed raster data are not supported", r.fileName)
+		}
+		if valid && offset+int64(blockBytes) > fileSize {
+			return &DataSizeError{r.fileName, r.header.rows, r.header.columns,
+				offset + int64(blockBytes), fileSize, "bytes"}
+		}
+		if valid {
+			if _, err = f.ReadAt(block, offset); err != nil {
+				return FileReadingError
+			}
+		}
+		row0 := (b / blocksPerRow) * r.header.blockHeight
+		col0 := (b % blocksPerRow) * r.header.blockWidth
+		for i := 0; i < r.header.blockHeight && row0+i < r.header.rows; i++ {
+			for j := 0; j < r.header.blockWidth && col0+j < r.header.columns; j++ {
+				cellNum := (row0+i)*r.header.columns + col0 + j
+				if valid {
+					r.data[cellNum] = hfaPixelValue(block, i*r.header.blockWidth+j, r.header.pixelType)
+				} else {
+					r.data[cellNum] = r.header.nodata
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// readMapInfo reads the georeferencing of the layer from its Eprj_MapInfo
+// node: the projection name, the coordinates of the centres of the
+// upper-left and lower-right cells, the cell size and the map units.
+func (r *erdasImagineRaster) readMapInfo(f io.ReaderAt, e hfaEntry) error {
+	d, err := hfaEntryData(f, e, 0)
+	if err != nil {
+		return err
+	}
+	pos := 0
+	hfaString(d, &pos) // the projection name
+	ulx, uly, ok1 := hfaDoublePair(d, &pos)
+	_, _, ok2 := hfaDoublePair(d, &pos)
+	cellSizeX, cellSizeY, ok3 := hfaDoublePair(d, &pos)
+	if !ok1 || !ok2 || !ok3 || cellSizeX <= 0 || cellSizeY <= 0 {
+		return FileIsNotProperlyFormated
+	}
+	if units := hfaString(d, &pos); units != "" {
+		r.config.XYUnits = units
+	}
+	r.header.west = ulx - cellSizeX/2
+	r.header.north = uly + cellSizeY/2
+	r.header.east = r.header.west + float64(r.header.columns)*cellSizeX
+	r.header.south = r.header.north - float64(r.header.rows)*cellSizeY
+	return nil
+}
+
+// readNoData reads the layer's nodata value, which is held as a single value
+// of base data.
+func (r *erdasImagineRaster) readNoData(f io.ReaderAt, e hfaEntry) error {
+	// a pointer (count and position), then the number of rows and columns,
+	// the pixel type and the object type of the base data, then its values
+	d, err := hfaEntryData(f, e, 20)
+	if err != nil {
+		return err
+	}
+	pixelType := int(binary.LittleEndian.Uint16(d[16:]))
+	if pixelType < hfa_u8 || pixelType > hfa_f64 || len(d) < 20+hfaPixelBits[pixelType]/8 {
+		return nil
+	}
+	r.header.nodata = hfaPixelValue(d[20:], 0, pixelType)
+	r.config.NoDataValue = r.header.nodata
+	return nil
+}
+
+// readProjection reads the projection of the layer from its
+// Eprj_ProParameters node and, for the common geographic and UTM coordinate
+// systems, sets the EPSG code from it and the datum of its Datum child.
+func (r *erdasImagineRaster) readProjection(f io.ReaderAt, e hfaEntry) {
+	d, err := hfaEntryData(f, e, 6)
+	if err != nil {
+		return
+	}
+	// proType, proNumber, proExeName, proName, proZone, proParams
+	pos := 6
+	hfaString(d, &pos)
+	proName := hfaString(d, &pos)
+	if pos+4 > len(d) {
+		return
+	}
+	zone := int(int32(binary.LittleEndian.Uint32(d[pos:])))
+	pos += 4
+	var params []float64
+	if pos+8 <= len(d) {
+		n := int(binary.LittleEndian.Uint32(d[pos:]))
+		for i := 0; i < n && pos+16+8*i <= len(d); i++ {
+			params = append(params, math.Float64frombits(binary.LittleEndian.Uint64(d[pos+8+8*i:])))
+		}
+	}
+	datum := ""
+	for _, c := range hfaChildren(f, e) {
+		if c.name == "Datum" {
+			if dd, err := hfaEntryData(f, c, 0); err == nil {
+				p := 0
+				datum = hfaString(dd, &p)
+			}
+		}
+	}
+	r.metadata = append(r.metadata, fmt.Sprintf("Projection: %s", proName))
+	if datum != "" {
+		r.metadata = append(r.metadata, fmt.Sprintf("Datum: %s", datum))
+	}
+
+	datum = strings.ToUpper(strings.Replace(datum, " ", "", -1))
+	switch {
+	case proName == "Geographic (Lat/Lon)":
+		switch datum {
+		case "WGS84":
+			r.config.EPSGCode = 4326
+		case "NAD83":
+			r.config.EPSGCode = 4269
+		case "NAD27":
+			r.config.EPSGCode = 4267
+		}
+	case proName == "UTM" && zone >= 1 && zone <= 60:
+		// the hemisphere is given by the sign of the fourth parameter
+		north := len(params) < 4 || params[3] >= 0
+		switch {
+		case datum == "WGS84" && north:
+			r.config.EPSGCode = 32600 + zone
+		case datum == "WGS84":
+			r.config.EPSGCode = 32700 + zone
+		case datum == "NAD83" && north:
+			r.config.EPSGCode = 26900 + zone
+		case datum == "NAD27" && north:
+			r.config.EPSGCode = 26700 + zone
+		}
+	}
+}
+
+// readHfaEntry reads the node of an HFA file at a position.
+func readHfaEntry(f io.ReaderAt, pos uint32) (hfaEntry, error) {
+	var e hfaEntry
+	// next, prev, parent, child, data and dataSize, then the name and type
+	buf := make([]byte, 120)
+	if _, err := f.ReadAt(buf, int64(pos)); err != nil {
+		return e, FileIsNotProperlyFormated
+	}
+	e.next = binary.LittleEndian.Uint32(buf[0:])
+	e.child = binary.LittleEndian.Uint32(buf[12:])
+	e.data = binary.LittleEndian.Uint32(buf[16:])
+	e.dataSize = binary.LittleEndian.Uint32(buf[20:])
+	e.name = hfaCString(buf[24:88])
+	e.typeName = hfaCString(buf[88:120])
+	return e, nil
+}
+
+// hfaChildren returns the child nodes of a node. A malformed list of children
+// is cut short.
+func hfaChildren(f io.ReaderAt, e hfaEntry) []hfaEntry {
+	var ret []hfaEntry
+	visited := make(map[uint32]bool)
+	for pos := e.child; pos != 0 && !visited[pos]; {
+		visited[pos] = true
+		c, err := readHfaEntry(f, pos)
+		if err != nil {
+			break
+		}
+		ret = append(ret, c)
+		pos = c.next
+	}
+	return ret
+}
+
+// hfaEntryData reads the data of a node, which must hold at least minSize
+// bytes.
+func hfaEntryData(f io.ReaderAt, e hfaEntry, minSize int) ([]byte, error) {
+	if int(e.dataSize) < minSize || e.dataSize > 1<<30 {
+		return nil, FileIsNotProperlyFormated
+	}
+	d := make([]byte, e.dataSize)
+	if _, err := f.ReadAt(d, int64(e.data)); err != nil {
+		return nil, FileIsNotProperlyFormated
+	}
+	return d, nil
+}
+
+// hfaCString returns a NUL-terminated string.
+func hfaCString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// hfaString reads a string field, a pointer (count and position) followed by
+// its characters, and advances pos past it.
+func hfaString(d []byte, pos *int) string {
+	if *pos+8 > len(d) {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(d[*pos:]))
+	*pos += 8
+	if n < 0 || *pos+n > len(d) {
+		*pos = len(d)
+		return ""
+	}
+	s := hfaCString(d[*pos : *pos+n])
+	*pos += n
+	return s
+}
+
+// hfaDoublePair reads an object field of two doubles, e.g. an Eprj_Coordinate,
+// and advances pos past it.
+func hfaDoublePair(d []byte, pos *int) (float64, float64, bool) {
+	if *pos+24 > len(d) {
+		return 0, 0, false
+	}
+	x := math.Float64frombits(binary.LittleEndian.Uint64(d[*pos+8:]))
+	y := math.Float64frombits(binary.LittleEndian.Uint64(d[*pos+16:]))
+	*pos += 24
+	return x, y, true
+}
+
+// hfaPixelValue returns the i'th value of little-endian pixel data. Values of
+// less than a byte are packed from the least significant bit.
+func hfaPixelValue(d []byte, i int, pixelType int) float64 {
+	switch pixelType {
+	case hfa_u1:
+		return float64((d[i>>3] >> uint(i&7)) & 1)
+	case hfa_u2:
+		return float64((d[i>>2] >> uint((i&3)*2)) & 3)
+	case hfa_u4:
+		return float64((d[i>>1] >> uint((i&1)*4)) & 15)
+	case hfa_u8:
+		return float64(d[i])
+	case hfa_s8:
+		return float64(int8(d[i]))
+	case hfa_u16:
+		return float64(binary.LittleEndian.Uint16(d[2*i:]))
+	case hfa_s16:
+		return float64(int16(binary.LittleEndian.Uint16(d[2*i:])))
+	case hfa_u32:
+		return float64(binary.LittleEndian.Uint32(d[4*i:]))
+	case hfa_s32:
+		return float64(int32(binary.LittleEndian.Uint32(d[4*i:])))
+	case hfa_f32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(d[4*i:])))
+	case hfa_f64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(d[8*i:]))
+	}
+	return 0
+}
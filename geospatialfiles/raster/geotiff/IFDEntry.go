@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type IfdEntry struct {
@@ -116,7 +117,8 @@ func (ifd *IfdEntry) InterpretDataAsASCII() (u []string, err error) {
 	u = make([]string, 1)
 	switch ifd.dataType {
 	case DT_ASCII:
-		u[0] = string(ifd.rawData[:ifd.count-1])
+		// ASCII values should be NUL terminated, but some writers omit it
+		u[0] = strings.TrimRight(string(ifd.rawData[:ifd.count]), "\x00")
 	default:
 		return nil, UnsupportedDataTypeError
 	}
@@ -0,0 +1,113 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package geotiff
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
+)
+
+// Citation of a user-defined projected system that is Web Mercator.
+const pseudoMercatorCitation = "Popular Visualisation Pseudo Mercator"
+
+// Creates the geokeys describing a coordinate reference system that is not
+// listed in the GeoTIFF specification. The EPSG code is stored in the
+// citation so that it can be recovered when the file is read.
+func userDefinedGeoKeys(crs epsg.CRS, byteOrder binary.ByteOrder) []IfdEntry {
+	ret := make([]IfdEntry, 0)
+	addShort := func(code int, value int) {
+		ret = append(ret, CreateIfdEntry(code, dtShort, 1, uint16(value), byteOrder))
+	}
+	addDouble := func(code int, value float64) {
+		ret = append(ret, CreateIfdEntry(code, dtDouble, 1, value, byteOrder))
+	}
+	addASCII := func(code int, value string) {
+		value += "|"
+		ret = append(ret, CreateIfdEntry(code, dtASCII, uint32(len(value)), value, byteOrder))
+	}
+
+	addASCII(tGTCitationGeoKey, fmt.Sprintf("EPSG:%d %s", crs.Code, crs.Name))
+
+	// the geographic coordinate system
+	if _, ok := geographicTypeMap[uint(crs.GeographicCode)]; ok {
+		addShort(tGeographicTypeGeoKey, crs.GeographicCode)
+	} else {
+		addShort(tGeographicTypeGeoKey, userDefined)
+		if g, ok := epsg.Lookup(crs.GeographicCode); ok {
+			addASCII(tGeogCitationGeoKey, g.Name)
+		}
+		addShort(tGeogGeodeticDatumGeoKey, userDefined)
+		addShort(tGeogAngularUnitsGeoKey, angularDegree)
+		addShort(tGeogEllipsoidGeoKey, userDefined)
+		addDouble(tGeogSemiMajorAxisGeoKey, crs.Ellipsoid.SemiMajorAxis)
+		addDouble(tGeogInvFlatteningGeoKey, crs.Ellipsoid.InverseFlattening)
+	}
+
+	if crs.IsGeographic() {
+		addShort(tGTModelTypeGeoKey, 2)
+		return ret
+	}
+
+	addShort(tGTModelTypeGeoKey, 1)
+	addShort(tProjectedCSTypeGeoKey, userDefined)
+	if crs.Projection == epsg.PseudoMercator {
+		// the Mercator keys describe the ellipsoidal projection; readers,
+		// e.g. GDAL, recognize the spherical formulas of Web Mercator, applied
+		// to WGS 84 coordinates, by this citation
+		addASCII(tPCSCitationGeoKey, pseudoMercatorCitation)
+	} else {
+		addASCII(tPCSCitationGeoKey, crs.Name)
+	}
+	addShort(tProjectionGeoKey, userDefined)
+	addShort(tProjLinearUnitsGeoKey, linearMeter)
+
+	p := crs.Params
+	switch crs.Projection {
+	case epsg.TransverseMercator, epsg.Mercator, epsg.PseudoMercator:
+		if crs.Projection == epsg.TransverseMercator {
+			addShort(tProjCoordTransGeoKey, ctTransverseMercator)
+		} else {
+			addShort(tProjCoordTransGeoKey, ctMercator)
+		}
+		addDouble(tProjNatOriginLatGeoKey, p.LatitudeOfOrigin)
+		addDouble(tProjNatOriginLongGeoKey, p.CentralMeridian)
+		addDouble(tProjScaleAtNatOriginGeoKey, p.ScaleFactor)
+		addDouble(tProjFalseEastingGeoKey, p.FalseEasting)
+		addDouble(tProjFalseNorthingGeoKey, p.FalseNorthing)
+	case epsg.LambertConformalConic:
+		addShort(tProjCoordTransGeoKey, ctLambertConfConic2SP)
+		addDouble(tProjStdParallel1GeoKey, p.StandardParallel1)
+		addDouble(tProjStdParallel2GeoKey, p.StandardParallel2)
+		addDouble(tProjFalseOriginLatGeoKey, p.LatitudeOfOrigin)
+		addDouble(tProjFalseOriginLongGeoKey, p.CentralMeridian)
+		addDouble(tProjFalseOriginEastingGeoKey, p.FalseEasting)
+		addDouble(tProjFalseOriginNorthingGeoKey, p.FalseNorthing)
+	case epsg.AlbersEqualArea:
+		addShort(tProjCoordTransGeoKey, ctAlbersEqualArea)
+		addDouble(tProjStdParallel1GeoKey, p.StandardParallel1)
+		addDouble(tProjStdParallel2GeoKey, p.StandardParallel2)
+		addDouble(tProjNatOriginLatGeoKey, p.LatitudeOfOrigin)
+		addDouble(tProjNatOriginLongGeoKey, p.CentralMeridian)
+		addDouble(tProjFalseEastingGeoKey, p.FalseEasting)
+		addDouble(tProjFalseNorthingGeoKey, p.FalseNorthing)
+	case epsg.LambertAzimuthalEqualArea:
+		addShort(tProjCoordTransGeoKey, ctLambertAzimEqualArea)
+		addDouble(tProjCenterLatGeoKey, p.LatitudeOfOrigin)
+		addDouble(tProjCenterLongGeoKey, p.CentralMeridian)
+		addDouble(tProjFalseEastingGeoKey, p.FalseEasting)
+		addDouble(tProjFalseNorthingGeoKey, p.FalseNorthing)
+	case epsg.PolarStereographic:
+		addShort(tProjCoordTransGeoKey, ctPolarStereographic)
+		addDouble(tProjNatOriginLatGeoKey, p.StandardParallel1)
+		addDouble(tProjStraightVertPoleLongGeoKey, p.CentralMeridian)
+		addDouble(tProjScaleAtNatOriginGeoKey, p.ScaleFactor)
+		addDouble(tProjFalseEastingGeoKey, p.FalseEasting)
+		addDouble(tProjFalseNorthingGeoKey, p.FalseNorthing)
+	}
+
+	return ret
+}
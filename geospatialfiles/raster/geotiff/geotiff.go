@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -12,8 +13,10 @@ import (
 	"math"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster/geotiff/lzw"
 )
 
@@ -45,6 +48,30 @@ type GeoTIFF struct {
 	NodataValue       string
 	RasterPixelIsArea bool
 	EPSGCode          uint
+	Compress          bool // write deflate-compressed strips
+	// WKT describes a coordinate reference system without an EPSG code, e.g.
+	// one read from a .prj file. It is written in the citation geokey as an
+	// ESRI PE string, as GDAL writes such systems.
+	WKT string
+	// RowsPerStrip is the number of rows in each strip that is written; if it
+	// is zero, strips of about 8 KB are written, as the TIFF specification
+	// recommends.
+	RowsPerStrip uint
+	// Page is the image, or page, of a file that is read, from 0 for the
+	// first, and Pages is the number of pages in the file.
+	Page  int
+	Pages int
+	// Scale and Offset unpack the values stored in Data, as value * Scale +
+	// Offset, e.g. elevations stored as whole centimetres with a Scale of
+	// 0.01. They are held in the GDAL_METADATA tag, which is written if
+	// Scale isn't 1 (or 0) or Offset isn't 0.
+	Scale  float64
+	Offset float64
+	// NewData, if it isn't nil, allocates the zeroed Data of a file that is
+	// read, e.g. from a pool of buffers.
+	NewData func(n int) []float64
+	file    *os.File // the file opened by ReadHeader
+	layout  *blockLayout
 }
 
 func (g *GeoTIFF) Write(fileName string) (err error) {
@@ -69,25 +96,139 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 		return err
 	}
 
-	// output the offset to the IFD
+	// the data follow the header and the offset to the IFD
+	imageData, ifd, err := g.encode(8)
+	if err != nil {
+		return err
+	}
+	if err = binary.Write(w, g.ByteOrder, uint32(8+len(imageData))); err != nil {
+		return err
+	}
+	if err = writeImage(w, 8, imageData, ifd, g.ByteOrder); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Append writes the image as a new page of an existing TIFF file, after its
+// other pages, e.g. to keep related bands together in one file. The image is
+// written in the byte order of the file.
+func (g *GeoTIFF) Append(fileName string) (err error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		return FileOpeningError
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	p := make([]byte, 8)
+	if _, err = f.ReadAt(p, 0); err != nil {
+		return FileIsNotProperlyFormated
+	}
+	switch string(p[0:4]) {
+	case leHeader:
+		g.ByteOrder = binary.LittleEndian
+	case beHeader:
+		g.ByteOrder = binary.BigEndian
+	default:
+		return FileIsNotProperlyFormated
+	}
+
+	// find the offset to the next IFD of the last page, which is zero
+	nextPos := int64(4)
+	offset := int64(g.ByteOrder.Uint32(p[4:8]))
+	seen := make(map[int64]bool)
+	for offset > 0 {
+		if seen[offset] {
+			return FileIsNotProperlyFormated
+		}
+		seen[offset] = true
+		if nextPos, offset, err = nextIFD(f, offset, g.ByteOrder); err != nil {
+			return err
+		}
+	}
+
+	// the page is written at the end of the file, on a word boundary
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end%2 == 1 {
+		if _, err = f.Write([]byte{0}); err != nil {
+			return err
+		}
+		end++
+	}
+	if end >= math.MaxUint32 {
+		return errors.New("The file exceeds the 4 GB limit of a GeoTIFF file.")
+	}
+	imageData, ifd, err := g.encode(uint32(end))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = writeImage(w, uint32(end), imageData, ifd, g.ByteOrder); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	// link the page to the last one
+	b := make([]byte, 4)
+	g.ByteOrder.PutUint32(b, uint32(end)+uint32(len(imageData)))
+	_, err = f.WriteAt(b, nextPos)
+	return err
+}
+
+// nextIFD returns the position of the offset to the next IFD in the IFD at
+// offset, and that offset, which is zero if the IFD is the last.
+func nextIFD(r io.ReaderAt, offset int64, byteOrder binary.ByteOrder) (nextPos, next int64, err error) {
+	p := make([]byte, 4)
+	if _, err = r.ReadAt(p[0:2], offset); err != nil {
+		return 0, 0, FileIsNotProperlyFormated
+	}
+	nextPos = offset + 2 + ifdLen*int64(byteOrder.Uint16(p[0:2]))
+	if _, err = r.ReadAt(p, nextPos); err != nil {
+		return 0, 0, FileIsNotProperlyFormated
+	}
+	return nextPos, int64(byteOrder.Uint32(p)), nil
+}
+
+// writeImage writes the data of an image, which start at the offset base,
+// followed by its IFD, which is the last in the file.
+func writeImage(w io.Writer, base uint32, imageData []byte, ifd []IfdEntry, byteOrder binary.ByteOrder) error {
+	if _, err := w.Write(imageData); err != nil {
+		return err
+	}
+	if err := writeIFD(w, int(base)+len(imageData), ifd, byteOrder); err != nil {
+		return err
+	}
+	// The IFD ends with the offset of the next IFD in the file,
+	// or zero if it is the last one (page 14).
+	return binary.Write(w, byteOrder, uint32(0))
+}
+
+// encode returns the data of the image, as they are stored in the file, which
+// start at the offset base, and the entries of its IFD.
+func (g *GeoTIFF) encode(base uint32) (imageData []byte, ifd []IfdEntry, err error) {
 	var totalBytesPerPixel uint32 = 0
 	for _, bits := range g.BitsPerSample {
 		totalBytesPerPixel += uint32(bits)
 	}
 	totalBytesPerPixel /= 8
-	imageLen := uint32(g.Rows) * uint32(g.Columns) * totalBytesPerPixel
-	if err = binary.Write(w, g.ByteOrder, imageLen+8); err != nil {
-		return err
-	}
 
-	// output the data; compression is not currently supported for output
+	// encode the data
 	g.samplesPerPixel = uint(len(g.BitsPerSample))
 	buf := new(bytes.Buffer)
 	switch g.PhotometricInterp {
 	case PI_BlackIsZero, PI_WhiteIsZero:
 		if g.samplesPerPixel != 1 {
 			err = errors.New("The number of samples per pixel should be 1 for this photometric interpretation.")
-			return err
+			return nil, nil, err
 		}
 		switch g.SampleFormat {
 		case SF_SignedInteger:
@@ -98,7 +239,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = int8(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 				//for _, v := range g.Data {
 				//	if err = binary.Write(buf, g.ByteOrder, int8(v)); err != nil {
@@ -111,7 +252,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = int16(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 				//for _, v := range g.Data {
 				//	if err = binary.Write(buf, g.ByteOrder, int16(v)); err != nil {
@@ -124,7 +265,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = int32(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			case 64:
 				out := make([]int64, len(g.Data))
@@ -132,11 +273,11 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = int64(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			default:
 				err = errors.New("Unexpected bit-depth.")
-				return err
+				return nil, nil, err
 			}
 		case SF_FloatingPoint:
 			switch g.BitsPerSample[0] {
@@ -146,7 +287,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = float32(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 				//for _, v := range g.Data {
 				//	if err = binary.Write(buf, g.ByteOrder, float32(v)); err != nil {
@@ -155,11 +296,11 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 				//}
 			case 64:
 				if err = binary.Write(buf, g.ByteOrder, g.Data); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			default:
 				err = errors.New("Unexpected bit-depth.")
-				return err
+				return nil, nil, err
 			}
 		default: // sfUnsignedInteger
 			switch g.BitsPerSample[0] {
@@ -169,7 +310,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = uint8(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 				//for _, v := range g.Data {
 				//	if err = binary.Write(buf, g.ByteOrder, uint8(v)); err != nil {
@@ -182,7 +323,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = uint16(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			case 32:
 				out := make([]uint32, len(g.Data))
@@ -190,7 +331,7 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = uint32(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			case 64:
 				out := make([]uint64, len(g.Data))
@@ -198,14 +339,14 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 					out[i] = uint64(g.Data[i])
 				}
 				if err = binary.Write(buf, g.ByteOrder, out); err != nil {
-					return FileWritingError
+					return nil, nil, FileWritingError
 				}
 			default:
 				err = errors.New("Unexpected bit-depth.")
-				return err
+				return nil, nil, err
 			}
 		}
-		w.Write(buf.Bytes())
+		imageData = buf.Bytes()
 	case PI_RGB:
 		i := 0
 		bytes := make([]uint8, 3*len(g.Data))
@@ -235,37 +376,92 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 			}
 		} else {
 			err = errors.New("Unexpected number of samples per pixel.")
-			return err
+			return nil, nil, err
 		}
-		w.Write(bytes)
+		imageData = bytes
 	case PI_Paletted:
 		// TODO write the code for a paletted tiff
 	default:
 		panic(errors.New("An error has occurred during the writing of the geoTIFF file."))
 	}
 
+	// the rows are grouped into strips, which are deflate-compressed if
+	// required
+	rowLengthInBytes := uint64(g.Columns) * uint64(totalBytesPerPixel)
+	rowsPerStrip := uint64(g.RowsPerStrip)
+	if rowsPerStrip == 0 {
+		rowsPerStrip = 1
+		if rowLengthInBytes > 0 && rowLengthInBytes < stripSize {
+			rowsPerStrip = stripSize / rowLengthInBytes
+		}
+	}
+	if rowsPerStrip > uint64(g.Rows) && g.Rows > 0 {
+		rowsPerStrip = uint64(g.Rows)
+	}
+	numStrips := (uint64(g.Rows) + rowsPerStrip - 1) / rowsPerStrip
+	stripBounds := func(i uint64) (start, end uint64) {
+		start = i * rowsPerStrip * rowLengthInBytes
+		end = start + rowsPerStrip*rowLengthInBytes
+		if end > uint64(len(imageData)) {
+			end = uint64(len(imageData))
+		}
+		return start, end
+	}
+	stripOffsets := make([]uint32, numStrips)
+	stripByteCount := make([]uint32, numStrips)
+	if g.Compress && len(imageData) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		for i := uint64(0); i < numStrips; i++ {
+			start, end := stripBounds(i)
+			n := compressed.Len()
+			zw.Reset(&compressed)
+			if _, err = zw.Write(imageData[start:end]); err != nil {
+				return nil, nil, err
+			}
+			if err = zw.Close(); err != nil {
+				return nil, nil, err
+			}
+			stripOffsets[i] = base + uint32(n)
+			stripByteCount[i] = uint32(compressed.Len() - n)
+		}
+		imageData = compressed.Bytes()
+	}
+	// the offsets of a classic TIFF are 32-bit
+	if uint64(base)+uint64(len(imageData)) > math.MaxUint32 {
+		return nil, nil, errors.New("The image data exceed the 4 GB limit of a GeoTIFF file.")
+	}
+	if !g.Compress || len(imageData) == 0 {
+		for i := uint64(0); i < numStrips; i++ {
+			start, end := stripBounds(i)
+			stripOffsets[i] = base + uint32(start)
+			stripByteCount[i] = uint32(end - start)
+		}
+	}
+	// the IFD that follows the data begins on a word boundary
+	if len(imageData)%2 == 1 {
+		imageData = append(imageData, 0)
+	}
+
 	// create the ifd's
-	ifd := make([]IfdEntry, 0)
-	ifd = append(ifd, CreateIfdEntry(tImageWidth, dtShort, 1, uint16(g.Columns), g.ByteOrder))
-	ifd = append(ifd, CreateIfdEntry(tImageLength, dtShort, 1, uint16(g.Rows), g.ByteOrder))
+	ifd = make([]IfdEntry, 0)
+	ifd = append(ifd, createCountEntry(tImageWidth, g.Columns, g.ByteOrder))
+	ifd = append(ifd, createCountEntry(tImageLength, g.Rows, g.ByteOrder))
 	var bps = make([]uint16, g.samplesPerPixel)
 	for i := 0; i < int(g.samplesPerPixel); i++ {
 		bps[i] = uint16(g.BitsPerSample[i])
 	}
 	ifd = append(ifd, CreateIfdEntry(tBitsPerSample, dtShort, uint32(g.samplesPerPixel), bps, g.ByteOrder))
-	ifd = append(ifd, CreateIfdEntry(tCompression, dtShort, 1, uint16(1), g.ByteOrder))
+	if g.Compress {
+		ifd = append(ifd, CreateIfdEntry(tCompression, dtShort, 1, uint16(cDeflate), g.ByteOrder))
+	} else {
+		ifd = append(ifd, CreateIfdEntry(tCompression, dtShort, 1, uint16(cNone), g.ByteOrder))
+	}
 	ifd = append(ifd, CreateIfdEntry(tPhotometricInterpretation, dtShort, 1, uint16(g.PhotometricInterp), g.ByteOrder))
-	stripOffsets := make([]uint32, g.Rows)
-	stripByteCount := make([]uint32, g.Rows)
-	rowLengthInBytes := uint32(g.Columns) * totalBytesPerPixel
-	for i := 0; i < int(g.Rows); i++ {
-		stripOffsets[i] = uint32(8 + rowLengthInBytes*uint32(i))
-		stripByteCount[i] = rowLengthInBytes
-	}
-	ifd = append(ifd, CreateIfdEntry(tStripOffsets, dtLong, uint32(g.Rows), stripOffsets, g.ByteOrder))
+	ifd = append(ifd, CreateIfdEntry(tStripOffsets, dtLong, uint32(numStrips), stripOffsets, g.ByteOrder))
 	ifd = append(ifd, CreateIfdEntry(tSamplesPerPixel, dtShort, 1, uint16(g.samplesPerPixel), g.ByteOrder))
-	ifd = append(ifd, CreateIfdEntry(tRowsPerStrip, dtShort, 1, uint16(1), g.ByteOrder))
-	ifd = append(ifd, CreateIfdEntry(tStripByteCounts, dtLong, uint32(g.Rows), stripByteCount, g.ByteOrder))
+	ifd = append(ifd, createCountEntry(tRowsPerStrip, uint(rowsPerStrip), g.ByteOrder))
+	ifd = append(ifd, CreateIfdEntry(tStripByteCounts, dtLong, uint32(numStrips), stripByteCount, g.ByteOrder))
 	software := "GoSpatial"
 	softwareLength := uint32(len(software))
 	ifd = append(ifd, CreateIfdEntry(tSoftware, dtASCII, softwareLength, software, g.ByteOrder))
@@ -295,7 +491,12 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 	ifd = append(ifd, CreateIfdEntry(tModelPixelScaleTag, dtDouble, 3, g.TiepointData.getModelPixelScaleTagData(), g.ByteOrder))
 
 	if g.NodataValue != "" {
-		ifd = append(ifd, CreateIfdEntry(tGDAL_NODATA, dtASCII, uint32(len(g.NodataValue)), g.NodataValue, g.ByteOrder))
+		nodataStr := g.NodataValue + "\x00"
+		ifd = append(ifd, CreateIfdEntry(tGDAL_NODATA, dtASCII, uint32(len(nodataStr)), nodataStr, g.ByteOrder))
+	}
+	if g.Scale != 0 && (g.Scale != 1 || g.Offset != 0) {
+		metadata := gdalMetadata(g.Scale, g.Offset) + "\x00"
+		ifd = append(ifd, CreateIfdEntry(tGDAL_METADATA, dtASCII, uint32(len(metadata)), metadata, g.ByteOrder))
 	}
 
 	// Create the geokeys
@@ -321,9 +522,21 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 		v += "|"
 		v = strings.Replace(v, "_", " ", -1)
 		geokeys = append(geokeys, CreateIfdEntry(tGTCitationGeoKey, dtASCII, uint32(len(v)), v, g.ByteOrder))
+	} else if crs, ok := epsg.Lookup(int(g.EPSGCode)); ok {
+		// The code isn't one of those listed in the GeoTIFF specification;
+		// describe it using user-defined geokeys.
+		geokeys = append(geokeys, userDefinedGeoKeys(crs, g.ByteOrder)...)
 	} else {
 		if g.EPSGCode != 0 {
-			panic(errors.New("Unrecognized EPSG code."))
+			// Unrecognized code; there is no way to describe the system
+			// so record the code in the citation.
+			geokeys = append(geokeys, CreateIfdEntry(tGTModelTypeGeoKey, dtShort, 1, uint16(userDefined), g.ByteOrder))
+			v := fmt.Sprintf("EPSG:%d|", g.EPSGCode)
+			geokeys = append(geokeys, CreateIfdEntry(tGTCitationGeoKey, dtASCII, uint32(len(v)), v, g.ByteOrder))
+		} else if g.WKT != "" {
+			geokeys = append(geokeys, CreateIfdEntry(tGTModelTypeGeoKey, dtShort, 1, uint16(userDefined), g.ByteOrder))
+			v := esriPEString + g.WKT + "|"
+			geokeys = append(geokeys, CreateIfdEntry(tGTCitationGeoKey, dtASCII, uint32(len(v)), v, g.ByteOrder))
 		} else {
 			v := "Unknown|"
 			geokeys = append(geokeys, CreateIfdEntry(tGTCitationGeoKey, dtASCII, uint32(len(v)), v, g.ByteOrder))
@@ -341,24 +554,23 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 	gkdtData[3] = uint16(len(geokeys))
 	for i, val := range geokeys {
 		gkdtData[i*4+4] = uint16(val.tag.Code)
-		if val.count < 5 {
+		if val.dataType == dtASCII {
+			gkdtData[i*4+5] = tGeoAsciiParamsTag
+			gkdtData[i*4+6] = uint16(val.count)
+			gkdtData[i*4+7] = uint16(asciiParams.count)
+			asciiParams.AddData(val.rawData)
+			asciiParams.count += val.count
+		} else if val.dataType == dtDouble {
+			gkdtData[i*4+5] = tGeoDoubleParamsTag
+			gkdtData[i*4+6] = uint16(val.count)
+			gkdtData[i*4+7] = uint16(doubleParams.count)
+			doubleParams.AddData(val.rawData)
+			doubleParams.count += val.count
+		} else {
 			gkdtData[i*4+5] = 0
 			gkdtData[i*4+6] = 1
 			v, _ := val.InterpretDataAsInt()
 			gkdtData[i*4+7] = uint16(v[0])
-		} else {
-			gkdtData[i*4+5] = 0
-			gkdtData[i*4+6] = 1
-			if val.dataType == dtASCII {
-				gkdtData[i*4+7] = uint16(asciiParams.count)
-				asciiParams.AddData(val.rawData)
-				asciiParams.count += val.count
-			} else if val.dataType == dtDouble {
-				gkdtData[i*4+7] = uint16(doubleParams.count)
-				doubleParams.AddData(val.rawData)
-				doubleParams.count += val.count
-			}
-
 		}
 	}
 
@@ -374,17 +586,6 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 	// sort the ifd's
 	sort.Sort(ifdSortedByCode(ifd))
 
-	// output the ifd's
-	writeIFD(w, int(imageLen+8), ifd, g.ByteOrder)
-
-	// The IFD ends with the offset of the next IFD in the file,
-	// or zero if it is the last one (page 14).
-	if err := binary.Write(w, g.ByteOrder, uint32(0)); err != nil {
-		return err
-	}
-
-	w.Flush()
-
 	// use ifd to create the ifdList, which is really a map
 	g.ifdList = make(map[int]IfdEntry)
 	g.geoKeyList = make(map[int]IfdEntry)
@@ -396,7 +597,73 @@ func (g *GeoTIFF) Write(fileName string) (err error) {
 		g.geoKeyList[val.tag.Code] = val
 	}
 
-	return err
+	return imageData, ifd, nil
+}
+
+// gdalMetadataItem is an item of the XML document of the GDAL_METADATA tag,
+// e.g. <Item name="SCALE" sample="0" role="scale">0.01</Item>.
+type gdalMetadataItem struct {
+	Name   string `xml:"name,attr"`
+	Sample string `xml:"sample,attr"`
+	Role   string `xml:"role,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// parseGDALMetadata returns the scale and offset of the first band given in
+// the XML document of a GDAL_METADATA tag, which are 1 and 0 if it doesn't
+// give them.
+func parseGDALMetadata(s string) (scale, offset float64, err error) {
+	var doc struct {
+		Items []gdalMetadataItem `xml:"Item"`
+	}
+	scale, offset = 1, 0
+	if err = xml.Unmarshal([]byte(strings.TrimRight(s, "\x00")), &doc); err != nil {
+		return scale, offset, fmt.Errorf("The GDAL_METADATA tag can't be read: %v", err)
+	}
+	for _, item := range doc.Items {
+		if item.Sample != "" && item.Sample != "0" {
+			continue
+		}
+		var v *float64
+		switch strings.ToLower(item.Role) {
+		case "scale":
+			v = &scale
+		case "offset":
+			v = &offset
+		default:
+			continue
+		}
+		if *v, err = strconv.ParseFloat(strings.TrimSpace(item.Value), 64); err != nil {
+			return 1, 0, fmt.Errorf("The GDAL_METADATA tag has an invalid %s '%s'.", item.Role, item.Value)
+		}
+	}
+	return scale, offset, nil
+}
+
+// gdalMetadata returns the XML document of a GDAL_METADATA tag holding the
+// scale and offset of the first band.
+func gdalMetadata(scale, offset float64) string {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name           `xml:"GDALMetadata"`
+		Items   []gdalMetadataItem `xml:"Item"`
+	}{Items: []gdalMetadataItem{
+		{"OFFSET", "0", "offset", strconv.FormatFloat(offset, 'g', -1, 64)},
+		{"SCALE", "0", "scale", strconv.FormatFloat(scale, 'g', -1, 64)},
+	}})
+	return string(b)
+}
+
+// stripSize is the approximate size in bytes of the strips that are written
+// when the number of rows per strip isn't given.
+const stripSize = 8192
+
+// createCountEntry creates the entry of a tag holding a single count, e.g.
+// the image width, which may be a SHORT or, for counts beyond 65535, a LONG.
+func createCountEntry(code int, n uint, byteOrder binary.ByteOrder) IfdEntry {
+	if n > math.MaxUint16 {
+		return CreateIfdEntry(code, dtLong, 1, uint32(n), byteOrder)
+	}
+	return CreateIfdEntry(code, dtShort, 1, uint16(n), byteOrder)
 }
 
 func writeIFD(w io.Writer, ifdOffset int, d []IfdEntry, enc binary.ByteOrder) error {
@@ -415,6 +682,9 @@ func writeIFD(w io.Writer, ifdOffset int, d []IfdEntry, enc binary.ByteOrder) er
 		return err
 	}
 	for _, ent := range d {
+		// clear the value field so that short values are zero padded rather
+		// than holding bytes left over from the previous entry
+		buf = [ifdLen]byte{}
 		enc.PutUint16(buf[0:2], uint16(ent.tag.Code))
 		enc.PutUint16(buf[2:4], uint16(ent.dataType))
 		count := uint32(ent.count)
@@ -454,19 +724,57 @@ func writeIFD(w io.Writer, ifdOffset int, d []IfdEntry, enc binary.ByteOrder) er
 }
 
 func (g *GeoTIFF) Read(fileName string) (err error) {
+	if err = g.ReadHeader(fileName); err != nil {
+		return err
+	}
+	defer g.Close()
+	return g.readData()
+}
+
+// ReadHeader reads the tags of a file, but not its data, and leaves the file
+// open so that the data can then be read a strip, or row of tiles, at a time
+// with ReadBlockRow, e.g. for images that are too large to hold in memory.
+// Close closes the file.
+func (g *GeoTIFF) ReadHeader(fileName string) (err error) {
 	// initialize some things
 	g.ifdList = make(map[int]IfdEntry)
 	g.geoKeyList = make(map[int]IfdEntry)
 	g.off = 0
+	g.layout = nil
 
 	// open the file
 	f, err := os.Open(fileName)
 	if err != nil {
 		return FileOpeningError
 	}
-	defer f.Close()
+	defer func() {
+		if err != nil {
+			f.Close()
+			g.file = nil
+		}
+	}()
+
+	g.file = f
+	return g.readHeader(f)
+}
+
+// ReadReader reads the tags and data of a GeoTIFF from r, e.g. a file held
+// in memory.
+func (g *GeoTIFF) ReadReader(r io.ReaderAt) (err error) {
+	g.ifdList = make(map[int]IfdEntry)
+	g.geoKeyList = make(map[int]IfdEntry)
+	g.off = 0
+	g.layout = nil
+	g.file = nil
+	if err = g.readHeader(r); err != nil {
+		return err
+	}
+	return g.readData()
+}
 
-	g.r = f
+// readHeader reads the tags of a GeoTIFF from r.
+func (g *GeoTIFF) readHeader(r io.ReaderAt) (err error) {
+	g.r = r
 
 	p := make([]byte, 8)
 	if _, err := g.r.ReadAt(p, 0); err != nil && err != io.EOF {
@@ -487,12 +795,25 @@ func (g *GeoTIFF) Read(fileName string) (err error) {
 
 	offset := int64(g.ByteOrder.Uint32(p[4:8]))
 
-	for offset > 0 {
-		offset, err = g.readIFD(offset)
-		if err != nil {
+	// each page of the file has an IFD, and only that of the page being read
+	// is parsed
+	seen := make(map[int64]bool)
+	for g.Pages = 0; offset > 0; g.Pages++ {
+		if seen[offset] {
+			return FileIsNotProperlyFormated
+		}
+		seen[offset] = true
+		if g.Pages == g.Page {
+			if offset, err = g.readIFD(offset); err != nil {
+				return err
+			}
+			g.parseGeoKeys()
+		} else if _, offset, err = nextIFD(g.r, offset, g.ByteOrder); err != nil {
 			return err
 		}
-		g.parseGeoKeys()
+	}
+	if g.Page < 0 || g.Page >= g.Pages {
+		return fmt.Errorf("There is no page %d; the file has %d pages, numbered from 0.", g.Page, g.Pages)
 	}
 
 	//fmt.Println(g.GetTags())
@@ -527,6 +848,21 @@ func (g *GeoTIFF) Read(fileName string) (err error) {
 			g.EPSGCode = val[0]
 		}
 	}
+	if g.EPSGCode == userDefined {
+		g.EPSGCode = 0
+	}
+	if g.EPSGCode == 0 {
+		// user-defined systems written by GoSpatial carry the code in the citation
+		if ifd, ok := g.geoKeyList[tGTCitationGeoKey]; ok && ifd.dataType == DT_ASCII {
+			var code uint
+			citation := strings.TrimRight(string(ifd.rawData), "|\x00")
+			if _, err := fmt.Sscanf(citation, "EPSG:%d", &code); err == nil {
+				g.EPSGCode = code
+			} else if strings.HasPrefix(citation, esriPEString) {
+				g.WKT = strings.TrimPrefix(citation, esriPEString)
+			}
+		}
+	}
 
 	// see if the GDAL_NODATA tag has been set
 	if ifd, err := g.FindIFDEntryFromCode(tGDAL_NODATA); err == nil {
@@ -538,6 +874,13 @@ func (g *GeoTIFF) Read(fileName string) (err error) {
 			return err
 		}
 	}
+	// and the GDAL_METADATA tag, which holds the scale and offset
+	g.Scale, g.Offset = 1, 0
+	if ifd, err := g.FindIFDEntryFromCode(tGDAL_METADATA); err == nil && ifd.dataType == DT_ASCII {
+		if g.Scale, g.Offset, err = parseGDALMetadata(string(ifd.rawData)); err != nil {
+			return err
+		}
+	}
 	//if entry, err := g.FindIFDEntryFromCode(tGDAL_NODATA); err != TagNotFoundError {
 	//	strArray, err := entry.InterpretDataAsASCII()
 	//	if err == nil {
@@ -624,367 +967,461 @@ func (g *GeoTIFF) Read(fileName string) (err error) {
 		return
 	}
 
-	g.readData()
+	return nil
+}
 
-	return
+// Close closes the file opened by ReadHeader.
+func (g *GeoTIFF) Close() error {
+	if g.file == nil {
+		return nil
+	}
+	err := g.file.Close()
+	g.file = nil
+	return err
 }
 
 func (g *GeoTIFF) readData() (err error) {
-	compressionType := g.firstVal(tCompression)
-	g.SampleFormat = g.firstVal(tSampleFormat)
-
 	width := int(g.Columns)
 	height := int(g.Rows)
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("The image has an invalid size of %d rows by %d columns.", height, width)
+	}
 	//if g.mode == mGray || g.mode == mGrayInvert {
-	g.Data = make([]float64, width*height)
+	if g.NewData != nil {
+		g.Data = g.NewData(width * height)
+	} else {
+		g.Data = make([]float64, width*height)
+	}
 	//} else {
 	//	g.ColorData = make([]color.Color, width*height)
 	//}
 
-	blockPadding := false
-	blockWidth := int(g.Columns)
-	blockHeight := int(g.Rows)
-	blocksAcross := 1
-	blocksDown := 1
+	l, err := g.getBlockLayout()
+	if err != nil {
+		return err
+	}
+	for j := 0; j < l.down; j++ {
+		if err = g.ReadBlockRow(j, g.Data[j*l.height*width:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	var blockOffsets, blockCounts []uint
+// blockLayout describes the strips or tiles in which the data of an image are
+// stored.
+type blockLayout struct {
+	width, height   int  // the size of a block
+	across, down    int  // the number of blocks
+	padding         bool // whether the blocks at the right and bottom edges are padded, as tiles are
+	offsets, counts []uint
+	bitsPerPixel    int
+}
+
+func (g *GeoTIFF) getBlockLayout() (*blockLayout, error) {
+	if g.layout != nil {
+		return g.layout, nil
+	}
+	width := int(g.Columns)
+	height := int(g.Rows)
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("The image has an invalid size of %d rows by %d columns.", height, width)
+	}
+	l := &blockLayout{width: width, height: height, across: 1, down: 1}
 
 	if int(g.firstVal(tTileWidth)) != 0 {
-		blockPadding = true
+		l.padding = true
 
-		blockWidth = int(g.firstVal(tTileWidth))
-		blockHeight = int(g.firstVal(tTileLength))
+		l.width = int(g.firstVal(tTileWidth))
+		l.height = int(g.firstVal(tTileLength))
+		if l.width <= 0 || l.height <= 0 {
+			return nil, fmt.Errorf("The image has invalid tiles of %d rows by %d columns.", l.height, l.width)
+		}
 
-		blocksAcross = (width + blockWidth - 1) / blockWidth
-		blocksDown = (height + blockHeight - 1) / blockHeight
+		l.across = (width + l.width - 1) / l.width
+		l.down = (height + l.height - 1) / l.height
 
 		if ifd, ok := g.ifdList[tTileOffsets]; ok {
-			blockOffsets, _ = ifd.InterpretDataAsInt()
+			l.offsets, _ = ifd.InterpretDataAsInt()
 		}
 		if ifd, ok := g.ifdList[tTileByteCounts]; ok {
-			blockCounts, _ = ifd.InterpretDataAsInt()
+			l.counts, _ = ifd.InterpretDataAsInt()
 		}
 
 	} else {
 		if int(g.firstVal(tRowsPerStrip)) != 0 {
-			blockHeight = int(g.firstVal(tRowsPerStrip))
+			l.height = minInt(int(g.firstVal(tRowsPerStrip)), height)
 		}
 
-		blocksDown = (height + blockHeight - 1) / blockHeight
+		l.down = (height + l.height - 1) / l.height
 
 		if ifd, ok := g.ifdList[tStripOffsets]; ok {
-			blockOffsets, _ = ifd.InterpretDataAsInt()
+			l.offsets, _ = ifd.InterpretDataAsInt()
 		}
 		if ifd, ok := g.ifdList[tStripByteCounts]; ok {
-			blockCounts, _ = ifd.InterpretDataAsInt()
+			l.counts, _ = ifd.InterpretDataAsInt()
 		}
 	}
 
+	if numBlocks := l.across * l.down; len(l.offsets) < numBlocks || len(l.counts) < numBlocks {
+		return nil, fmt.Errorf("The image of %d rows by %d columns needs %d data blocks, but the file lists %d.",
+			height, width, numBlocks, minInt(len(l.offsets), len(l.counts)))
+	}
+	for _, b := range g.BitsPerSample {
+		l.bitsPerPixel += int(b)
+	}
+	g.layout = l
+	return l, nil
+}
+
+// BlockRows returns the number of rows in each strip, or row of tiles, in
+// which the data of an image read with ReadHeader are stored, i.e. the rows
+// that are decoded together by ReadBlockRow. The last may have fewer.
+func (g *GeoTIFF) BlockRows() (int, error) {
+	l, err := g.getBlockLayout()
+	if err != nil {
+		return 0, err
+	}
+	return l.height, nil
+}
+
+// ReadBlockRow decodes the j'th strip, or row of tiles, of an image read with
+// ReadHeader into data, which receives the values of its rows, in row-major
+// order, from the first.
+func (g *GeoTIFF) ReadBlockRow(j int, data []float64) (err error) {
+	l, err := g.getBlockLayout()
+	if err != nil {
+		return err
+	}
+	if j < 0 || j >= l.down {
+		return fmt.Errorf("There is no data block row %d.", j)
+	}
+	compressionType := g.firstVal(tCompression)
+	width := int(g.Columns)
+	height := int(g.Rows)
+	blockWidth, blockHeight := l.width, l.height
+	blocksAcross, blocksDown := l.across, l.down
+	blockPadding := l.padding
+	blockOffsets, blockCounts := l.offsets, l.counts
+	bitsPerPixel := l.bitsPerPixel
+	if need := (minInt((j+1)*blockHeight, height) - j*blockHeight) * width; len(data) < need {
+		return fmt.Errorf("Data block row %d needs a buffer of %d values, not %d.", j, need, len(data))
+	}
+
+	blkH := blockHeight
+	if !blockPadding && j == blocksDown-1 && height%blockHeight != 0 {
+		blkH = height % blockHeight
+	}
 	for i := 0; i < blocksAcross; i++ {
 		blkW := blockWidth
 		if !blockPadding && i == blocksAcross-1 && width%blockWidth != 0 {
 			blkW = width % blockWidth
 		}
-		for j := 0; j < blocksDown; j++ {
-			blkH := blockHeight
-			if !blockPadding && j == blocksDown-1 && height%blockHeight != 0 {
-				blkH = height % blockHeight
+		offset := int64(blockOffsets[j*blocksAcross+i])
+		n := int64(blockCounts[j*blocksAcross+i])
+		switch compressionType {
+		case cNone:
+			if b, ok := g.r.(*buffer); ok {
+				g.buf, err = b.Slice(int(offset), int(n))
+			} else {
+				g.buf = make([]byte, n)
+				_, err = g.r.ReadAt(g.buf, offset)
 			}
-			offset := int64(blockOffsets[j*blocksAcross+i])
-			n := int64(blockCounts[j*blocksAcross+i])
-			switch compressionType {
-			case cNone:
-				if b, ok := g.r.(*buffer); ok {
-					g.buf, err = b.Slice(int(offset), int(n))
-				} else {
-					g.buf = make([]byte, n)
-					_, err = g.r.ReadAt(g.buf, offset)
-				}
-			case cLZW:
-				r := lzw.NewReader(io.NewSectionReader(g.r, offset, n), lzw.MSB, 8)
-				defer r.Close()
-				g.buf, err = ioutil.ReadAll(r)
-				if err != nil {
-					println(err)
-					//println("Block X: ", i, "Block Y: ", j, "Offset: ", offset, "n: ", n, "buf len: ", len(g.buf))
-					//	panic(err)
-				}
-			case cDeflate, cDeflateOld:
-				r, err := zlib.NewReader(io.NewSectionReader(g.r, offset, n))
-				if err != nil {
-					return err
-				}
-				g.buf, err = ioutil.ReadAll(r)
-				r.Close()
-			case cPackBits:
+		case cLZW:
+			r := lzw.NewReader(io.NewSectionReader(g.r, offset, n), lzw.MSB, 8)
+			defer r.Close()
+			g.buf, err = ioutil.ReadAll(r)
+			if err != nil {
+				println(err)
+				//println("Block X: ", i, "Block Y: ", j, "Offset: ", offset, "n: ", n, "buf len: ", len(g.buf))
+				//	panic(err)
+			}
+		case cDeflate, cDeflateOld:
+			r, err := zlib.NewReader(io.NewSectionReader(g.r, offset, n))
+			if err != nil {
+				return err
+			}
+			g.buf, err = ioutil.ReadAll(r)
+			r.Close()
+		case cPackBits:
 
-			default:
-				err = errors.New(fmt.Sprintf("Unsupported compression value %d", compressionType))
+		default:
+			return errors.New(fmt.Sprintf("Unsupported compression value %d", compressionType))
 
+		}
+		xmin := i * blockWidth
+		ymin := j * blockHeight
+		xmax := xmin + blkW
+		ymax := ymin + blkH
+
+		xmax = minInt(xmax, width)
+		ymax = minInt(ymax, height)
+
+		// LZW errors were reported above; a short block is caught below
+		if err != nil && compressionType != cLZW {
+			return fmt.Errorf("Data block %d could not be read (%v); the file may be truncated.", j*blocksAcross+i, err)
+		}
+		if bitsPerPixel%8 == 0 {
+			if need := (ymax - ymin) * (xmax - xmin) * bitsPerPixel / 8; len(g.buf) < need {
+				return fmt.Errorf("Data block %d holds %d bytes, but its %d rows by %d columns need %d; the file may be truncated.",
+					j*blocksAcross+i, len(g.buf), ymax-ymin, xmax-xmin, need)
 			}
-			xmin := i * blockWidth
-			ymin := j * blockHeight
-			xmax := xmin + blkW
-			ymax := ymin + blkH
-
-			xmax = minInt(xmax, width)
-			ymax = minInt(ymax, height)
-
-			g.off = 0
-
-			// Apply horizontal predictor if necessary.
-			// In this case, p contains the color difference to the preceding pixel.
-			// See page 64-65 of the spec.
-			if g.firstVal(tPredictor) == prHorizontal {
-				// does it make sense to extend this to 32 and 64 bits?
-				if g.BitsPerSample[0] == 16 {
-					var off int
-					spp := len(g.BitsPerSample) // samples per pixel
-					bpp := spp * 2              // bytes per pixel
-					for y := ymin; y < ymax; y++ {
-						off += spp * 2
-						for x := 0; x < (xmax-xmin-1)*bpp; x += 2 {
-							v0 := g.ByteOrder.Uint16(g.buf[off-bpp : off-bpp+2])
-							v1 := g.ByteOrder.Uint16(g.buf[off : off+2])
-							g.ByteOrder.PutUint16(g.buf[off:off+2], v1+v0)
-							off += 2
-						}
+		}
+
+		g.off = 0
+
+		// Apply horizontal predictor if necessary.
+		// In this case, p contains the color difference to the preceding pixel.
+		// See page 64-65 of the spec.
+		if g.firstVal(tPredictor) == prHorizontal {
+			// does it make sense to extend this to 32 and 64 bits?
+			if g.BitsPerSample[0] == 16 {
+				var off int
+				spp := len(g.BitsPerSample) // samples per pixel
+				bpp := spp * 2              // bytes per pixel
+				for y := ymin; y < ymax; y++ {
+					off += spp * 2
+					for x := 0; x < (xmax-xmin-1)*bpp; x += 2 {
+						v0 := g.ByteOrder.Uint16(g.buf[off-bpp : off-bpp+2])
+						v1 := g.ByteOrder.Uint16(g.buf[off : off+2])
+						g.ByteOrder.PutUint16(g.buf[off:off+2], v1+v0)
+						off += 2
 					}
-				} else if g.BitsPerSample[0] == 8 {
-					var off int
-					spp := len(g.BitsPerSample) // samples per pixel
-					for y := ymin; y < ymax; y++ {
-						off += spp
-						for x := 0; x < (xmax-xmin-1)*spp; x++ {
-							g.buf[off] += g.buf[off-spp]
-							off++
-						}
+				}
+			} else if g.BitsPerSample[0] == 8 {
+				var off int
+				spp := len(g.BitsPerSample) // samples per pixel
+				for y := ymin; y < ymax; y++ {
+					off += spp
+					for x := 0; x < (xmax-xmin-1)*spp; x++ {
+						g.buf[off] += g.buf[off-spp]
+						off++
 					}
 				}
 			}
+		}
 
-			switch g.mode {
-			case mGray, mGrayInvert:
-				switch g.SampleFormat {
-				case 1: // Unsigned integer data
-					switch g.BitsPerSample[0] {
-					case 8:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								i := y*width + x
-								g.Data[i] = float64(g.buf[g.off])
-								g.off++
-							}
-						}
-					case 16:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := g.ByteOrder.Uint16(g.buf[g.off : g.off+2])
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 2
-							}
-						}
-					case 32:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := g.ByteOrder.Uint32(g.buf[g.off : g.off+4])
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 4
-							}
-						}
-					case 64:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := g.ByteOrder.Uint64(g.buf[g.off : g.off+8])
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 8
-							}
+		switch g.mode {
+		case mGray, mGrayInvert:
+			switch g.SampleFormat {
+			case 1: // Unsigned integer data
+				switch g.BitsPerSample[0] {
+				case 8:
+					for y := ymin; y < ymax; y++ {
+						for x := xmin; x < xmax; x++ {
+							i := (y-ymin)*width + x
+							data[i] = float64(g.buf[g.off])
+							g.off++
 						}
-					default:
-						err = errors.New("Unsupported data format")
-						return
 					}
-				case 2: // Signed integer data
-					switch g.BitsPerSample[0] {
-					case 8:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								i := y*width + x
-								g.Data[i] = float64(int8(g.buf[g.off]))
-								g.off++
-							}
-						}
-					case 16:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int16(g.ByteOrder.Uint16(g.buf[g.off : g.off+2]))
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 2
-							}
-						}
-					case 32:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int32(g.ByteOrder.Uint32(g.buf[g.off : g.off+4]))
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 4
-							}
-						}
-					case 64:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								value := int64(g.ByteOrder.Uint64(g.buf[g.off : g.off+8]))
-								i := y*width + x
-								g.Data[i] = float64(value)
-								g.off += 8
-							}
+				case 16:
+					for y := ymin; y < ymax; y++ {
+						for x := xmin; x < xmax; x++ {
+							value := g.ByteOrder.Uint16(g.buf[g.off : g.off+2])
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
+							g.off += 2
 						}
-					default:
-						err = errors.New("Unsupported data format")
-						return
 					}
-				case 3: // Floating point data
-					switch g.BitsPerSample[0] {
-					case 32:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								if g.off <= len(g.buf) {
-									bits := g.ByteOrder.Uint32(g.buf[g.off : g.off+4])
-									float := math.Float32frombits(bits)
-									i := y*width + x
-									g.Data[i] = float64(float)
-									g.off += 4
-								}
-							}
+				case 32:
+					for y := ymin; y < ymax; y++ {
+						for x := xmin; x < xmax; x++ {
+							value := g.ByteOrder.Uint32(g.buf[g.off : g.off+4])
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
+							g.off += 4
 						}
-					case 64:
-						for y := ymin; y < ymax; y++ {
-							for x := xmin; x < xmax; x++ {
-								if g.off <= len(g.buf) {
-									bits := g.ByteOrder.Uint64(g.buf[g.off : g.off+8])
-									float := math.Float64frombits(bits)
-									i := y*width + x
-									g.Data[i] = float
-									g.off += 8
-								}
-							}
+					}
+				case 64:
+					for y := ymin; y < ymax; y++ {
+						for x := xmin; x < xmax; x++ {
+							value := g.ByteOrder.Uint64(g.buf[g.off : g.off+8])
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
+							g.off += 8
 						}
-					default:
-						err = errors.New("Unsupported data format")
-						return
 					}
 				default:
-					err = errors.New("Unsupported sample format")
+					err = errors.New("Unsupported data format")
 					return
 				}
-			case mPaletted:
-				for y := ymin; y < ymax; y++ {
-					for x := xmin; x < xmax; x++ {
-						i := y*width + x
-						val := int(g.buf[g.off])
-						g.Data[i] = float64(g.palette[val])
-						g.off++
-					}
-				}
-
-			case mRGB:
-				if g.BitsPerSample[0] == 8 {
+			case 2: // Signed integer data
+				switch g.BitsPerSample[0] {
+				case 8:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							red := uint32(g.buf[g.off])
-							green := uint32(g.buf[g.off+1])
-							blue := uint32(g.buf[g.off+2])
-							a := uint32(255)
-							g.off += 3
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
+							i := (y-ymin)*width + x
+							data[i] = float64(int8(g.buf[g.off]))
+							g.off++
 						}
 					}
-				} else if g.BitsPerSample[0] == 16 {
+				case 16:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							// the spec doesn't talk about 16-bit RGB images so
-							// I'm not sure why I bother with this. They specifically
-							// say that RGB images are 8-bits per channel. Anyhow,
-							// I rescale the 16-bits to an 8-bit channel for simplicity.
-							red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
-							green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
-							blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
-							a := uint32(255)
-							g.off += 6
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
+							value := int16(g.ByteOrder.Uint16(g.buf[g.off : g.off+2]))
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
+							g.off += 2
 						}
 					}
-				} else {
-					err = errors.New("Unsupported data format")
-					return
-				}
-			case mNRGBA:
-				if g.BitsPerSample[0] == 8 {
+				case 32:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							red := uint32(g.buf[g.off])
-							green := uint32(g.buf[g.off+1])
-							blue := uint32(g.buf[g.off+2])
-							a := uint32(g.buf[g.off+3])
+							value := int32(g.ByteOrder.Uint32(g.buf[g.off : g.off+4]))
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
 							g.off += 4
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
 						}
 					}
-				} else if g.BitsPerSample[0] == 16 {
+				case 64:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
-							green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
-							blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
-							a := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+6:g.off+8])) / 65535.0 * 255.0)
+							value := int64(g.ByteOrder.Uint64(g.buf[g.off : g.off+8]))
+							i := (y-ymin)*width + x
+							data[i] = float64(value)
 							g.off += 8
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
 						}
 					}
-				} else {
+				default:
 					err = errors.New("Unsupported data format")
 					return
 				}
-			case mRGBA:
-				if g.BitsPerSample[0] == 16 {
+			case 3: // Floating point data
+				switch g.BitsPerSample[0] {
+				case 32:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
-							green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
-							blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
-							a := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+6:g.off+8])) / 65535.0 * 255.0)
-							g.off += 8
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
+							if g.off <= len(g.buf) {
+								bits := g.ByteOrder.Uint32(g.buf[g.off : g.off+4])
+								float := math.Float32frombits(bits)
+								i := (y-ymin)*width + x
+								data[i] = float64(float)
+								g.off += 4
+							}
 						}
 					}
-				} else {
+				case 64:
 					for y := ymin; y < ymax; y++ {
 						for x := xmin; x < xmax; x++ {
-							red := uint32(g.buf[g.off])
-							green := uint32(g.buf[g.off+1])
-							blue := uint32(g.buf[g.off+2])
-							a := uint32(g.buf[g.off+3])
-							g.off += 4
-							i := y*width + x
-							val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
-							g.Data[i] = float64(val)
+							if g.off <= len(g.buf) {
+								bits := g.ByteOrder.Uint64(g.buf[g.off : g.off+8])
+								float := math.Float64frombits(bits)
+								i := (y-ymin)*width + x
+								data[i] = float
+								g.off += 8
+							}
 						}
 					}
+				default:
+					err = errors.New("Unsupported data format")
+					return
+				}
+			default:
+				err = errors.New("Unsupported sample format")
+				return
+			}
+		case mPaletted:
+			for y := ymin; y < ymax; y++ {
+				for x := xmin; x < xmax; x++ {
+					i := (y-ymin)*width + x
+					val := int(g.buf[g.off])
+					data[i] = float64(g.palette[val])
+					g.off++
+				}
+			}
+
+		case mRGB:
+			if g.BitsPerSample[0] == 8 {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						red := uint32(g.buf[g.off])
+						green := uint32(g.buf[g.off+1])
+						blue := uint32(g.buf[g.off+2])
+						a := uint32(255)
+						g.off += 3
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
+				}
+			} else if g.BitsPerSample[0] == 16 {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						// the spec doesn't talk about 16-bit RGB images so
+						// I'm not sure why I bother with this. They specifically
+						// say that RGB images are 8-bits per channel. Anyhow,
+						// I rescale the 16-bits to an 8-bit channel for simplicity.
+						red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
+						green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
+						blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
+						a := uint32(255)
+						g.off += 6
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
+				}
+			} else {
+				err = errors.New("Unsupported data format")
+				return
+			}
+		case mNRGBA:
+			if g.BitsPerSample[0] == 8 {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						red := uint32(g.buf[g.off])
+						green := uint32(g.buf[g.off+1])
+						blue := uint32(g.buf[g.off+2])
+						a := uint32(g.buf[g.off+3])
+						g.off += 4
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
+				}
+			} else if g.BitsPerSample[0] == 16 {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
+						green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
+						blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
+						a := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+6:g.off+8])) / 65535.0 * 255.0)
+						g.off += 8
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
+				}
+			} else {
+				err = errors.New("Unsupported data format")
+				return
+			}
+		case mRGBA:
+			if g.BitsPerSample[0] == 16 {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						red := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+0:g.off+2])) / 65535.0 * 255.0)
+						green := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+2:g.off+4])) / 65535.0 * 255.0)
+						blue := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+4:g.off+6])) / 65535.0 * 255.0)
+						a := uint32(float64(g.ByteOrder.Uint16(g.buf[g.off+6:g.off+8])) / 65535.0 * 255.0)
+						g.off += 8
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
+				}
+			} else {
+				for y := ymin; y < ymax; y++ {
+					for x := xmin; x < xmax; x++ {
+						red := uint32(g.buf[g.off])
+						green := uint32(g.buf[g.off+1])
+						blue := uint32(g.buf[g.off+2])
+						a := uint32(g.buf[g.off+3])
+						g.off += 4
+						i := (y-ymin)*width + x
+						val := uint32((a << 24) | (red << 16) | (green << 8) | blue)
+						data[i] = float64(val)
+					}
 				}
 			}
 		}
@@ -1125,7 +1562,7 @@ func (g *GeoTIFF) parseGeoKeys() error {
 					if gkDoubleParams, err := g.FindIFDEntryFromCode(tGeoDoubleParamsTag); err == nil {
 						// I think that the offset is "based on the natural data type", which in this case is the number of
 						// 8-byte doubles. Unfortunately the GeoTiff specs don't clarify this.
-						raw := gkDoubleParams.rawData[valOffset*8 : valOffset*8+uint(newGeoKey.count)]
+						raw := gkDoubleParams.rawData[valOffset*8 : valOffset*8+uint(newGeoKey.count)*8]
 						newGeoKey.rawData = raw
 						newGeoKey.dataType = DT_Double
 					} else {
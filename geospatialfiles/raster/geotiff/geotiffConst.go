@@ -189,6 +189,26 @@ const (
 	prHorizontal = 2
 )
 
+// Value used by geokeys to indicate a user-defined parameter (section 6.3 of
+// the GeoTIFF spec).
+const userDefined = 32767
+
+// Prefix of a citation geokey holding the WKT of a coordinate reference
+// system, as GDAL writes those without an EPSG code.
+const esriPEString = "ESRI PE String = "
+
+// Coordinate transformation codes for the ProjCoordTransGeoKey (section 6.3.3.3).
+const (
+	ctTransverseMercator   = 1
+	ctMercator             = 7
+	ctLambertConfConic2SP  = 8
+	ctLambertAzimEqualArea = 10
+	ctAlbersEqualArea      = 11
+	ctPolarStereographic   = 15
+	linearMeter            = 9001
+	angularDegree          = 9102
+)
+
 // Values for the tResolutionUnit tag (page 18).
 const (
 	resNone    = 1
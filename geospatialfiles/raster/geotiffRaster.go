@@ -12,6 +12,7 @@ package raster
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
 	"os"
 	"strconv"
@@ -49,15 +50,16 @@ func (r *geotiffRaster) InitializeRaster(fileName string,
 
 	r.fileName = fileName
 
-	// does the file already exist? If yes, delete it.
-	if _, err = os.Stat(r.fileName); err == nil {
+	// does the file already exist? If yes, delete it, unless the raster is to
+	// be appended to it.
+	if _, err = os.Stat(r.fileName); err == nil && !config.AppendPage {
 		if err = os.Remove(r.fileName); err != nil {
 			return FileDeletingError
 		}
 	}
 
 	// initialize the data array
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	if config.InitialValue != 0 {
 		for i := range r.data {
 			r.data[i] = config.InitialValue
@@ -138,7 +140,7 @@ func (r *geotiffRaster) SetFileName(value string) (err error) {
 	r.config = NewDefaultRasterConfig()
 
 	// does the file exist?
-	if _, err = os.Stat(r.fileName); err == nil {
+	if FileExists(r.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -315,14 +317,41 @@ func (r *geotiffRaster) SetValue(index int, value float64) {
 
 // Save the file
 func (r *geotiffRaster) Save() (err error) {
-	// does the file already exist? If yes, delete it.
+	if r.gt.Pages > 1 {
+		return MultiPageSaveError
+	}
+	// the values are packed first, so that a file isn't deleted for values
+	// that can't be packed
+	r.gt.Data = r.data
+	nodata := r.config.NoDataValue
+	r.gt.Scale, r.gt.Offset = 1, 0
+	if r.config.packed() && len(r.gt.BitsPerSample) == 1 {
+		if r.gt.Data, nodata, err = r.pack(); err != nil {
+			return err
+		}
+		r.gt.Scale, r.gt.Offset = r.config.Scale, r.config.Offset
+	}
+
+	// does the file already exist? If yes, delete it, unless the raster is
+	// appended to it.
+	appendPage := false
 	if _, err = os.Stat(r.fileName); err == nil {
-		if err = os.Remove(r.fileName); err != nil {
+		if r.config.AppendPage {
+			appendPage = true
+		} else if err = os.Remove(r.fileName); err != nil {
 			return FileDeletingError
 		}
 	}
 
-	r.gt.Data = r.data
+	// the CRS may have been assigned after the raster was initialized
+	if r.config.EPSGCode == 0 {
+		r.config.EPSGCode = EPSGCodeFromWKT(r.config.CoordinateRefSystemWKT)
+	}
+	r.gt.EPSGCode = uint(r.config.EPSGCode)
+	r.gt.WKT = ""
+	if wkt := strings.TrimSpace(r.config.CoordinateRefSystemWKT); r.config.EPSGCode == 0 && wkt != "not specified" {
+		r.gt.WKT = wkt
+	}
 
 	if r.config.PixelIsArea {
 		cellSizeX := (r.header.east - r.header.west) / float64(r.header.columns)
@@ -338,18 +367,24 @@ func (r *geotiffRaster) Save() (err error) {
 		r.gt.TiepointData = tiepointData
 	}
 
-	if r.config.NoDataValue != math.MaxFloat32 {
-		r.gt.NodataValue = strconv.FormatFloat(r.config.NoDataValue, 'f', -1, 64)
+	if nodata != math.MaxFloat32 {
+		r.gt.NodataValue = strconv.FormatFloat(nodata, 'f', -1, 64)
 		r.gt.NodataValue = strings.TrimSpace(r.gt.NodataValue)
 		//r.gt.NodataValue = strings.Trim(r.gt.NodataValue, "\x00")
 
 	}
 
+	r.gt.Compress = CompressOutput
+	r.gt.RowsPerStrip = uint(RowsPerStrip)
+	if appendPage {
+		// the display settings in the sidecar are those of the first page
+		return r.gt.Append(r.fileName)
+	}
 	err = r.gt.Write(r.fileName)
 	if err != nil {
 		return err
 	}
-	return nil
+	return writeDisplaySidecar(r.fileName, r.config)
 }
 
 // Reads the file
@@ -360,8 +395,32 @@ func (r *geotiffRaster) ReadFile() error {
 	}
 
 	//r.gt := new(geotiff.GeoTIFF)
-	err := r.gt.Read(r.fileName)
-	r.check(err)
+	r.gt.NewData = newCellBuffer
+	if _, _, ok := zipMember(r.fileName); ok {
+		// a GeoTIFF within a ZIP archive is decompressed into memory
+		f, _, err := openFile(r.fileName)
+		if err != nil {
+			return FileOpeningError
+		}
+		defer f.Close()
+		if err = r.gt.ReadReader(f); err != nil {
+			return fmt.Errorf("%s: %v", r.fileName, err)
+		}
+	} else if err := r.gt.Read(r.fileName); err != nil {
+		return fmt.Errorf("%s: %v", r.fileName, err)
+	}
+	r.readTags()
+	r.data = r.gt.Data
+	r.unpack(r.data)
+
+	// the display settings are held in a sidecar file
+	return readDisplaySidecar(r.fileName, r.config)
+}
+
+// readTags sets the header and config of this raster from the tags of the
+// GeoTIFF, which may have been read without its data.
+func (r *geotiffRaster) readTags() {
+	var err error
 
 	r.header.columns = int(r.gt.Columns)
 	r.header.rows = int(r.gt.Rows)
@@ -382,10 +441,16 @@ func (r *geotiffRaster) ReadFile() error {
 	r.header.west = modelTiepoint[3] - modelTiepoint[0]*modelPixelScale[0]
 
 	if r.gt.NodataValue != "" {
-		r.config.NoDataValue, err = strconv.ParseFloat(r.gt.NodataValue, 64)
+		r.header.rawNodata, err = strconv.ParseFloat(r.gt.NodataValue, 64)
 		r.check(err)
 	} else {
-		r.config.NoDataValue = math.MaxFloat32
+		r.header.rawNodata = math.MaxFloat32
+	}
+	r.config.NoDataValue = r.header.rawNodata
+	r.config.Scale, r.config.Offset = r.gt.Scale, r.gt.Offset
+	r.config.readScale, r.config.readOffset = r.gt.Scale, r.gt.Offset
+	if r.config.packed() && r.config.NoDataValue != math.MaxFloat32 {
+		r.config.NoDataValue = r.header.rawNodata*r.config.Scale + r.config.Offset
 	}
 
 	// set the data type based on the sample format and the bitspersample
@@ -455,12 +520,79 @@ func (r *geotiffRaster) ReadFile() error {
 		panic(errors.New("Unrecognizable data format"))
 	}
 
-	// get the EPSG code of the file
+	// packed values are unpacked as floating-point values
+	if r.config.packed() && numSamples == 1 {
+		r.config.DataType = DT_FLOAT32
+	}
+
+	// get the EPSG code of the file, or the WKT of a system without one
 	r.config.EPSGCode = int(r.gt.EPSGCode)
+	if r.gt.WKT != "" {
+		r.config.CoordinateRefSystemWKT = r.gt.WKT
+	}
+}
 
-	r.data = r.gt.Data
+// unpack unpacks values read from the file as value * Scale + Offset, setting
+// those equal to the file's nodata value to the raster's.
+func (r *geotiffRaster) unpack(values []float64) {
+	if !r.config.packed() || len(r.gt.BitsPerSample) != 1 {
+		return
+	}
+	scale, offset := r.config.Scale, r.config.Offset
+	for i, v := range values {
+		if v == r.header.rawNodata {
+			values[i] = r.config.NoDataValue
+		} else {
+			values[i] = v*scale + offset
+		}
+	}
+}
 
-	return nil
+// pack returns a copy of the raster's values packed as (value - Offset) /
+// Scale, rounded to whole numbers for an integer sample format, and the
+// packed nodata value. It returns a *PackingOverflowError if a value, or the
+// nodata value, is packed outside of the range of an integer sample format.
+func (r *geotiffRaster) pack() ([]float64, float64, error) {
+	scale, offset := r.config.Scale, r.config.Offset
+	round := r.gt.SampleFormat != geotiff.SF_FloatingPoint
+	min, max := -math.MaxFloat64, math.MaxFloat64
+	if bits := r.gt.BitsPerSample[0]; r.gt.SampleFormat == geotiff.SF_SignedInteger {
+		min, max = -math.Ldexp(1, int(bits)-1), math.Ldexp(1, int(bits)-1)-1
+	} else if round {
+		min, max = 0, math.Ldexp(1, int(bits))-1
+	}
+	packValue := func(v float64) (float64, error) {
+		p := (v - offset) / scale
+		if round {
+			p = math.Floor(p + 0.5)
+		}
+		if p < min || p > max {
+			return p, &PackingOverflowError{FileName: r.fileName, Value: v, Scale: scale, Offset: offset,
+				Minimum: min, Maximum: max}
+		}
+		return p, nil
+	}
+	nodata := r.config.NoDataValue
+	rawNodata := nodata
+	if nodata != math.MaxFloat32 {
+		var err error
+		if rawNodata, err = packValue(nodata); err != nil {
+			return nil, 0, err
+		}
+	}
+	packed := make([]float64, len(r.data))
+	for i, v := range r.data {
+		if v == nodata {
+			packed[i] = rawNodata
+		} else {
+			p, err := packValue(v)
+			if err != nil {
+				return nil, 0, err
+			}
+			packed[i] = p
+		}
+	}
+	return packed, rawNodata, nil
 }
 
 type geotiffRasterHeader struct {
@@ -472,6 +604,8 @@ type geotiffRasterHeader struct {
 	south    float64
 	east     float64
 	west     float64
+	// the nodata value stored in the file, before unpacking
+	rawNodata float64
 }
 
 func (r *geotiffRaster) check(e error) {
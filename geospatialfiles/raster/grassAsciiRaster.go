@@ -26,6 +26,7 @@ type grassAsciiRaster struct {
 	minimumValue float64
 	maximumValue float64
 	config       *RasterConfig
+	headerOnly   bool // read only the header, leaving the data empty
 }
 
 func (r *grassAsciiRaster) InitializeRaster(fileName string,
@@ -54,7 +55,7 @@ func (r *grassAsciiRaster) InitializeRaster(fileName string,
 	}
 
 	// initialize the data array
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	if config.InitialValue != 0 {
 		for i := range r.data {
 			r.data[i] = config.InitialValue
@@ -78,7 +79,7 @@ func (r *grassAsciiRaster) SetFileName(value string) (err error) {
 
 	r.fileName = value
 	// does the file exist?
-	if _, err = os.Stat(r.fileName); err == nil {
+	if FileExists(r.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -270,7 +271,7 @@ func (r *grassAsciiRaster) Save() (err error) {
 	}
 
 	// write the header file
-	f, err := os.Create(r.fileName)
+	f, err := createCompressed(r.fileName)
 	r.check(err)
 	defer f.Close()
 	w := bufio.NewWriter(f)
@@ -302,11 +303,10 @@ func (r *grassAsciiRaster) Save() (err error) {
 		w.WriteString(str)
 	}
 
-	w.Flush()
-
-	// write the data file
-
-	return nil
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 // Reads the file
@@ -316,18 +316,20 @@ func (r *grassAsciiRaster) ReadFile() error {
 		return FileReadingError
 	}
 
-	f, err := os.Open(r.fileName)
+	f, err := OpenDecompressed(r.fileName)
 	if err != nil {
 		return FileOpeningError
 	}
 	defer f.Close()
 
 	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLineLength) // a row of a wide grid is a long line
 	cellNum := 0
+	inData := false
 	for scanner.Scan() {
 		str := strings.ToLower(scanner.Text())
 		s := strings.Fields(str)
-		if len(s) < 4 {
+		if !inData && strings.Contains(str, ":") { // a header line, e.g. 'rows: 100'
 			if strings.Contains(str, "north") {
 				r.header.north, err = strconv.ParseFloat(s[len(s)-1], 64)
 				r.check(err)
@@ -343,28 +345,50 @@ func (r *grassAsciiRaster) ReadFile() error {
 			} else if strings.Contains(str, "cols") {
 				r.header.columns, err = strconv.Atoi(s[len(s)-1])
 				r.check(err)
-				if r.header.rows > 0 {
-					r.header.numCells = r.header.columns * r.header.rows
-					r.data = make([]float64, r.header.numCells)
-				}
 			} else if strings.Contains(str, "rows") {
 				r.header.rows, err = strconv.Atoi(s[len(s)-1])
 				r.check(err)
-				if r.header.columns > 0 {
-					r.header.numCells = r.header.columns * r.header.rows
-					r.data = make([]float64, r.header.numCells)
-				}
 			} else if strings.Contains(str, "nodata:") {
 				r.header.nodata, err = strconv.ParseFloat(s[len(s)-1], 64)
 				r.check(err)
 			}
-		} else { // it's a data line
+		} else if len(s) > 0 { // it's a data line
+			if r.headerOnly {
+				break
+			}
+			if !inData {
+				if err = r.allocateData(); err != nil {
+					return err
+				}
+				inData = true
+			}
 			for _, v := range s {
-				r.data[cellNum], _ = strconv.ParseFloat(v, 64)
+				if cellNum < r.header.numCells {
+					r.data[cellNum], _ = strconv.ParseFloat(v, 64)
+				}
 				cellNum++
 			}
 		}
 	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if r.headerOnly {
+		if err = checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+			return err
+		}
+		r.header.numCells = r.header.columns * r.header.rows
+	} else {
+		if !inData {
+			if err = r.allocateData(); err != nil {
+				return err
+			}
+		}
+		if cellNum != r.header.numCells {
+			return &DataSizeError{r.fileName, r.header.rows, r.header.columns,
+				int64(r.header.numCells), int64(cellNum), "values"}
+		}
+	}
 
 	r.header.cellSize = (r.header.north - r.header.south) / float64(r.header.rows)
 
@@ -383,6 +407,17 @@ type grassAsciiRasterHeader struct {
 	west     float64
 }
 
+// allocateData checks the dimensions read from the header and allocates the
+// data to be read.
+func (r *grassAsciiRaster) allocateData() error {
+	if err := checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+		return err
+	}
+	r.header.numCells = r.header.columns * r.header.rows
+	r.data = newCellBuffer(r.header.numCells)
+	return nil
+}
+
 func (r *grassAsciiRaster) check(e error) {
 	if e != nil {
 		panic(e)
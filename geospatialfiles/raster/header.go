@@ -0,0 +1,83 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"fmt"
+	"math"
+)
+
+// readHeader reads the header of a raster file into rd, i.e. its dimensions,
+// georeferencing, CRS and metadata, without reading its data, which is left
+// empty. The minimum and maximum values are those recorded in the header, by
+// the Whitebox and Idrisi formats, and are unknown, i.e. math.MaxFloat64 and
+// -math.MaxFloat64, otherwise.
+func readHeader(rd rasterData, fileName string) (err error) {
+	if !FileExists(fileName) {
+		return FileDoesNotExistError
+	}
+	config := NewDefaultRasterConfig()
+	config.RasterFormat = rd.RasterType()
+	switch r := rd.(type) {
+	case *whiteboxRaster:
+		r.config = config
+		if err = r.setFileNames(fileName); err != nil {
+			return err
+		}
+		if err = r.readHeaderFile(); err != nil {
+			return FileReadingError
+		}
+	case *idrisiRaster:
+		r.config = config
+		if err = r.setFileNames(fileName); err != nil {
+			return err
+		}
+		if err = r.readHeaderFile(); err != nil {
+			return FileReadingError
+		}
+	case *arcGisBinaryRaster:
+		r.config = config
+		if err = r.setFileNames(fileName); err != nil {
+			return err
+		}
+		if err = r.header.readHeaderFile(); err != nil {
+			return FileReadingError
+		}
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+	case *geotiffRaster:
+		if _, _, ok := zipMember(fileName); ok {
+			// a GeoTIFF within a ZIP archive is decompressed into memory
+			// whole
+			return r.SetFileName(fileName)
+		}
+		r.fileName, r.config = fileName, config
+		if err = r.gt.ReadHeader(fileName); err != nil {
+			return fmt.Errorf("%s: %v", fileName, err)
+		}
+		r.gt.Close()
+		r.readTags()
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+		return readDisplaySidecar(fileName, r.config)
+	case *arcGisASCIIRaster:
+		r.fileName, r.config, r.headerOnly = fileName, config, true
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+		return r.ReadFile()
+	case *grassAsciiRaster:
+		r.fileName, r.config, r.headerOnly = fileName, config, true
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+		return r.ReadFile()
+	case *erdasImagineRaster:
+		r.fileName, r.config, r.headerOnly = fileName, config, true
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+		return r.ReadFile()
+	case *netCDFRaster:
+		r.fileName, r.config, r.headerOnly = fileName, config, true
+		r.minimumValue, r.maximumValue = math.MaxFloat64, -math.MaxFloat64
+		return r.ReadFile()
+	default:
+		return rd.SetFileName(fileName)
+	}
+	return nil
+}
@@ -14,7 +14,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
@@ -68,7 +67,7 @@ func (r *idrisiRaster) InitializeRaster(fileName string,
 	}
 
 	// initialize the data array
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	if config.InitialValue != 0 {
 		for i := range r.data {
 			r.data[i] = config.InitialValue
@@ -91,19 +90,12 @@ func (r *idrisiRaster) SetFileName(value string) (err error) {
 	r.config = NewDefaultRasterConfig()
 
 	// sort out the names of the header and data files
-	ext := strings.ToLower(filepath.Ext(value))
-	if ext == ".rst" {
-		r.dataFile = value
-		r.header.fileName = strings.Replace(value, ext, ".rdc", -1)
-	} else if ext == ".rdc" {
-		r.header.fileName = value
-		r.dataFile = strings.Replace(value, ext, ".rst", -1)
-	} else {
-		return UnsupportedRasterFormatError
+	if err = r.setFileNames(value); err != nil {
+		return err
 	}
 
 	// does the file exist?
-	if _, err = os.Stat(r.header.fileName); err == nil {
+	if FileExists(r.header.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -119,6 +111,22 @@ func (r *idrisiRaster) SetFileName(value string) (err error) {
 	return nil
 }
 
+// setFileNames sets the names of the header and data files of this Idrisi
+// raster from the name of either.
+func (r *idrisiRaster) setFileNames(value string) error {
+	ext := strings.ToLower(filepath.Ext(value))
+	if ext == ".rst" {
+		r.dataFile = value
+		r.header.fileName = strings.Replace(value, ext, ".rdc", -1)
+	} else if ext == ".rdc" {
+		r.header.fileName = value
+		r.dataFile = strings.Replace(value, ext, ".rst", -1)
+	} else {
+		return UnsupportedRasterFormatError
+	}
+	return nil
+}
+
 // Retrieve the RasterType of this Raster.
 func (r *idrisiRaster) RasterType() RasterType {
 	return RT_IdrisiRaster
@@ -342,10 +350,29 @@ func (r *idrisiRaster) ReadFile() error {
 	}
 
 	// read the data file
-	bytedata, err := ioutil.ReadFile(r.dataFile)
+	bytedata, err := readFile(r.dataFile)
+	if err != nil {
+		return err
+	}
+	var bytesPerCell int
+	switch r.config.DataType {
+	case DT_FLOAT32:
+		bytesPerCell = 4
+	case DT_INT16:
+		bytesPerCell = 2
+	case DT_UINT8:
+		bytesPerCell = 1
+	case DT_RGB24:
+		bytesPerCell = 3
+	default:
+		return FileReadingError
+	}
+	if err = checkDataSize(r.dataFile, r.header.rows, r.header.columns, bytesPerCell, int64(len(bytedata))); err != nil {
+		return err
+	}
 	buf := bytes.NewReader(bytedata)
 	r.header.numCells = r.header.columns * r.header.rows
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	switch r.config.DataType {
 	case DT_FLOAT32:
 		nativeData := make([]float32, r.header.numCells)
@@ -398,7 +425,7 @@ func (r *idrisiRaster) readHeaderFile() error {
 	if r.header.fileName == "" {
 		return errors.New("Idrisi raster header file not set properly.")
 	}
-	content, err := ioutil.ReadFile(r.header.fileName)
+	content, err := readFile(r.header.fileName)
 	r.check(err)
 	str := strings.Replace(string(content), "\r\n", "\n", -1)
 	lines := strings.Split(str, "\n")
@@ -0,0 +1,112 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incomplete holds the files of the rasters that have been created, or are
+// being saved, but haven't been completely written, so that a run that is
+// stopped part way, e.g. with Ctrl-C, can remove them rather than leave
+// half-written outputs behind. Each file is mapped to the time at which it
+// was last modified when the raster was created, or the zero time if it
+// didn't exist.
+var incomplete = struct {
+	sync.Mutex
+	files   map[string]time.Time
+	rasters map[string][]string
+}{files: make(map[string]time.Time), rasters: make(map[string][]string)}
+
+// rasterFiles returns the files written for a raster of a format, including
+// its header and sidecar files.
+func rasterFiles(rt RasterType, fileName string) []string {
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	files := []string{fileName}
+	switch rt {
+	case RT_WhiteboxRaster:
+		files = []string{base + ".dep", base + ".tas"}
+	case RT_IdrisiRaster:
+		files = []string{base + ".rdc", base + ".rst"}
+	case RT_ArcGisBinaryRaster:
+		files = []string{base + ".hdr", base + ".flt"}
+	case RT_GeoTiff:
+		files = append(files, fileName+DisplaySidecarExtension)
+	}
+	if usesPrjSidecar(rt) {
+		files = append(files, PrjFileName(fileName))
+	}
+	return files
+}
+
+// beginOutput records that a raster is being written.
+func beginOutput(rt RasterType, fileName string) {
+	incomplete.Lock()
+	defer incomplete.Unlock()
+	if _, ok := incomplete.rasters[fileName]; ok {
+		return
+	}
+	files := rasterFiles(rt, fileName)
+	for _, f := range files {
+		var modTime time.Time
+		if fi, err := os.Stat(f); err == nil {
+			modTime = fi.ModTime()
+		}
+		incomplete.files[f] = modTime
+	}
+	incomplete.rasters[fileName] = files
+}
+
+// endOutput records that a raster has been completely written.
+func endOutput(fileName string) {
+	incomplete.Lock()
+	defer incomplete.Unlock()
+	for _, f := range incomplete.rasters[fileName] {
+		delete(incomplete.files, f)
+	}
+	delete(incomplete.rasters, fileName)
+}
+
+// RemoveIncompleteOutputs removes the files of the rasters that have been
+// created, or were being saved, but haven't been completely written, and
+// returns their names. Files that existed before the raster was created and
+// haven't been changed since are left alone. The record of incomplete
+// rasters is then cleared.
+func RemoveIncompleteOutputs() []string {
+	incomplete.Lock()
+	defer incomplete.Unlock()
+	var removed []string
+	for f, modTime := range incomplete.files {
+		fi, err := os.Stat(f)
+		if err != nil || (!modTime.IsZero() && fi.ModTime().Equal(modTime)) {
+			continue
+		}
+		if os.Remove(f) == nil {
+			removed = append(removed, f)
+		}
+	}
+	sort.Strings(removed)
+	forgetIncompleteOutputs()
+	return removed
+}
+
+// ForgetIncompleteOutputs clears the record of incomplete rasters without
+// removing their files, e.g. when a new run starts, so that rasters that a
+// finished run created but never saved aren't removed by a later one.
+func ForgetIncompleteOutputs() {
+	incomplete.Lock()
+	defer incomplete.Unlock()
+	forgetIncompleteOutputs()
+}
+
+func forgetIncompleteOutputs() {
+	incomplete.files = make(map[string]time.Time)
+	incomplete.rasters = make(map[string][]string)
+}
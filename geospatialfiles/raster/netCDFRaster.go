@@ -0,0 +1,659 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Used to read a NetCDF (.nc) raster file. The classic and 64-bit offset
+// formats (CDF-1, CDF-2 and CDF-5) are supported, but not NetCDF-4, which is
+// built on HDF5. A file may hold many variables; the one read is the
+// variable with the largest grid of two or more dimensions, of which the
+// last two are taken to be y and x, following the CF conventions, and the
+// first slice, e.g. the first time step, is read. The cell centres are given
+// by the coordinate variables of the x and y dimensions, and the CF
+// scale_factor, add_offset and _FillValue (or missing_value) attributes are
+// applied. The format is read-only.
+type netCDFRaster struct {
+	fileName     string
+	data         []float64
+	header       netCDFRasterHeader
+	minimumValue float64
+	maximumValue float64
+	config       *RasterConfig
+	metadata     []string
+	headerOnly   bool // read only the header, leaving the data empty
+}
+
+type netCDFRasterHeader struct {
+	rows     int
+	columns  int
+	numCells int
+	variable string
+	nodata   float64
+	north    float64
+	south    float64
+	east     float64
+	west     float64
+}
+
+// The NetCDF data types
+const (
+	nc_byte = iota + 1
+	nc_char
+	nc_short
+	nc_int
+	nc_float
+	nc_double
+	nc_ubyte
+	nc_ushort
+	nc_uint
+	nc_int64
+	nc_uint64
+)
+
+// the size in bytes of a value of each data type
+var ncTypeSizes = []int{0, 1, 1, 2, 4, 4, 8, 1, 2, 4, 8, 8}
+
+// the fill values used by NetCDF for each data type when no _FillValue is
+// given
+var ncDefaultFillValues = []float64{0, -127, 0, -32767, -2147483647,
+	float64(float32(9.9692099683868690e+36)), 9.9692099683868690e+36,
+	255, 65535, 4294967295, -9223372036854775806, 18446744073709551614}
+
+type ncDimension struct {
+	name   string
+	length int64
+}
+
+type ncAttribute struct {
+	name   string
+	text   string    // the value of a character attribute
+	values []float64 // the values of a numeric attribute
+}
+
+type ncVariable struct {
+	name       string
+	dimIDs     []int64
+	attributes []ncAttribute
+	dataType   int
+	begin      int64
+}
+
+// attribute returns the attribute of a variable with a name, if it has one.
+func (v *ncVariable) attribute(name string) (ncAttribute, bool) {
+	return ncFindAttribute(v.attributes, name)
+}
+
+func ncFindAttribute(attributes []ncAttribute, name string) (ncAttribute, bool) {
+	for _, a := range attributes {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return ncAttribute{}, false
+}
+
+func (r *netCDFRaster) InitializeRaster(fileName string,
+	rows int, columns int, north float64, south float64,
+	east float64, west float64, config *RasterConfig) (err error) {
+	return ReadOnlyRasterFormatError
+}
+
+// Retrieve the file name (.nc) of this NetCDF raster file.
+func (r *netCDFRaster) FileName() string {
+	return r.fileName
+}
+
+// Set the file name (.nc) of this NetCDF raster file and read it.
+func (r *netCDFRaster) SetFileName(value string) (err error) {
+	r.config = NewDefaultRasterConfig()
+
+	r.fileName = value
+	// does the file exist?
+	if FileExists(r.fileName) {
+		// yes it does; read the file
+		if err = r.ReadFile(); err != nil {
+			return err
+		}
+	} else {
+		return FileDoesNotExistError
+	}
+
+	r.minimumValue = math.MaxFloat64
+	r.maximumValue = -math.MaxFloat64
+	r.config.RasterFormat = RT_NetCDFRaster
+
+	return nil
+}
+
+// Retrieve the RasterType of this Raster.
+func (r *netCDFRaster) RasterType() RasterType {
+	return RT_NetCDFRaster
+}
+
+// Retrieve the number of rows this NetCDF raster file.
+func (r *netCDFRaster) Rows() int {
+	return r.header.rows
+}
+
+// Sets the number of rows of this NetCDF raster file.
+func (r *netCDFRaster) SetRows(value int) {
+	r.header.rows = value
+}
+
+// Retrieve the number of columns of this NetCDF raster file.
+func (r *netCDFRaster) Columns() int {
+	return r.header.columns
+}
+
+// Sets the number of columns of this NetCDF raster file.
+func (r *netCDFRaster) SetColumns(value int) {
+	r.header.columns = value
+}
+
+// Retrieve the raster's northern edge's coordinate
+func (r *netCDFRaster) North() float64 {
+	return r.header.north
+}
+
+// Retrieve the raster's southern edge's coordinate
+func (r *netCDFRaster) South() float64 {
+	return r.header.south
+}
+
+// Retrieve the raster's eastern edge's coordinate
+func (r *netCDFRaster) East() float64 {
+	return r.header.east
+}
+
+// Retrieve the raster's western edge's coordinate
+func (r *netCDFRaster) West() float64 {
+	return r.header.west
+}
+
+// Retrieve the raster's minimum value
+func (r *netCDFRaster) MinimumValue() float64 {
+	if r.minimumValue == math.MaxFloat64 {
+		r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	}
+	return r.minimumValue
+}
+
+// Retrieve the raster's minimum value
+func (r *netCDFRaster) MaximumValue() float64 {
+	if r.maximumValue == -math.MaxFloat64 {
+		r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	}
+	return r.maximumValue
+}
+
+func (r *netCDFRaster) findMinAndMaxVals() (minVal float64, maxVal float64) {
+	if r.data != nil && len(r.data) > 0 {
+		minVal = math.MaxFloat64
+		maxVal = -math.MaxFloat64
+		for _, v := range r.data {
+			if v != r.header.nodata {
+				if v > maxVal {
+					maxVal = v
+				}
+				if v < minVal {
+					minVal = v
+				}
+			}
+		}
+		return minVal, maxVal
+	} else {
+		return math.MaxFloat64, -math.MaxFloat64
+	}
+}
+
+// Sets the raster config
+func (r *netCDFRaster) SetRasterConfig(value *RasterConfig) {
+	r.config = value
+}
+
+// Retrieves the raster config
+func (r *netCDFRaster) GetRasterConfig() *RasterConfig {
+	return r.config
+}
+
+// Retrieve the NoData value used by this NetCDF raster file.
+func (r *netCDFRaster) NoData() float64 {
+	return r.header.nodata
+}
+
+// Sets the NoData value used by this NetCDF raster file.
+func (r *netCDFRaster) SetNoData(value float64) {
+	r.header.nodata = value
+}
+
+// Retrieve the byte order used by this NetCDF raster file.
+func (r *netCDFRaster) ByteOrder() binary.ByteOrder {
+	return binary.BigEndian
+}
+
+// Sets the byte order used by this NetCDF raster file.
+func (r *netCDFRaster) SetByteOrder(value binary.ByteOrder) {
+	// Do nothing, NetCDF files are always big-endian.
+	// This method is simply present to satisfy the RasterData interface
+}
+
+// Retrieves the metadata for this raster
+func (r *netCDFRaster) MetadataEntries() []string {
+	return r.metadata
+}
+
+// Adds a metadata entry to this raster
+func (r *netCDFRaster) AddMetadataEntry(value string) {
+	r.metadata = append(r.metadata, value)
+}
+
+// Returns the data as a slice of float64 values
+func (r *netCDFRaster) Data() ([]float64, error) {
+	if len(r.data) == 0 {
+		if err := r.ReadFile(); err != nil {
+			return nil, err
+		}
+	}
+	return r.data, nil
+}
+
+// Sets the data from a slice of float64 values
+func (r *netCDFRaster) SetData(values []float64) {
+	if r.header.numCells == 0 {
+		r.header.numCells = r.header.rows * r.header.columns
+	}
+	if len(values) == r.header.numCells {
+		r.data = values
+	} else {
+		panic(DataSetError)
+	}
+}
+
+// Returns the value within data
+func (r *netCDFRaster) Value(index int) float64 {
+	return r.data[index]
+}
+
+// Sets the value of index within data
+func (r *netCDFRaster) SetValue(index int, value float64) {
+	r.data[index] = value
+}
+
+// Save the file. NetCDF rasters are read-only.
+func (r *netCDFRaster) Save() (err error) {
+	return ReadOnlyRasterFormatError
+}
+
+// Reads the file
+func (r *netCDFRaster) ReadFile() error {
+	if r.fileName == "" {
+		return FileReadingError
+	}
+	f, fileSize, err := openFile(r.fileName)
+	if err != nil {
+		return FileOpeningError
+	}
+	defer f.Close()
+
+	hr := &ncHeaderReader{r: bufio.NewReader(f)}
+	magic := hr.bytes(4)
+	if hr.err != nil {
+		return FileIsNotProperlyFormated
+	}
+	if bytes.HasPrefix(magic, []byte("\x89HDF")) {
+		return fmt.Errorf("%s: NetCDF-4 (HDF5) files are not supported; convert the file to the classic format, e.g. with nccopy -k classic", r.fileName)
+	}
+	if !bytes.HasPrefix(magic, []byte("CDF")) || (magic[3] != 1 && magic[3] != 2 && magic[3] != 5) {
+		return FileIsNotProperlyFormated
+	}
+	hr.version = int(magic[3])
+
+	// the header: the number of records, then the lists of dimensions,
+	// global attributes and variables
+	numRecords := hr.size()
+	var dims []ncDimension
+	for i, n := 0, hr.listLength(0x0A); i < n && hr.err == nil; i++ {
+		dims = append(dims, ncDimension{hr.name(), hr.size()})
+	}
+	globalAttributes := hr.attributes()
+	var vars []ncVariable
+	for i, n := 0, hr.listLength(0x0B); i < n && hr.err == nil; i++ {
+		var v ncVariable
+		v.name = hr.name()
+		numDims := int(hr.size())
+		for j := 0; j < numDims && hr.err == nil; j++ {
+			v.dimIDs = append(v.dimIDs, hr.size())
+		}
+		v.attributes = hr.attributes()
+		v.dataType = int(hr.int32())
+		hr.size() // vsize
+		v.begin = hr.offset()
+		vars = append(vars, v)
+	}
+	if hr.err != nil {
+		return FileIsNotProperlyFormated
+	}
+	for _, v := range vars {
+		for _, id := range v.dimIDs {
+			if id < 0 || id >= int64(len(dims)) {
+				return FileIsNotProperlyFormated
+			}
+		}
+		if v.dataType < nc_byte || v.dataType > nc_uint64 {
+			return FileIsNotProperlyFormated
+		}
+	}
+	dimLength := func(id int64) int64 {
+		if dims[id].length == 0 {
+			return numRecords // the record dimension
+		}
+		return dims[id].length
+	}
+
+	// the variable with the largest grid
+	var grid *ncVariable
+	var gridSize int64
+	for i := range vars {
+		v := &vars[i]
+		n := len(v.dimIDs)
+		if n < 2 || v.dataType == nc_char {
+			continue
+		}
+		size := dimLength(v.dimIDs[n-2]) * dimLength(v.dimIDs[n-1])
+		if size > gridSize {
+			grid, gridSize = v, size
+		}
+	}
+	if grid == nil {
+		return fmt.Errorf("%s: the file contains no gridded variables", r.fileName)
+	}
+	n := len(grid.dimIDs)
+	yDim, xDim := dims[grid.dimIDs[n-2]], dims[grid.dimIDs[n-1]]
+	r.header.rows = int(dimLength(grid.dimIDs[n-2]))
+	r.header.columns = int(dimLength(grid.dimIDs[n-1]))
+	r.header.variable = grid.name
+	for _, id := range grid.dimIDs[:n-2] {
+		if dimLength(id) == 0 {
+			return fmt.Errorf("%s: the variable %s has no data", r.fileName, grid.name)
+		}
+	}
+	if err = checkDimensions(r.fileName, r.header.rows, r.header.columns); err != nil {
+		return err
+	}
+	r.header.numCells = r.header.rows * r.header.columns
+	size := ncTypeSizes[grid.dataType]
+	if end := grid.begin + int64(r.header.numCells*size); grid.begin < 0 || end > fileSize {
+		return &DataSizeError{r.fileName, r.header.rows, r.header.columns,
+			end, fileSize, "bytes"}
+	}
+
+	switch grid.dataType {
+	case nc_byte:
+		r.config.DataType = DT_INT8
+	case nc_ubyte:
+		r.config.DataType = DT_UINT8
+	case nc_short:
+		r.config.DataType = DT_INT16
+	case nc_ushort:
+		r.config.DataType = DT_UINT16
+	case nc_int:
+		r.config.DataType = DT_INT32
+	case nc_uint:
+		r.config.DataType = DT_UINT32
+	case nc_int64:
+		r.config.DataType = DT_INT64
+	case nc_uint64:
+		r.config.DataType = DT_UINT64
+	case nc_float:
+		r.config.DataType = DT_FLOAT32
+	case nc_double:
+		r.config.DataType = DT_FLOAT64
+	}
+
+	// packed values are unpacked as value * scale_factor + add_offset
+	scale, offset := 1.0, 0.0
+	if a, ok := grid.attribute("scale_factor"); ok && len(a.values) > 0 {
+		scale = a.values[0]
+		r.config.DataType = DT_FLOAT32
+	}
+	if a, ok := grid.attribute("add_offset"); ok && len(a.values) > 0 {
+		offset = a.values[0]
+		r.config.DataType = DT_FLOAT32
+	}
+	fill := ncDefaultFillValues[grid.dataType]
+	if a, ok := grid.attribute("_FillValue"); ok && len(a.values) > 0 {
+		fill = a.values[0]
+	} else if a, ok := grid.attribute("missing_value"); ok && len(a.values) > 0 {
+		fill = a.values[0]
+	}
+	r.config.Scale, r.config.Offset = scale, offset
+	r.config.readScale, r.config.readOffset = scale, offset
+	r.header.nodata = fill*scale + offset
+	r.config.NoDataValue = r.header.nodata
+
+	// the first slice of the variable, which is its first record if it
+	// is a record variable
+	if !r.headerOnly {
+		raw := make([]byte, r.header.numCells*size)
+		if _, err = f.ReadAt(raw, grid.begin); err != nil {
+			return FileReadingError
+		}
+		r.data = newCellBuffer(r.header.numCells)
+		for i := range r.data {
+			v := ncValue(raw[i*size:], grid.dataType)
+			if v == fill || math.IsNaN(v) {
+				r.data[i] = r.header.nodata
+			} else {
+				r.data[i] = v*scale + offset
+			}
+		}
+	}
+
+	// the cell centres, from the coordinate variables
+	coordinates := func(dim ncDimension, length int) ([]float64, *ncVariable) {
+		for i := range vars {
+			v := &vars[i]
+			if v.name == dim.name && len(v.dimIDs) == 1 && v.dataType != nc_char &&
+				dims[v.dimIDs[0]].name == dim.name {
+				b := make([]byte, length*ncTypeSizes[v.dataType])
+				if _, err := f.ReadAt(b, v.begin); err != nil {
+					return nil, nil
+				}
+				values := make([]float64, length)
+				for j := range values {
+					values[j] = ncValue(b[j*ncTypeSizes[v.dataType]:], v.dataType)
+				}
+				return values, v
+			}
+		}
+		return nil, nil
+	}
+	// without coordinate variables, the cells are one unit in size
+	r.header.west, r.header.east = 0, float64(r.header.columns)
+	r.header.south, r.header.north = 0, float64(r.header.rows)
+	xs, xVar := coordinates(xDim, r.header.columns)
+	if len(xs) > 1 {
+		dx := (xs[len(xs)-1] - xs[0]) / float64(len(xs)-1)
+		r.header.west = xs[0] - dx/2
+		r.header.east = xs[len(xs)-1] + dx/2
+	}
+	ys, _ := coordinates(yDim, r.header.rows)
+	if len(ys) > 1 {
+		// the rows of a grid whose y coordinates increase run from south
+		// to north, which places its 'northern' edge below its southern
+		// edge
+		dy := (ys[len(ys)-1] - ys[0]) / float64(len(ys)-1)
+		r.header.north = ys[0] - dy/2
+		r.header.south = ys[len(ys)-1] + dy/2
+	}
+
+	// the units and coordinate reference system
+	if a, ok := grid.attribute("units"); ok && a.text != "" {
+		r.config.ZUnits = a.text
+	}
+	if xVar != nil {
+		if a, ok := xVar.attribute("units"); ok && a.text != "" {
+			r.config.XYUnits = a.text
+			if strings.HasPrefix(a.text, "degree") {
+				r.config.EPSGCode = 4326
+			}
+		}
+	}
+	if a, ok := grid.attribute("grid_mapping"); ok {
+		for i := range vars {
+			if vars[i].name != a.text {
+				continue
+			}
+			for _, name := range []string{"crs_wkt", "spatial_ref"} {
+				if wkt, ok := vars[i].attribute(name); ok && wkt.text != "" {
+					r.config.CoordinateRefSystemWKT = wkt.text
+					r.config.EPSGCode = EPSGCodeFromWKT(wkt.text)
+					break
+				}
+			}
+		}
+	}
+
+	r.metadata = append(r.metadata, fmt.Sprintf("NetCDF variable: %s", grid.name))
+	if a, ok := grid.attribute("long_name"); ok && a.text != "" {
+		r.metadata = append(r.metadata, fmt.Sprintf("Long name: %s", a.text))
+	}
+	if a, ok := ncFindAttribute(globalAttributes, "title"); ok && a.text != "" {
+		r.metadata = append(r.metadata, fmt.Sprintf("Title: %s", a.text))
+	}
+
+	return nil
+}
+
+// ncValue returns the big-endian value of a data type at the start of b.
+func ncValue(b []byte, dataType int) float64 {
+	switch dataType {
+	case nc_byte:
+		return float64(int8(b[0]))
+	case nc_ubyte, nc_char:
+		return float64(b[0])
+	case nc_short:
+		return float64(int16(binary.BigEndian.Uint16(b)))
+	case nc_ushort:
+		return float64(binary.BigEndian.Uint16(b))
+	case nc_int:
+		return float64(int32(binary.BigEndian.Uint32(b)))
+	case nc_uint:
+		return float64(binary.BigEndian.Uint32(b))
+	case nc_int64:
+		return float64(int64(binary.BigEndian.Uint64(b)))
+	case nc_uint64:
+		return float64(binary.BigEndian.Uint64(b))
+	case nc_float:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case nc_double:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// ncHeaderReader reads the header of a classic NetCDF file, in which sizes
+// are 32-bit, except in CDF-5 files, and offsets are 32-bit in CDF-1 files.
+// The first error is recorded and stops further reading.
+type ncHeaderReader struct {
+	r       *bufio.Reader
+	version int
+	err     error
+}
+
+func (h *ncHeaderReader) bytes(n int64) []byte {
+	if h.err != nil {
+		return nil
+	}
+	if n < 0 || n > 1<<24 {
+		h.err = FileIsNotProperlyFormated
+		return nil
+	}
+	b := make([]byte, n)
+	_, h.err = io.ReadFull(h.r, b)
+	return b
+}
+
+func (h *ncHeaderReader) int32() int32 {
+	if b := h.bytes(4); h.err == nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// size reads a count or length, e.g. of a list.
+func (h *ncHeaderReader) size() int64 {
+	if h.version == 5 {
+		if b := h.bytes(8); h.err == nil {
+			return int64(binary.BigEndian.Uint64(b))
+		}
+		return 0
+	}
+	return int64(h.int32())
+}
+
+func (h *ncHeaderReader) offset() int64 {
+	if h.version == 1 {
+		return int64(h.int32())
+	}
+	if b := h.bytes(8); h.err == nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// listLength reads the tag and length of a list, which is absent if both are
+// zero.
+func (h *ncHeaderReader) listLength(tag int32) int {
+	t := h.int32()
+	n := h.size()
+	if h.err == nil && (n < 0 || (t != tag && !(t == 0 && n == 0))) {
+		h.err = FileIsNotProperlyFormated
+	}
+	return int(n)
+}
+
+// padded reads n bytes, which are padded to a multiple of four bytes.
+func (h *ncHeaderReader) padded(n int64) []byte {
+	b := h.bytes(n)
+	h.bytes((4 - n%4) % 4)
+	return b
+}
+
+func (h *ncHeaderReader) name() string {
+	return string(h.padded(h.size()))
+}
+
+func (h *ncHeaderReader) attributes() []ncAttribute {
+	var ret []ncAttribute
+	for i, n := 0, h.listLength(0x0C); i < n && h.err == nil; i++ {
+		a := ncAttribute{name: h.name()}
+		dataType := int(h.int32())
+		count := h.size()
+		if h.err != nil || dataType < nc_byte || dataType > nc_uint64 {
+			h.err = FileIsNotProperlyFormated
+			break
+		}
+		b := h.padded(count * int64(ncTypeSizes[dataType]))
+		if dataType == nc_char {
+			a.text = strings.TrimRight(string(b), "\x00")
+		} else {
+			for j := 0; j < len(b); j += ncTypeSizes[dataType] {
+				a.values = append(a.values, ncValue(b[j:], dataType))
+			}
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
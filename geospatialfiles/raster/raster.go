@@ -15,9 +15,12 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 
 	"path/filepath"
 	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
 )
 
 type rasterData interface {
@@ -63,6 +66,8 @@ type Raster struct {
 	ByteOrder                binary.ByteOrder
 	rd                       rasterData
 	reflectAtBoundaries      bool
+	headerOnly               bool
+	page                     int // of a multi-page file that is read
 }
 
 type RasterConfig struct {
@@ -84,6 +89,62 @@ type RasterConfig struct {
 	ReflectAtBoundaries       bool
 	PixelIsArea               bool
 	EPSGCode                  int
+	// DisplayClipPercent, when greater than zero and the display range has
+	// not been set, causes the display range to be set at save time so that
+	// this percentage of the cells lies beyond each end of it, e.g. 2 for a
+	// 2-98% clip. DisplayClipSampleSize limits the number of cells used to
+	// estimate the range (0 uses all cells).
+	DisplayClipPercent    float64
+	DisplayClipSampleSize int
+	// SouthUp is true for a file whose rows run from south to north. Rasters
+	// are always north-up in memory, i.e. row 0 is the northernmost, so the
+	// rows of a south-up file are reversed when it is read and again when it
+	// is saved. A file is detected as south-up when its header places its
+	// northern edge below its southern edge, e.g. a GeoTIFF with a negative y
+	// pixel scale or an ASCII grid with a negative DY; since most formats
+	// can't otherwise record the row order, SouthUp can also be set in the
+	// config passed to CreateRasterFromFile, and in that of a new raster that
+	// is to be written south-up.
+	SouthUp bool
+	// HeaderOnly, when set in the config passed to CreateRasterFromFile,
+	// causes only the header of the file to be read, i.e. its dimensions,
+	// georeferencing, CRS and metadata, and not its data, so that even a
+	// very large file is opened instantly. The raster's values can't then
+	// be read, nor the raster saved; Data and Save return a HeaderOnlyError.
+	HeaderOnly bool
+	// Page, when set in the config passed to CreateRasterFromFile, is the
+	// page, or image, of a multi-page GeoTIFF that is read, from 0 for the
+	// first. A raster read from such a file can't be saved over it, since the
+	// other pages would be lost.
+	Page int
+	// AppendPage, when set in the config of a new GeoTIFF raster, causes it
+	// to be saved as a new page after those of its existing file, rather than
+	// replacing the file, e.g. to keep the related outputs of a tool, such as
+	// the magnitude and scale of MaxElevationDeviation, in one file. The file
+	// is created if it doesn't exist. Other formats ignore it.
+	AppendPage bool
+	// Scale and Offset are the packing of the values of a file that stores
+	// them, e.g., as whole centimetres in an integer type, which are unpacked
+	// as stored value * Scale + Offset. A raster read from a packed GeoTIFF
+	// (from its GDAL_METADATA tag) or NetCDF file has its values, and its
+	// nodata value, unpacked and a DataType of DT_FLOAT32, and a GeoTIFF
+	// raster with a Scale other than 1 or an Offset other than 0 is packed
+	// again when saved, in the sample format of its file. A Scale of 0 is
+	// taken to be 1. A GeoTIFF's nodata value is held per page, so each page
+	// of a multi-page file may have its own. A raster created with a config
+	// copied from a raster that was read, whose Scale and Offset are still
+	// those of the file, isn't packed, since its values are derived ones
+	// that the packing may not fit; a Scale or Offset must be set
+	// explicitly for a new raster to be packed.
+	Scale  float64
+	Offset float64
+	// the Scale and Offset read from the file, if any
+	readScale, readOffset float64
+}
+
+// packed reports whether the Scale and Offset of the config pack values.
+func (h *RasterConfig) packed() bool {
+	return h.Scale != 0 && (h.Scale != 1 || h.Offset != 0)
 }
 
 func (h RasterConfig) String() string {
@@ -93,6 +154,10 @@ func (h RasterConfig) String() string {
 	typeOfT := s.Type()
 	for i := 0; i < s.NumField(); i++ {
 		f := s.Field(i)
+		if !f.CanInterface() {
+			// unexported bookkeeping
+			continue
+		}
 		str := fmt.Sprintf("%s %s = %v\n", typeOfT.Field(i).Name, f.Type(), f.Interface())
 		buffer.WriteString(str)
 	}
@@ -116,6 +181,7 @@ func NewDefaultRasterConfig() *RasterConfig {
 	rc.PixelIsArea = true
 	rc.PhotometricInterpretation = -1
 	rc.DataType = -1
+	rc.Scale = 1.0
 	rc.MetadataEntries = make([]string, 1)
 	return &rc
 }
@@ -153,12 +219,26 @@ func CreateNewRaster(fileName string, rows int, columns int, north float64,
 		// specified, only the last is used.
 		myConfig = config[len(config)-1]
 	}
+	if myConfig.packed() && myConfig.Scale == myConfig.readScale && myConfig.Offset == myConfig.readOffset {
+		// the packing was inherited from an input rather than asked for; the
+		// caller's config, which may be the input's own, is left as it is
+		c := *myConfig
+		c.Scale, c.Offset = 1, 0
+		c.readScale, c.readOffset = 0, 0
+		myConfig = &c
+	}
 	var r Raster
 	var rasterType RasterType
 	if myConfig.RasterFormat != RT_UnknownRaster {
 		rasterType = myConfig.RasterFormat
 	} else {
-		rasterType, err = DetermineRasterFormat(fileName)
+		rasterType, err = RasterFormatFromExtension(fileName)
+		if err != nil {
+			// an existing file that is overwritten keeps its format
+			if rt, err2 := DetermineRasterFormat(fileName); err2 == nil && rt != RT_UnknownRaster {
+				rasterType, err = rt, nil
+			}
+		}
 		if err == UnsupportedRasterFormatError {
 			return &r, err
 		}
@@ -186,16 +266,25 @@ func CreateNewRaster(fileName string, rows int, columns int, north float64,
 	case RT_IdrisiRaster:
 		myRasterData = new(idrisiRaster)
 
+	case RT_ErdasImagineRaster:
+		myRasterData = new(erdasImagineRaster)
+
+	case RT_NetCDFRaster:
+		myRasterData = new(netCDFRaster)
+
 	}
 
 	r.reflectAtBoundaries = myConfig.ReflectAtBoundaries
 
 	err = myRasterData.InitializeRaster(fileName, rows, columns, north, south, east, west, myConfig)
-	if err != nil {
+	if err == ReadOnlyRasterFormatError {
+		return &r, err
+	} else if err != nil {
 		return &r, RasterInitializationError
 	}
 	r.rd = myRasterData
 	setVariablesFromRasterData(&r, r.rd)
+	beginOutput(r.RasterFormat, r.rd.FileName())
 
 	return &r, nil
 }
@@ -217,19 +306,20 @@ func CreateRasterFromFile(fileName string, config ...RasterConfig) (*Raster, err
 		// is possible to specify no config. If more than one config is
 		// specified, only the last is used.
 		rt = config[len(config)-1].RasterFormat
-		if rt == RT_UnknownRaster {
-			rt, err = DetermineRasterFormat(fileName)
-			if err != nil || rt == RT_UnknownRaster {
-				return &r, err
-			}
-		}
-	} else {
-		rt, err = DetermineRasterFormat(fileName)
-		if err != nil || rt == RT_UnknownRaster {
+	}
+	if rt == RT_UnknownRaster {
+		rt, err = inputRasterFormat(fileName)
+		if err != nil {
 			return &r, err
+		} else if rt == RT_UnknownRaster {
+			return &r, UnsupportedRasterFormatError
 		}
 	}
 	r.RasterFormat = rt
+	r.headerOnly = len(config) > 0 && config[len(config)-1].HeaderOnly
+	if len(config) > 0 {
+		r.page = config[len(config)-1].Page
+	}
 
 	// see if it is a supported raster format
 	//if !IsSupportedRasterFileExtension(fileName) {
@@ -237,11 +327,31 @@ func CreateRasterFromFile(fileName string, config ...RasterConfig) (*Raster, err
 	//}
 
 	r.rd, err = r.getRasterData()
-	r.check(err)
+	if err != nil {
+		return &r, err
+	}
 	if r.rd == nil {
 		return &r, RasterInitializationError
 	}
 
+	// formats without native CRS support may have a .prj sidecar file
+	if usesPrjSidecar(r.RasterFormat) {
+		if err = readPrjFile(r.FileName, r.rd.GetRasterConfig()); err != nil {
+			return &r, err
+		}
+	}
+
+	// the rows of a south-up file are reversed, so that the raster is
+	// north-up in memory
+	if r.rd.North() < r.rd.South() || (len(config) > 0 && config[len(config)-1].SouthUp) {
+		if !r.headerOnly {
+			if err = reverseRows(r.rd); err != nil {
+				return &r, err
+			}
+		}
+		r.rd.GetRasterConfig().SouthUp = true
+	}
+
 	setVariablesFromRasterData(&r, r.rd)
 
 	return &r, nil
@@ -249,40 +359,42 @@ func CreateRasterFromFile(fileName string, config ...RasterConfig) (*Raster, err
 }
 
 func (r *Raster) getRasterData() (rasterData, error) {
-
+	var rd rasterData
 	switch r.RasterFormat {
 	case RT_GeoTiff:
-		myGeoTiff := new(geotiffRaster)
-		myGeoTiff.SetFileName(r.FileName)
-		return myGeoTiff, nil
-
+		gr := new(geotiffRaster)
+		gr.gt.Page = r.page
+		rd = gr
 	case RT_ArcGisBinaryRaster:
-		myArcRaster := new(arcGisBinaryRaster)
-		myArcRaster.SetFileName(r.FileName)
-		return myArcRaster, nil
-
+		rd = new(arcGisBinaryRaster)
 	case RT_ArcGisAsciiRaster:
-		myArcRaster := new(arcGisASCIIRaster)
-		myArcRaster.SetFileName(r.FileName)
-		return myArcRaster, nil
-
+		rd = new(arcGisASCIIRaster)
 	case RT_WhiteboxRaster:
-		myWhiteboxRaster := new(whiteboxRaster)
-		myWhiteboxRaster.SetFileName(r.FileName)
-		return myWhiteboxRaster, nil
-
+		rd = new(whiteboxRaster)
 	case RT_GrassAsciiRaster:
-		myGrassRaster := new(grassAsciiRaster)
-		myGrassRaster.SetFileName(r.FileName)
-		return myGrassRaster, nil
-
+		rd = new(grassAsciiRaster)
 	case RT_IdrisiRaster:
-		myIdrisiRaster := new(idrisiRaster)
-		myIdrisiRaster.SetFileName(r.FileName)
-		return myIdrisiRaster, nil
+		rd = new(idrisiRaster)
+	case RT_ErdasImagineRaster:
+		rd = new(erdasImagineRaster)
+	case RT_NetCDFRaster:
+		rd = new(netCDFRaster)
+	default:
+		return nil, nil
+	}
+
+	if r.headerOnly {
+		if err := readHeader(rd, r.FileName); err != nil {
+			return nil, err
+		}
+		return rd, nil
 	}
 
-	return nil, nil
+	// this reads the file, checking its data against its header
+	if err := rd.SetFileName(r.FileName); err != nil {
+		return nil, err
+	}
+	return rd, nil
 }
 
 // Retrives an individual pixel value in the grid.
@@ -318,6 +430,40 @@ func (r *Raster) Value(row, column int) float64 {
 	}
 }
 
+// Estimates the value at a map coordinate by bilinear interpolation between
+// the centres of the four surrounding cells. Nodata neighbours are excluded
+// and the weights of the remaining cells renormalized, so that values near
+// nodata areas and the edges of the grid are still estimated. Nodata is
+// returned for coordinates outside of the grid or within a nodata cell.
+func (r *Raster) SampleBilinear(x, y float64) float64 {
+	nodata := r.rd.NoData()
+	col := (x-r.West)/r.GetCellSizeX() - 0.5
+	row := (r.North-y)/r.GetCellSizeY() - 0.5
+	if row < -0.5 || col < -0.5 || row > float64(r.Rows)-0.5 || col > float64(r.Columns)-0.5 {
+		return nodata
+	}
+	// a point on the east or south edge is within the last column or row
+	nearestRow := int(math.Min(math.Floor(row+0.5), float64(r.Rows-1)))
+	nearestCol := int(math.Min(math.Floor(col+0.5), float64(r.Columns-1)))
+	if r.Value(nearestRow, nearestCol) == nodata {
+		return nodata
+	}
+	r0 := int(math.Floor(row))
+	c0 := int(math.Floor(col))
+	dr := row - float64(r0)
+	dc := col - float64(c0)
+	weights := [4]float64{(1 - dr) * (1 - dc), (1 - dr) * dc, dr * (1 - dc), dr * dc}
+	values := [4]float64{r.Value(r0, c0), r.Value(r0, c0+1), r.Value(r0+1, c0), r.Value(r0+1, c0+1)}
+	var sum, sumWeights float64
+	for i, z := range values {
+		if z != nodata && weights[i] > 0 {
+			sum += weights[i] * z
+			sumWeights += weights[i]
+		}
+	}
+	return sum / sumWeights
+}
+
 // Sets an individual pixel value in the grid.
 func (r *Raster) SetValue(row, column int, value float64) {
 	if column >= 0 && column < r.Columns && row >= 0 && row < r.Rows {
@@ -327,6 +473,17 @@ func (r *Raster) SetValue(row, column int, value float64) {
 	}
 }
 
+// SetValueChecked sets an individual pixel value in the grid, like SetValue,
+// but returns a CellOutOfBoundsError for a cell outside of the grid rather
+// than ignoring it.
+func (r *Raster) SetValueChecked(row, column int, value float64) error {
+	if column < 0 || column >= r.Columns || row < 0 || row >= r.Rows {
+		return &CellOutOfBoundsError{row, column, r.Rows, r.Columns}
+	}
+	r.rd.SetValue(row*r.Columns+column, value)
+	return nil
+}
+
 // Sets an a row of pixel value in the grid.
 func (r *Raster) SetRowValues(row int, values []float64) {
 	// does values have the length of columns?
@@ -341,6 +498,9 @@ func (r *Raster) SetRowValues(row int, values []float64) {
 
 // Returns the data as a slice of float64 values
 func (r *Raster) Data() ([]float64, error) {
+	if r.headerOnly {
+		return nil, HeaderOnlyError
+	}
 	return r.rd.Data()
 }
 
@@ -349,8 +509,138 @@ func (r *Raster) SetData(values []float64) {
 	r.rd.SetData(values)
 }
 
+// DeterministicOutput causes volatile metadata entries, i.e. creation and
+// elapsed times, to be dropped when rasters are saved, and a fixed date to be
+// written in the attribute tables of shapefiles, so that repeated runs of a
+// tool produce byte-for-byte identical files.
+var DeterministicOutput = false
+
+// DefaultExtension is added by the tools to output file names that lack a
+// supported raster extension, and so sets the default output format.
+var DefaultExtension = ".tif"
+
+// InputFormat, if set, is the format of the raster files that are read,
+// overriding the format determined from their contents and extensions, e.g.
+// for a GeoTIFF with a misleading name. It doesn't apply to a raster whose
+// RasterConfig specifies its format.
+var InputFormat = RT_UnknownRaster
+
+// inputRasterFormat returns the format of a raster file that is to be read.
+func inputRasterFormat(fileName string) (RasterType, error) {
+	if InputFormat != RT_UnknownRaster {
+		return InputFormat, nil
+	}
+	return DetermineRasterFormat(fileName)
+}
+
+// CompressOutput causes GeoTIFF rasters to be saved with deflate compression.
+// The other formats are always saved uncompressed.
+var CompressOutput = false
+
+// RowsPerStrip is the number of rows in each strip of the GeoTIFF rasters that
+// are saved. If it is zero, strips of about 8 KB are written.
+var RowsPerStrip = 0
+
+// volatileMetadataPrefixes identifies the metadata entries that vary between
+// otherwise identical runs.
+var volatileMetadataPrefixes = []string{"Created on", "Elapsed Time"}
+
 func (r *Raster) Save() (err error) {
-	return r.rd.Save()
+	if _, _, ok := zipMember(r.FileName); ok {
+		return ReadOnlyArchiveError
+	}
+	if r.headerOnly {
+		return HeaderOnlyError
+	}
+	beginOutput(r.RasterFormat, r.rd.FileName())
+	if config := r.rd.GetRasterConfig(); config.DisplayClipPercent > 0 &&
+		config.DisplayMinimum == math.MaxFloat64 && config.DisplayMaximum == -math.MaxFloat64 &&
+		(r.RasterFormat == RT_WhiteboxRaster || r.RasterFormat == RT_IdrisiRaster || r.RasterFormat == RT_GeoTiff) {
+		// only formats that store a display range, GeoTIFFs in a sidecar,
+		// need it to be calculated
+		if min, max, ok := r.PercentClipRange(config.DisplayClipPercent, config.DisplayClipSampleSize); ok {
+			config.DisplayMinimum = min
+			config.DisplayMaximum = max
+		}
+	}
+	if DeterministicOutput {
+		config := r.rd.GetRasterConfig()
+		entries := make([]string, 0, len(config.MetadataEntries))
+		for _, value := range config.MetadataEntries {
+			if !isVolatileMetadata(value) {
+				entries = append(entries, value)
+			}
+		}
+		config.MetadataEntries = entries
+	}
+	if r.rd.GetRasterConfig().SouthUp {
+		// the file is written in its own row order, and the raster is left
+		// north-up
+		if err = reverseRows(r.rd); err != nil {
+			return err
+		}
+		err = r.rd.Save()
+		if flipErr := reverseRows(r.rd); err == nil {
+			err = flipErr
+		}
+	} else {
+		err = r.rd.Save()
+	}
+	if err != nil {
+		return err
+	}
+	if usesPrjSidecar(r.RasterFormat) {
+		if err = WritePrjFile(r.rd.FileName(), r.rd.GetRasterConfig()); err != nil {
+			return err
+		}
+	}
+	endOutput(r.rd.FileName())
+	return nil
+}
+
+// PercentClipRange returns the values between which all but percent per cent
+// of the valid cells at each end of the distribution lie. If sampleSize is
+// greater than zero, the range is estimated from a regular subgrid of about
+// that many cells, which is much faster for large rasters. ok is false if the
+// raster contains too few valid values for a range to be found.
+func (r *Raster) PercentClipRange(percent float64, sampleSize int) (min, max float64, ok bool) {
+	step := 1
+	if sampleSize > 0 && r.Rows*r.Columns > sampleSize {
+		step = int(math.Ceil(math.Sqrt(float64(r.Rows*r.Columns) / float64(sampleSize))))
+	}
+	nodata := r.NoDataValue
+	values := make([]float64, 0)
+	for row := step / 2; row < r.Rows; row += step {
+		for col := step / 2; col < r.Columns; col += step {
+			if z := r.Value(row, col); z != nodata && !math.IsNaN(z) {
+				values = append(values, z)
+			}
+		}
+	}
+	if len(values) < 2 {
+		return 0, 0, false
+	}
+	sort.Float64s(values)
+	n := len(values) - 1
+	i := int(math.Floor(percent / 100.0 * float64(n)))
+	if i > n/2 {
+		i = n / 2
+	}
+	min, max = values[i], values[n-i]
+	if max <= min {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+func isVolatileMetadata(value string) bool {
+	value = strings.TrimSpace(value)
+	for _, prefix := range volatileMetadataPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // Sets the raster config
@@ -421,8 +711,11 @@ func (r *Raster) IsInGeographicCoordinates() bool {
 	*/
 	config := r.GetRasterConfig()
 
-	epsg := config.EPSGCode
-	if epsg == 4322 || epsg == 4326 || epsg == 4629 || epsg == 4277 {
+	if crs, ok := epsg.Lookup(config.EPSGCode); ok {
+		return crs.IsGeographic()
+	}
+	code := config.EPSGCode
+	if code == 4322 || code == 4326 || code == 4629 || code == 4277 {
 		return true
 	}
 	wkt := strings.ToLower(config.CoordinateRefSystemWKT)
@@ -435,12 +728,35 @@ func (r *Raster) IsInGeographicCoordinates() bool {
 	return false
 }
 
+// Returns the EPSG code of the UTM zone containing the centre of the raster.
+func (r *Raster) GetUTMCode() (int, error) {
+	config := r.GetRasterConfig()
+	x := (r.East + r.West) / 2.0
+	y := (r.North + r.South) / 2.0
+	if crs, ok := epsg.Lookup(config.EPSGCode); ok {
+		lon, lat, err := crs.Inverse(x, y)
+		if err != nil {
+			return 0, err
+		}
+		return epsg.UTMCode(crs.GeographicCode, lon, lat)
+	}
+	if r.IsInGeographicCoordinates() {
+		// assume WGS 84 when the datum is unknown
+		return epsg.UTMCode(4326, x, y)
+	}
+	return 0, epsg.UnsupportedProjectionError
+}
+
 // set's the Raster's public variables based on a RasterData
 func setVariablesFromRasterData(r *Raster, rd rasterData) (err error) {
 	r.Columns = rd.Columns()
 	r.Rows = rd.Rows()
 	r.North = rd.North()
 	r.South = rd.South()
+	if r.North < r.South {
+		// the extent of a south-up file, whose rows have been reversed
+		r.North, r.South = r.South, r.North
+	}
 	r.East = rd.East()
 	r.West = rd.West()
 	r.ByteOrder = rd.ByteOrder()
@@ -448,3 +764,24 @@ func setVariablesFromRasterData(r *Raster, rd rasterData) (err error) {
 	r.NumberofCells = r.Rows * r.Columns
 	return nil
 }
+
+// reverseRows reverses the order of the rows of the data of rd.
+func reverseRows(rd rasterData) error {
+	data, err := rd.Data()
+	if err != nil {
+		return err
+	}
+	rows, columns := rd.Rows(), rd.Columns()
+	if len(data) != rows*columns {
+		return DataSetError
+	}
+	for top, bottom := 0, rows-1; top < bottom; top, bottom = top+1, bottom-1 {
+		a := data[top*columns : (top+1)*columns]
+		b := data[bottom*columns : (bottom+1)*columns]
+		for col := range a {
+			a[col], b[col] = b[col], a[col]
+		}
+	}
+	rd.SetData(data)
+	return nil
+}
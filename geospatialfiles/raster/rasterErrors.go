@@ -6,10 +6,16 @@
 
 package raster
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var UnsupportedRasterFormatError = errors.New("Unsupported raster format.")
 var MultipleRasterFormatError = errors.New("There are multiple possible raster formats for this file.")
+var ReadOnlyRasterFormatError = errors.New("This raster format can be read but not written.")
+var ReadOnlyArchiveError = errors.New("Files within ZIP archives can be read but not written.")
+var CompressedRasterError = errors.New("The file is compressed; it must be decompressed before it can be read, or if it is a ZIP archive, the raster within it named, e.g. tiles.zip/dem.tif.")
 var FileReadingError = errors.New("An error occurred while reading the data file.")
 var FileWritingError = errors.New("An error occurred while writing the data file.")
 var FileOpeningError = errors.New("An error occurred while opening the data file.")
@@ -18,3 +24,76 @@ var FileDeletingError = errors.New("There were problems deleting the file.")
 var FileDoesNotExistError = errors.New("The file does not exist.")
 var DataSetError = errors.New("An error occurred while setting the data.")
 var FileIsNotProperlyFormated = errors.New("The file does not appear to be properly formated")
+var HeaderOnlyError = errors.New("Only the header of the raster was read; its data can't be used or saved.")
+var MultiPageSaveError = errors.New("The raster was read from a multi-page GeoTIFF; it can't be saved over the file without losing the other pages.")
+var EmptyRasterStackError = errors.New("The raster stack does not contain any rasters.")
+var MisalignedRasterStackError = errors.New("The rasters in the stack do not share the same grid.")
+
+// DataSizeError reports a raster whose data doesn't match the dimensions in
+// its header, e.g. a truncated data file or a header with the wrong data type.
+type DataSizeError struct {
+	FileName      string
+	Rows, Columns int
+	Expected      int64  // the amount of data that the header implies
+	Actual        int64  // the amount of data in the file
+	Unit          string // "bytes" or "values"
+}
+
+func (e *DataSizeError) Error() string {
+	return fmt.Sprintf("%s: the header describes %d rows by %d columns, or %d %s of data, but the file holds %d %s.",
+		e.FileName, e.Rows, e.Columns, e.Expected, e.Unit, e.Actual, e.Unit)
+}
+
+// CellOutOfBoundsError reports a cell outside of the grid of a raster.
+type CellOutOfBoundsError struct {
+	Row, Column   int
+	Rows, Columns int
+}
+
+func (e *CellOutOfBoundsError) Error() string {
+	return fmt.Sprintf("The cell at row %d, column %d is outside of the %d by %d grid.",
+		e.Row, e.Column, e.Rows, e.Columns)
+}
+
+// PackingOverflowError reports a value that can't be packed with the scale
+// and offset of a raster, since it would lie outside of the range of the
+// integer type of its file.
+type PackingOverflowError struct {
+	FileName         string
+	Value            float64
+	Scale, Offset    float64
+	Minimum, Maximum float64 // the range of the packed values
+}
+
+func (e *PackingOverflowError) Error() string {
+	return fmt.Sprintf("%s: the value %v can't be packed with a scale of %v and an offset of %v, since the packed values must lie between %v and %v.",
+		e.FileName, e.Value, e.Scale, e.Offset, e.Minimum, e.Maximum)
+}
+
+// maxCells is the largest number of cells that a raster read into memory can
+// have, such that its float64 data can be addressed.
+const maxCells = int(^uint(0)>>1) / 8
+
+// maxLineLength is the longest line that the readers of ASCII formats accept.
+const maxLineLength = 1 << 30
+
+// checkDimensions returns an error if the rows and columns read from the
+// header of a file can't describe a grid.
+func checkDimensions(fileName string, rows, columns int) error {
+	if rows <= 0 || columns <= 0 || columns > maxCells/rows {
+		return fmt.Errorf("%s: the header describes an invalid grid of %d rows by %d columns.", fileName, rows, columns)
+	}
+	return nil
+}
+
+// checkDataSize returns an error if the size of a binary data file doesn't
+// match the rows and columns read from its header.
+func checkDataSize(fileName string, rows, columns, bytesPerCell int, size int64) error {
+	if err := checkDimensions(fileName, rows, columns); err != nil {
+		return err
+	}
+	if expected := int64(rows) * int64(columns) * int64(bytesPerCell); size != expected {
+		return &DataSizeError{fileName, rows, columns, expected, size, "bytes"}
+	}
+	return nil
+}
@@ -9,8 +9,9 @@
 package raster
 
 import (
-	"bufio"
-	"os"
+	"bytes"
+	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
 )
@@ -29,6 +30,8 @@ const (
 	RT_SurferAsciiRaster
 	RT_SagaRaster
 	RT_IdrisiRaster
+	RT_ErdasImagineRaster
+	RT_NetCDFRaster
 )
 
 var rasterTypeList = []string{
@@ -41,6 +44,8 @@ var rasterTypeList = []string{
 	"SurferAsciiRaster",
 	"SagaRaster",
 	"IdrisiRaster",
+	"ErdasImagineRaster",
+	"NetCDFRaster",
 }
 
 // String returns the English name of the RasterType ("ArcGisBinaryRaster", "ArcGisAsciiRaster", ...).
@@ -59,6 +64,8 @@ func init() {
 	rasterExtensionList = append(rasterExtensionList, []string{".grd"})
 	rasterExtensionList = append(rasterExtensionList, []string{".sdat", ".sgrd"})
 	rasterExtensionList = append(rasterExtensionList, []string{".rst", ".rdc"})
+	rasterExtensionList = append(rasterExtensionList, []string{".img"})
+	rasterExtensionList = append(rasterExtensionList, []string{".nc"})
 }
 
 // Returns a list of the file extensions associated with a particular raster format.
@@ -82,13 +89,35 @@ func IsSupportedRasterFileExtension(fileName string) (ret bool) {
 	return
 }
 
-// Attempts to determine the raster format from the filename.
+// Attempts to determine the raster format of a file. The contents of an
+// existing file are examined first, so that GeoTIFFs and ASCII grids are
+// recognized whatever their names, and the format is otherwise determined
+// from the file extension.
 func DetermineRasterFormat(fileName string) (rt RasterType, err error) {
+	if FileExists(fileName) && !isRawDataExtension(fileName) {
+		rt, err = sniffRasterFormat(fileName)
+		if err != nil || rt != RT_UnknownRaster {
+			return rt, err
+		}
+	}
+	return RasterFormatFromExtension(fileName)
+}
+
+// RasterFormatFromExtension determines the raster format from the file
+// extension alone, as is needed for files that don't exist yet.
+func RasterFormatFromExtension(fileName string) (rt RasterType, err error) {
 	rt = RT_UnknownRaster
 
 	// get a list of each of the raster formats that have
 	// the same file extension as the filename.
 	fileExtension := strings.ToLower(filepath.Ext(fileName))
+	if fileExtension == ".gz" {
+		// only the ASCII grids are read and written gzipped
+		fileExtension = strings.ToLower(filepath.Ext(strings.TrimSuffix(fileName, filepath.Ext(fileName))))
+		if fileExtension != ".asc" && fileExtension != ".txt" {
+			return rt, UnsupportedRasterFormatError
+		}
+	}
 	list := make([]RasterType, 0)
 	for i, extensions := range rasterExtensionList {
 		for _, ext := range extensions {
@@ -105,57 +134,103 @@ func DetermineRasterFormat(fileName string) (rt RasterType, err error) {
 	} else if numPossibleFormats == 1 {
 		// there is only one unique format it could be
 		return list[0], nil
-	} else {
-		// conflict resolution
-
-		// first see if it's an existing file
-		if _, err := os.Stat(fileName); err == nil {
-			if fileExtension == ".txt" {
-				// read in the first six lines of the file
-				contents := ""
-				f, err := os.Open(fileName)
-				if err != nil {
-					return rt, FileOpeningError
-				}
-				defer f.Close()
-
-				scanner := bufio.NewScanner(f)
-				j := 0
-				for scanner.Scan() {
-					contents += strings.ToLower(scanner.Text()) + "\n"
-					j++
-					if j == 6 {
-						break
-					}
-				}
-
-				if strings.Contains(contents, "ncols") &&
-					strings.Contains(contents, "nrows") &&
-					strings.Contains(contents, "xll") &&
-					strings.Contains(contents, "yll") &&
-					strings.Contains(contents, "yll") {
-					// it's an ArcGIS ASCII raster
-					rt = RasterType(2)
-					return rt, nil
-				} else if strings.Contains(contents, "north") &&
-					strings.Contains(contents, "south") &&
-					strings.Contains(contents, "east") &&
-					strings.Contains(contents, "west") &&
-					strings.Contains(contents, "rows") &&
-					strings.Contains(contents, "cols") {
-					// it's a GRASS ASCII raster
-					rt = RasterType(5)
-					return rt, nil
-				}
+	}
+	// There is no way to tell what the format should be uniquely. Just
+	// return the first entry of list along with a warning that there are
+	// multiple possible formats
+	return list[0], MultipleRasterFormatError
+}
+
+// isRawDataExtension reports whether a file name has the extension of the
+// data file of a format with a separate header, whose contents can't be
+// recognized.
+func isRawDataExtension(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".flt", ".tas", ".rst", ".sdat":
+		return true
+	}
+	return false
+}
+
+// sniffRasterFormat recognizes the single-file raster formats from the first
+// bytes of a file: the TIFF byte-order mark, the Erdas Imagine header tag,
+// the NetCDF magic number and the header keywords of the ArcGIS and GRASS
+// ASCII grids. RT_UnknownRaster is returned for other files, including the
+// headers of formats with separate data files, which are left to their
+// extensions. Gzipped ASCII grids are recognized, since they are read
+// transparently; other compressed files are reported with a
+// CompressedRasterError.
+func sniffRasterFormat(fileName string) (RasterType, error) {
+	rd, gzipped, err := openDecompressed(fileName)
+	if gzipped && err != nil {
+		return RT_UnknownRaster, CompressedRasterError
+	} else if err != nil {
+		return RT_UnknownRaster, FileOpeningError
+	}
+	defer rd.Close()
+	buf := make([]byte, 1024)
+	n, err := io.ReadFull(rd, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return RT_UnknownRaster, nil
+	}
+	buf = buf[:n]
+
+	// the keywords that begin the header lines, e.g. 'ncols' or 'north:'
+	keywords := make(map[string]bool)
+	if bytes.IndexByte(buf, 0) < 0 {
+		for i, line := range strings.Split(string(buf), "\n") {
+			if i == 10 {
+				break
+			}
+			fields := strings.Fields(strings.ToLower(line))
+			if len(fields) > 0 {
+				keywords[strings.TrimSuffix(fields[0], ":")] = true
 			}
-		} else {
-			// The file does not already exist so there is no way to tell what the
-			// format should be uniquely. Just return the first entry of list along
-			// with a warning that there are multiple possible formats
-			return list[0], MultipleRasterFormatError
 		}
 	}
-	return
+
+	switch {
+	case keywords["ncols"] && keywords["nrows"] && !keywords["byteorder"]:
+		return RT_ArcGisAsciiRaster, nil
+	case keywords["north"] && keywords["south"] && keywords["east"] &&
+		keywords["west"] && keywords["rows"] && keywords["cols"] && !keywords["min"]:
+		// a Whitebox header has the same keywords, following its Min and Max
+		return RT_GrassAsciiRaster, nil
+	case gzipped, bytes.HasPrefix(buf, []byte("PK\x03\x04")):
+		return RT_UnknownRaster, CompressedRasterError
+	case bytes.HasPrefix(buf, []byte("II*\x00")), bytes.HasPrefix(buf, []byte("MM\x00*")),
+		bytes.HasPrefix(buf, []byte("II+\x00")), bytes.HasPrefix(buf, []byte("MM\x00+")):
+		return RT_GeoTiff, nil
+	case bytes.HasPrefix(buf, []byte("EHFA_HEADER_TAG")):
+		return RT_ErdasImagineRaster, nil
+	case bytes.HasPrefix(buf, []byte("CDF\x01")), bytes.HasPrefix(buf, []byte("CDF\x02")),
+		bytes.HasPrefix(buf, []byte("CDF\x05")):
+		return RT_NetCDFRaster, nil
+	}
+	return RT_UnknownRaster, nil
+}
+
+// ParseRasterFormat returns the raster format with a name, e.g. GeoTiff, or
+// a file extension, e.g. tif or .tif. Names are matched without regard to
+// case and a 'Raster' suffix, so that 'whitebox' is RT_WhiteboxRaster.
+func ParseRasterFormat(s string) (RasterType, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	for i, name := range rasterTypeList {
+		name = strings.ToLower(name)
+		if i > 0 && (str == name || str == strings.TrimSuffix(name, "raster")) {
+			return RasterType(i), nil
+		}
+	}
+	if !strings.HasPrefix(str, ".") {
+		str = "." + str
+	}
+	rt, err := RasterFormatFromExtension("file" + str)
+	if err == MultipleRasterFormatError {
+		return rt, fmt.Errorf("the extension '%s' is shared by several raster formats; use a format name", s)
+	} else if err != nil || rt == RT_UnknownRaster {
+		return RT_UnknownRaster, fmt.Errorf("unsupported raster format '%s'", s)
+	}
+	return rt, nil
 }
 
 // String returns the English name of the RasterType ("ArcGisBinaryRaster", "ArcGisAsciiRaster", ...).
@@ -0,0 +1,170 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RasterStack is an ordered list of co-registered rasters, e.g. the epochs
+// of a multi-temporal survey. Each layer has an associated time, which is
+// used for trend analysis.
+type RasterStack struct {
+	Rows, Columns            int
+	North, South, East, West float64
+	layers                   []*Raster
+	times                    []float64
+}
+
+// CreateRasterStackFromFiles reads a list of rasters into a stack. The
+// rasters must share the same grid. If times is nil, the layers are
+// assigned the times 0, 1, 2, ...
+func CreateRasterStackFromFiles(fileNames []string, times []float64) (*RasterStack, error) {
+	if len(fileNames) == 0 {
+		return nil, EmptyRasterStackError
+	}
+	if times != nil && len(times) != len(fileNames) {
+		return nil, DataSetError
+	}
+	s := new(RasterStack)
+	for i, fileName := range fileNames {
+		r, err := CreateRasterFromFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		t := float64(i)
+		if times != nil {
+			t = times[i]
+		}
+		if err = s.Add(r, t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// CreateRasterStackFromListFile reads a stack from a text file listing one
+// raster per line, optionally followed by its time as either a number or a
+// date (YYYY-MM-DD, converted to a decimal year). Relative file names are
+// resolved against the directory of the list file. Blank lines and lines
+// beginning with '#' are ignored.
+func CreateRasterStackFromListFile(fileName string) (*RasterStack, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, FileOpeningError
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(fileName)
+	fileNames := make([]string, 0)
+	times := make([]float64, 0)
+	hasTimes := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line
+		t := math.NaN()
+		if i := strings.LastIndexAny(line, " \t,"); i > 0 {
+			if v, ok := parseStackTime(line[i+1:]); ok {
+				name = strings.TrimRight(strings.TrimSpace(line[:i]), ",")
+				t = v
+			}
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		fileNames = append(fileNames, name)
+		times = append(times, t)
+		if math.IsNaN(t) {
+			hasTimes = false
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, FileReadingError
+	}
+	if !hasTimes {
+		times = nil
+	}
+	return CreateRasterStackFromFiles(fileNames, times)
+}
+
+// parses a layer time, either a number or a YYYY-MM-DD date.
+func parseStackTime(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, true
+	}
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		start := time.Date(d.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		end := time.Date(d.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+		return float64(d.Year()) + d.Sub(start).Hours()/end.Sub(start).Hours(), true
+	}
+	return 0, false
+}
+
+// Add appends a raster to the end of the stack. The first raster sets the
+// grid of the stack and subsequent rasters must match it to within a small
+// fraction of a grid cell.
+func (s *RasterStack) Add(r *Raster, t float64) error {
+	if len(s.layers) == 0 {
+		s.Rows = r.Rows
+		s.Columns = r.Columns
+		s.North = r.North
+		s.South = r.South
+		s.East = r.East
+		s.West = r.West
+	} else {
+		tolX := 0.01 * (s.East - s.West) / float64(s.Columns)
+		tolY := 0.01 * (s.North - s.South) / float64(s.Rows)
+		if r.Rows != s.Rows || r.Columns != s.Columns ||
+			math.Abs(r.North-s.North) > tolY || math.Abs(r.South-s.South) > tolY ||
+			math.Abs(r.East-s.East) > tolX || math.Abs(r.West-s.West) > tolX {
+			return MisalignedRasterStackError
+		}
+	}
+	s.layers = append(s.layers, r)
+	s.times = append(s.times, t)
+	return nil
+}
+
+// Len returns the number of layers in the stack.
+func (s *RasterStack) Len() int {
+	return len(s.layers)
+}
+
+// Layer returns the i'th raster of the stack.
+func (s *RasterStack) Layer(i int) *Raster {
+	return s.layers[i]
+}
+
+// Time returns the time of the i'th layer of the stack.
+func (s *RasterStack) Time(i int) float64 {
+	return s.times[i]
+}
+
+// CellSeries returns the times and values of the layers that are not
+// nodata at a grid cell. The slices t and z are reused if they have
+// sufficient capacity.
+func (s *RasterStack) CellSeries(row, column int, t, z []float64) ([]float64, []float64) {
+	t = t[:0]
+	z = z[:0]
+	for i, r := range s.layers {
+		value := r.Value(row, column)
+		if value != r.NoDataValue {
+			t = append(t, s.times[i])
+			z = append(z, value)
+		}
+	}
+	return t, z
+}
@@ -0,0 +1,426 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package raster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// BlockReader reads the data of a raster file a band of rows at a time,
+// rather than holding the whole grid in memory, so that rasters larger than
+// RAM can be processed by algorithms that only need a window of rows at once,
+// e.g. filters and per-cell operations. Algorithms that need random access to
+// the whole grid, such as depression breaching and flow accumulation, can
+// read it into a more compact grid than a Raster, as FD8FlowAccum does. A
+// BlockReader is returned by OpenStreaming.
+type BlockReader struct {
+	Rows, Columns            int
+	North, South, East, West float64
+	NoDataValue              float64
+	FileName                 string
+	RasterFormat             RasterType
+	rd                       rasterData // the header, without the data
+
+	// the raw binary formats
+	file         *os.File
+	dataType     int
+	bytesPerCell int
+	byteOrder    binary.ByteOrder
+	buf          []byte
+
+	// GeoTIFFs, which are decoded a strip, or row of tiles, at a time
+	gt         *geotiffRaster
+	blockRows  int
+	block      []float64
+	blockIndex int // the index of the decoded block, or -1
+}
+
+// OpenStreaming opens a raster file for reading with a BlockReader. Only the
+// header is read. The Whitebox, Idrisi, ArcGIS binary and GeoTIFF formats are
+// supported; the ASCII formats, which can't be read from an arbitrary row,
+// are not. The BlockReader must be closed.
+func OpenStreaming(fileName string) (*BlockReader, error) {
+	rt, err := inputRasterFormat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	br := &BlockReader{FileName: fileName, RasterFormat: rt, blockIndex: -1}
+	var dataFile string
+	switch rt {
+	case RT_WhiteboxRaster:
+		r := &whiteboxRaster{config: NewDefaultRasterConfig()}
+		if err = r.setFileNames(fileName); err != nil {
+			return nil, err
+		}
+		if err = r.readHeaderFile(); err != nil {
+			return nil, FileReadingError
+		}
+		r.config.RasterFormat = rt
+		br.rd, dataFile, br.dataType = r, r.dataFile, r.config.DataType
+	case RT_IdrisiRaster:
+		r := &idrisiRaster{config: NewDefaultRasterConfig()}
+		if err = r.setFileNames(fileName); err != nil {
+			return nil, err
+		}
+		if err = r.readHeaderFile(); err != nil {
+			return nil, FileReadingError
+		}
+		r.config.RasterFormat = rt
+		br.rd, dataFile, br.dataType = r, r.dataFile, r.config.DataType
+	case RT_ArcGisBinaryRaster:
+		r := &arcGisBinaryRaster{config: NewDefaultRasterConfig()}
+		if err = r.setFileNames(fileName); err != nil {
+			return nil, err
+		}
+		if err = r.header.readHeaderFile(); err != nil {
+			return nil, FileReadingError
+		}
+		r.config.RasterFormat = rt
+		if err = readPrjFile(fileName, r.config); err != nil {
+			return nil, err
+		}
+		br.rd, dataFile, br.dataType = r, r.dataFile, DT_FLOAT32
+	case RT_GeoTiff:
+		r := &geotiffRaster{fileName: fileName, config: NewDefaultRasterConfig()}
+		if err = r.gt.ReadHeader(fileName); err != nil {
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		r.readTags()
+		r.config.RasterFormat = rt
+		if err = readDisplaySidecar(fileName, r.config); err != nil {
+			r.gt.Close()
+			return nil, err
+		}
+		if br.blockRows, err = r.gt.BlockRows(); err != nil {
+			r.gt.Close()
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		br.rd, br.gt = r, r
+	default:
+		return nil, fmt.Errorf("%s: the %s format can't be read by rows.", fileName, rt)
+	}
+
+	br.Rows = br.rd.Rows()
+	br.Columns = br.rd.Columns()
+	br.North = br.rd.North()
+	br.South = br.rd.South()
+	br.East = br.rd.East()
+	br.West = br.rd.West()
+	br.NoDataValue = br.rd.NoData()
+	if err = checkDimensions(fileName, br.Rows, br.Columns); err == nil && br.North < br.South {
+		// the rows would have to be read from the end of the file
+		err = fmt.Errorf("%s: the rows of a south-up raster can't be read in order.", fileName)
+	}
+	if err != nil {
+		if br.gt != nil {
+			br.gt.gt.Close()
+		}
+		return nil, err
+	}
+	if br.gt != nil {
+		return br, nil
+	}
+
+	if br.bytesPerCell = bytesPerCell(br.dataType); br.bytesPerCell == 0 {
+		return nil, FileReadingError
+	}
+	br.byteOrder = br.rd.ByteOrder()
+	if br.file, err = os.Open(dataFile); err != nil {
+		return nil, err
+	}
+	info, err := br.file.Stat()
+	if err == nil {
+		err = checkDataSize(dataFile, br.Rows, br.Columns, br.bytesPerCell, info.Size())
+	}
+	if err != nil {
+		br.file.Close()
+		return nil, err
+	}
+	return br, nil
+}
+
+// GetRasterConfig returns the config read from the header of the raster, e.g.
+// for creating an output of the same format and CRS.
+func (br *BlockReader) GetRasterConfig() *RasterConfig {
+	return br.rd.GetRasterConfig()
+}
+
+// BlockRows returns the number of rows in which the data are stored, and so
+// most efficiently read: those of a GeoTIFF strip or row of tiles, or one row
+// for the other formats.
+func (br *BlockReader) BlockRows() int {
+	if br.gt != nil {
+		return br.blockRows
+	}
+	return 1
+}
+
+// ReadRows reads the rows from row into dst, which holds a whole number of
+// rows in row-major order, and returns the number of rows read, which is
+// fewer than dst holds at the bottom of the grid.
+func (br *BlockReader) ReadRows(row int, dst []float64) (int, error) {
+	if row < 0 || row >= br.Rows {
+		return 0, &CellOutOfBoundsError{row, 0, br.Rows, br.Columns}
+	}
+	n := len(dst) / br.Columns
+	if n > br.Rows-row {
+		n = br.Rows - row
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("A buffer of %d values can't hold a row of %d columns.", len(dst), br.Columns)
+	}
+	if br.gt != nil {
+		for i := 0; i < n; i++ {
+			j := (row + i) / br.blockRows
+			if j != br.blockIndex {
+				if br.block == nil {
+					br.block = make([]float64, br.blockRows*br.Columns)
+				}
+				if err := br.gt.gt.ReadBlockRow(j, br.block); err != nil {
+					br.blockIndex = -1
+					return i, fmt.Errorf("%s: %v", br.FileName, err)
+				}
+				br.gt.unpack(br.block)
+				br.blockIndex = j
+			}
+			k := (row + i - j*br.blockRows) * br.Columns
+			copy(dst[i*br.Columns:(i+1)*br.Columns], br.block[k:k+br.Columns])
+		}
+		return n, nil
+	}
+
+	size := n * br.Columns * br.bytesPerCell
+	if cap(br.buf) < size {
+		br.buf = make([]byte, size)
+	}
+	b := br.buf[:size]
+	if _, err := br.file.ReadAt(b, int64(row)*int64(br.Columns)*int64(br.bytesPerCell)); err != nil && err != io.EOF {
+		return 0, err
+	}
+	decodeCells(b, br.byteOrder, br.dataType, dst[:n*br.Columns])
+	return n, nil
+}
+
+// Close closes the file.
+func (br *BlockReader) Close() error {
+	if br.gt != nil {
+		return br.gt.gt.Close()
+	}
+	return br.file.Close()
+}
+
+// BlockWriter writes a new raster file a band of rows at a time, from the
+// top, without holding the grid in memory. The header is written when it is
+// closed, with the range of the values written. It is returned by
+// CreateStreaming.
+type BlockWriter struct {
+	Rows, Columns int
+	NoDataValue   float64
+	FileName      string
+	RasterFormat  RasterType
+	rd            rasterData
+	file          *os.File
+	w             *bufio.Writer
+	dataType      int
+	bytesPerCell  int
+	byteOrder     binary.ByteOrder
+	buf           []byte
+	row           int // the next row to be written
+	min, max      float64
+}
+
+// CreateStreaming creates a raster file to be written with a BlockWriter.
+// Only the Whitebox and ArcGIS binary formats are supported; the GeoTIFF
+// writer needs the whole grid. The data type of a Whitebox raster is taken
+// from the config, and is DT_FLOAT32 if it's unset or unsupported.
+func CreateStreaming(fileName string, rows, columns int, north, south, east, west float64,
+	config *RasterConfig) (*BlockWriter, error) {
+	rt, err := RasterFormatFromExtension(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkDimensions(fileName, rows, columns); err != nil {
+		return nil, err
+	}
+	bw := &BlockWriter{Rows: rows, Columns: columns, NoDataValue: config.NoDataValue,
+		FileName: fileName, RasterFormat: rt, min: math.MaxFloat64, max: -math.MaxFloat64}
+	var dataFile string
+	switch rt {
+	case RT_WhiteboxRaster:
+		r := new(whiteboxRaster)
+		if bytesPerCell(config.DataType) == 0 || config.DataType == DT_UINT8 {
+			config.DataType = DT_FLOAT32
+		}
+		if err = r.initializeHeader(fileName, rows, columns, north, south, east, west, config); err != nil {
+			return nil, err
+		}
+		bw.rd, dataFile, bw.dataType = r, r.dataFile, config.DataType
+	case RT_ArcGisBinaryRaster:
+		r := new(arcGisBinaryRaster)
+		if err = r.initializeHeader(fileName, rows, columns, north, south, east, west, config); err != nil {
+			return nil, err
+		}
+		bw.rd, dataFile, bw.dataType = r, r.dataFile, DT_FLOAT32
+	default:
+		return nil, fmt.Errorf("%s: the %s format can't be written by rows.", fileName, rt)
+	}
+	if config.ByteOrder == nil {
+		config.ByteOrder = binary.LittleEndian
+		bw.rd.SetByteOrder(config.ByteOrder)
+	}
+	bw.byteOrder = config.ByteOrder
+	bw.bytesPerCell = bytesPerCell(bw.dataType)
+	beginOutput(rt, bw.rd.FileName())
+	if bw.file, err = os.Create(dataFile); err != nil {
+		return nil, err
+	}
+	bw.w = bufio.NewWriter(bw.file)
+	return bw, nil
+}
+
+// WriteRows writes the next rows of the raster from src, which holds a whole
+// number of rows in row-major order.
+func (bw *BlockWriter) WriteRows(src []float64) error {
+	n := len(src) / bw.Columns
+	if n*bw.Columns != len(src) {
+		return fmt.Errorf("%d values are not a whole number of rows of %d columns.", len(src), bw.Columns)
+	}
+	if bw.row+n > bw.Rows {
+		return &CellOutOfBoundsError{bw.row + n - 1, 0, bw.Rows, bw.Columns}
+	}
+	for _, v := range src {
+		if v != bw.NoDataValue {
+			if v < bw.min {
+				bw.min = v
+			}
+			if v > bw.max {
+				bw.max = v
+			}
+		}
+	}
+	size := len(src) * bw.bytesPerCell
+	if cap(bw.buf) < size {
+		bw.buf = make([]byte, size)
+	}
+	b := bw.buf[:size]
+	encodeCells(b, bw.byteOrder, bw.dataType, src)
+	if _, err := bw.w.Write(b); err != nil {
+		return FileWritingError
+	}
+	bw.row += n
+	return nil
+}
+
+// AddMetadataEntry adds a metadata entry to the header, in the formats that
+// hold them.
+func (bw *BlockWriter) AddMetadataEntry(value string) {
+	bw.rd.AddMetadataEntry(value)
+}
+
+// Close writes the header and closes the file. All of the rows must have
+// been written.
+func (bw *BlockWriter) Close() (err error) {
+	if err = bw.w.Flush(); err != nil {
+		bw.file.Close()
+		return FileWritingError
+	}
+	if err = bw.file.Close(); err != nil {
+		return FileWritingError
+	}
+	if bw.row != bw.Rows {
+		return fmt.Errorf("%s: %d of the %d rows were written.", bw.FileName, bw.row, bw.Rows)
+	}
+	switch r := bw.rd.(type) {
+	case *whiteboxRaster:
+		r.minimumValue, r.maximumValue = bw.min, bw.max
+		err = r.writeHeaderFile()
+	case *arcGisBinaryRaster:
+		err = r.header.writeHeaderFile()
+	}
+	if err != nil {
+		return err
+	}
+	if usesPrjSidecar(bw.RasterFormat) {
+		if err = WritePrjFile(bw.rd.FileName(), bw.rd.GetRasterConfig()); err != nil {
+			return err
+		}
+	}
+	endOutput(bw.rd.FileName())
+	return nil
+}
+
+// bytesPerCell returns the size of a cell of the raw binary data types, or
+// zero for the others.
+func bytesPerCell(dataType int) int {
+	switch dataType {
+	case DT_FLOAT64:
+		return 8
+	case DT_FLOAT32:
+		return 4
+	case DT_INT16:
+		return 2
+	case DT_INT8, DT_UINT8:
+		return 1
+	}
+	return 0
+}
+
+// decodeCells decodes the raw binary cell values in b into dst.
+func decodeCells(b []byte, order binary.ByteOrder, dataType int, dst []float64) {
+	switch dataType {
+	case DT_FLOAT64:
+		for i := range dst {
+			dst[i] = math.Float64frombits(order.Uint64(b[8*i:]))
+		}
+	case DT_FLOAT32:
+		for i := range dst {
+			dst[i] = float64(math.Float32frombits(order.Uint32(b[4*i:])))
+		}
+	case DT_INT16:
+		for i := range dst {
+			dst[i] = float64(int16(order.Uint16(b[2*i:])))
+		}
+	case DT_INT8:
+		for i := range dst {
+			dst[i] = float64(int8(b[i]))
+		}
+	case DT_UINT8:
+		for i := range dst {
+			dst[i] = float64(b[i])
+		}
+	}
+}
+
+// encodeCells encodes the cell values in src into b, converting them as the
+// Save methods of the formats do.
+func encodeCells(b []byte, order binary.ByteOrder, dataType int, src []float64) {
+	switch dataType {
+	case DT_FLOAT64:
+		for i, v := range src {
+			order.PutUint64(b[8*i:], math.Float64bits(v))
+		}
+	case DT_FLOAT32:
+		for i, v := range src {
+			order.PutUint32(b[4*i:], math.Float32bits(float32(v)))
+		}
+	case DT_INT16:
+		for i, v := range src {
+			order.PutUint16(b[2*i:], uint16(int16(v)))
+		}
+	case DT_INT8:
+		for i, v := range src {
+			b[i] = byte(int8(v))
+		}
+	case DT_UINT8:
+		for i, v := range src {
+			b[i] = uint8(v)
+		}
+	}
+}
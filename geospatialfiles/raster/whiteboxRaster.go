@@ -14,7 +14,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
@@ -33,6 +32,30 @@ type whiteboxRaster struct {
 }
 
 func (r *whiteboxRaster) InitializeRaster(fileName string,
+	rows int, columns int, north float64, south float64,
+	east float64, west float64, config *RasterConfig) (err error) {
+	if err = r.initializeHeader(fileName, rows, columns, north, south, east, west, config); err != nil {
+		return err
+	}
+
+	// initialize the data array
+	r.data = newCellBuffer(r.header.numCells)
+	if config.InitialValue != 0 {
+		for i := range r.data {
+			r.data[i] = config.InitialValue
+		}
+	}
+
+	r.minimumValue = math.MaxFloat64
+	r.maximumValue = -math.MaxFloat64
+
+	return nil
+}
+
+// initializeHeader sets up the header of a new raster and deletes any existing
+// files of the same name, without allocating the data, which a BlockWriter
+// writes a row at a time.
+func (r *whiteboxRaster) initializeHeader(fileName string,
 	rows int, columns int, north float64, south float64,
 	east float64, west float64, config *RasterConfig) (err error) {
 	r.config = config
@@ -67,17 +90,6 @@ func (r *whiteboxRaster) InitializeRaster(fileName string,
 		return err
 	}
 
-	// initialize the data array
-	r.data = make([]float64, r.header.numCells)
-	if config.InitialValue != 0 {
-		for i := range r.data {
-			r.data[i] = config.InitialValue
-		}
-	}
-
-	r.minimumValue = math.MaxFloat64
-	r.maximumValue = -math.MaxFloat64
-
 	return nil
 }
 
@@ -91,19 +103,12 @@ func (r *whiteboxRaster) SetFileName(value string) (err error) {
 	r.config = NewDefaultRasterConfig()
 
 	// sort out the names of the header and data files
-	ext := strings.ToLower(filepath.Ext(value))
-	if ext == ".tas" {
-		r.dataFile = value
-		r.header.fileName = strings.Replace(value, ext, ".dep", -1)
-	} else if ext == ".dep" {
-		r.header.fileName = value
-		r.dataFile = strings.Replace(value, ext, ".tas", -1)
-	} else {
-		return UnsupportedRasterFormatError
+	if err = r.setFileNames(value); err != nil {
+		return err
 	}
 
 	// does the file exist?
-	if _, err = os.Stat(r.header.fileName); err == nil {
+	if FileExists(r.header.fileName) {
 		// yes it does; read the file
 		if err = r.ReadFile(); err != nil {
 			return err
@@ -119,6 +124,22 @@ func (r *whiteboxRaster) SetFileName(value string) (err error) {
 	return nil
 }
 
+// setFileNames sets the names of the header and data files of this Whitebox
+// raster from the name of either.
+func (r *whiteboxRaster) setFileNames(value string) error {
+	ext := strings.ToLower(filepath.Ext(value))
+	if ext == ".tas" {
+		r.dataFile = value
+		r.header.fileName = strings.Replace(value, ext, ".dep", -1)
+	} else if ext == ".dep" {
+		r.header.fileName = value
+		r.dataFile = strings.Replace(value, ext, ".tas", -1)
+	} else {
+		return UnsupportedRasterFormatError
+	}
+	return nil
+}
+
 // Retrieve the RasterType of this Raster.
 func (r *whiteboxRaster) RasterType() RasterType {
 	return RT_WhiteboxRaster
@@ -354,10 +375,29 @@ func (r *whiteboxRaster) ReadFile() error {
 	}
 
 	// read the data file
-	bytedata, err := ioutil.ReadFile(r.dataFile)
+	bytedata, err := readFile(r.dataFile)
+	if err != nil {
+		return err
+	}
+	var bytesPerCell int
+	switch r.config.DataType {
+	case DT_FLOAT64:
+		bytesPerCell = 8
+	case DT_FLOAT32:
+		bytesPerCell = 4
+	case DT_INT16:
+		bytesPerCell = 2
+	case DT_INT8:
+		bytesPerCell = 1
+	default:
+		return FileReadingError
+	}
+	if err = checkDataSize(r.dataFile, r.header.rows, r.header.columns, bytesPerCell, int64(len(bytedata))); err != nil {
+		return err
+	}
 	buf := bytes.NewReader(bytedata)
 	r.header.numCells = r.header.columns * r.header.rows
-	r.data = make([]float64, r.header.numCells)
+	r.data = newCellBuffer(r.header.numCells)
 	switch r.config.DataType {
 	case DT_FLOAT64:
 		err = binary.Read(buf, r.config.ByteOrder, &r.data)
@@ -410,7 +450,7 @@ func (r *whiteboxRaster) readHeaderFile() error {
 	if r.header.fileName == "" {
 		return errors.New("Whitebox GAT raster header file not set properly.")
 	}
-	content, err := ioutil.ReadFile(r.header.fileName)
+	content, err := readFile(r.header.fileName)
 	r.check(err)
 	str := strings.Replace(string(content), "\r\n", "\n", -1)
 	lines := strings.Split(str, "\n")
@@ -480,6 +520,15 @@ func (r *whiteboxRaster) readHeaderFile() error {
 			r.config.XYUnits = strings.ToLower(strings.TrimSpace(s[len(s)-1]))
 		} else if strings.Contains(str, "projection") && !strings.Contains(str, "metadata entry") {
 			r.config.CoordinateRefSystemWKT = strings.TrimPrefix(lines[a], "Projection:\t")
+			if code := ParseEPSGString(r.config.CoordinateRefSystemWKT); code > 0 {
+				r.config.EPSGCode = code
+				r.config.CoordinateRefSystemWKT = ""
+			} else if r.config.EPSGCode == 0 {
+				r.config.EPSGCode = EPSGCodeFromWKT(r.config.CoordinateRefSystemWKT)
+			}
+		} else if strings.Contains(str, "palette nonlinearity") && !strings.Contains(str, "metadata entry") {
+			r.config.PaletteNonlinearity, err = strconv.ParseFloat(strings.TrimSpace(s[len(s)-1]), 64)
+			r.check(err)
 		} else if strings.Contains(str, "preferred palette") && !strings.Contains(str, "metadata entry") {
 			r.config.PreferredPalette = strings.ToLower(strings.TrimSpace(s[len(s)-1]))
 		} else if strings.Contains(str, "byteorder") && !strings.Contains(str, "metadata entry") {
@@ -511,7 +560,10 @@ func (r *whiteboxRaster) writeHeaderFile() (err error) {
 	w := bufio.NewWriter(f)
 	var str string
 
-	r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	if r.data != nil {
+		// the range of data written by a BlockWriter is tracked as it's written
+		r.minimumValue, r.maximumValue = r.findMinAndMaxVals()
+	}
 
 	str = "Min:\t" + strconv.FormatFloat(r.minimumValue, 'f', -1, 64)
 	_, err = w.WriteString(str + "\n")
@@ -572,9 +624,14 @@ func (r *whiteboxRaster) writeHeaderFile() (err error) {
 	r.check(err)
 
 	if r.config.CoordinateRefSystemWKT == "" {
-		r.config.CoordinateRefSystemWKT = "not specified"
+		if r.config.EPSGCode > 0 {
+			str = "Projection:\tEPSG:" + strconv.Itoa(r.config.EPSGCode)
+		} else {
+			str = "Projection:\tnot specified"
+		}
+	} else {
+		str = "Projection:\t" + r.config.CoordinateRefSystemWKT
 	}
-	str = "Projection:\t" + r.config.CoordinateRefSystemWKT
 	_, err = w.WriteString(str + "\n")
 	r.check(err)
 
@@ -0,0 +1,308 @@
+package tests
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster/geotiff"
+)
+
+var testEPSGRegistry = true
+var testUserDefinedGeoKeys = true
+var testUTMZone = true
+var testTransverseMercator = true
+
+func TestEPSGRegistry(t *testing.T) {
+	if testEPSGRegistry {
+		tests := []struct {
+			code           int
+			name           string
+			geographicCode int
+			projection     epsg.Projection
+			params         epsg.Parameters
+		}{
+			{4326, "WGS 84", 4326, epsg.NoProjection, epsg.Parameters{}},
+			{26917, "NAD83 / UTM zone 17N", 4269, epsg.TransverseMercator,
+				epsg.Parameters{CentralMeridian: -81, ScaleFactor: 0.9996, FalseEasting: 500000}},
+			{32755, "WGS 84 / UTM zone 55S", 4326, epsg.TransverseMercator,
+				epsg.Parameters{CentralMeridian: 147, ScaleFactor: 0.9996, FalseEasting: 500000, FalseNorthing: 10000000}},
+			{2958, "NAD83(CSRS) / UTM zone 17N", 4617, epsg.TransverseMercator,
+				epsg.Parameters{CentralMeridian: -81, ScaleFactor: 0.9996, FalseEasting: 500000}},
+			{3857, "WGS 84 / Pseudo-Mercator", 4326, epsg.PseudoMercator, epsg.Parameters{ScaleFactor: 1}},
+			{5070, "NAD83 / Conus Albers", 4269, epsg.AlbersEqualArea,
+				epsg.Parameters{LatitudeOfOrigin: 23, CentralMeridian: -96, StandardParallel1: 29.5, StandardParallel2: 45.5}},
+		}
+		for _, test := range tests {
+			c, ok := epsg.Lookup(test.code)
+			if !ok {
+				t.Errorf("EPSG:%v isn't in the registry", test.code)
+				continue
+			}
+			if c.Code != test.code || c.Name != test.name || c.GeographicCode != test.geographicCode ||
+				c.Projection != test.projection || c.Params != test.params {
+				t.Errorf("EPSG:%v is %+v", test.code, c)
+			}
+			if c.IsGeographic() != (test.projection == epsg.NoProjection) {
+				t.Errorf("EPSG:%v: IsGeographic is %v", test.code, c.IsGeographic())
+			}
+			// a projected system takes the datum and ellipsoid of its
+			// geographic system
+			g, _ := epsg.Lookup(test.geographicCode)
+			if c.DatumCode != g.DatumCode || c.Ellipsoid != g.Ellipsoid {
+				t.Errorf("EPSG:%v has datum %v and ellipsoid %v, expected %v and %v", test.code,
+					c.DatumCode, c.Ellipsoid, g.DatumCode, g.Ellipsoid)
+			}
+			if c.String() != "EPSG:"+strconv.Itoa(test.code)+" "+test.name {
+				t.Errorf("EPSG:%v is described as %q", test.code, c.String())
+			}
+			if code := raster.EPSGCodeFromWKT(c.WKT()); code != test.code {
+				t.Errorf("the WKT of EPSG:%v has code %v", test.code, code)
+			}
+		}
+		if c, _ := epsg.Lookup(26917); c.Ellipsoid.SemiMajorAxis != 6378137 || c.Ellipsoid.InverseFlattening != 298.257222101 {
+			t.Errorf("NAD83 has the ellipsoid %v, expected GRS 1980", c.Ellipsoid)
+		}
+		if _, ok := epsg.Lookup(12345); ok {
+			t.Error("EPSG:12345 was found")
+		}
+
+		codes := epsg.Codes()
+		if !sort.IntsAreSorted(codes) {
+			t.Error("the codes aren't sorted")
+		}
+		for _, code := range codes {
+			if _, ok := epsg.Lookup(code); !ok {
+				t.Errorf("EPSG:%v is listed but can't be looked up", code)
+			}
+		}
+
+		// names are searched ignoring case, and the systems sorted by code
+		var found []int
+		for _, c := range epsg.Search(" utm ZONE 17n") {
+			found = append(found, c.Code)
+		}
+		if !sort.IntsAreSorted(found) || len(found) != 5 || found[0] != 2958 || found[4] != 32617 {
+			t.Errorf("UTM zone 17N systems %v", found)
+		}
+		if s := epsg.Search("pseudo-mercator"); len(s) != 1 || s[0].Code != 3857 {
+			t.Errorf("Pseudo-Mercator systems %v", s)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestUserDefinedGeoKeys(t *testing.T) {
+	if testUserDefinedGeoKeys {
+		dir, err := os.MkdirTemp("", "geokeytest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// systems that the GeoTIFF specification doesn't list are described
+		// with user-defined geokeys and their code recovered from the citation
+		tests := []struct {
+			code    int
+			geokeys map[string]string
+		}{
+			{2958, map[string]string{
+				"GeographicTypeGeoKey":       "32767",
+				"GeogCitationGeoKey":         "NAD83(CSRS)|",
+				"GeogSemiMajorAxisGeoKey":    "6.378137e+06",
+				"GeogInvFlatteningGeoKey":    "298.257222101",
+				"ProjectedCSTypeGeoKey":      "32767",
+				"ProjCoordTransGeoKey":       "1, CT_TransverseMercator",
+				"ProjNatOriginLongGeoKey":    "-81",
+				"ProjScaleAtNatOriginGeoKey": "0.9996",
+				"ProjFalseEastingGeoKey":     "500000",
+			}},
+			{4617, map[string]string{
+				"GTModelTypeGeoKey":    "2, ModelTypeGeographic",
+				"GTCitationGeoKey":     "EPSG:4617 NAD83(CSRS)|",
+				"GeographicTypeGeoKey": "32767",
+			}},
+			{3347, map[string]string{
+				"GeographicTypeGeoKey":     "4269, GCS_NAD83",
+				"ProjCoordTransGeoKey":     "8, CT_LambertConfConic_2SP",
+				"ProjStdParallel1GeoKey":   "49",
+				"ProjStdParallel2GeoKey":   "77",
+				"ProjFalseOriginLatGeoKey": "63.390675",
+			}},
+			// Web Mercator applies the spherical formulas to WGS 84
+			// coordinates, which readers recognize by the citation
+			{3857, map[string]string{
+				"GeographicTypeGeoKey":       "4326, GCS_WGS_84",
+				"PCSCitationGeoKey":          "Popular Visualisation Pseudo Mercator|",
+				"ProjCoordTransGeoKey":       "7, CT_Mercator",
+				"ProjScaleAtNatOriginGeoKey": "1",
+			}},
+		}
+		for _, test := range tests {
+			fileName := filepath.Join(dir, "grid.tif")
+			config := raster.NewDefaultRasterConfig()
+			config.EPSGCode = test.code
+			rout, err := raster.CreateNewRaster(fileName, 1, 1, 1, 0, 1, 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+			var gt geotiff.GeoTIFF
+			if err = gt.Read(fileName); err != nil {
+				t.Fatal(err)
+			}
+			if gt.EPSGCode != uint(test.code) {
+				t.Errorf("EPSG:%v was read as EPSG:%v", test.code, gt.EPSGCode)
+			}
+			values := make(map[string]string)
+			for _, line := range strings.Split(gt.GetTags(), "\n") {
+				// e.g. "Name: ProjFalseEastingGeoKey, Code: 3082 , ... Value: [500000]"
+				if i, j := strings.Index(line, ","), strings.LastIndex(line, "Value: ["); strings.HasPrefix(line, "Name: ") && i > 0 && j > 0 {
+					values[line[len("Name: "):i]] = strings.TrimSuffix(line[j+len("Value: ["):], "]")
+				}
+			}
+			for key, expected := range test.geokeys {
+				if values[key] != expected {
+					t.Errorf("EPSG:%v has %v %q, expected %q", test.code, key, values[key], expected)
+				}
+			}
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestUTMZone(t *testing.T) {
+	if testUTMZone {
+		tests := []struct {
+			lon, lat float64
+			zone     int
+		}{
+			{-81, 43, 17},
+			{-78, 43, 18}, // zones include their western edge
+			{-180, 0, 1},
+			{180, 0, 1},
+			{179.9, 0, 60},
+			{200, 0, 4},
+			// zone 32 is widened westward over southwestern Norway
+			{5, 60, 32},
+			{2.9, 60, 31},
+			{11.9, 63.9, 32},
+			{5, 64, 31},
+			{5, 55.9, 31},
+			// zones 32, 34 and 36 aren't used over Svalbard
+			{8, 78, 31},
+			{10, 78, 33},
+			{20, 78, 33},
+			{22, 78, 35},
+			{32, 78, 35},
+			{35, 78, 37},
+			{41.9, 78, 37},
+			{42, 78, 38},
+			{8, 84, 32},
+		}
+		for _, test := range tests {
+			if zone := epsg.UTMZone(test.lon, test.lat); zone != test.zone {
+				t.Errorf("(%v, %v) is in zone %v, expected %v", test.lon, test.lat, zone, test.zone)
+			}
+		}
+
+		codes := []struct {
+			geographicCode int
+			lon, lat       float64
+			code           int
+		}{
+			{4326, -81, 43, 32617},
+			{4326, -81, -43, 32717},
+			{4326, -81, 0, 32617},
+			{4326, 5, 60, 32632},
+			{4326, 8, 78, 32631},
+			{4269, -81, 43, 26917},
+			{4269, -81, -10, 32717}, // there are no NAD83 zones south of the equator
+			{4269, 100, 10, 32647},  // nor beyond zone 23
+			{4267, -150, 60, 26706},
+			{4283, 150, -33.9, 28356},
+			{4322, 10, -5, 32332},
+			{4617, -81, 43, 32617}, // NAD83(CSRS) zones aren't chosen
+		}
+		for _, test := range codes {
+			code, err := epsg.UTMCode(test.geographicCode, test.lon, test.lat)
+			if err != nil || code != test.code {
+				t.Errorf("(%v, %v) on EPSG:%v has the code %v (%v), expected %v", test.lon, test.lat,
+					test.geographicCode, code, err, test.code)
+			}
+		}
+		for _, p := range [][2]float64{{0, 84.1}, {0, -80.1}, {-180.1, 0}} {
+			if _, err := epsg.UTMCode(4326, p[0], p[1]); err != epsg.InvalidCoordinateError {
+				t.Errorf("(%v, %v) returned %v", p[0], p[1], err)
+			}
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestTransverseMercator(t *testing.T) {
+	if testTransverseMercator {
+		// the numerical example of Snyder (1987, appendix A), on the Clarke 1866
+		// ellipsoid, given to a tenth of a metre
+		c, _ := epsg.Lookup(26718)
+		c.Params.CentralMeridian, c.Params.FalseEasting = -75, 0
+		if x, y, err := c.Forward(-73.5, 40.5); err != nil || math.Abs(x-127106.5) > 0.05 || math.Abs(y-4484124.4) > 0.05 {
+			t.Errorf("Snyder's example is projected to (%.3f, %.3f) (%v)", x, y, err)
+		}
+
+		// points at the edges of their zones, 3 degrees from the central
+		// meridian, where the series are least accurate. The coordinates were
+		// computed with the Krueger series to sixth order in n (Karney 2011,
+		// J. Geodesy 85(8)), which is accurate to 5 nm within a zone.
+		tests := []struct {
+			code     int
+			lon, lat float64
+			x, y     float64
+		}{
+			{26917, -78, 43, 744533.01945, 4765182.93268},
+			{26917, -84, 43, 255466.98055, 4765182.93268},
+			{32617, -78, 60, 667294.82112, 6655205.48363},
+			{32756, 150, -33.9, 222584.01648, 6244878.75706},
+		}
+		for _, test := range tests {
+			c, _ := epsg.Lookup(test.code)
+			x, y, err := c.Forward(test.lon, test.lat)
+			if err != nil || math.Abs(x-test.x) > 0.001 || math.Abs(y-test.y) > 0.001 {
+				t.Errorf("EPSG:%v: (%v, %v) is projected to (%.5f, %.5f) (%v), expected (%.5f, %.5f)",
+					test.code, test.lon, test.lat, x, y, err, test.x, test.y)
+			}
+			lon, lat, err := c.Inverse(test.x, test.y)
+			// the error on the ground, in metres
+			dx := (lon - test.lon) * math.Pi / 180 * c.Ellipsoid.SemiMajorAxis * math.Cos(test.lat*math.Pi/180)
+			dy := (lat - test.lat) * math.Pi / 180 * c.Ellipsoid.SemiMajorAxis
+			if err != nil || math.Hypot(dx, dy) > 0.001 {
+				t.Errorf("EPSG:%v: (%.5f, %.5f) is unprojected to (%v, %v) (%v), %.5f m from (%v, %v)",
+					test.code, test.x, test.y, lon, lat, err, math.Hypot(dx, dy), test.lon, test.lat)
+			}
+		}
+
+		// other projections aren't supported, and geographic systems are
+		// left unchanged
+		c, _ = epsg.Lookup(3347)
+		if _, _, err := c.Forward(-81, 43); err != epsg.UnsupportedProjectionError {
+			t.Errorf("a Lambert projection returned %v", err)
+		}
+		c, _ = epsg.Lookup(4326)
+		if x, y, err := c.Forward(-81, 43); err != nil || x != -81 || y != 43 {
+			t.Errorf("a geographic system projects (-81, 43) to (%v, %v) (%v)", x, y, err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
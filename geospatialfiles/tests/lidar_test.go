@@ -2,13 +2,18 @@ package tests
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jblindsay/go-spatial/geospatialfiles/lidar"
 )
 
 var testLidarRead = true
+var testLidarFormats = true
 
 func TestLidarRead(t *testing.T) {
 	if testLidarRead {
@@ -39,6 +44,104 @@ func TestLidarRead(t *testing.T) {
 	}
 }
 
+func TestLidarFormats(t *testing.T) {
+	if testLidarFormats {
+		dir, err := os.MkdirTemp("", "lidartest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// a LAS 1.4 file with two points of format 6 and the WKT of its
+		// coordinate reference system in an extended variable length record
+		wkt := `PROJCS["NAD83 / UTM zone 17N"]`
+		b := las14File(wkt)
+		fileName := filepath.Join(dir, "points.las")
+		if err = os.WriteFile(fileName, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		input, err := lidar.CreateFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer input.Close()
+		if input.Header.NumberPoints != 2 || input.Header.NumberPointsByReturn[9] != 1 {
+			t.Errorf("unexpected point counts %v %v", input.Header.NumberPoints, input.Header.NumberPointsByReturn)
+		}
+		if input.GetWKT() != wkt || input.GetEPSGCode() != 0 {
+			t.Errorf("unexpected coordinate reference system %q", input.GetWKT())
+		}
+		if x, y, z := input.GetPointXYZ(1); x != 500001.5 || y != 4800002.25 || z != 101.0 {
+			t.Errorf("unexpected coordinates %v %v %v", x, y, z)
+		}
+		p := input.GetPointData(1)
+		if p.BitField.ReturnNumber() != 10 || p.BitField.NumberOfReturns() != 10 || !p.BitField.IsLastReturn() {
+			t.Errorf("unexpected returns %v", p.BitField)
+		}
+		if p.ClassField.ClassValue() != 40 || !p.ClassField.IsWithheld() || p.ClassField.IsSynthetic() {
+			t.Errorf("unexpected classification %v", p.ClassField)
+		}
+		if input.GetPointGPSTime(1) != 12345.5 {
+			t.Errorf("unexpected GPS time %v", input.GetPointGPSTime(1))
+		}
+
+		// LAZ files and other files are reported rather than misread
+		b[104] |= 0x80
+		os.WriteFile(filepath.Join(dir, "points.laz"), b, 0644)
+		if _, err = lidar.CreateFromFile(filepath.Join(dir, "points.laz")); err != lidar.LazFileError {
+			t.Errorf("a LAZ file was not reported: %v", err)
+		}
+		os.WriteFile(filepath.Join(dir, "other.las"), make([]byte, 400), 0644)
+		if _, err = lidar.CreateFromFile(filepath.Join(dir, "other.las")); err != lidar.NotLasFileError {
+			t.Errorf("a file that isn't a LAS file was not reported: %v", err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+// las14File returns a LAS 1.4 file of two points of format 6, the second
+// a withheld tenth return of class 40, followed by an extended variable
+// length record of well-known text.
+func las14File(wkt string) []byte {
+	const headerSize, recordLength = 375, 30
+	b := make([]byte, headerSize+2*recordLength+60+len(wkt))
+	le := binary.LittleEndian
+	copy(b[0:4], "LASF")
+	b[24], b[25] = 1, 4
+	le.PutUint16(b[94:96], headerSize)
+	le.PutUint32(b[96:100], headerSize)
+	b[104] = 6
+	le.PutUint16(b[105:107], recordLength)
+	for i, v := range []float64{0.25, 0.25, 0.5, 500000, 4800000, 100} {
+		le.PutUint64(b[131+8*i:], math.Float64bits(v))
+	}
+	le.PutUint64(b[235:243], headerSize+2*recordLength)
+	le.PutUint32(b[243:247], 1)
+	le.PutUint64(b[247:255], 2)
+	le.PutUint64(b[255:263], 1)
+	le.PutUint64(b[255+9*8:], 1)
+
+	rec := b[headerSize:]
+	rec[14] = 1 | 1<<4
+	rec[16] = 2
+	rec = b[headerSize+recordLength:]
+	le.PutUint32(rec[0:4], 6)
+	le.PutUint32(rec[4:8], 9)
+	le.PutUint32(rec[8:12], 2)
+	rec[14] = 10 | 10<<4
+	rec[15] = 1 << 2 // withheld
+	rec[16] = 40
+	le.PutUint64(rec[22:30], math.Float64bits(12345.5))
+
+	evlr := b[headerSize+2*recordLength:]
+	copy(evlr[2:18], "LASF_Projection")
+	le.PutUint16(evlr[18:20], 2112)
+	le.PutUint64(evlr[20:28], uint64(len(wkt)))
+	copy(evlr[60:], wkt)
+	return b
+}
+
 func convertYearday(yday int, year int) (int, string) {
 	var months = [...]string{
 		"January",
@@ -1,11 +1,19 @@
 package tests
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	. "fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster/geotiff"
 )
 
 //var println = fmt.Println
@@ -16,6 +24,21 @@ var testIdrisiRead = true
 var testIdrisiWrite = true
 var testWhiteboxRead = true
 var testGeoTiffRead = true
+var testDisplaySettings = true
+var testDataSizeValidation = true
+var testStreaming = true
+var testArcGisASCII = true
+var testSouthUp = true
+var testFormatDetection = true
+var testErdasImagine = true
+var testGzip = true
+var testNetCDF = true
+var testZipArchive = true
+var testHeaderOnly = true
+var testGeoTiffStrips = true
+var testGeoTiffPages = true
+var testGeoTiffScaleOffset = true
+var testCRS = true
 
 func TestIdrisiRead(t *testing.T) {
 	if testIdrisiRead {
@@ -155,3 +178,1437 @@ func TestGeoTiffRead(t *testing.T) {
 		t.SkipNow()
 	}
 }
+
+func TestGeoTiffStrips(t *testing.T) {
+	if testGeoTiffStrips {
+		dir := t.TempDir()
+		defer func() { raster.RowsPerStrip, raster.CompressOutput = 0, false }()
+		for _, c := range []struct {
+			rows, columns, rowsPerStrip, blockRows int
+			compress                               bool
+		}{
+			{5, 4, 2, 2, false},
+			{5, 4, 2, 2, true},
+			{5, 4, 0, 5, false},              // 8 KB strips hold every row
+			{300, 10, 0, 204, false},         // 8192 / 40 bytes per row
+			{3, 70000, 0, 1, true},           // a LONG image width
+			{70000, 1, 100000, 70000, false}, // a LONG image length
+		} {
+			raster.RowsPerStrip, raster.CompressOutput = c.rowsPerStrip, c.compress
+			fileName := filepath.Join(dir, Sprintf("strips%dx%d.tif", c.rows, c.columns))
+			config := raster.NewDefaultRasterConfig()
+			config.DataType = raster.DT_FLOAT32
+			rout, err := raster.CreateNewRaster(fileName, c.rows, c.columns, float64(c.rows), 0, float64(c.columns), 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for row := 0; row < c.rows; row++ {
+				rout.SetValue(row, (row*7)%c.columns, float64(row+1))
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			g := new(geotiff.GeoTIFF)
+			if err = g.ReadHeader(fileName); err != nil {
+				t.Fatal(err)
+			}
+			n, err := g.BlockRows()
+			g.Close()
+			if err != nil || n != c.blockRows {
+				t.Errorf("%v x %v: strips of %v rows (%v), expected %v", c.rows, c.columns, n, err, c.blockRows)
+			}
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rin.Rows != c.rows || rin.Columns != c.columns {
+				t.Fatalf("%v x %v: read back as %v x %v", c.rows, c.columns, rin.Rows, rin.Columns)
+			}
+			for row := 0; row < c.rows; row++ {
+				if z := rin.Value(row, (row*7)%c.columns); z != float64(row+1) {
+					t.Fatalf("%v x %v: row %v has %v, expected %v", c.rows, c.columns, row, z, row+1)
+				}
+			}
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestGeoTiffPages(t *testing.T) {
+	if testGeoTiffPages {
+		fileName := filepath.Join(t.TempDir(), "pages.tif")
+		// the pages differ in data type and compression
+		defer func() { raster.CompressOutput = false }()
+		for page, dataType := range []int{raster.DT_FLOAT32, raster.DT_INT16, raster.DT_FLOAT64} {
+			raster.CompressOutput = page == 1
+			config := raster.NewDefaultRasterConfig()
+			config.DataType = dataType
+			config.AppendPage = page > 0
+			rout, err := raster.CreateNewRaster(fileName, 3, 5, 3, 0, 5, 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 15; i++ {
+				rout.SetValue(i/5, i%5, float64(100*page+i))
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for page := 0; page < 3; page++ {
+			rin, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{Page: page})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 15; i++ {
+				if z := rin.Value(i/5, i%5); z != float64(100*page+i) {
+					t.Fatalf("page %v, cell %v = %v, expected %v", page, i, z, 100*page+i)
+				}
+			}
+			if page == 1 {
+				// a page can't be saved over the file
+				if err = rin.Save(); err != raster.MultiPageSaveError {
+					t.Errorf("a page was saved over its file (%v)", err)
+				}
+			}
+		}
+		if rin, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{Page: 2, HeaderOnly: true}); err != nil ||
+			rin.GetRasterConfig().DataType != raster.DT_FLOAT64 {
+			t.Errorf("the header of the last page was not read (%v)", err)
+		}
+		if _, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{Page: 3}); err == nil {
+			t.Errorf("a missing page was read")
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestGeoTiffScaleOffset(t *testing.T) {
+	if testGeoTiffScaleOffset {
+		// elevations stored as whole quarter metres above 100 m
+		fileName := filepath.Join(t.TempDir(), "packed.tif")
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_INT16
+		config.Scale, config.Offset = 0.25, 100
+		config.NoDataValue = -1000
+		rout, err := raster.CreateNewRaster(fileName, 3, 5, 3, 0, 5, 0, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 15; i++ {
+			rout.SetValue(i/5, i%5, 100+float64(i)*1.25)
+		}
+		rout.SetValue(2, 4, -1000)
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(rin *raster.Raster) {
+			c := rin.GetRasterConfig()
+			if c.DataType != raster.DT_FLOAT32 || c.Scale != 0.25 || c.Offset != 100 {
+				t.Errorf("data type %v, scale %v, offset %v", c.DataType, c.Scale, c.Offset)
+			}
+			if rin.NoDataValue != -1000 {
+				t.Errorf("nodata value %v, expected -1000", rin.NoDataValue)
+			}
+			for i := 0; i < 14; i++ {
+				if z := rin.Value(i/5, i%5); z != 100+float64(i)*1.25 {
+					t.Fatalf("cell %v = %v, expected %v", i, z, 100+float64(i)*1.25)
+				}
+			}
+			if z := rin.Value(2, 4); z != rin.NoDataValue {
+				t.Errorf("the nodata cell = %v", z)
+			}
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(rin)
+
+		// the values are packed again when the raster is saved
+		if err = rin.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		check(rin)
+
+		// and unpacked by the streaming reader
+		br, err := raster.OpenStreaming(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer br.Close()
+		row := make([]float64, 5)
+		if _, err = br.ReadRows(2, row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != 112.5 || row[4] != br.NoDataValue || br.NoDataValue != -1000 {
+			t.Errorf("the streamed row is %v, nodata %v", row, br.NoDataValue)
+		}
+
+		// an output created with the input's config isn't packed, since its
+		// values, e.g. a slope, needn't fit the packing...
+		derivedFile := filepath.Join(t.TempDir(), "derived.tif")
+		derived, err := raster.CreateNewRaster(derivedFile, 3, 5, 3, 0, 5, 0, rin.GetRasterConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		derived.SetValue(0, 0, 0.123)
+		derived.SetValue(0, 1, 1e6)
+		if err = derived.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if c := rin.GetRasterConfig(); c.Scale != 0.25 || c.Offset != 100 {
+			t.Errorf("the input's scale and offset became %v and %v", c.Scale, c.Offset)
+		}
+		if derived, err = raster.CreateRasterFromFile(derivedFile); err != nil {
+			t.Fatal(err)
+		}
+		if c := derived.GetRasterConfig(); c.Scale != 1 || c.Offset != 0 ||
+			derived.Value(0, 0) != float64(float32(0.123)) || derived.Value(0, 1) != 1e6 {
+			t.Errorf("the derived output has scale %v, offset %v and values %v and %v",
+				c.Scale, c.Offset, derived.Value(0, 0), derived.Value(0, 1))
+		}
+
+		// ...unless its packing is set explicitly
+		config = new(raster.RasterConfig)
+		*config = *rin.GetRasterConfig()
+		config.DataType = raster.DT_INT16
+		config.Scale = 0.5
+		config.InitialValue = config.NoDataValue
+		if derived, err = raster.CreateNewRaster(derivedFile, 3, 5, 3, 0, 5, 0, config); err != nil {
+			t.Fatal(err)
+		}
+		derived.SetValue(0, 0, 150)
+		if err = derived.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if derived, err = raster.CreateRasterFromFile(derivedFile); err != nil {
+			t.Fatal(err)
+		}
+		if c := derived.GetRasterConfig(); c.Scale != 0.5 || c.Offset != 100 || derived.Value(0, 0) != 150 {
+			t.Errorf("the packed output has scale %v, offset %v and value %v", c.Scale, c.Offset, derived.Value(0, 0))
+		}
+
+		// a value beyond the range of the file's integers isn't saved, and
+		// the file is left as it was
+		rin.SetValue(0, 0, 100+0.25*40000)
+		err = rin.Save()
+		if e, ok := err.(*raster.PackingOverflowError); !ok || e.Minimum != -32768 || e.Maximum != 32767 {
+			t.Errorf("saving an overflowing value returned %v", err)
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		check(rin)
+
+		// as is a value below the range of unsigned integers, or a nodata
+		// value beyond it
+		config = raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_UINT8
+		config.Offset = 100
+		config.NoDataValue = 355
+		rout, err = raster.CreateNewRaster(filepath.Join(t.TempDir(), "uint8.tif"), 1, 2, 1, 0, 2, 0, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rout.SetValue(0, 0, 100)
+		rout.SetValue(0, 1, 354)
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+		rout.SetValue(0, 1, 99)
+		if _, ok := rout.Save().(*raster.PackingOverflowError); !ok {
+			t.Error("a value below the range of unsigned integers was saved")
+		}
+		rout.SetValue(0, 1, 354)
+		rout.GetRasterConfig().NoDataValue = 356
+		if _, ok := rout.Save().(*raster.PackingOverflowError); !ok {
+			t.Error("a nodata value beyond the range of unsigned integers was saved")
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestDisplaySettings(t *testing.T) {
+	if testDisplaySettings {
+		// the display settings of a Whitebox file survive a GeoTIFF copy
+		rin, err := raster.CreateRasterFromFile("./testdata/DEM.dep")
+		if err != nil {
+			t.Fatal("Failed to read file")
+		}
+		inConfig := rin.GetRasterConfig()
+		inConfig.PreferredPalette = "imhof1.pal"
+		inConfig.PaletteNonlinearity = 0.5
+
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_FLOAT32
+		config.CopyDisplaySettings(inConfig)
+		outFile := filepath.Join(t.TempDir(), "DEM.tif")
+		rout, err := raster.CreateNewRaster(outFile, rin.Rows, rin.Columns,
+			rin.North, rin.South, rin.East, rin.West, config)
+		if err != nil {
+			t.Fatal("Failed to create raster")
+		}
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(outFile + raster.DisplaySidecarExtension); err != nil {
+			t.Fatal("The display sidecar was not written")
+		}
+
+		rin, err = raster.CreateRasterFromFile(outFile)
+		if err != nil {
+			t.Fatal("Failed to read file")
+		}
+		c := rin.GetRasterConfig()
+		if c.PreferredPalette != "imhof1.pal" || c.PaletteNonlinearity != 0.5 ||
+			c.DisplayMinimum != inConfig.DisplayMinimum || c.DisplayMaximum != inConfig.DisplayMaximum {
+			t.Errorf("display settings were not read from the sidecar: %v %v %v %v",
+				c.PreferredPalette, c.PaletteNonlinearity, c.DisplayMinimum, c.DisplayMaximum)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	p := raster.NewBufferPool(0)
+	outside := p.Get(100)
+
+	mark := p.Begin()
+	a := p.Get(1000)
+	a[0] = 1
+	p.Get(10)
+	p.End(mark)
+
+	mark = p.Begin()
+	b := p.Get(900) // reuses a
+	if &b[0] != &a[0] || b[0] != 0 {
+		t.Error("a free buffer of a suitable size was not reused and cleared")
+	}
+	if c := p.Get(500); len(c) != 500 || cap(c) == 1000 {
+		t.Error("a buffer much larger than the request was reused")
+	}
+	p.Put(outside) // ignored, since it was handed out outside of a step
+	p.End(mark)
+	if requested, reused := p.Stats(); requested != 5 || reused != 1 {
+		t.Errorf("%v of %v buffers reused, expected 1 of 5", reused, requested)
+	}
+
+	// the pool holds free buffers up to its maximum size
+	p = raster.NewBufferPool(8000)
+	mark = p.Begin()
+	a, b = p.Get(1000), p.Get(1000)
+	p.End(mark)
+	if c := p.Get(1000); &c[0] != &b[0] {
+		t.Error("the newest free buffer was not kept")
+	}
+	if c := p.Get(1000); &c[0] == &a[0] {
+		t.Error("a free buffer beyond the maximum size was kept")
+	}
+}
+
+func TestDataSizeValidation(t *testing.T) {
+	if testDataSizeValidation {
+		dir := t.TempDir()
+		for _, ext := range []string{".dep", ".flt"} {
+			fileName := filepath.Join(dir, "grid"+ext)
+			config := raster.NewDefaultRasterConfig()
+			config.DataType = raster.DT_FLOAT32
+			rout, err := raster.CreateNewRaster(fileName, 10, 10, 10, 0, 10, 0, config)
+			if err != nil {
+				t.Fatal("Failed to create raster")
+			}
+			if err = rout.SetValueChecked(5, 5, 1); err != nil {
+				t.Error(err)
+			}
+			if err = rout.SetValueChecked(5, 10, 1); err == nil {
+				t.Error("a value was set outside of the grid")
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rin.Value(5, 5) != 1 {
+				t.Errorf("%s: cell (5, 5) = %v, expected 1", ext, rin.Value(5, 5))
+			}
+
+			// truncate the data
+			dataFile := fileName
+			if ext == ".dep" {
+				dataFile = filepath.Join(dir, "grid.tas")
+			}
+			info, err := os.Stat(dataFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Truncate(dataFile, info.Size()-40); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = raster.CreateRasterFromFile(fileName); err == nil {
+				t.Errorf("%s: a truncated file was read without an error", ext)
+			} else if _, ok := err.(*raster.DataSizeError); !ok {
+				t.Errorf("%s: unexpected error %v", ext, err)
+			}
+		}
+
+		// the data of this GeoTIFF follow its tags
+		data, err := os.ReadFile("./testdata/Sample64Bit.tif")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(dir, "grid.tif")
+		if err = os.WriteFile(fileName, data[:len(data)-1000], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = raster.CreateRasterFromFile(fileName); err == nil {
+			t.Error("a truncated GeoTIFF was read without an error")
+		}
+
+		// an ArcGIS ASCII grid may omit NODATA_value, but not data values
+		fileName = filepath.Join(dir, "grid.asc")
+		header := "ncols 3\nnrows 3\nxllcorner 0\nyllcorner 0\ncellsize 1\n"
+		if err = os.WriteFile(fileName, []byte(header+"1 2 3\n4 5 6\n7 8 9\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rin.Value(0, 0) != 1 || rin.Value(2, 2) != 9 {
+			t.Errorf("unexpected values %v and %v", rin.Value(0, 0), rin.Value(2, 2))
+		}
+		if err = os.WriteFile(fileName, []byte(header+"1 2 3\n4 5 6\n7 8\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = raster.CreateRasterFromFile(fileName); err == nil {
+			t.Error("a short ASCII grid was read without an error")
+		} else {
+			Println(err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestArcGisASCII(t *testing.T) {
+	if testArcGisASCII {
+		dir, err := os.MkdirTemp("", "asciitest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// a grid of non-square cells written and read back
+		fileName := filepath.Join(dir, "grid.asc")
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_FLOAT32
+		config.NoDataValue = -9999
+		config.InitialValue = -9999
+		rout, err := raster.CreateNewRaster(fileName, 2, 3, 4820000, 4819980, 500030, 500000, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for col := 0; col < 3; col++ {
+			rout.SetValue(0, col, float64(float32(0.1*float64(col+1))))
+		}
+		rout.SetValue(1, 1, 123.5)
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rin.Rows != 2 || rin.Columns != 3 || rin.North != 4820000 || rin.South != 4819980 ||
+			rin.East != 500030 || rin.West != 500000 {
+			t.Errorf("unexpected extent %v x %v, %v %v %v %v", rin.Rows, rin.Columns,
+				rin.North, rin.South, rin.East, rin.West)
+		}
+		if rin.GetCellSizeX() != 10 || rin.GetCellSizeY() != 10 {
+			t.Errorf("unexpected cell sizes %v and %v", rin.GetCellSizeX(), rin.GetCellSizeY())
+		}
+		// 32-bit values are written with the fewest digits that identify them
+		if rin.Value(0, 0) != 0.1 || rin.Value(1, 1) != 123.5 || rin.Value(1, 0) != rin.NoDataValue {
+			t.Errorf("unexpected values %v, %v and %v", rin.Value(0, 0), rin.Value(1, 1), rin.Value(1, 0))
+		}
+
+		rout, err = raster.CreateNewRaster(fileName, 2, 2, 100, 90, 10, 0, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		if rin.GetCellSizeX() != 5 || rin.GetCellSizeY() != 5 || rin.West != 0 {
+			t.Errorf("unexpected cell sizes %v and %v or west edge %v", rin.GetCellSizeX(), rin.GetCellSizeY(), rin.West)
+		}
+
+		// GDAL's DX and DY, and a cell-centre origin at zero
+		header := "ncols 2\nnrows 2\nxllcenter 0\nyllcenter 0\ndx 2\ndy 4\nNODATA_value -1\n"
+		if err = os.WriteFile(fileName, []byte(header+"1 2\n3 -1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		if rin.West != -1 || rin.East != 3 || rin.South != -2 || rin.North != 6 || rin.Value(1, 1) != rin.NoDataValue {
+			t.Errorf("unexpected extent %v %v %v %v or nodata value %v", rin.North, rin.South,
+				rin.East, rin.West, rin.Value(1, 1))
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestSouthUp(t *testing.T) {
+	if testSouthUp {
+		dir, err := os.MkdirTemp("", "southuptest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// an ASCII grid with a negative DY lists its rows from south to north
+		fileName := filepath.Join(dir, "grid.asc")
+		header := "ncols 2\nnrows 3\nxllcorner 0\nyllcorner 30\ndx 10\ndy -10\n"
+		if err = os.WriteFile(fileName, []byte(header+"1 2\n3 4\n5 6\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rin.North != 30 || rin.South != 0 || rin.GetCellSizeY() != 10 || !rin.GetRasterConfig().SouthUp {
+			t.Errorf("unexpected extent %v to %v of a south-up grid", rin.South, rin.North)
+		}
+		if rin.Value(0, 0) != 5 || rin.Value(2, 1) != 2 {
+			t.Errorf("unexpected values %v and %v of a south-up grid", rin.Value(0, 0), rin.Value(2, 1))
+		}
+		// it is saved in its own row order
+		if err = rin.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if rin.Value(0, 0) != 5 {
+			t.Errorf("the rows of a saved south-up grid were left reversed")
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Fatal(err)
+		}
+		if rin.Value(0, 0) != 5 || rin.North != 30 {
+			t.Errorf("unexpected value %v or northern edge %v of a resaved south-up grid", rin.Value(0, 0), rin.North)
+		}
+
+		// the row order of other files is given in the config, for reading
+		// and writing
+		for _, ext := range []string{".dep", ".tif", ".flt"} {
+			fileName = filepath.Join(dir, "grid"+ext)
+			config := raster.NewDefaultRasterConfig()
+			config.DataType = raster.DT_FLOAT32
+			config.SouthUp = true
+			rout, err := raster.CreateNewRaster(fileName, 3, 2, 30, 0, 20, 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for row := 0; row < 3; row++ {
+				for col := 0; col < 2; col++ {
+					rout.SetValue(row, col, float64(2*row+col+1))
+				}
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if rin, err = raster.CreateRasterFromFile(fileName); err != nil {
+				t.Fatal(err)
+			}
+			if rin.Value(0, 0) != 5 || rin.GetRasterConfig().SouthUp {
+				t.Errorf("%s: the first row of the file has %v, expected 5", ext, rin.Value(0, 0))
+			}
+			if rin, err = raster.CreateRasterFromFile(fileName, raster.RasterConfig{SouthUp: true}); err != nil {
+				t.Fatal(err)
+			}
+			if rin.Value(0, 0) != 1 || rin.Value(2, 1) != 6 {
+				t.Errorf("%s: unexpected values %v and %v of a south-up file", ext, rin.Value(0, 0), rin.Value(2, 1))
+			}
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	if testStreaming {
+		// the rows read by a BlockReader match those of the whole raster
+		for _, fileName := range []string{"./testdata/DEM.dep", "./testdata/DEM.rst", "./testdata/DEM.tif", "./testdata/Sample64Bit.tif"} {
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			br, err := raster.OpenStreaming(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if br.Rows != rin.Rows || br.Columns != rin.Columns || br.NoDataValue != rin.NoDataValue {
+				t.Errorf("%s: the header differs from that of the raster", fileName)
+			}
+			// an odd number of rows, so that reads straddle GeoTIFF strips
+			buf := make([]float64, 3*br.Columns)
+			for row := 0; row < br.Rows; {
+				n, err := br.ReadRows(row, buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < n*br.Columns; i++ {
+					if z := rin.Value(row+i/br.Columns, i%br.Columns); buf[i] != z {
+						t.Fatalf("%s: cell (%d, %d) = %v, expected %v", fileName, row+i/br.Columns, i%br.Columns, buf[i], z)
+					}
+				}
+				row += n
+			}
+			br.Close()
+		}
+
+		// rows written by a BlockWriter are read back
+		dir := t.TempDir()
+		for _, ext := range []string{".dep", ".flt"} {
+			fileName := filepath.Join(dir, "streamed"+ext)
+			config := raster.NewDefaultRasterConfig()
+			config.NoDataValue = -32768
+			bw, err := raster.CreateStreaming(fileName, 5, 4, 5, 0, 4, 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			row := make([]float64, 4)
+			for r := 0; r < 5; r++ {
+				for c := range row {
+					row[c] = float64(r*4 + c)
+				}
+				row[0] = -32768
+				if err = bw.WriteRows(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = bw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rin.Value(0, 0) != -32768 || rin.Value(4, 3) != 19 || rin.NoDataValue != -32768 {
+				t.Errorf("%s: the streamed raster was not read back", ext)
+			}
+			if ext == ".dep" && (rin.GetMinimumValue() != 1 || rin.GetMaximumValue() != 19) {
+				t.Errorf("%s: range %v to %v, expected 1 to 19", ext, rin.GetMinimumValue(), rin.GetMaximumValue())
+			}
+		}
+	}
+}
+
+func TestFormatDetection(t *testing.T) {
+	if testFormatDetection {
+		dir, err := os.MkdirTemp("", "formattest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// files are recognized by their contents whatever their names
+		tiff, err := os.ReadFile("./testdata/DEM.tif")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string][]byte{
+			"dem":       tiff,
+			"dem.dat":   []byte("ncols 2\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2\n"),
+			"grass.txt": []byte("north: 1\nsouth: 0\neast: 2\nwest: 0\nrows: 1\ncols: 2\n1 2\n"),
+			"arc.txt":   []byte("ncols 2\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2\n"),
+		}
+		expected := map[string]raster.RasterType{"dem": raster.RT_GeoTiff, "dem.dat": raster.RT_ArcGisAsciiRaster,
+			"grass.txt": raster.RT_GrassAsciiRaster, "arc.txt": raster.RT_ArcGisAsciiRaster}
+		for name, contents := range files {
+			fileName := filepath.Join(dir, name)
+			if err = os.WriteFile(fileName, contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+			rt, err := raster.DetermineRasterFormat(fileName)
+			if err != nil || rt != expected[name] {
+				t.Errorf("%s: detected %v (%v), expected %v", name, rt, err, expected[name])
+				continue
+			}
+			if _, err = raster.CreateRasterFromFile(fileName); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+
+		// header files and new files are identified by their extensions
+		if rt, err := raster.DetermineRasterFormat("./testdata/DEM.dep"); err != nil || rt != raster.RT_WhiteboxRaster {
+			t.Errorf("DEM.dep: detected %v (%v)", rt, err)
+		}
+		if rt, err := raster.DetermineRasterFormat(filepath.Join(dir, "new.tif")); err != nil || rt != raster.RT_GeoTiff {
+			t.Errorf("new.tif: detected %v (%v)", rt, err)
+		}
+
+		// compressed files are reported
+		fileName := filepath.Join(dir, "dem.tif.gz")
+		if err = os.WriteFile(fileName, []byte{0x1f, 0x8b, 8, 0}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = raster.DetermineRasterFormat(fileName); err != raster.CompressedRasterError {
+			t.Errorf("a gzip file was not reported as compressed: %v", err)
+		}
+
+		// the format can be given explicitly
+		for _, s := range []string{"GeoTiff", "tif", ".TIFF", "geotiff"} {
+			if rt, err := raster.ParseRasterFormat(s); err != nil || rt != raster.RT_GeoTiff {
+				t.Errorf("%s: parsed as %v (%v)", s, rt, err)
+			}
+		}
+		if rt, err := raster.ParseRasterFormat("whitebox"); err != nil || rt != raster.RT_WhiteboxRaster {
+			t.Errorf("whitebox: parsed as %v (%v)", rt, err)
+		}
+		if _, err := raster.ParseRasterFormat("txt"); err == nil {
+			t.Errorf("the ambiguous extension txt was accepted")
+		}
+		// e.g. for a GeoTIFF named like the data file of an ArcGIS binary grid
+		fileName = filepath.Join(dir, "dem.flt")
+		if err = os.WriteFile(fileName, tiff, 0644); err != nil {
+			t.Fatal(err)
+		}
+		raster.InputFormat = raster.RT_GeoTiff
+		defer func() { raster.InputFormat = raster.RT_UnknownRaster }()
+		if _, err = raster.CreateRasterFromFile(fileName); err != nil {
+			t.Errorf("InputFormat: %v", err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestErdasImagine(t *testing.T) {
+	if testErdasImagine {
+		dir, err := os.MkdirTemp("", "erdastest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// a 5 x 7 grid of 10 m cells in 4 x 4 tiles, the last of which is
+		// missing
+		rows, columns := 5, 7
+		fileName := filepath.Join(dir, "dem.img")
+		if err = os.WriteFile(fileName, erdasImagineFile(rows, columns), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if rt, err := raster.DetermineRasterFormat(fileName); err != nil || rt != raster.RT_ErdasImagineRaster {
+			t.Errorf("detected %v (%v)", rt, err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rin.Rows != rows || rin.Columns != columns || rin.West != 500000 || rin.North != 4820000 ||
+			rin.East != 500070 || rin.South != 4819950 {
+			t.Errorf("unexpected dimensions %v x %v or extent %v, %v, %v, %v",
+				rin.Rows, rin.Columns, rin.West, rin.North, rin.East, rin.South)
+		}
+		if rin.NoDataValue != -9999 || rin.GetRasterConfig().EPSGCode != 26917 {
+			t.Errorf("unexpected nodata %v or EPSG code %v", rin.NoDataValue, rin.GetRasterConfig().EPSGCode)
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				expected := float64(row*columns + col)
+				if row >= 4 && col >= 4 {
+					expected = -9999
+				}
+				if rin.Value(row, col) != expected {
+					t.Errorf("cell (%v, %v) = %v, expected %v", row, col, rin.Value(row, col), expected)
+				}
+			}
+		}
+
+		// the format is read-only
+		if _, err = raster.CreateNewRaster(filepath.Join(dir, "new.img"), 2, 2, 2, 0, 2, 0); err != raster.ReadOnlyRasterFormatError {
+			t.Errorf("a new Erdas Imagine raster was created: %v", err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+// erdasImagineFile returns an Erdas Imagine file of a single float32 layer,
+// whose cells are numbered in row-major order, in tiles of 4 x 4 cells of
+// which the last is missing, with a nodata value of -9999 and georeferencing
+// in UTM zone 17 on NAD83.
+func erdasImagineFile(rows, columns int) []byte {
+	le := binary.LittleEndian
+	blocksPerRow, blocksPerColumn := (columns+3)/4, (rows+3)/4
+	numBlocks := blocksPerRow * blocksPerColumn
+	const entrySize = 128
+	root, layer, dms, mapInfo, nodata, proj, datum := 64, 192, 320, 448, 576, 704, 832
+	dataPos := 960
+	b := make([]byte, dataPos+1024+numBlocks*64)
+	copy(b, "EHFA_HEADER_TAG")
+	le.PutUint32(b[16:], 20)
+	le.PutUint32(b[20:], 1)            // version
+	le.PutUint32(b[28:], uint32(root)) // the root
+	le.PutUint16(b[32:], entrySize)
+
+	// the data of each node follows the nodes
+	entry := func(pos, next, child int, name, typeName string, data []byte) {
+		le.PutUint32(b[pos:], uint32(next))
+		le.PutUint32(b[pos+12:], uint32(child))
+		le.PutUint32(b[pos+16:], uint32(dataPos))
+		le.PutUint32(b[pos+20:], uint32(len(data)))
+		copy(b[pos+24:pos+88], name)
+		copy(b[pos+88:pos+120], typeName)
+		copy(b[dataPos:], data)
+		dataPos += len(data)
+	}
+	str := func(s string) []byte {
+		d := make([]byte, 8+len(s)+1)
+		le.PutUint32(d, uint32(len(s)+1))
+		copy(d[8:], s)
+		return d
+	}
+	pair := func(x, y float64) []byte {
+		d := make([]byte, 24)
+		le.PutUint32(d, 1)
+		le.PutUint64(d[8:], math.Float64bits(x))
+		le.PutUint64(d[16:], math.Float64bits(y))
+		return d
+	}
+
+	entry(root, 0, layer, "root", "root", nil)
+
+	d := make([]byte, 20)
+	le.PutUint32(d[0:], uint32(columns))
+	le.PutUint32(d[4:], uint32(rows))
+	le.PutUint16(d[8:], 1)  // athematic
+	le.PutUint16(d[10:], 9) // f32
+	le.PutUint32(d[12:], 4)
+	le.PutUint32(d[16:], 4)
+	entry(layer, 0, dms, "Layer_1", "Eimg_Layer", d)
+
+	// the tiles are stored after the nodes' data
+	blockPos := 960 + 1024
+	d = make([]byte, 22+14*numBlocks)
+	le.PutUint32(d[0:], uint32(numBlocks))
+	le.PutUint32(d[14:], uint32(numBlocks))
+	for i := 0; i < numBlocks; i++ {
+		info := d[22+14*i:]
+		le.PutUint32(info[2:], uint32(blockPos+i*64))
+		le.PutUint32(info[6:], 64)
+		if i < numBlocks-1 {
+			le.PutUint16(info[10:], 1) // valid
+		}
+		for j := 0; j < 16; j++ {
+			row, col := (i/blocksPerRow)*4+j/4, (i%blocksPerRow)*4+j%4
+			le.PutUint32(b[blockPos+i*64+4*j:], math.Float32bits(float32(row*columns+col)))
+		}
+	}
+	entry(dms, mapInfo, 0, "RasterDMS", "Edms_State", d)
+
+	d = append(str("UTM"), pair(500005, 4819995)...)
+	d = append(d, pair(500065, 4819955)...)
+	d = append(d, pair(10, 10)...)
+	d = append(d, str("meters")...)
+	entry(mapInfo, nodata, 0, "Map_Info", "Eprj_MapInfo", d)
+
+	d = make([]byte, 24)
+	le.PutUint32(d[0:], 1)
+	le.PutUint32(d[8:], 1)
+	le.PutUint32(d[12:], 1)
+	le.PutUint16(d[16:], 9) // f32
+	le.PutUint32(d[20:], math.Float32bits(-9999))
+	entry(nodata, proj, 0, "Eimg_NonInitializedValue", "Eimg_NonInitializedValue", d)
+
+	// proType and proNumber, then proExeName, proName, proZone and proParams
+	d = make([]byte, 6)
+	le.PutUint32(d[2:], 1)
+	d = append(d, str("")...)
+	d = append(d, str("UTM")...)
+	d = append(d, 17, 0, 0, 0)
+	d = append(d, make([]byte, 8)...)
+	entry(proj, 0, datum, "Projection", "Eprj_ProParameters", d)
+	entry(datum, 0, 0, "Datum", "Eprj_Datum", str("NAD83"))
+
+	return b
+}
+
+func TestGzip(t *testing.T) {
+	if testGzip {
+		dir, err := os.MkdirTemp("", "gziptest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte("ncols 2\nnrows 2\nxllcorner 0\nyllcorner 0\ncellsize 1\nnodata_value -9999\n1 2\n3 -9999\n"))
+		zw.Close()
+		for _, name := range []string{"dem.asc.gz", "dem.gz"} {
+			fileName := filepath.Join(dir, name)
+			if err = os.WriteFile(fileName, b.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if rin.RasterFormat != raster.RT_ArcGisAsciiRaster || rin.Value(1, 0) != 3 || rin.Value(1, 1) != -9999 {
+				t.Errorf("%s: unexpected format %v or values", name, rin.RasterFormat)
+			}
+		}
+
+		// a gzipped grid is saved gzipped
+		fileName := filepath.Join(dir, "dem.asc.gz")
+		rin, _ := raster.CreateRasterFromFile(fileName)
+		rin.SetValue(0, 0, 5)
+		if err = rin.Save(); err != nil {
+			t.Fatal(err)
+		}
+		contents, err := os.ReadFile(fileName)
+		if err != nil || len(contents) < 2 || contents[0] != 0x1f || contents[1] != 0x8b {
+			t.Errorf("the saved grid is not gzipped")
+		}
+		if rin, err = raster.CreateRasterFromFile(fileName); err != nil || rin.Value(0, 0) != 5 {
+			t.Errorf("the saved grid was not read back: %v", err)
+		}
+
+		// gzipped binary rasters must be decompressed first
+		b.Reset()
+		zw = gzip.NewWriter(&b)
+		zw.Write([]byte("II*\x00"))
+		zw.Close()
+		fileName = filepath.Join(dir, "dem.tif.gz")
+		if err = os.WriteFile(fileName, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = raster.CreateRasterFromFile(fileName); err != raster.CompressedRasterError {
+			t.Errorf("a gzipped GeoTIFF was not reported as compressed: %v", err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestNetCDF(t *testing.T) {
+	if testNetCDF {
+		dir, err := os.MkdirTemp("", "netcdftest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		fileName := filepath.Join(dir, "elev.nc")
+		if err = os.WriteFile(fileName, netCDFFile(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if rt, err := raster.DetermineRasterFormat(fileName); err != nil || rt != raster.RT_NetCDFRaster {
+			t.Errorf("detected %v (%v)", rt, err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the latitudes increase, so the rows are reversed
+		if rin.Rows != 3 || rin.Columns != 4 || rin.North != 44.25 || rin.South != 42.75 ||
+			rin.West != -80.25 || rin.East != -78.25 || !rin.GetRasterConfig().SouthUp {
+			t.Errorf("unexpected dimensions %v x %v or extent %v, %v, %v, %v",
+				rin.Rows, rin.Columns, rin.West, rin.North, rin.East, rin.South)
+		}
+		if rin.NoDataValue != -399.5 || rin.GetRasterConfig().EPSGCode != 4326 || rin.GetRasterConfig().ZUnits != "m" {
+			t.Errorf("unexpected nodata %v, EPSG code %v or units %v", rin.NoDataValue,
+				rin.GetRasterConfig().EPSGCode, rin.GetRasterConfig().ZUnits)
+		}
+		// the values are unpacked, and the fill value is nodata
+		if rin.Value(0, 0) != 104 || rin.Value(2, 3) != 101.5 || rin.Value(1, 1) != -399.5 {
+			t.Errorf("unexpected values %v, %v and %v", rin.Value(0, 0), rin.Value(2, 3), rin.Value(1, 1))
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+// netCDFFile returns a classic NetCDF file holding a 3 x 4 grid of elevations
+// for a single time step, packed as shorts with a scale factor of 0.5 and an
+// offset of 100, on increasing latitudes and longitudes of 0.5 degrees.
+func netCDFFile() []byte {
+	be := binary.BigEndian
+	var b bytes.Buffer
+	i32 := func(v int) { binary.Write(&b, be, int32(v)) }
+	name := func(s string) {
+		i32(len(s))
+		b.WriteString(s)
+		b.Write(make([]byte, (4-len(s)%4)%4))
+	}
+	text := func(key, value string) {
+		name(key)
+		i32(2) // char
+		name(value)
+	}
+	header := func(begins []int) {
+		b.WriteString("CDF\x01")
+		i32(1) // one record
+		i32(0x0A)
+		i32(3)
+		name("time")
+		i32(0)
+		name("lat")
+		i32(3)
+		name("lon")
+		i32(4)
+		i32(0x0C)
+		i32(1)
+		text("title", "Test")
+		i32(0x0B)
+		i32(3)
+		// lat(lat) and lon(lon), as doubles
+		name("lat")
+		i32(1)
+		i32(1)
+		i32(0x0C)
+		i32(1)
+		text("units", "degrees_north")
+		i32(6)
+		i32(24)
+		i32(begins[0])
+		name("lon")
+		i32(1)
+		i32(2)
+		i32(0x0C)
+		i32(1)
+		text("units", "degrees_east")
+		i32(6)
+		i32(32)
+		i32(begins[1])
+		// elev(time, lat, lon), as shorts
+		name("elev")
+		i32(3)
+		i32(0)
+		i32(1)
+		i32(2)
+		i32(0x0C)
+		i32(4)
+		name("scale_factor")
+		i32(5) // float
+		i32(1)
+		binary.Write(&b, be, float32(0.5))
+		name("add_offset")
+		i32(5)
+		i32(1)
+		binary.Write(&b, be, float32(100))
+		name("_FillValue")
+		i32(3) // short
+		i32(1)
+		binary.Write(&b, be, []int16{-999, 0})
+		text("units", "m")
+		i32(3)
+		i32(24)
+		i32(begins[2])
+	}
+	header([]int{0, 0, 0})
+	n := b.Len()
+	b.Reset()
+	header([]int{n, n + 24, n + 56})
+	binary.Write(&b, be, []float64{43, 43.5, 44})
+	binary.Write(&b, be, []float64{-80, -79.5, -79, -78.5})
+	for i := 0; i < 12; i++ {
+		if i == 5 {
+			binary.Write(&b, be, int16(-999))
+		} else {
+			binary.Write(&b, be, int16(i))
+		}
+	}
+	return b.Bytes()
+}
+
+func TestZipArchive(t *testing.T) {
+	if testZipArchive {
+		dir, err := os.MkdirTemp("", "ziptest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// an archive of a GeoTIFF, a Whitebox raster, whose header and data
+		// files are read separately, and a gzipped ASCII grid in a folder
+		archive := filepath.Join(dir, "tiles.zip")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		for _, name := range []string{"DEM.tif", "DEM.dep", "DEM.tas"} {
+			contents, err := os.ReadFile(filepath.Join("./testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, _ := zw.Create(name)
+			w.Write(contents)
+		}
+		w, _ := zw.Create("ascii/grid.asc.gz")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte("ncols 2\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2\n"))
+		gw.Close()
+		if err = zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		names, err := raster.ListZipArchive(archive)
+		if err != nil || len(names) != 4 || names[0] != "DEM.dep" || names[3] != "ascii/grid.asc.gz" {
+			t.Errorf("unexpected members %v (%v)", names, err)
+		}
+		for _, name := range []string{"DEM.tif", "DEM.dep"} {
+			rin, err := raster.CreateRasterFromFile(filepath.Join(archive, name))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			expected, _ := raster.CreateRasterFromFile(filepath.Join("./testdata", name))
+			if rin.Rows != expected.Rows || rin.Value(100, 100) != expected.Value(100, 100) {
+				t.Errorf("%s: unexpected dimensions or value %v", name, rin.Value(100, 100))
+			}
+			// the archive is read-only
+			if err = rin.Save(); err != raster.ReadOnlyArchiveError {
+				t.Errorf("%s: a raster was saved within an archive: %v", name, err)
+			}
+		}
+		rin, err := raster.CreateRasterFromFile(filepath.Join(archive, "ascii/grid.asc.gz"))
+		if err != nil || rin.Value(0, 1) != 2 {
+			t.Errorf("the gzipped grid was not read: %v", err)
+		}
+		if raster.FileExists(filepath.Join(archive, "missing.tif")) {
+			t.Errorf("a missing member was found")
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestHeaderOnly(t *testing.T) {
+	if testHeaderOnly {
+		dir, err := os.MkdirTemp("", "headertest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		files := []string{"./testdata/DEM.dep", "./testdata/DEM.rst", "./testdata/DEM.tif", "./testdata/Sample64Bit.tif"}
+		write := func(name string, b []byte) {
+			fileName := filepath.Join(dir, name)
+			if err := os.WriteFile(fileName, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, fileName)
+		}
+		write("grid.asc", []byte("ncols 2\nnrows 3\nxllcorner 0\nyllcorner 30\ndx 10\ndy -10\n1 2\n3 4\n5 6\n"))
+		write("grid.txt", []byte("north: 30\nsouth: 0\neast: 20\nwest: 0\nrows: 3\ncols: 2\n1 2\n3 4\n5 6\n"))
+		write("dem.img", erdasImagineFile(5, 7))
+		write("elev.nc", netCDFFile())
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_FLOAT32
+		config.EPSGCode = 26917
+		rout, err := raster.CreateNewRaster(filepath.Join(dir, "grid.flt"), 3, 2, 30, 0, 20, 0, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rout.Save(); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.Join(dir, "grid.flt"))
+
+		// the header matches that of the whole raster
+		for _, fileName := range files {
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatalf("%s: %v", fileName, err)
+			}
+			header, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{HeaderOnly: true})
+			if err != nil {
+				t.Fatalf("%s: %v", fileName, err)
+			}
+			if header.Rows != rin.Rows || header.Columns != rin.Columns || header.North != rin.North ||
+				header.South != rin.South || header.East != rin.East || header.West != rin.West ||
+				header.NoDataValue != rin.NoDataValue || header.RasterFormat != rin.RasterFormat {
+				t.Errorf("%s: the header differs from that of the raster", fileName)
+			}
+			c1, c2 := header.GetRasterConfig(), rin.GetRasterConfig()
+			if c1.EPSGCode != c2.EPSGCode || c1.DataType != c2.DataType || c1.SouthUp != c2.SouthUp {
+				t.Errorf("%s: the header's EPSG code %v, data type %v or row order differ", fileName,
+					c1.EPSGCode, c1.DataType)
+			}
+			// its data can't be used
+			if _, err = header.Data(); err != raster.HeaderOnlyError {
+				t.Errorf("%s: the data of a header were read: %v", fileName, err)
+			}
+			if err = header.Save(); err != raster.HeaderOnlyError {
+				t.Errorf("%s: a header was saved: %v", fileName, err)
+			}
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestBounds(t *testing.T) {
+	a := raster.Bounds{North: 100, South: 0, East: 100, West: 0}
+	b := raster.Bounds{North: 150, South: 50, East: 130, West: 80}
+	if i := a.Intersect(b); i != (raster.Bounds{North: 100, South: 50, East: 100, West: 80}) {
+		t.Errorf("intersection %v", i)
+	}
+	if u := a.Union(b); u != (raster.Bounds{North: 150, South: 0, East: 130, West: 0}) {
+		t.Errorf("union %v", u)
+	}
+	if u := (raster.Bounds{}).Union(b); u != b {
+		t.Errorf("the union with empty bounds is %v", u)
+	}
+
+	// bounds that only touch don't overlap
+	c := raster.Bounds{North: 100, South: 0, East: 200, West: 100}
+	if a.Overlaps(c) || !a.Intersect(c).IsEmpty() || !a.Overlaps(b) {
+		t.Error("the overlaps of the bounds are wrong")
+	}
+	if !a.Contains(100, 0) || a.Contains(100.1, 50) {
+		t.Error("the points within the bounds are wrong")
+	}
+	if !a.ContainsBounds(a.Expand(-10, -10)) || a.ContainsBounds(b) {
+		t.Error("the bounds within the bounds are wrong")
+	}
+
+	// snapped outwards to a grid of 30 x 20 cells with a corner at (5, 7),
+	// except where an edge is within rounding of a grid line
+	s := raster.Bounds{North: 66.9999999999, South: 20, East: 64, West: 36}.SnapToGrid(5, 7, 30, 20)
+	if s != (raster.Bounds{North: 67, South: 7, East: 65, West: 35}) {
+		t.Errorf("snapped bounds %v", s)
+	}
+	if rows, columns := s.Dimensions(30, 20); rows != 3 || columns != 1 {
+		t.Errorf("%v rows and %v columns, expected 3 and 1", rows, columns)
+	}
+}
+
+func TestSampleBilinear(t *testing.T) {
+	config := raster.NewDefaultRasterConfig()
+	config.RasterFormat = raster.RT_GeoTiff
+	config.DataType = raster.DT_FLOAT64
+	r, err := raster.CreateNewRaster("", 2, 2, 20, 0, 20, 0, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetRowValues(0, []float64{1, 2})
+	r.SetRowValues(1, []float64{3, 4})
+	nodata := r.NoDataValue
+	check := func(x, y, expected float64) {
+		t.Helper()
+		if z := r.SampleBilinear(x, y); math.Abs(z-expected) > 1e-9 {
+			t.Errorf("the value at (%v, %v) is %v, expected %v", x, y, z, expected)
+		}
+	}
+	check(5, 15, 1)
+	check(10, 10, 2.5)
+	check(7.5, 15, 1.25)
+	check(10, 7.5, 3)
+	// the weights of the cells beyond the edge are renormalized away
+	check(1, 15, 1)
+	check(19, 1, 4)
+	check(20, 0, 4)
+	check(0, 20, 1)
+	check(-1, 10, nodata)
+	check(10, 20.5, nodata)
+
+	// nodata neighbours are excluded, but a nodata cell has no value
+	r.SetValue(0, 1, nodata)
+	check(10, 10, 8.0/3)
+	check(7.5, 15, 1)
+	check(15, 15, nodata)
+}
+
+func TestPercentClipRange(t *testing.T) {
+	config := raster.NewDefaultRasterConfig()
+	config.RasterFormat = raster.RT_GeoTiff
+	config.DataType = raster.DT_FLOAT64
+	r, err := raster.CreateNewRaster("", 10, 10, 10, 0, 10, 0, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodata := r.NoDataValue
+	fill := func(value func(row, col int) float64) {
+		for row := 0; row < r.Rows; row++ {
+			for col := 0; col < r.Columns; col++ {
+				r.SetValue(row, col, value(row, col))
+			}
+		}
+	}
+	check := func(percent float64, sampleSize int, expectedMin, expectedMax float64, expectedOK bool) {
+		t.Helper()
+		min, max, ok := r.PercentClipRange(percent, sampleSize)
+		if ok != expectedOK || min != expectedMin || max != expectedMax {
+			t.Errorf("the %v%% range of %d cells is %v to %v (%v), expected %v to %v (%v)",
+				percent, sampleSize, min, max, ok, expectedMin, expectedMax, expectedOK)
+		}
+	}
+
+	// the values 1 to 100 with an outlier in place of 100
+	fill(func(row, col int) float64 { return float64(row*10 + col + 1) })
+	r.SetValue(9, 9, 1e6)
+	check(0, 0, 1, 1e6, true)
+	check(2, 0, 2, 99, true)
+	check(5, 0, 5, 96, true)
+	// the clip is limited to the median
+	check(60, 0, 50, 51, true)
+	// a 5 by 5 subgrid of every other row and column, starting at 12
+	check(0, 25, 12, 1e6, true)
+	check(5, 25, 14, 98, true)
+
+	// nodata cells are excluded
+	r.SetValue(0, 0, nodata)
+	r.SetValue(0, 1, math.NaN())
+	check(0, 0, 3, 1e6, true)
+
+	fill(func(row, col int) float64 { return nodata })
+	check(2, 0, 0, 0, false)
+	r.SetValue(5, 5, 7)
+	check(2, 0, 0, 0, false)
+
+	fill(func(row, col int) float64 { return 7 })
+	check(2, 0, 0, 0, false)
+	check(0, 25, 0, 0, false)
+}
+
+func TestCRS(t *testing.T) {
+	if testCRS {
+		for _, test := range []struct {
+			value    string
+			expected int
+		}{
+			{"EPSG:26917", 26917},
+			{" epsg: 4326\n", 4326},
+			{"EPSG:", 0},
+			{"EPSG:-1", 0},
+			{"EPSG:utm", 0},
+			{"26917", 0},
+			{"", 0},
+		} {
+			if code := raster.ParseEPSGString(test.value); code != test.expected {
+				t.Errorf("ParseEPSGString(%q) = %v, expected %v", test.value, code, test.expected)
+			}
+		}
+
+		// the code of a projected system is that of its outermost AUTHORITY
+		// node, not that of its geographic system
+		utm := `PROJCS["NAD83 / UTM zone 17N",GEOGCS["NAD83",DATUM["North_American_Datum_1983",` +
+			`SPHEROID["GRS 1980",6378137,298.257222101]],PRIMEM["Greenwich",0],` +
+			`UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","4269"]],PROJECTION["Transverse_Mercator"],` +
+			`PARAMETER["central_meridian",-81],PARAMETER["scale_factor",0.9996],` +
+			`PARAMETER["false_easting",500000],UNIT["metre",1],AUTHORITY["EPSG","26917"]]`
+		local := `PROJCS["Local_Grid",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",` +
+			`SPHEROID["WGS_1984",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["Degree",0.0174532925199433]],` +
+			`PROJECTION["Transverse_Mercator"],PARAMETER["Central_Meridian",-80.5],` +
+			`PARAMETER["Scale_Factor",1],PARAMETER["False_Easting",300000],UNIT["Meter",1]]`
+		for _, test := range []struct {
+			wkt      string
+			expected int
+		}{
+			{utm, 26917},
+			{local, 0},
+			{`PROJCS["ESRI_Albers",AUTHORITY["ESRI","102001"]]`, 0},
+			{"EPSG:3857", 3857},
+		} {
+			if code := raster.EPSGCodeFromWKT(test.wkt); code != test.expected {
+				t.Errorf("EPSGCodeFromWKT(%.30q...) = %v, expected %v", test.wkt, code, test.expected)
+			}
+		}
+
+		if raster.PrjFileName("dem.asc") != "dem.prj" || raster.PrjFileName("dem.asc.gz") != "dem.prj" {
+			t.Errorf("unexpected .prj names %v and %v", raster.PrjFileName("dem.asc"), raster.PrjFileName("dem.asc.gz"))
+		}
+
+		dir, err := os.MkdirTemp("", "crstest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// a system without an EPSG code is kept as WKT, in a .prj file for an
+		// ArcGIS raster and in the citation geokey of a GeoTIFF, and a code
+		// is written as "EPSG:code"
+		for _, test := range []struct {
+			file, wkt string
+			code      int
+			prj       string
+		}{
+			{"local.asc", local, 0, local},
+			{"utm.asc", "", 26917, "EPSG:26917"},
+			{"localgeotiff.tif", local, 0, ""},
+			{"utmgeotiff.tif", utm, 0, ""},
+		} {
+			fileName := filepath.Join(dir, test.file)
+			config := raster.NewDefaultRasterConfig()
+			config.CoordinateRefSystemWKT = test.wkt
+			config.EPSGCode = test.code
+			rout, err := raster.CreateNewRaster(fileName, 2, 2, 20, 0, 20, 0, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = rout.Save(); err != nil {
+				t.Fatal(err)
+			}
+			prj, err := os.ReadFile(raster.PrjFileName(fileName))
+			if test.prj == "" && err == nil {
+				t.Errorf("%v: a .prj file was written", test.file)
+			} else if test.prj != "" && strings.TrimSpace(string(prj)) != test.prj {
+				t.Errorf("%v: the .prj file holds %q, expected %q", test.file, prj, test.prj)
+			}
+			rin, err := raster.CreateRasterFromFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := test.code
+			if test.wkt != "" {
+				code = raster.EPSGCodeFromWKT(test.wkt)
+			}
+			c := rin.GetRasterConfig()
+			if c.EPSGCode != code {
+				t.Errorf("%v: read EPSG code %v, expected %v", test.file, c.EPSGCode, code)
+			}
+			if code == 0 && c.CoordinateRefSystemWKT != test.wkt {
+				t.Errorf("%v: read WKT %q, expected %q", test.file, c.CoordinateRefSystemWKT, test.wkt)
+			}
+		}
+
+		// a .prj file written by other software, holding WKT with a code
+		fileName := filepath.Join(dir, "other.asc")
+		if err = os.WriteFile(fileName, []byte("ncols 1\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n5\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(raster.PrjFileName(fileName), []byte(utm+"\r\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rin, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := rin.GetRasterConfig(); c.EPSGCode != 26917 || c.CoordinateRefSystemWKT != utm {
+			t.Errorf("read EPSG code %v and WKT %.30q...", c.EPSGCode, c.CoordinateRefSystemWKT)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+var testShapefile = true
+var testGeoJSON = true
+
+func TestShapefile(t *testing.T) {
+	if testShapefile {
+		dir, err := os.MkdirTemp("", "vectortest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// points with attributes of each field type, and a null shape
+		fields := []vector.Field{
+			{Name: "NAME", Type: 'C', Length: 12},
+			{Name: "VALUE", Type: 'N', Decimals: 3},
+			{Name: "VALID", Type: 'L'},
+			{Name: "SURVEYED", Type: 'D'},
+		}
+		sf, err := vector.CreateNewShapefile(filepath.Join(dir, "points"), vector.ST_Point, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf.EPSGCode = 26917
+		date := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+		if err = sf.AddShape(vector.NewPoint(500000.5, 4800000.25), "outlet", 12.5, true, date); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.AddShape(vector.NewPoint(500100, 4800100), "gauge", -3, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.AddShape(vector.Shape{}, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.AddShape(vector.NewPoint(0, 0), "too few"); err != vector.FieldCountError {
+			t.Errorf("AddShape with too few values returned %v", err)
+		}
+		if err = sf.AddShape(vector.NewPoint(0, 0), "a name that is too long", 1, true, nil); err == nil {
+			t.Error("AddShape accepted a value longer than its field")
+		}
+		if err = sf.AddShape(vector.NewPolyLine([][2]float64{{0, 0}, {1, 1}}), "line", 1, true, nil); err != vector.ShapeTypeMismatchError {
+			t.Errorf("AddShape of a line to a point shapefile returned %v", err)
+		}
+		if err = sf.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		in, err := vector.CreateShapefileFromFile(filepath.Join(dir, "points.shp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.ShapeType != vector.ST_Point || in.NumShapes() != 3 || len(in.Fields) != 4 {
+			t.Fatalf("read %v shapes of type %v with %v fields", in.NumShapes(), in.ShapeType, len(in.Fields))
+		}
+		if p := in.GetShape(0).Parts[0][0]; p.X != 500000.5 || p.Y != 4800000.25 {
+			t.Errorf("the first point was read as %v", p)
+		}
+		if len(in.GetShape(2).Parts) != 0 {
+			t.Error("the null shape was read with parts")
+		}
+		if v := in.GetAttribute(0, "name"); v != "outlet" {
+			t.Errorf("NAME was read as %v", v)
+		}
+		if v := in.GetAttribute(1, "VALUE"); v != -3.0 {
+			t.Errorf("VALUE was read as %v", v)
+		}
+		if v := in.GetAttribute(1, "VALID"); v != false {
+			t.Errorf("VALID was read as %v", v)
+		}
+		if v, ok := in.GetAttribute(0, "SURVEYED").(time.Time); !ok || !v.Equal(date) {
+			t.Errorf("SURVEYED was read as %v", in.GetAttribute(0, "SURVEYED"))
+		}
+		if v := in.GetRecord(2); v[0] != "" || v[1] != nil || v[2] != nil || v[3] != nil {
+			t.Errorf("the blank record was read as %v", v)
+		}
+		if in.EPSGCode != 26917 || !strings.Contains(in.CoordinateRefSystemWKT, "UTM zone 17N") {
+			t.Errorf("the CRS was read as EPSG %v, %q", in.EPSGCode, in.CoordinateRefSystemWKT)
+		}
+
+		// the index holds the offset and length of each record, in 16-bit words
+		shp, _ := os.ReadFile(filepath.Join(dir, "points.shp"))
+		shx, err := os.ReadFile(filepath.Join(dir, "points.shx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(shx[0:4]) != 9994 || len(shx) != 100+3*8 {
+			t.Fatalf("the index is %v bytes long", len(shx))
+		}
+		if int(binary.BigEndian.Uint32(shp[24:28]))*2 != len(shp) {
+			t.Error("the file length in the .shp header is wrong")
+		}
+		offsets := []uint32{50, 64, 78}
+		lengths := []uint32{10, 10, 2}
+		for i := range offsets {
+			offset := binary.BigEndian.Uint32(shx[100+8*i:])
+			length := binary.BigEndian.Uint32(shx[104+8*i:])
+			if offset != offsets[i] || length != lengths[i] {
+				t.Errorf("record %v is indexed at %v, %v", i, offset, length)
+			}
+		}
+
+		// a PolyLineZ with measures on only some points
+		sf, err = vector.CreateNewShapefile(filepath.Join(dir, "lines.shp"), vector.ST_PolyLineZ, []vector.Field{{Name: "ID", Type: 'N', Length: 6}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := vector.Shape{Parts: [][]vector.Point{
+			{{X: 0, Y: 0, Z: 10, M: 0}, {X: 10, Y: 0, Z: 9, M: vector.NoMeasure}},
+			{{X: 10, Y: 5, Z: 8, M: 2}, {X: 20, Y: 5, Z: 7, M: 3}, {X: 20, Y: 15, Z: 6, M: 4}},
+		}}
+		if err = sf.AddShape(line, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "lines.prj")); err == nil {
+			t.Error("a .prj file was written without a CRS")
+		}
+		if in, err = vector.CreateShapefileFromFile(filepath.Join(dir, "lines.dbf")); err != nil {
+			t.Fatal(err)
+		}
+		got := in.GetShape(0)
+		if in.ShapeType != vector.ST_PolyLineZ || len(got.Parts) != 2 || len(got.Parts[1]) != 3 {
+			t.Fatalf("the line was read as %v", got)
+		}
+		for i := range line.Parts {
+			for j, p := range line.Parts[i] {
+				if got.Parts[i][j] != p {
+					t.Errorf("point %v of part %v was read as %v, not %v", j, i, got.Parts[i][j], p)
+				}
+			}
+		}
+		if minX, minY, maxX, maxY := in.Bounds(); minX != 0 || minY != 0 || maxX != 20 || maxY != 15 {
+			t.Errorf("the bounds were read as %v, %v, %v, %v", minX, minY, maxX, maxY)
+		}
+
+		// a polygon with a hole, and a WKT coordinate reference system
+		sf, err = vector.CreateNewShapefile(filepath.Join(dir, "polygons"), vector.ST_Polygon, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wkt := `PROJCS["Local grid"]`
+		sf.CoordinateRefSystemWKT = wkt
+		polygon := vector.NewPolygon([][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}})
+		hole := vector.NewPolygon([][2]float64{{2, 2}, {2, 4}, {4, 4}, {4, 2}}).Parts[0]
+		for i, j := 0, len(hole)-1; i < j; i, j = i+1, j-1 {
+			hole[i], hole[j] = hole[j], hole[i]
+		}
+		polygon.Parts = append(polygon.Parts, hole)
+		if !vector.IsClockwise(polygon.Parts[0]) || vector.IsClockwise(polygon.Parts[1]) || len(polygon.Parts[0]) != 5 {
+			t.Fatal("NewPolygon didn't close the ring clockwise")
+		}
+		if err = sf.AddShape(polygon); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if in, err = vector.CreateShapefileFromFile(filepath.Join(dir, "polygons.shp")); err != nil {
+			t.Fatal(err)
+		}
+		got = in.GetShape(0)
+		if len(got.Parts) != 2 || len(got.Parts[1]) != 5 || vector.IsClockwise(got.Parts[1]) {
+			t.Errorf("the polygon was read as %v", got)
+		}
+		if in.CoordinateRefSystemWKT != wkt {
+			t.Errorf("the WKT was read as %q", in.CoordinateRefSystemWKT)
+		}
+
+		// invalid fields and files
+		if _, err = vector.CreateNewShapefile(filepath.Join(dir, "bad"), vector.ST_Point, []vector.Field{{Name: "A", Type: 'C'}, {Name: "a", Type: 'N'}}); err == nil {
+			t.Error("fields with the same name were accepted")
+		}
+		if _, err = vector.CreateNewShapefile(filepath.Join(dir, "bad"), vector.ShapeType(31), nil); err != vector.UnsupportedShapeTypeError {
+			t.Errorf("an unknown shape type returned %v", err)
+		}
+		os.WriteFile(filepath.Join(dir, "bad.shp"), make([]byte, 100), 0644)
+		if _, err = vector.CreateShapefileFromFile(filepath.Join(dir, "bad.shp")); err != vector.FileIsNotProperlyFormated {
+			t.Errorf("a file without the shapefile file code returned %v", err)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	if testGeoJSON {
+		dir, err := os.MkdirTemp("", "vectortest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		type geoJSON struct {
+			Type     string
+			CRS      *struct{ Properties struct{ Name string } }
+			Features []struct {
+				Type     string
+				Geometry *struct {
+					Type        string
+					Coordinates json.RawMessage
+				}
+				Properties map[string]interface{}
+			}
+		}
+
+		// points in UTM coordinates are written as longitudes and latitudes
+		fields := []vector.Field{{Name: "NAME", Type: 'C'}, {Name: "AREA", Type: 'N', Decimals: 2}, {Name: "DATE", Type: 'D'}}
+		sf, err := vector.CreateNewShapefile(filepath.Join(dir, "outlets.geojson"), vector.ST_Point, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf.EPSGCode = 32617 // WGS 84 / UTM zone 17N, whose central meridian is 81 W
+		if err = sf.AddShape(vector.NewPoint(500000, 0), "outlet \"A\"", 12.5, time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.AddShape(vector.Shape{}, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = sf.Save(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "outlets.geojson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fc geoJSON
+		if err = json.Unmarshal(b, &fc); err != nil {
+			t.Fatalf("the GeoJSON isn't valid JSON: %v\n%s", err, b)
+		}
+		if fc.Type != "FeatureCollection" || len(fc.Features) != 2 || fc.CRS != nil {
+			t.Fatalf("unexpected GeoJSON:\n%s", b)
+		}
+		var pos []float64
+		json.Unmarshal(fc.Features[0].Geometry.Coordinates, &pos)
+		if fc.Features[0].Geometry.Type != "Point" || len(pos) != 2 || math.Abs(pos[0]+81) > 1e-9 || math.Abs(pos[1]) > 1e-9 {
+			t.Errorf("the point was written as %s", fc.Features[0].Geometry.Coordinates)
+		}
+		props := fc.Features[0].Properties
+		if props["NAME"] != "outlet \"A\"" || props["AREA"] != 12.5 || props["DATE"] != "2015-12-01" {
+			t.Errorf("the properties were written as %v", props)
+		}
+		if fc.Features[1].Geometry != nil || fc.Features[1].Properties["NAME"] != nil {
+			t.Errorf("the null shape was written as %v", fc.Features[1])
+		}
+		if !bytes.Contains(b, []byte(`"properties":{"NAME":`)) {
+			t.Error("the properties weren't written in the order of the fields")
+		}
+
+		// a polygon with a hole, in a local grid, keeps its coordinates and
+		// names its CRS; the rings are reoriented for GeoJSON
+		sf, err = vector.CreateNewShapefile(filepath.Join(dir, "basins.json"), vector.ST_PolygonZ, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf.EPSGCode = 27700
+		polygon := vector.NewPolygon([][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}})
+		hole := vector.NewPolygon([][2]float64{{2, 2}, {2, 4}, {4, 4}, {4, 2}}).Parts[0]
+		for i, j := 0, len(hole)-1; i < j; i, j = i+1, j-1 {
+			hole[i], hole[j] = hole[j], hole[i]
+		}
+		island := vector.NewPolygon([][2]float64{{20, 20}, {25, 20}, {25, 25}})
+		polygon.Parts = append(polygon.Parts, island.Parts[0], hole)
+		if err = sf.AddShape(polygon); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = sf.WriteGeoJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(buf.Bytes(), &fc); err != nil {
+			t.Fatalf("the GeoJSON isn't valid JSON: %v\n%s", err, buf.Bytes())
+		}
+		if fc.CRS == nil || fc.CRS.Properties.Name != "urn:ogc:def:crs:EPSG::27700" {
+			t.Errorf("the CRS wasn't named:\n%s", buf.Bytes())
+		}
+		var rings [][][][3]float64
+		if err = json.Unmarshal(fc.Features[0].Geometry.Coordinates, &rings); err != nil {
+			t.Fatal(err)
+		}
+		if fc.Features[0].Geometry.Type != "MultiPolygon" || len(rings) != 2 || len(rings[0]) != 2 || len(rings[1]) != 1 {
+			t.Fatalf("the polygon was written as %s", fc.Features[0].Geometry.Coordinates)
+		}
+		clockwise := func(ring [][3]float64) bool {
+			points := make([]vector.Point, len(ring))
+			for i, p := range ring {
+				points[i] = vector.Point{X: p[0], Y: p[1]}
+			}
+			return vector.IsClockwise(points)
+		}
+		if clockwise(rings[0][0]) || !clockwise(rings[0][1]) || clockwise(rings[1][0]) || rings[0][1][0][0] > 4 {
+			t.Errorf("the rings were written as %s", fc.Features[0].Geometry.Coordinates)
+		}
+	} else {
+		t.SkipNow()
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package vector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Field is an attribute field of a shapefile, stored in its dBASE (.dbf)
+// table. Its Type is 'C' (character), 'N' or 'F' (numeric), 'L' (logical) or
+// 'D' (date), whose values are read as string, float64, bool and time.Time
+// respectively, or nil where they are blank. A Length of zero is given the
+// default for the type.
+type Field struct {
+	Name     string // at most 10 characters
+	Type     byte
+	Length   int
+	Decimals int
+}
+
+// validate checks a field and sets its default length.
+func (f *Field) validate() error {
+	if f.Name == "" || len(f.Name) > 10 {
+		return &FieldError{f.Name, "the name must be from 1 to 10 characters long"}
+	}
+	maxLength := 0
+	switch f.Type {
+	case 'C':
+		if f.Length == 0 {
+			f.Length = 80
+		}
+		maxLength = 254
+	case 'N', 'F':
+		if f.Length == 0 {
+			f.Length = 18
+		}
+		maxLength = 20
+	case 'L':
+		f.Length, maxLength = 1, 1
+	case 'D':
+		f.Length, maxLength = 8, 8
+	default:
+		return &FieldError{f.Name, fmt.Sprintf("unsupported field type '%c'", f.Type)}
+	}
+	if f.Length < 1 || f.Length > maxLength {
+		return &FieldError{f.Name, fmt.Sprintf("the length must be from 1 to %v", maxLength)}
+	}
+	if f.Decimals < 0 || (f.Decimals > 0 && (f.Type == 'C' || f.Decimals >= f.Length-1)) {
+		return &FieldError{f.Name, "invalid number of decimal places"}
+	}
+	return nil
+}
+
+// format formats an attribute value to the width of the field.
+func (f *Field) format(value interface{}) (string, error) {
+	var s string
+	switch f.Type {
+	case 'C':
+		if value != nil {
+			s = fmt.Sprint(value)
+		}
+		if len(s) > f.Length {
+			return "", &FieldError{f.Name, fmt.Sprintf("'%s' is longer than %v characters", s, f.Length)}
+		}
+		return s + strings.Repeat(" ", f.Length-len(s)), nil
+	case 'N', 'F':
+		if value != nil {
+			v, ok := toFloat(value)
+			if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+				return "", &FieldError{f.Name, fmt.Sprintf("%v is not a number", value)}
+			}
+			s = strconv.FormatFloat(v, 'f', f.Decimals, 64)
+			if len(s) > f.Length {
+				return "", &FieldError{f.Name, fmt.Sprintf("%v is wider than %v characters", value, f.Length)}
+			}
+		}
+		return strings.Repeat(" ", f.Length-len(s)) + s, nil
+	case 'L':
+		switch v := value.(type) {
+		case nil:
+			return "?", nil
+		case bool:
+			if v {
+				return "T", nil
+			}
+			return "F", nil
+		}
+		return "", &FieldError{f.Name, fmt.Sprintf("%v is not a bool", value)}
+	case 'D':
+		switch v := value.(type) {
+		case nil:
+			return "        ", nil
+		case time.Time:
+			return v.Format("20060102"), nil
+		}
+		return "", &FieldError{f.Name, fmt.Sprintf("%v is not a time.Time", value)}
+	}
+	return "", &FieldError{f.Name, "unsupported field type"}
+}
+
+// parse reads an attribute value of the field.
+func (f *Field) parse(s string) interface{} {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	switch f.Type {
+	case 'N', 'F':
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+		return nil
+	case 'L':
+		switch strings.ToUpper(s) {
+		case "T", "Y":
+			return true
+		case "F", "N":
+			return false
+		}
+		return nil
+	case 'D':
+		if t, err := time.Parse("20060102", s); err == nil {
+			return t
+		}
+		return nil
+	}
+	return s
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// deterministicDate is the date of last update written in the header of a
+// dBASE file, in place of the current date, when raster.DeterministicOutput
+// is set.
+var deterministicDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeDbf writes the attribute table of a shapefile as a dBASE III file.
+func writeDbf(w io.Writer, fields []Field, records [][]interface{}) error {
+	recordLength := 1 // the deletion flag
+	for _, f := range fields {
+		recordLength += f.Length
+	}
+	headerLength := 32 + 32*len(fields) + 1
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 32)
+	header[0] = 3
+	now := time.Now()
+	if raster.DeterministicOutput {
+		now = deterministicDate
+	}
+	header[1], header[2], header[3] = byte(now.Year()-1900), byte(now.Month()), byte(now.Day())
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(records)))
+	binary.LittleEndian.PutUint16(header[8:10], uint16(headerLength))
+	binary.LittleEndian.PutUint16(header[10:12], uint16(recordLength))
+	bw.Write(header)
+	for _, f := range fields {
+		desc := make([]byte, 32)
+		copy(desc[0:11], f.Name)
+		desc[11] = f.Type
+		desc[16] = byte(f.Length)
+		desc[17] = byte(f.Decimals)
+		bw.Write(desc)
+	}
+	bw.WriteByte(0x0D)
+	for _, record := range records {
+		bw.WriteByte(' ')
+		for i := range fields {
+			s, err := fields[i].format(record[i])
+			if err != nil {
+				return err
+			}
+			bw.WriteString(s)
+		}
+	}
+	bw.WriteByte(0x1A)
+	return bw.Flush()
+}
+
+// readDbf reads the attribute table of a shapefile. Deleted records are read
+// as blank records, so that the records stay in step with the shapes.
+func readDbf(b []byte) ([]Field, [][]interface{}, error) {
+	if len(b) < 32 {
+		return nil, nil, FileIsNotProperlyFormated
+	}
+	numRecords := int(binary.LittleEndian.Uint32(b[4:8]))
+	headerLength := int(binary.LittleEndian.Uint16(b[8:10]))
+	recordLength := int(binary.LittleEndian.Uint16(b[10:12]))
+	if headerLength > len(b) || recordLength < 1 {
+		return nil, nil, FileIsNotProperlyFormated
+	}
+	var fields []Field
+	offsets := []int{1}
+	for pos := 32; pos+32 <= headerLength && b[pos] != 0x0D; pos += 32 {
+		desc := b[pos : pos+32]
+		name := string(desc[0:11])
+		if i := strings.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		f := Field{Name: strings.TrimSpace(name), Type: desc[11], Length: int(desc[16]), Decimals: int(desc[17])}
+		if f.Type == 'C' {
+			// long character fields use the decimal count as the high byte
+			f.Length += f.Decimals << 8
+			f.Decimals = 0
+		}
+		fields = append(fields, f)
+		offsets = append(offsets, offsets[len(offsets)-1]+f.Length)
+	}
+	if offsets[len(offsets)-1] > recordLength {
+		return nil, nil, FileIsNotProperlyFormated
+	}
+	records := make([][]interface{}, 0, numRecords)
+	for i := 0; i < numRecords; i++ {
+		start := headerLength + i*recordLength
+		if start+recordLength > len(b) {
+			return nil, nil, FileIsNotProperlyFormated
+		}
+		rec := b[start : start+recordLength]
+		values := make([]interface{}, len(fields))
+		if rec[0] != '*' {
+			for j := range fields {
+				values[j] = fields[j].parse(string(rec[offsets[j]:offsets[j+1]]))
+			}
+		}
+		records = append(records, values)
+	}
+	return fields, records, nil
+}
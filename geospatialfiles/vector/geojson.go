@@ -0,0 +1,261 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package vector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
+)
+
+// isGeoJSONFile reports whether a file name has a GeoJSON extension.
+func isGeoJSONFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".geojson", ".json":
+		return true
+	}
+	return false
+}
+
+// wgs84Datums are the geographic systems whose datums are within a metre or
+// two of WGS 84, and so may be written as WGS 84 in GeoJSON.
+var wgs84Datums = map[int]bool{
+	4326: true, 4269: true, 4617: true, 4258: true, 4171: true, 4283: true,
+	7844: true, 4167: true, 4674: true, 4612: true, 4148: true,
+}
+
+// saveGeoJSON writes the shapes to the GeoJSON file of the shapefile.
+func (s *Shapefile) saveGeoJSON() error {
+	f, err := os.Create(s.fileName)
+	if err != nil {
+		return FileWritingError
+	}
+	defer f.Close()
+	return s.WriteGeoJSON(f)
+}
+
+// WriteGeoJSON writes the shapes, with their attributes as properties, as a
+// GeoJSON FeatureCollection. Coordinates are converted to WGS 84 longitudes
+// and latitudes, as RFC 7946 requires, where the coordinate reference system
+// is one whose projection is supported, given by its EPSG code; otherwise
+// they are written as they are, and the EPSG code, if any, is named by a
+// "crs" member, as in the 2008 GeoJSON specification. Measures are dropped,
+// since GeoJSON has no place for them.
+func (s *Shapefile) WriteGeoJSON(w io.Writer) error {
+	var crs *epsg.CRS
+	if c, ok := epsg.Lookup(s.EPSGCode); ok && wgs84Datums[c.GeographicCode] {
+		crs = &c
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"type":"FeatureCollection",`)
+	if crs == nil && s.EPSGCode > 0 {
+		fmt.Fprintf(bw, `"crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::%d"}},`, s.EPSGCode)
+	}
+	bw.WriteString("\"features\":[\n")
+	for i, shape := range s.shapes {
+		if i > 0 {
+			bw.WriteString(",\n")
+		}
+		bw.WriteString(`{"type":"Feature","geometry":`)
+		geometry, err := s.geoJSONGeometry(shape, crs)
+		if err != nil {
+			return err
+		}
+		bw.WriteString(geometry)
+		bw.WriteString(`,"properties":{`)
+		for j, f := range s.Fields {
+			if j > 0 {
+				bw.WriteByte(',')
+			}
+			name, _ := json.Marshal(f.Name)
+			bw.Write(name)
+			bw.WriteByte(':')
+			bw.WriteString(geoJSONValue(f, s.records[i][j]))
+		}
+		bw.WriteString("}}")
+	}
+	bw.WriteString("\n]}\n")
+	if err := bw.Flush(); err != nil {
+		return FileWritingError
+	}
+	return nil
+}
+
+// geoJSONValue returns an attribute value as JSON, formatted as it would be
+// stored in the field. Dates are written as YYYY-MM-DD.
+func geoJSONValue(f Field, value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	switch f.Type {
+	case 'C':
+		b, _ := json.Marshal(fmt.Sprint(value))
+		return string(b)
+	case 'N', 'F':
+		if v, ok := toFloat(value); ok {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	case 'L':
+		if v, ok := value.(bool); ok {
+			return strconv.FormatBool(v)
+		}
+	case 'D':
+		if v, ok := value.(time.Time); ok {
+			return `"` + v.Format("2006-01-02") + `"`
+		}
+	}
+	return "null"
+}
+
+// geoJSONGeometry returns the geometry of a shape as JSON, in the WGS 84
+// longitudes and latitudes of its coordinates where crs isn't nil. A
+// polyline of several parts is a MultiLineString, and a polygon of several
+// outer rings a MultiPolygon.
+func (s *Shapefile) geoJSONGeometry(shape Shape, crs *epsg.CRS) (string, error) {
+	if len(shape.Parts) == 0 {
+		return "null", nil
+	}
+	var sb strings.Builder
+	var err error
+	position := func(p Point) {
+		x, y := p.X, p.Y
+		if crs != nil && err == nil {
+			x, y, err = crs.Inverse(x, y)
+		}
+		sb.WriteByte('[')
+		sb.WriteString(strconv.FormatFloat(x, 'f', -1, 64))
+		sb.WriteByte(',')
+		sb.WriteString(strconv.FormatFloat(y, 'f', -1, 64))
+		if s.ShapeType.HasZ() {
+			sb.WriteByte(',')
+			sb.WriteString(strconv.FormatFloat(p.Z, 'f', -1, 64))
+		}
+		sb.WriteByte(']')
+	}
+	positions := func(points []Point) {
+		sb.WriteByte('[')
+		for i, p := range points {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			position(p)
+		}
+		sb.WriteByte(']')
+	}
+
+	switch s.ShapeType.baseType() {
+	case ST_Point:
+		sb.WriteString(`{"type":"Point","coordinates":`)
+		position(shape.Parts[0][0])
+	case ST_MultiPoint:
+		sb.WriteString(`{"type":"MultiPoint","coordinates":`)
+		positions(shape.Points())
+	case ST_PolyLine:
+		if len(shape.Parts) == 1 {
+			sb.WriteString(`{"type":"LineString","coordinates":`)
+			positions(shape.Parts[0])
+			break
+		}
+		sb.WriteString(`{"type":"MultiLineString","coordinates":[`)
+		for i, part := range shape.Parts {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			positions(part)
+		}
+		sb.WriteByte(']')
+	case ST_Polygon:
+		polygons := groupRings(shape.Parts)
+		if len(polygons) == 1 {
+			sb.WriteString(`{"type":"Polygon","coordinates":[`)
+		} else {
+			sb.WriteString(`{"type":"MultiPolygon","coordinates":[`)
+		}
+		for i, rings := range polygons {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if len(polygons) > 1 {
+				sb.WriteByte('[')
+			}
+			for j, ring := range rings {
+				if j > 0 {
+					sb.WriteByte(',')
+				}
+				positions(ring)
+			}
+			if len(polygons) > 1 {
+				sb.WriteByte(']')
+			}
+		}
+		sb.WriteByte(']')
+	}
+	sb.WriteByte('}')
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// groupRings groups the rings of a polygon into polygons of an outer ring
+// and its holes, each hole going with the outer ring that contains it. The
+// rings are oriented as GeoJSON requires, i.e. outer rings counter-clockwise
+// and holes clockwise, which is the reverse of shapefiles.
+func groupRings(rings [][]Point) [][][]Point {
+	var polygons [][][]Point
+	var holes [][]Point
+	for _, ring := range rings {
+		if IsClockwise(ring) {
+			polygons = append(polygons, [][]Point{reversed(ring)})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, hole := range holes {
+		if len(polygons) == 0 {
+			// a lone counter-clockwise ring is taken to be an outer ring
+			polygons = append(polygons, [][]Point{hole})
+			continue
+		}
+		owner := len(polygons) - 1
+		for i := range polygons {
+			if len(hole) > 0 && ringContains(polygons[i][0], hole[0]) {
+				owner = i
+				break
+			}
+		}
+		polygons[owner] = append(polygons[owner], reversed(hole))
+	}
+	return polygons
+}
+
+func reversed(ring []Point) []Point {
+	ret := make([]Point, len(ring))
+	for i, p := range ring {
+		ret[len(ring)-1-i] = p
+	}
+	return ret
+}
+
+// ringContains reports whether a point is inside a ring, by the even-odd
+// rule.
+func ringContains(ring []Point, p Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,519 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package vector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+var be = binary.BigEndian
+var le = binary.LittleEndian
+
+// Shapefile is an ESRI shapefile: shapes of a single type, stored in its
+// .shp file and indexed by its .shx file, each with a record of attribute
+// values in its .dbf file, and the coordinate reference system of the shapes
+// in its .prj file.
+type Shapefile struct {
+	fileName               string
+	ShapeType              ShapeType
+	Fields                 []Field
+	shapes                 []Shape
+	records                [][]interface{}
+	CoordinateRefSystemWKT string
+	EPSGCode               int
+}
+
+// CreateNewShapefile creates an empty shapefile of the shape type with the
+// attribute fields. Shapes are added with AddShape and the files are written
+// by Save. A file name with a .geojson or .json extension is written as a
+// GeoJSON file instead; otherwise the .shp extension is added to the file
+// name if it lacks it.
+func CreateNewShapefile(fileName string, shapeType ShapeType, fields []Field) (*Shapefile, error) {
+	if !shapeType.isValid() || shapeType == ST_Null {
+		return nil, UnsupportedShapeTypeError
+	}
+	s := &Shapefile{fileName: shpFileName(fileName), ShapeType: shapeType}
+	names := make(map[string]bool)
+	for _, f := range fields {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		if names[strings.ToUpper(f.Name)] {
+			return nil, &FieldError{f.Name, "the name is used by another field"}
+		}
+		names[strings.ToUpper(f.Name)] = true
+		s.Fields = append(s.Fields, f)
+	}
+	return s, nil
+}
+
+// CreateShapefileFromFile reads a shapefile, with its attribute table and
+// coordinate reference system where it has them.
+func CreateShapefileFromFile(fileName string) (*Shapefile, error) {
+	s := &Shapefile{fileName: shpFileName(fileName)}
+	if isGeoJSONFile(s.fileName) {
+		return nil, errors.New("GeoJSON files can be written but not read.")
+	}
+	b, err := ioutil.ReadFile(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.readShp(b); err != nil {
+		return nil, err
+	}
+
+	if b, err = ioutil.ReadFile(s.sidecarName(".dbf")); err == nil {
+		if s.Fields, s.records, err = readDbf(b); err != nil {
+			return nil, err
+		}
+		if len(s.records) != len(s.shapes) {
+			return nil, errors.New("The attribute table and the shapes of the shapefile don't match.")
+		}
+	} else {
+		s.records = make([][]interface{}, len(s.shapes))
+	}
+
+	if b, err = ioutil.ReadFile(s.sidecarName(".prj")); err == nil {
+		str := strings.TrimSpace(string(b))
+		if code := raster.ParseEPSGString(str); code > 0 {
+			s.EPSGCode = code
+		} else {
+			s.CoordinateRefSystemWKT = str
+			s.EPSGCode = raster.EPSGCodeFromWKT(str)
+		}
+	}
+	return s, nil
+}
+
+// shpFileName returns the name of the .shp file of a shapefile, given the
+// name of any of its files or of none, or the name of a GeoJSON file.
+func shpFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".shp", ".geojson", ".json":
+		return fileName
+	case ".shx", ".dbf", ".prj":
+		return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".shp"
+	}
+	return fileName + ".shp"
+}
+
+func (s *Shapefile) sidecarName(ext string) string {
+	return strings.TrimSuffix(s.fileName, filepath.Ext(s.fileName)) + ext
+}
+
+// GetFileName returns the name of the .shp or GeoJSON file.
+func (s *Shapefile) GetFileName() string {
+	return s.fileName
+}
+
+// NumShapes returns the number of shapes.
+func (s *Shapefile) NumShapes() int {
+	return len(s.shapes)
+}
+
+// GetShape returns a shape. A null shape has no parts.
+func (s *Shapefile) GetShape(i int) Shape {
+	return s.shapes[i]
+}
+
+// GetRecord returns the attribute values of a shape, in the order of the
+// fields.
+func (s *Shapefile) GetRecord(i int) []interface{} {
+	return s.records[i]
+}
+
+// GetAttribute returns an attribute value of a shape, or nil if it is blank
+// or there is no such field.
+func (s *Shapefile) GetAttribute(i int, fieldName string) interface{} {
+	for j, f := range s.Fields {
+		if strings.EqualFold(f.Name, fieldName) {
+			return s.records[i][j]
+		}
+	}
+	return nil
+}
+
+// AddShape adds a shape, with its attribute values in the order of the
+// fields. A shape without parts is written as a null shape.
+func (s *Shapefile) AddShape(shape Shape, values ...interface{}) error {
+	if len(values) != len(s.Fields) {
+		return FieldCountError
+	}
+	if len(shape.Parts) > 0 {
+		switch s.ShapeType.baseType() {
+		case ST_Point:
+			if len(shape.Parts) != 1 || len(shape.Parts[0]) != 1 {
+				return ShapeTypeMismatchError
+			}
+		case ST_MultiPoint:
+			if len(shape.Parts) != 1 {
+				return ShapeTypeMismatchError
+			}
+		case ST_PolyLine, ST_Polygon:
+			for _, part := range shape.Parts {
+				if len(part) < 2 {
+					return ShapeTypeMismatchError
+				}
+			}
+		}
+	}
+	for i := range s.Fields {
+		if _, err := s.Fields[i].format(values[i]); err != nil {
+			return err
+		}
+	}
+	s.shapes = append(s.shapes, shape)
+	s.records = append(s.records, values)
+	return nil
+}
+
+// Bounds returns the extent of the shapes.
+func (s *Shapefile) Bounds() (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, shape := range s.shapes {
+		x0, y0, x1, y1 := shape.Bounds()
+		minX, minY = math.Min(minX, x0), math.Min(minY, y0)
+		maxX, maxY = math.Max(maxX, x1), math.Max(maxY, y1)
+	}
+	return minX, minY, maxX, maxY
+}
+
+// Save writes the .shp, .shx and .dbf files of the shapefile, and its .prj
+// file if it has a coordinate reference system, which is written as WKT
+// where it is given as an EPSG code in the registry. A GeoJSON file is
+// written by WriteGeoJSON.
+func (s *Shapefile) Save() error {
+	if isGeoJSONFile(s.fileName) {
+		return s.saveGeoJSON()
+	}
+	// the shapes are encoded first, for the lengths in the headers
+	contents := make([][]byte, len(s.shapes))
+	length := 100
+	for i, shape := range s.shapes {
+		contents[i] = s.encodeShape(shape)
+		length += 8 + len(contents[i])
+	}
+	minX, minY, maxX, maxY := s.Bounds()
+	minZ, maxZ, minM, maxM := s.zmRanges()
+	if len(s.shapes) == 0 || math.IsInf(minX, 0) {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+	header := make([]byte, 100)
+	be.PutUint32(header[0:4], 9994)
+	le.PutUint32(header[28:32], 1000)
+	le.PutUint32(header[32:36], uint32(s.ShapeType))
+	for i, v := range []float64{minX, minY, maxX, maxY, minZ, maxZ, minM, maxM} {
+		le.PutUint64(header[36+8*i:], math.Float64bits(v))
+	}
+
+	shp, err := os.Create(s.fileName)
+	if err != nil {
+		return FileWritingError
+	}
+	defer shp.Close()
+	shx, err := os.Create(s.sidecarName(".shx"))
+	if err != nil {
+		return FileWritingError
+	}
+	defer shx.Close()
+	shpw, shxw := bufio.NewWriter(shp), bufio.NewWriter(shx)
+
+	be.PutUint32(header[24:28], uint32(length/2))
+	shpw.Write(header)
+	be.PutUint32(header[24:28], uint32((100+8*len(s.shapes))/2))
+	shxw.Write(header)
+	offset := 100
+	rec := make([]byte, 8)
+	for i, content := range contents {
+		be.PutUint32(rec[0:4], uint32(i+1))
+		be.PutUint32(rec[4:8], uint32(len(content)/2))
+		shpw.Write(rec)
+		shpw.Write(content)
+		be.PutUint32(rec[0:4], uint32(offset/2))
+		shxw.Write(rec)
+		offset += 8 + len(content)
+	}
+	if err = shpw.Flush(); err != nil {
+		return FileWritingError
+	}
+	if err = shxw.Flush(); err != nil {
+		return FileWritingError
+	}
+
+	dbf, err := os.Create(s.sidecarName(".dbf"))
+	if err != nil {
+		return FileWritingError
+	}
+	defer dbf.Close()
+	if err = writeDbf(dbf, s.Fields, s.records); err != nil {
+		return err
+	}
+
+	var prj string
+	if wkt := strings.TrimSpace(s.CoordinateRefSystemWKT); wkt != "" && wkt != "not specified" {
+		prj = wkt
+	} else if crs, ok := epsg.Lookup(s.EPSGCode); ok {
+		prj = crs.WKT()
+	} else if s.EPSGCode > 0 {
+		prj = "EPSG:" + strconv.Itoa(s.EPSGCode)
+	}
+	if prj != "" {
+		if err = ioutil.WriteFile(s.sidecarName(".prj"), []byte(prj+"\n"), 0644); err != nil {
+			return FileWritingError
+		}
+	}
+	return nil
+}
+
+// zmRanges returns the ranges of the elevations and measures of the shapes,
+// or zeros for shape types that don't have them.
+func (s *Shapefile) zmRanges() (minZ, maxZ, minM, maxM float64) {
+	minZ, minM = math.Inf(1), math.Inf(1)
+	maxZ, maxM = math.Inf(-1), math.Inf(-1)
+	for _, shape := range s.shapes {
+		for _, part := range shape.Parts {
+			for _, p := range part {
+				minZ, maxZ = math.Min(minZ, p.Z), math.Max(maxZ, p.Z)
+				if p.M != NoMeasure {
+					minM, maxM = math.Min(minM, p.M), math.Max(maxM, p.M)
+				}
+			}
+		}
+	}
+	if !s.ShapeType.HasZ() || math.IsInf(minZ, 0) {
+		minZ, maxZ = 0, 0
+	}
+	if !s.ShapeType.HasM() || math.IsInf(minM, 0) {
+		minM, maxM = 0, 0
+	}
+	return minZ, maxZ, minM, maxM
+}
+
+// measure returns a measure as it is stored; NoMeasure is stored as -1e39,
+// which is read as "no data".
+func measure(m float64) float64 {
+	if m == NoMeasure {
+		return -1e39
+	}
+	return m
+}
+
+// encodeShape returns the content of the record of a shape.
+func (s *Shapefile) encodeShape(shape Shape) []byte {
+	if len(shape.Parts) == 0 {
+		b := make([]byte, 4)
+		le.PutUint32(b, uint32(ST_Null))
+		return b
+	}
+	var b []byte
+	putFloat := func(v float64) {
+		b = le.AppendUint64(b, math.Float64bits(v))
+	}
+	putInt := func(v int) {
+		b = le.AppendUint32(b, uint32(v))
+	}
+	putInt(int(s.ShapeType))
+	hasZ, hasM := s.ShapeType.HasZ(), s.ShapeType.HasM()
+
+	if s.ShapeType.baseType() == ST_Point {
+		p := shape.Parts[0][0]
+		putFloat(p.X)
+		putFloat(p.Y)
+		if hasZ {
+			putFloat(p.Z)
+		}
+		if hasM {
+			putFloat(measure(p.M))
+		}
+		return b
+	}
+
+	points := shape.Points()
+	minX, minY, maxX, maxY := shape.Bounds()
+	putFloat(minX)
+	putFloat(minY)
+	putFloat(maxX)
+	putFloat(maxY)
+	if s.ShapeType.baseType() != ST_MultiPoint {
+		putInt(len(shape.Parts))
+	}
+	putInt(len(points))
+	if s.ShapeType.baseType() != ST_MultiPoint {
+		start := 0
+		for _, part := range shape.Parts {
+			putInt(start)
+			start += len(part)
+		}
+	}
+	for _, p := range points {
+		putFloat(p.X)
+		putFloat(p.Y)
+	}
+	if hasZ {
+		minZ, maxZ := math.Inf(1), math.Inf(-1)
+		for _, p := range points {
+			minZ, maxZ = math.Min(minZ, p.Z), math.Max(maxZ, p.Z)
+		}
+		putFloat(minZ)
+		putFloat(maxZ)
+		for _, p := range points {
+			putFloat(p.Z)
+		}
+	}
+	if hasM {
+		minM, maxM := math.Inf(1), math.Inf(-1)
+		for _, p := range points {
+			if p.M != NoMeasure {
+				minM, maxM = math.Min(minM, p.M), math.Max(maxM, p.M)
+			}
+		}
+		if math.IsInf(minM, 0) {
+			minM, maxM = -1e39, -1e39
+		}
+		putFloat(minM)
+		putFloat(maxM)
+		for _, p := range points {
+			putFloat(measure(p.M))
+		}
+	}
+	return b
+}
+
+// readShp reads the shapes of a .shp file.
+func (s *Shapefile) readShp(b []byte) error {
+	if len(b) < 100 || be.Uint32(b[0:4]) != 9994 {
+		return FileIsNotProperlyFormated
+	}
+	s.ShapeType = ShapeType(le.Uint32(b[32:36]))
+	if !s.ShapeType.isValid() {
+		return UnsupportedShapeTypeError
+	}
+	length := 2 * int(be.Uint32(b[24:28]))
+	if length > len(b) || length < 100 {
+		length = len(b) // tolerate a wrong file length
+	}
+	for pos := 100; pos+8 <= length; {
+		contentLength := 2 * int(be.Uint32(b[pos+4:pos+8]))
+		pos += 8
+		if pos+contentLength > length || contentLength < 4 {
+			return FileIsNotProperlyFormated
+		}
+		shape, err := decodeShape(b[pos : pos+contentLength])
+		if err != nil {
+			return err
+		}
+		s.shapes = append(s.shapes, shape)
+		pos += contentLength
+	}
+	return nil
+}
+
+// decodeShape reads the content of the record of a shape.
+func decodeShape(b []byte) (Shape, error) {
+	st := ShapeType(le.Uint32(b[0:4]))
+	if st == ST_Null {
+		return Shape{}, nil
+	}
+	if !st.isValid() {
+		return Shape{}, UnsupportedShapeTypeError
+	}
+	pos := 4
+	ok := true
+	getFloat := func() float64 {
+		if pos+8 > len(b) {
+			ok = false
+			return 0
+		}
+		pos += 8
+		return math.Float64frombits(le.Uint64(b[pos-8 : pos]))
+	}
+	getInt := func() int {
+		if pos+4 > len(b) {
+			ok = false
+			return 0
+		}
+		pos += 4
+		return int(int32(le.Uint32(b[pos-4 : pos])))
+	}
+	readMeasure := func() float64 {
+		if pos+8 > len(b) {
+			return NoMeasure // measures are optional in Z types
+		}
+		if m := getFloat(); m > -1e38 {
+			return m
+		}
+		return NoMeasure
+	}
+
+	if st.baseType() == ST_Point {
+		p := Point{X: getFloat(), Y: getFloat(), M: NoMeasure}
+		if st.HasZ() {
+			p.Z = getFloat()
+		}
+		if st.HasM() {
+			p.M = readMeasure()
+		}
+		if !ok {
+			return Shape{}, FileIsNotProperlyFormated
+		}
+		return Shape{Parts: [][]Point{{p}}}, nil
+	}
+
+	pos += 32 // the bounding box
+	numParts := 1
+	if st.baseType() != ST_MultiPoint {
+		numParts = getInt()
+	}
+	numPoints := getInt()
+	if !ok || numParts < 1 || numPoints < 0 || numParts > len(b)/4 || numPoints > len(b)/16 {
+		return Shape{}, FileIsNotProperlyFormated
+	}
+	starts := make([]int, numParts+1)
+	if st.baseType() != ST_MultiPoint {
+		for i := 0; i < numParts; i++ {
+			starts[i] = getInt()
+		}
+	}
+	starts[numParts] = numPoints
+	points := make([]Point, numPoints)
+	for i := range points {
+		points[i] = Point{X: getFloat(), Y: getFloat(), M: NoMeasure}
+	}
+	if st.HasZ() {
+		pos += 16 // the range
+		for i := range points {
+			points[i].Z = getFloat()
+		}
+	}
+	if st.HasM() && pos+16 <= len(b) {
+		pos += 16
+		for i := range points {
+			points[i].M = readMeasure()
+		}
+	}
+	if !ok {
+		return Shape{}, FileIsNotProperlyFormated
+	}
+	shape := Shape{Parts: make([][]Point, numParts)}
+	for i := 0; i < numParts; i++ {
+		if starts[i] < 0 || starts[i] > starts[i+1] || starts[i+1] > numPoints {
+			return Shape{}, FileIsNotProperlyFormated
+		}
+		shape.Parts[i] = points[starts[i]:starts[i+1]]
+	}
+	return shape, nil
+}
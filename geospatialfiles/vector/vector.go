@@ -0,0 +1,161 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+// Package vector reads and writes vector data, i.e. points, lines and
+// polygons with attributes, as ESRI shapefiles, and writes them as GeoJSON.
+package vector
+
+import "math"
+
+// ShapeType is the type of the shapes of a shapefile. The Z types have
+// elevations and measures, and the M types measures.
+type ShapeType int
+
+const (
+	ST_Null        ShapeType = 0
+	ST_Point       ShapeType = 1
+	ST_PolyLine    ShapeType = 3
+	ST_Polygon     ShapeType = 5
+	ST_MultiPoint  ShapeType = 8
+	ST_PointZ      ShapeType = 11
+	ST_PolyLineZ   ShapeType = 13
+	ST_PolygonZ    ShapeType = 15
+	ST_MultiPointZ ShapeType = 18
+	ST_PointM      ShapeType = 21
+	ST_PolyLineM   ShapeType = 23
+	ST_PolygonM    ShapeType = 25
+	ST_MultiPointM ShapeType = 28
+)
+
+var shapeTypeNames = map[ShapeType]string{
+	ST_Null:        "Null",
+	ST_Point:       "Point",
+	ST_PolyLine:    "PolyLine",
+	ST_Polygon:     "Polygon",
+	ST_MultiPoint:  "MultiPoint",
+	ST_PointZ:      "PointZ",
+	ST_PolyLineZ:   "PolyLineZ",
+	ST_PolygonZ:    "PolygonZ",
+	ST_MultiPointZ: "MultiPointZ",
+	ST_PointM:      "PointM",
+	ST_PolyLineM:   "PolyLineM",
+	ST_PolygonM:    "PolygonM",
+	ST_MultiPointM: "MultiPointM",
+}
+
+func (st ShapeType) String() string {
+	if s, ok := shapeTypeNames[st]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+func (st ShapeType) isValid() bool {
+	_, ok := shapeTypeNames[st]
+	return ok
+}
+
+// HasZ reports whether shapes of the type have elevations.
+func (st ShapeType) HasZ() bool {
+	return st >= ST_PointZ && st <= ST_MultiPointZ
+}
+
+// HasM reports whether shapes of the type have measures.
+func (st ShapeType) HasM() bool {
+	return st >= ST_PointZ
+}
+
+// baseType returns the two-dimensional type of a Z or M type, e.g. ST_Polygon
+// for ST_PolygonZ.
+func (st ShapeType) baseType() ShapeType {
+	if st >= ST_PointM {
+		return st - 20
+	} else if st >= ST_PointZ {
+		return st - 10
+	}
+	return st
+}
+
+// NoMeasure is the measure of a point that has none. Measures less than -1e38
+// are read as NoMeasure.
+var NoMeasure = math.Inf(-1)
+
+// Point is a vertex of a shape. Z is zero and M is NoMeasure for shape types
+// that don't have them.
+type Point struct {
+	X, Y, Z, M float64
+}
+
+// Shape is a single geometry. A point has one part of one point, and a
+// multipoint one part of all of its points. The parts of a polyline are its
+// lines, and those of a polygon its rings, which are closed, i.e. their
+// first and last points are the same. The outer rings of a polygon run
+// clockwise and its holes counter-clockwise.
+type Shape struct {
+	Parts [][]Point
+}
+
+// NewPoint returns a shape of a single point.
+func NewPoint(x, y float64) Shape {
+	return Shape{Parts: [][]Point{{{X: x, Y: y, M: NoMeasure}}}}
+}
+
+// NewPolyLine returns a shape of a single line through the vertices, which
+// are x, y pairs.
+func NewPolyLine(vertices [][2]float64) Shape {
+	line := make([]Point, len(vertices))
+	for i, v := range vertices {
+		line[i] = Point{X: v[0], Y: v[1], M: NoMeasure}
+	}
+	return Shape{Parts: [][]Point{line}}
+}
+
+// NewPolygon returns a shape of a single ring through the vertices, which
+// are x, y pairs. The ring is closed and made clockwise if it isn't already.
+func NewPolygon(vertices [][2]float64) Shape {
+	s := NewPolyLine(vertices)
+	ring := s.Parts[0]
+	if n := len(ring); n > 0 && (ring[0].X != ring[n-1].X || ring[0].Y != ring[n-1].Y) {
+		ring = append(ring, ring[0])
+	}
+	if !IsClockwise(ring) {
+		for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+			ring[i], ring[j] = ring[j], ring[i]
+		}
+	}
+	s.Parts[0] = ring
+	return s
+}
+
+// Points returns the points of all of the parts of a shape.
+func (s Shape) Points() []Point {
+	var ret []Point
+	for _, part := range s.Parts {
+		ret = append(ret, part...)
+	}
+	return ret
+}
+
+// Bounds returns the extent of a shape.
+func (s Shape) Bounds() (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, part := range s.Parts {
+		for _, p := range part {
+			minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+			minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+// IsClockwise reports whether a ring runs clockwise, i.e. its signed area,
+// by the shoelace formula, is negative.
+func IsClockwise(ring []Point) bool {
+	area := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i].X*ring[i+1].Y - ring[i+1].X*ring[i].Y
+	}
+	return area < 0
+}
@@ -0,0 +1,28 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package vector
+
+import (
+	"errors"
+	"fmt"
+)
+
+var UnsupportedShapeTypeError = errors.New("Unsupported shape type.")
+var ShapeTypeMismatchError = errors.New("The shape doesn't match the shape type of the shapefile.")
+var FieldCountError = errors.New("The number of attribute values doesn't match the number of fields.")
+var FileReadingError = errors.New("An error occurred while reading the shapefile.")
+var FileWritingError = errors.New("An error occurred while writing the shapefile.")
+var FileIsNotProperlyFormated = errors.New("The file does not appear to be a properly formated shapefile.")
+
+// FieldError reports an invalid attribute field, or a value that can't be
+// stored in a field.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("Field %s: %s.", e.Field, e.Reason)
+}
@@ -8,9 +8,11 @@ import (
 	"os/exec"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
 	"github.com/jblindsay/go-spatial/tools"
 )
@@ -35,11 +37,9 @@ var carryon bool
 var workingdir string
 var err error
 var toolManager tools.PluginToolManager
-
-//var flagCpuprofile string
+var config gospatialConfig
 
 func main() {
-	//flag.StringVar(&flagCpuprofile, "cpuprofile", "", "output a cpuprofile to...")
 	var runTool string
 	flag.StringVar(&runTool, "run", "", "Run a particular tool")
 	var toolArgs string
@@ -51,14 +51,63 @@ func main() {
 	var toolHelp string
 	flag.StringVar(&toolHelp, "toolhelp", "", "Prints help documentation for a tool")
 	var toolArgsStr string
-	flag.StringVar(&toolArgsStr, "toolargs", "", "Prints details about the arguments for a tool")
+	flag.StringVar(&toolArgsStr, "toolargs", "", "Prints details about the arguments for a tool, optionally followed by the rows and columns of the input for a memory estimate")
 	var helpArg = false
 	flag.BoolVar(&helpArg, "help", false, "Help")
 	// var ldflags string
 	// flag.StringVar(&ldflags, "ldflags", "", "ldflags")
 	var versionFlag = false
 	flag.BoolVar(&versionFlag, "version", false, "Version number")
+	var utmZone string
+	flag.StringVar(&utmZone, "utmzone", "", "Prints the UTM zone EPSG code for a raster file or a 'lon lat' coordinate")
+	var taudemFlag = false
+	flag.BoolVar(&taudemFlag, "taudem", false, "Writes flow outputs using TauDEM naming, encoding and nodata conventions")
+	var deterministic = false
+	flag.BoolVar(&deterministic, "deterministic", false, "Omits creation and elapsed times from output metadata for reproducible files")
+	var selfTest = false
+	flag.BoolVar(&selfTest, "selftest", false, "Runs the tools on synthetic data and verifies their outputs")
+	var toolsMetadata = false
+	flag.BoolVar(&toolsMetadata, "toolsmetadata", false, "Prints the metadata of all tools and their arguments as JSON")
+	var threads string
+	flag.StringVar(&threads, "threads", "", "The maximum number of threads used by tools (overrides the config file)")
+	var maxMemory string
+	flag.StringVar(&maxMemory, "maxmemory", "", "The memory budget, e.g. 8GB (overrides the config file)")
+	var outputFormat string
+	flag.StringVar(&outputFormat, "outputformat", "", "The default output format extension, e.g. tif (overrides the config file)")
+	var inputFormat string
+	flag.StringVar(&inputFormat, "inputformat", "", "The format of input rasters, e.g. geotiff, or auto to detect it (overrides the config file)")
+	var compression string
+	flag.StringVar(&compression, "compression", "", "The GeoTIFF output compression, none or deflate (overrides the config file)")
+	var rowsPerStrip string
+	flag.StringVar(&rowsPerStrip, "rowsperstrip", "", "The rows in each strip of GeoTIFF outputs, or 0 for strips of about 8 KB (overrides the config file)")
+	var reuseBuffers string
+	flag.StringVar(&reuseBuffers, "reusebuffers", "", "Reuses the cell buffers of rasters between tool runs, true or false (overrides the config file)")
+	flag.StringVar(&toolManager.CPUProfile, "cpuprofile", "", "Writes a CPU profile of the tool run to a file, for go tool pprof")
+	flag.StringVar(&toolManager.MemProfile, "memprofile", "", "Writes a memory profile at the end of the tool run to a file, for go tool pprof")
+	flag.StringVar(&toolManager.TraceFile, "trace", "", "Writes an execution trace of the tool run to a file, for go tool trace")
+	var dryRun = false
+	flag.BoolVar(&dryRun, "dry-run", false, "Validates the -run tool's arguments and prints its planned outputs without running it")
+	flag.StringVar(&toolManager.ReportFile, "report", "", "The CSV file that analysis tools write their tabular results to")
 	flag.Parse()
+	toolManager.HandleInterrupts()
+
+	// load the config file, then apply any overriding flags
+	if config, err = readConfig(configFileName()); err != nil {
+		printerr(err)
+	}
+	for _, err = range config.override(map[string]string{"threads": threads, "memorybudget": maxMemory,
+		"outputformat": outputFormat, "inputformat": inputFormat, "compression": compression, "rowsperstrip": rowsPerStrip,
+		"reusebuffers": reuseBuffers}) {
+		printerr(err)
+	}
+	config.apply(&toolManager)
+
+	if taudemFlag {
+		toolManager.TauDEMMode = true
+	}
+	if deterministic {
+		raster.DeterministicOutput = true
+	}
 
 	if strings.Contains(cwd, "\"") {
 		cwd = strings.Replace(cwd, "\"", "", -1)
@@ -68,15 +117,6 @@ func main() {
 		runTool = strings.Replace(runTool, "\"", "", -1)
 	}
 
-	//	if flagCpuprofile != "" {
-	//		f, err := os.Create(flagCpuprofile)
-	//		if err != nil {
-	//			log.Fatal(err)
-	//		}
-	//		pprof.StartCPUProfile(f)
-	//		//defer pprof.StopCPUProfile()
-	//	}
-
 	//args := os.Args[1:]
 	if listTools {
 		if cmd, ok := commandMap["listtools"]; ok {
@@ -96,6 +136,24 @@ func main() {
 		} else {
 			printerr(fmt.Errorf("unrecognized command '%s', type 'help' for details...", commandArgs[0]))
 		}
+	} else if toolsMetadata {
+		if cmd, ok := commandMap["toolsmetadata"]; ok {
+			cmd()
+		}
+	} else if selfTest {
+		if numFailed := toolManager.SelfTest(); numFailed > 0 {
+			os.Exit(1)
+		}
+	} else if utmZone != "" {
+		if len(strings.TrimSpace(cwd)) > 0 {
+			changeWorkingDirectory(cwd)
+		}
+		commandArgs = append([]string{"utmzone"}, strings.Fields(utmZone)...)
+		if cmd, ok := commandMap["utmzone"]; ok {
+			cmd()
+		} else {
+			printerr(fmt.Errorf("Unrecognized command '%s', type 'help' for details...", commandArgs[0]))
+		}
 	} else if toolHelp != "" {
 		commandArgs = []string{"toolhelp", toolHelp}
 		if cmd, ok := commandMap["toolhelp"]; ok {
@@ -104,7 +162,7 @@ func main() {
 			printerr(fmt.Errorf("Unrecognized command '%s', type 'help' for details...", commandArgs[0]))
 		}
 	} else if toolArgsStr != "" {
-		commandArgs = []string{"toolargs", toolArgsStr}
+		commandArgs = append([]string{"toolargs"}, strings.Fields(toolArgsStr)...)
 		if cmd, ok := commandMap["toolargs"]; ok {
 			cmd()
 		} else {
@@ -127,13 +185,21 @@ func main() {
 		if len(toolArgs) > 0 {
 			// parse the args
 			f := func(c rune) bool {
-				return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != os.PathSeparator && c != ' ' && c != '-' && c != '_'
+				return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != os.PathSeparator && c != ' ' && c != '-' && c != '_' && c != '=' && c != '{' && c != '}'
 			}
 			argsArray = strings.FieldsFunc(toolArgs, f)
 		}
-		if len(strings.TrimSpace(runTool)) > 0 {
+		if len(strings.TrimSpace(runTool)) > 0 && dryRun {
+			if err = toolManager.DryRun(strings.TrimSpace(runTool), argsArray); err != nil {
+				printerr(err)
+				os.Exit(1)
+			}
+		} else if len(strings.TrimSpace(runTool)) > 0 {
 			if err = toolManager.RunWithArguments(strings.TrimSpace(runTool), argsArray); err != nil {
 				printerr(err)
+				if _, ok := err.(*tools.InterruptedError); ok {
+					os.Exit(130)
+				}
 				//printerr(fmt.Errorf("Unrecognized tool name '%s;. Type 'listtools' for a list of available tools.", commandArgs[1]))
 			}
 		}
@@ -173,6 +239,7 @@ var commandMap map[string]func()
 func init() {
 	toolManager = tools.PluginToolManager{}
 	toolManager.InitializeTools()
+	toolManager.Version = version
 
 	// set the current working directory
 	if workingdir, err = os.Getwd(); err != nil {
@@ -189,14 +256,27 @@ func init() {
 	helpMap["pwd"] = []string{"Prints the working directory (also 'dir')"}
 	helpMap["run"] = []string{"Runs a specified tool (also 'r'),",
 		" e.g. run toolname  or  run toolname \"arg1;arg2;arg3;...\""}
+	helpMap["dryrun"] = []string{"Validates a tool's arguments and prints its planned outputs",
+		" without running it, e.g. dryrun toolname \"arg1;arg2;arg3;...\""}
 	helpMap["listtools"] = []string{"Lists all available tools"}
 	helpMap["licence"] = []string{"Prints the licence"}
-	helpMap["toolargs"] = []string{"Prints the argument descriptions for a tool"}
+	helpMap["toolargs"] = []string{"Prints the argument descriptions for a tool and, for a",
+		" raster size, its memory estimate, e.g. toolargs Slope 20000 15000"}
 	helpMap["memprof"] = []string{"Outputs a memory usage profile"}
+	helpMap["toolsmetadata"] = []string{"Prints the metadata of all tools and their arguments as JSON"}
 	helpMap["toolhelp"] = []string{"Prints help documentation for a tool,", " e.g. toolhelp BreachDepressions"}
 	helpMap["benchon"] = []string{"Turns benchmarking mode on. Note: not all tools support this"}
 	helpMap["benchoff"] = []string{"Turns benchmarking mode off"}
 	helpMap["bench"] = []string{"Prints the current benchmarking mode"}
+	helpMap["selftest"] = []string{"Runs the tools on synthetic data and verifies their outputs"}
+	helpMap["taudemon"] = []string{"Turns TauDEM compatibility mode on for flow outputs"}
+	helpMap["taudemoff"] = []string{"Turns TauDEM compatibility mode off"}
+	helpMap["report"] = []string{"Sets the CSV file that analysis tools write their tabular results",
+		" to, e.g. report stats.csv; 'report off' stops the reports and 'report' prints the file"}
+	helpMap["taudem"] = []string{"Prints the current TauDEM compatibility mode"}
+	helpMap["config"] = []string{"Prints the settings loaded from the config file (~/.gospatialrc)"}
+	helpMap["utmzone"] = []string{"Prints the UTM zone EPSG code for a raster or lon/lat,",
+		" e.g. utmzone DEM.tif  or  utmzone -80.25 43.53"}
 
 	commandMap = make(map[string]func())
 	commandMap["benchon"] = func() {
@@ -212,6 +292,34 @@ func init() {
 			println("Benchmark Mode = off")
 		}
 	}
+	commandMap["selftest"] = func() {
+		toolManager.SelfTest()
+	}
+	commandMap["taudemon"] = func() {
+		toolManager.TauDEMMode = true
+	}
+	commandMap["taudemoff"] = func() {
+		toolManager.TauDEMMode = false
+	}
+	commandMap["taudem"] = func() {
+		if toolManager.TauDEMMode {
+			println("TauDEM Mode = on")
+		} else {
+			println("TauDEM Mode = off")
+		}
+	}
+	commandMap["report"] = func() {
+		switch {
+		case len(commandArgs) > 1 && strings.ToLower(commandArgs[1]) == "off":
+			toolManager.ReportFile = ""
+		case len(commandArgs) > 1:
+			toolManager.ReportFile = strings.Join(commandArgs[1:], " ")
+		case toolManager.ReportFile != "":
+			println("Report file:", toolManager.ReportFile)
+		default:
+			println("Report file: none")
+		}
+	}
 	commandMap["toolhelp"] = func() {
 		if len(commandArgs) > 1 {
 			s, err := toolManager.GetToolHelp(commandArgs[1])
@@ -252,9 +360,6 @@ func init() {
 	commandMap["exit"] = func() {
 		carryon = false
 		println("Goodbye for now")
-		//		if flagCpuprofile != "" {
-		//			pprof.StopCPUProfile()
-		//		}
 		os.Exit(0)
 	}
 	commandMap["logout"] = commandMap["exit"]
@@ -262,7 +367,11 @@ func init() {
 	commandMap["run"] = func() {
 		if len(commandArgs) == 2 {
 			if err = toolManager.Run(commandArgs[1]); err != nil {
-				printf("Unrecognized tool name '%s'. Type 'listtools' for a list of available tools.\n", commandArgs[1])
+				if _, ok := err.(*tools.InterruptedError); ok {
+					printerr(err)
+				} else {
+					printf("Unrecognized tool name '%s'. Type 'listtools' for a list of available tools.\n", commandArgs[1])
+				}
 			}
 		} else if len(commandArgs) > 2 { // there are specified arguments
 			s := ""
@@ -272,18 +381,38 @@ func init() {
 			s = strings.TrimSpace(s)
 			// parse the args
 			f := func(c rune) bool {
-				return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != os.PathSeparator && c != ' ' && c != '-'
+				return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != os.PathSeparator && c != ' ' && c != '-' && c != '=' && c != '{' && c != '}'
 			}
 			argsArray := strings.FieldsFunc(s, f)
 
 			if err = toolManager.RunWithArguments(strings.TrimSpace(commandArgs[1]), argsArray); err != nil {
-				printf("Unrecognized tool name '%s'. Type 'listtools' for a list of available tools.\n", commandArgs[1])
+				printerr(err)
 			}
 		} else {
 			println("Tool name not specified, e.g. run BreachDepressions")
 		}
 	}
 	commandMap["r"] = commandMap["run"]
+	commandMap["dryrun"] = func() {
+		if len(commandArgs) > 1 {
+			s := ""
+			for i := 2; i < len(commandArgs); i++ {
+				s += " " + commandArgs[i]
+			}
+			s = strings.TrimSpace(s)
+			// parse the args
+			f := func(c rune) bool {
+				return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != os.PathSeparator && c != ' ' && c != '-' && c != '=' && c != '{' && c != '}'
+			}
+			argsArray := strings.FieldsFunc(s, f)
+
+			if err = toolManager.DryRun(strings.TrimSpace(commandArgs[1]), argsArray); err != nil {
+				println(err)
+			}
+		} else {
+			println("Tool name not specified, e.g. dryrun BreachDepressions")
+		}
+	}
 	commandMap["rasterformats"] = func() {
 		// first sort the commands alphabetically
 		m := raster.GetMapOfFormatsAndExtensions()
@@ -304,6 +433,46 @@ func init() {
 		printf("GoSpatial version %s.%s\n", version, buildstamp) //releaseDate.Format(layout))
 	}
 	commandMap["v"] = commandMap["version"]
+	commandMap["config"] = func() {
+		if config.fileName != "" {
+			println("Config file:", config.fileName)
+		} else {
+			println("Config file: none")
+		}
+		println("Working directory:", workingdir)
+		if toolManager.NumThreads > 0 {
+			println("Threads:", toolManager.NumThreads)
+		} else {
+			println("Threads: all CPUs")
+		}
+		if toolManager.MemoryBudget > 0 {
+			println("Memory budget:", tools.FormatBytes(toolManager.MemoryBudget))
+		} else {
+			println("Memory budget: unlimited")
+		}
+		println("Output format:", raster.DefaultExtension)
+		if raster.InputFormat != raster.RT_UnknownRaster {
+			println("Input format:", raster.InputFormat.String())
+		} else {
+			println("Input format: auto")
+		}
+		if raster.CompressOutput {
+			println("Compression: deflate")
+		} else {
+			println("Compression: none")
+		}
+		if raster.RowsPerStrip > 0 {
+			println("Rows per strip:", raster.RowsPerStrip)
+		} else {
+			println("Rows per strip: auto (8 KB strips)")
+		}
+		if raster.Pool != nil {
+			requested, reused := raster.Pool.Stats()
+			printf("Buffer reuse: on (%v of %v buffers reused)\n", reused, requested)
+		} else {
+			println("Buffer reuse: off")
+		}
+	}
 	commandMap["pwd"] = func() {
 		println("Working directory:", workingdir)
 	}
@@ -337,7 +506,7 @@ func init() {
 		pt := toolManager.GetListOfTools()
 		plugs := make([]string, 0, len(pt))
 		for _, value := range pt {
-			plugs = append(plugs, trailingSpaces(value.GetName(), 20)+value.GetDescription())
+			plugs = append(plugs, trailingSpaces(value.GetName(), 20)+trailingSpaces(value.GetVersion(), 5)+value.GetDescription())
 		}
 		sort.Strings(plugs)
 		printf("The following %v tools are available:\n", len(pt))
@@ -345,6 +514,14 @@ func init() {
 			println(value)
 		}
 	}
+	commandMap["toolsmetadata"] = func() {
+		s, err := toolManager.GetToolsMetadataJSON()
+		if err != nil {
+			printerr(err)
+			return
+		}
+		println(s)
+	}
 	commandMap["licence"] = func() {
 		println(licenceText)
 	}
@@ -358,11 +535,59 @@ func init() {
 				for _, val := range argDescriptions {
 					println(val)
 				}
+				// an optional raster size, e.g. toolargs FillDepressions 20000 15000
+				if len(commandArgs) == 4 {
+					rows, err1 := strconv.Atoi(commandArgs[2])
+					columns, err2 := strconv.Atoi(commandArgs[3])
+					if err1 != nil || err2 != nil || rows < 1 || columns < 1 {
+						println("Unable to parse the raster size, e.g. toolargs FillDepressions 20000 15000")
+						return
+					}
+					if mem, err := toolManager.EstimateToolMemory(commandArgs[1], rows, columns); err == nil {
+						printf("Estimated peak memory for a %v x %v raster: %s\n", rows, columns, tools.FormatBytes(mem))
+						if toolManager.MemoryBudget > 0 && mem > toolManager.MemoryBudget {
+							printf("This exceeds the memory budget of %s.\n", tools.FormatBytes(toolManager.MemoryBudget))
+						}
+					}
+				}
 			}
 		} else {
 			println("Tool name not specified, e.g. toolargs FastBreach")
 		}
 	}
+	commandMap["utmzone"] = func() {
+		var code int
+		var err error
+		if len(commandArgs) == 3 {
+			lon, err1 := strconv.ParseFloat(commandArgs[1], 64)
+			lat, err2 := strconv.ParseFloat(commandArgs[2], 64)
+			if err1 != nil || err2 != nil {
+				println("Unable to parse the coordinate, e.g. utmzone -80.25 43.53")
+				return
+			}
+			code, err = epsg.UTMCode(4326, lon, lat)
+		} else if len(commandArgs) > 1 {
+			fileName := strings.Join(commandArgs[1:], " ")
+			if !strings.Contains(fileName, pathSep) {
+				fileName = workingdir + pathSep + fileName
+			}
+			var r *raster.Raster
+			if r, err = raster.CreateRasterFromFile(fileName, raster.RasterConfig{HeaderOnly: true}); err != nil {
+				printerr(err)
+				return
+			}
+			code, err = r.GetUTMCode()
+		} else {
+			println("A raster file or lon/lat coordinate must be specified, e.g. utmzone -80.25 43.53")
+			return
+		}
+		if err != nil {
+			printerr(err)
+			return
+		}
+		crs, _ := epsg.Lookup(code)
+		printf("EPSG:%d %s\n", code, crs.Name)
+	}
 	commandMap["memprof"] = func() {
 		m := new(runtime.MemStats)
 		runtime.ReadMemStats(m)
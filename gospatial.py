@@ -4,6 +4,7 @@
 # See gospatial_example.py for an example of how to use it.
 import os
 import sys
+import json
 import subprocess
 from sys import platform
 
@@ -101,6 +102,19 @@ def list_tools():
     except Exception as e:
         return e
 
+def tools_metadata():
+    # Returns a list of dicts describing each tool and its arguments
+    try:
+        os.chdir(exe_path)
+        cmd = []
+        cmd.append("." + os.path.sep + exe_name)
+        cmd.append("-toolsmetadata")
+        ps = subprocess.Popen(cmd, shell=False, stdout=subprocess.PIPE, stderr=subprocess.PIPE, bufsize=1, universal_newlines=True)
+        out, err = ps.communicate()
+        return json.loads(out)
+    except Exception as e:
+        return e
+
 def default_callback(str):
     print(str)
 
@@ -0,0 +1,147 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// weightTable is a lookup table of the weights of the flow that DEM cells
+// contribute to accumulation, e.g. snowmelt contribution curves, as a
+// function of their aspect or elevation. Weights are interpolated linearly
+// between the values of the table; elevations beyond its ends take the
+// weight of the nearest end, and aspects wrap around from 360 to 0 degrees.
+type weightTable struct {
+	variable string    // "aspect" or "elevation"
+	values   []float64 // in increasing order
+	weights  []float64
+}
+
+// readWeightTable reads a weight table from a text file whose first line
+// names the variable, 'aspect' or 'elevation', optionally followed by a
+// weight column heading, and whose other lines are 'value weight' pairs,
+// separated by spaces, tabs or commas. Lines starting with '#' are skipped.
+func readWeightTable(fileName string) (*weightTable, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	t := &weightTable{}
+	type entry struct{ value, weight float64 }
+	entries := make([]entry, 0)
+	for _, line := range strings.Split(strings.Replace(string(b), "\r", "", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ','
+		})
+		if t.variable == "" {
+			switch v := strings.ToLower(fields[0]); v {
+			case "aspect", "elevation":
+				t.variable = v
+				continue
+			}
+			return nil, errors.New("The first line of the weight table must name its variable, 'aspect' or 'elevation'.")
+		}
+		var e entry
+		if len(fields) >= 2 {
+			e.value, err = strconv.ParseFloat(fields[0], 64)
+			if err == nil {
+				e.weight, err = strconv.ParseFloat(fields[1], 64)
+			}
+		}
+		if len(fields) < 2 || err != nil {
+			return nil, fmt.Errorf("Unable to read the weight table entry '%s'.", line)
+		}
+		if e.weight < 0 {
+			return nil, fmt.Errorf("The weight table entry '%s' has a negative weight.", line)
+		}
+		if t.variable == "aspect" && (e.value < 0 || e.value > 360) {
+			return nil, fmt.Errorf("The weight table entry '%s' isn't an aspect from 0 to 360 degrees.", line)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("The weight table must contain at least one entry.")
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].value < entries[j].value })
+	for i, e := range entries {
+		if i > 0 && e.value == entries[i-1].value {
+			return nil, fmt.Errorf("The weight table has more than one entry for %v.", e.value)
+		}
+		t.values = append(t.values, e.value)
+		t.weights = append(t.weights, e.weight)
+	}
+	return t, nil
+}
+
+// weight returns the weight of a value of the table's variable.
+func (t *weightTable) weight(v float64) float64 {
+	n := len(t.values)
+	if t.variable == "aspect" {
+		// the first entry follows the last, 360 degrees on
+		v = math.Mod(v, 360)
+		if v < t.values[0] {
+			v += 360
+		}
+		if v > t.values[n-1] {
+			span := t.values[0] + 360 - t.values[n-1]
+			if span == 0 {
+				return t.weights[0]
+			}
+			f := (v - t.values[n-1]) / span
+			return t.weights[n-1] + f*(t.weights[0]-t.weights[n-1])
+		}
+	} else if v <= t.values[0] {
+		return t.weights[0]
+	} else if v >= t.values[n-1] {
+		return t.weights[n-1]
+	}
+	i := sort.SearchFloat64s(t.values, v)
+	if t.values[i] == v {
+		return t.weights[i]
+	}
+	f := (v - t.values[i-1]) / (t.values[i] - t.values[i-1])
+	return t.weights[i-1] + f*(t.weights[i]-t.weights[i-1])
+}
+
+// cellWeights returns the weight of each DEM cell, indexed row*columns+col,
+// from its elevation, or from its aspect by Horn's method. Level cells, which
+// have no aspect, and nodata cells have a weight of 1.
+func (t *weightTable) cellWeights(dem *raster.Raster) []float64 {
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	w := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			w[i] = 1
+			z := dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			if t.variable == "elevation" {
+				w[i] = t.weight(z)
+			} else if d, ok := hornDerivatives(dem, row, col, cellSizeX, cellSizeY); ok {
+				if a := d.aspect(); a >= 0 {
+					w[i] = t.weight(a)
+				}
+			}
+		}
+	}
+	return w
+}
@@ -0,0 +1,371 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// AccuracyAssessment compares a classified raster to reference data and
+// reports the confusion matrix, overall accuracy and kappa coefficient.
+type AccuracyAssessment struct {
+	classifiedFile string
+	referenceFile  string
+	outputFile     string
+	toolManager    *PluginToolManager
+}
+
+func (this *AccuracyAssessment) GetName() string {
+	s := "AccuracyAssessment"
+	return getFormattedToolName(s)
+}
+
+func (this *AccuracyAssessment) GetDescription() string {
+	s := "Confusion matrix and kappa for a classified raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *AccuracyAssessment) GetVersion() string {
+	return "1.0"
+}
+
+func (this *AccuracyAssessment) GetHelpDocumentation() string {
+	ret := "This tool assesses the accuracy of a classified (categorical) raster, such as " +
+		"a landform classification or a ground/non-ground filtering, against reference data. " +
+		"The reference data are either a raster with the same dimensions as the classified " +
+		"raster, in which case every cell that is valid in both is compared, or a text file " +
+		"of 'x y class' points, in which case the cell containing each point is compared; " +
+		"points outside of the raster or on nodata cells are ignored. The tool reports the " +
+		"confusion matrix, with classified classes in rows and reference classes in columns, " +
+		"the user's and producer's accuracy of each class, the overall accuracy and Cohen's " +
+		"kappa coefficient. If an OutputFile is specified, or failing that a -report file, the " +
+		"same report is also written to it in CSV format; the matrix is only printed when " +
+		"there are at most 20 classes."
+	return ret
+}
+
+func (this *AccuracyAssessment) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *AccuracyAssessment) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "ClassifiedFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The classified raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "ReferenceFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The reference raster or reference points file"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output CSV file name"
+	ret[2].Role = ArgOutput
+
+	return ret
+}
+
+func (this *AccuracyAssessment) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The classified and reference files must be specified.")
+		return
+	}
+	classifiedFile := args[0]
+	classifiedFile = strings.TrimSpace(classifiedFile)
+	if !strings.Contains(classifiedFile, pathSep) {
+		classifiedFile = this.toolManager.workingDirectory + classifiedFile
+	}
+	this.classifiedFile = classifiedFile
+	// see if the file exists
+	if _, err := os.Stat(this.classifiedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.classifiedFile)
+		return
+	}
+	referenceFile := strings.TrimSpace(args[1])
+	if !strings.Contains(referenceFile, pathSep) {
+		referenceFile = this.toolManager.workingDirectory + referenceFile
+	}
+	this.referenceFile = referenceFile
+	if _, err := os.Stat(this.referenceFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.referenceFile)
+		return
+	}
+	this.outputFile = ""
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		this.setOutputFile(args[2])
+	}
+
+	this.Run()
+}
+
+func (this *AccuracyAssessment) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the classified file name
+	print("Enter the classified raster file name (incl. file extension): ")
+	classifiedFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	classifiedFile = strings.TrimSpace(classifiedFile)
+	if !strings.Contains(classifiedFile, pathSep) {
+		classifiedFile = this.toolManager.workingDirectory + classifiedFile
+	}
+	this.classifiedFile = classifiedFile
+	// see if the file exists
+	if _, err := os.Stat(this.classifiedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.classifiedFile)
+		return
+	}
+
+	// get the reference file name
+	print("Enter the reference raster or points file name: ")
+	referenceFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	referenceFile = strings.TrimSpace(referenceFile)
+	if !strings.Contains(referenceFile, pathSep) {
+		referenceFile = this.toolManager.workingDirectory + referenceFile
+	}
+	this.referenceFile = referenceFile
+	if _, err := os.Stat(this.referenceFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.referenceFile)
+		return
+	}
+
+	// get the output file name
+	this.outputFile = ""
+	print("Enter the output CSV file name (blank for none): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(outputFile)) > 0 {
+		this.setOutputFile(outputFile)
+	}
+
+	this.Run()
+}
+
+func (this *AccuracyAssessment) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+}
+
+func (this *AccuracyAssessment) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	classified, err := raster.CreateRasterFromFile(this.classifiedFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := classified.Rows
+	columns := classified.Columns
+	nodata := classified.NoDataValue
+
+	// gather the (classified, reference) pairs
+	type classPair struct{ classified, reference float64 }
+	counts := make(map[classPair]int)
+	numIgnored := 0
+	if rt, err := raster.DetermineRasterFormat(this.referenceFile); err == nil && rt != raster.RT_UnknownRaster {
+		reference, err := raster.CreateRasterFromFile(this.referenceFile)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		if reference.Rows != rows || reference.Columns != columns {
+			println("The reference raster must have the same dimensions as the classified raster.")
+			return
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				c := classified.Value(row, col)
+				r := reference.Value(row, col)
+				if c == nodata || r == reference.NoDataValue {
+					continue
+				}
+				counts[classPair{c, r}]++
+			}
+		}
+	} else {
+		points, err := readPointFile(this.referenceFile)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		cellSizeX := classified.GetCellSizeX()
+		cellSizeY := classified.GetCellSizeY()
+		for _, pt := range points {
+			r, err := strconv.ParseFloat(pt.label, 64)
+			if err != nil {
+				printf("The reference class of point (%v, %v) is not numeric.\n", pt.x, pt.y)
+				return
+			}
+			row := int(math.Floor((classified.North - pt.y) / cellSizeY))
+			col := int(math.Floor((pt.x - classified.West) / cellSizeX))
+			if row < 0 || row >= rows || col < 0 || col >= columns || classified.Value(row, col) == nodata {
+				numIgnored++
+				continue
+			}
+			counts[classPair{classified.Value(row, col), r}]++
+		}
+	}
+	if len(counts) == 0 {
+		println("There are no valid samples to compare.")
+		return
+	}
+
+	start2 := time.Now()
+
+	// build the confusion matrix over the union of the classes
+	classSet := make(map[float64]bool)
+	for p := range counts {
+		classSet[p.classified] = true
+		classSet[p.reference] = true
+	}
+	classes := make([]float64, 0, len(classSet))
+	for c := range classSet {
+		classes = append(classes, c)
+	}
+	sort.Float64s(classes)
+	index := make(map[float64]int)
+	for i, c := range classes {
+		index[c] = i
+	}
+	k := len(classes)
+	matrix := make([][]int, k)
+	for i := range matrix {
+		matrix[i] = make([]int, k)
+	}
+	for p, n := range counts {
+		matrix[index[p.classified]][index[p.reference]] += n
+	}
+	rowTotals := make([]int, k)
+	colTotals := make([]int, k)
+	total, agreement := 0, 0
+	for i := 0; i < k; i++ {
+		for j := 0; j < k; j++ {
+			rowTotals[i] += matrix[i][j]
+			colTotals[j] += matrix[i][j]
+			total += matrix[i][j]
+		}
+		agreement += matrix[i][i]
+	}
+	overall := float64(agreement) / float64(total)
+	expected := 0.0
+	for i := 0; i < k; i++ {
+		expected += float64(rowTotals[i]) * float64(colTotals[i])
+	}
+	expected /= float64(total) * float64(total)
+	kappa := 1.0
+	if expected < 1 {
+		kappa = (overall - expected) / (1 - expected)
+	}
+
+	// the report, as a table on the console and as CSV in the output file
+	ratio := func(a, b int) string {
+		if b == 0 {
+			return "NaN"
+		}
+		return strconv.FormatFloat(float64(a)/float64(b), 'f', 4, 64)
+	}
+	table := make([][]string, 0, k+5)
+	fields := []string{"class"}
+	for _, c := range classes {
+		fields = append(fields, strconv.FormatFloat(c, 'f', -1, 64))
+	}
+	table = append(table, append(fields, "total", "user's"))
+	for i, c := range classes {
+		fields = []string{strconv.FormatFloat(c, 'f', -1, 64)}
+		for j := 0; j < k; j++ {
+			fields = append(fields, strconv.Itoa(matrix[i][j]))
+		}
+		table = append(table, append(fields, strconv.Itoa(rowTotals[i]), ratio(matrix[i][i], rowTotals[i])))
+	}
+	fields = []string{"total"}
+	for j := 0; j < k; j++ {
+		fields = append(fields, strconv.Itoa(colTotals[j]))
+	}
+	table = append(table, append(fields, strconv.Itoa(total)))
+	fields = []string{"producer's"}
+	for j := 0; j < k; j++ {
+		fields = append(fields, ratio(matrix[j][j], colTotals[j]))
+	}
+	table = append(table, fields, []string{})
+	table = append(table, []string{"overall accuracy", strconv.FormatFloat(overall, 'f', 4, 64)})
+	table = append(table, []string{"kappa", strconv.FormatFloat(kappa, 'f', 4, 64)})
+
+	if k <= 20 {
+		println("\nConfusion matrix (rows: classified, columns: reference):")
+		for _, fields := range table {
+			cells := make([]string, len(fields))
+			for i, field := range fields {
+				cells[i] = fmt.Sprintf("%10s", field)
+			}
+			printf("%s\n", strings.Join(cells, " "))
+		}
+	} else {
+		printf("Warning: there are %v classes; is the raster categorical?\n", k)
+		printf("overall accuracy %.4f\nkappa %.4f\n", overall, kappa)
+	}
+	if numIgnored > 0 {
+		printf("Reference points outside of the raster or on nodata cells: %v\n", numIgnored)
+	}
+
+	// the output file, or failing that the report requested with -report
+	var report *tableWriter
+	if this.outputFile != "" {
+		report, err = createTable(this.outputFile)
+	} else {
+		report, err = this.toolManager.openReport()
+	}
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	for _, fields := range table {
+		report.writeRecord(fields)
+	}
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	elapsed := time.Since(start2)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
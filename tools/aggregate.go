@@ -0,0 +1,318 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Aggregate creates a coarser raster in which each cell holds a statistic of
+// the block of input cells that it covers.
+type Aggregate struct {
+	inputFile   string
+	outputFile  string
+	factor      int
+	statistic   string
+	toolManager *PluginToolManager
+}
+
+var aggregateStatisticNames = []string{"mean", "min", "max", "range", "stdev", "count"}
+
+func (this *Aggregate) GetName() string {
+	s := "Aggregate"
+	return getFormattedToolName(s)
+}
+
+func (this *Aggregate) GetDescription() string {
+	s := "Aggregates a raster to a coarser grid"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Aggregate) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Aggregate) GetHelpDocumentation() string {
+	ret := "This tool creates a coarser raster in which each cell covers a block of Factor x " +
+		"Factor input cells and holds their mean, min, max, range, stdev (sample standard " +
+		"deviation) or count of valid cells. Nodata cells are excluded from the statistics and " +
+		"output cells covering no valid cells are assigned nodata. Where the input dimensions " +
+		"are not multiples of the factor, the last row and column of output cells cover " +
+		"partial blocks; the output extent is enlarged to the full blocks so that the output " +
+		"cell size is exactly Factor times the input cell size and the grids remain aligned."
+	return ret
+}
+
+func (this *Aggregate) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Aggregate) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Factor"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of input cells in each row and column of a block"
+	ret[2].Required = true
+
+	ret[3].Name = "Statistic"
+	ret[3].Type = "string"
+	ret[3].Description = "mean, min, max, range, stdev or count"
+	ret[3].Default = "mean"
+	ret[3].Choices = []string{"mean", "min", "max", "range", "stdev", "count"}
+
+	return ret
+}
+
+func (this *Aggregate) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and aggregation factor must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setFactor(args[2]) {
+		return
+	}
+
+	this.statistic = "mean"
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setStatistic(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Aggregate) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the aggregation factor
+	print("Aggregation factor (input cells per output cell side): ")
+	factorStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setFactor(factorStr) {
+		return
+	}
+
+	// get the statistic
+	printf("Statistic, %s (blank for mean): ", strings.Join(aggregateStatisticNames, ", "))
+	statistic, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.statistic = "mean"
+	if len(strings.TrimSpace(statistic)) > 0 {
+		if !this.setStatistic(statistic) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Aggregate) setFactor(s string) bool {
+	factor, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if factor < 2 {
+		println("The aggregation factor must be at least 2.")
+		return false
+	}
+	this.factor = factor
+	return true
+}
+
+func (this *Aggregate) setStatistic(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, stat := range aggregateStatisticNames {
+		if s == stat {
+			this.statistic = stat
+			return true
+		}
+	}
+	printf("Unrecognized statistic: %s\n", s)
+	return false
+}
+
+func (this *Aggregate) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	f := this.factor
+	outRows := (rows + f - 1) / f
+	outColumns := (columns + f - 1) / f
+	cellSizeX := rin.GetCellSizeX() * float64(f)
+	cellSizeY := rin.GetCellSizeY() * float64(f)
+	if outRows*f != rows || outColumns*f != columns {
+		printf("The input dimensions are not multiples of %v; the last output row and column cover partial blocks.\n", f)
+	}
+
+	// create the output raster; the extent grows to cover the partial blocks
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	if this.statistic == "mean" || this.statistic == "min" || this.statistic == "max" {
+		// the output shares the values and units of the input
+		config.CopyDisplaySettings(inConfig)
+	}
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	if this.statistic == "count" {
+		config.DataType = raster.DT_INT32
+	}
+	south := rin.North - float64(outRows)*cellSizeY
+	east := rin.West + float64(outColumns)*cellSizeX
+	rout, err := raster.CreateNewRaster(this.outputFile, outRows, outColumns,
+		rin.North, south, east, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	values := make([]float64, 0, f*f)
+	outRowsLessOne := outRows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < outRows; row++ {
+		for col := 0; col < outColumns; col++ {
+			values = values[:0]
+			for r := row * f; r < (row+1)*f && r < rows; r++ {
+				for c := col * f; c < (col+1)*f && c < columns; c++ {
+					if z := rin.Value(r, c); z != nodata && !math.IsNaN(z) {
+						values = append(values, z)
+					}
+				}
+			}
+			if len(values) == 0 && this.statistic != "count" {
+				continue
+			}
+			if v, ok := cellStatistic(this.statistic, nil, values); ok {
+				rout.SetValue(row, col, v)
+			}
+		}
+		if outRowsLessOne > 0 {
+			progress = int(100.0 * row / outRowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Aggregate tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Aggregated by a factor of %v using the %s", f, this.statistic))
+	rout.Save()
+
+	printf("Output dimensions: %v rows x %v columns\n", outRows, outColumns)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
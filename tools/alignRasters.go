@@ -0,0 +1,422 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// The default tolerance, as a fraction of the cell size, within which the
+// cell sizes of two rasters must agree for one to be aligned onto the other.
+const defaultAlignTolerance = 0.01
+
+// AlignRasters resamples and crops a raster onto the grid of a base raster.
+type AlignRasters struct {
+	inputFile   string
+	baseFile    string
+	outputFile  string
+	method      string
+	tolerance   float64
+	toolManager *PluginToolManager
+}
+
+func (this *AlignRasters) GetName() string {
+	s := "AlignRasters"
+	return getFormattedToolName(s)
+}
+
+func (this *AlignRasters) GetDescription() string {
+	s := "Resamples a raster onto the grid of a base raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *AlignRasters) GetVersion() string {
+	return "1.0"
+}
+
+func (this *AlignRasters) GetHelpDocumentation() string {
+	ret := "This tool aligns a raster onto the grid of a base raster, so that the two can " +
+		"be used together by tools that require inputs with the same dimensions, e.g. a " +
+		"streams raster and a DEM whose extents or origins differ slightly. The output has " +
+		"the rows, columns and extent of the base raster; the input is cropped where it " +
+		"extends beyond the base and base cells that it does not cover are nodata. Where the " +
+		"grids are offset by a fraction of a cell, the input is resampled using the 'nearest' " +
+		"or 'bilinear' Method; the default is nearest-neighbour for integer (e.g. categorical) " +
+		"rasters and bilinear otherwise. The cell sizes of the two rasters must agree to " +
+		"within the Tolerance, a fraction of the base cell size (default 0.01); rasters of " +
+		"very different resolutions should be resampled with the Resample, Aggregate or " +
+		"Disaggregate tools instead. Tools that read a secondary mask, e.g. the barrier raster of " +
+		"BreachDepressions, the walls of BurnWalls or the seeds of FloodFill, align it in the " +
+		"same way automatically."
+	return ret
+}
+
+func (this *AlignRasters) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *AlignRasters) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "BaseFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The base raster, whose grid the output will share"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "nearest or bilinear"
+	ret[3].Choices = []string{"nearest", "bilinear"}
+
+	ret[4].Name = "Tolerance"
+	ret[4].Type = "float64"
+	ret[4].Description = "Allowed cell size difference, fraction"
+	ret[4].Default = "0.01"
+
+	return ret
+}
+
+func (this *AlignRasters) EstimateMemory(rows, columns int) int64 {
+	// the input, base and output rasters
+	return gridBytes(rows, columns, 3*rasterBytesPerCell)
+}
+
+func (this *AlignRasters) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input, base and output files must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	baseFile := strings.TrimSpace(args[1])
+	if !strings.Contains(baseFile, pathSep) {
+		baseFile = this.toolManager.workingDirectory + baseFile
+	}
+	this.baseFile = baseFile
+	if _, err := os.Stat(this.baseFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.baseFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[2])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.method = ""
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setMethod(args[3]) {
+			return
+		}
+	}
+	this.tolerance = defaultAlignTolerance
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if !this.setTolerance(args[4]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *AlignRasters) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the base file name
+	print("Enter the base raster file name (incl. file extension): ")
+	baseFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	baseFile = strings.TrimSpace(baseFile)
+	if !strings.Contains(baseFile, pathSep) {
+		baseFile = this.toolManager.workingDirectory + baseFile
+	}
+	this.baseFile = baseFile
+	if _, err := os.Stat(this.baseFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.baseFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the resampling method
+	this.method = ""
+	print("Resampling method, nearest or bilinear (blank for default): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(method)) > 0 {
+		if !this.setMethod(method) {
+			return
+		}
+	}
+
+	// get the tolerance
+	this.tolerance = defaultAlignTolerance
+	print("Cell size tolerance, as a fraction (blank for 0.01): ")
+	toleranceStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(toleranceStr)) > 0 {
+		if !this.setTolerance(toleranceStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *AlignRasters) setMethod(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "nearest", "bilinear":
+		this.method = s
+		return true
+	}
+	println("Unrecognized resampling method; use 'nearest' or 'bilinear'.")
+	return false
+}
+
+func (this *AlignRasters) setTolerance(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 0 {
+		println("The tolerance must not be negative.")
+		return false
+	}
+	this.tolerance = v
+	return true
+}
+
+func (this *AlignRasters) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	base, err := raster.CreateRasterFromFile(this.baseFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	inConfig := rin.GetRasterConfig()
+	baseConfig := base.GetRasterConfig()
+	if inConfig.EPSGCode != 0 && baseConfig.EPSGCode != 0 && inConfig.EPSGCode != baseConfig.EPSGCode {
+		printf("The input (EPSG:%v) and base (EPSG:%v) coordinate systems differ.\n", inConfig.EPSGCode, baseConfig.EPSGCode)
+		return
+	}
+
+	start2 := time.Now()
+
+	if onSameGrid(base, rin) {
+		println("The input raster is already on the grid of the base raster.")
+	}
+	aligned, err := alignRaster(base, rin, this.tolerance, this.method)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = rin.NoDataValue
+	config.InitialValue = rin.NoDataValue
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = baseConfig.XYUnits
+	config.CoordinateRefSystemWKT = baseConfig.CoordinateRefSystemWKT
+	config.EPSGCode = baseConfig.EPSGCode
+	if config.EPSGCode == 0 && config.CoordinateRefSystemWKT == "" {
+		config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+		config.EPSGCode = inConfig.EPSGCode
+	}
+	rout, err := raster.CreateNewRaster(this.outputFile, base.Rows, base.Columns,
+		base.North, base.South, base.East, base.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	numValid := 0
+	for row := 0; row < base.Rows; row++ {
+		for col := 0; col < base.Columns; col++ {
+			if z := aligned.Value(row, col); z != rin.NoDataValue {
+				rout.SetValue(row, col, z)
+				numValid++
+			}
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by AlignRasters tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Aligned to the grid of %s", this.baseFile))
+	rout.Save()
+
+	printf("Output dimensions: %v rows x %v columns (%v valid cells)\n", base.Rows, base.Columns, numValid)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Returns true if two rasters have identical dimensions, extents and cell
+// sizes.
+func onSameGrid(a, b *raster.Raster) bool {
+	return a.Rows == b.Rows && a.Columns == b.Columns &&
+		a.North == b.North && a.West == b.West &&
+		a.GetCellSizeX() == b.GetCellSizeX() && a.GetCellSizeY() == b.GetCellSizeY()
+}
+
+// Returns r on the grid of base. If the two are already on the same grid, r
+// itself is returned; otherwise r is cropped and resampled into a new,
+// in-memory raster, provided that its cell sizes agree with those of base to
+// within tolerance (a fraction of the base cell size) and the two overlap,
+// and a warning is printed. The method is 'nearest' or 'bilinear'; if it is
+// empty, nearest-neighbour resampling is used for integer rasters.
+func alignRaster(base, r *raster.Raster, tolerance float64, method string) (*raster.Raster, error) {
+	if onSameGrid(base, r) {
+		return r, nil
+	}
+	cellSizeX := base.GetCellSizeX()
+	cellSizeY := base.GetCellSizeY()
+	inCellSizeX := r.GetCellSizeX()
+	inCellSizeY := r.GetCellSizeY()
+	if math.Abs(inCellSizeX-cellSizeX) > tolerance*cellSizeX || math.Abs(inCellSizeY-cellSizeY) > tolerance*cellSizeY {
+		return nil, fmt.Errorf("The cell size of %s (%v x %v) differs from that of %s (%v x %v) by more than the tolerance.",
+			r.FileName, inCellSizeX, inCellSizeY, base.FileName, cellSizeX, cellSizeY)
+	}
+	if !r.Bounds().Overlaps(base.Bounds()) {
+		return nil, fmt.Errorf("%s does not overlap %s.", r.FileName, base.FileName)
+	}
+	inConfig := r.GetRasterConfig()
+	if method == "" {
+		method = "bilinear"
+		if inConfig.DataType != raster.DT_FLOAT32 && inConfig.DataType != raster.DT_FLOAT64 {
+			method = "nearest"
+		}
+	}
+
+	// an unsaved raster holds the aligned values
+	nodata := r.NoDataValue
+	config := raster.NewDefaultRasterConfig()
+	config.RasterFormat = raster.RT_GeoTiff
+	config.DataType = inConfig.DataType
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	aligned, err := raster.CreateNewRaster("", base.Rows, base.Columns,
+		base.North, base.South, base.East, base.West, config)
+	if err != nil {
+		return nil, err
+	}
+	aligned.FileName = r.FileName
+	numOutside := 0
+	for row := 0; row < base.Rows; row++ {
+		y := base.North - (float64(row)+0.5)*cellSizeY
+		inRow := (r.North-y)/inCellSizeY - 0.5
+		for col := 0; col < base.Columns; col++ {
+			x := base.West + (float64(col)+0.5)*cellSizeX
+			inCol := (x-r.West)/inCellSizeX - 0.5
+			if inRow < -0.5 || inCol < -0.5 || inRow > float64(r.Rows)-0.5 || inCol > float64(r.Columns)-0.5 {
+				numOutside++
+				continue
+			}
+			var z float64
+			if method == "nearest" {
+				z = r.Value(int(math.Floor(inRow+0.5)), int(math.Floor(inCol+0.5)))
+			} else {
+				z = bilinearValue(r, inRow, inCol)
+			}
+			aligned.SetValue(row, col, z)
+		}
+	}
+	printf("Warning: %s is not on the grid of %s; it was aligned using %s resampling", r.FileName, base.FileName, method)
+	if numOutside > 0 {
+		printf(" (%v cells are not covered)", numOutside)
+	}
+	printf(".\n")
+	return aligned, nil
+}
@@ -0,0 +1,561 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// AnisotropicDeviation calculates the maximum deviation from mean elevation
+// (DEV) across a range of scales using elongated, rotated windows, and
+// outputs the magnitude, scale and orientation of the maximum.
+type AnisotropicDeviation struct {
+	inputFile             string
+	magOutputFile         string
+	scaleOutputFile       string
+	orientationOutputFile string
+	minNeighbourhood      int
+	maxNeighbourhood      int
+	neighbourhoodStep     int
+	aspectRatio           float64
+	numOrientations       int
+	toolManager           *PluginToolManager
+}
+
+func (this *AnisotropicDeviation) GetName() string {
+	s := "AnisotropicDeviation"
+	return getFormattedToolName(s)
+}
+
+func (this *AnisotropicDeviation) GetDescription() string {
+	s := "Maximum DEV across scales and window orientations"
+	return getFormattedToolDescription(s)
+}
+
+func (this *AnisotropicDeviation) GetVersion() string {
+	return "1.0"
+}
+
+func (this *AnisotropicDeviation) GetHelpDocumentation() string {
+	ret := "This tool extends the MaxElevationDeviation tool with directional windows. Each " +
+		"window is a rectangle whose long axis has a half-length of the neighbourhood size " +
+		"and whose half-width is the half-length divided by the AspectRatio (at least one " +
+		"cell), rotated to each of NumOrientations evenly spaced azimuths between 0 and 180 " +
+		"degrees. For every cell, the deviation from mean elevation (DEV) with the greatest " +
+		"magnitude across all scales and orientations is output, together with its scale (the " +
+		"half-length in grid cells) and orientation (the azimuth of the window's long axis in " +
+		"degrees clockwise from north), so that elongated landforms such as lineaments and " +
+		"drumlins can be detected from orientation-specific scale signatures. Note that the " +
+		"deviation of a linear feature is usually greatest in windows that cross it, i.e. " +
+		"perpendicular to its long axis. The rotated windows are evaluated on a " +
+		"nearest-neighbour rotated copy of the grid."
+	return ret
+}
+
+func (this *AnisotropicDeviation) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *AnisotropicDeviation) GetArgDescriptions() []ToolArg {
+	numArgs := 9
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputMagnitudeFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The magnitude output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "OutputScaleFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The scale output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "OutputOrientationFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The orientation output filename, with directory and file extension"
+	ret[3].Role = ArgOutputRaster
+	ret[3].Required = true
+
+	ret[4].Name = "MinNeighbourhoodSize"
+	ret[4].Type = "int"
+	ret[4].Description = "The starting half-length of the window in grid cells"
+	ret[4].Default = "1"
+
+	ret[5].Name = "MaxNeighbourhoodSize"
+	ret[5].Type = "int"
+	ret[5].Description = "The ending half-length of the window in grid cells"
+	ret[5].Default = "3"
+
+	ret[6].Name = "NeighbourhoodStep"
+	ret[6].Type = "int"
+	ret[6].Description = "The neighbourhood step size in grid cells"
+	ret[6].Default = "1"
+
+	ret[7].Name = "AspectRatio"
+	ret[7].Type = "float64"
+	ret[7].Description = "The ratio of window length to width"
+	ret[7].Default = "3"
+
+	ret[8].Name = "NumOrientations"
+	ret[8].Type = "int"
+	ret[8].Description = "The number of window orientations"
+	ret[8].Default = "8"
+
+	return ret
+}
+
+func (this *AnisotropicDeviation) EstimateMemory(rows, columns int) int64 {
+	// the input and three output rasters, the per-cell maximum deviation,
+	// scale and orientation, and the integral images of the rotated grid,
+	// which is largest at 45 degrees
+	rotated := (rows + columns) * (rows + columns) / 2
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+8+4+4) + gridBytes(rotated, 1, 8+8+4)
+}
+
+func (this *AnisotropicDeviation) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input DEM and the three output files must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFiles := []*string{&this.magOutputFile, &this.scaleOutputFile, &this.orientationOutputFile}
+	for i, f := range outputFiles {
+		outputFile := strings.TrimSpace(args[i+1])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		*f = outputFile
+	}
+
+	this.minNeighbourhood = 1
+	this.maxNeighbourhood = 3
+	this.neighbourhoodStep = 1
+	this.numOrientations = 8
+	values := []*int{&this.minNeighbourhood, &this.maxNeighbourhood, &this.neighbourhoodStep}
+	for i, v := range values {
+		if len(args) > i+4 && len(strings.TrimSpace(args[i+4])) > 0 && args[i+4] != "not specified" {
+			val, err := strconv.Atoi(strings.TrimSpace(args[i+4]))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	this.aspectRatio = 3.0
+	if len(args) > 7 && len(strings.TrimSpace(args[7])) > 0 && args[7] != "not specified" {
+		val, err := strconv.ParseFloat(strings.TrimSpace(args[7]), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.aspectRatio = val
+	}
+
+	if len(args) > 8 && len(strings.TrimSpace(args[8])) > 0 && args[8] != "not specified" {
+		val, err := strconv.Atoi(strings.TrimSpace(args[8]))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.numOrientations = val
+	}
+
+	this.Run()
+}
+
+func (this *AnisotropicDeviation) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file names
+	prompts := []string{"Enter the magnitude output file name (incl. file extension): ",
+		"Enter the scale output file name (incl. file extension): ",
+		"Enter the orientation output file name (incl. file extension): "}
+	outputFiles := []*string{&this.magOutputFile, &this.scaleOutputFile, &this.orientationOutputFile}
+	for i, f := range outputFiles {
+		print(prompts[i])
+		outputFile, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		outputFile = strings.TrimSpace(outputFile)
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		*f = outputFile
+	}
+
+	// get the neighbourhood sizes
+	this.minNeighbourhood = 1
+	this.maxNeighbourhood = 3
+	this.neighbourhoodStep = 1
+	prompts = []string{"Minimum window half-length in grid cells (default 1): ",
+		"Maximum window half-length in grid cells (default 3): ",
+		"Neighbourhood step size in grid cells (default 1): "}
+	values := []*int{&this.minNeighbourhood, &this.maxNeighbourhood, &this.neighbourhoodStep}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.Atoi(strings.TrimSpace(str))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	// get the aspect ratio
+	print("Window aspect ratio, length/width (default 3): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.aspectRatio = 3.0
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.aspectRatio, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the number of orientations
+	print("Number of window orientations (default 8): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.numOrientations = 8
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.numOrientations, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *AnisotropicDeviation) Run() {
+	start1 := time.Now()
+
+	if this.minNeighbourhood < 1 || this.maxNeighbourhood < this.minNeighbourhood || this.neighbourhoodStep < 1 {
+		println("The neighbourhood sizes must satisfy 1 <= min <= max and the step must be at least 1.")
+		return
+	}
+	if this.aspectRatio < 1 {
+		println("The aspect ratio must be at least 1.")
+		return
+	}
+	if this.numOrientations < 1 {
+		println("There must be at least one window orientation.")
+		return
+	}
+
+	println("Reading DEM data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	minValue := rin.GetMinimumValue()
+	maxValue := rin.GetMaximumValue()
+	k := minValue + (maxValue-minValue)/2.0
+
+	start2 := time.Now()
+
+	numCells := rows * columns
+	maxVal := make([]float64, numCells)
+	scaleVal := make([]int32, numCells)
+	orientVal := make([]float32, numCells)
+	for i := range maxVal {
+		maxVal[i] = -math.MaxFloat32
+	}
+
+	numCPUs := this.toolManager.numThreads()
+	runtime.GOMAXPROCS(numCPUs)
+
+	// the integral images of the rotated grid, with a leading row and column
+	// of zeros, reused for each orientation
+	var I, I2 []float64
+	var IN []int32
+
+	var progress, oldProgress int
+	for o := 0; o < this.numOrientations; o++ {
+		azimuth := 180.0 * float64(o) / float64(this.numOrientations)
+		theta := azimuth * math.Pi / 180.0
+		// unit vectors along and across the long axis of the window, with x
+		// increasing eastward (col) and y northward (-row)
+		ax, ay := math.Sin(theta), math.Cos(theta)
+		px, py := math.Cos(theta), -math.Sin(theta)
+		toU := func(row, col int) float64 { return float64(col)*ax - float64(row)*ay }
+		toV := func(row, col int) float64 { return float64(col)*px - float64(row)*py }
+
+		uMin, uMax := math.Inf(1), math.Inf(-1)
+		vMin, vMax := math.Inf(1), math.Inf(-1)
+		for _, corner := range [][2]int{{0, 0}, {0, columns - 1}, {rows - 1, 0}, {rows - 1, columns - 1}} {
+			u, v := toU(corner[0], corner[1]), toV(corner[0], corner[1])
+			uMin, uMax = math.Min(uMin, u), math.Max(uMax, u)
+			vMin, vMax = math.Min(vMin, v), math.Max(vMax, v)
+		}
+		uMin, vMin = math.Floor(uMin), math.Floor(vMin)
+		nU := int(math.Ceil(uMax)-uMin) + 1
+		nV := int(math.Ceil(vMax)-vMin) + 1
+		stride := nV + 1
+		size := (nU + 1) * stride
+		if cap(I) < size {
+			I = make([]float64, size)
+			I2 = make([]float64, size)
+			IN = make([]int32, size)
+		}
+		I, I2, IN = I[:size], I2[:size], IN[:size]
+
+		// sample the rotated grid and accumulate each of its rows
+		var wg sync.WaitGroup
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			wg.Add(1)
+			go func(cpu int) {
+				defer wg.Done()
+				for ri := cpu; ri <= nU; ri += numCPUs {
+					k0 := ri * stride
+					I[k0], I2[k0], IN[k0] = 0, 0, 0
+					if ri == 0 {
+						for ci := 1; ci <= nV; ci++ {
+							I[ci], I2[ci], IN[ci] = 0, 0, 0
+						}
+						continue
+					}
+					u := uMin + float64(ri-1)
+					var sum, sumSqr float64
+					var n int32
+					for ci := 1; ci <= nV; ci++ {
+						v := vMin + float64(ci-1)
+						col := int(math.Floor(u*ax + v*px + 0.5))
+						row := int(math.Floor(-(u*ay + v*py) + 0.5))
+						if row >= 0 && row < rows && col >= 0 && col < columns {
+							if z := rin.Value(row, col); z != nodata {
+								z -= k
+								sum += z
+								sumSqr += z * z
+								n++
+							}
+						}
+						I[k0+ci], I2[k0+ci], IN[k0+ci] = sum, sumSqr, n
+					}
+				}
+			}(cpu)
+		}
+		wg.Wait()
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			wg.Add(1)
+			go func(cpu int) {
+				defer wg.Done()
+				for ci := 1 + cpu; ci <= nV; ci += numCPUs {
+					for ri := 2; ri <= nU; ri++ {
+						kk := ri*stride + ci
+						I[kk] += I[kk-stride]
+						I2[kk] += I2[kk-stride]
+						IN[kk] += IN[kk-stride]
+					}
+				}
+			}(cpu)
+		}
+		wg.Wait()
+
+		// the DEV of each cell within windows of each scale at this orientation
+		c1 := make(chan bool)
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			go func(cpu int) {
+				for row := cpu; row < rows; row += numCPUs {
+					for col := 0; col < columns; col++ {
+						z := rin.Value(row, col)
+						if z == nodata {
+							continue
+						}
+						i := row*columns + col
+						ri := int(math.Floor(toU(row, col)-uMin+0.5)) + 1
+						ci := int(math.Floor(toV(row, col)-vMin+0.5)) + 1
+						for L := this.minNeighbourhood; L <= this.maxNeighbourhood; L += this.neighbourhoodStep {
+							W := int(math.Floor(float64(L)/this.aspectRatio + 0.5))
+							if W < 1 {
+								W = 1
+							}
+							y1, y2 := ri-L-1, ri+L
+							x1, x2 := ci-W-1, ci+W
+							if y1 < 0 {
+								y1 = 0
+							}
+							if y2 > nU {
+								y2 = nU
+							}
+							if x1 < 0 {
+								x1 = 0
+							}
+							if x2 > nV {
+								x2 = nV
+							}
+							a, b, c, d := y2*stride+x2, y1*stride+x1, y1*stride+x2, y2*stride+x1
+							N := IN[a] + IN[b] - IN[c] - IN[d]
+							if N == 0 {
+								continue
+							}
+							sum := I[a] + I[b] - I[c] - I[d]
+							sumSqr := I2[a] + I2[b] - I2[c] - I2[d]
+							v := (sumSqr - (sum*sum)/float64(N)) / float64(N)
+							if v <= 0 {
+								continue
+							}
+							outValue := ((z - k) - sum/float64(N)) / math.Sqrt(v)
+							if math.Abs(outValue) > maxVal[i] {
+								maxVal[i] = math.Abs(outValue)
+								if outValue >= 0 {
+									scaleVal[i] = int32(L)
+								} else {
+									scaleVal[i] = -int32(L)
+								}
+								orientVal[i] = float32(azimuth)
+							}
+						}
+					}
+					c1 <- true // row completed
+				}
+			}(cpu)
+		}
+
+		oldProgress = -1
+		for rowsCompleted := 0; rowsCompleted < rows; rowsCompleted++ {
+			<-c1 // a row has successfully completed
+			progress = int(100.0 * float64(rowsCompleted+1) / float64(rows))
+			if progress%5 == 0 && progress != oldProgress {
+				printf("Orientation %v of %v: %v%%\n", o+1, this.numOrientations, progress)
+				oldProgress = progress
+			}
+		}
+	}
+
+	// output the data
+	outputs := make([]*raster.Raster, 3)
+	palettes := []string{"blue_white_red.plt", "imhof1.plt", "circular_bw.pal"}
+	for j, fileName := range []string{this.magOutputFile, this.scaleOutputFile, this.orientationOutputFile} {
+		config := raster.NewDefaultRasterConfig()
+		config.PreferredPalette = palettes[j]
+		config.DataType = raster.DT_FLOAT32
+		config.NoDataValue = nodata
+		config.InitialValue = nodata
+		config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+		config.EPSGCode = inConfig.EPSGCode
+		if j == 0 {
+			config.DisplayMinimum = -3.0
+			config.DisplayMaximum = 3.0
+		}
+		outputs[j], err = raster.CreateNewRaster(fileName, rows, columns,
+			rin.North, rin.South, rin.East, rin.West, config)
+		if err != nil {
+			println("Failed to write raster")
+			return
+		}
+	}
+
+	println("Saving the outputs...")
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if maxVal[i] > -math.MaxFloat32 {
+				if scaleVal[i] >= 0 {
+					outputs[0].SetValue(row, col, maxVal[i])
+					outputs[1].SetValue(row, col, float64(scaleVal[i]))
+				} else {
+					outputs[0].SetValue(row, col, -maxVal[i])
+					outputs[1].SetValue(row, col, float64(-scaleVal[i]))
+				}
+				outputs[2].SetValue(row, col, float64(orientVal[i]))
+			}
+		}
+	}
+
+	elapsed := time.Since(start2)
+	for _, rout := range outputs {
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by AnisotropicDeviation tool (%s)", this.toolManager.toolVersion(this)))
+		rout.AddMetadataEntry(fmt.Sprintf("Min. window half-length: %v", this.minNeighbourhood))
+		rout.AddMetadataEntry(fmt.Sprintf("Max. window half-length: %v", this.maxNeighbourhood))
+		rout.AddMetadataEntry(fmt.Sprintf("Step size: %v", this.neighbourhoodStep))
+		rout.AddMetadataEntry(fmt.Sprintf("Aspect ratio: %v", this.aspectRatio))
+		rout.AddMetadataEntry(fmt.Sprintf("Num. orientations: %v", this.numOrientations))
+		rout.Save()
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -10,7 +10,6 @@ package tools
 import (
 	"bufio"
 	"fmt"
-	"math"
 	"os"
 	"runtime"
 	"strings"
@@ -23,6 +22,7 @@ import (
 type Aspect struct {
 	inputFile   string
 	outputFile  string
+	method      string
 	toolManager *PluginToolManager
 }
 
@@ -37,8 +37,18 @@ func (this *Aspect) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *Aspect) GetVersion() string {
+	return "1.1"
+}
+
 func (this *Aspect) GetHelpDocumentation() string {
-	ret := ""
+	ret := "This tool calculates the aspect of each cell of a digital elevation model (DEM), " +
+		"i.e. the direction that its slope faces, in degrees clockwise from north, with " +
+		"level cells assigned -1. The surface gradient is estimated from the 3 x 3 " +
+		"neighbourhood of each cell by the Method of Horn (1981), the default, or by the " +
+		"quadratic surface of Evans (1979). Nodata neighbours take the elevation of the " +
+		"cell. If the DEM is in geographic coordinates, its cell sizes are converted from " +
+		"degrees to metres at its mid-latitude."
 	return ret
 }
 
@@ -46,20 +56,27 @@ func (this *Aspect) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *Aspect) GetArgDescriptions() [][]string {
-	numArgs := 2
+func (this *Aspect) GetArgDescriptions() []ToolArg {
+	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM File name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Method"
+	ret[2].Type = "string"
+	ret[2].Description = "The method of estimating the surface gradient: horn or evans"
+	ret[2].Default = "horn"
+	ret[2].Choices = []string{"horn", "evans"}
 
 	return ret
 }
@@ -72,7 +89,7 @@ func (this *Aspect) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -83,10 +100,18 @@ func (this *Aspect) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	method := ""
+	if len(args) > 2 && args[2] != "not specified" {
+		method = args[2]
+	}
+	if !this.setMethod(method) {
+		return
+	}
+
 	this.Run()
 }
 
@@ -105,7 +130,7 @@ func (this *Aspect) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -122,17 +147,44 @@ func (this *Aspect) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	// get the method
+	print("Gradient method, 'horn' or 'evans' (blank for the default): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setMethod(method) {
+		return
+	}
+
 	this.Run()
 }
 
+// setMethod sets the method of estimating the surface gradient, Horn's if s
+// is blank.
+func (this *Aspect) setMethod(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "horn":
+		this.method = "horn"
+	case "evans":
+		this.method = "evans"
+	default:
+		printf("unrecognized method: %s\n", s)
+		return false
+	}
+	return true
+}
+
 func (this *Aspect) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress int
+	var eta progressETA
 
 	println("Reading raster data...")
 	rin, err := raster.CreateRasterFromFile(this.inputFile)
@@ -147,10 +199,6 @@ func (this *Aspect) Run() {
 	rowsLessOne := rows - 1
 	nodata := rin.NoDataValue
 	inConfig := rin.GetRasterConfig()
-	gridRes := (rin.GetCellSizeX() + rin.GetCellSizeY()) / 2.0
-	eightGridRes := 8 * gridRes
-	const radToDeg float64 = 180.0 / math.Pi
-	rin.GetRasterConfig()
 
 	// create the output raster
 	config := raster.NewDefaultRasterConfig()
@@ -167,19 +215,17 @@ func (this *Aspect) Run() {
 		return
 	}
 
-	zConvFactor := 1.0
-	if rin.IsInGeographicCoordinates() {
-		// calculate a new z-conversion factor
-		midLat := (rin.North - rin.South) / 2.0
-		if midLat <= 90 && midLat >= -90 {
-			zConvFactor = 1.0 / (113200 * math.Cos(math.Pi/180.0*midLat))
-		}
+	cellSizeX, cellSizeY := metricCellSizes(rin)
+	derivatives := hornDerivatives
+	if this.method == "evans" {
+		derivatives = evansDerivatives
 	}
 
-	numCPUs := runtime.NumCPU()
-	c1 := make(chan bool)
+	numCPUs := this.toolManager.numThreads()
+	c1 := make(chan bool, rows)
 	runtime.GOMAXPROCS(numCPUs)
 	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	// calculate aspect
 	printf("\r                                                    ")
@@ -197,34 +243,15 @@ func (this *Aspect) Run() {
 		wg.Add(1)
 		go func(rowSt, rowEnd, k int) {
 			defer wg.Done()
-			var z, zN, fy, fx, value float64
-			dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
-			dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
-			N := [8]float64{}
 			for row := rowSt; row <= rowEnd; row++ {
+				if interrupted() {
+					c1 <- true
+					continue
+				}
 				floatData := make([]float64, columns)
 				for col := 0; col < columns; col++ {
-					z = rin.Value(row, col)
-					if z != nodata {
-						z = z * zConvFactor
-						for n := 0; n < 8; n++ {
-							zN = rin.Value(row+dY[n], col+dX[n])
-							if zN != nodata {
-								N[n] = zN * zConvFactor
-							} else {
-								N[n] = z
-							}
-						}
-
-						fy = (N[6] - N[4] + 2*(N[7]-N[3]) + N[0] - N[2]) / eightGridRes
-						fx = (N[2] - N[4] + 2*(N[1]-N[5]) + N[0] - N[6]) / eightGridRes
-
-						if fx != 0 {
-							value = 180 - math.Atan(fy/fx)*radToDeg + 90*(fx/math.Abs(fx))
-							floatData[col] = value
-						} else {
-							floatData[col] = -1.0
-						}
+					if d, ok := derivatives(rin, row, col, cellSizeX, cellSizeY); ok {
+						floatData[col] = d.aspect()
 					} else {
 						floatData[col] = nodata
 					}
@@ -242,7 +269,7 @@ func (this *Aspect) Run() {
 		<-c1 // a row has successfully completed
 		progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
 		if progress != oldProgress {
-			printf("\rProgress: %v%%", progress)
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -255,7 +282,7 @@ func (this *Aspect) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by Slope"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Aspect tool (%s)", this.toolManager.toolVersion(this)))
 	rout.Save()
 
 	println("Operation complete!")
@@ -0,0 +1,267 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// AssignCRS stamps a coordinate reference system, specified either as an
+// EPSG code or as a WKT (.prj) file, onto a raster.
+type AssignCRS struct {
+	inputFile   string
+	crs         string
+	outputFile  string
+	toolManager *PluginToolManager
+}
+
+func (this *AssignCRS) GetName() string {
+	s := "AssignCRS"
+	return getFormattedToolName(s)
+}
+
+func (this *AssignCRS) GetDescription() string {
+	s := "Assigns a coordinate reference system to a raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *AssignCRS) GetVersion() string {
+	return "1.0"
+}
+
+func (this *AssignCRS) GetHelpDocumentation() string {
+	ret := "This tool assigns (or overrides) the coordinate reference system (CRS) of a raster. " +
+		"The CRS can be specified either as a numeric EPSG code (e.g. 26917) or as the name of " +
+		"a .prj file containing a WKT CRS description. The grid values are not modified; this " +
+		"tool does not reproject data. Whitebox, GeoTIFF, and Idrisi rasters store the CRS in " +
+		"their header, a GeoTIFF keeping a CRS without an EPSG code as WKT in its citation " +
+		"geokey, as GDAL does, while ArcGIS and GRASS rasters use a .prj sidecar file. If no output " +
+		"file is specified, the input raster is overwritten."
+	return ret
+}
+
+func (this *AssignCRS) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *AssignCRS) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "CRS"
+	ret[1].Type = "string"
+	ret[1].Description = "An EPSG code or the name of a .prj (WKT) file"
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename (overwrites the input if not specified)"
+	ret[2].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *AssignCRS) ParseArguments(args []string) {
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	this.crs = strings.TrimSpace(args[1])
+
+	this.outputFile = this.inputFile
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		outputFile := strings.TrimSpace(args[2])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *AssignCRS) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the CRS
+	print("Enter an EPSG code or .prj file name: ")
+	crs, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.crs = strings.TrimSpace(crs)
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension; blank to overwrite input): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputFile = this.inputFile
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+// Interprets the CRS argument, which is either an EPSG code or the name of
+// a file containing a WKT string (or an "EPSG:code" string).
+func (this *AssignCRS) parseCRS() (wkt string, epsg int, err error) {
+	str := strings.TrimSpace(this.crs)
+	if strings.HasPrefix(strings.ToUpper(str), "EPSG") {
+		str = strings.TrimLeft(str[4:], ":_- ")
+	}
+	if code, err := strconv.Atoi(str); err == nil {
+		if code <= 0 {
+			return "", 0, errors.New("The EPSG code must be a positive integer.")
+		}
+		return "", code, nil
+	}
+
+	fileName := str
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", 0, fmt.Errorf("Unable to read the CRS file: %s", fileName)
+	}
+	wkt = strings.TrimSpace(string(content))
+	if code := raster.ParseEPSGString(wkt); code > 0 {
+		return "", code, nil
+	}
+	return wkt, raster.EPSGCodeFromWKT(wkt), nil
+}
+
+func (this *AssignCRS) Run() {
+	start1 := time.Now()
+
+	wkt, epsg, err := this.parseCRS()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	inConfig := rin.GetRasterConfig()
+	data, err := rin.Data()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = rin.NoDataValue
+	config.InitialValue = rin.NoDataValue
+	config.PhotometricInterpretation = inConfig.PhotometricInterpretation
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.PixelIsArea = inConfig.PixelIsArea
+	config.CoordinateRefSystemWKT = wkt
+	config.EPSGCode = epsg
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	rout.SetData(data)
+
+	for _, entry := range rin.GetMetadataEntries() {
+		if len(strings.TrimSpace(entry)) > 0 {
+			rout.AddMetadataEntry(entry)
+		}
+	}
+	if epsg > 0 {
+		rout.AddMetadataEntry(fmt.Sprintf("CRS assigned by AssignCRS tool: EPSG %v", epsg))
+	} else {
+		rout.AddMetadataEntry("CRS assigned by AssignCRS tool from WKT")
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by AssignCRS tool (%s)", this.toolManager.toolVersion(this)))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
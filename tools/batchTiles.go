@@ -0,0 +1,410 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// BatchTiles runs a tool on each of the tiles listed in a tile index.
+type BatchTiles struct {
+	indexFile   string
+	toolName    string
+	argTemplate string
+	buffer      int
+	toolManager *PluginToolManager
+}
+
+func (this *BatchTiles) GetName() string {
+	s := "BatchTiles"
+	return getFormattedToolName(s)
+}
+
+func (this *BatchTiles) GetDescription() string {
+	s := "Runs a tool on each tile of a tile index"
+	return getFormattedToolDescription(s)
+}
+
+func (this *BatchTiles) GetVersion() string {
+	return "1.0"
+}
+
+func (this *BatchTiles) GetHelpDocumentation() string {
+	ret := "This tool runs another tool on each of the raster tiles listed in a tile index " +
+		"created by the TileIndex tool, the usual way of processing large lidar datasets. " +
+		"The ArgTemplate gives the arguments of the tool separated by spaces, since commas " +
+		"and semicolons separate the arguments of this tool, or by '|' characters when the " +
+		"tool is run interactively. In each argument, {tile} is replaced by the full file " +
+		"name of the tile, {name} by the file name without its directory and extension, " +
+		"{dir} by the tile's directory, {index} by the tile's position in the index, padded " +
+		"with zeros to a fixed width, e.g. 007, {tool} by the name of the tool and {date} by " +
+		"the date on which the batch started, as YYYYMMDD, e.g. the template " +
+		"'{tile} {dir}/{name}_{tool}_{date}.tif' runs FillDepressions on every tile, writing " +
+		"each output beside its tile. Before any tile is run, the arguments of the tiles are checked " +
+		"for collisions, i.e. an argument that differs between tiles but is the same for two " +
+		"of them, such as {name} for tiles of the same name in different directories, or an " +
+		"output argument of the tool that is the same for every tile, either of which would " +
+		"overwrite one tile's output with another's; adding {dir} or {index} to the argument " +
+		"resolves it. " +
+		"If a Buffer of n cells is specified, each tile is first expanded by n cells on each " +
+		"side with the cells of the neighbouring tiles in the index, so that focal and flow " +
+		"tools do not produce artifacts at the tile seams; {tile} then refers to a temporary " +
+		"buffered copy of the tile, and each output raster on the buffered grid is trimmed " +
+		"back to the extent of the tile once the tool has run. Tiles are processed in the " +
+		"order of the index and a tile whose run fails does not stop the batch."
+	return ret
+}
+
+func (this *BatchTiles) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *BatchTiles) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "IndexFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The tile index CSV file created by TileIndex"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "ToolName"
+	ret[1].Type = "string"
+	ret[1].Description = "The name of the tool to run on each tile"
+	ret[1].Required = true
+
+	ret[2].Name = "ArgTemplate"
+	ret[2].Type = "string"
+	ret[2].Description = "The tool's arguments, with {tile}, {name}, {dir}, {index}, {tool} and {date}"
+	ret[2].Required = true
+
+	ret[3].Name = "Buffer"
+	ret[3].Type = "int"
+	ret[3].Description = "Cells of neighbouring tiles to add"
+	ret[3].Default = "0"
+
+	return ret
+}
+
+func (this *BatchTiles) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The index file, tool name, and argument template must be specified.")
+		return
+	}
+	if !this.setIndexFile(args[0]) {
+		return
+	}
+	if !this.setToolName(args[1]) {
+		return
+	}
+	this.argTemplate = strings.TrimSpace(args[2])
+	this.buffer = 0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setBuffer(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *BatchTiles) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the index file name
+	print("Enter the tile index file name (incl. file extension): ")
+	indexFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setIndexFile(indexFile) {
+		return
+	}
+
+	// get the tool name
+	print("Enter the name of the tool to run: ")
+	toolName, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setToolName(toolName) {
+		return
+	}
+
+	// get the argument template
+	print("Enter the tool's arguments, separated by spaces or '|': ")
+	argTemplate, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.argTemplate = strings.TrimSpace(argTemplate)
+
+	// get the buffer size
+	this.buffer = 0
+	print("Buffer size, in cells (blank for none): ")
+	bufferStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(bufferStr)) > 0 {
+		if !this.setBuffer(bufferStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *BatchTiles) setIndexFile(s string) bool {
+	indexFile := strings.TrimSpace(s)
+	if !strings.Contains(indexFile, pathSep) {
+		indexFile = this.toolManager.workingDirectory + indexFile
+	}
+	if _, err := os.Stat(indexFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", indexFile)
+		return false
+	}
+	this.indexFile = indexFile
+	return true
+}
+
+func (this *BatchTiles) setToolName(s string) bool {
+	toolName := strings.ToLower(getFormattedToolName(strings.TrimSpace(s)))
+	if _, ok := this.toolManager.mapOfPluginTools[toolName]; !ok {
+		printf("Unrecognized tool name: %s\n", strings.TrimSpace(s))
+		return false
+	}
+	if toolName == strings.ToLower(this.GetName()) {
+		println("BatchTiles cannot run itself.")
+		return false
+	}
+	this.toolName = toolName
+	return true
+}
+
+func (this *BatchTiles) setBuffer(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 0 {
+		println("The buffer size must not be negative.")
+		return false
+	}
+	this.buffer = v
+	return true
+}
+
+func (this *BatchTiles) Run() {
+	start1 := time.Now()
+
+	tiles, err := readTileIndex(this.indexFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if len(tiles) == 0 {
+		println("The tile index does not contain any tiles.")
+		return
+	}
+
+	// the arguments are split before the placeholders are replaced, so that
+	// file names containing spaces are kept intact
+	var templateArgs []string
+	if strings.Contains(this.argTemplate, "|") {
+		templateArgs = strings.Split(this.argTemplate, "|")
+		for j := range templateArgs {
+			templateArgs[j] = strings.TrimSpace(templateArgs[j])
+		}
+	} else {
+		templateArgs = strings.Fields(this.argTemplate)
+	}
+
+	// buffered tiles are written to a temporary directory
+	var tempDir string
+	if this.buffer > 0 {
+		if tempDir, err = ioutil.TempDir("", "gospatial-tiles"); err != nil {
+			println(err.Error())
+			return
+		}
+		defer os.RemoveAll(tempDir)
+	}
+
+	// the arguments of every tile are expanded first, so that colliding
+	// outputs are found before any are written
+	tool := this.toolManager.mapOfPluginTools[this.toolName]
+	toolName := tool.GetName()
+	date := start1.Format("20060102")
+	tileFiles := make([]string, len(tiles))
+	tileArgs := make([][]string, len(tiles))
+	for i, t := range tiles {
+		tileFiles[i] = t.fileName
+		if this.buffer > 0 {
+			// numbered, since tiles in different directories may share a name
+			tileFiles[i] = filepath.Join(tempDir, fmt.Sprintf("%v_%s", i+1, filepath.Base(t.fileName)))
+		}
+		dir := filepath.Dir(t.fileName)
+		if isZipArchive(dir) {
+			// the outputs of a tile read from within an archive go beside it
+			dir = filepath.Dir(dir)
+		}
+		placeholders := map[string]string{
+			"tile":  tileFiles[i],
+			"name":  strings.TrimSuffix(filepath.Base(t.fileName), filepath.Ext(t.fileName)),
+			"dir":   dir,
+			"index": fmt.Sprintf("%0*d", len(strconv.Itoa(len(tiles))), i+1),
+			"tool":  toolName,
+			"date":  date,
+		}
+		tileArgs[i] = expandArgTemplate(templateArgs, placeholders)
+	}
+	// outputs that are the same for every tile collide too
+	argDescriptions := tool.GetArgDescriptions()
+	outputs := make([]bool, len(templateArgs))
+	for j := range outputs {
+		if j < len(argDescriptions) {
+			role := argDescriptions[j].Role
+			outputs[j] = role == ArgOutput || role == ArgOutputRaster
+		}
+	}
+	if a, b, arg, ok := findArgCollision(tileArgs, outputs); ok {
+		printf("The arguments of tiles %v and %v are both '%s', so one would overwrite the other; add {dir} or {index} to the argument template.\n",
+			a+1, b+1, arg)
+		return
+	}
+
+	numFailed, numTrimmed := 0, 0
+	for i, t := range tiles {
+		tileFile := tileFiles[i]
+		args := tileArgs[i]
+		checkInterrupt(fmt.Sprintf("before tile %v of %v, after %v tiles were run", i+1, len(tiles), i-numFailed))
+		printf("\nTile %v of %v: %s\n", i+1, len(tiles), filepath.Base(t.fileName))
+		if !raster.FileExists(t.fileName) {
+			printf("Warning: %s does not exist and was skipped.\n", t.fileName)
+			numFailed++
+			continue
+		}
+		if this.buffer > 0 {
+			if err := writeBufferedTile(tiles, i, this.buffer, tileFile); err != nil {
+				printf("Warning: the buffered copy of %s could not be written (%s).\n", t.fileName, err.Error())
+				numFailed++
+				continue
+			}
+		}
+		if err := this.toolManager.RunWithArguments(this.toolName, args); err != nil {
+			println(err.Error())
+			numFailed++
+			continue
+		}
+		if this.buffer > 0 {
+			numTrimmed += this.trimOutputs(args, tileFile, t)
+		}
+	}
+
+	println("")
+	printf("Number of tiles run: %v\n", len(tiles)-numFailed)
+	if numFailed > 0 {
+		printf("Warning: %v tiles were skipped or failed.\n", numFailed)
+	}
+	if this.buffer > 0 {
+		printf("Number of buffered outputs trimmed: %v\n", numTrimmed)
+	}
+	println("Operation complete!")
+
+	overallTime := time.Since(start1)
+	value := fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Trims the output rasters of a run on a buffered tile, i.e. the raster
+// arguments, other than the buffered tile itself, that lie on the buffered
+// grid, back to the extent of tile t. It returns the number of rasters
+// trimmed.
+func (this *BatchTiles) trimOutputs(args []string, tileFile string, t tileRecord) int {
+	bt := bufferedTile(t, this.buffer)
+	numTrimmed := 0
+	for _, arg := range args {
+		fileName := arg
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		if fileName == tileFile {
+			continue
+		}
+		if rt, err := raster.DetermineRasterFormat(fileName); err != nil || rt == raster.RT_UnknownRaster {
+			// tools add the default output extension
+			fileName += raster.DefaultExtension
+		}
+		if _, err := os.Stat(fileName); err != nil {
+			continue
+		}
+		trimmed, err := trimBufferedTile(fileName, t, bt)
+		if err != nil {
+			printf("Warning: %s could not be trimmed (%s).\n", fileName, err.Error())
+		} else if trimmed {
+			numTrimmed++
+		}
+	}
+	return numTrimmed
+}
+
+// expandArgTemplate replaces the placeholders, e.g. {name}, in the arguments
+// of a template with their values.
+func expandArgTemplate(templateArgs []string, placeholders map[string]string) []string {
+	pairs := make([]string, 0, 2*len(placeholders))
+	for key, value := range placeholders {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	args := make([]string, len(templateArgs))
+	for j, arg := range templateArgs {
+		args[j] = replacer.Replace(arg)
+	}
+	return args
+}
+
+// findArgCollision finds an argument that takes the same value in two runs
+// where it would overwrite one run's output with another's, and returns the
+// runs and the value. outputs reports which arguments are outputs of the
+// tool, which collide whenever two runs share them, e.g. when they are named
+// after tiles that share a name or the same in every run. Other arguments
+// collide only if they differ between runs, since those that are the same in
+// every run, e.g. a shared input, are meant to be.
+func findArgCollision(runArgs [][]string, outputs []bool) (a, b int, arg string, ok bool) {
+	if len(runArgs) < 2 {
+		return 0, 0, "", false
+	}
+	for j := range runArgs[0] {
+		constant := true
+		for i := range runArgs {
+			if runArgs[i][j] != runArgs[0][j] {
+				constant = false
+				break
+			}
+		}
+		if constant && (j >= len(outputs) || !outputs[j]) {
+			continue
+		}
+		first := make(map[string]int, len(runArgs))
+		for i := range runArgs {
+			if k, found := first[runArgs[i][j]]; found {
+				return k, i, runArgs[i][j], true
+			}
+			first[runArgs[i][j]] = i
+		}
+	}
+	return 0, 0, "", false
+}
@@ -24,6 +24,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 	println("Benchmarking BreachDepressions...")
 
 	var progress, oldProgress, col, row, i, n int
+	var eta progressETA
 	var colN, rowN, r, c, flatindex int
 	var dir byte
 	needsFilling := false
@@ -159,7 +160,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 			}
 			progress = int(100.0 * row / rowsLessOne)
 			if progress != oldProgress {
-				printf("\rBreaching DEM (1 of 2): %v%%", progress)
+				printf("\rBreaching DEM (1 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -235,7 +236,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 				}
 				progress = int(100.0 * numSolvedCells / numCellsTotal)
 				if progress != oldProgress {
-					printf("\rBreaching DEM (2 of 2): %v%%", progress)
+					printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 					oldProgress = progress
 				}
 			}
@@ -341,7 +342,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 				}
 				progress = int(100.0 * numSolvedCells / numCellsTotal)
 				if progress != oldProgress {
-					printf("\rBreaching DEM (2 of 2): %v%%", progress)
+					printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 					oldProgress = progress
 				}
 			}
@@ -506,7 +507,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 				}
 				progress = int(100.0 * numSolvedCells / numCellsTotal)
 				if progress != oldProgress {
-					printf("\rBreaching DEM (2 of 2): %v%%", progress)
+					printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 					oldProgress = progress
 				}
 			}
@@ -542,7 +543,7 @@ func benchmarkBreachDepressions(parent *BreachDepressions) {
 				numSolvedCells++
 				progress = int(100.0 * numSolvedCells / numValidCells)
 				if progress != oldProgress {
-					printf("\rFilling DEM: %v%%", progress)
+					printf("\rFilling DEM: %v%%%s", progress, eta.remaining(progress))
 					oldProgress = progress
 				}
 			}
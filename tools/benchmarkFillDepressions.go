@@ -25,6 +25,7 @@ func benchmarkFillDepressions(parent *FillDepressions) {
 	println("Benchmarking FillDepressions...")
 
 	var progress, oldProgress, col, row, i, n int
+	var eta progressETA
 	var colN, rowN, flatindex int
 	numSolvedCells := 0
 	var z, zN float64
@@ -129,7 +130,7 @@ func benchmarkFillDepressions(parent *FillDepressions) {
 			}
 			progress = int(100.0 * row / rowsLessOne)
 			if progress != oldProgress {
-				printf("\rFilling DEM (1 of 2): %v%%", progress)
+				printf("\rFilling DEM (1 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -162,7 +163,7 @@ func benchmarkFillDepressions(parent *FillDepressions) {
 			}
 			progress = int(100.0 * numSolvedCells / numCellsTotal)
 			if progress != oldProgress {
-				printf("\rFilling DEM (2 of 2): %v%%", progress)
+				printf("\rFilling DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
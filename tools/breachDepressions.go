@@ -17,7 +17,6 @@ import (
 	"time"
 
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
-	"github.com/jblindsay/go-spatial/structures"
 )
 
 type BreachDepressions struct {
@@ -27,6 +26,9 @@ type BreachDepressions struct {
 	maxDepth             float64
 	constrainedBreaching bool
 	postBreachFilling    bool
+	barrierFile          string
+	culvertFile          string
+	modificationFile     string
 	toolManager          *PluginToolManager
 }
 
@@ -40,8 +42,24 @@ func (this *BreachDepressions) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *BreachDepressions) GetVersion() string {
+	return "1.0"
+}
+
 func (this *BreachDepressions) GetHelpDocumentation() string {
-	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using a highly efficient and flexible breaching, or carving, method."
+	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using a highly efficient and flexible breaching, or carving, method. " +
+		"Breach channels can be prevented from cutting through barriers such as road and rail " +
+		"embankments by specifying a barrier raster, in which non-zero cells are barriers. " +
+		"Breach channels may only cross barriers at the non-zero cells of the optional culvert " +
+		"raster. Depressions that cannot be breached without crossing a barrier are filled; " +
+		"post-breach filling is therefore always performed when a barrier raster is used. Both " +
+		"rasters should be on the grid of the DEM; rasters with the same cell size but a " +
+		"different extent are aligned onto it (see AlignRasters). If a ModificationFile is specified, " +
+		"the change in elevation of each cell (negative where it was lowered and positive where it " +
+		"was raised) is written to it, and the number of cells lowered by breaching and raised by " +
+		"post-breach filling, the volumes excavated and added, and the greatest depths of each are " +
+		"printed and are also written to the -report file, if there is one, so that the effects of " +
+		"different parameter settings can be compared. Filling is measured from the breached surface."
 	return ret
 }
 
@@ -50,39 +68,68 @@ func (this *BreachDepressions) SetToolManager(tm *PluginToolManager) {
 }
 
 // Can be called to gather a listing of the arguments required to run this tool.
-func (this *BreachDepressions) GetArgDescriptions() [][]string {
-	numArgs := 6
-	ret := structures.Create2dStringArray(numArgs, 3)
-
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name with file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename with file extension"
-
-	ret[2][0] = "MaxDepth"
-	ret[2][1] = "float64"
-	ret[2][2] = "The maximum breach channel depth (-1 to ignore)"
-
-	ret[3][0] = "MaxLength"
-	ret[3][1] = "int"
-	ret[3][2] = "The maximum length of a breach channel (-1 to ignore)"
-
-	ret[4][0] = "ConstrainedBreaching"
-	ret[4][1] = "bool"
-	ret[4][2] = "Use constrained breaching?"
-
-	ret[5][0] = "SubsequentFilling"
-	ret[5][1] = "bool"
-	ret[5][2] = "Perform post-breach filling?"
+func (this *BreachDepressions) GetArgDescriptions() []ToolArg {
+	numArgs := 9
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name with file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename with file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = taudemOutputRequired(this.toolManager)
+
+	ret[2].Name = "MaxDepth"
+	ret[2].Type = "float64"
+	ret[2].Description = "The maximum breach channel depth (-1 to ignore)"
+	ret[2].Default = "-1"
+
+	ret[3].Name = "MaxLength"
+	ret[3].Type = "int"
+	ret[3].Description = "The maximum length of a breach channel (-1 to ignore)"
+	ret[3].Default = "-1"
+
+	ret[4].Name = "ConstrainedBreaching"
+	ret[4].Type = "bool"
+	ret[4].Description = "Use constrained breaching?"
+	ret[4].Default = "false"
+
+	ret[5].Name = "SubsequentFilling"
+	ret[5].Type = "bool"
+	ret[5].Description = "Perform post-breach filling?"
+	ret[5].Default = "false"
+
+	ret[6].Name = "BarrierFile"
+	ret[6].Type = "string"
+	ret[6].Description = "Raster of barriers that breaching may not cross"
+	ret[6].Role = ArgInput
+
+	ret[7].Name = "CulvertFile"
+	ret[7].Type = "string"
+	ret[7].Description = "Raster of culverts where barriers may be crossed"
+	ret[7].Role = ArgInput
+
+	ret[8].Name = "ModificationFile"
+	ret[8].Type = "string"
+	ret[8].Description = "Output raster of the change in elevation of each cell"
+	ret[8].Role = ArgOutputRaster
 
 	return ret
 }
 
 // ParseArguments is used when the tool is run using command-line args
 // rather than in interactive input/output mode.
+func (this *BreachDepressions) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the padded output elevation, pit, queue and
+	// flow direction grids, and the flood order
+	return gridBytes(rows, columns, 3*rasterBytesPerCell) + gridBytes(rows+2, columns+2, 8+1+1+1)
+}
+
 func (this *BreachDepressions) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -91,18 +138,21 @@ func (this *BreachDepressions) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
 	outputFile := args[1]
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "fel")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -144,6 +194,40 @@ func (this *BreachDepressions) ParseArguments(args []string) {
 		this.constrainedBreaching = false
 	}
 
+	this.postBreachFilling = false
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		var err error
+		if this.postBreachFilling, err = strconv.ParseBool(strings.TrimSpace(args[5])); err != nil {
+			this.postBreachFilling = false
+			println(err)
+		}
+	}
+
+	this.barrierFile = ""
+	this.culvertFile = ""
+	for i := 6; i < 8; i++ {
+		if len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified" {
+			fileName := strings.TrimSpace(args[i])
+			if !strings.Contains(fileName, pathSep) {
+				fileName = this.toolManager.workingDirectory + fileName
+			}
+			if _, err := os.Stat(fileName); os.IsNotExist(err) {
+				printf("no such file or directory: %s\n", fileName)
+				return
+			}
+			if i == 6 {
+				this.barrierFile = fileName
+			} else {
+				this.culvertFile = fileName
+			}
+		}
+	}
+
+	this.modificationFile = ""
+	if len(args) > 8 && len(strings.TrimSpace(args[8])) > 0 && args[8] != "not specified" {
+		this.setModificationFile(args[8])
+	}
+
 	this.Run()
 }
 
@@ -162,7 +246,7 @@ func (this *BreachDepressions) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -174,12 +258,15 @@ func (this *BreachDepressions) CollectArguments() {
 		println(err)
 	}
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "fel")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -253,19 +340,65 @@ func (this *BreachDepressions) CollectArguments() {
 		}
 	}
 
+	// get the barrier and culvert file names
+	this.barrierFile = ""
+	this.culvertFile = ""
+	prompts := []string{"Enter the barrier raster file name (blank for none): ",
+		"Enter the culvert raster file name (blank for none): "}
+	for i, prompt := range prompts {
+		if i == 1 && this.barrierFile == "" {
+			break
+		}
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if len(fileName) == 0 {
+			continue
+		}
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.barrierFile = fileName
+		} else {
+			this.culvertFile = fileName
+		}
+	}
+
+	// get the modification file name
+	this.modificationFile = ""
+	print("Enter the elevation change output file name (blank for none): ")
+	modificationFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(modificationFile)) > 0 {
+		this.setModificationFile(modificationFile)
+	}
+
 	this.Run()
 }
 
-func (this *BreachDepressions) Run() {
-	//	cfg := profile.Config{
-	//		CPUProfile:     true,
-	//		NoShutdownHook: true, // do not hook SIGINT
-	//		ProfilePath:    "/Users/johnlindsay/Documents/",
-	//	}
-	//	//profile.Config.ProfilePath("/Users/johnlindsay/Documents/")
-	//	prf := profile.Start(&cfg)
-	//	defer prf.Stop()
+func (this *BreachDepressions) setModificationFile(s string) {
+	modificationFile := strings.TrimSpace(s)
+	if !strings.Contains(modificationFile, pathSep) {
+		modificationFile = this.toolManager.workingDirectory + modificationFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(modificationFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		modificationFile = modificationFile + raster.DefaultExtension // the default output format
+	}
+	this.modificationFile = modificationFile
+}
 
+func (this *BreachDepressions) Run() {
 	//this.postBreachFilling = false
 
 	if this.toolManager.BenchMode {
@@ -276,6 +409,7 @@ func (this *BreachDepressions) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row, i, n int
+	var eta progressETA
 	var colN, rowN, r, c, flatindex int
 	numSolvedCells := 0
 	var dir byte
@@ -286,7 +420,7 @@ func (this *BreachDepressions) Run() {
 	var p int64
 	var breachDepth, maxPathBreachDepth float64
 	var numCellsInPath int32
-	var isPit, isEdgeCell bool
+	var isPit, isEdgeCell, isBlocked bool
 	numPits := 0
 	numPitsSolved := 0
 	numUnsolvedPits := 0
@@ -320,6 +454,39 @@ func (this *BreachDepressions) Run() {
 	demConfig := dem.GetRasterConfig()
 	rows := dem.Rows
 	columns := dem.Columns
+
+	// barriers that breach channels may only cross at culverts
+	var barrier [][]bool
+	if this.barrierFile != "" {
+		if barrier, err = readConstraintGrid(this.barrierFile, dem); err != nil {
+			println(err.Error())
+			return
+		}
+		if this.culvertFile != "" {
+			culvert, err := readConstraintGrid(this.culvertFile, dem)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			for row = 0; row < rows+2; row++ {
+				for col = 0; col < columns+2; col++ {
+					if culvert[row][col] {
+						barrier[row][col] = false
+					}
+				}
+			}
+		}
+		// depressions that can't be breached must be filled
+		if !maxLengthOrDepthUsed {
+			maxLengthOrDepthUsed = true
+			this.maxDepth = math.MaxFloat64
+			this.maxLength = math.MaxInt32
+		}
+		this.postBreachFilling = true
+	}
+	isBarrier := func(r, c int) bool {
+		return barrier != nil && barrier[r][c]
+	}
 	rowsLessOne := rows - 1
 	numCellsTotal := rows * columns
 	nodata := dem.NoDataValue
@@ -329,6 +496,9 @@ func (this *BreachDepressions) Run() {
 	elevMultiplier := math.Pow(10, float64(5-elevDigits))
 	SMALL_NUM := 1 / elevMultiplier * 10
 	POS_INF := math.Inf(1)
+	// barrier cells are visited after all others so that the flood reaches the
+	// areas behind barriers through culverts wherever possible
+	barrierPenalty := int64(math.Ceil((dem.GetMaximumValue()-minVal)+1) * elevMultiplier)
 
 	start2 := time.Now()
 
@@ -421,7 +591,7 @@ func (this *BreachDepressions) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rBreaching DEM (1 of 2): %v%%", progress)
+			printf("\rBreaching DEM (1 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -493,13 +663,16 @@ func (this *BreachDepressions) Run() {
 					}
 					gc = newGridCell(rowN, colN, n)
 					p = int64(int64(zN*elevMultiplier)*100000 + (int64(n) % 100000))
+					if isBarrier(rowN, colN) {
+						p += barrierPenalty * 100000
+					}
 					pq.Push(gc, p)
 					inQueue[rowN][colN] = true
 				}
 			}
 			progress = int(100.0 * numSolvedCells / numCellsTotal)
 			if progress != oldProgress {
-				printf("\rBreaching DEM (2 of 2): %v%%", progress)
+				printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -531,6 +704,7 @@ func (this *BreachDepressions) Run() {
 						// or a constraint is encountered
 						numCellsInPath = 0
 						maxPathBreachDepth = 0
+						isBlocked = false
 
 						zTest = zN
 						r = rowN
@@ -546,6 +720,9 @@ func (this *BreachDepressions) Run() {
 								if zN2 <= zTest || zN2 == nodata {
 									// a lower grid cell has been found
 									isActive = false
+								} else if isBarrier(r, c) {
+									isBlocked = true
+									isActive = false
 								} else {
 									breachDepth = dem.Value(r-1, c-1) - zTest
 									if breachDepth > maxPathBreachDepth {
@@ -564,7 +741,7 @@ func (this *BreachDepressions) Run() {
 							}
 						}
 
-						if numCellsInPath <= this.maxLength && maxPathBreachDepth <= this.maxDepth {
+						if !isBlocked && numCellsInPath <= this.maxLength && maxPathBreachDepth <= this.maxDepth {
 							// breach it completely
 							zTest = zN
 							r = rowN
@@ -600,13 +777,16 @@ func (this *BreachDepressions) Run() {
 					}
 					gc = newGridCell(rowN, colN, n)
 					p = int64(int64(zN*elevMultiplier)*100000 + (int64(n) % 100000))
+					if isBarrier(rowN, colN) {
+						p += barrierPenalty * 100000
+					}
 					pq.Push(gc, p)
 					inQueue[rowN][colN] = true
 				}
 			}
 			progress = int(100.0 * numSolvedCells / numCellsTotal)
 			if progress != oldProgress {
-				printf("\rBreaching DEM (2 of 2): %v%%", progress)
+				printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -647,6 +827,7 @@ func (this *BreachDepressions) Run() {
 						c = colN
 						outletHeight = -math.MaxFloat64
 						outletDist = 0
+						isBlocked = false
 						isActive = true
 						for isActive {
 							zTest -= SMALL_NUM // ensures a small increment slope
@@ -658,6 +839,9 @@ func (this *BreachDepressions) Run() {
 								if zN2 <= zTest || zN2 == nodata {
 									// a lower grid cell has been found
 									isActive = false
+								} else if isBarrier(r, c) {
+									isBlocked = true
+									isActive = false
 								} else {
 									zOrig = dem.Value(r-1, c-1)
 									breachDepth = zOrig - zTest
@@ -675,7 +859,7 @@ func (this *BreachDepressions) Run() {
 							numCellsInPath++
 						}
 
-						if numCellsInPath <= this.maxLength && maxPathBreachDepth <= this.maxDepth {
+						if !isBlocked && numCellsInPath <= this.maxLength && maxPathBreachDepth <= this.maxDepth {
 							// breach it completely
 							zTest = zN
 							r = rowN
@@ -740,8 +924,8 @@ func (this *BreachDepressions) Run() {
 									r += dY[dir-1]
 									c += dX[dir-1]
 									zN2 = output[r][c]
-									if zN2 <= zN || zN2 == nodata {
-										// a lower grid cell has been found
+									if zN2 <= zN || zN2 == nodata || isBarrier(r, c) {
+										// a lower grid cell or a barrier has been found
 										isActive = false
 									} else {
 										if output[r][c] > zTest {
@@ -765,13 +949,16 @@ func (this *BreachDepressions) Run() {
 					}
 					gc = newGridCell(rowN, colN, n)
 					p = int64(int64(zN*elevMultiplier)*100000 + (int64(n) % 100000))
+					if isBarrier(rowN, colN) {
+						p += barrierPenalty * 100000
+					}
 					pq.Push(gc, p)
 					inQueue[rowN][colN] = true
 				}
 			}
 			progress = int(100.0 * numSolvedCells / numCellsTotal)
 			if progress != oldProgress {
-				printf("\rBreaching DEM (2 of 2): %v%%", progress)
+				printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -780,6 +967,11 @@ func (this *BreachDepressions) Run() {
 	pits = nil
 	inQueue = nil
 
+	// the breached elevations of the cells raised by filling, so that the
+	// modifications made by breaching and filling can be told apart
+	measureModifications := this.modificationFile != "" || this.toolManager.ReportFile != ""
+	breachedElevations := make(map[int]float64)
+
 	if needsFilling && this.postBreachFilling {
 		// Fill the DEM.
 		printf("\r                                                                ")
@@ -799,6 +991,9 @@ func (this *BreachDepressions) Run() {
 					zN = output[rowN][colN]
 					if zN != nodata {
 						if z <= zN+SMALL_NUM {
+							if measureModifications {
+								breachedElevations[row*(columns+2)+col] = z
+							}
 							output[row][col] = zN + SMALL_NUM
 						}
 					}
@@ -807,7 +1002,7 @@ func (this *BreachDepressions) Run() {
 			numSolvedCells++
 			progress = int(100.0 * numSolvedCells / numValidCells)
 			if progress != oldProgress {
-				printf("\rFilling DEM: %v%%", progress)
+				printf("\rFilling DEM: %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -818,8 +1013,11 @@ func (this *BreachDepressions) Run() {
 	config.PreferredPalette = paletteName
 	config.DataType = raster.DT_FLOAT32
 	config.NoDataValue = nodata
-	displayMin := demConfig.DisplayMinimum
-	displayMax := demConfig.DisplayMaximum
+	outNodata := nodata
+	if this.toolManager.TauDEMMode {
+		outNodata = taudemElevationNodata
+		config.NoDataValue = outNodata
+	}
 	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
 	config.EPSGCode = demConfig.EPSGCode
 	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -832,6 +1030,9 @@ func (this *BreachDepressions) Run() {
 	for row = 0; row < rows; row++ {
 		for col = 0; col < columns; col++ {
 			z = output[row+1][col+1]
+			if z == nodata {
+				z = outNodata
+			}
 			rout.SetValue(row, col, z)
 		}
 	}
@@ -839,15 +1040,27 @@ func (this *BreachDepressions) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by BreachDepressions tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by BreachDepressions tool (%s)", this.toolManager.toolVersion(this)))
 	rout.AddMetadataEntry(fmt.Sprintf("Max breach depth: %v", this.maxDepth))
 	rout.AddMetadataEntry(fmt.Sprintf("Max breach length: %v", this.maxLength))
 	rout.AddMetadataEntry(fmt.Sprintf("Constrained Breaching: %v", this.constrainedBreaching))
-	config.DisplayMinimum = displayMin
-	config.DisplayMaximum = displayMax
+	if this.barrierFile != "" {
+		rout.AddMetadataEntry(fmt.Sprintf("Barriers: %s", this.barrierFile))
+	}
+	if this.culvertFile != "" {
+		rout.AddMetadataEntry(fmt.Sprintf("Culverts: %s", this.culvertFile))
+	}
+	config.CopyDisplaySettings(demConfig)
 	rout.SetRasterConfig(config)
 	rout.Save()
 
+	if measureModifications {
+		if err = this.writeModifications(dem, output, breachedElevations); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
 	println("Operation complete!")
 
 	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
@@ -864,6 +1077,123 @@ func (this *BreachDepressions) Run() {
 	}
 }
 
+// writeModifications compares the breached and filled DEM, held in the padded
+// output grid, with the input DEM. It writes the change in elevation of each
+// cell to the modification file, if there is one, and prints and reports the
+// numbers of cells lowered by breaching and raised by filling, the volumes
+// excavated and added, and their mean and greatest depths. breached holds the
+// elevations of the cells raised by filling before they were filled.
+func (this *BreachDepressions) writeModifications(dem *raster.Raster, output [][]float64, breached map[int]float64) error {
+	rows, columns := dem.Rows, dem.Columns
+	nodata := dem.NoDataValue
+	cellArea := dem.GetCellSizeX() * dem.GetCellSizeY()
+
+	var rout *raster.Raster
+	if this.modificationFile != "" {
+		config := raster.NewDefaultRasterConfig()
+		config.PreferredPalette = "blue_white_red.pal"
+		config.DataType = raster.DT_FLOAT32
+		config.NoDataValue = nodata
+		config.InitialValue = nodata
+		demConfig := dem.GetRasterConfig()
+		config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+		config.EPSGCode = demConfig.EPSGCode
+		var err error
+		rout, err = raster.CreateNewRaster(this.modificationFile, rows, columns,
+			dem.North, dem.South, dem.East, dem.West, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	type modification struct {
+		cells    int
+		volume   float64
+		maxDepth float64
+	}
+	var breaching, filling modification
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z0 := dem.Value(row, col)
+			z := output[row+1][col+1]
+			if z0 == nodata || z == nodata {
+				continue
+			}
+			zBreached := z
+			if zb, ok := breached[(row+1)*(columns+2)+col+1]; ok {
+				zBreached = zb
+			}
+			if depth := z0 - zBreached; depth > 0 {
+				breaching.cells++
+				breaching.volume += depth * cellArea
+				breaching.maxDepth = math.Max(breaching.maxDepth, depth)
+			}
+			if depth := z - zBreached; depth > 0 {
+				filling.cells++
+				filling.volume += depth * cellArea
+				filling.maxDepth = math.Max(filling.maxDepth, depth)
+			}
+			if rout != nil {
+				rout.SetValue(row, col, z-z0)
+			}
+		}
+	}
+
+	if rout != nil {
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by BreachDepressions tool (%s)", this.toolManager.toolVersion(this)))
+		rout.AddMetadataEntry(fmt.Sprintf("Elevation change of: %s", this.inputFile))
+		if err := rout.Save(); err != nil {
+			return err
+		}
+	}
+
+	report, err := this.toolManager.openReport("modification", "cells", "volume", "mean_depth", "max_depth")
+	if err != nil {
+		return err
+	}
+	for _, m := range []struct {
+		name, label string
+		modification
+	}{{"breaching", "Lowered by breaching", breaching}, {"filling", "Raised by filling", filling}} {
+		meanDepth := 0.0
+		if m.cells > 0 {
+			meanDepth = m.volume / cellArea / float64(m.cells)
+		}
+		printf("%s: %v cells, volume %s, mean depth %s, max. depth %s\n", m.label, m.cells,
+			formatFloat(m.volume, 64), formatFloat(meanDepth, 64), formatFloat(m.maxDepth, 64))
+		report.writeRow(m.name, m.cells, m.volume, meanDepth, m.maxDepth)
+	}
+	return report.close()
+}
+
+// Reads a raster of breaching constraints into a grid, padded by one cell
+// on each side to match the grids used by BreachDepressions. Cells that are
+// neither zero nor nodata are set to true. A raster that is not on the grid
+// of the DEM is aligned onto it, if possible.
+func readConstraintGrid(fileName string, dem *raster.Raster) ([][]bool, error) {
+	r, err := raster.CreateRasterFromFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if r, err = alignRaster(dem, r, defaultAlignTolerance, "nearest"); err != nil {
+		return nil, fmt.Errorf("The constraint raster %s could not be aligned with the DEM: %s", fileName, err.Error())
+	}
+	rows, columns := dem.Rows, dem.Columns
+	grid := make([][]bool, rows+2)
+	for i := range grid {
+		grid[i] = make([]bool, columns+2)
+	}
+	var z float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z = r.Value(row, col)
+			grid[row+1][col+1] = z != r.NoDataValue && z != 0
+		}
+	}
+	return grid, nil
+}
+
 type gridCell struct {
 	row       int
 	column    int
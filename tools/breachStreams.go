@@ -17,7 +17,6 @@ import (
 	"time"
 
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
-	"github.com/jblindsay/go-spatial/structures"
 )
 
 type BreachStreams struct {
@@ -37,8 +36,13 @@ func (this *BreachStreams) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *BreachStreams) GetVersion() string {
+	return "1.0"
+}
+
 func (this *BreachStreams) GetHelpDocumentation() string {
-	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using a highly efficient and flexible breaching, or carving, method."
+	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using a highly efficient and flexible breaching, or carving, method. " +
+		"If the streams raster is not on the grid of the DEM but has the same cell size, it is aligned onto the DEM's grid with a warning."
 	return ret
 }
 
@@ -47,27 +51,39 @@ func (this *BreachStreams) SetToolManager(tm *PluginToolManager) {
 }
 
 // Can be called to gather a listing of the arguments required to run this tool.
-func (this *BreachStreams) GetArgDescriptions() [][]string {
+func (this *BreachStreams) GetArgDescriptions() []ToolArg {
 	numArgs := 3
-	ret := structures.Create2dStringArray(numArgs, 3)
-
-	ret[0][0] = "InputStream"
-	ret[0][1] = "string"
-	ret[0][2] = "The input stream raster file name with file extension"
-
-	ret[1][0] = "InputDEM"
-	ret[1][1] = "string"
-	ret[1][2] = "The input DEM name with file extension"
 
-	ret[2][0] = "OutputFile"
-	ret[2][1] = "string"
-	ret[2][2] = "The output filename with file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputStream"
+	ret[0].Type = "string"
+	ret[0].Description = "The input stream raster file name with file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "InputDEM"
+	ret[1].Type = "string"
+	ret[1].Description = "The input DEM name with file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename with file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
 
 	return ret
 }
 
 // ParseArguments is used when the tool is run using command-line args
 // rather than in interactive input/output mode.
+func (this *BreachStreams) EstimateMemory(rows, columns int) int64 {
+	// the DEM, stream and output rasters, and the padded output elevation,
+	// pit, queue and flow direction grids
+	return gridBytes(rows, columns, 3*rasterBytesPerCell) + gridBytes(rows+2, columns+2, 8+1+1+1)
+}
+
 func (this *BreachStreams) ParseArguments(args []string) {
 	streamFile := args[0]
 	streamFile = strings.TrimSpace(streamFile)
@@ -100,7 +116,7 @@ func (this *BreachStreams) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -156,7 +172,7 @@ func (this *BreachStreams) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -167,6 +183,7 @@ func (this *BreachStreams) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row, i, n int
+	var eta progressETA
 	var colN, rowN, r, c, flatindex int
 	numSolvedCells := 0
 	var dir byte
@@ -206,8 +223,9 @@ func (this *BreachStreams) Run() {
 	if err != nil {
 		println(err.Error())
 	}
-	if streams.Rows != rows || streams.Columns != columns {
-		println("The input rasters must be of the same dimensions.")
+	if streams, err = alignRaster(dem, streams, defaultAlignTolerance, "nearest"); err != nil {
+		println(err.Error())
+		println("The streams raster could not be aligned with the DEM; see the AlignRasters tool.")
 		return
 	}
 	streamsNodata := streams.NoDataValue
@@ -340,7 +358,7 @@ func (this *BreachStreams) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rBreaching DEM (1 of 2): %v%%", progress)
+			printf("\rBreaching DEM (1 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -448,7 +466,7 @@ func (this *BreachStreams) Run() {
 		}
 		progress = int(100.0 * numSolvedCells / numCellsTotal)
 		if progress != oldProgress {
-			printf("\rBreaching DEM (2 of 2): %v%%", progress)
+			printf("\rBreaching DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -458,8 +476,6 @@ func (this *BreachStreams) Run() {
 	config.PreferredPalette = paletteName
 	config.DataType = raster.DT_FLOAT32
 	config.NoDataValue = nodata
-	displayMin := demConfig.DisplayMinimum
-	displayMax := demConfig.DisplayMaximum
 	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
 	config.EPSGCode = demConfig.EPSGCode
 	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -485,9 +501,8 @@ func (this *BreachStreams) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by BreachStreams tool"))
-	config.DisplayMinimum = displayMin
-	config.DisplayMaximum = displayMax
+	rout.AddMetadataEntry(fmt.Sprintf("Created by BreachStreams tool (%s)", this.toolManager.toolVersion(this)))
+	config.CopyDisplaySettings(demConfig)
 	rout.SetRasterConfig(config)
 	rout.Save()
 
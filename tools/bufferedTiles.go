@@ -0,0 +1,120 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// bufferedTile returns the record of a tile expanded by buffer cells on each
+// side.
+func bufferedTile(t tileRecord, buffer int) tileRecord {
+	b := float64(buffer)
+	t.rows += 2 * buffer
+	t.columns += 2 * buffer
+	e := t.bounds().Expand(b*t.cellSizeX, b*t.cellSizeY)
+	t.north, t.south, t.east, t.west = e.North, e.South, e.East, e.West
+	return t
+}
+
+// writeBufferedTile writes tile index of tiles, expanded by buffer cells on
+// each side, to fileName. The buffer is filled from the neighbouring tiles
+// of the index that share the tile's cell size, and is nodata beyond the
+// edges of the tiled area, so that tools run on each tile of a batch see
+// the same neighbourhood at the edges of a tile as they would in a mosaic.
+func writeBufferedTile(tiles []tileRecord, index, buffer int, fileName string) error {
+	t := tiles[index]
+	bt := bufferedTile(t, buffer)
+	rin, err := raster.CreateRasterFromFile(t.fileName)
+	if err != nil {
+		return err
+	}
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(fileName, bt.rows, bt.columns,
+		bt.north, bt.south, bt.east, bt.west, config)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range tiles {
+		if !n.bounds().Overlaps(bt.bounds()) {
+			continue
+		}
+		if math.Abs(n.cellSizeX-t.cellSizeX) > defaultAlignTolerance*t.cellSizeX ||
+			math.Abs(n.cellSizeY-t.cellSizeY) > defaultAlignTolerance*t.cellSizeY {
+			continue
+		}
+		r := rin
+		if i != index {
+			if r, err = raster.CreateRasterFromFile(n.fileName); err != nil {
+				return err
+			}
+		}
+		for row := 0; row < bt.rows; row++ {
+			y := bt.north - (float64(row)+0.5)*t.cellSizeY
+			if y >= n.north || y <= n.south {
+				continue
+			}
+			inRow := int(math.Floor((n.north - y) / n.cellSizeY))
+			for col := 0; col < bt.columns; col++ {
+				x := bt.west + (float64(col)+0.5)*t.cellSizeX
+				if x <= n.west || x >= n.east {
+					continue
+				}
+				inCol := int(math.Floor((x - n.west) / n.cellSizeX))
+				if z := r.Value(inRow, inCol); z != r.NoDataValue {
+					rout.SetValue(row, col, z)
+				}
+			}
+		}
+	}
+	rout.AddMetadataEntry(fmt.Sprintf("Tile %s buffered by %v cells", t.fileName, buffer))
+	return rout.Save()
+}
+
+// trimBufferedTile trims a raster on the grid of the buffered tile bt back
+// to the extent of tile t, overwriting the file. It returns false, and
+// leaves the file unchanged, if the raster is not on the buffered grid.
+func trimBufferedTile(fileName string, t, bt tileRecord) (bool, error) {
+	r, err := raster.CreateRasterFromFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	if r.Rows != bt.rows || r.Columns != bt.columns ||
+		math.Abs(r.North-bt.north) > 0.5*bt.cellSizeY || math.Abs(r.West-bt.west) > 0.5*bt.cellSizeX {
+		return false, nil
+	}
+	buffer := (bt.rows - t.rows) / 2
+	values, err := r.Data()
+	if err != nil {
+		return false, err
+	}
+	config := *r.GetRasterConfig()
+	config.InitialValue = r.NoDataValue
+	rout, err := raster.CreateNewRaster(fileName, t.rows, t.columns,
+		t.north, t.south, t.east, t.west, &config)
+	if err != nil {
+		return false, err
+	}
+	for row := 0; row < t.rows; row++ {
+		for col := 0; col < t.columns; col++ {
+			rout.SetValue(row, col, values[(row+buffer)*bt.columns+col+buffer])
+		}
+	}
+	return true, rout.Save()
+}
@@ -0,0 +1,397 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// BurnStreams lowers the elevations of DEM cells along a stream network by a
+// fixed decrement, optionally with banks that slope down to the streams.
+type BurnStreams struct {
+	inputFile   string
+	streamsFile string
+	outputFile  string
+	decrement   float64
+	bufferWidth float64
+	toolManager *PluginToolManager
+}
+
+func (this *BurnStreams) GetName() string {
+	s := "BurnStreams"
+	return getFormattedToolName(s)
+}
+
+func (this *BurnStreams) GetDescription() string {
+	s := "Lowers DEM cells along streams by a fixed decrement"
+	return getFormattedToolDescription(s)
+}
+
+func (this *BurnStreams) GetVersion() string {
+	return "1.0"
+}
+
+func (this *BurnStreams) GetHelpDocumentation() string {
+	ret := "This tool performs classic stream burning, lowering the elevations of the DEM " +
+		"cells along a mapped stream network by a specified decrement, so that flow " +
+		"routed over the DEM follows the mapped streams. Unlike BreachStreams, which " +
+		"carves the streams to a minimal downstream gradient, the decrement is the same " +
+		"everywhere. Streams are the non-zero cells of a raster on the grid of the DEM, or " +
+		"aligned onto it (see AlignRasters), or the lines of a polyline shapefile (.shp), " +
+		"which are burned into each cell that they cross. If the BufferWidth is greater " +
+		"than zero, the banks within that distance of the streams, in the horizontal " +
+		"units of the DEM or metres for DEMs in geographic coordinates, are also lowered, " +
+		"by an amount that falls linearly from the decrement at the streams to zero at " +
+		"the edge of the buffer, so that the burned channels have smoothed banks, as in " +
+		"the AGREE method, rather than vertical walls. Nodata cells are left unchanged."
+	return ret
+}
+
+func (this *BurnStreams) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *BurnStreams) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "StreamsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "Raster of streams (non-zero cells) or polyline shapefile, with file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Decrement"
+	ret[3].Type = "float64"
+	ret[3].Description = "The depth by which streams are lowered, in z units"
+	ret[3].Required = true
+
+	ret[4].Name = "BufferWidth"
+	ret[4].Type = "float64"
+	ret[4].Description = "The width of the smoothed banks, in xy units"
+	ret[4].Default = "0"
+
+	return ret
+}
+
+func (this *BurnStreams) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the padded streams grid and the drops
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+8) + gridBytes(rows+2, columns+2, 1)
+}
+
+func (this *BurnStreams) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input DEM, streams file, output file, and decrement must be specified.")
+		return
+	}
+	for i, fileName := range args[0:2] {
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.inputFile = fileName
+		} else {
+			this.streamsFile = fileName
+		}
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if this.decrement, err = strconv.ParseFloat(strings.TrimSpace(args[3]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.bufferWidth = 0
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.bufferWidth, err = strconv.ParseFloat(strings.TrimSpace(args[4]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *BurnStreams) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the DEM file name (incl. file extension): ",
+		"Enter the streams raster or shapefile name (incl. file extension): "}
+	for i, prompt := range prompts {
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.inputFile = fileName
+		} else {
+			this.streamsFile = fileName
+		}
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the decrement
+	print("Enter the decrement (z units): ")
+	decrementStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.decrement, err = strconv.ParseFloat(strings.TrimSpace(decrementStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the buffer width
+	print("Enter the bank buffer width (xy units, blank for none): ")
+	widthStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.bufferWidth = 0
+	if widthStr = strings.TrimSpace(widthStr); len(widthStr) > 0 {
+		if this.bufferWidth, err = strconv.ParseFloat(widthStr, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *BurnStreams) Run() {
+	start1 := time.Now()
+
+	if this.decrement <= 0 || this.bufferWidth < 0 {
+		println("The decrement must be greater than zero, and the buffer width can't be negative.")
+		return
+	}
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+
+	// the streams grid is padded by one cell on each side
+	streams, err := readStreamGrid(this.streamsFile, dem)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	drops := burnDrops(dem, streams, this.decrement, this.bufferWidth)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(demConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = demConfig.ZUnits
+	config.XYUnits = demConfig.XYUnits
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numStreamCells, numBankCells := 0, 0
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	var z float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z = dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			if streams[row+1][col+1] {
+				numStreamCells++
+			} else if drops[row*columns+col] > 0 {
+				numBankCells++
+			}
+			rout.SetValue(row, col, z-drops[row*columns+col])
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by BurnStreams tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Streams: %s", this.streamsFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Decrement: %v", this.decrement))
+	if this.bufferWidth > 0 {
+		rout.AddMetadataEntry(fmt.Sprintf("Buffer width: %v", this.bufferWidth))
+	}
+	rout.Save()
+
+	printf("Number of stream cells lowered: %v\n", numStreamCells)
+	if this.bufferWidth > 0 {
+		printf("Number of bank cells lowered: %v\n", numBankCells)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// readStreamGrid reads the cells of a stream network on the grid of a DEM,
+// padded by one cell on each side, from either a raster of streams, as
+// readConstraintGrid does, or the lines of a polyline shapefile, which mark
+// the cells that they cross.
+func readStreamGrid(fileName string, dem *raster.Raster) ([][]bool, error) {
+	if strings.ToLower(filepath.Ext(fileName)) != ".shp" {
+		return readConstraintGrid(fileName, dem)
+	}
+	s, err := vector.CreateShapefileFromFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if s.ShapeType != vector.ST_PolyLine && s.ShapeType != vector.ST_PolyLineZ && s.ShapeType != vector.ST_PolyLineM {
+		return nil, errors.New("The streams shapefile must contain polylines.")
+	}
+	grid := make([][]bool, dem.Rows+2)
+	for i := range grid {
+		grid[i] = make([]bool, dem.Columns+2)
+	}
+	for i := 0; i < s.NumShapes(); i++ {
+		for _, part := range s.GetShape(i).Parts {
+			vertices := make([][2]float64, len(part))
+			for j, p := range part {
+				vertices[j] = [2]float64{p.X, p.Y}
+			}
+			for _, cell := range rasterizePolyline(vertices, dem) {
+				grid[cell[0]+1][cell[1]+1] = true
+			}
+		}
+	}
+	return grid, nil
+}
+
+// burnDrops returns the amount by which each cell of a DEM is lowered by
+// burning in the streams, marked in a grid padded by one cell on each side:
+// the decrement at the streams and, within bufferWidth of them, a drop that
+// falls linearly with the distance to the nearest stream cell, to zero at the
+// edge of the buffer. The decrement must be positive.
+func burnDrops(dem *raster.Raster, streams [][]bool, decrement, bufferWidth float64) []float64 {
+	rows := dem.Rows
+	columns := dem.Columns
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	drops := make([]float64, rows*columns)
+	rx := int(math.Floor(bufferWidth / cellSizeX))
+	ry := int(math.Floor(bufferWidth / cellSizeY))
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if !streams[row+1][col+1] {
+				continue
+			}
+			drops[row*columns+col] = decrement
+			for r := row - ry; r <= row+ry; r++ {
+				if r < 0 || r >= rows {
+					continue
+				}
+				for c := col - rx; c <= col+rx; c++ {
+					if c < 0 || c >= columns {
+						continue
+					}
+					d := math.Hypot(float64(c-col)*cellSizeX, float64(r-row)*cellSizeY)
+					if d >= bufferWidth {
+						continue
+					}
+					drops[r*columns+c] = math.Max(drops[r*columns+c], decrement*(1-d/bufferWidth))
+				}
+			}
+		}
+	}
+	return drops
+}
@@ -0,0 +1,352 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// BurnWalls raises the elevations of DEM cells along linear features such
+// as roads and levees, the counterpart of stream burning.
+type BurnWalls struct {
+	inputFile   string
+	wallFile    string
+	gapFile     string
+	outputFile  string
+	wallHeight  float64
+	toolManager *PluginToolManager
+}
+
+func (this *BurnWalls) GetName() string {
+	s := "BurnWalls"
+	return getFormattedToolName(s)
+}
+
+func (this *BurnWalls) GetDescription() string {
+	s := "Raises DEM cells along roads, levees and other walls"
+	return getFormattedToolDescription(s)
+}
+
+func (this *BurnWalls) GetVersion() string {
+	return "1.0"
+}
+
+func (this *BurnWalls) GetHelpDocumentation() string {
+	ret := "This tool raises the elevations of the DEM cells along linear features such as " +
+		"roads, railways and levees by a specified height, the counterpart of stream " +
+		"burning. It is used to prevent spurious flow across these features before " +
+		"depressions are removed. Walls are the non-zero cells of a raster on the grid of " +
+		"the DEM, or aligned onto it (see AlignRasters). Where two wall cells meet only diagonally, one of the " +
+		"orthogonal cells between them is also raised so that D8 flow cannot pass through " +
+		"the wall. The optional gap raster marks culverts and bridges (non-zero cells) where " +
+		"the walls are not raised."
+	return ret
+}
+
+func (this *BurnWalls) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *BurnWalls) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "WallFile"
+	ret[1].Type = "string"
+	ret[1].Description = "Raster of walls (non-zero cells), with file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "WallHeight"
+	ret[3].Type = "float64"
+	ret[3].Description = "The height by which walls are raised, in z units"
+	ret[3].Required = true
+
+	ret[4].Name = "GapFile"
+	ret[4].Type = "string"
+	ret[4].Description = "Raster of culverts and bridges in the walls"
+	ret[4].Role = ArgInput
+
+	return ret
+}
+
+func (this *BurnWalls) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters and the padded wall and gap grids
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) + gridBytes(rows+2, columns+2, 1+1)
+}
+
+func (this *BurnWalls) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input DEM, wall file, output file, and wall height must be specified.")
+		return
+	}
+	for i, fileName := range args[0:2] {
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.inputFile = fileName
+		} else {
+			this.wallFile = fileName
+		}
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if this.wallHeight, err = strconv.ParseFloat(strings.TrimSpace(args[3]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.gapFile = ""
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		gapFile := strings.TrimSpace(args[4])
+		if !strings.Contains(gapFile, pathSep) {
+			gapFile = this.toolManager.workingDirectory + gapFile
+		}
+		if _, err := os.Stat(gapFile); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", gapFile)
+			return
+		}
+		this.gapFile = gapFile
+	}
+
+	this.Run()
+}
+
+func (this *BurnWalls) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the DEM file name (incl. file extension): ",
+		"Enter the wall raster file name (incl. file extension): "}
+	for i, prompt := range prompts {
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.inputFile = fileName
+		} else {
+			this.wallFile = fileName
+		}
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the wall height
+	print("Enter the wall height (z units): ")
+	heightStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.wallHeight, err = strconv.ParseFloat(strings.TrimSpace(heightStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the gap file name
+	print("Enter the gap raster file name (blank for none): ")
+	gapFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	gapFile = strings.TrimSpace(gapFile)
+	this.gapFile = ""
+	if len(gapFile) > 0 {
+		if !strings.Contains(gapFile, pathSep) {
+			gapFile = this.toolManager.workingDirectory + gapFile
+		}
+		if _, err := os.Stat(gapFile); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", gapFile)
+			return
+		}
+		this.gapFile = gapFile
+	}
+
+	this.Run()
+}
+
+func (this *BurnWalls) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+
+	// the constraint grids are padded by one cell on each side
+	walls, err := readConstraintGrid(this.wallFile, dem)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var gaps [][]bool
+	if this.gapFile != "" {
+		if gaps, err = readConstraintGrid(this.gapFile, dem); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	start2 := time.Now()
+
+	// close diagonal leaks: where two wall cells meet only at a corner, D8 flow
+	// can pass between them, so the lower of the two orthogonal cells is added
+	numAdded := 0
+	for row := 1; row <= rows; row++ {
+		for col := 1; col <= columns; col++ {
+			if !walls[row][col] {
+				continue
+			}
+			for _, dc := range []int{-1, 1} {
+				r, c := row+1, col+dc
+				if !walls[r][c] || walls[row][c] || walls[r][col] {
+					continue
+				}
+				z1 := dem.Value(row-1, c-1)
+				z2 := dem.Value(r-1, col-1)
+				if z1 == nodata && z2 == nodata {
+					continue
+				}
+				if z2 == nodata || (z1 != nodata && z1 <= z2) {
+					walls[row][c] = true
+				} else {
+					walls[r][col] = true
+				}
+				numAdded++
+			}
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(demConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = demConfig.ZUnits
+	config.XYUnits = demConfig.XYUnits
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numRaised := 0
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	var z float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z = dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			if walls[row+1][col+1] && (gaps == nil || !gaps[row+1][col+1]) {
+				z += this.wallHeight
+				numRaised++
+			}
+			rout.SetValue(row, col, z)
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by BurnWalls tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Walls: %s", this.wallFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Wall height: %v", this.wallHeight))
+	if this.gapFile != "" {
+		rout.AddMetadataEntry(fmt.Sprintf("Gaps: %s", this.gapFile))
+	}
+	rout.Save()
+
+	printf("Number of cells raised: %v (%v added to close diagonal gaps)\n", numRaised, numAdded)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
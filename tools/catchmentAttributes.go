@@ -0,0 +1,460 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// CatchmentAttributes writes the links of a raster stream network as
+// polylines with the area, mean slope and mean wetness index of their
+// catchments as attributes.
+type CatchmentAttributes struct {
+	demFile        string
+	streamsFile    string
+	accumFile      string
+	outputFile     string
+	catchmentsFile string
+	toolManager    *PluginToolManager
+}
+
+func (this *CatchmentAttributes) GetName() string {
+	s := "CatchmentAttributes"
+	return getFormattedToolName(s)
+}
+
+func (this *CatchmentAttributes) GetDescription() string {
+	s := "Attaches catchment statistics to stream links"
+	return getFormattedToolDescription(s)
+}
+
+func (this *CatchmentAttributes) GetVersion() string {
+	return "1.0"
+}
+
+func (this *CatchmentAttributes) GetHelpDocumentation() string {
+	ret := "This tool writes the links of a raster stream network, e.g. the output of " +
+		"ExtractStreams, as polylines, as RasterStreamsToVector does, with statistics of " +
+		"their catchments as attributes. Each cell of the DEM drains along its D8 flowpath " +
+		"to the first stream cell that it reaches, and so to the link of that cell, its " +
+		"local catchment; a link's catchment is its local catchment and those of all of " +
+		"the links above it. Cells that drain off the DEM or into pits without reaching a " +
+		"stream belong to no link, so the DEM should be breached or filled first. Along " +
+		"with the LINK_ID, DS_LINK, STRAHLER and LENGTH attributes of " +
+		"RasterStreamsToVector, each link has LOC_AREA and AREA, the areas of its local " +
+		"and whole catchments, in square metres, counting only cells with data, and " +
+		"MEAN_SLOPE and MEAN_WI, the mean slope, in degrees, and mean topographic wetness " +
+		"index, ln(a / tan(slope)), of its whole catchment, where a is the upslope area " +
+		"per unit contour width, from the flow accumulation raster, in cells, and slopes " +
+		"are no less than 0.001 (0.057 degrees). The output is a polyline shapefile, or a " +
+		"GeoJSON file if the output file name has a .geojson or .json extension. If a " +
+		"CatchmentsFile is given, the local catchment of each link, coded by its link " +
+		"number, is also written to it. Cell sizes in geographic coordinates are " +
+		"converted to metres."
+	return ret
+}
+
+func (this *CatchmentAttributes) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *CatchmentAttributes) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "StreamsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The streams raster name, with directory and file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "AccumulationFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The flow accumulation raster name, in cells, with directory and file extension"
+	ret[2].Role = ArgInput
+	ret[2].Required = true
+
+	ret[3].Name = "OutputFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The output shapefile or GeoJSON file name, with directory"
+	ret[3].Role = ArgOutput
+	ret[3].Required = true
+
+	ret[4].Name = "CatchmentsFile"
+	ret[4].Type = "string"
+	ret[4].Description = "The optional output catchments raster filename, with directory and file extension"
+	ret[4].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *CatchmentAttributes) EstimateMemory(rows, columns int) int64 {
+	// the DEM, streams, accumulation and catchments rasters, the flow
+	// directions, the downstream cells and numbers of inflowing cells of the
+	// streams, and the link of each cell
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+1+4+1+4)
+}
+
+func (this *CatchmentAttributes) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The DEM, streams file, accumulation file, and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(&this.demFile, args[0]) || !this.setInputFile(&this.streamsFile, args[1]) ||
+		!this.setInputFile(&this.accumFile, args[2]) {
+		return
+	}
+	this.setOutputFile(args[3])
+
+	catchmentsFile := ""
+	if len(args) > 4 && args[4] != "not specified" {
+		catchmentsFile = args[4]
+	}
+	this.setCatchmentsFile(catchmentsFile)
+
+	this.Run()
+}
+
+func (this *CatchmentAttributes) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the DEM file name (incl. file extension): ",
+		"Enter the streams file name (incl. file extension): ",
+		"Enter the flow accumulation file name (incl. file extension): "}
+	fileNames := []*string{&this.demFile, &this.streamsFile, &this.accumFile}
+	for i, fileName := range fileNames {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if !this.setInputFile(fileName, str) {
+			return
+		}
+	}
+
+	// get the output file name
+	print("Enter the output shapefile or GeoJSON file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the catchments file name
+	print("Enter the output catchments file name (blank for none): ")
+	catchmentsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setCatchmentsFile(catchmentsFile)
+
+	this.Run()
+}
+
+func (this *CatchmentAttributes) setInputFile(fileName *string, s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	*fileName = inputFile
+	if !raster.FileExists(inputFile) {
+		printf("no such file or directory: %s\n", inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *CatchmentAttributes) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	this.outputFile = outputFile
+}
+
+// setCatchmentsFile sets the optional catchments raster, which isn't written
+// if s is blank.
+func (this *CatchmentAttributes) setCatchmentsFile(s string) {
+	catchmentsFile := strings.TrimSpace(s)
+	if catchmentsFile == "" {
+		this.catchmentsFile = ""
+		return
+	}
+	if !strings.Contains(catchmentsFile, pathSep) {
+		catchmentsFile = this.toolManager.workingDirectory + catchmentsFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(catchmentsFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		catchmentsFile = catchmentsFile + raster.DefaultExtension // the default output format
+	}
+	this.catchmentsFile = catchmentsFile
+}
+
+func (this *CatchmentAttributes) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.demFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	streams, err := raster.CreateRasterFromFile(this.streamsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	accum, err := raster.CreateRasterFromFile(this.accumFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if !onSameGrid(dem, streams) || !onSameGrid(dem, accum) {
+		println("The DEM, streams and accumulation rasters must have the same dimensions.")
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	sn := traceStreamNetwork(streams, accum)
+	stats, catchments := linkCatchmentStats(dem, accum, sn)
+
+	// the output polylines
+	fields := []vector.Field{
+		{Name: "LINK_ID", Type: 'N', Length: 10},
+		{Name: "DS_LINK", Type: 'N', Length: 10},
+		{Name: "STRAHLER", Type: 'N', Length: 4},
+		{Name: "LENGTH", Type: 'N', Length: 18, Decimals: 3},
+		{Name: "LOC_AREA", Type: 'N', Length: 18, Decimals: 1},
+		{Name: "AREA", Type: 'N', Length: 18, Decimals: 1},
+		{Name: "MEAN_SLOPE", Type: 'N', Length: 12, Decimals: 4},
+		{Name: "MEAN_WI", Type: 'N', Length: 12, Decimals: 4},
+	}
+	output, err := vector.CreateNewShapefile(this.outputFile, vector.ST_PolyLine, fields)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt != "not specified" {
+		output.CoordinateRefSystemWKT = wkt
+	}
+	output.EPSGCode = inConfig.EPSGCode
+
+	// as in RasterStreamsToVector, links of a single cell are not written
+	for i, l := range sn.links {
+		if l.id == 0 {
+			continue
+		}
+		vertices, length := l.vertices(dem)
+		s := stats[i]
+		if err = output.AddShape(vector.NewPolyLine(vertices), l.id, sn.downstreamID(l), l.order, length,
+			s.localArea, s.area, s.meanSlope, s.meanWetness); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	elapsed := time.Since(start2)
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+	if err = output.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	if this.catchmentsFile != "" {
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_INT32
+		config.PreferredPalette = "qual.pal"
+		config.NoDataValue = -32768
+		config.InitialValue = -32768
+		config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+		config.EPSGCode = inConfig.EPSGCode
+		rout, err := raster.CreateNewRaster(this.catchmentsFile, rows, columns,
+			dem.North, dem.South, dem.East, dem.West, config)
+		if err != nil {
+			println("Failed to write raster")
+			return
+		}
+		for i, c := range catchments {
+			if c >= 0 && sn.links[c].id > 0 {
+				rout.SetValue(i/columns, i%columns, float64(sn.links[c].id))
+			}
+		}
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by CatchmentAttributes tool (%s)", this.toolManager.toolVersion(this)))
+		if err = rout.Save(); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	printf("Stream links: %v\n", sn.numLinks)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// catchmentStats holds the statistics of the catchment of a stream link.
+type catchmentStats struct {
+	localArea, area        float64 // in square metres
+	meanSlope, meanWetness float64 // the slope in degrees; zero if the catchment is empty
+}
+
+// minWetnessTangent is the least tangent of the slope in the wetness index,
+// which would otherwise be infinite on level ground.
+const minWetnessTangent = 0.001
+
+// linkCatchmentStats returns the catchment statistics of each link of a
+// stream network, indexed as sn.links, and the index of the link whose local
+// catchment each cell lies in, or -1. Each cell drains along its D8 flowpath
+// to the first stream cell that it reaches, which belongs to the link that
+// linkIDs assigns it to; the whole catchment of a link adds those of the
+// links above it. The wetness index uses the flow accumulation, in cells.
+func linkCatchmentStats(dem, accum *raster.Raster, sn *streamNetwork) ([]catchmentStats, []int32) {
+	var progress, oldProgress int
+	var eta progressETA
+
+	rows := sn.rows
+	columns := sn.columns
+	accumNodata := accum.NoDataValue
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	cellArea := cellSizeX * cellSizeY
+	contourWidth := (cellSizeX + cellSizeY) / 2
+	fd := FlowDirectionsFromDEM(dem)
+
+	// the stream cells belong to their links, and the other cells to the
+	// link of the stream cell that their flowpath reaches; -2 is unresolved
+	link := make([]int32, rows*columns)
+	for i := range link {
+		link[i] = -2
+	}
+	for li, l := range sn.links {
+		for k, c := range l.cells {
+			if k < len(l.cells)-1 || l.downLink < 0 {
+				link[c] = int32(li)
+			}
+		}
+	}
+	path := make([]int, 0)
+	for i := range link {
+		c := i
+		for link[c] == -2 {
+			path = append(path, c)
+			dir := fd.dir[c]
+			if dir < 0 {
+				link[c] = -1 // an outlet or pit
+				break
+			}
+			c = (c/columns+d8DY[dir])*columns + c%columns + d8DX[dir]
+		}
+		for _, p := range path {
+			link[p] = link[c]
+		}
+		path = path[:0]
+	}
+
+	// the local sums
+	numLinks := len(sn.links)
+	numCells := make([]float64, numLinks)
+	sumSlope := make([]float64, numLinks)
+	numWetness := make([]float64, numLinks)
+	sumWetness := make([]float64, numLinks)
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if link[i] < 0 {
+				continue
+			}
+			d, ok := hornDerivatives(dem, row, col, cellSizeX, cellSizeY)
+			if !ok {
+				continue
+			}
+			li := link[i]
+			slope := d.slope()
+			numCells[li]++
+			sumSlope[li] += slope
+			if a := accum.Value(row, col); a != accumNodata && a > 0 {
+				tangent := math.Max(math.Tan(slope*DegToRad), minWetnessTangent)
+				numWetness[li]++
+				sumWetness[li] += math.Log(a * cellArea / contourWidth / tangent)
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Catchments): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// the whole catchments, accumulated down the network from the channel
+	// heads
+	stats := make([]catchmentStats, numLinks)
+	totalCells := append([]float64(nil), numCells...)
+	totalSlope := append([]float64(nil), sumSlope...)
+	totalNumWetness := append([]float64(nil), numWetness...)
+	totalWetness := append([]float64(nil), sumWetness...)
+	numUpstream := make([]int, numLinks)
+	for _, l := range sn.links {
+		if l.downLink >= 0 {
+			numUpstream[l.downLink]++
+		}
+	}
+	queue := make([]int, 0)
+	for li := range sn.links {
+		if numUpstream[li] == 0 {
+			queue = append(queue, li)
+		}
+	}
+	for len(queue) > 0 {
+		li := queue[0]
+		queue = queue[1:]
+		s := &stats[li]
+		s.localArea = numCells[li] * cellArea
+		s.area = totalCells[li] * cellArea
+		if totalCells[li] > 0 {
+			s.meanSlope = totalSlope[li] / totalCells[li]
+		}
+		if totalNumWetness[li] > 0 {
+			s.meanWetness = totalWetness[li] / totalNumWetness[li]
+		}
+		if j := sn.links[li].downLink; j >= 0 {
+			totalCells[j] += totalCells[li]
+			totalSlope[j] += totalSlope[li]
+			totalNumWetness[j] += totalNumWetness[li]
+			totalWetness[j] += totalWetness[li]
+			if numUpstream[j]--; numUpstream[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	return stats, link
+}
@@ -0,0 +1,710 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// CoRegister estimates the horizontal (and optionally vertical) offset of a
+// target DEM relative to a reference DEM and resamples the target onto the
+// reference grid with the offset removed.
+type CoRegister struct {
+	referenceFile string
+	targetFile    string
+	outputFile    string
+	phaseCorr     bool
+	correctZ      bool
+	toolManager   *PluginToolManager
+}
+
+func (this *CoRegister) GetName() string {
+	s := "CoRegister"
+	return getFormattedToolName(s)
+}
+
+func (this *CoRegister) GetDescription() string {
+	s := "Co-registers a DEM to a reference DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *CoRegister) GetVersion() string {
+	return "1.0"
+}
+
+func (this *CoRegister) GetHelpDocumentation() string {
+	ret := "This tool estimates the sub-pixel horizontal offset between two overlapping DEMs " +
+		"and applies the correction to the target DEM, which is resampled (bilinear) onto the " +
+		"grid of the reference DEM. Two methods are available. The 'nuth' method (default) " +
+		"iteratively fits the elevation differences to the slope and aspect of the reference " +
+		"surface following Nuth and Kääb (2011), The Cryosphere, 5, 271-290. The 'phase' method " +
+		"first finds the offset to the nearest cell by phase correlation of the two surfaces " +
+		"over the largest power-of-two window that fits in the reference grid and then refines " +
+		"it with the slope-aspect fit; it is better suited to offsets of more than a few cells. " +
+		"If CorrectZ is true, the median vertical bias remaining after the horizontal " +
+		"correction is also removed. Both DEMs must share the same coordinate reference " +
+		"system and elevation units."
+	return ret
+}
+
+func (this *CoRegister) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *CoRegister) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "ReferenceDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The reference DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "TargetDEM"
+	ret[1].Type = "string"
+	ret[1].Description = "The DEM to be co-registered, with directory and file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "The estimation method, 'nuth' or 'phase'"
+	ret[3].Default = "nuth"
+	ret[3].Choices = []string{"nuth", "phase"}
+
+	ret[4].Name = "CorrectZ"
+	ret[4].Type = "bool"
+	ret[4].Description = "Remove the vertical bias as well"
+	ret[4].Default = "false"
+
+	return ret
+}
+
+func (this *CoRegister) EstimateMemory(rows, columns int) int64 {
+	// the reference, target and output rasters and the elevation differences
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+8)
+}
+
+func (this *CoRegister) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The reference DEM, target DEM, and output file must be specified.")
+		return
+	}
+	for i, fileName := range args[0:2] {
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.referenceFile = fileName
+		} else {
+			this.targetFile = fileName
+		}
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.phaseCorr = false
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(args[3])), "p") {
+			this.phaseCorr = true
+		}
+	}
+
+	this.correctZ = false
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if strings.ToLower(strings.TrimSpace(args[4])) == "true" {
+			this.correctZ = true
+		}
+	}
+
+	this.Run()
+}
+
+func (this *CoRegister) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the reference DEM file name (incl. file extension): ",
+		"Enter the target DEM file name (incl. file extension): "}
+	for i, prompt := range prompts {
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.referenceFile = fileName
+		} else {
+			this.targetFile = fileName
+		}
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the method
+	print("Estimation method, 'nuth' or 'phase' (blank for nuth): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.phaseCorr = strings.HasPrefix(strings.ToLower(strings.TrimSpace(method)), "p")
+
+	// correct the vertical bias?
+	print("Remove the vertical bias (T or F)? ")
+	correctZ, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	correctZ = strings.ToLower(strings.TrimSpace(correctZ))
+	this.correctZ = correctZ == "t" || correctZ == "true"
+
+	this.Run()
+}
+
+func (this *CoRegister) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	ref, err := raster.CreateRasterFromFile(this.referenceFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	target, err := raster.CreateRasterFromFile(this.targetFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := ref.Rows
+	columns := ref.Columns
+	nodata := ref.NoDataValue
+	targetNodata := target.NoDataValue
+	inConfig := ref.GetRasterConfig()
+	cellSizeX := ref.GetCellSizeX()
+	cellSizeY := ref.GetCellSizeY()
+
+	if ref.IsInGeographicCoordinates() {
+		println("Warning: The reference DEM appears to be in geographic coordinates. Slopes will be inaccurate.")
+	}
+
+	var dx, dy float64
+	if this.phaseCorr {
+		println("Estimating offset by phase correlation...")
+		if dx, dy, err = this.phaseCorrelation(ref, target); err != nil {
+			println(err.Error())
+			return
+		}
+		printf("Integer offset: %v, %v\n", dx, dy)
+	}
+	println("Estimating offset by slope-aspect fitting...")
+	if dx, dy, err = this.nuthKaab(ref, target, dx, dy); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// vertical bias after the horizontal correction
+	dh := make([]float64, 0, rows*columns)
+	for row := 0; row < rows; row++ {
+		y := ref.North - (float64(row)+0.5)*cellSizeY
+		for col := 0; col < columns; col++ {
+			z := ref.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			x := ref.West + (float64(col)+0.5)*cellSizeX
+			zt := target.SampleBilinear(x+dx, y+dy)
+			if zt != targetNodata {
+				dh = append(dh, zt-z)
+			}
+		}
+	}
+	dz := 0.0
+	if len(dh) > 0 {
+		sort.Float64s(dh)
+		dz = dh[len(dh)/2]
+	}
+
+	printf("Estimated offset: dX = %v, dY = %v, dZ = %v\n", dx, dy, dz)
+	if !this.correctZ {
+		dz = 0.0
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		ref.North, ref.South, ref.East, ref.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		y := ref.North - (float64(row)+0.5)*cellSizeY
+		for col := 0; col < columns; col++ {
+			x := ref.West + (float64(col)+0.5)*cellSizeX
+			zt := target.SampleBilinear(x+dx, y+dy)
+			if zt != targetNodata {
+				rout.SetValue(row, col, zt-dz)
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by CoRegister tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Offset removed: dX = %v, dY = %v, dZ = %v", dx, dy, dz))
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Iteratively solves dh = tan(slope)*(dX*sin(aspect) + dY*cos(aspect)) + c
+// for the horizontal offset (dX, dY) of the target relative to the reference,
+// following Nuth and Kääb (2011). Aspect is the downslope direction.
+func (this *CoRegister) nuthKaab(ref, target *raster.Raster, dx0, dy0 float64) (dx, dy float64, err error) {
+	rows := ref.Rows
+	columns := ref.Columns
+	nodata := ref.NoDataValue
+	targetNodata := target.NoDataValue
+	cellSizeX := ref.GetCellSizeX()
+	cellSizeY := ref.GetCellSizeY()
+	eightGridRes := 8 * (cellSizeX + cellSizeY) / 2.0
+	maxSlope := math.Tan(70.0 * DegToRad)
+
+	// calculate the slope and aspect terms of the reference surface
+	type sample struct {
+		x, y, z    float64
+		tanSinAsp  float64
+		tanCosAsp  float64
+		difference float64
+	}
+	samples := make([]sample, 0)
+	dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
+	dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
+	N := [8]float64{}
+	for row := 1; row < rows-1; row++ {
+		for col := 1; col < columns-1; col++ {
+			z := ref.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			valid := true
+			for n := 0; n < 8; n++ {
+				N[n] = ref.Value(row+dY[n], col+dX[n])
+				if N[n] == nodata {
+					valid = false
+					break
+				}
+			}
+			if !valid {
+				continue
+			}
+			fy := (N[6] - N[4] + 2*(N[7]-N[3]) + N[0] - N[2]) / eightGridRes
+			fx := (N[2] - N[4] + 2*(N[1]-N[5]) + N[0] - N[6]) / eightGridRes
+			if math.Hypot(fx, fy) > maxSlope {
+				continue
+			}
+			// tan(slope)*sin(aspect) = -fx and tan(slope)*cos(aspect) = -fy
+			samples = append(samples, sample{x: ref.West + (float64(col)+0.5)*cellSizeX,
+				y: ref.North - (float64(row)+0.5)*cellSizeY, z: z,
+				tanSinAsp: -fx, tanCosAsp: -fy})
+		}
+	}
+	if len(samples) < 3 {
+		return 0, 0, errors.New("There are too few valid cells in the overlap of the two DEMs.")
+	}
+
+	dx, dy = dx0, dy0
+	tolerance := 0.001 * (cellSizeX + cellSizeY) / 2.0
+	maxIterations := 25
+	for iter := 1; iter <= maxIterations; iter++ {
+		// elevation differences at the current offset
+		differences := make([]float64, 0, len(samples))
+		for i := range samples {
+			s := &samples[i]
+			zt := target.SampleBilinear(s.x+dx, s.y+dy)
+			if zt == targetNodata {
+				s.difference = math.NaN()
+				continue
+			}
+			s.difference = zt - s.z
+			differences = append(differences, s.difference)
+		}
+		if len(differences) < 3 {
+			return 0, 0, errors.New("There are too few valid cells in the overlap of the two DEMs.")
+		}
+
+		// exclude outliers using the normalized median absolute deviation
+		median, nmad := medianAndNMAD(differences)
+		threshold := 3.0 * nmad
+		if threshold <= 0 {
+			threshold = math.MaxFloat64
+		}
+
+		// least-squares fit of the differences
+		a := [][]float64{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}
+		b := []float64{0, 0, 0}
+		n := 0
+		for _, s := range samples {
+			if math.IsNaN(s.difference) || math.Abs(s.difference-median) > threshold {
+				continue
+			}
+			f := [3]float64{s.tanSinAsp, s.tanCosAsp, 1.0}
+			for j := 0; j < 3; j++ {
+				for k := 0; k < 3; k++ {
+					a[j][k] += f[j] * f[k]
+				}
+				b[j] += f[j] * s.difference
+			}
+			n++
+		}
+		if n < 3 {
+			return 0, 0, errors.New("There are too few valid cells in the overlap of the two DEMs.")
+		}
+		solution, err := solveLinearSystem(a, b)
+		if err != nil {
+			return 0, 0, errors.New("The offset could not be estimated; the overlapping terrain may be too flat.")
+		}
+		dx += solution[0]
+		dy += solution[1]
+		printf("Iteration %v: dX = %v, dY = %v\n", iter, dx, dy)
+		if math.Hypot(solution[0], solution[1]) < tolerance {
+			break
+		}
+	}
+
+	return dx, dy, nil
+}
+
+// Estimates the horizontal offset of the target relative to the reference
+// to the nearest grid cell using phase correlation over a power-of-two
+// window centred on the reference grid.
+func (this *CoRegister) phaseCorrelation(ref, target *raster.Raster) (dx, dy float64, err error) {
+	rows := ref.Rows
+	columns := ref.Columns
+	nodata := ref.NoDataValue
+	targetNodata := target.NoDataValue
+	cellSizeX := ref.GetCellSizeX()
+	cellSizeY := ref.GetCellSizeY()
+
+	size := 1
+	for size*2 <= rows && size*2 <= columns && size < 1024 {
+		size *= 2
+	}
+	if size < 8 {
+		return 0, 0, errors.New("The reference DEM is too small for phase correlation.")
+	}
+	row0 := (rows - size) / 2
+	col0 := (columns - size) / 2
+
+	// Sample both surfaces over the window (plus a one-cell border).
+	z1 := make([][]float64, size+2)
+	z2 := make([][]float64, size+2)
+	for i := 0; i < size+2; i++ {
+		z1[i] = make([]float64, size+2)
+		z2[i] = make([]float64, size+2)
+		row := row0 + i - 1
+		y := ref.North - (float64(row)+0.5)*cellSizeY
+		for j := 0; j < size+2; j++ {
+			col := col0 + j - 1
+			x := ref.West + (float64(col)+0.5)*cellSizeX
+			z1[i][j] = ref.Value(row, col)
+			if z1[i][j] == nodata {
+				z1[i][j] = math.NaN()
+			}
+			z2[i][j] = target.SampleBilinear(x, y)
+			if z2[i][j] == targetNodata {
+				z2[i][j] = math.NaN()
+			}
+		}
+	}
+
+	// The correlation is performed on the complex gradient (dz/dx + i dz/dy)
+	// rather than the elevations, which removes regional trends that would
+	// otherwise dominate the spectrum. Being linear, the gradient operator
+	// preserves the offset between the two surfaces.
+	gradient := func(z [][]float64, i, j int) complex128 {
+		fx := z[i+1][j+2] - z[i+1][j]
+		fy := z[i][j+1] - z[i+2][j+1]
+		if math.IsNaN(fx) || math.IsNaN(fy) {
+			return 0
+		}
+		return complex(fx, fy)
+	}
+	w1 := make([][]complex128, size)
+	w2 := make([][]complex128, size)
+	var sum1, sum2 complex128
+	var n1, n2 int
+	for i := 0; i < size; i++ {
+		w1[i] = make([]complex128, size)
+		w2[i] = make([]complex128, size)
+		for j := 0; j < size; j++ {
+			w1[i][j] = gradient(z1, i, j)
+			w2[i][j] = gradient(z2, i, j)
+			if w1[i][j] != 0 {
+				sum1 += w1[i][j]
+				n1++
+			}
+			if w2[i][j] != 0 {
+				sum2 += w2[i][j]
+				n2++
+			}
+		}
+	}
+	if n1 == 0 || n2 == 0 {
+		return 0, 0, errors.New("There are too few valid cells in the overlap of the two DEMs.")
+	}
+
+	// remove the mean gradient and apply a Hann taper
+	mean1 := sum1 / complex(float64(n1), 0)
+	mean2 := sum2 / complex(float64(n2), 0)
+	for i := 0; i < size; i++ {
+		wi := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+		for j := 0; j < size; j++ {
+			w := complex(wi*(0.5-0.5*math.Cos(2*math.Pi*float64(j)/float64(size-1))), 0)
+			if w1[i][j] != 0 {
+				w1[i][j] = (w1[i][j] - mean1) * w
+			}
+			if w2[i][j] != 0 {
+				w2[i][j] = (w2[i][j] - mean2) * w
+			}
+		}
+	}
+
+	fft2d(w1, false)
+	fft2d(w2, false)
+
+	// normalized cross-power spectrum
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			v := w1[i][j] * cmplx.Conj(w2[i][j])
+			if mag := cmplx.Abs(v); mag > 0 {
+				w1[i][j] = v / complex(mag, 0)
+			} else {
+				w1[i][j] = 0
+			}
+		}
+	}
+	fft2d(w1, true)
+
+	// locate the correlation peak
+	peakRow, peakCol := 0, 0
+	peak := -math.MaxFloat64
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			if real(w1[i][j]) > peak {
+				peak = real(w1[i][j])
+				peakRow, peakCol = i, j
+			}
+		}
+	}
+	if peakRow > size/2 {
+		peakRow -= size
+	}
+	if peakCol > size/2 {
+		peakCol -= size
+	}
+
+	// the peak lies at the negative of the shift of the target; rows increase southward
+	dx = float64(-peakCol) * cellSizeX
+	dy = float64(peakRow) * cellSizeY
+	return dx, dy, nil
+}
+
+// Returns the median and the normalized median absolute deviation of a
+// slice of values. The slice is sorted in place.
+func medianAndNMAD(values []float64) (median, nmad float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(values)
+	median = values[len(values)/2]
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+	sort.Float64s(deviations)
+	nmad = 1.4826 * deviations[len(deviations)/2]
+	return median, nmad
+}
+
+// Solves the linear system a*x = b by Gaussian elimination with partial
+// pivoting. Both a and b are modified.
+func solveLinearSystem(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	for k := 0; k < n; k++ {
+		// find the pivot
+		p := k
+		for i := k + 1; i < n; i++ {
+			if math.Abs(a[i][k]) > math.Abs(a[p][k]) {
+				p = i
+			}
+		}
+		if math.Abs(a[p][k]) < 1e-12 {
+			return nil, errors.New("The linear system is singular.")
+		}
+		a[k], a[p] = a[p], a[k]
+		b[k], b[p] = b[p], b[k]
+		for i := k + 1; i < n; i++ {
+			f := a[i][k] / a[k][k]
+			for j := k; j < n; j++ {
+				a[i][j] -= f * a[k][j]
+			}
+			b[i] -= f * b[k]
+		}
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		sum := b[i]
+		for j := i + 1; j < n; j++ {
+			sum -= a[i][j] * x[j]
+		}
+		x[i] = sum / a[i][i]
+	}
+	return x, nil
+}
+
+// In-place radix-2 fast Fourier transform. The length of data must be a
+// power of two.
+func fft(data []complex128, inverse bool) {
+	n := len(data)
+	// bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for length := 2; length <= n; length <<= 1 {
+		angle := sign * 2 * math.Pi / float64(length)
+		wLen := complex(math.Cos(angle), math.Sin(angle))
+		for i := 0; i < n; i += length {
+			w := complex(1, 0)
+			for j := 0; j < length/2; j++ {
+				u := data[i+j]
+				v := data[i+j+length/2] * w
+				data[i+j] = u + v
+				data[i+j+length/2] = u - v
+				w *= wLen
+			}
+		}
+	}
+	if inverse {
+		for i := range data {
+			data[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// Two-dimensional FFT of a square, power-of-two sized grid.
+func fft2d(data [][]complex128, inverse bool) {
+	n := len(data)
+	for i := 0; i < n; i++ {
+		fft(data[i], inverse)
+	}
+	column := make([]complex128, n)
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			column[i] = data[i][j]
+		}
+		fft(column, inverse)
+		for i := 0; i < n; i++ {
+			data[i][j] = column[i]
+		}
+	}
+}
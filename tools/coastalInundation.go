@@ -0,0 +1,359 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// CoastalInundation floods the cells of a DEM that lie below a water level
+// and are hydraulically connected to the ocean, i.e. a bathtub model with a
+// connectivity constraint.
+type CoastalInundation struct {
+	inputFile        string
+	seedFile         string
+	waterLevel       float64
+	depthOutputFile  string
+	extentOutputFile string
+	toolManager      *PluginToolManager
+}
+
+func (this *CoastalInundation) GetName() string {
+	s := "CoastalInundation"
+	return getFormattedToolName(s)
+}
+
+func (this *CoastalInundation) GetDescription() string {
+	s := "Connected bathtub inundation below a water level"
+	return getFormattedToolDescription(s)
+}
+
+func (this *CoastalInundation) GetVersion() string {
+	return "1.0"
+}
+
+func (this *CoastalInundation) GetHelpDocumentation() string {
+	ret := "This tool maps coastal inundation using a bathtub model with a connectivity " +
+		"constraint: cells with elevations below the WaterLevel are flooded only if they are " +
+		"connected, through 8-connected neighbours that are also below the water level, to " +
+		"the ocean. The ocean is defined by seed cells, read from a raster (cells that are " +
+		"neither zero nor nodata) or a text file of 'x y' points, or, if the SeedFile is " +
+		"'edge', by the cells on the edge of the DEM or adjacent to nodata cells, as is " +
+		"common where the sea is nodata. The depth output contains the water depth of the " +
+		"flooded cells and zero elsewhere, and the extent output contains 1 for flooded cells " +
+		"and 0 elsewhere. Low-lying areas below the water level that aren't connected to the " +
+		"ocean are reported but not flooded."
+	return ret
+}
+
+func (this *CoastalInundation) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *CoastalInundation) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "SeedFile"
+	ret[1].Type = "string"
+	ret[1].Description = "An ocean seed raster, a seed points file, or 'edge'"
+	ret[1].Required = true
+
+	ret[2].Name = "WaterLevel"
+	ret[2].Type = "float64"
+	ret[2].Description = "The water level, in the DEM's elevation units"
+	ret[2].Required = true
+
+	ret[3].Name = "OutputDepthFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The depth output filename, with directory and file extension"
+	ret[3].Role = ArgOutputRaster
+	ret[3].Required = true
+
+	ret[4].Name = "OutputExtentFile"
+	ret[4].Type = "string"
+	ret[4].Description = "The extent output filename, with directory and file extension"
+	ret[4].Role = ArgOutputRaster
+	ret[4].Required = true
+
+	return ret
+}
+
+func (this *CoastalInundation) EstimateMemory(rows, columns int) int64 {
+	// the DEM and two output rasters and the seed of each cell
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+4)
+}
+
+func (this *CoastalInundation) ParseArguments(args []string) {
+	if len(args) < 5 {
+		println("The input DEM, seed file, water level, and two output files must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	if !this.setSeedFile(args[1]) {
+		return
+	}
+	var err error
+	if this.waterLevel, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	outputFiles := []*string{&this.depthOutputFile, &this.extentOutputFile}
+	for i, f := range outputFiles {
+		outputFile := strings.TrimSpace(args[i+3])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		*f = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *CoastalInundation) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the seed file name
+	print("Enter the ocean seed raster or points file name, or 'edge': ")
+	seedFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setSeedFile(seedFile) {
+		return
+	}
+
+	// get the water level
+	print("Water level: ")
+	levelStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.waterLevel, err = strconv.ParseFloat(strings.TrimSpace(levelStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the output file names
+	prompts := []string{"Enter the depth output file name (incl. file extension): ",
+		"Enter the extent output file name (incl. file extension): "}
+	outputFiles := []*string{&this.depthOutputFile, &this.extentOutputFile}
+	for i, f := range outputFiles {
+		print(prompts[i])
+		outputFile, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		outputFile = strings.TrimSpace(outputFile)
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		*f = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *CoastalInundation) setSeedFile(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.ToLower(s) == "edge" {
+		this.seedFile = ""
+		return true
+	}
+	if !strings.Contains(s, pathSep) {
+		s = this.toolManager.workingDirectory + s
+	}
+	if _, err := os.Stat(s); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", s)
+		return false
+	}
+	this.seedFile = s
+	return true
+}
+
+func (this *CoastalInundation) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+
+	var seeds []seedCell
+	if this.seedFile != "" {
+		if seeds, err = readSeedCells(this.seedFile, dem); err != nil {
+			println(err.Error())
+			return
+		}
+	} else if seeds, err = edgeSeedCells(dem); err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	level := this.waterLevel
+	origin, _ := floodFill(dem, seeds, func(z, seedZ float64) bool { return z < level }, 8)
+
+	// create the output rasters
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "blueyellow.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = demConfig.ZUnits
+	config.XYUnits = demConfig.XYUnits
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	depthOut, err := raster.CreateNewRaster(this.depthOutputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	config2 := raster.NewDefaultRasterConfig()
+	config2.PreferredPalette = "qual.plt"
+	config2.DataType = raster.DT_INT16
+	config2.NoDataValue = nodata
+	config2.InitialValue = nodata
+	config2.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config2.EPSGCode = demConfig.EPSGCode
+	extentOut, err := raster.CreateNewRaster(this.extentOutputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config2)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numFlooded, numIsolated := 0, 0
+	var maxDepth float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			if origin[row*columns+col] >= 0 {
+				depth := level - z
+				depthOut.SetValue(row, col, depth)
+				extentOut.SetValue(row, col, 1)
+				numFlooded++
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+			} else {
+				depthOut.SetValue(row, col, 0)
+				extentOut.SetValue(row, col, 0)
+				if z < level {
+					numIsolated++
+				}
+			}
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	for _, rout := range []*raster.Raster{depthOut, extentOut} {
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by CoastalInundation tool (%s)", this.toolManager.toolVersion(this)))
+		rout.AddMetadataEntry(fmt.Sprintf("Water level: %v", level))
+		rout.Save()
+	}
+
+	printf("Flooded cells: %v (%.1f%%), maximum depth: %.3f\n", numFlooded,
+		100.0*float64(numFlooded)/float64(rows*columns), maxDepth)
+	if numIsolated > 0 {
+		printf("Cells below the water level but not connected to the ocean: %v\n", numIsolated)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// edgeSeedCells returns the valid cells of r that lie on the edge of the grid
+// or adjacent to nodata cells.
+func edgeSeedCells(r *raster.Raster) ([]seedCell, error) {
+	nodata := r.NoDataValue
+	seeds := make([]seedCell, 0)
+	for row := 0; row < r.Rows; row++ {
+		for col := 0; col < r.Columns; col++ {
+			if r.Value(row, col) == nodata {
+				continue
+			}
+			for n := 0; n < 8; n++ {
+				rowN, colN := row+d8DY[n], col+d8DX[n]
+				if rowN < 0 || rowN >= r.Rows || colN < 0 || colN >= r.Columns || r.Value(rowN, colN) == nodata {
+					seeds = append(seeds, seedCell{row, col, 1})
+					break
+				}
+			}
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, errors.New("The raster does not contain any valid cells.")
+	}
+	return seeds, nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ConvertPointer translates a D8 pointer raster from one flow direction
+// encoding to another.
+type ConvertPointer struct {
+	inputFile      string
+	outputFile     string
+	inputEncoding  pointerEncoding
+	outputEncoding pointerEncoding
+	toolManager    *PluginToolManager
+}
+
+func (this *ConvertPointer) GetName() string {
+	s := "ConvertPointer"
+	return getFormattedToolName(s)
+}
+
+func (this *ConvertPointer) GetDescription() string {
+	s := "Converts D8 pointers between encodings"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ConvertPointer) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ConvertPointer) GetHelpDocumentation() string {
+	ret := "This tool translates a D8 flow pointer raster between the encodings used by " +
+		"different software. The supported encodings are 'whitebox' (1 = NE, 2 = E, 4 = SE, " +
+		"... 128 = N), 'esri' (1 = E, 2 = SE, 4 = S, ... 128 = NE), 'taudem' (1 = E, 2 = NE, " +
+		"3 = N, ... 8 = SE), and 'gospatial' (1 = NE, 2 = E, 3 = SE, ... 8 = N), the backlink " +
+		"encoding used internally by the GoSpatial flow-routing tools. Cells without a valid " +
+		"flow direction, e.g. outlets and pits, are assigned 0, except in the 'taudem' " +
+		"encoding, where they are assigned nodata."
+	return ret
+}
+
+func (this *ConvertPointer) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ConvertPointer) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input pointer name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "InputEncoding"
+	ret[2].Type = "string"
+	ret[2].Description = "The input encoding: whitebox, esri, taudem or gospatial"
+	ret[2].Required = true
+	ret[2].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	ret[3].Name = "OutputEncoding"
+	ret[3].Type = "string"
+	ret[3].Description = "The output encoding: whitebox, esri, taudem or gospatial"
+	ret[3].Required = true
+	ret[3].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	return ret
+}
+
+func (this *ConvertPointer) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input file, output file, and input and output encodings must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if this.inputEncoding, err = parsePointerEncoding(args[2]); err != nil {
+		println(err.Error())
+		return
+	}
+	if this.outputEncoding, err = parsePointerEncoding(args[3]); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.Run()
+}
+
+func (this *ConvertPointer) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the pointer file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the encodings
+	print("Input encoding (whitebox, esri, taudem or gospatial): ")
+	encoding, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.inputEncoding, err = parsePointerEncoding(encoding); err != nil {
+		println(err.Error())
+		return
+	}
+	print("Output encoding (whitebox, esri, taudem or gospatial): ")
+	encoding, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.outputEncoding, err = parsePointerEncoding(encoding); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.Run()
+}
+
+func (this *ConvertPointer) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	outNodata := -32768.0
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	config.DataType = raster.DT_INT16
+	config.NoDataValue = outNodata
+	config.InitialValue = outNodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	noFlow := 0.0
+	if this.outputEncoding == taudemPointer {
+		noFlow = outNodata
+	}
+
+	numInvalid := 0
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	var z float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z = rin.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			if n := this.inputEncoding.decode(z); n >= 0 {
+				rout.SetValue(row, col, this.outputEncoding.encode(n))
+			} else {
+				if z != 0 {
+					numInvalid++
+				}
+				rout.SetValue(row, col, noFlow)
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ConvertPointer tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Pointer encoding: %s (converted from %s)", this.outputEncoding, this.inputEncoding))
+	rout.Save()
+
+	if numInvalid > 0 {
+		printf("Warning: %v cells did not contain valid %s pointer values.\n", numInvalid, this.inputEncoding)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,264 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Curvature calculates the profile, plan or tangential curvature of a DEM
+// from the quadratic surface of Evans (1979).
+type Curvature struct {
+	inputFile     string
+	outputFile    string
+	curvatureType string
+	toolManager   *PluginToolManager
+}
+
+func (this *Curvature) GetName() string {
+	s := "Curvature"
+	return getFormattedToolName(s)
+}
+
+func (this *Curvature) GetDescription() string {
+	s := "Calculates the profile, plan or tangential curvature of a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Curvature) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Curvature) GetHelpDocumentation() string {
+	ret := "This tool calculates the curvature of each cell of a digital elevation model " +
+		"(DEM) from the quadratic surface fitted to its 3 x 3 neighbourhood (Evans, 1979). " +
+		"The CurvatureType may be 'profile', the default, the curvature along the line of " +
+		"steepest slope, which is positive where the slope is convex, steepening downslope, " +
+		"and negative where it is concave; 'plan', the curvature of the contour, which is " +
+		"positive where flow diverges, e.g. on ridges, and negative where it converges, " +
+		"e.g. in hollows; or 'tangential', the curvature in the direction of the contour " +
+		"of the surface itself, which is the plan curvature times the sine of the slope, " +
+		"and so is less extreme on gentle slopes. Curvatures are in degrees per unit of XY " +
+		"distance, or per metre if the DEM is in geographic coordinates, whose cell sizes " +
+		"are converted from degrees to metres at its mid-latitude, and are zero on level " +
+		"ground. Nodata neighbours take the elevation of the cell. Since curvature is " +
+		"sensitive to noise, a DEM may need smoothing first."
+	return ret
+}
+
+func (this *Curvature) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Curvature) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CurvatureType"
+	ret[2].Type = "string"
+	ret[2].Description = "The type of curvature: profile, plan or tangential"
+	ret[2].Default = "profile"
+	ret[2].Choices = []string{"profile", "plan", "tangential"}
+
+	return ret
+}
+
+func (this *Curvature) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters
+	return gridBytes(rows, columns, 2*rasterBytesPerCell)
+}
+
+func (this *Curvature) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	curvatureType := ""
+	if len(args) > 2 && args[2] != "not specified" {
+		curvatureType = args[2]
+	}
+	if !this.setCurvatureType(curvatureType) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *Curvature) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the curvature type
+	print("Curvature type, 'profile', 'plan' or 'tangential' (blank for the default): ")
+	curvatureType, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setCurvatureType(curvatureType) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *Curvature) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *Curvature) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+// setCurvatureType sets the type of curvature, profile if s is blank.
+func (this *Curvature) setCurvatureType(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		this.curvatureType = "profile"
+	case "profile", "plan", "tangential":
+		this.curvatureType = s
+	default:
+		printf("unrecognized curvature type: %s\n", s)
+		return false
+	}
+	return true
+}
+
+func (this *Curvature) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+
+	curvature := surfaceDerivatives.profileCurvature
+	switch this.curvatureType {
+	case "plan":
+		curvature = surfaceDerivatives.planCurvature
+	case "tangential":
+		curvature = surfaceDerivatives.tangentialCurvature
+	}
+
+	start2 := time.Now()
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "blue_white_red.plt"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if d, ok := evansDerivatives(dem, row, col, cellSizeX, cellSizeY); ok {
+				rout.SetValue(row, col, curvature(d)*RadToDeg)
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Curvature tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Curvature type: %s", this.curvatureType))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
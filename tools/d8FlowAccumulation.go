@@ -11,7 +11,9 @@ import (
 	"bufio"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +25,14 @@ type D8FlowAccumulation struct {
 	inputFile   string
 	outputFile  string
 	lnTransform bool
+	logMethod   logTransform
+	rho8        bool
+	seed        int64
+	isPointer   bool
+	encoding    pointerEncoding
+	pointerFile string
+	pointerEnc  pointerEncoding
+	weightFile  string
 	toolManager *PluginToolManager
 }
 
@@ -36,8 +46,33 @@ func (this *D8FlowAccumulation) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *D8FlowAccumulation) GetVersion() string {
+	return "1.0"
+}
+
 func (this *D8FlowAccumulation) GetHelpDocumentation() string {
-	ret := "This tool calculates a D8 flow accumulation raster from a digital elevation model (DEM)."
+	ret := "This tool calculates a D8 flow accumulation raster from a digital elevation model (DEM). " +
+		"If the Method is 'rho8', flow directions are assigned with the stochastic Rho8 method " +
+		"of Fairfield and Leymarie (1991), Water Resources Research, 27(5), 709-717, in which the " +
+		"distance to the diagonal neighbours of each cell is randomly perturbed. This reduces " +
+		"the parallel flow paths that D8 produces on low-relief terrain. The Seed value can be " +
+		"specified to make the Rho8 result repeatable. Alternatively, if a PointerEncoding " +
+		"('whitebox', 'esri', 'taudem' or 'gospatial') is specified, the input is an existing D8 pointer " +
+		"raster in that encoding and flow directions are not recalculated, which guarantees " +
+		"consistency with pointers produced by other software. In TauDEM mode (see the 'taudemon' " +
+		"command), an unspecified output is named with the TauDEM 'ad8' suffix, nodata cells are " +
+		"assigned -1, and a TauDEM-encoded pointer with the 'p' suffix is also written. If LogTransform " +
+		"is true, the output is log-transformed using the LogMethod, either 'ln', the natural logarithm, " +
+		"or 'ln1p', the natural logarithm of one plus the value, which is zero rather than undefined " +
+		"at zero. Cells at which the transform is undefined are assigned nodata and are counted. " +
+		"If a PointerFile is specified, the flow directions are also written to it, as a D8 " +
+		"pointer in the PointerFileEncoding, by default 'whitebox', as D8Pointer would write them. " +
+		"If a WeightTable is specified, each cell contributes a weighted amount of flow rather " +
+		"than one cell, e.g. from a snowmelt contribution curve, which is interpolated linearly " +
+		"from the table's 'value weight' lines by the cell's aspect or elevation, as named on its " +
+		"first line, 'aspect' or 'elevation'. Elevations beyond the ends of the table take the " +
+		"weight of the nearest end, aspects wrap around from 360 to 0 degrees, and level cells " +
+		"have a weight of 1. A weight table needs a DEM input, rather than a pointer."
 	return ret
 }
 
@@ -45,28 +80,73 @@ func (this *D8FlowAccumulation) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *D8FlowAccumulation) GetArgDescriptions() [][]string {
-	numArgs := 3
-
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
-
-	ret[2][0] = "LogTransform"
-	ret[2][1] = "bool"
-	ret[2][2] = "Log transform the output?"
+func (this *D8FlowAccumulation) GetArgDescriptions() []ToolArg {
+	numArgs := 10
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM (or D8 pointer) name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = taudemOutputRequired(this.toolManager)
+
+	ret[2].Name = "LogTransform"
+	ret[2].Type = "bool"
+	ret[2].Description = "Log transform the output?"
+	ret[2].Default = "false"
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "The flow direction method, 'd8' or 'rho8'"
+	ret[3].Default = "d8"
+	ret[3].Choices = []string{"d8", "rho8"}
+
+	ret[4].Name = "Seed"
+	ret[4].Type = "int"
+	ret[4].Description = "The random seed for the Rho8 method"
+
+	ret[5].Name = "PointerEncoding"
+	ret[5].Type = "string"
+	ret[5].Description = "Input is a D8 pointer: 'whitebox', 'esri', 'taudem' or 'gospatial'"
+	ret[5].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	ret[6].Name = "LogMethod"
+	ret[6].Type = "string"
+	ret[6].Description = "The log transform, 'ln' or 'ln1p' (i.e. ln(1 + x))"
+	ret[6].Default = "ln"
+	ret[6].Choices = []string{"ln", "ln1p"}
+
+	ret[7].Name = "PointerFile"
+	ret[7].Type = "string"
+	ret[7].Description = "The optional output D8 pointer filename, with directory and file extension"
+	ret[7].Role = ArgOutputRaster
+
+	ret[8].Name = "PointerFileEncoding"
+	ret[8].Type = "string"
+	ret[8].Description = "The encoding of the output pointer: whitebox, esri, taudem or gospatial"
+	ret[8].Default = "whitebox"
+	ret[8].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	ret[9].Name = "WeightTable"
+	ret[9].Type = "string"
+	ret[9].Description = "The optional table of flow weights by aspect or elevation"
+	ret[9].Role = ArgInput
 
 	return ret
 }
 
+func (this *D8FlowAccumulation) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the padded flow direction and inflowing
+	// neighbour grids and the optional weights
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+8) + gridBytes(rows+2, columns+2, 1+1)
+}
+
 func (this *D8FlowAccumulation) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -75,18 +155,21 @@ func (this *D8FlowAccumulation) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
 	outputFile := args[1]
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "ad8")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -100,6 +183,54 @@ func (this *D8FlowAccumulation) ParseArguments(args []string) {
 	} else {
 		this.lnTransform = false
 	}
+
+	this.rho8 = false
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		this.rho8 = strings.ToLower(strings.TrimSpace(args[3])) == "rho8"
+	}
+
+	this.seed = time.Now().UnixNano()
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(args[4]), 10, 64); err != nil {
+			println(err)
+			return
+		}
+	}
+
+	this.isPointer = false
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if this.encoding, err = parsePointerEncoding(args[5]); err != nil {
+			println(err.Error())
+			return
+		}
+		this.isPointer = true
+	}
+
+	this.logMethod = lnTransform
+	if len(args) > 6 && len(strings.TrimSpace(args[6])) > 0 && args[6] != "not specified" {
+		if this.logMethod, err = parseLogTransform(args[6]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	pointerFile, pointerEnc := "", ""
+	if len(args) > 7 && args[7] != "not specified" {
+		pointerFile = args[7]
+	}
+	if len(args) > 8 && args[8] != "not specified" {
+		pointerEnc = args[8]
+	}
+	if !this.setPointerFile(pointerFile, pointerEnc) {
+		return
+	}
+
+	this.weightFile = ""
+	if len(args) > 9 && len(strings.TrimSpace(args[9])) > 0 && args[9] != "not specified" {
+		if !this.setWeightFile(args[9]) {
+			return
+		}
+	}
 	this.Run()
 }
 
@@ -118,7 +249,7 @@ func (this *D8FlowAccumulation) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -130,15 +261,33 @@ func (this *D8FlowAccumulation) CollectArguments() {
 		println(err)
 	}
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "ad8")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	// is the input a pointer?
+	print("If the input is a D8 pointer, its encoding, 'whitebox', 'esri', 'taudem' or 'gospatial' (blank for a DEM): ")
+	encodingStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.isPointer = false
+	if len(strings.TrimSpace(encodingStr)) > 0 {
+		if this.encoding, err = parsePointerEncoding(encodingStr); err != nil {
+			println(err.Error())
+			return
+		}
+		this.isPointer = true
+	}
+
 	// get the ln-transform argument
 	print("Log-transform the output (T or F)? ")
 	lnTransformStr, err := consolereader.ReadString('\n')
@@ -156,24 +305,165 @@ func (this *D8FlowAccumulation) CollectArguments() {
 		this.lnTransform = false
 	}
 
+	// get the log transform method
+	this.logMethod = lnTransform
+	if this.lnTransform {
+		print("Log transform, 'ln' or 'ln1p' (blank for ln): ")
+		logMethod, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(logMethod)) > 0 {
+			if this.logMethod, err = parseLogTransform(logMethod); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	// get the flow direction method
+	this.rho8 = false
+	if !this.isPointer {
+		print("Flow direction method, 'd8' or 'rho8' (blank for d8): ")
+		method, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		this.rho8 = strings.ToLower(strings.TrimSpace(method)) == "rho8"
+	}
+
+	this.seed = time.Now().UnixNano()
+	if this.rho8 {
+		print("Random seed (blank for none): ")
+		seedStr, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(seedStr)) > 0 {
+			if this.seed, err = strconv.ParseInt(strings.TrimSpace(seedStr), 10, 64); err != nil {
+				println(err)
+				return
+			}
+		}
+	}
+
+	// get the pointer output
+	pointerFile, pointerEnc := "", ""
+	if !this.isPointer {
+		print("Enter the output pointer file name (blank for none): ")
+		if pointerFile, err = consolereader.ReadString('\n'); err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(pointerFile)) > 0 {
+			print("Pointer encoding, 'whitebox', 'esri', 'taudem' or 'gospatial' (blank for the default): ")
+			if pointerEnc, err = consolereader.ReadString('\n'); err != nil {
+				println(err)
+			}
+		}
+	}
+	if !this.setPointerFile(pointerFile, pointerEnc) {
+		return
+	}
+
+	// get the weight table
+	this.weightFile = ""
+	if !this.isPointer {
+		print("Enter the flow weight table file name (blank for none): ")
+		weightFile, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(weightFile)) > 0 && !this.setWeightFile(weightFile) {
+			return
+		}
+	}
+
 	this.Run()
 }
 
+// setWeightFile sets the optional table of flow weights.
+func (this *D8FlowAccumulation) setWeightFile(fileName string) bool {
+	fileName = strings.TrimSpace(fileName)
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", fileName)
+		return false
+	}
+	this.weightFile = fileName
+	return true
+}
+
+// setPointerFile sets the optional pointer output and its encoding, which is
+// Whitebox's if encoding is blank. In TauDEM mode, a TauDEM-encoded pointer
+// with the 'p' suffix is written by default when flow directions are
+// calculated from a DEM.
+func (this *D8FlowAccumulation) setPointerFile(fileName, encoding string) bool {
+	fileName = strings.TrimSpace(fileName)
+	this.pointerEnc = whiteboxPointer
+	if this.toolManager.TauDEMMode && !this.isPointer {
+		this.pointerEnc = taudemPointer
+		if fileName == "" {
+			fileName = taudemFileName(this.inputFile, filepath.Dir(this.outputFile), "p")
+		}
+	}
+	if len(strings.TrimSpace(encoding)) > 0 {
+		var err error
+		if this.pointerEnc, err = parsePointerEncoding(encoding); err != nil {
+			println(err.Error())
+			return false
+		}
+	}
+	this.pointerFile = ""
+	if fileName == "" {
+		return true
+	}
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	rasterType, err := raster.DetermineRasterFormat(fileName)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		fileName = fileName + raster.DefaultExtension // the default output format
+	}
+	this.pointerFile = fileName
+	return true
+}
+
 func (this *D8FlowAccumulation) Run() {
 	start1 := time.Now()
 
 	var z, zN, slope, maxSlope float64
 	var progress, oldProgress, col, row, r, c, i, n int
+	var eta progressETA
 	var dir int8
 	//var b int8
 	dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
 	dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
 	//inflowingVals := [8]int8{5, 6, 7, 8, 1, 2, 3, 4}
 
-	println("Reading DEM data...")
+	var weights *weightTable
+	if this.weightFile != "" {
+		if this.isPointer {
+			println("A weight table can't be used with a D8 pointer input.")
+			return
+		}
+		var err error
+		if weights, err = readWeightTable(this.weightFile); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	if this.isPointer {
+		println("Reading pointer data...")
+	} else {
+		println("Reading DEM data...")
+	}
 	dem, err := raster.CreateRasterFromFile(this.inputFile)
 	if err != nil {
 		println(err.Error())
+		return
 	}
 	rows := dem.Rows
 	columns := dem.Columns
@@ -183,6 +473,8 @@ func (this *D8FlowAccumulation) Run() {
 	cellSizeY := dem.GetCellSizeY()
 	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
 	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+	gridRes := (cellSizeX + cellSizeY) / 2.0
+	rng := rand.New(rand.NewSource(this.seed))
 	println("Calculating pointer grid...")
 	flowdir := make([][]int8, rows+2)
 	numInflowing := make([][]int8, rows+2)
@@ -200,7 +492,18 @@ func (this *D8FlowAccumulation) Run() {
 			z = dem.Value(row, col)
 			flowdir[row+1][col+1] = 0
 			//			numInflowing[row+1][col+1] = 0
-			if z != nodata {
+			if z != nodata && this.isPointer {
+				// pointers that lead off the grid or into nodata are outlets
+				if n = this.encoding.decode(z); n >= 0 && dem.Value(row+dY[n], col+dX[n]) != nodata {
+					flowdir[row+1][col+1] = int8(n) + 1
+					numInflowing[row+dY[n]+1][col+dX[n]+1]++
+				}
+			} else if z != nodata {
+				if this.rho8 {
+					// the diagonal distance is gridRes * (2 - p), p ~ U[0, 1)
+					diagDist = gridRes * (2.0 - rng.Float64())
+					dist[0], dist[2], dist[4], dist[6] = diagDist, diagDist, diagDist, diagDist
+				}
 				maxSlope = math.Inf(-1)
 				for n = 0; n < 8; n++ {
 					zN = dem.Value(row+dY[n], col+dX[n])
@@ -230,7 +533,7 @@ func (this *D8FlowAccumulation) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rLoop (1 of 3): %v%%", progress)
+			printf("\rLoop (1 of 3): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -259,7 +562,7 @@ func (this *D8FlowAccumulation) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rLoop (2 of 3): %v%%", progress)
+			printf("\rLoop (2 of 3): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -269,7 +572,12 @@ func (this *D8FlowAccumulation) Run() {
 	config.DataType = raster.DT_FLOAT32
 	config.NoDataValue = nodata
 	config.InitialValue = 1
+	if this.toolManager.TauDEMMode {
+		config.NoDataValue = taudemAreaNodata
+	}
 	config.PreferredPalette = "blueyellow.pal"
+	config.DisplayClipPercent = 2.0 // accumulation is highly skewed
+	config.DisplayClipSampleSize = 1000000
 	config.CoordinateRefSystemWKT = dem.GetRasterConfig().CoordinateRefSystemWKT
 	config.EPSGCode = dem.GetRasterConfig().EPSGCode
 	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -277,6 +585,20 @@ func (this *D8FlowAccumulation) Run() {
 	if err != nil {
 		panic("Failed to write raster")
 	}
+	outNodata := config.NoDataValue
+	var cellWeights []float64
+	if weights != nil {
+		cellWeights = weights.cellWeights(dem)
+	}
+	for row = 0; row < rows; row++ {
+		for col = 0; col < columns; col++ {
+			if dem.Value(row, col) == nodata {
+				rout.SetValue(row, col, outNodata)
+			} else if cellWeights != nil {
+				rout.SetValue(row, col, cellWeights[row*columns+col])
+			}
+		}
+	}
 
 	// perform the flow accumlation
 	println("")
@@ -307,7 +629,7 @@ func (this *D8FlowAccumulation) Run() {
 		numSolvedCells++
 		progress = int(100.0 * numSolvedCells / numCellsTotal)
 		if progress != oldProgress {
-			printf("\rLoop (3 of 3): %v%%", progress)
+			printf("\rLoop (3 of 3): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -369,28 +691,63 @@ func (this *D8FlowAccumulation) Run() {
 		printf("\r                                                    ")
 		printf("\rTransforming output: %v%%", 0)
 		oldProgress = 0
+		numUndefined := 0
 		for row = 0; row < rows; row++ {
 			for col = 0; col < columns; col++ {
 				z = rout.Value(row, col)
-				if z != nodata {
-					rout.SetValue(row, col, math.Log(z))
+				if z != outNodata {
+					if z, ok := this.logMethod.apply(z); ok {
+						rout.SetValue(row, col, z)
+					} else {
+						rout.SetValue(row, col, outNodata)
+						numUndefined++
+					}
 				}
 			}
 			progress = int(100.0 * row / rowsLessOne)
 			if progress != oldProgress {
-				printf("\rTransforming output: %v%%", progress)
+				printf("\rTransforming output: %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
+		if numUndefined > 0 {
+			printf("\nWarning: the log transform was undefined for %v cells, which were assigned nodata.", numUndefined)
+		}
 	}
 
 	println("\nSaving data...")
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start1)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool (%s)", this.toolManager.toolVersion(this)))
+	if this.isPointer {
+		rout.AddMetadataEntry(fmt.Sprintf("Flow directions: %s pointer", this.encoding))
+	} else if this.rho8 {
+		rout.AddMetadataEntry(fmt.Sprintf("Flow directions: Rho8, seed %v", this.seed))
+	}
+	if this.lnTransform {
+		rout.AddMetadataEntry(fmt.Sprintf("Log transform: %s", this.logMethod))
+	}
+	if weights != nil {
+		rout.AddMetadataEntry(fmt.Sprintf("Weighted by %s: %s", weights.variable, this.weightFile))
+	}
 	rout.Save()
 
+	if this.pointerFile != "" {
+		printf("Saving pointer data to %s...\n", this.pointerFile)
+		pout, err := writePointer(this.pointerFile, dem, this.pointerEnc, func(row, col int) int {
+			return int(flowdir[row+1][col+1]) - 1
+		})
+		if err != nil {
+			println("Failed to write raster")
+			return
+		}
+		pout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		pout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool (%s)", this.toolManager.toolVersion(this)))
+		pout.AddMetadataEntry(fmt.Sprintf("Encoding: %s", this.pointerEnc))
+		pout.Save()
+	}
+
 	println("Operation complete!")
 
 	//value = fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
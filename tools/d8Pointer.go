@@ -0,0 +1,259 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// D8Pointer calculates the D8 flow directions of a DEM as a pointer raster.
+type D8Pointer struct {
+	inputFile   string
+	outputFile  string
+	encoding    pointerEncoding
+	toolManager *PluginToolManager
+}
+
+func (this *D8Pointer) GetName() string {
+	s := "D8Pointer"
+	return getFormattedToolName(s)
+}
+
+func (this *D8Pointer) GetDescription() string {
+	s := "Calculates a D8 flow pointer raster from a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *D8Pointer) GetVersion() string {
+	return "1.0"
+}
+
+func (this *D8Pointer) GetHelpDocumentation() string {
+	ret := "This tool calculates the D8 flow direction of each cell of a digital elevation " +
+		"model (DEM), i.e. the direction of its steepest downslope neighbour, as used by " +
+		"D8FlowAccumulation, and writes it as a pointer raster in the given Encoding, " +
+		"'whitebox' (1 = NE, 2 = E, 4 = SE, ... 128 = N), the default, 'esri' (1 = E, 2 = SE, " +
+		"4 = S, ... 128 = NE), 'taudem' (1 = E, 2 = NE, 3 = N, ... 8 = SE) or 'gospatial' " +
+		"(1 = NE, 2 = E, 3 = SE, ... 8 = N). Cells without a downslope neighbour, e.g. pits, " +
+		"flats and outlets, are assigned 0, except in the 'taudem' encoding, where they are " +
+		"assigned nodata, as are the nodata cells of the DEM. The DEM should normally have " +
+		"had its depressions removed, e.g. by BreachDepressions or FillDepressions. In TauDEM " +
+		"mode (see the 'taudemon' command), the encoding defaults to 'taudem' and an " +
+		"unspecified output is named with the TauDEM 'p' suffix. The pointer can be read " +
+		"by D8FlowAccumulation and translated to other encodings by ConvertPointer."
+	return ret
+}
+
+func (this *D8Pointer) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *D8Pointer) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output pointer filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = taudemOutputRequired(this.toolManager)
+
+	ret[2].Name = "Encoding"
+	ret[2].Type = "string"
+	ret[2].Description = "The pointer encoding: whitebox, esri, taudem or gospatial"
+	ret[2].Default = "whitebox"
+	ret[2].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	return ret
+}
+
+func (this *D8Pointer) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters and the flow directions
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+1)
+}
+
+func (this *D8Pointer) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	encoding := ""
+	if len(args) > 2 && args[2] != "not specified" {
+		encoding = args[2]
+	}
+	if !this.setEncoding(encoding) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *D8Pointer) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the encoding
+	print("Pointer encoding, 'whitebox', 'esri', 'taudem' or 'gospatial' (blank for the default): ")
+	encoding, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setEncoding(encoding) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *D8Pointer) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *D8Pointer) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "p")
+	}
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+// setEncoding sets the output encoding, which is TauDEM's in TauDEM mode and
+// Whitebox's otherwise if s is blank.
+func (this *D8Pointer) setEncoding(s string) bool {
+	if len(strings.TrimSpace(s)) == 0 {
+		this.encoding = whiteboxPointer
+		if this.toolManager.TauDEMMode {
+			this.encoding = taudemPointer
+		}
+		return true
+	}
+	var err error
+	if this.encoding, err = parsePointerEncoding(s); err != nil {
+		println(err.Error())
+		return false
+	}
+	return true
+}
+
+func (this *D8Pointer) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	cellSizeX := dem.GetCellSizeX()
+	cellSizeY := dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+
+	start2 := time.Now()
+
+	dir := make([]int8, rows*columns)
+	numPits := 0
+	nodata := dem.NoDataValue
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dir[row*columns+col] = d8Direction(dem, row, col, dist)
+			if dir[row*columns+col] < 0 && dem.Value(row, col) != nodata {
+				numPits++
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	rout, err := writePointer(this.outputFile, dem, this.encoding, func(row, col int) int {
+		return int(dir[row*columns+col])
+	})
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by D8Pointer tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Encoding: %s", this.encoding))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Cells without a downslope neighbour: %v\n", numPits)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
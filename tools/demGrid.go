@@ -0,0 +1,95 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// demGrid holds the elevations of a DEM compactly, as float32 values unless
+// the file stores float64 values, for the hydrological tools that visit its
+// cells in an order set by the terrain and so need the whole grid in memory.
+// It is read a band of rows at a time with a raster.BlockReader, so that the
+// file's data are never also held as a Raster's float64 values.
+type demGrid struct {
+	Rows, Columns            int
+	North, South, East, West float64
+	NoDataValue              float64
+	config                   *raster.RasterConfig
+	data32                   []float32
+	data64                   []float64 // for DEMs stored as float64 values
+}
+
+// readDEMGrid reads a DEM into a demGrid. The formats that a BlockReader
+// can't read, e.g. ASCII grids, are read as a Raster and copied.
+func readDEMGrid(fileName string) (*demGrid, error) {
+	br, err := raster.OpenStreaming(fileName)
+	if err != nil {
+		r, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		g := newDEMGrid(r.Rows, r.Columns, r.NoDataValue, r.GetRasterConfig())
+		g.North, g.South, g.East, g.West = r.North, r.South, r.East, r.West
+		for row := 0; row < r.Rows; row++ {
+			for col := 0; col < r.Columns; col++ {
+				g.set(row*r.Columns+col, r.Value(row, col))
+			}
+		}
+		return g, nil
+	}
+	defer br.Close()
+	g := newDEMGrid(br.Rows, br.Columns, br.NoDataValue, br.GetRasterConfig())
+	g.North, g.South, g.East, g.West = br.North, br.South, br.East, br.West
+	buf := make([]float64, br.BlockRows()*br.Columns)
+	for row := 0; row < br.Rows; {
+		n, err := br.ReadRows(row, buf)
+		if err != nil {
+			return nil, err
+		}
+		for i, z := range buf[:n*br.Columns] {
+			g.set(row*br.Columns+i, z)
+		}
+		row += n
+	}
+	return g, nil
+}
+
+func newDEMGrid(rows, columns int, nodata float64, config *raster.RasterConfig) *demGrid {
+	g := &demGrid{Rows: rows, Columns: columns, config: config}
+	if config.DataType == raster.DT_FLOAT64 {
+		g.data64 = make([]float64, rows*columns)
+		g.NoDataValue = nodata
+	} else {
+		// the nodata value must survive the conversion to match the cells
+		g.data32 = make([]float32, rows*columns)
+		g.NoDataValue = float64(float32(nodata))
+	}
+	return g
+}
+
+func (g *demGrid) set(i int, z float64) {
+	if g.data64 != nil {
+		g.data64[i] = z
+	} else {
+		g.data32[i] = float32(z)
+	}
+}
+
+// Value returns the elevation of a cell, or nodata outside the grid.
+func (g *demGrid) Value(row, col int) float64 {
+	if row < 0 || row >= g.Rows || col < 0 || col >= g.Columns {
+		return g.NoDataValue
+	}
+	if g.data64 != nil {
+		return g.data64[row*g.Columns+col]
+	}
+	return float64(g.data32[row*g.Columns+col])
+}
+
+// GetRasterConfig returns the config read from the header of the DEM.
+func (g *demGrid) GetRasterConfig() *raster.RasterConfig {
+	return g.config
+}
@@ -0,0 +1,624 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Flag values of the DEMQualityReport output.
+const (
+	demFlagValid  = 0
+	demFlagVoid   = 1
+	demFlagSpike  = 2
+	demFlagWell   = 3
+	demFlagStripe = 4
+)
+
+// DEMQualityReport detects voids, spikes, wells and stripes in a DEM.
+type DEMQualityReport struct {
+	inputFile       string
+	outputFile      string
+	reportFile      string
+	spikeThreshold  float64
+	stripeThreshold float64
+	toolManager     *PluginToolManager
+}
+
+func (this *DEMQualityReport) GetName() string {
+	s := "DEMQualityReport"
+	return getFormattedToolName(s)
+}
+
+func (this *DEMQualityReport) GetDescription() string {
+	s := "Detects voids, spikes and stripes in a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *DEMQualityReport) GetVersion() string {
+	return "1.0"
+}
+
+func (this *DEMQualityReport) GetHelpDocumentation() string {
+	ret := "This tool checks a DEM for common data problems before lengthy processing, such " +
+		"as depression breaching, and writes a flag raster and a report. Voids are groups of " +
+		"nodata cells that are enclosed by valid data; nodata cells connected to the edge of " +
+		"the raster are treated as lying outside of the data area and are nodata in the " +
+		"output. Spikes and wells are cells that are higher or lower than the median of their " +
+		"valid neighbours by more than the SpikeThreshold (default 5, in z units). Stripes " +
+		"are detected in the Fourier power spectrum of the high-pass filtered DEM: rows or " +
+		"columns of constant offset concentrate power along an axis of the spectrum, and the " +
+		"stripe index is the ratio of the axis power to that expected for noise without " +
+		"stripes. Where the index exceeds the StripeThreshold (default 5), the rows (or " +
+		"columns) whose mean high-pass value is anomalous are flagged. Cells of the output are " +
+		"flagged 0 (valid), 1 (void), 2 (spike), 3 (well) or 4 (stripe). The areas of voids " +
+		"are reported in square metres, also for DEMs in geographic coordinates, and their " +
+		"centroids in the coordinates of the DEM. The optional ReportFile (by default the " +
+		"-report file, if any) lists each void, spike and well, and the stripe indices, in CSV " +
+		"format."
+	return ret
+}
+
+func (this *DEMQualityReport) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *DEMQualityReport) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output flag raster name, with directory and extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "ReportFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output CSV report file name"
+	ret[2].Role = ArgOutput
+
+	ret[3].Name = "SpikeThreshold"
+	ret[3].Type = "float64"
+	ret[3].Description = "Spike and well threshold, in z units"
+	ret[3].Default = "5"
+
+	ret[4].Name = "StripeThreshold"
+	ret[4].Type = "float64"
+	ret[4].Description = "Stripe index threshold"
+	ret[4].Default = "5"
+
+	return ret
+}
+
+func (this *DEMQualityReport) EstimateMemory(rows, columns int) int64 {
+	// the DEM, output and high-pass rasters, the void labels and a window of
+	// at most 1024 x 1024 complex values for the FFT
+	n := 1
+	for n*2 <= rows && n*2 <= columns && n < 1024 {
+		n *= 2
+	}
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+4) + gridBytes(n, n, 16)
+}
+
+func (this *DEMQualityReport) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.reportFile = ""
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		this.setReportFile(args[2])
+	}
+	this.spikeThreshold = 5.0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if this.spikeThreshold, err = parsePositiveFloat(args[3], "spike threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.stripeThreshold = 5.0
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.stripeThreshold, err = parsePositiveFloat(args[4], "stripe threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *DEMQualityReport) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output flag raster file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the report file name
+	this.reportFile = ""
+	print("Enter the report CSV file name (blank for none): ")
+	reportFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(reportFile)) > 0 {
+		this.setReportFile(reportFile)
+	}
+
+	// get the thresholds
+	this.spikeThreshold = 5.0
+	print("Spike threshold, in z units (blank for 5): ")
+	spikeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(spikeStr)) > 0 {
+		if this.spikeThreshold, err = parsePositiveFloat(spikeStr, "spike threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.stripeThreshold = 5.0
+	print("Stripe index threshold (blank for 5): ")
+	stripeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(stripeStr)) > 0 {
+		if this.stripeThreshold, err = parsePositiveFloat(stripeStr, "stripe threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *DEMQualityReport) setReportFile(s string) {
+	reportFile := strings.TrimSpace(s)
+	if !strings.Contains(reportFile, pathSep) {
+		reportFile = this.toolManager.workingDirectory + reportFile
+	}
+	if filepath.Ext(reportFile) == "" {
+		reportFile += ".csv"
+	}
+	this.reportFile = reportFile
+}
+
+// Parses a value that must be greater than zero.
+func parsePositiveFloat(s, name string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("The %s must be greater than zero.", name)
+	}
+	return v, nil
+}
+
+func (this *DEMQualityReport) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+	cellSizeX := dem.GetCellSizeX()
+	cellSizeY := dem.GetCellSizeY()
+	geographic := dem.IsInGeographicCoordinates()
+
+	start2 := time.Now()
+
+	// the area, in square metres, of the cells of a row
+	cellArea := func(row int) float64 {
+		if geographic {
+			lat := dem.North - (float64(row)+0.5)*cellSizeY
+			return cellSizeX * 111320.0 * math.Cos(lat*DegToRad) * cellSizeY * 111320.0
+		}
+		return cellSizeX * cellSizeY
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "qual.plt"
+	config.DataType = raster.DT_INT16
+	outNodata := -32768.0
+	config.NoDataValue = outNodata
+	config.InitialValue = outNodata
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if dem.Value(row, col) != nodata {
+				rout.SetValue(row, col, demFlagValid)
+			}
+		}
+	}
+
+	type issue struct {
+		kind       string
+		cells      int
+		area, x, y float64
+		magnitude  float64
+	}
+
+	// voids: nodata regions that are not connected to the edge of the raster
+	println("Finding voids...")
+	label := make([]int32, rows*columns) // -1 outside the data area, 0 valid
+	isNodata := func(row, col int) bool {
+		return dem.Value(row, col) == nodata
+	}
+	queue := make([]int, 0)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if (row == 0 || row == rows-1 || col == 0 || col == columns-1) && isNodata(row, col) {
+				label[row*columns+col] = -1
+				queue = append(queue, row*columns+col)
+			}
+		}
+	}
+	grow := func(value int32) (cells int, area, sumX, sumY float64) {
+		for len(queue) > 0 {
+			i := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			row, col := i/columns, i%columns
+			cells++
+			a := cellArea(row)
+			area += a
+			sumX += dem.West + (float64(col)+0.5)*cellSizeX
+			sumY += dem.North - (float64(row)+0.5)*cellSizeY
+			for n := 0; n < 8; n++ {
+				rn, cn := row+d8DY[n], col+d8DX[n]
+				if rn < 0 || rn >= rows || cn < 0 || cn >= columns {
+					continue
+				}
+				if j := rn*columns + cn; label[j] == 0 && isNodata(rn, cn) {
+					label[j] = value
+					queue = append(queue, j)
+				}
+			}
+		}
+		return
+	}
+	grow(-1)
+	voids := make([]issue, 0)
+	voidCells := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if label[i] != 0 || !isNodata(row, col) {
+				continue
+			}
+			label[i] = int32(len(voids) + 1)
+			queue = append(queue, i)
+			cells, area, sumX, sumY := grow(label[i])
+			voids = append(voids, issue{"void", cells, area, sumX / float64(cells), sumY / float64(cells), 0})
+			voidCells += cells
+		}
+	}
+	for i, l := range label {
+		if l > 0 {
+			rout.SetValue(i/columns, i%columns, demFlagVoid)
+		}
+	}
+
+	// spikes and wells: deviations from the median of the valid neighbours
+	println("Finding spikes and wells...")
+	spikes := make([]issue, 0)
+	neighbours := make([]float64, 0, 8)
+	numSpikes, numWells := 0, 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			median, ok := neighbourMedian(dem.Value, nodata, row, col, neighbours)
+			if !ok {
+				continue
+			}
+			dev := z - median
+			if math.Abs(dev) <= this.spikeThreshold {
+				continue
+			}
+			x := dem.West + (float64(col)+0.5)*cellSizeX
+			y := dem.North - (float64(row)+0.5)*cellSizeY
+			if dev > 0 {
+				rout.SetValue(row, col, demFlagSpike)
+				spikes = append(spikes, issue{"spike", 1, cellArea(row), x, y, dev})
+				numSpikes++
+			} else {
+				rout.SetValue(row, col, demFlagWell)
+				spikes = append(spikes, issue{"well", 1, cellArea(row), x, y, dev})
+				numWells++
+			}
+		}
+	}
+
+	println("Finding stripes...")
+	highPass := highPassValues(dem)
+	rowIndex, colIndex := stripeIndices(highPass, rows, columns)
+	stripeCells := 0
+	flagStripes := func(byRow bool) {
+		numLines, lineLength := rows, columns
+		if !byRow {
+			numLines, lineLength = columns, rows
+		}
+		means := make([]float64, numLines)
+		for i := 0; i < numLines; i++ {
+			sum, n := 0.0, 0
+			for j := 0; j < lineLength; j++ {
+				row, col := i, j
+				if !byRow {
+					row, col = j, i
+				}
+				if dem.Value(row, col) != nodata {
+					sum += highPass[row*columns+col]
+					n++
+				}
+			}
+			if n > 0 {
+				means[i] = sum / float64(n)
+			}
+		}
+		sorted := append([]float64(nil), means...)
+		median, nmad := medianAndNMAD(sorted)
+		for i := 0; i < numLines; i++ {
+			if math.Abs(means[i]-median) <= 3*nmad {
+				continue
+			}
+			for j := 0; j < lineLength; j++ {
+				row, col := i, j
+				if !byRow {
+					row, col = j, i
+				}
+				if rout.Value(row, col) == demFlagValid {
+					rout.SetValue(row, col, demFlagStripe)
+					stripeCells++
+				}
+			}
+		}
+	}
+	if rowIndex > this.stripeThreshold {
+		flagStripes(true)
+	}
+	if colIndex > this.stripeThreshold {
+		flagStripes(false)
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by DEMQualityReport tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Flags: 0 valid, 1 void, 2 spike, 3 well, 4 stripe"))
+	rout.Save()
+
+	// the report
+	sort.SliceStable(voids, func(i, j int) bool { return voids[i].cells > voids[j].cells })
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	printf("Voids: %v (%v cells)\n", len(voids), voidCells)
+	for i, v := range voids {
+		if i == 10 {
+			printf("  ... and %v smaller voids\n", len(voids)-10)
+			break
+		}
+		printf("  %v cells, %.1f m^2, centred at (%s, %s)\n", v.cells, v.area, format(v.x), format(v.y))
+	}
+	printf("Spikes: %v, wells: %v\n", numSpikes, numWells)
+	printf("Stripe index: rows %.2f, columns %.2f", rowIndex, colIndex)
+	if stripeCells > 0 {
+		printf(" (%v cells flagged as stripes)", stripeCells)
+	}
+	printf("\n")
+
+	var report *tableWriter
+	if this.reportFile != "" {
+		report, err = createTable(this.reportFile, "issue", "cells", "area", "x", "y", "magnitude")
+	} else {
+		report, err = this.toolManager.openReport("issue", "cells", "area", "x", "y", "magnitude")
+	}
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	for _, list := range [][]issue{voids, spikes} {
+		for _, v := range list {
+			report.writeRow(v.kind, v.cells, strconv.FormatFloat(v.area, 'f', 2, 64), v.x, v.y, v.magnitude)
+		}
+	}
+	report.writeRow("stripe_rows", "", "", "", "", strconv.FormatFloat(rowIndex, 'f', 4, 64))
+	report.writeRow("stripe_columns", "", "", "", "", strconv.FormatFloat(colIndex, 'f', 4, 64))
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Returns the median of the valid 8 neighbours of a cell, using buf as
+// scratch space. It returns false where fewer than four neighbours are valid,
+// since the median of so few values does not describe the surface.
+func neighbourMedian(value func(row, col int) float64, nodata float64, row, col int, buf []float64) (float64, bool) {
+	buf = buf[:0]
+	for n := 0; n < 8; n++ {
+		if zn := value(row+d8DY[n], col+d8DX[n]); zn != nodata {
+			buf = append(buf, zn)
+		}
+	}
+	if len(buf) < 4 {
+		return 0, false
+	}
+	sort.Float64s(buf)
+	m := len(buf)
+	return (buf[(m-1)/2] + buf[m/2]) / 2, true
+}
+
+// Returns the high-pass filtered values of a DEM, i.e. the difference from
+// the 3 x 3 mean, in row-major order. The values are zero where the
+// neighbourhood is incomplete, so that the edges of the data do not
+// themselves appear as lines in the spectrum.
+func highPassValues(dem *raster.Raster) []float64 {
+	rows, columns := dem.Rows, dem.Columns
+	nodata := dem.NoDataValue
+	highPass := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := dem.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			sum, n := 0.0, 0
+			for r := row - 1; r <= row+1; r++ {
+				for c := col - 1; c <= col+1; c++ {
+					if zn := dem.Value(r, c); zn != nodata {
+						sum += zn
+						n++
+					}
+				}
+			}
+			if n == 9 {
+				highPass[row*columns+col] = z - sum/9
+			}
+		}
+	}
+	return highPass
+}
+
+// Returns the stripe indices of a high-pass filtered grid for stripes along
+// rows and along columns, measured over the largest centred power-of-two
+// window, of at most 1024 x 1024 cells. The index is the share of the
+// spectral power, excluding the mean, that lies along the corresponding axis
+// of the spectrum, relative to the share expected for white noise; it is
+// zero for grids smaller than 16 x 16.
+func stripeIndices(values []float64, rows, columns int) (rowIndex, colIndex float64) {
+	n := 1
+	for n*2 <= rows && n*2 <= columns && n < 1024 {
+		n *= 2
+	}
+	if n < 16 {
+		return 0, 0
+	}
+	r0, c0 := (rows-n)/2, (columns-n)/2
+	data := make([][]complex128, n)
+	for i := range data {
+		data[i] = make([]complex128, n)
+		for j := range data[i] {
+			data[i][j] = complex(values[(r0+i)*columns+c0+j], 0)
+		}
+	}
+	fft2d(data, false)
+	var total, rowPower, colPower float64
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if i == 0 && j == 0 {
+				continue
+			}
+			p := real(data[i][j])*real(data[i][j]) + imag(data[i][j])*imag(data[i][j])
+			total += p
+			if j == 0 {
+				// constant along rows, varying between them
+				rowPower += p
+			} else if i == 0 {
+				colPower += p
+			}
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	// white noise spreads its power evenly over the n*n - 1 frequencies
+	expected := float64(n-1) / float64(n*n-1)
+	return rowPower / total / expected, colPower / total / expected
+}
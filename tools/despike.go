@@ -0,0 +1,325 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Despike replaces spikes and wells in a DEM with the median of their
+// neighbours.
+type Despike struct {
+	inputFile     string
+	outputFile    string
+	threshold     float64
+	numIterations int
+	toolManager   *PluginToolManager
+}
+
+func (this *Despike) GetName() string {
+	s := "Despike"
+	return getFormattedToolName(s)
+}
+
+func (this *Despike) GetDescription() string {
+	s := "Replaces spikes and wells with the neighbour median"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Despike) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Despike) GetHelpDocumentation() string {
+	ret := "This tool removes spikes and wells, i.e. single cells or small clusters of cells " +
+		"that are much higher or lower than their surroundings, from a DEM. A cell is a spike " +
+		"(or well) if it is higher (or lower) than the median of its valid 8 neighbours by " +
+		"more than the Threshold (default 5, in z units), the same test as is used by the " +
+		"DEMQualityReport tool, and it is replaced by that median. All cells are tested " +
+		"against the values of the previous iteration. A cluster of a few spike cells may " +
+		"need several Iterations (default 1) to be removed, since the cells of the cluster " +
+		"support one another; the tool stops early once an iteration replaces no cells. " +
+		"Cells with fewer than four valid neighbours, and nodata cells, are unchanged."
+	return ret
+}
+
+func (this *Despike) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Despike) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Threshold"
+	ret[2].Type = "float64"
+	ret[2].Description = "Spike and well threshold, in z units"
+	ret[2].Default = "5"
+
+	ret[3].Name = "Iterations"
+	ret[3].Type = "int"
+	ret[3].Description = "The maximum number of iterations"
+	ret[3].Default = "1"
+
+	return ret
+}
+
+func (this *Despike) EstimateMemory(rows, columns int) int64 {
+	// the DEM, the output and two grids of the current values
+	return gridBytes(rows, columns, 4*rasterBytesPerCell)
+}
+
+func (this *Despike) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.threshold = 5.0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if this.threshold, err = parsePositiveFloat(args[2], "threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.numIterations = 1
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setIterations(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Despike) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the threshold
+	this.threshold = 5.0
+	print("Spike threshold, in z units (blank for 5): ")
+	thresholdStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(thresholdStr)) > 0 {
+		if this.threshold, err = parsePositiveFloat(thresholdStr, "threshold"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the number of iterations
+	this.numIterations = 1
+	print("Maximum number of iterations (blank for 1): ")
+	iterStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(iterStr)) > 0 {
+		if !this.setIterations(iterStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Despike) setIterations(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 1 {
+		println("The number of iterations must be at least 1.")
+		return false
+	}
+	this.numIterations = v
+	return true
+}
+
+func (this *Despike) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+	data, err := dem.Data()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	// a copy, since the DEM's values are needed to measure the changes
+	z := append([]float64(nil), data...)
+
+	start2 := time.Now()
+
+	// the values of the previous iteration
+	prev := make([]float64, len(z))
+	prevValue := func(row, col int) float64 {
+		if row < 0 || row >= rows || col < 0 || col >= columns {
+			return nodata
+		}
+		return prev[row*columns+col]
+	}
+	neighbours := make([]float64, 0, 8)
+	replaced := make([]bool, len(z))
+	numReplaced := 0
+	maxChange := 0.0
+	for iter := 1; iter <= this.numIterations; iter++ {
+		copy(prev, z)
+		numChanged := 0
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				i := row*columns + col
+				if prev[i] == nodata {
+					continue
+				}
+				median, ok := neighbourMedian(prevValue, nodata, row, col, neighbours)
+				if !ok || math.Abs(prev[i]-median) <= this.threshold {
+					continue
+				}
+				if d := math.Abs(dem.Value(row, col) - median); d > maxChange {
+					maxChange = d
+				}
+				z[i] = median
+				numChanged++
+				if !replaced[i] {
+					replaced[i] = true
+					numReplaced++
+				}
+			}
+		}
+		printf("Iteration %v: %v cells replaced\n", iter, numChanged)
+		if numChanged == 0 {
+			break
+		}
+	}
+
+	// output the data
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			rout.SetValue(row, col, z[row*columns+col])
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Despike tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Threshold: %v", this.threshold))
+	rout.AddMetadataEntry(fmt.Sprintf("Iterations: %v", this.numIterations))
+	rout.Save()
+
+	printf("Number of cells replaced: %v\n", numReplaced)
+	if numReplaced > 0 {
+		printf("Largest change: %v\n", strconv.FormatFloat(maxChange, 'f', -1, 64))
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
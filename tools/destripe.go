@@ -0,0 +1,519 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Destripe removes acquisition stripes that run along the rows or columns
+// of a DEM.
+type Destripe struct {
+	inputFile   string
+	outputFile  string
+	direction   string
+	method      string
+	filterSize  int
+	toolManager *PluginToolManager
+}
+
+func (this *Destripe) GetName() string {
+	s := "Destripe"
+	return getFormattedToolName(s)
+}
+
+func (this *Destripe) GetDescription() string {
+	s := "Removes row or column stripes from a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Destripe) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Destripe) GetHelpDocumentation() string {
+	ret := "This tool removes stripes, i.e. offsets shared by the cells of a row or a column, " +
+		"from a DEM. Stripes are a common artifact of acquisition, e.g. in SRTM and " +
+		"photogrammetric DEMs, and create spurious parallel streams in flow accumulation. " +
+		"The Direction is either 'rows', for stripes running along the rows of the DEM, " +
+		"'columns', or 'auto' (the default), which uses the direction with the larger stripe " +
+		"index, as reported by the DEMQualityReport tool. The 'fft' method (the default) " +
+		"removes the frequencies of the Fourier spectrum along the axis that corresponds to " +
+		"stripes of the given direction, i.e. a notch filter, for wavelengths across the " +
+		"stripes shorter than the FilterSize (default 11 cells); longer wavelengths are kept, " +
+		"since they are mostly real topography. Since these frequencies are the spectrum of " +
+		"the profile of the row (or column) means, the filter is applied to the detrended " +
+		"profile, mirrored at its ends, and the removed part of the profile is subtracted " +
+		"from each row (or column). The 'median' method first removes the topography by subtracting " +
+		"the median of the FilterSize cells across the stripes, and then subtracts the median " +
+		"of the remainder along the stripe, over a window of 4 x FilterSize + 1 cells, from " +
+		"each cell; it is slower than the 'fft' method but follows stripes whose offset " +
+		"varies along their length. Nodata cells are unchanged."
+	return ret
+}
+
+func (this *Destripe) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Destripe) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Direction"
+	ret[2].Type = "string"
+	ret[2].Description = "Stripe direction: rows, columns or auto"
+	ret[2].Default = "auto"
+	ret[2].Choices = []string{"rows", "columns", "auto"}
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "The filter: fft or median"
+	ret[3].Default = "fft"
+	ret[3].Choices = []string{"fft", "median"}
+
+	ret[4].Name = "FilterSize"
+	ret[4].Type = "int"
+	ret[4].Description = "The filter size, in cells"
+	ret[4].Default = "11"
+
+	return ret
+}
+
+func (this *Destripe) EstimateMemory(rows, columns int) int64 {
+	if this.method == "median" {
+		return gridBytes(rows, columns, 4*rasterBytesPerCell)
+	}
+	return gridBytes(rows, columns, 3*rasterBytesPerCell)
+}
+
+func (this *Destripe) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.direction = "auto"
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if !this.setDirection(args[2]) {
+			return
+		}
+	}
+	this.method = "fft"
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setMethod(args[3]) {
+			return
+		}
+	}
+	this.filterSize = 11
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if !this.setFilterSize(args[4]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Destripe) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the direction
+	this.direction = "auto"
+	print("Stripe direction, rows, columns or auto (blank for auto): ")
+	directionStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(directionStr)) > 0 {
+		if !this.setDirection(directionStr) {
+			return
+		}
+	}
+
+	// get the method
+	this.method = "fft"
+	print("Filter method, fft or median (blank for fft): ")
+	methodStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(methodStr)) > 0 {
+		if !this.setMethod(methodStr) {
+			return
+		}
+	}
+
+	// get the filter size
+	this.filterSize = 11
+	print("Filter size, in cells (blank for 11): ")
+	sizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(sizeStr)) > 0 {
+		if !this.setFilterSize(sizeStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Destripe) setDirection(s string) bool {
+	switch d := strings.ToLower(strings.TrimSpace(s)); d {
+	case "rows", "columns", "auto":
+		this.direction = d
+		return true
+	}
+	printf("Unrecognized stripe direction: %s\n", strings.TrimSpace(s))
+	return false
+}
+
+func (this *Destripe) setMethod(s string) bool {
+	switch m := strings.ToLower(strings.TrimSpace(s)); m {
+	case "fft", "median":
+		this.method = m
+		return true
+	}
+	printf("Unrecognized filter method: %s\n", strings.TrimSpace(s))
+	return false
+}
+
+func (this *Destripe) setFilterSize(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 3 {
+		println("The filter size must be at least 3 cells.")
+		return false
+	}
+	this.filterSize = v
+	return true
+}
+
+func (this *Destripe) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	rowIndex, colIndex := stripeIndices(highPassValues(dem), rows, columns)
+	printf("Stripe index: rows %.2f, columns %.2f\n", rowIndex, colIndex)
+	byRow := this.direction == "rows"
+	if this.direction == "auto" {
+		byRow = rowIndex >= colIndex
+		if byRow {
+			println("Removing stripes along the rows")
+		} else {
+			println("Removing stripes along the columns")
+		}
+	}
+
+	// the filters remove stripes along the rows, so column stripes are
+	// removed from the transposed grid
+	numLines, lineLength := rows, columns
+	if !byRow {
+		numLines, lineLength = columns, rows
+	}
+	z := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if byRow {
+				z[row*columns+col] = dem.Value(row, col)
+			} else {
+				z[col*rows+row] = dem.Value(row, col)
+			}
+		}
+	}
+	var filtered []float64
+	if this.method == "median" {
+		filtered = destripeMedian(z, numLines, lineLength, nodata, this.filterSize)
+	} else {
+		filtered = destripeFFT(z, numLines, lineLength, nodata, this.filterSize)
+	}
+
+	// output the data
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	sumSq, n := 0.0, 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			zIn := dem.Value(row, col)
+			if zIn == nodata {
+				continue
+			}
+			zOut := filtered[row*columns+col]
+			if !byRow {
+				zOut = filtered[col*rows+row]
+			}
+			rout.SetValue(row, col, zOut)
+			sumSq += (zOut - zIn) * (zOut - zIn)
+			n++
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Destripe tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Method: %s", this.method))
+	rout.AddMetadataEntry(fmt.Sprintf("Filter size: %v", this.filterSize))
+	rout.Save()
+
+	if n > 0 {
+		printf("RMS change: %v\n", strconv.FormatFloat(math.Sqrt(sumSq/float64(n)), 'g', 6, 64))
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Removes stripes along the rows of a grid with a notch filter, i.e. removes
+// the frequencies of the two-dimensional spectrum that are constant along
+// the rows and have a wavelength across the rows shorter than filterSize.
+// These frequencies are the spectrum of the profile of the row means, so
+// the filter is applied to the profile, which is detrended and mirrored so
+// that its ends do not add spurious short wavelengths, and the removed part
+// of the profile is subtracted from each row.
+func destripeFFT(z []float64, rows, columns int, nodata float64, filterSize int) []float64 {
+	means := make([]float64, rows)
+	valid := make([]bool, rows)
+	for row := 0; row < rows; row++ {
+		s, c := 0.0, 0
+		for col := 0; col < columns; col++ {
+			if v := z[row*columns+col]; v != nodata {
+				s += v
+				c++
+			}
+		}
+		if c > 0 {
+			means[row] = s / float64(c)
+			valid[row] = true
+		}
+	}
+
+	// the least-squares line through the profile
+	var n, sumX, sumY, sumXX, sumXY float64
+	for row := 0; row < rows; row++ {
+		if valid[row] {
+			x := float64(row)
+			n++
+			sumX += x
+			sumY += means[row]
+			sumXX += x * x
+			sumXY += x * means[row]
+		}
+	}
+	offsets := make([]float64, rows)
+	if n == 0 {
+		return append([]float64(nil), z...)
+	}
+	slope := 0.0
+	if d := n*sumXX - sumX*sumX; d != 0 {
+		slope = (n*sumXY - sumX*sumY) / d
+	}
+	intercept := (sumY - slope*sumX) / n
+
+	size := 1
+	for size < 2*rows {
+		size *= 2
+	}
+	data := make([]complex128, size)
+	for i := range data {
+		row := i % (2 * rows)
+		if row >= rows {
+			row = 2*rows - 1 - row
+		}
+		if valid[row] {
+			data[i] = complex(means[row]-intercept-slope*float64(row), 0)
+		}
+	}
+	fft(data, false)
+	minK := int(math.Ceil(float64(size) / float64(filterSize)))
+	for k := 0; k < size; k++ {
+		if k < minK || size-k < minK {
+			data[k] = 0
+		}
+	}
+	fft(data, true)
+	for row := 0; row < rows; row++ {
+		offsets[row] = real(data[row])
+	}
+
+	ret := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if z[i] == nodata {
+				ret[i] = nodata
+			} else {
+				ret[i] = z[i] - offsets[row]
+			}
+		}
+	}
+	return ret
+}
+
+// Removes stripes along the rows of a grid with directional median filters:
+// the residual from the median of filterSize cells across the rows is
+// mostly stripe, and its median along the row is the stripe's offset.
+func destripeMedian(z []float64, rows, columns int, nodata float64, filterSize int) []float64 {
+	half := filterSize / 2
+	residual := make([]float64, rows*columns)
+	window := make([]float64, 0, 4*filterSize+1)
+	median := func(values []float64) float64 {
+		sort.Float64s(values)
+		m := len(values)
+		return (values[(m-1)/2] + values[m/2]) / 2
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z[row*columns+col] == nodata {
+				continue
+			}
+			// the window is kept symmetric near the edges, since the median
+			// of a one-sided window is biased on a slope
+			h := half
+			if row < h {
+				h = row
+			}
+			if rows-1-row < h {
+				h = rows - 1 - row
+			}
+			window = window[:0]
+			for r := row - h; r <= row+h; r++ {
+				if z[r*columns+col] != nodata {
+					window = append(window, z[r*columns+col])
+				}
+			}
+			residual[row*columns+col] = z[row*columns+col] - median(window)
+		}
+	}
+	ret := make([]float64, rows*columns)
+	along := 2 * filterSize
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if z[i] == nodata {
+				ret[i] = nodata
+				continue
+			}
+			window = window[:0]
+			for c := col - along; c <= col+along; c++ {
+				if c >= 0 && c < columns && z[row*columns+c] != nodata {
+					window = append(window, residual[row*columns+c])
+				}
+			}
+			ret[i] = z[i] - median(window)
+		}
+	}
+	return ret
+}
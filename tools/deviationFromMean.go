@@ -38,6 +38,10 @@ func (this *DeviationFromMean) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *DeviationFromMean) GetVersion() string {
+	return "1.0"
+}
+
 func (this *DeviationFromMean) GetHelpDocumentation() string {
 	ret := "This tool is used to perform a fast deviation from local mean filter operation."
 	return ret
@@ -47,28 +51,35 @@ func (this *DeviationFromMean) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *DeviationFromMean) GetArgDescriptions() [][]string {
+func (this *DeviationFromMean) GetArgDescriptions() []ToolArg {
 	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
-	ret[2][0] = "NeighbourhoodSize"
-	ret[2][1] = "int"
-	ret[2][2] = "The radius of the neighbourhood in grid cells"
+	ret[2].Name = "NeighbourhoodSize"
+	ret[2].Type = "int"
+	ret[2].Description = "The radius of the neighbourhood in grid cells"
+	ret[2].Default = "1"
 
 	return ret
 }
 
+func (this *DeviationFromMean) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters and the three integral images
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+3*8)
+}
+
 func (this *DeviationFromMean) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -77,7 +88,7 @@ func (this *DeviationFromMean) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -88,7 +99,7 @@ func (this *DeviationFromMean) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -123,7 +134,7 @@ func (this *DeviationFromMean) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -140,7 +151,7 @@ func (this *DeviationFromMean) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -255,7 +266,7 @@ func (this *DeviationFromMean) Run() {
 
 	fmt.Printf("Performing analysis (2 of 2): %v%%\n", 0)
 
-	numCPUs := runtime.NumCPU()
+	numCPUs := this.toolManager.numThreads()
 	c1 := make(chan bool)
 	runtime.GOMAXPROCS(numCPUs)
 	var wg sync.WaitGroup
@@ -412,7 +423,7 @@ func (this *DeviationFromMean) Run() {
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMean tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMean tool (%s)", this.toolManager.toolVersion(this)))
 	rout.AddMetadataEntry(fmt.Sprintf("Window size: %v", (this.neighbourhoodSize*2 + 1)))
 	config.DisplayMinimum = -2.58
 	config.DisplayMaximum = 2.58
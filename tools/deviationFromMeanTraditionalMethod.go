@@ -42,6 +42,10 @@ func (this *DeviationFromMeanTraditional) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *DeviationFromMeanTraditional) GetVersion() string {
+	return "1.0"
+}
+
 func (this *DeviationFromMeanTraditional) GetHelpDocumentation() string {
 	ret := "This tool is used to perform a deviation from local mean filter operation."
 	return ret
@@ -51,24 +55,26 @@ func (this *DeviationFromMeanTraditional) SetToolManager(tm *PluginToolManager)
 	this.toolManager = tm
 }
 
-func (this *DeviationFromMeanTraditional) GetArgDescriptions() [][]string {
+func (this *DeviationFromMeanTraditional) GetArgDescriptions() []ToolArg {
 	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
-	ret[2][0] = "NeighbourhoodSize"
-	ret[2][1] = "int"
-	ret[2][2] = "The radius of the neighbourhood in grid cells"
+	ret[2].Name = "NeighbourhoodSize"
+	ret[2].Type = "int"
+	ret[2].Description = "The radius of the neighbourhood in grid cells"
+	ret[2].Default = "1"
 
 	return ret
 }
@@ -81,7 +87,7 @@ func (this *DeviationFromMeanTraditional) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -92,7 +98,7 @@ func (this *DeviationFromMeanTraditional) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -127,7 +133,7 @@ func (this *DeviationFromMeanTraditional) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -144,7 +150,7 @@ func (this *DeviationFromMeanTraditional) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -281,7 +287,7 @@ func (this *DeviationFromMeanTraditional) Run() {
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMeanTraditional tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMeanTraditional tool (%s)", this.toolManager.toolVersion(this)))
 	rout.AddMetadataEntry(fmt.Sprintf("Window size: %v", (this.neighbourhoodSize*2 + 1)))
 	config.DisplayMinimum = -2.58
 	config.DisplayMaximum = 2.58
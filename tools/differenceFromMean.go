@@ -38,6 +38,10 @@ func (this *DifferenceFromMean) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *DifferenceFromMean) GetVersion() string {
+	return "1.0"
+}
+
 func (this *DifferenceFromMean) GetHelpDocumentation() string {
 	ret := "This tool is used to perform a fast difference from local mean filter operation."
 	return ret
@@ -47,28 +51,35 @@ func (this *DifferenceFromMean) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *DifferenceFromMean) GetArgDescriptions() [][]string {
+func (this *DifferenceFromMean) GetArgDescriptions() []ToolArg {
 	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
-	ret[2][0] = "NeighbourhoodSize"
-	ret[2][1] = "int"
-	ret[2][2] = "The radius of the neighbourhood in grid cells"
+	ret[2].Name = "NeighbourhoodSize"
+	ret[2].Type = "int"
+	ret[2].Description = "The radius of the neighbourhood in grid cells"
+	ret[2].Default = "1"
 
 	return ret
 }
 
+func (this *DifferenceFromMean) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters and the two integral images
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+2*8)
+}
+
 func (this *DifferenceFromMean) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -77,7 +88,7 @@ func (this *DifferenceFromMean) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -88,7 +99,7 @@ func (this *DifferenceFromMean) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -123,7 +134,7 @@ func (this *DifferenceFromMean) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -140,7 +151,7 @@ func (this *DifferenceFromMean) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -171,6 +182,7 @@ func (this *DifferenceFromMean) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row int
+	var eta progressETA
 	var z, sum float64
 	var sumN int
 
@@ -254,12 +266,13 @@ func (this *DifferenceFromMean) Run() {
 	parallelCode := true
 	if parallelCode {
 
-		numCPUs := runtime.NumCPU()
-		c1 := make(chan bool)
-		c2 := make(chan float64)
-		c3 := make(chan float64)
+		numCPUs := this.toolManager.numThreads()
+		c1 := make(chan bool, rows)
+		c2 := make(chan float64, rows)
+		c3 := make(chan float64, rows)
 		runtime.GOMAXPROCS(numCPUs)
 		var wg sync.WaitGroup
+		defer wg.Wait()
 		startingRow := 0
 		var rowBlockSize int = rows / numCPUs
 
@@ -276,6 +289,12 @@ func (this *DifferenceFromMean) Run() {
 				minVal := math.Inf(1)
 				maxVal := math.Inf(-1)
 				for row := rowSt; row <= rowEnd; row++ {
+					if interrupted() {
+						c1 <- true
+						c2 <- minVal
+						c3 <- maxVal
+						continue
+					}
 					y1 = row - this.neighbourhoodSize - 1
 					if y1 < 0 {
 						y1 = 0
@@ -352,7 +371,7 @@ func (this *DifferenceFromMean) Run() {
 			}
 			progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
 			if progress != oldProgress {
-				printf("\rProgress: %v%%", progress)
+				printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 				oldProgress = progress
 			}
 		}
@@ -427,7 +446,7 @@ func (this *DifferenceFromMean) Run() {
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMean tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by DeviationFromMean tool (%s)", this.toolManager.toolVersion(this)))
 	rout.AddMetadataEntry(fmt.Sprintf("Window size: %v", (this.neighbourhoodSize*2 + 1)))
 	palVal := math.Min(math.Abs(minVal), maxVal)
 	config.DisplayMinimum = -palVal
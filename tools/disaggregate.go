@@ -0,0 +1,374 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Disaggregate resamples a coarse raster onto a finer grid, either that of a
+// template raster or one obtained by subdividing the input cells.
+type Disaggregate struct {
+	inputFile    string
+	outputFile   string
+	templateFile string
+	factor       int
+	method       string
+	toolManager  *PluginToolManager
+}
+
+func (this *Disaggregate) GetName() string {
+	s := "Disaggregate"
+	return getFormattedToolName(s)
+}
+
+func (this *Disaggregate) GetDescription() string {
+	s := "Resamples a raster onto a finer grid"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Disaggregate) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Disaggregate) GetHelpDocumentation() string {
+	ret := "This tool resamples a coarse raster onto a finer grid, the inverse of the Aggregate " +
+		"tool. The Template is either a raster, whose rows, columns, extent and coordinate " +
+		"system are copied exactly, e.g. to resample an SRTM DEM onto a lidar DEM's grid for " +
+		"fusion, or an integer factor by which each input cell is subdivided. The Method is " +
+		"'nearest', 'bilinear' (the default) or 'spline', a bicubic convolution that gives a " +
+		"smoother surface than bilinear interpolation. Near nodata cells, the spline falls back " +
+		"to bilinear interpolation, which ignores nodata cells and renormalizes the weights of " +
+		"the others. Output cells outside the input extent are assigned nodata."
+	return ret
+}
+
+func (this *Disaggregate) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Disaggregate) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Template"
+	ret[2].Type = "string"
+	ret[2].Description = "A template raster defining the output grid, or a subdivision factor"
+	ret[2].Required = true
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "nearest, bilinear or spline"
+	ret[3].Default = "bilinear"
+	ret[3].Choices = []string{"nearest", "bilinear", "spline"}
+
+	return ret
+}
+
+func (this *Disaggregate) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and template must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setTemplate(args[2]) {
+		return
+	}
+
+	this.method = "bilinear"
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setMethod(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Disaggregate) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the template
+	print("Enter the template raster file name or a subdivision factor: ")
+	template, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setTemplate(template) {
+		return
+	}
+
+	// get the method
+	print("Resampling method, nearest, bilinear or spline (blank for bilinear): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.method = "bilinear"
+	if len(strings.TrimSpace(method)) > 0 {
+		if !this.setMethod(method) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Disaggregate) setTemplate(s string) bool {
+	s = strings.TrimSpace(s)
+	this.templateFile = ""
+	this.factor = 0
+	if factor, err := strconv.Atoi(s); err == nil {
+		if factor < 2 {
+			println("The subdivision factor must be at least 2.")
+			return false
+		}
+		this.factor = factor
+		return true
+	}
+	if !strings.Contains(s, pathSep) {
+		s = this.toolManager.workingDirectory + s
+	}
+	if _, err := os.Stat(s); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", s)
+		return false
+	}
+	this.templateFile = s
+	return true
+}
+
+func (this *Disaggregate) setMethod(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "nearest", "bilinear", "spline":
+		this.method = s
+		return true
+	case "cubic", "bicubic":
+		this.method = "spline"
+		return true
+	}
+	println("Unrecognized resampling method; use 'nearest', 'bilinear' or 'spline'.")
+	return false
+}
+
+func (this *Disaggregate) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+
+	// the output grid
+	var rows, columns int
+	var north, south, east, west float64
+	epsg, wkt := inConfig.EPSGCode, inConfig.CoordinateRefSystemWKT
+	if this.templateFile != "" {
+		template, err := raster.CreateRasterFromFile(this.templateFile)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		rows, columns = template.Rows, template.Columns
+		north, south, east, west = template.North, template.South, template.East, template.West
+		tConfig := template.GetRasterConfig()
+		if tConfig.EPSGCode != 0 && epsg != 0 && tConfig.EPSGCode != epsg {
+			printf("Warning: the template (EPSG:%v) and input (EPSG:%v) coordinate systems differ.\n", tConfig.EPSGCode, epsg)
+		}
+		if tConfig.EPSGCode != 0 || tConfig.CoordinateRefSystemWKT != "" {
+			epsg, wkt = tConfig.EPSGCode, tConfig.CoordinateRefSystemWKT
+		}
+	} else {
+		rows, columns = rin.Rows*this.factor, rin.Columns*this.factor
+		north, south, east, west = rin.North, rin.South, rin.East, rin.West
+	}
+
+	start2 := time.Now()
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = wkt
+	config.EPSGCode = epsg
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	cellSizeX := rout.GetCellSizeX()
+	cellSizeY := rout.GetCellSizeY()
+	inCellSizeX := rin.GetCellSizeX()
+	inCellSizeY := rin.GetCellSizeY()
+
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	var z float64
+	for row := 0; row < rows; row++ {
+		y := north - (float64(row)+0.5)*cellSizeY
+		inRow := (rin.North-y)/inCellSizeY - 0.5
+		for col := 0; col < columns; col++ {
+			x := west + (float64(col)+0.5)*cellSizeX
+			inCol := (x-rin.West)/inCellSizeX - 0.5
+			if inRow < -0.5 || inCol < -0.5 || inRow > float64(rin.Rows)-0.5 || inCol > float64(rin.Columns)-0.5 {
+				continue
+			}
+			switch this.method {
+			case "nearest":
+				z = rin.Value(int(math.Floor(inRow+0.5)), int(math.Floor(inCol+0.5)))
+			case "bilinear":
+				z = rin.SampleBilinear(x, y)
+			default:
+				z = bicubicValue(rin, inRow, inCol)
+			}
+			if z != nodata {
+				rout.SetValue(row, col, z)
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Disaggregate tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Resampled from %s using %s interpolation", this.inputFile, this.method))
+	rout.Save()
+
+	printf("Output dimensions: %v rows x %v columns\n", rows, columns)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Interpolates the value at the fractional grid position (row, col) by cubic
+// convolution (Keys, 1981) of the surrounding 4 x 4 cells, falling back to
+// bilinear interpolation where any of them is nodata.
+func bicubicValue(r *raster.Raster, row, col float64) float64 {
+	nodata := r.NoDataValue
+	r0 := int(math.Floor(row))
+	c0 := int(math.Floor(col))
+	dr := row - float64(r0)
+	dc := col - float64(c0)
+	kernel := func(t float64) float64 {
+		// a = -0.5
+		t = math.Abs(t)
+		if t <= 1 {
+			return 1.5*t*t*t - 2.5*t*t + 1
+		} else if t < 2 {
+			return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+		}
+		return 0
+	}
+	sum := 0.0
+	for i := -1; i <= 2; i++ {
+		wr := kernel(float64(i) - dr)
+		for j := -1; j <= 2; j++ {
+			z := r.Value(r0+i, c0+j)
+			if z == nodata {
+				return bilinearValue(r, row, col)
+			}
+			sum += wr * kernel(float64(j)-dc) * z
+		}
+	}
+	return sum
+}
@@ -0,0 +1,402 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// DoD creates a DEM of difference from two co-registered DEMs, removing
+// changes that are smaller than a minimum level of detection, and reports
+// the volumetric change.
+type DoD struct {
+	newFile     string
+	oldFile     string
+	outputFile  string
+	lodValue    float64
+	lodFile     string
+	toolManager *PluginToolManager
+}
+
+func (this *DoD) GetName() string {
+	s := "DoD"
+	return getFormattedToolName(s)
+}
+
+func (this *DoD) GetDescription() string {
+	s := "Thresholded DEM of difference and change volumes"
+	return getFormattedToolDescription(s)
+}
+
+func (this *DoD) GetVersion() string {
+	return "1.0"
+}
+
+func (this *DoD) GetHelpDocumentation() string {
+	ret := "This tool subtracts an earlier DEM from a later DEM of the same area to " +
+		"create a DEM of difference (DoD). Differences with a magnitude smaller than the " +
+		"minimum level of detection (LoD) are set to zero. The LoD may either be a uniform " +
+		"value, in elevation units, or the name of a raster of spatially variable LoD " +
+		"values, e.g. the propagated uncertainty of the two surfaces multiplied by a " +
+		"critical t-value. The areas and volumes of surface lowering and raising, the net " +
+		"volume change, and the volumetric uncertainty of the detected change are reported. " +
+		"The two DEMs should be co-registered beforehand (see CoRegister); where their " +
+		"grids differ, the earlier DEM is resampled (bilinear) onto the grid of the later. " +
+		"The change statistics are also written to the -report file, if there is one."
+	return ret
+}
+
+func (this *DoD) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *DoD) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "NewDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The later DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OldDEM"
+	ret[1].Type = "string"
+	ret[1].Description = "The earlier DEM name, with directory and file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "LoD"
+	ret[3].Type = "string"
+	ret[3].Description = "Level of detection, a value or a raster file"
+	ret[3].Default = "0"
+
+	return ret
+}
+
+func (this *DoD) EstimateMemory(rows, columns int) int64 {
+	// the two DEMs and the output raster
+	return gridBytes(rows, columns, 3*rasterBytesPerCell)
+}
+
+func (this *DoD) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The new DEM, old DEM, and output file must be specified.")
+		return
+	}
+	for i, fileName := range args[0:2] {
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.newFile = fileName
+		} else {
+			this.oldFile = fileName
+		}
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.lodValue = 0.0
+	this.lodFile = ""
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setLoD(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *DoD) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the new (later) DEM file name (incl. file extension): ",
+		"Enter the old (earlier) DEM file name (incl. file extension): "}
+	for i, prompt := range prompts {
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		if i == 0 {
+			this.newFile = fileName
+		} else {
+			this.oldFile = fileName
+		}
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the level of detection
+	print("Level of detection, a value or a raster file name (blank for 0): ")
+	lod, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.lodValue = 0.0
+	this.lodFile = ""
+	if len(strings.TrimSpace(lod)) > 0 {
+		if !this.setLoD(lod) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+// setLoD interprets the level-of-detection argument, which is either a
+// number or the name of an existing raster file.
+func (this *DoD) setLoD(arg string) bool {
+	arg = strings.TrimSpace(arg)
+	if value, err := strconv.ParseFloat(arg, 64); err == nil {
+		this.lodValue = math.Abs(value)
+		return true
+	}
+	if !strings.Contains(arg, pathSep) {
+		arg = this.toolManager.workingDirectory + arg
+	}
+	if _, err := os.Stat(arg); os.IsNotExist(err) {
+		printf("The level of detection is neither a number nor an existing file: %s\n", arg)
+		return false
+	}
+	this.lodFile = arg
+	return true
+}
+
+func (this *DoD) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	newDEM, err := raster.CreateRasterFromFile(this.newFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	oldDEM, err := raster.CreateRasterFromFile(this.oldFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var lodRaster *raster.Raster
+	if this.lodFile != "" {
+		if lodRaster, err = raster.CreateRasterFromFile(this.lodFile); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	start2 := time.Now()
+
+	rows := newDEM.Rows
+	columns := newDEM.Columns
+	nodata := newDEM.NoDataValue
+	inConfig := newDEM.GetRasterConfig()
+	cellSizeX := newDEM.GetCellSizeX()
+	cellSizeY := newDEM.GetCellSizeY()
+	cellArea := cellSizeX * cellSizeY
+
+	if newDEM.IsInGeographicCoordinates() {
+		println("Warning: The DEM appears to be in geographic coordinates. Areas and volumes will be in square degrees.")
+	}
+
+	sameGrid := func(r *raster.Raster) bool {
+		return r.Rows == rows && r.Columns == columns &&
+			r.North == newDEM.North && r.West == newDEM.West &&
+			r.GetCellSizeX() == cellSizeX && r.GetCellSizeY() == cellSizeY
+	}
+	oldOnGrid := sameGrid(oldDEM)
+	lodOnGrid := lodRaster != nil && sameGrid(lodRaster)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "blue_white_red.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		newDEM.North, newDEM.South, newDEM.East, newDEM.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	var numCells, numLowering, numRaising, numBelowLoD int
+	var volLowering, volRaising, volBelowLoD float64
+	var uncertLowering, uncertRaising float64
+	var z1, z2, lod float64
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		y := newDEM.North - (float64(row)+0.5)*cellSizeY
+		for col := 0; col < columns; col++ {
+			z1 = newDEM.Value(row, col)
+			if z1 == nodata {
+				continue
+			}
+			x := newDEM.West + (float64(col)+0.5)*cellSizeX
+			if oldOnGrid {
+				z2 = oldDEM.Value(row, col)
+			} else {
+				z2 = oldDEM.SampleBilinear(x, y)
+			}
+			if z2 == oldDEM.NoDataValue {
+				continue
+			}
+			lod = this.lodValue
+			if lodRaster != nil {
+				if lodOnGrid {
+					lod = lodRaster.Value(row, col)
+				} else {
+					lod = lodRaster.SampleBilinear(x, y)
+				}
+				if lod == lodRaster.NoDataValue {
+					continue
+				}
+				lod = math.Abs(lod)
+			}
+			numCells++
+			dz := z1 - z2
+			if math.Abs(dz) < lod || dz == 0 {
+				numBelowLoD++
+				volBelowLoD += dz * cellArea
+				rout.SetValue(row, col, 0)
+				continue
+			}
+			if dz > 0 {
+				numRaising++
+				volRaising += dz * cellArea
+				uncertRaising += lod * cellArea
+			} else {
+				numLowering++
+				volLowering -= dz * cellArea
+				uncertLowering += lod * cellArea
+			}
+			rout.SetValue(row, col, dz)
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by DoD tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("New DEM: %s", this.newFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Old DEM: %s", this.oldFile))
+	if this.lodFile != "" {
+		rout.AddMetadataEntry(fmt.Sprintf("Level of detection: %s", this.lodFile))
+	} else {
+		rout.AddMetadataEntry(fmt.Sprintf("Level of detection: %v", this.lodValue))
+	}
+	rout.Save()
+
+	percent := func(n int) float64 {
+		if numCells == 0 {
+			return 0
+		}
+		return 100.0 * float64(n) / float64(numCells)
+	}
+	println("\nChange statistics:")
+	printf("Area of overlap: %v (%v cells)\n", float64(numCells)*cellArea, numCells)
+	printf("Area of surface lowering: %v (%.2f%%)\n", float64(numLowering)*cellArea, percent(numLowering))
+	printf("Area of surface raising: %v (%.2f%%)\n", float64(numRaising)*cellArea, percent(numRaising))
+	printf("Area below the level of detection: %v (%.2f%%)\n", float64(numBelowLoD)*cellArea, percent(numBelowLoD))
+	printf("Volume of surface lowering: %v ± %v\n", volLowering, uncertLowering)
+	printf("Volume of surface raising: %v ± %v\n", volRaising, uncertRaising)
+	printf("Net volume change: %v ± %v\n", volRaising-volLowering, uncertRaising+uncertLowering)
+	printf("Net volume change below the level of detection: %v\n", volBelowLoD)
+
+	report, err := this.toolManager.openReport("statistic", "value", "uncertainty", "cells", "percent")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	report.writeRow("area of overlap", float64(numCells)*cellArea, "", numCells, "")
+	report.writeRow("area of surface lowering", float64(numLowering)*cellArea, "", numLowering, percent(numLowering))
+	report.writeRow("area of surface raising", float64(numRaising)*cellArea, "", numRaising, percent(numRaising))
+	report.writeRow("area below the level of detection", float64(numBelowLoD)*cellArea, "", numBelowLoD, percent(numBelowLoD))
+	report.writeRow("volume of surface lowering", volLowering, uncertLowering, "", "")
+	report.writeRow("volume of surface raising", volRaising, uncertRaising, "", "")
+	report.writeRow("net volume change", volRaising-volLowering, uncertRaising+uncertLowering, "", "")
+	report.writeRow("net volume change below the level of detection", volBelowLoD, "", "", "")
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
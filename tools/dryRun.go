@@ -0,0 +1,133 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// DryRun resolves and validates the arguments of a tool, as they would be
+// passed to it by RunWithArguments, and prints the files that the tool would
+// read and write and its estimated peak memory, without running it. Input
+// rasters are read to check that they are valid. All of the problems that
+// are found are returned as a single error.
+func (ptm *PluginToolManager) DryRun(toolName string, args []string) error {
+	toolName = strings.ToLower(getFormattedToolName(toolName))
+	tool, ok := ptm.mapOfPluginTools[toolName]
+	if !ok {
+		return errors.New("Unrecognized tool name. Type 'listtools' for a list of available tools.\n")
+	}
+	tool.SetToolManager(ptm)
+	descs := tool.GetArgDescriptions()
+	args, err := resolveArguments(descs, args)
+	if err != nil {
+		return err
+	}
+
+	printf("Dry run of %s\n", tool.GetName())
+	problems := make([]string, 0)
+	rows, columns := 0, 0
+	start := time.Now()
+	for i, d := range descs {
+		value := args[i]
+		if value == "" {
+			if d.Default != "" {
+				printf("  %s = %s (default)\n", d.Name, d.Default)
+			}
+			continue
+		}
+		if err := checkArgumentType(d, value); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if d.Role == "" {
+			printf("  %s = %s\n", d.Name, value)
+			continue
+		}
+
+		fileName := value
+		if !strings.Contains(fileName, pathSep) {
+			fileName = ptm.workingDirectory + fileName
+		}
+		switch d.Role {
+		case ArgInput:
+			if !raster.FileExists(fileName) {
+				problems = append(problems, fmt.Sprintf("%s: no such file or directory: %s", d.Name, fileName))
+				continue
+			}
+			note := ""
+			info, err := os.Stat(fileName)
+			isDir := err == nil && info.IsDir() // e.g. the input directory of TileIndex
+			if rt, err := raster.DetermineRasterFormat(fileName); !isDir && err == nil && rt != raster.RT_UnknownRaster {
+				r, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{HeaderOnly: true})
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: unable to read %s: %v", d.Name, fileName, err))
+					continue
+				}
+				note = fmt.Sprintf(" (%v rows x %v columns)", r.Rows, r.Columns)
+				if r.Rows*r.Columns > rows*columns {
+					rows, columns = r.Rows, r.Columns
+				}
+			}
+			printf("  %s: reads %s%s\n", d.Name, fileName, note)
+		case ArgOutput, ArgOutputRaster:
+			if d.Role == ArgOutputRaster {
+				rasterType, err := raster.DetermineRasterFormat(fileName)
+				if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+					fileName += raster.DefaultExtension
+				}
+			}
+			if info, err := os.Stat(filepath.Dir(fileName)); err != nil || !info.IsDir() {
+				problems = append(problems, fmt.Sprintf("%s: no such directory: %s", d.Name, filepath.Dir(fileName)))
+				continue
+			}
+			note := ""
+			if _, err := os.Stat(fileName); err == nil {
+				note = " (overwrites the existing file)"
+			}
+			printf("  %s: writes %s%s\n", d.Name, fileName, note)
+		}
+	}
+	if rows > 0 {
+		if mem, err := ptm.EstimateToolMemory(toolName, rows, columns); err == nil {
+			printf("Estimated peak memory: %s\n", FormatBytes(mem))
+			if ptm.MemoryBudget > 0 && mem > ptm.MemoryBudget {
+				problems = append(problems, fmt.Sprintf("The estimated peak memory exceeds the memory budget of %s.", FormatBytes(ptm.MemoryBudget)))
+			}
+		}
+		printf("Time to read the inputs: %v\n", time.Since(start))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	printf("The arguments are valid; the tool was not run.\n")
+	return nil
+}
+
+// checkArgumentType checks that the value of a numeric or boolean argument
+// can be parsed.
+func checkArgumentType(d ToolArg, value string) (err error) {
+	switch d.Type {
+	case "float64":
+		_, err = strconv.ParseFloat(value, 64)
+	case "int", "integer":
+		_, err = strconv.ParseInt(value, 0, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: '%s' is not a valid %s", d.Name, value, d.Type)
+	}
+	return nil
+}
@@ -0,0 +1,654 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// EditDEM applies scripted hydro-enforcement edits to a DEM: setting or
+// offsetting the elevations within a polygon, cutting a ditch with a
+// monotonic gradient along a polyline, burning in 3D breaklines, and
+// smoothing within a mask.
+type EditDEM struct {
+	inputFile    string
+	outputFile   string
+	operation    string
+	geometryFile string
+	value        float64
+	toolManager  *PluginToolManager
+}
+
+var editDEMOperations = []string{"set", "offset", "gradient", "breakline", "smooth"}
+
+func (this *EditDEM) GetName() string {
+	s := "EditDEM"
+	return getFormattedToolName(s)
+}
+
+func (this *EditDEM) GetDescription() string {
+	s := "Edits DEM elevations within polygons, lines or masks"
+	return getFormattedToolDescription(s)
+}
+
+func (this *EditDEM) GetVersion() string {
+	return "1.0"
+}
+
+func (this *EditDEM) GetHelpDocumentation() string {
+	ret := "This tool performs the elevation edits used in hydro-enforcement, so that they " +
+		"can be scripted rather than made in an external GIS. The 'set' operation assigns the " +
+		"Value to all cells whose centres are within a polygon and 'offset' adds the Value to " +
+		"them, e.g. to flatten a lake or raise a road embankment. The 'gradient' operation " +
+		"cuts a ditch along each polyline, drawn from its upstream to its downstream end, by " +
+		"lowering cells where necessary so that elevations decrease by at least the Value " +
+		"(default 0.001) from each cell to the next. The 'breakline' operation burns 3D " +
+		"breaklines, e.g. the crests and toes of embankments or the banks of channels, into " +
+		"the DEM, setting each cell that a line crosses to the elevation of the line, " +
+		"interpolated linearly between its vertices, plus the Value (default 0). The 'smooth' operation replaces the " +
+		"cells of a mask raster (non-zero cells) with the mean of the DEM within a radius of " +
+		"Value cells (default 1). The polygons, polylines and breaklines are read from a " +
+		"shapefile, in the coordinates of the DEM: a cell is within the polygons if its " +
+		"centre is within any of them, outside of their holes; each part of a polyline is " +
+		"a separate line; and breaklines are the parts of the polylines of a PolyLineZ " +
+		"shapefile, with the elevations of their vertices, as used by the TINGridding tool."
+	return ret
+}
+
+func (this *EditDEM) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *EditDEM) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Operation"
+	ret[2].Type = "string"
+	ret[2].Description = "set, offset, gradient, breakline or smooth"
+	ret[2].Required = true
+	ret[2].Choices = editDEMOperations
+
+	ret[3].Name = "GeometryFile"
+	ret[3].Type = "string"
+	ret[3].Description = "Polygon, polyline or breakline shapefile, or mask raster for smooth"
+	ret[3].Role = ArgInput
+	ret[3].Required = true
+
+	ret[4].Name = "Value"
+	ret[4].Type = "float64"
+	ret[4].Description = "Elevation, offset, minimum drop per cell, breakline offset, or smoothing radius"
+
+	return ret
+}
+
+func (this *EditDEM) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters and the edited elevations
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+8)
+}
+
+func (this *EditDEM) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input DEM, output file, operation, and geometry file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setOperation(args[2]) {
+		return
+	}
+
+	geometryFile := strings.TrimSpace(args[3])
+	if !strings.Contains(geometryFile, pathSep) {
+		geometryFile = this.toolManager.workingDirectory + geometryFile
+	}
+	if _, err := os.Stat(geometryFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", geometryFile)
+		return
+	}
+	this.geometryFile = geometryFile
+
+	valueStr := ""
+	if len(args) > 4 && args[4] != "not specified" {
+		valueStr = args[4]
+	}
+	if !this.setValue(valueStr) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *EditDEM) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the operation
+	print("Operation (set, offset, gradient, breakline or smooth): ")
+	operation, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setOperation(operation) {
+		return
+	}
+
+	// get the geometry file name
+	if this.operation == "smooth" {
+		print("Enter the mask raster file name (incl. file extension): ")
+	} else {
+		print("Enter the shapefile name (incl. file extension): ")
+	}
+	geometryFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	geometryFile = strings.TrimSpace(geometryFile)
+	if !strings.Contains(geometryFile, pathSep) {
+		geometryFile = this.toolManager.workingDirectory + geometryFile
+	}
+	if _, err := os.Stat(geometryFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", geometryFile)
+		return
+	}
+	this.geometryFile = geometryFile
+
+	// get the value
+	switch this.operation {
+	case "set":
+		print("Elevation: ")
+	case "offset":
+		print("Offset (z units): ")
+	case "gradient":
+		print("Minimum drop per cell (default 0.001): ")
+	case "breakline":
+		print("Offset added to the breaklines (default 0): ")
+	case "smooth":
+		print("Smoothing radius in cells (default 1): ")
+	}
+	valueStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setValue(valueStr) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *EditDEM) setOperation(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, op := range editDEMOperations {
+		if s == op {
+			this.operation = s
+			return true
+		}
+	}
+	println("Unrecognized operation; use 'set', 'offset', 'gradient', 'breakline' or 'smooth'.")
+	return false
+}
+
+func (this *EditDEM) setValue(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		switch this.operation {
+		case "gradient":
+			this.value = 0.001
+		case "breakline":
+			this.value = 0
+		case "smooth":
+			this.value = 1
+		default:
+			println("A value must be specified for the set and offset operations.")
+			return false
+		}
+		return true
+	}
+	var err error
+	if this.value, err = strconv.ParseFloat(s, 64); err != nil {
+		println(err.Error())
+		return false
+	}
+	if this.operation == "smooth" && this.value < 1 {
+		println("The smoothing radius must be at least one cell.")
+		return false
+	}
+	return true
+}
+
+func (this *EditDEM) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+	cellSizeX := dem.GetCellSizeX()
+	cellSizeY := dem.GetCellSizeY()
+
+	var polygons []vectorPolygon
+	var lines [][][2]float64
+	var breaklines [][][3]float64
+	var mask [][]bool
+	switch this.operation {
+	case "smooth":
+		mask, err = readConstraintGrid(this.geometryFile, dem)
+	case "breakline":
+		breaklines, err = readBreaklines(this.geometryFile)
+	case "gradient":
+		lines, err = readPolylines(this.geometryFile)
+	default:
+		polygons, err = readPolygons(this.geometryFile)
+	}
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	z := make([][]float64, rows)
+	for row := 0; row < rows; row++ {
+		z[row] = make([]float64, columns)
+		for col := 0; col < columns; col++ {
+			z[row][col] = dem.Value(row, col)
+		}
+	}
+
+	numEdited := 0
+	switch this.operation {
+	case "set", "offset":
+		for row := 0; row < rows; row++ {
+			y := dem.North - (float64(row)+0.5)*cellSizeY
+			for col := 0; col < columns; col++ {
+				x := dem.West + (float64(col)+0.5)*cellSizeX
+				if z[row][col] == nodata || !anyPolygonContains(polygons, x, y) {
+					continue
+				}
+				if this.operation == "set" {
+					z[row][col] = this.value
+				} else {
+					z[row][col] += this.value
+				}
+				numEdited++
+			}
+		}
+
+	case "gradient":
+		numCut := 0
+		for _, line := range lines {
+			cells := rasterizePolyline(line, dem)
+			if len(cells) < 2 {
+				continue
+			}
+			numCut++
+			prev := math.Inf(1)
+			for _, cell := range cells {
+				row, col := cell[0], cell[1]
+				if z[row][col] == nodata {
+					continue
+				}
+				if z[row][col] > prev-this.value {
+					z[row][col] = prev - this.value
+					numEdited++
+				}
+				prev = z[row][col]
+			}
+		}
+		if numCut == 0 {
+			println("No polyline crosses at least two cells of the DEM.")
+			return
+		}
+
+	case "breakline":
+		for _, line := range breaklines {
+			cells, zs := rasterizeBreakline(line, dem)
+			for i, cell := range cells {
+				z[cell[0]][cell[1]] = zs[i] + this.value
+				numEdited++
+			}
+		}
+
+	case "smooth":
+		radius := int(this.value)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				if !mask[row+1][col+1] || dem.Value(row, col) == nodata {
+					continue
+				}
+				sum, n := 0.0, 0.0
+				for r := row - radius; r <= row+radius; r++ {
+					for c := col - radius; c <= col+radius; c++ {
+						if zN := dem.Value(r, c); zN != nodata {
+							sum += zN
+							n++
+						}
+					}
+				}
+				z[row][col] = sum / n
+				numEdited++
+			}
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(demConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = demConfig.ZUnits
+	config.XYUnits = demConfig.XYUnits
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z[row][col] != nodata {
+				rout.SetValue(row, col, z[row][col])
+			}
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by EditDEM tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Edit: %s %v using %s", this.operation, this.value, this.geometryFile))
+	rout.Save()
+
+	printf("Number of cells edited: %v\n", numEdited)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// vectorPolygon is a polygon read from a shapefile, as its rings.
+type vectorPolygon [][][2]float64
+
+// contains reports whether (x, y) lies within the polygon, i.e. within an
+// odd number of its rings, so that it is outside of the polygon's holes.
+func (p vectorPolygon) contains(x, y float64) bool {
+	inside := false
+	for _, ring := range p {
+		if pointInPolygon(x, y, ring) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// anyPolygonContains reports whether (x, y) lies within any of the polygons.
+func anyPolygonContains(polygons []vectorPolygon, x, y float64) bool {
+	for _, p := range polygons {
+		if p.contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// readShapes reads the shapes of a shapefile, which must be of one of the
+// shape types.
+func readShapes(fileName string, shapeTypes ...vector.ShapeType) ([]vector.Shape, error) {
+	shp, err := vector.CreateShapefileFromFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	matched := false
+	for _, st := range shapeTypes {
+		matched = matched || shp.ShapeType == st
+	}
+	if !matched {
+		return nil, fmt.Errorf("%s: the shapefile contains %v shapes, not %v shapes.", fileName, shp.ShapeType, shapeTypes[0])
+	}
+	shapes := make([]vector.Shape, 0, shp.NumShapes())
+	for i := 0; i < shp.NumShapes(); i++ {
+		if shape := shp.GetShape(i); len(shape.Parts) > 0 {
+			shapes = append(shapes, shape)
+		}
+	}
+	if len(shapes) == 0 {
+		return nil, fmt.Errorf("%s: the shapefile contains no shapes.", fileName)
+	}
+	return shapes, nil
+}
+
+// readPolygons reads the polygons of a polygon shapefile.
+func readPolygons(fileName string) ([]vectorPolygon, error) {
+	shapes, err := readShapes(fileName, vector.ST_Polygon, vector.ST_PolygonZ, vector.ST_PolygonM)
+	if err != nil {
+		return nil, err
+	}
+	polygons := make([]vectorPolygon, len(shapes))
+	for i, shape := range shapes {
+		for _, part := range shape.Parts {
+			ring := make([][2]float64, len(part))
+			for j, p := range part {
+				ring[j] = [2]float64{p.X, p.Y}
+			}
+			polygons[i] = append(polygons[i], ring)
+		}
+	}
+	return polygons, nil
+}
+
+// readPolylines reads the lines of a polyline shapefile, one per part of
+// each polyline.
+func readPolylines(fileName string) ([][][2]float64, error) {
+	shapes, err := readShapes(fileName, vector.ST_PolyLine, vector.ST_PolyLineZ, vector.ST_PolyLineM)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([][][2]float64, 0)
+	for _, shape := range shapes {
+		for _, part := range shape.Parts {
+			line := make([][2]float64, len(part))
+			for j, p := range part {
+				line[j] = [2]float64{p.X, p.Y}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// readBreaklines reads 3D breaklines from a PolyLineZ shapefile, one per part
+// of each polyline, with the elevations of their vertices.
+func readBreaklines(fileName string) ([][][3]float64, error) {
+	shapes, err := readShapes(fileName, vector.ST_PolyLineZ)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([][][3]float64, 0)
+	for _, shape := range shapes {
+		for _, part := range shape.Parts {
+			line := make([][3]float64, len(part))
+			for j, p := range part {
+				line[j] = [3]float64{p.X, p.Y, p.Z}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// pointInPolygon reports whether (x, y) lies within the polygon, using the
+// even-odd rule. The polygon need not be closed.
+func pointInPolygon(x, y float64, polygon [][2]float64) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := 0; i < len(polygon); i++ {
+		xi, yi := polygon[i][0], polygon[i][1]
+		xj, yj := polygon[j][0], polygon[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// rasterizePolyline returns the row and column of each grid cell crossed by
+// the polyline, in order from its first vertex to its last, without repeats
+// of consecutive cells. Parts of the line outside the grid are skipped.
+func rasterizePolyline(vertices [][2]float64, r *raster.Raster) [][2]int {
+	cellSizeX := r.GetCellSizeX()
+	cellSizeY := r.GetCellSizeY()
+	step := math.Min(cellSizeX, cellSizeY) / 4.0
+	cells := make([][2]int, 0)
+	lastRow, lastCol := -1, -1
+	for i := 1; i < len(vertices); i++ {
+		x0, y0 := vertices[i-1][0], vertices[i-1][1]
+		x1, y1 := vertices[i][0], vertices[i][1]
+		n := int(math.Ceil(math.Hypot(x1-x0, y1-y0)/step)) + 1
+		for k := 0; k <= n; k++ {
+			t := float64(k) / float64(n)
+			x := x0 + t*(x1-x0)
+			y := y0 + t*(y1-y0)
+			row := int(math.Floor((r.North - y) / cellSizeY))
+			col := int(math.Floor((x - r.West) / cellSizeX))
+			if row < 0 || row >= r.Rows || col < 0 || col >= r.Columns {
+				continue
+			}
+			if row != lastRow || col != lastCol {
+				cells = append(cells, [2]int{row, col})
+				lastRow, lastCol = row, col
+			}
+		}
+	}
+	return cells
+}
+
+// rasterizeBreakline returns the row and column of each grid cell crossed by
+// a 3D breakline, as rasterizePolyline does, and the elevation of the line
+// at each, interpolated linearly between the vertices at the point of the
+// line nearest the cell's centre.
+func rasterizeBreakline(line [][3]float64, r *raster.Raster) ([][2]int, []float64) {
+	cellSizeX := r.GetCellSizeX()
+	cellSizeY := r.GetCellSizeY()
+	step := math.Min(cellSizeX, cellSizeY) / 4.0
+	cells := make([][2]int, 0)
+	zs := make([]float64, 0)
+	lastRow, lastCol := -1, -1
+	for i := 1; i < len(line); i++ {
+		x0, y0, z0 := line[i-1][0], line[i-1][1], line[i-1][2]
+		dx, dy, dz := line[i][0]-x0, line[i][1]-y0, line[i][2]-z0
+		length2 := dx*dx + dy*dy
+		n := int(math.Ceil(math.Sqrt(length2)/step)) + 1
+		for k := 0; k <= n; k++ {
+			t := float64(k) / float64(n)
+			row := int(math.Floor((r.North - (y0 + t*dy)) / cellSizeY))
+			col := int(math.Floor((x0 + t*dx - r.West) / cellSizeX))
+			if row < 0 || row >= r.Rows || col < 0 || col >= r.Columns {
+				continue
+			}
+			if row == lastRow && col == lastCol {
+				continue
+			}
+			lastRow, lastCol = row, col
+			// the position along the segment nearest the cell's centre
+			if length2 > 0 {
+				x := r.West + (float64(col)+0.5)*cellSizeX
+				y := r.North - (float64(row)+0.5)*cellSizeY
+				t = math.Max(0, math.Min(1, ((x-x0)*dx+(y-y0)*dy)/length2))
+			}
+			cells = append(cells, [2]int{row, col})
+			zs = append(zs, z0+t*dz)
+		}
+	}
+	return cells, zs
+}
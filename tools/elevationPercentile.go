@@ -12,13 +12,19 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
 )
 
+// The maximum size of the integral histogram of a block of rows. It is a
+// variable so that tests can divide small rasters into several blocks.
+var elevationPercentileBlockBytes = 256 * 1024 * 1024
+
 type ElevationPercentile struct {
 	inputFile         string
 	outputFile        string
@@ -37,6 +43,10 @@ func (this *ElevationPercentile) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *ElevationPercentile) GetVersion() string {
+	return "1.0"
+}
+
 func (this *ElevationPercentile) GetHelpDocumentation() string {
 	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using an efficient depression filling method. Note that the BreachDepressions tool is the preferred method of creating a depressionless DEM."
 	return ret
@@ -46,32 +56,49 @@ func (this *ElevationPercentile) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *ElevationPercentile) GetArgDescriptions() [][]string {
+func (this *ElevationPercentile) GetArgDescriptions() []ToolArg {
 	numArgs := 4
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
-
-	ret[2][0] = "NeighbourhoodSize"
-	ret[2][1] = "int"
-	ret[2][2] = "The radius of the neighbourhood in grid cells"
-
-	ret[3][0] = "NumBins"
-	ret[3][1] = "int"
-	ret[3][2] = "The number of bins used to calculate the histogram"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "NeighbourhoodSize"
+	ret[2].Type = "int"
+	ret[2].Description = "The radius of the neighbourhood in grid cells"
+	ret[2].Default = "1"
+
+	ret[3].Name = "NumBins"
+	ret[3].Type = "int"
+	ret[3].Description = "The number of bins used to calculate the histogram"
+	ret[3].Default = "1"
 
 	return ret
 }
 
+func (this *ElevationPercentile) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters and the integral histogram, which is
+	// calculated in blocks of rows when it is large
+	numBins := int(this.numBins)
+	if numBins < 1 {
+		numBins = 1
+	}
+	histoBytes := gridBytes(rows, columns, 4*numBins)
+	if histoBytes > int64(elevationPercentileBlockBytes) {
+		histoBytes = int64(elevationPercentileBlockBytes)
+	}
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) + histoBytes
+}
+
 func (this *ElevationPercentile) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -80,7 +107,7 @@ func (this *ElevationPercentile) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -91,7 +118,7 @@ func (this *ElevationPercentile) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -135,7 +162,7 @@ func (this *ElevationPercentile) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -152,7 +179,7 @@ func (this *ElevationPercentile) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -196,11 +223,9 @@ func (this *ElevationPercentile) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row int
-	var i, j, bin, highResNumBins uint32
-	var z, percentile float64
-	var N, numLess, binRunningTotal uint32
-	var x1, x2, y1, y2 int
-	var a, b, c, d, e, f, g, rowSum []uint32
+	var i, bin, highResNumBins uint32
+	var z float64
+	var binRunningTotal uint32
 
 	println("Reading raster data...")
 	rin, err := raster.CreateRasterFromFile(this.inputFile)
@@ -293,41 +318,6 @@ func (this *ElevationPercentile) Run() {
 	//		println(binLowerValue[i], binSize[i])
 	//	}
 
-	histoImage := make([][][]uint32, rows)
-
-	oldProgress = -1
-	for row = 0; row < rows; row++ {
-		histoImage[row] = make([][]uint32, columns)
-		rowSum = make([]uint32, this.numBins)
-		for col = 0; col < columns; col++ {
-			z = rin.Value(row, col)
-			if z != nodata {
-				//bin = int(math.Floor((z - minValue) / binSize))
-				i = uint32(math.Floor((z - minValue) / highResBinSize))
-				if i >= highResNumBins {
-					i = highResNumBins - 1
-				}
-				bin = binNumMap[i]
-				rowSum[bin]++
-			}
-			histoImage[row][col] = make([]uint32, this.numBins)
-			if row > 0 {
-				for i = 0; i < this.numBins; i++ {
-					histoImage[row][col][i] = rowSum[i] + histoImage[row-1][col][i]
-				}
-			} else {
-				for i = 0; i < this.numBins; i++ {
-					histoImage[row][col][i] = rowSum[i]
-				}
-			}
-		}
-		progress = int(100.0 * row / rowsLessOne)
-		if progress%5 == 0 && progress != oldProgress {
-			printf("Calculating integral histogram (1 of 2): %v%%\n", progress)
-			oldProgress = progress
-		}
-	}
-
 	// create the output raster
 	config := raster.NewDefaultRasterConfig()
 	config.PreferredPalette = "blue_white_red.plt"
@@ -343,89 +333,184 @@ func (this *ElevationPercentile) Run() {
 		return
 	}
 
-	e = make([]uint32, this.numBins)
-	f = make([]uint32, this.numBins)
-	g = make([]uint32, this.numBins)
+	// the elevation bin of each valid cell
+	binOf := func(z float64) (uint32, uint32) {
+		j := uint32(math.Floor((z - minValue) / highResBinSize))
+		if j >= highResNumBins {
+			j = highResNumBins - 1
+		}
+		return binNumMap[j], j
+	}
 
+	// The integral histogram is calculated for blocks of rows, together with
+	// the rows above and below that the neighbourhoods of the block reach,
+	// so that its memory is bounded. Window sums are differences of integral
+	// values within the block, so an integral that starts at the top of the
+	// block gives the same sums as one that starts at the top of the raster.
+	numBins := int(this.numBins)
+	n := this.neighbourhoodSize
+	rowBytes := columns * numBins * 4
+	blockSize := elevationPercentileBlockBytes/rowBytes - 2*n - 1
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	if blockSize > rows {
+		blockSize = rows
+	}
+	numBlocks := (rows + blockSize - 1) / blockSize
+	if numBlocks > 1 {
+		printf("Processing the raster in %v blocks of %v rows\n", numBlocks, blockSize)
+	}
+
+	numCPUs := this.toolManager.numThreads()
+	runtime.GOMAXPROCS(numCPUs)
+	var histoImage []uint32
 	oldProgress = -1
-	for row = 0; row < rows; row++ {
-		y1 = row - this.neighbourhoodSize - 1
-		if y1 < 0 {
-			y1 = 0
+	rowsCompleted := 0
+	for blockStart := 0; blockStart < rows; blockStart += blockSize {
+		blockEnd := blockStart + blockSize - 1
+		if blockEnd >= rows {
+			blockEnd = rows - 1
 		}
-		if y1 >= rows {
-			y1 = rows - 1
+		top := blockStart - n - 1
+		if top < 0 {
+			top = 0
 		}
-
-		y2 = row + this.neighbourhoodSize
-		if y2 < 0 {
-			y2 = 0
+		bottom := blockEnd + n
+		if bottom >= rows {
+			bottom = rows - 1
 		}
-		if y2 >= rows {
-			y2 = rows - 1
+		numIntegralRows := bottom - top + 1
+		if cap(histoImage) < numIntegralRows*columns*numBins {
+			histoImage = make([]uint32, numIntegralRows*columns*numBins)
+		}
+		histoImage = histoImage[:numIntegralRows*columns*numBins]
+		for i := range histoImage {
+			histoImage[i] = 0
+		}
+		index := func(row, col int) int {
+			return ((row-top)*columns + col) * numBins
 		}
-		for col = 0; col < columns; col++ {
-			z = rin.Value(row, col)
-			if z != nodata {
-				//bin = int(math.Floor((z - minValue) / binSize))
-				j = uint32(math.Floor((z - minValue) / highResBinSize))
-				if j >= highResNumBins {
-					j = highResNumBins - 1
-				}
-				bin = binNumMap[j]
-
-				x1 = col - this.neighbourhoodSize - 1
-				if x1 < 0 {
-					x1 = 0
-				}
-				if x1 >= columns {
-					x1 = columns - 1
-				}
-
-				x2 = col + this.neighbourhoodSize
-				if x2 < 0 {
-					x2 = 0
-				}
-				if x2 >= columns {
-					x2 = columns - 1
-				}
-
-				a = histoImage[y2][x2]
-				b = histoImage[y1][x1]
-				c = histoImage[y1][x2]
-				d = histoImage[y2][x1]
 
-				for i = 0; i < this.numBins; i++ {
-					e[i] = a[i] + b[i]
-				}
-				for i = 0; i < this.numBins; i++ {
-					f[i] = e[i] - c[i]
+		// cumulative counts along each row, then down each column
+		var wg sync.WaitGroup
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			wg.Add(1)
+			go func(cpu int) {
+				defer wg.Done()
+				for row := top + cpu; row <= bottom; row += numCPUs {
+					k := index(row, 0)
+					for col := 0; col < columns; col++ {
+						if col > 0 {
+							copy(histoImage[k:k+numBins], histoImage[k-numBins:k])
+						}
+						if z := rin.Value(row, col); z != nodata {
+							bin, _ := binOf(z)
+							histoImage[k+int(bin)]++
+						}
+						k += numBins
+					}
 				}
-				for i = 0; i < this.numBins; i++ {
-					g[i] = f[i] - d[i]
+			}(cpu)
+		}
+		wg.Wait()
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			wg.Add(1)
+			go func(cpu int) {
+				defer wg.Done()
+				for col := cpu; col < columns; col += numCPUs {
+					for row := top + 1; row <= bottom; row++ {
+						k := index(row, col)
+						kAbove := index(row-1, col)
+						for i := 0; i < numBins; i++ {
+							histoImage[k+i] += histoImage[kAbove+i]
+						}
+					}
 				}
+			}(cpu)
+		}
+		wg.Wait()
+
+		// the percentile of each cell in the block within its neighbourhood
+		c1 := make(chan bool)
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			go func(cpu int) {
+				var x1, x2, y1, y2 int
+				var N, numLess uint32
+				g := make([]uint32, numBins)
+				for row := blockStart + cpu; row <= blockEnd; row += numCPUs {
+					y1 = row - n - 1
+					if y1 < 0 {
+						y1 = 0
+					}
+					if y1 >= rows {
+						y1 = rows - 1
+					}
 
-				N = 0
-				numLess = 0
-				for i = 0; i < this.numBins; i++ {
-					N += g[i]
-					if i < bin {
-						numLess += g[i]
+					y2 = row + n
+					if y2 < 0 {
+						y2 = 0
+					}
+					if y2 >= rows {
+						y2 = rows - 1
 					}
+					for col := 0; col < columns; col++ {
+						z := rin.Value(row, col)
+						if z != nodata {
+							bin, j := binOf(z)
+
+							x1 = col - n - 1
+							if x1 < 0 {
+								x1 = 0
+							}
+							if x1 >= columns {
+								x1 = columns - 1
+							}
+
+							x2 = col + n
+							if x2 < 0 {
+								x2 = 0
+							}
+							if x2 >= columns {
+								x2 = columns - 1
+							}
+
+							a := histoImage[index(y2, x2):]
+							b := histoImage[index(y1, x1):]
+							c := histoImage[index(y1, x2):]
+							d := histoImage[index(y2, x1):]
+
+							N = 0
+							numLess = 0
+							for i := 0; i < numBins; i++ {
+								g[i] = a[i] + b[i] - c[i] - d[i]
+								N += g[i]
+								if uint32(i) < bin {
+									numLess += g[i]
+								}
+							}
+
+							if N > 0 {
+								percentile := 100.0 * (float64(numLess) + valProbMap[j]*float64(g[bin])) / float64(N)
+								rout.SetValue(row, col, percentile)
+							}
+						}
+					}
+					c1 <- true // row completed
 				}
+			}(cpu)
+		}
 
-				if N > 0 {
-					//percentile = 100.0 * float64(g[bin]) / float64(N) // only used for accuracy assessment
-					percentile = 100.0 * (float64(numLess) + valProbMap[j]*float64(g[bin])) / float64(N)
-					//percentile = 100.0 * (float64(numLess) + (z-binLowerValue[bin])/binSize[bin]*float64(g[bin])) / float64(N)
-					rout.SetValue(row, col, percentile)
-				}
+		for r := blockStart; r <= blockEnd; r++ {
+			<-c1 // a row has successfully completed
+			rowsCompleted++
+			if rowsLessOne > 0 {
+				progress = int(100.0 * float64(rowsCompleted-1) / float64(rowsLessOne))
+			}
+			if progress%5 == 0 && progress != oldProgress {
+				printf("Performing analysis: %v%%\n", progress)
+				oldProgress = progress
 			}
-		}
-		progress = int(100.0 * row / rowsLessOne)
-		if progress%5 == 0 && progress != oldProgress {
-			printf("Performing analysis (2 of 2): %v%%\n", progress)
-			oldProgress = progress
 		}
 	}
 
@@ -434,7 +519,7 @@ func (this *ElevationPercentile) Run() {
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by ElevationPercentile tool"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ElevationPercentile tool (%s)", this.toolManager.toolVersion(this)))
 	rout.AddMetadataEntry(fmt.Sprintf("Window size: %v", (this.neighbourhoodSize*2 + 1)))
 	rout.AddMetadataEntry(fmt.Sprintf("Num. histogram bins: %v", this.numBins))
 	config.DisplayMinimum = 0
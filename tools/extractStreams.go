@@ -0,0 +1,371 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ExtractStreams maps the stream network of a flow accumulation raster, i.e.
+// the cells whose accumulation is at least a channel initiation threshold,
+// thinned to lines one cell wide.
+type ExtractStreams struct {
+	inputFile   string
+	outputFile  string
+	threshold   float64
+	thin        bool
+	toolManager *PluginToolManager
+}
+
+func (this *ExtractStreams) GetName() string {
+	s := "ExtractStreams"
+	return getFormattedToolName(s)
+}
+
+func (this *ExtractStreams) GetDescription() string {
+	s := "Extracts streams from a flow accumulation raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ExtractStreams) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ExtractStreams) GetHelpDocumentation() string {
+	ret := "This tool maps a stream network from a flow accumulation raster, e.g. the " +
+		"output of D8FlowAccumulation or FD8FlowAccum, as the cells whose accumulation is " +
+		"at least the channel initiation Threshold, in the units of the accumulation. The " +
+		"streams of dispersive accumulations such as FD8 are several cells wide, as are D8 " +
+		"streams where parallel flowpaths, e.g. across flats, exceed the threshold side by " +
+		"side, so the network is thinned to lines one cell wide, preserving its connections, " +
+		"junctions and channel heads; streams already one cell wide are left as they are. " +
+		"Set Thin to false to keep the cells as thresholded. Stream cells are assigned 1, " +
+		"other cells 0, and nodata cells of the accumulation nodata. The network can be " +
+		"written as polylines with Strahler orders by RasterStreamsToVector."
+	return ret
+}
+
+func (this *ExtractStreams) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ExtractStreams) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The flow accumulation raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output streams filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Threshold"
+	ret[2].Type = "float64"
+	ret[2].Description = "The channel initiation threshold, in units of accumulation"
+	ret[2].Required = true
+
+	ret[3].Name = "Thin"
+	ret[3].Type = "bool"
+	ret[3].Description = "Thin the streams to lines one cell wide"
+	ret[3].Default = "true"
+
+	return ret
+}
+
+func (this *ExtractStreams) EstimateMemory(rows, columns int) int64 {
+	// the accumulation and output rasters and the stream cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+1)
+}
+
+func (this *ExtractStreams) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and threshold must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+	if !this.setThreshold(args[2]) {
+		return
+	}
+
+	this.thin = true
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		var err error
+		if this.thin, err = strconv.ParseBool(strings.TrimSpace(args[3])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ExtractStreams) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the flow accumulation file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the threshold
+	print("Channel initiation threshold: ")
+	thresholdStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setThreshold(thresholdStr) {
+		return
+	}
+
+	// thin the streams?
+	print("Thin the streams to lines one cell wide (T or F, blank for T)? ")
+	thinStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.thin = true
+	if len(strings.TrimSpace(thinStr)) > 0 {
+		if this.thin, err = strconv.ParseBool(strings.TrimSpace(thinStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ExtractStreams) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *ExtractStreams) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *ExtractStreams) setThreshold(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The threshold must be greater than zero.")
+		return false
+	}
+	this.threshold = v
+	return true
+}
+
+func (this *ExtractStreams) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading raster data...")
+	accum, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := accum.Rows
+	columns := accum.Columns
+	nodata := accum.NoDataValue
+	inConfig := accum.GetRasterConfig()
+
+	start2 := time.Now()
+
+	stream := make([]bool, rows*columns)
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z := accum.Value(row, col); z != nodata && z >= this.threshold {
+				stream[row*columns+col] = true
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 1 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	removed := 0
+	if this.thin {
+		removed = thinCells(stream, rows, columns)
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_INT16
+	config.NoDataValue = -32768
+	config.InitialValue = 0
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		accum.North, accum.South, accum.East, accum.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	numStreamCells := 0
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if accum.Value(row, col) == nodata {
+				rout.SetValue(row, col, config.NoDataValue)
+			} else if stream[row*columns+col] {
+				rout.SetValue(row, col, 1)
+				numStreamCells++
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 2 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ExtractStreams tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Threshold: %v", this.threshold))
+	rout.AddMetadataEntry(fmt.Sprintf("Thinned: %v", this.thin))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Stream cells: %v", numStreamCells)
+	if this.thin {
+		printf(" (%v removed by thinning)", removed)
+	}
+	println("")
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// thinCells thins the true cells of a rows x columns grid to lines one cell
+// wide by the Zhang-Suen method, which repeatedly removes the cells on the
+// boundaries of a region, alternately from its south-east and north-west
+// sides, that are neither needed to keep their neighbours connected nor the
+// ends of lines. Unlike the original method, a cell with only two neighbours
+// is always kept, so that the heads of lines aren't eroded where they turn,
+// and lines that are already one cell wide are left as they are. It returns
+// the number of cells removed.
+func thinCells(cells []bool, rows, columns int) int {
+	at := func(row, col int) bool {
+		return row >= 0 && row < rows && col >= 0 && col < columns && cells[row*columns+col]
+	}
+	// the neighbours clockwise from the north
+	dy := [8]int{-1, -1, 0, 1, 1, 1, 0, -1}
+	dx := [8]int{0, 1, 1, 1, 0, -1, -1, -1}
+	removed := 0
+	remove := make([]int, 0)
+	for changed := true; changed; {
+		changed = false
+		for step := 0; step < 2; step++ {
+			remove = remove[:0]
+			for row := 0; row < rows; row++ {
+				for col := 0; col < columns; col++ {
+					if !cells[row*columns+col] {
+						continue
+					}
+					var p [8]bool
+					count := 0
+					for n := 0; n < 8; n++ {
+						p[n] = at(row+dy[n], col+dx[n])
+						if p[n] {
+							count++
+						}
+					}
+					if count < 3 || count > 6 {
+						continue
+					}
+					transitions := 0
+					for n := 0; n < 8; n++ {
+						if !p[n] && p[(n+1)%8] {
+							transitions++
+						}
+					}
+					if transitions != 1 {
+						continue
+					}
+					// p[0], p[2], p[4] and p[6] are the N, E, S and W neighbours
+					if step == 0 && (p[0] && p[2] && p[4] || p[2] && p[4] && p[6]) {
+						continue
+					}
+					if step == 1 && (p[0] && p[2] && p[6] || p[0] && p[4] && p[6]) {
+						continue
+					}
+					remove = append(remove, row*columns+col)
+				}
+			}
+			for _, i := range remove {
+				cells[i] = false
+			}
+			removed += len(remove)
+			if len(remove) > 0 {
+				changed = true
+			}
+		}
+	}
+	return removed
+}
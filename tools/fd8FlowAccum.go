@@ -26,8 +26,10 @@ type FD8FlowAccum struct {
 	inputFile   string
 	outputFile  string
 	lnTransform bool
+	logMethod   logTransform
 	power       float32
 	parallel    bool
+	weightFile  string
 	toolManager *PluginToolManager
 }
 
@@ -41,8 +43,18 @@ func (this *FD8FlowAccum) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *FD8FlowAccum) GetVersion() string {
+	return "1.0"
+}
+
 func (this *FD8FlowAccum) GetHelpDocumentation() string {
-	ret := "This tool calculates a FD8 flow accumulation raster from a digital elevation model (DEM)."
+	ret := "This tool calculates a FD8 flow accumulation raster from a digital elevation model (DEM). " +
+		"If LogTransform is true, the output is log-transformed using the LogMethod, either 'ln', the " +
+		"natural logarithm, or 'ln1p', the natural logarithm of one plus the value, which is zero " +
+		"rather than undefined at zero. Cells at which the transform is undefined are assigned " +
+		"nodata and are counted. If a WeightTable is specified, each cell contributes a weighted " +
+		"amount of flow rather than one cell, interpolated from the table by the cell's aspect " +
+		"or elevation, as by D8FlowAccumulation."
 	return ret
 }
 
@@ -50,32 +62,52 @@ func (this *FD8FlowAccum) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *FD8FlowAccum) GetArgDescriptions() [][]string {
-	numArgs := 4
-
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
-
-	ret[2][0] = "LogTransform"
-	ret[2][1] = "bool"
-	ret[2][2] = "Log transform the output?"
-
-	ret[3][0] = "PerformParallel"
-	ret[3][1] = "bool"
-	ret[3][2] = "Perform the analysis in parallel?"
+func (this *FD8FlowAccum) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "LogTransform"
+	ret[2].Type = "bool"
+	ret[2].Description = "Log transform the output?"
+	ret[2].Default = "false"
+
+	ret[3].Name = "PerformParallel"
+	ret[3].Type = "bool"
+	ret[3].Description = "Perform the analysis in parallel?"
+	ret[3].Default = "false"
+
+	ret[4].Name = "LogMethod"
+	ret[4].Type = "string"
+	ret[4].Description = "The log transform, 'ln' or 'ln1p' (i.e. ln(1 + x))"
+	ret[4].Default = "ln"
+	ret[4].Choices = []string{"ln", "ln1p"}
+
+	ret[5].Name = "WeightTable"
+	ret[5].Type = "string"
+	ret[5].Description = "The optional table of flow weights by aspect or elevation"
+	ret[5].Role = ArgInput
 
 	return ret
 }
 
+func (this *FD8FlowAccum) EstimateMemory(rows, columns int) int64 {
+	// the compact DEM grid, the output raster, the inflowing neighbour grid,
+	// the accumulated values and the optional weights
+	return gridBytes(rows, columns, 4+rasterBytesPerCell+1+8+8)
+}
+
 func (this *FD8FlowAccum) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -84,7 +116,7 @@ func (this *FD8FlowAccum) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -95,7 +127,7 @@ func (this *FD8FlowAccum) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -120,6 +152,21 @@ func (this *FD8FlowAccum) ParseArguments(args []string) {
 	} else {
 		this.parallel = false
 	}
+
+	this.logMethod = lnTransform
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.logMethod, err = parseLogTransform(args[4]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.weightFile = ""
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if !this.setWeightFile(args[5]) {
+			return
+		}
+	}
 	this.Run()
 }
 
@@ -138,7 +185,7 @@ func (this *FD8FlowAccum) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -155,7 +202,7 @@ func (this *FD8FlowAccum) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -176,6 +223,22 @@ func (this *FD8FlowAccum) CollectArguments() {
 		this.lnTransform = false
 	}
 
+	// get the log transform method
+	this.logMethod = lnTransform
+	if this.lnTransform {
+		print("Log transform, 'ln' or 'ln1p' (blank for ln): ")
+		logMethod, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(logMethod)) > 0 {
+			if this.logMethod, err = parseLogTransform(logMethod); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
 	// get the perform parallel argument
 	print("Perform in parallel (T or F)? ")
 	parallelStr, err := consolereader.ReadString('\n')
@@ -193,28 +256,76 @@ func (this *FD8FlowAccum) CollectArguments() {
 		this.parallel = false
 	}
 
+	// get the weight table
+	print("Enter the flow weight table file name (blank for none): ")
+	weightFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.weightFile = ""
+	if len(strings.TrimSpace(weightFile)) > 0 && !this.setWeightFile(weightFile) {
+		return
+	}
+
 	this.Run()
 }
 
+// setWeightFile sets the optional table of flow weights.
+func (this *FD8FlowAccum) setWeightFile(fileName string) bool {
+	fileName = strings.TrimSpace(fileName)
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", fileName)
+		return false
+	}
+	this.weightFile = fileName
+	return true
+}
+
 func (this *FD8FlowAccum) Run() {
 	start1 := time.Now()
 
 	//var z, zN float64
 	var progress, oldProgress int
+	var eta progressETA
 	var col, row int
 	//power := 2.0
 
+	// the flow contributed by each cell, whose aspects are calculated from a
+	// Raster that is released before the DEM is read compactly
+	weightAt := func(row, col int) float64 { return 1.0 }
+	var weights *weightTable
+	var err error
+	if this.weightFile != "" {
+		if weights, err = readWeightTable(this.weightFile); err != nil {
+			println(err.Error())
+			return
+		}
+		println("Calculating flow weights...")
+		r, err := raster.CreateRasterFromFile(this.inputFile)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		cellWeights := weights.cellWeights(r)
+		columns := r.Columns
+		weightAt = func(row, col int) float64 { return cellWeights[row*columns+col] }
+	}
+
 	println("Reading DEM data...")
-	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	dem, err := readDEMGrid(this.inputFile)
 	if err != nil {
 		println(err.Error())
+		return
 	}
 	rows := dem.Rows
 	columns := dem.Columns
 	nodata := dem.NoDataValue
 	println("Calculating pointer grid...")
 
-	numCPUs := runtime.NumCPU()
+	numCPUs := this.toolManager.numThreads()
 
 	if numCPUs > 1 && this.parallel {
 		numInflowing := structures.NewParallelRectangularArrayByte(rows, columns)
@@ -226,10 +337,11 @@ func (this *FD8FlowAccum) Run() {
 
 		// parallel stuff
 		println("Num CPUs:", numCPUs)
-		c1 := make(chan bool)
+		c1 := make(chan bool, rows)
 		//c2 := make(chan bool)
 		runtime.GOMAXPROCS(numCPUs)
 		var wg sync.WaitGroup
+		defer wg.Wait()
 
 		qg := NewQueueGroup(numCPUs)
 
@@ -271,6 +383,10 @@ func (this *FD8FlowAccum) Run() {
 				dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
 				dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
 				for row := rowSt; row <= rowEnd; row++ {
+					if interrupted() {
+						c1 <- true
+						continue
+					}
 					byteData := make([]byte, columns)
 					floatData := make([]float64, columns)
 					for col := 0; col < columns; col++ {
@@ -288,7 +404,7 @@ func (this *FD8FlowAccum) Run() {
 							if j == 0 {
 								qg.push(row, col, k)
 							}
-							floatData[col] = 1.0
+							floatData[col] = weightAt(row, col)
 						} else {
 							//c2 <- true // update the number of solved cells
 							//outputData.SetValue(row, col, nodata)
@@ -311,7 +427,7 @@ func (this *FD8FlowAccum) Run() {
 			<-c1 // a row has successfully completed
 			progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
 			if progress != oldProgress {
-				printf("\rLoop (1 of 2): %v%%", progress)
+				printf("\rLoop (1 of 2): %v%%%s", progress, eta.remaining(int(progress)))
 				oldProgress = progress
 			}
 		}
@@ -324,6 +440,8 @@ func (this *FD8FlowAccum) Run() {
 		config.NoDataValue = nodata
 		config.InitialValue = 1
 		config.PreferredPalette = "blueyellow.pal"
+		config.DisplayClipPercent = 2.0 // accumulation is highly skewed
+		config.DisplayClipSampleSize = 1000000
 		config.CoordinateRefSystemWKT = dem.GetRasterConfig().CoordinateRefSystemWKT
 		config.EPSGCode = dem.GetRasterConfig().EPSGCode
 		rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -404,6 +522,7 @@ func (this *FD8FlowAccum) Run() {
 
 		wg.Wait()
 
+		numUndefined := 0
 		if this.lnTransform {
 			println("")
 			printf("\r                                                    ")
@@ -417,17 +536,24 @@ func (this *FD8FlowAccum) Run() {
 					//z = rout.Value(row, col)
 					//z = outputData.Value(row, col)
 					if floatData[col] != nodata {
-						//rout.SetValue(row, col, math.Log(z))
-						rout.SetValue(row, col, math.Log(floatData[col]))
+						if z, ok := this.logMethod.apply(floatData[col]); ok {
+							rout.SetValue(row, col, z)
+						} else {
+							rout.SetValue(row, col, nodata)
+							numUndefined++
+						}
 					}
 				}
 
 				progress = int(100.0 * int32(row) / rowsLessOne)
 				if progress != oldProgress {
-					printf("\rTransforming output: %v%%", progress)
+					printf("\rTransforming output: %v%%%s", progress, eta.remaining(int(progress)))
 					oldProgress = progress
 				}
 			}
+			if numUndefined > 0 {
+				printf("\nWarning: the log transform was undefined for %v cells, which were assigned nodata.", numUndefined)
+			}
 		} else {
 			println("")
 			printf("\r                                                    ")
@@ -449,7 +575,7 @@ func (this *FD8FlowAccum) Run() {
 				}
 				progress = int(100.0 * int32(row) / rowsLessOne)
 				if progress != oldProgress {
-					printf("\rOutputing data: %v%%", progress)
+					printf("\rOutputing data: %v%%%s", progress, eta.remaining(int(progress)))
 					oldProgress = progress
 				}
 			}
@@ -459,7 +585,13 @@ func (this *FD8FlowAccum) Run() {
 		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 		elapsed := time.Since(start1)
 		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-		rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool"))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool (%s)", this.toolManager.toolVersion(this)))
+		if this.lnTransform {
+			rout.AddMetadataEntry(fmt.Sprintf("Log transform: %s", this.logMethod))
+		}
+		if weights != nil {
+			rout.AddMetadataEntry(fmt.Sprintf("Weighted by %s: %s", weights.variable, this.weightFile))
+		}
 		rout.Save()
 	} else {
 		numInflowing := structures.NewRectangularArrayByte(rows, columns)
@@ -497,6 +629,9 @@ func (this *FD8FlowAccum) Run() {
 					if j == 0 {
 						q.push(row, col)
 					}
+					if weights != nil {
+						outputData.SetValue(row, col, weightAt(row, col))
+					}
 				} else {
 					numSolvedCells++
 					outputData.SetValue(row, col, nodata)
@@ -506,7 +641,7 @@ func (this *FD8FlowAccum) Run() {
 			rowsCompleted++
 			progress = int32(100.0 * rowsCompleted / rowsLessOne)
 			if progress != oldProgress {
-				printf("\rLoop (1 of 2): %v%%", progress)
+				printf("\rLoop (1 of 2): %v%%%s", progress, eta.remaining(int(progress)))
 				oldProgress = progress
 			}
 		}
@@ -517,6 +652,8 @@ func (this *FD8FlowAccum) Run() {
 		config.NoDataValue = nodata
 		config.InitialValue = 1
 		config.PreferredPalette = "blueyellow.pal"
+		config.DisplayClipPercent = 2.0 // accumulation is highly skewed
+		config.DisplayClipSampleSize = 1000000
 		config.CoordinateRefSystemWKT = dem.GetRasterConfig().CoordinateRefSystemWKT
 		config.EPSGCode = dem.GetRasterConfig().EPSGCode
 		rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -579,11 +716,12 @@ func (this *FD8FlowAccum) Run() {
 			numSolvedCells++
 			progress = int32(100.0 * float64(numSolvedCells) / numCellsTotal)
 			if progress != oldProgress {
-				printf("\rLoop (2 of 2): %v%%", progress)
+				printf("\rLoop (2 of 2): %v%%%s", progress, eta.remaining(int(progress)))
 				oldProgress = progress
 			}
 		}
 
+		numUndefined := 0
 		if this.lnTransform {
 			println("")
 			printf("\r                                                    ")
@@ -596,17 +734,25 @@ func (this *FD8FlowAccum) Run() {
 					//z = rout.Value(row, col)
 					z = outputData.Value(row, col)
 					if z != nodata {
-						rout.SetValue(row, col, math.Log(z))
+						if z, ok := this.logMethod.apply(z); ok {
+							rout.SetValue(row, col, z)
+						} else {
+							rout.SetValue(row, col, nodata)
+							numUndefined++
+						}
 					} else {
 						rout.SetValue(row, col, nodata)
 					}
 				}
 				progress = int32(100.0 * int32(row) / rowsLessOne)
 				if progress != oldProgress {
-					printf("\rTransforming output: %v%%", progress)
+					printf("\rTransforming output: %v%%%s", progress, eta.remaining(int(progress)))
 					oldProgress = progress
 				}
 			}
+			if numUndefined > 0 {
+				printf("\nWarning: the log transform was undefined for %v cells, which were assigned nodata.", numUndefined)
+			}
 		} else {
 			println("")
 			printf("\r                                                    ")
@@ -626,7 +772,7 @@ func (this *FD8FlowAccum) Run() {
 				}
 				progress = int32(100.0 * int32(row) / rowsLessOne)
 				if progress != oldProgress {
-					printf("\rOutputing data: %v%%", progress)
+					printf("\rOutputing data: %v%%%s", progress, eta.remaining(int(progress)))
 					oldProgress = progress
 				}
 			}
@@ -636,7 +782,13 @@ func (this *FD8FlowAccum) Run() {
 		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 		elapsed := time.Since(start1)
 		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-		rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool"))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by D8FlowAccumulation tool (%s)", this.toolManager.toolVersion(this)))
+		if this.lnTransform {
+			rout.AddMetadataEntry(fmt.Sprintf("Log transform: %s", this.logMethod))
+		}
+		if weights != nil {
+			rout.AddMetadataEntry(fmt.Sprintf("Weighted by %s: %s", weights.variable, this.weightFile))
+		}
 		rout.Save()
 	}
 
@@ -36,6 +36,10 @@ func (this *FillDepressions) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *FillDepressions) GetVersion() string {
+	return "1.0"
+}
+
 func (this *FillDepressions) GetHelpDocumentation() string {
 	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using an efficient depression filling method. Note that the BreachDepressions tool is the preferred method of creating a depressionless DEM."
 	return ret
@@ -45,28 +49,35 @@ func (this *FillDepressions) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *FillDepressions) GetArgDescriptions() [][]string {
+func (this *FillDepressions) GetArgDescriptions() []ToolArg {
 	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = taudemOutputRequired(this.toolManager)
 
-	ret[2][0] = "FixFlats"
-	ret[2][1] = "bool"
-	ret[2][2] = "Should the resulting flat areas be fixed?"
+	ret[2].Name = "FixFlats"
+	ret[2].Type = "bool"
+	ret[2].Description = "Should the resulting flat areas be fixed?"
+	ret[2].Default = "false"
 
 	return ret
 }
 
+func (this *FillDepressions) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters and the padded queue grid
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) + gridBytes(rows+2, columns+2, 1)
+}
+
 func (this *FillDepressions) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -75,18 +86,21 @@ func (this *FillDepressions) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
 	outputFile := args[1]
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "fel")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -118,7 +132,7 @@ func (this *FillDepressions) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -130,12 +144,15 @@ func (this *FillDepressions) CollectArguments() {
 		println(err)
 	}
 	outputFile = strings.TrimSpace(outputFile)
+	if this.toolManager.TauDEMMode {
+		outputFile = taudemOutputFile(this.inputFile, outputFile, "fel")
+	}
 	if !strings.Contains(outputFile, pathSep) {
 		outputFile = this.toolManager.workingDirectory + outputFile
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -169,6 +186,7 @@ func (this *FillDepressions) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row, i, n int
+	var eta progressETA
 	var colN, rowN, flatindex int
 	numSolvedCells := 0
 	var z, zN float64
@@ -199,10 +217,11 @@ func (this *FillDepressions) Run() {
 	config.DataType = raster.DT_FLOAT32
 	config.NoDataValue = nodata
 	config.InitialValue = nodata
-	displayMin := demConfig.DisplayMinimum
-	displayMax := demConfig.DisplayMaximum
-	config.DisplayMinimum = displayMin
-	config.DisplayMaximum = displayMax
+	if this.toolManager.TauDEMMode {
+		config.NoDataValue = taudemElevationNodata
+		config.InitialValue = taudemElevationNodata
+	}
+	config.CopyDisplaySettings(demConfig)
 	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
 	config.EPSGCode = demConfig.EPSGCode
 	value := fmt.Sprintf("Created on %s\n", time.Now().Local())
@@ -275,7 +294,7 @@ func (this *FillDepressions) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rFilling DEM (1 of 2): %v%%", progress)
+			printf("\rFilling DEM (1 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -316,7 +335,7 @@ func (this *FillDepressions) Run() {
 		}
 		progress = int(100.0 * numSolvedCells / numCellsTotal)
 		if progress != oldProgress {
-			printf("\rFilling DEM (2 of 2): %v%%", progress)
+			printf("\rFilling DEM (2 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -324,9 +343,8 @@ func (this *FillDepressions) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by FillDepressions tool"))
-	config.DisplayMinimum = displayMin
-	config.DisplayMaximum = displayMax
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FillDepressions tool (%s)", this.toolManager.toolVersion(this)))
+	config.CopyDisplaySettings(demConfig)
 	rout.SetRasterConfig(config)
 	rout.Save()
 
@@ -33,6 +33,10 @@ func (this *FillSmallNodataHoles) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *FillSmallNodataHoles) GetVersion() string {
+	return "1.0"
+}
+
 func (this *FillSmallNodataHoles) GetHelpDocumentation() string {
 	ret := ""
 	return ret
@@ -42,20 +46,21 @@ func (this *FillSmallNodataHoles) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *FillSmallNodataHoles) GetArgDescriptions() [][]string {
+func (this *FillSmallNodataHoles) GetArgDescriptions() []ToolArg {
 	numArgs := 2
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
 	return ret
 }
@@ -68,7 +73,7 @@ func (this *FillSmallNodataHoles) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -79,7 +84,7 @@ func (this *FillSmallNodataHoles) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -101,7 +106,7 @@ func (this *FillSmallNodataHoles) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -118,7 +123,7 @@ func (this *FillSmallNodataHoles) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -129,6 +134,7 @@ func (this *FillSmallNodataHoles) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row int
+	var eta progressETA
 	var z, zN1, zN2 float64
 
 	println("Reading raster data...")
@@ -147,14 +153,12 @@ func (this *FillSmallNodataHoles) Run() {
 
 	// create the output raster
 	config := raster.NewDefaultRasterConfig()
-	config.PreferredPalette = inConfig.PreferredPalette
+	config.CopyDisplaySettings(inConfig)
 	config.DataType = inConfig.DataType
 	config.NoDataValue = nodata
 	config.InitialValue = nodata
 	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
 	config.EPSGCode = inConfig.EPSGCode
-	config.DisplayMinimum = inConfig.DisplayMinimum
-	config.DisplayMaximum = inConfig.DisplayMaximum
 	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
 		rin.North, rin.South, rin.East, rin.West, config)
 	if err != nil {
@@ -180,7 +184,7 @@ func (this *FillSmallNodataHoles) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rProgress (Loop 1 of 2): %v%%", progress)
+			printf("\rProgress (Loop 1 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -199,7 +203,7 @@ func (this *FillSmallNodataHoles) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rProgress (Loop 2 of 2): %v%%", progress)
+			printf("\rProgress (Loop 2 of 2): %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -210,7 +214,7 @@ func (this *FillSmallNodataHoles) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by FillSmallNodataHoles"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FillSmallNodataHoles tool (%s)", this.toolManager.toolVersion(this)))
 	rout.Save()
 
 	println("Operation complete!")
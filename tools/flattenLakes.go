@@ -0,0 +1,415 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FlattenLakes hydro-flattens the waterbodies of a DEM, setting each to the
+// elevation of its lowest shoreline cell with a slight gradient towards its
+// outlet.
+type FlattenLakes struct {
+	inputFile     string
+	waterbodyFile string
+	outputFile    string
+	increment     float64
+	toolManager   *PluginToolManager
+}
+
+func (this *FlattenLakes) GetName() string {
+	s := "FlattenLakes"
+	return getFormattedToolName(s)
+}
+
+func (this *FlattenLakes) GetDescription() string {
+	s := "Hydro-flattens waterbodies to their shoreline level"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FlattenLakes) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FlattenLakes) GetHelpDocumentation() string {
+	ret := "This tool performs the hydro-flattening of lakes and reservoirs that is " +
+		"commonly applied before breaching depressions. Waterbodies are read either from a " +
+		"raster on the grid of the DEM, or aligned onto it, in which each 8-connected group of cells " +
+		"that are neither zero nor nodata is a waterbody, or from a polygon shapefile, in " +
+		"which case the waterbodies are the cells whose centres are within the polygons, " +
+		"outside of their holes. The shoreline of a waterbody " +
+		"is the set of valid cells bordering it, and its outlets are the shoreline cells with " +
+		"the minimum shoreline elevation. Each waterbody cell is set to the minimum shoreline " +
+		"elevation plus the Increment (default 0.001) times its distance, in cells, from the " +
+		"nearest outlet, so that the lake surface drains to its outlet rather than being flat. " +
+		"The Increment should be small enough that the raised surface remains below the " +
+		"other shoreline cells, but larger than the precision of the output (32-bit floats)."
+	return ret
+}
+
+func (this *FlattenLakes) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FlattenLakes) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "WaterbodyFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The waterbody raster or polygon shapefile"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Increment"
+	ret[3].Type = "float64"
+	ret[3].Description = "The elevation increment per cell from the outlet"
+	ret[3].Default = "0.001"
+
+	return ret
+}
+
+func (this *FlattenLakes) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters, the waterbody mask and the distances
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+5)
+}
+
+func (this *FlattenLakes) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input DEM, waterbody file, and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	waterbodyFile := strings.TrimSpace(args[1])
+	if !strings.Contains(waterbodyFile, pathSep) {
+		waterbodyFile = this.toolManager.workingDirectory + waterbodyFile
+	}
+	if _, err := os.Stat(waterbodyFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", waterbodyFile)
+		return
+	}
+	this.waterbodyFile = waterbodyFile
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.increment = 0.001
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setIncrement(args[3]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FlattenLakes) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the waterbody file name
+	print("Enter the waterbody raster or polygon shapefile name: ")
+	waterbodyFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	waterbodyFile = strings.TrimSpace(waterbodyFile)
+	if !strings.Contains(waterbodyFile, pathSep) {
+		waterbodyFile = this.toolManager.workingDirectory + waterbodyFile
+	}
+	if _, err := os.Stat(waterbodyFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", waterbodyFile)
+		return
+	}
+	this.waterbodyFile = waterbodyFile
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the increment
+	this.increment = 0.001
+	print("Elevation increment per cell from the outlet (default 0.001): ")
+	incrementStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(incrementStr)) > 0 {
+		if !this.setIncrement(incrementStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FlattenLakes) setIncrement(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 0 {
+		println("The increment must not be negative.")
+		return false
+	}
+	this.increment = v
+	return true
+}
+
+func (this *FlattenLakes) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+
+	// waterbody cells must be valid cells of the DEM
+	lake := make([]bool, rows*columns)
+	if rt, err := raster.DetermineRasterFormat(this.waterbodyFile); err == nil && rt != raster.RT_UnknownRaster {
+		mask, err := readConstraintGrid(this.waterbodyFile, dem)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				lake[row*columns+col] = mask[row+1][col+1] && dem.Value(row, col) != nodata
+			}
+		}
+	} else {
+		polygons, err := readPolygons(this.waterbodyFile)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		cellSizeX := dem.GetCellSizeX()
+		cellSizeY := dem.GetCellSizeY()
+		for row := 0; row < rows; row++ {
+			y := dem.North - (float64(row)+0.5)*cellSizeY
+			for col := 0; col < columns; col++ {
+				x := dem.West + (float64(col)+0.5)*cellSizeX
+				lake[row*columns+col] = dem.Value(row, col) != nodata && anyPolygonContains(polygons, x, y)
+			}
+		}
+	}
+
+	start2 := time.Now()
+
+	z := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z[row*columns+col] = dem.Value(row, col)
+		}
+	}
+
+	// flatten each 8-connected waterbody in turn
+	dist := make([]int32, rows*columns)
+	for i := range dist {
+		dist[i] = -1
+	}
+	numLakes, numEdited := 0, 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if !lake[row*columns+col] || dist[row*columns+col] >= 0 {
+				continue
+			}
+			numLakes++
+
+			// gather the waterbody and find its lowest shoreline elevation
+			cells := [][2]int{{row, col}}
+			dist[row*columns+col] = math.MaxInt32
+			minShore := math.Inf(1)
+			for k := 0; k < len(cells); k++ {
+				r, c := cells[k][0], cells[k][1]
+				for n := 0; n < 8; n++ {
+					rN, cN := r+d8DY[n], c+d8DX[n]
+					if rN < 0 || rN >= rows || cN < 0 || cN >= columns {
+						continue
+					}
+					iN := rN*columns + cN
+					if lake[iN] {
+						if dist[iN] < 0 {
+							dist[iN] = math.MaxInt32
+							cells = append(cells, [2]int{rN, cN})
+						}
+					} else if z[iN] != nodata && z[iN] < minShore {
+						minShore = z[iN]
+					}
+				}
+			}
+			if math.IsInf(minShore, 1) {
+				// the waterbody has no shoreline; use its lowest cell
+				for _, cell := range cells {
+					if zc := z[cell[0]*columns+cell[1]]; zc < minShore {
+						minShore = zc
+					}
+				}
+			}
+
+			// distances from the outlets, breadth first
+			q := newFlowQueue()
+			for _, cell := range cells {
+				r, c := cell[0], cell[1]
+				for n := 0; n < 8; n++ {
+					rN, cN := r+d8DY[n], c+d8DX[n]
+					if rN < 0 || rN >= rows || cN < 0 || cN >= columns {
+						continue
+					}
+					iN := rN*columns + cN
+					if !lake[iN] && z[iN] == minShore {
+						dist[r*columns+c] = 0
+						q.push(r, c)
+						break
+					}
+				}
+			}
+			if q.count == 0 {
+				for _, cell := range cells {
+					if z[cell[0]*columns+cell[1]] == minShore {
+						dist[cell[0]*columns+cell[1]] = 0
+						q.push(cell[0], cell[1])
+					}
+				}
+			}
+			for q.count > 0 {
+				r, c := q.pop()
+				d := dist[r*columns+c] + 1
+				for n := 0; n < 8; n++ {
+					rN, cN := r+d8DY[n], c+d8DX[n]
+					if rN < 0 || rN >= rows || cN < 0 || cN >= columns {
+						continue
+					}
+					iN := rN*columns + cN
+					if lake[iN] && dist[iN] > d {
+						dist[iN] = d
+						q.push(rN, cN)
+					}
+				}
+			}
+
+			for _, cell := range cells {
+				i := cell[0]*columns + cell[1]
+				z[i] = minShore + this.increment*float64(dist[i]+1)
+				numEdited++
+			}
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(demConfig)
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = demConfig.ZUnits
+	config.XYUnits = demConfig.XYUnits
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z[row*columns+col] != nodata {
+				rout.SetValue(row, col, z[row*columns+col])
+			}
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FlattenLakes tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Waterbodies: %s", this.waterbodyFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Increment: %v", this.increment))
+	rout.Save()
+
+	printf("Number of waterbodies: %v, cells flattened: %v\n", numLakes, numEdited)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
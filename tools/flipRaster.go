@@ -0,0 +1,260 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FlipRaster reverses the row or column order of a raster, e.g. to correct a
+// dataset delivered bottom-up.
+type FlipRaster struct {
+	inputFile   string
+	outputFile  string
+	direction   string
+	toolManager *PluginToolManager
+}
+
+func (this *FlipRaster) GetName() string {
+	s := "FlipRaster"
+	return getFormattedToolName(s)
+}
+
+func (this *FlipRaster) GetDescription() string {
+	s := "Flips a raster vertically or horizontally"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FlipRaster) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FlipRaster) GetHelpDocumentation() string {
+	ret := "This tool flips the grid of a raster within its extent, e.g. to correct a " +
+		"dataset whose rows were written from south to north. A 'vertical' flip (the default) " +
+		"reverses the order of the rows, a 'horizontal' flip reverses the order of the " +
+		"columns, and 'both' does both, which is a rotation of 180 degrees. The " +
+		"georeferencing is not modified. Directional rasters, e.g. flow pointers and aspect, " +
+		"are not adjusted and should be recalculated from a flipped DEM. If no output file is " +
+		"specified, the input raster is overwritten."
+	return ret
+}
+
+func (this *FlipRaster) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FlipRaster) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "Direction"
+	ret[1].Type = "string"
+	ret[1].Description = "vertical, horizontal or both"
+	ret[1].Default = "vertical"
+	ret[1].Choices = flipDirections
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename (overwrites the input if not specified)"
+	ret[2].Role = ArgOutputRaster
+
+	return ret
+}
+
+var flipDirections = []string{"vertical", "horizontal", "both"}
+
+func (this *FlipRaster) ParseArguments(args []string) {
+	if len(args) < 1 {
+		println("The input file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	directionStr := ""
+	if len(args) > 1 && args[1] != "not specified" {
+		directionStr = args[1]
+	}
+	if !this.setDirection(directionStr) {
+		return
+	}
+
+	this.outputFile = this.inputFile
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		outputFile := strings.TrimSpace(args[2])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *FlipRaster) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the direction
+	print("Direction (vertical, horizontal or both; default vertical): ")
+	direction, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setDirection(direction) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension; blank to overwrite input): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputFile = this.inputFile
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *FlipRaster) setDirection(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 0 {
+		s = "vertical"
+	}
+	for _, d := range flipDirections {
+		if s == d {
+			this.direction = s
+			return true
+		}
+	}
+	println("Unrecognized direction; use 'vertical', 'horizontal' or 'both'.")
+	return false
+}
+
+func (this *FlipRaster) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	data, err := rin.Data()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	flipRows := this.direction != "horizontal"
+	flipColumns := this.direction != "vertical"
+	flipped := make([]float64, len(data))
+	for row := 0; row < rows; row++ {
+		inRow := row
+		if flipRows {
+			inRow = rows - 1 - row
+		}
+		for col := 0; col < columns; col++ {
+			inCol := col
+			if flipColumns {
+				inCol = columns - 1 - col
+			}
+			flipped[row*columns+col] = data[inRow*columns+inCol]
+		}
+	}
+
+	// create the output raster
+	config := copyRasterConfig(rin)
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	rout.SetData(flipped)
+
+	for _, entry := range rin.GetMetadataEntries() {
+		if len(strings.TrimSpace(entry)) > 0 {
+			rout.AddMetadataEntry(entry)
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FlipRaster tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Flip: %s", this.direction))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
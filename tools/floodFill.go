@@ -0,0 +1,459 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FloodFill grows regions from seed cells across neighbouring cells that
+// satisfy a predicate, e.g. for simple bathtub flood modelling.
+type FloodFill struct {
+	inputFile    string
+	seedFile     string
+	outputFile   string
+	predicate    string
+	threshold    float64
+	connectivity int
+	toolManager  *PluginToolManager
+}
+
+var floodFillPredicates = []string{"below", "above", "tolerance"}
+
+func (this *FloodFill) GetName() string {
+	s := "FloodFill"
+	return getFormattedToolName(s)
+}
+
+func (this *FloodFill) GetDescription() string {
+	s := "Grows regions from seed cells (region growing)"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FloodFill) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FloodFill) GetHelpDocumentation() string {
+	ret := "This tool grows regions from seed cells across neighbouring cells for which a " +
+		"predicate holds, i.e. flood filling or region growing. The predicate is 'below' " +
+		"(the cell value is less than or equal to the Threshold, producing bathtub " +
+		"inundation extents), 'above' (the value is greater than or equal to the Threshold), " +
+		"or 'tolerance' (the value is within Threshold of the value of the seed cell from " +
+		"which the region grew). Seed cells that don't satisfy the predicate are ignored. " +
+		"The seeds are either read from a raster, in which every cell that is neither zero " +
+		"nor nodata is a seed labelled with its value, or from a text file of 'x y [label]' " +
+		"points, which are labelled in order from 1. Each output cell is assigned the label " +
+		"of the region that reached it first, or zero if it wasn't reached. Connectivity is " +
+		"either 8 (the default) or 4."
+	return ret
+}
+
+func (this *FloodFill) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FloodFill) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, e.g. a DEM, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "SeedFile"
+	ret[1].Type = "string"
+	ret[1].Description = "A seed raster or a text file of 'x y [label]' seed points"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Predicate"
+	ret[3].Type = "string"
+	ret[3].Description = "below, above or tolerance"
+	ret[3].Required = true
+	ret[3].Choices = []string{"below", "above", "tolerance"}
+
+	ret[4].Name = "Threshold"
+	ret[4].Type = "float64"
+	ret[4].Description = "The threshold value, or the tolerance about the seed value"
+	ret[4].Required = true
+
+	ret[5].Name = "Connectivity"
+	ret[5].Type = "int"
+	ret[5].Description = "4 or 8"
+	ret[5].Default = "8"
+	ret[5].Choices = []string{"4", "8"}
+
+	return ret
+}
+
+func (this *FloodFill) EstimateMemory(rows, columns int) int64 {
+	// the input, seed and output rasters and the seed of each cell
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+4)
+}
+
+func (this *FloodFill) ParseArguments(args []string) {
+	if len(args) < 5 {
+		println("The input file, seed file, output file, predicate, and threshold must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	seedFile := strings.TrimSpace(args[1])
+	if !strings.Contains(seedFile, pathSep) {
+		seedFile = this.toolManager.workingDirectory + seedFile
+	}
+	this.seedFile = seedFile
+	if _, err := os.Stat(this.seedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.seedFile)
+		return
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setPredicate(args[3]) {
+		return
+	}
+	if this.threshold, err = strconv.ParseFloat(strings.TrimSpace(args[4]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.connectivity = 8
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if !this.setConnectivity(args[5]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FloodFill) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the seed file name
+	print("Enter the seed raster or points file name (incl. file extension): ")
+	seedFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	seedFile = strings.TrimSpace(seedFile)
+	if !strings.Contains(seedFile, pathSep) {
+		seedFile = this.toolManager.workingDirectory + seedFile
+	}
+	this.seedFile = seedFile
+	if _, err := os.Stat(this.seedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.seedFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the predicate
+	print("Predicate (below, above or tolerance): ")
+	predicate, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setPredicate(predicate) {
+		return
+	}
+
+	// get the threshold
+	print("Threshold value (or tolerance): ")
+	thresholdStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.threshold, err = strconv.ParseFloat(strings.TrimSpace(thresholdStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the connectivity
+	print("Connectivity, 4 or 8 (blank for 8): ")
+	connectivity, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.connectivity = 8
+	if len(strings.TrimSpace(connectivity)) > 0 {
+		if !this.setConnectivity(connectivity) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FloodFill) setPredicate(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, p := range floodFillPredicates {
+		if s == p {
+			this.predicate = p
+			return true
+		}
+	}
+	printf("Unrecognized predicate: %s\n", s)
+	return false
+}
+
+func (this *FloodFill) setConnectivity(s string) bool {
+	c, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || (c != 4 && c != 8) {
+		println("The connectivity must be either 4 or 8.")
+		return false
+	}
+	this.connectivity = c
+	return true
+}
+
+func (this *FloodFill) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+
+	seeds, err := readSeedCells(this.seedFile, rin)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	var accept func(z, seedZ float64) bool
+	switch this.predicate {
+	case "below":
+		accept = func(z, seedZ float64) bool { return z <= this.threshold }
+	case "above":
+		accept = func(z, seedZ float64) bool { return z >= this.threshold }
+	default:
+		accept = func(z, seedZ float64) bool { return math.Abs(z-seedZ) <= this.threshold }
+	}
+	origin, numRejected := floodFill(rin, seeds, accept, this.connectivity)
+	if numRejected > 0 {
+		printf("Warning: %v seed cells did not satisfy the predicate and were ignored.\n", numRejected)
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "qual.plt"
+	config.DataType = raster.DT_INT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numFilled := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if rin.Value(row, col) == nodata {
+				continue
+			}
+			if s := origin[row*columns+col]; s >= 0 {
+				rout.SetValue(row, col, seeds[s].label)
+				numFilled++
+			} else {
+				rout.SetValue(row, col, 0)
+			}
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FloodFill tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Predicate: %s %v", this.predicate, this.threshold))
+	rout.Save()
+
+	printf("Filled cells: %v\n", numFilled)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// seedCell is a labelled grid cell from which a region is grown.
+type seedCell struct {
+	row, col int
+	label    float64
+}
+
+// readSeedCells reads seed cells either from a raster on the grid of r, or
+// aligned onto it, in which every cell that is neither zero nor nodata is a
+// seed labelled with its value, or from a points file, in which case the
+// seeds are labelled in order from 1. Points outside of r are ignored.
+func readSeedCells(fileName string, r *raster.Raster) ([]seedCell, error) {
+	seeds := make([]seedCell, 0)
+	if rt, err := raster.DetermineRasterFormat(fileName); err == nil && rt != raster.RT_UnknownRaster {
+		sr, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if sr, err = alignRaster(r, sr, defaultAlignTolerance, "nearest"); err != nil {
+			return nil, err
+		}
+		for row := 0; row < sr.Rows; row++ {
+			for col := 0; col < sr.Columns; col++ {
+				if z := sr.Value(row, col); z != sr.NoDataValue && z != 0 {
+					seeds = append(seeds, seedCell{row, col, z})
+				}
+			}
+		}
+	} else {
+		points, err := readPointFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		for i, pt := range points {
+			row := int(math.Floor((r.North - pt.y) / r.GetCellSizeY()))
+			col := int(math.Floor((pt.x - r.West) / r.GetCellSizeX()))
+			if row >= 0 && row < r.Rows && col >= 0 && col < r.Columns {
+				seeds = append(seeds, seedCell{row, col, float64(i + 1)})
+			}
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, errors.New("No seed cells were found within the raster.")
+	}
+	return seeds, nil
+}
+
+// floodFill grows regions from the seeds, breadth first, across valid cells
+// for which accept(value, seed value) holds, using 4 or 8 connectivity. It
+// returns the index of the seed that reached each cell, in row-major order,
+// or -1 for cells that weren't reached, and the number of seeds that were
+// rejected by the predicate.
+func floodFill(r *raster.Raster, seeds []seedCell, accept func(z, seedZ float64) bool, connectivity int) ([]int32, int) {
+	rows, columns := r.Rows, r.Columns
+	nodata := r.NoDataValue
+	origin := make([]int32, rows*columns)
+	for i := range origin {
+		origin[i] = -1
+	}
+	step := 1
+	if connectivity == 4 {
+		step = 2 // the cardinal neighbours
+	}
+	numRejected := 0
+	q := newFlowQueue()
+	for s, seed := range seeds {
+		i := seed.row*columns + seed.col
+		if z := r.Value(seed.row, seed.col); z == nodata || !accept(z, z) {
+			numRejected++
+			continue
+		}
+		if origin[i] < 0 {
+			origin[i] = int32(s)
+			q.push(seed.row, seed.col)
+		}
+	}
+	for q.count > 0 {
+		row, col := q.pop()
+		s := origin[row*columns+col]
+		seedZ := r.Value(seeds[s].row, seeds[s].col)
+		for n := step - 1; n < 8; n += step {
+			rowN, colN := row+d8DY[n], col+d8DX[n]
+			if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns {
+				continue
+			}
+			i := rowN*columns + colN
+			if origin[i] >= 0 {
+				continue
+			}
+			if z := r.Value(rowN, colN); z != nodata && accept(z, seedZ) {
+				origin[i] = s
+				q.push(rowN, colN)
+			}
+		}
+	}
+	return origin, numRejected
+}
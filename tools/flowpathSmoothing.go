@@ -0,0 +1,409 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FlowpathSmoothing smooths a DEM along its D8 flowpaths, which reduces
+// noise without flattening channels.
+type FlowpathSmoothing struct {
+	inputFile     string
+	outputFile    string
+	pathLength    int
+	numIterations int
+	toolManager   *PluginToolManager
+}
+
+func (this *FlowpathSmoothing) GetName() string {
+	s := "FlowpathSmoothing"
+	return getFormattedToolName(s)
+}
+
+func (this *FlowpathSmoothing) GetDescription() string {
+	s := "Smooths a DEM along flowpaths, preserving drainage"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FlowpathSmoothing) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FlowpathSmoothing) GetHelpDocumentation() string {
+	ret := "This tool smooths a DEM by replacing each cell with the mean elevation of the " +
+		"cells on its flowpath, i.e. the cell itself, the PathLength (default 3) cells " +
+		"downstream of it along the D8 flow directions, and the same number of cells " +
+		"upstream of it, following the inflowing neighbour with the largest contributing " +
+		"area. Since the mean is taken along the direction of flow rather than over a " +
+		"square window, the noise that crenulates contours is reduced while channels are " +
+		"not filled in by the cells of their banks and valley bottoms are not flattened. " +
+		"The flowpath is kept symmetric about the cell, i.e. where there are fewer cells " +
+		"upstream (e.g. near divides) or downstream (e.g. near outlets and pits) than the " +
+		"PathLength, the shorter of the two lengths is used on both sides, so that uniform " +
+		"slopes are unchanged; divide cells and pits are unchanged. Flow directions are " +
+		"recalculated for each of the Iterations (default 1). The tool is intended to be " +
+		"used between denoising, e.g. with the Despike tool, and depression breaching."
+	return ret
+}
+
+func (this *FlowpathSmoothing) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FlowpathSmoothing) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "PathLength"
+	ret[2].Type = "int"
+	ret[2].Description = "Cells up- and downstream in the mean"
+	ret[2].Default = "3"
+
+	ret[3].Name = "Iterations"
+	ret[3].Type = "int"
+	ret[3].Description = "The number of iterations"
+	ret[3].Default = "1"
+
+	return ret
+}
+
+func (this *FlowpathSmoothing) EstimateMemory(rows, columns int) int64 {
+	// the DEM, the output, the working DEM, the accumulation and the
+	// flow directions
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+1)
+}
+
+func (this *FlowpathSmoothing) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.pathLength = 3
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if this.pathLength, err = parsePositiveInt(args[2], "path length"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.numIterations = 1
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if this.numIterations, err = parsePositiveInt(args[3], "number of iterations"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FlowpathSmoothing) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the path length
+	this.pathLength = 3
+	print("Cells up- and downstream in the mean (blank for 3): ")
+	lengthStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(lengthStr)) > 0 {
+		if this.pathLength, err = parsePositiveInt(lengthStr, "path length"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the number of iterations
+	this.numIterations = 1
+	print("Number of iterations (blank for 1): ")
+	iterStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(iterStr)) > 0 {
+		if this.numIterations, err = parsePositiveInt(iterStr, "number of iterations"); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+// Parses a value that must be at least one.
+func parsePositiveInt(s, name string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if v < 1 {
+		return 0, fmt.Errorf("The %s must be at least 1.", name)
+	}
+	return v, nil
+}
+
+func (this *FlowpathSmoothing) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+	cellSizeX := dem.GetCellSizeX()
+	cellSizeY := dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+
+	start2 := time.Now()
+
+	// the working DEM, from which the flow directions of each iteration are
+	// calculated; it is never saved
+	config := raster.NewDefaultRasterConfig()
+	config.RasterFormat = raster.RT_GeoTiff
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	work, err := raster.CreateNewRaster("", rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			work.SetValue(row, col, dem.Value(row, col))
+		}
+	}
+
+	flowdir := make([][]int8, rows)
+	acc := make([][]float64, rows)
+	for row := range flowdir {
+		flowdir[row] = make([]int8, columns)
+		acc[row] = make([]float64, columns)
+	}
+	smoothed := make([]float64, rows*columns)
+	up := make([]float64, 0, this.pathLength)
+	down := make([]float64, 0, this.pathLength)
+	for iter := 1; iter <= this.numIterations; iter++ {
+		printf("\rIteration %v of %v", iter, this.numIterations)
+
+		// flow directions and accumulation, the latter to pick the main
+		// inflowing neighbour
+		numInflowing := make([]int8, rows*columns)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				flowdir[row][col] = d8Direction(work, row, col, dist)
+				acc[row][col] = 1
+				if dir := flowdir[row][col]; dir >= 0 {
+					numInflowing[(row+d8DY[dir])*columns+col+d8DX[dir]]++
+				}
+			}
+		}
+		fq := newFlowQueue()
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				if numInflowing[row*columns+col] == 0 && work.Value(row, col) != nodata {
+					fq.push(row, col)
+				}
+			}
+		}
+		for fq.count > 0 {
+			row, col := fq.pop()
+			if dir := flowdir[row][col]; dir >= 0 {
+				r, c := row+d8DY[dir], col+d8DX[dir]
+				acc[r][c] += acc[row][col]
+				if numInflowing[r*columns+c]--; numInflowing[r*columns+c] == 0 {
+					fq.push(r, c)
+				}
+			}
+		}
+
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				z := work.Value(row, col)
+				smoothed[row*columns+col] = z
+				if z == nodata {
+					continue
+				}
+				down = down[:0]
+				for r, c, k := row, col, 0; k < this.pathLength; k++ {
+					dir := flowdir[r][c]
+					if dir < 0 {
+						break
+					}
+					r, c = r+d8DY[dir], c+d8DX[dir]
+					down = append(down, work.Value(r, c))
+				}
+				up = up[:0]
+				for r, c, k := row, col, 0; k < this.pathLength; k++ {
+					// the inflowing neighbour with the largest area
+					best, maxAcc := -1, 0.0
+					for n := 0; n < 8; n++ {
+						rn, cn := r-d8DY[n], c-d8DX[n]
+						if rn < 0 || rn >= rows || cn < 0 || cn >= columns || flowdir[rn][cn] != int8(n) {
+							continue
+						}
+						if acc[rn][cn] > maxAcc {
+							best, maxAcc = n, acc[rn][cn]
+						}
+					}
+					if best < 0 {
+						break
+					}
+					r, c = r-d8DY[best], c-d8DX[best]
+					up = append(up, work.Value(r, c))
+				}
+				m := len(up)
+				if len(down) < m {
+					m = len(down)
+				}
+				if m == 0 {
+					continue
+				}
+				sum := z
+				for k := 0; k < m; k++ {
+					sum += up[k] + down[k]
+				}
+				smoothed[row*columns+col] = sum / float64(2*m+1)
+			}
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				work.SetValue(row, col, smoothed[row*columns+col])
+			}
+		}
+	}
+	println("")
+
+	// output the data
+	outConfig := raster.NewDefaultRasterConfig()
+	outConfig.CopyDisplaySettings(inConfig)
+	outConfig.DataType = inConfig.DataType
+	outConfig.NoDataValue = nodata
+	outConfig.InitialValue = nodata
+	outConfig.ZUnits = inConfig.ZUnits
+	outConfig.XYUnits = inConfig.XYUnits
+	outConfig.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	outConfig.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, outConfig)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	sumSq, n := 0.0, 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := work.Value(row, col)
+			rout.SetValue(row, col, z)
+			if z != nodata {
+				d := z - dem.Value(row, col)
+				sumSq += d * d
+				n++
+			}
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FlowpathSmoothing tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Path length: %v", this.pathLength))
+	rout.AddMetadataEntry(fmt.Sprintf("Iterations: %v", this.numIterations))
+	rout.Save()
+
+	if n > 0 {
+		printf("RMS change: %v\n", strconv.FormatFloat(math.Sqrt(sumSq/float64(n)), 'g', 6, 64))
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
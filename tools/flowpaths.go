@@ -0,0 +1,183 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"math"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FlowpathCell is a cell on a traced flowpath.
+type FlowpathCell struct {
+	Row, Column int
+	X, Y        float64 // the coordinates of the cell centre
+	Z           float64 // the elevation of the cell
+	Distance    float64 // the distance along the flowpath from the first cell
+}
+
+// FlowpathEnd records why a traced flowpath ended.
+type FlowpathEnd string
+
+const (
+	// FlowpathEdge is the end of a flowpath that leaves the DEM, at its edge or
+	// at a nodata cell, which is where breached and filled DEMs drain to.
+	FlowpathEdge FlowpathEnd = "edge"
+	// FlowpathPit is the end of a flowpath at a cell whose neighbours are
+	// all higher, i.e. an unbreached pit.
+	FlowpathPit FlowpathEnd = "pit"
+	// FlowpathFlat is the end of a flowpath at a cell without a lower
+	// neighbour but with a neighbour of the same elevation, i.e. on a flat.
+	FlowpathFlat FlowpathEnd = "flat"
+	// FlowpathStop is the end of a flowpath at a cell for which the stop
+	// function of TraceFlowpath returned true, e.g. a stream cell.
+	FlowpathStop FlowpathEnd = "stop"
+)
+
+// TraceFlowpath follows the D8 flowpath from a cell of a DEM, from each cell
+// to its steepest downslope neighbour, as in FlowDirectionsFromDEM.
+// The path includes the first and last cells. It ends at a cell without a
+// downslope neighbour, where it leaves the DEM if the cell borders the edge or
+// a nodata cell, and is otherwise on a flat if a neighbour has the same
+// elevation or else in a pit, or at the first cell after the first for which
+// stop returns true; stop may be nil. A path from a nodata or out-of-grid cell
+// is empty and ends at the edge. Since elevations fall strictly along a path,
+// it can't contain a loop.
+func TraceFlowpath(dem *raster.Raster, row, col int, stop func(row, col int) bool) ([]FlowpathCell, FlowpathEnd) {
+	nodata := dem.NoDataValue
+	if row < 0 || row >= dem.Rows || col < 0 || col >= dem.Columns || dem.Value(row, col) == nodata {
+		return nil, FlowpathEdge
+	}
+	cellSizeX, cellSizeY := dem.GetCellSizeX(), dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+
+	path := make([]FlowpathCell, 0)
+	distance := 0.0
+	for {
+		path = append(path, FlowpathCell{
+			Row:      row,
+			Column:   col,
+			X:        dem.West + (float64(col)+0.5)*cellSizeX,
+			Y:        dem.North - (float64(row)+0.5)*cellSizeY,
+			Z:        dem.Value(row, col),
+			Distance: distance,
+		})
+		if len(path) > 1 && stop != nil && stop(row, col) {
+			return path, FlowpathStop
+		}
+		dir := d8Direction(dem, row, col, dist)
+		if dir < 0 {
+			end := FlowpathPit
+			z := dem.Value(row, col)
+			for n := 0; n < 8; n++ {
+				zN := dem.Value(row+d8DY[n], col+d8DX[n])
+				if zN == nodata {
+					return path, FlowpathEdge
+				} else if zN == z {
+					end = FlowpathFlat
+				}
+			}
+			return path, end
+		}
+		row += d8DY[dir]
+		col += d8DX[dir]
+		distance += dist[dir]
+	}
+}
+
+// FlowDirections holds the D8 flow directions of a grid for repeated upslope
+// area queries, e.g. the catchments of cells picked interactively, which are
+// answered by traversing the flow directions in reverse from the query cell
+// rather than by computing the watersheds of the whole grid. A query only
+// touches the cells of its catchment and their neighbours. Queries must not
+// be run concurrently.
+type FlowDirections struct {
+	Rows, Columns int
+	dir           []int8   // neighbour indices, or -1 at pits, outlets and nodata
+	visited       []uint64 // a bitset of the cells visited by the current query
+}
+
+// NewFlowDirections decodes a D8 pointer raster in the named encoding,
+// 'whitebox', 'esri', 'taudem' or 'gospatial'.
+func NewFlowDirections(pointer *raster.Raster, encoding string) (*FlowDirections, error) {
+	e, err := parsePointerEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	fd := newFlowDirections(pointer.Rows, pointer.Columns)
+	nodata := pointer.NoDataValue
+	for row := 0; row < fd.Rows; row++ {
+		for col := 0; col < fd.Columns; col++ {
+			if z := pointer.Value(row, col); z != nodata {
+				fd.dir[row*fd.Columns+col] = int8(e.decode(z))
+			}
+		}
+	}
+	return fd, nil
+}
+
+// FlowDirectionsFromDEM calculates the D8 flow directions of a DEM.
+func FlowDirectionsFromDEM(dem *raster.Raster) *FlowDirections {
+	fd := newFlowDirections(dem.Rows, dem.Columns)
+	cellSizeX, cellSizeY := dem.GetCellSizeX(), dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+	for row := 0; row < fd.Rows; row++ {
+		for col := 0; col < fd.Columns; col++ {
+			fd.dir[row*fd.Columns+col] = d8Direction(dem, row, col, dist)
+		}
+	}
+	return fd
+}
+
+func newFlowDirections(rows, columns int) *FlowDirections {
+	fd := &FlowDirections{Rows: rows, Columns: columns}
+	fd.dir = make([]int8, rows*columns)
+	for i := range fd.dir {
+		fd.dir[i] = -1
+	}
+	fd.visited = make([]uint64, (rows*columns+63)/64)
+	return fd
+}
+
+// UpslopeCells returns the cells that drain to the cell at row, col, including
+// the cell itself, as indices row*Columns+col. The traversal doesn't enter
+// cells other than the query cell for which stop returns true, nor the cells
+// upslope of them, so that nested catchments can be separated; stop may be
+// nil. A query cell outside of the grid has no upslope cells.
+func (fd *FlowDirections) UpslopeCells(row, col int, stop func(row, col int) bool) []int {
+	if row < 0 || row >= fd.Rows || col < 0 || col >= fd.Columns {
+		return nil
+	}
+	start := row*fd.Columns + col
+	cells := []int{start}
+	fd.visited[start>>6] |= 1 << uint(start&63)
+	for i := 0; i < len(cells); i++ {
+		r, c := cells[i]/fd.Columns, cells[i]%fd.Columns
+		for n := 0; n < 8; n++ {
+			rN, cN := r+d8DY[n], c+d8DX[n]
+			if rN < 0 || rN >= fd.Rows || cN < 0 || cN >= fd.Columns {
+				continue
+			}
+			// the neighbour drains to this cell if it points back along n
+			j := rN*fd.Columns + cN
+			if int(fd.dir[j]) != (n+4)%8 || fd.visited[j>>6]&(1<<uint(j&63)) != 0 {
+				continue
+			}
+			if stop != nil && stop(rN, cN) {
+				continue
+			}
+			fd.visited[j>>6] |= 1 << uint(j&63)
+			cells = append(cells, j)
+		}
+	}
+	// clear only the bits that were set, so that a query costs nothing
+	// outside of its catchment
+	for _, j := range cells {
+		fd.visited[j>>6] &^= 1 << uint(j&63)
+	}
+	return cells
+}
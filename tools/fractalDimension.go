@@ -0,0 +1,394 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FractalDimension estimates the local fractal dimension of a DEM from the
+// variogram of the elevations within a moving window.
+type FractalDimension struct {
+	inputFile     string
+	outputFile    string
+	neighbourhood int
+	maxLag        int
+	toolManager   *PluginToolManager
+}
+
+func (this *FractalDimension) GetName() string {
+	s := "FractalDimension"
+	return getFormattedToolName(s)
+}
+
+func (this *FractalDimension) GetDescription() string {
+	s := "Calculates the local fractal dimension of a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FractalDimension) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FractalDimension) GetHelpDocumentation() string {
+	ret := "This tool estimates the fractal dimension of the surface around each cell of a " +
+		"digital elevation model (DEM), a measure of its roughness across scales, by the " +
+		"variogram method (Mark and Aronson, 1984, Mathematical Geology, 16(7), 671-683). " +
+		"Within a square window extending NeighbourhoodSize cells from the cell on each side, " +
+		"the semivariance of elevation is calculated at lags of 1 to MaxLag cells, from the " +
+		"pairs of cells in the same row or column of the window that are a lag apart. For a " +
+		"self-affine surface, the semivariance rises as the lag to the power 2H, where H is " +
+		"the Hurst exponent, and the fractal dimension is 3 - H, i.e. 3 minus half of the " +
+		"slope of the line fitted to the logarithms of semivariance and lag by least " +
+		"squares. Smooth surfaces have dimensions near 2 and rough ones near 3, though the " +
+		"estimates of windows whose variograms aren't linear on log-log axes may fall " +
+		"outside of that range. The window must hold at least two lags, i.e. MaxLag must be " +
+		"at least 2 and at most twice the NeighbourhoodSize. Cells whose windows have no " +
+		"variation at some lag, e.g. on flats, or lack pairs at some lag because of nodata, " +
+		"are assigned nodata."
+	return ret
+}
+
+func (this *FractalDimension) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FractalDimension) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "NeighbourhoodSize"
+	ret[2].Type = "int"
+	ret[2].Description = "The half-width of the window in grid cells"
+	ret[2].Default = "10"
+
+	ret[3].Name = "MaxLag"
+	ret[3].Type = "int"
+	ret[3].Description = "The greatest lag of the variogram in grid cells"
+	ret[3].Default = "5"
+
+	return ret
+}
+
+func (this *FractalDimension) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters, the integral images of the squared
+	// differences and numbers of pairs in rows and columns, and the sums of
+	// the regression
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) + gridBytes(rows+1, columns+1, 8+8+4+4) +
+		gridBytes(rows, columns, 8+8+1)
+}
+
+func (this *FractalDimension) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.neighbourhood = 10
+	this.maxLag = 5
+	values := []*int{&this.neighbourhood, &this.maxLag}
+	for i, v := range values {
+		if len(args) > i+2 && len(strings.TrimSpace(args[i+2])) > 0 && args[i+2] != "not specified" {
+			val, err := strconv.Atoi(strings.TrimSpace(args[i+2]))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FractalDimension) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the window size and the greatest lag
+	this.neighbourhood = 10
+	this.maxLag = 5
+	prompts := []string{"Window half-width in grid cells (default 10): ",
+		"Greatest lag in grid cells (default 5): "}
+	values := []*int{&this.neighbourhood, &this.maxLag}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.Atoi(strings.TrimSpace(str))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FractalDimension) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *FractalDimension) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *FractalDimension) Run() {
+	start1 := time.Now()
+
+	if this.neighbourhood < 1 || this.maxLag < 2 || this.maxLag > 2*this.neighbourhood {
+		println("The greatest lag must be at least 2 and at most twice the window half-width.")
+		return
+	}
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	dims := variogramFractalDimension(dem, this.neighbourhood, this.maxLag)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.PreferredPalette = "spectrum.pal"
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	numUndefined := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if d := dims[row*columns+col]; !math.IsNaN(d) {
+				rout.SetValue(row, col, d)
+			} else if dem.Value(row, col) != nodata {
+				numUndefined++
+			}
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FractalDimension tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Window half-width: %v", this.neighbourhood))
+	rout.AddMetadataEntry(fmt.Sprintf("Max. lag: %v", this.maxLag))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	if numUndefined > 0 {
+		printf("The fractal dimension was undefined for %v cells, which were assigned nodata.\n", numUndefined)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// variogramFractalDimension returns the fractal dimension of the DEM around
+// each cell, as row*columns+col, estimated from the semivariances at lags of
+// 1 to maxLag cells of the pairs of cells in the same row or column of a
+// window extending halfWidth cells on each side. It is NaN at nodata cells
+// and where some lag has no pairs or no variation.
+func variogramFractalDimension(dem *raster.Raster, halfWidth, maxLag int) []float64 {
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	stride := columns + 1
+
+	// the sums of the least-squares fit of log semivariance to log lag;
+	// those of the lags alone are the same for every cell
+	sumY := make([]float64, rows*columns)
+	sumXY := make([]float64, rows*columns)
+	undefined := make([]bool, rows*columns)
+	var sumX, sumXX float64
+
+	// integral images, with a leading row and column of zeros, of the squared
+	// differences, and the numbers, of the pairs a lag apart in a row, each
+	// assigned to its western cell, and in a column, assigned to its northern
+	// cell
+	rowSqr := make([]float64, (rows+1)*stride)
+	colSqr := make([]float64, (rows+1)*stride)
+	rowN := make([]int32, (rows+1)*stride)
+	colN := make([]int32, (rows+1)*stride)
+	boxSum := func(I []float64, N []int32, row1, col1, row2, col2 int) (float64, int32) {
+		if row1 < 0 {
+			row1 = 0
+		}
+		if col1 < 0 {
+			col1 = 0
+		}
+		if row2 >= rows {
+			row2 = rows - 1
+		}
+		if col2 >= columns {
+			col2 = columns - 1
+		}
+		if row2 < row1 || col2 < col1 {
+			return 0, 0
+		}
+		a, b, c, d := (row2+1)*stride+col2+1, row1*stride+col1, row1*stride+col2+1, (row2+1)*stride+col1
+		return I[a] + I[b] - I[c] - I[d], N[a] + N[b] - N[c] - N[d]
+	}
+
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for lag := 1; lag <= maxLag; lag++ {
+		for row := 0; row < rows; row++ {
+			var sumR, sumC float64
+			var nR, nC int32
+			for col := 0; col < columns; col++ {
+				if z := dem.Value(row, col); z != nodata {
+					if col+lag < columns {
+						if zN := dem.Value(row, col+lag); zN != nodata {
+							sumR += (z - zN) * (z - zN)
+							nR++
+						}
+					}
+					if row+lag < rows {
+						if zN := dem.Value(row+lag, col); zN != nodata {
+							sumC += (z - zN) * (z - zN)
+							nC++
+						}
+					}
+				}
+				i := (row+1)*stride + col + 1
+				rowSqr[i], rowN[i] = rowSqr[i-stride]+sumR, rowN[i-stride]+nR
+				colSqr[i], colN[i] = colSqr[i-stride]+sumC, colN[i-stride]+nC
+			}
+		}
+
+		x := math.Log(float64(lag))
+		sumX += x
+		sumXX += x * x
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				i := row*columns + col
+				if undefined[i] {
+					continue
+				}
+				if dem.Value(row, col) == nodata {
+					undefined[i] = true
+					continue
+				}
+				// the pairs both of whose cells are within the window
+				s1, n1 := boxSum(rowSqr, rowN, row-halfWidth, col-halfWidth, row+halfWidth, col+halfWidth-lag)
+				s2, n2 := boxSum(colSqr, colN, row-halfWidth, col-halfWidth, row+halfWidth-lag, col+halfWidth)
+				if n1+n2 == 0 || s1+s2 <= 0 {
+					undefined[i] = true
+					continue
+				}
+				y := math.Log((s1 + s2) / (2.0 * float64(n1+n2)))
+				sumY[i] += y
+				sumXY[i] += x * y
+			}
+			progress = int(100.0 * float64((lag-1)*rows+row+1) / float64(maxLag*rows))
+			if progress != oldProgress {
+				printf("\rProgress (lag %v of %v): %v%%%s", lag, maxLag, progress, eta.remaining(progress))
+				oldProgress = progress
+			}
+		}
+	}
+
+	// the slope of the fit is twice the Hurst exponent
+	n := float64(maxLag)
+	dims := make([]float64, rows*columns)
+	for i := range dims {
+		if undefined[i] {
+			dims[i] = math.NaN()
+			continue
+		}
+		slope := (n*sumXY[i] - sumX*sumY[i]) / (n*sumXX - sumX*sumX)
+		dims[i] = 3.0 - slope/2.0
+	}
+	return dims
+}
@@ -0,0 +1,448 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// FrontTravelTime propagates a wave front outward from a set of seed cells
+// over a DEM, with a slope-dependent celerity, and records the time at which
+// the front reaches each cell.
+type FrontTravelTime struct {
+	inputFile   string
+	seedFile    string
+	outputFile  string
+	velocity    float64
+	minVelocity float64
+	allowUphill bool
+	toolManager *PluginToolManager
+}
+
+func (this *FrontTravelTime) GetName() string {
+	s := "FrontTravelTime"
+	return getFormattedToolName(s)
+}
+
+func (this *FrontTravelTime) GetDescription() string {
+	s := "Front arrival times with slope-dependent celerity"
+	return getFormattedToolDescription(s)
+}
+
+func (this *FrontTravelTime) GetVersion() string {
+	return "1.0"
+}
+
+func (this *FrontTravelTime) GetHelpDocumentation() string {
+	ret := "This tool is a quick screening alternative to full hydraulic modelling. It " +
+		"propagates a front, e.g. a flood wave or a debris flow, outward from a set of seed " +
+		"cells over a DEM and outputs the time at which the front first reaches each cell. " +
+		"Seeds are read from a raster (cells that are neither zero nor nodata) or a text file " +
+		"of 'x y' points. The celerity of the front between neighbouring cells is " +
+		"v = Velocity * sqrt(S), where S is the downslope gradient between the two cells, and " +
+		"is never less than the MinVelocity, which applies on flats. Upslope moves are " +
+		"blocked unless AllowUphill is true, in which case they proceed at the MinVelocity. " +
+		"Arrival times are found with a priority-queue (Dijkstra) expansion and are in the " +
+		"DEM's horizontal units divided by the velocity units, e.g. seconds for metres and " +
+		"metres per second; the horizontal units of geographic DEMs are converted to metres. " +
+		"Cells that the front never reaches are assigned nodata."
+	return ret
+}
+
+func (this *FrontTravelTime) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *FrontTravelTime) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "SeedFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The seed raster or seed points file"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "Velocity"
+	ret[3].Type = "float64"
+	ret[3].Description = "The celerity at a gradient of 1"
+	ret[3].Default = "1.0"
+
+	ret[4].Name = "MinVelocity"
+	ret[4].Type = "float64"
+	ret[4].Description = "The minimum celerity, used on flats"
+	ret[4].Default = "0.01"
+
+	ret[5].Name = "AllowUphill"
+	ret[5].Type = "bool"
+	ret[5].Description = "Can the front move upslope?"
+	ret[5].Default = "false"
+
+	return ret
+}
+
+func (this *FrontTravelTime) EstimateMemory(rows, columns int) int64 {
+	// the DEM, the output, the arrival times and the solved flags
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+1)
+}
+
+func (this *FrontTravelTime) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input DEM, seed file, and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	seedFile := strings.TrimSpace(args[1])
+	if !strings.Contains(seedFile, pathSep) {
+		seedFile = this.toolManager.workingDirectory + seedFile
+	}
+	this.seedFile = seedFile
+	if _, err := os.Stat(this.seedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.seedFile)
+		return
+	}
+	outputFile := args[2]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.velocity = 1.0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setVelocity(args[3]) {
+			return
+		}
+	}
+	this.minVelocity = 0.01
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if !this.setMinVelocity(args[4]) {
+			return
+		}
+	}
+	this.allowUphill = false
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if this.allowUphill, err = strconv.ParseBool(strings.TrimSpace(args[5])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FrontTravelTime) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the seed file name
+	print("Enter the seed raster or points file name: ")
+	seedFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	seedFile = strings.TrimSpace(seedFile)
+	if !strings.Contains(seedFile, pathSep) {
+		seedFile = this.toolManager.workingDirectory + seedFile
+	}
+	this.seedFile = seedFile
+	if _, err := os.Stat(this.seedFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.seedFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the velocities
+	this.velocity = 1.0
+	print("Velocity at a gradient of 1 (default 1.0): ")
+	velocityStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(velocityStr)) > 0 {
+		if !this.setVelocity(velocityStr) {
+			return
+		}
+	}
+	this.minVelocity = 0.01
+	print("Minimum velocity (default 0.01): ")
+	minVelocityStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(minVelocityStr)) > 0 {
+		if !this.setMinVelocity(minVelocityStr) {
+			return
+		}
+	}
+
+	// allow upslope propagation?
+	this.allowUphill = false
+	print("Allow the front to move upslope (T or F; default F)? ")
+	uphillStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(uphillStr)) > 0 {
+		if this.allowUphill, err = strconv.ParseBool(strings.TrimSpace(uphillStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *FrontTravelTime) setVelocity(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The velocity must be greater than zero.")
+		return false
+	}
+	this.velocity = v
+	return true
+}
+
+func (this *FrontTravelTime) setMinVelocity(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The minimum velocity must be greater than zero.")
+		return false
+	}
+	this.minVelocity = v
+	return true
+}
+
+func (this *FrontTravelTime) Run() {
+	start1 := time.Now()
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	demConfig := dem.GetRasterConfig()
+
+	seeds, err := readSeedCells(this.seedFile, dem)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	cellSizeX := dem.GetCellSizeX()
+	cellSizeY := dem.GetCellSizeY()
+	if dem.IsInGeographicCoordinates() {
+		midLat := (dem.North + dem.South) / 2.0
+		cellSizeX *= 111320.0 * math.Cos(math.Pi/180.0*midLat)
+		cellSizeY *= 111320.0
+	}
+	var dist [8]float64
+	for n := 0; n < 8; n++ {
+		dist[n] = math.Hypot(float64(d8DX[n])*cellSizeX, float64(d8DY[n])*cellSizeY)
+	}
+
+	// arrival times; priorities are the bit patterns of the non-negative
+	// times, which sort in the same order as the times themselves
+	arrival := make([]float64, rows*columns)
+	for i := range arrival {
+		arrival[i] = math.Inf(1)
+	}
+	done := make([]bool, rows*columns)
+	pq := NewPQueue()
+	for _, s := range seeds {
+		i := s.row*columns + s.col
+		if arrival[i] != 0 {
+			arrival[i] = 0
+			pq.Push(newGridCell(s.row, s.col, i), 0)
+		}
+	}
+
+	v0 := this.velocity
+	minV := this.minVelocity
+	numValidCells := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if dem.Value(row, col) != nodata {
+				numValidCells++
+			}
+		}
+	}
+	numSolved := 0
+	oldProgress := -1
+	var eta progressETA
+	for pq.Len() > 0 {
+		gc := pq.Pop()
+		if done[gc.flatIndex] {
+			continue // a stale entry, superseded by an earlier arrival
+		}
+		done[gc.flatIndex] = true
+		row, col := gc.row, gc.column
+		t := arrival[gc.flatIndex]
+		z := dem.Value(row, col)
+		for n := 0; n < 8; n++ {
+			rowN, colN := row+d8DY[n], col+d8DX[n]
+			if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns {
+				continue
+			}
+			zN := dem.Value(rowN, colN)
+			if zN == nodata {
+				continue
+			}
+			v := minV
+			if zN < z {
+				if vs := v0 * math.Sqrt((z-zN)/dist[n]); vs > v {
+					v = vs
+				}
+			} else if zN > z && !this.allowUphill {
+				continue
+			}
+			iN := rowN*columns + colN
+			if done[iN] {
+				continue
+			}
+			if tN := t + dist[n]/v; tN < arrival[iN] {
+				arrival[iN] = tN
+				pq.Push(newGridCell(rowN, colN, iN), int64(math.Float64bits(tN)))
+			}
+		}
+		numSolved++
+		progress := int(100.0 * float64(numSolved) / float64(numValidCells))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	println("")
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "spectrum.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = demConfig.CoordinateRefSystemWKT
+	config.EPSGCode = demConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numReached := 0
+	maxTime := 0.0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			t := arrival[row*columns+col]
+			if math.IsInf(t, 1) || dem.Value(row, col) == nodata {
+				continue
+			}
+			rout.SetValue(row, col, t)
+			numReached++
+			if t > maxTime {
+				maxTime = t
+			}
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by FrontTravelTime tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Velocity: %v", v0))
+	rout.AddMetadataEntry(fmt.Sprintf("Minimum velocity: %v", minV))
+	rout.AddMetadataEntry(fmt.Sprintf("Allow uphill: %v", this.allowUphill))
+	rout.Save()
+
+	printf("Cells reached: %v of %v, maximum arrival time: %.3f\n", numReached, numValidCells, maxTime)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,578 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// GaussianRandomField simulates a stationary Gaussian random field with a
+// given variogram on the grid of a base raster, optionally conditioned on
+// point values.
+type GaussianRandomField struct {
+	baseFile    string
+	outputFile  string
+	pointsFile  string // empty for an unconditional field
+	model       variogramModel
+	vrange      float64
+	sill        float64 // the partial sill
+	nugget      float64
+	addToBase   bool
+	seed        int64
+	toolManager *PluginToolManager
+}
+
+// the number of nearest points used to condition each cell
+const conditioningNeighbours = 16
+
+func (this *GaussianRandomField) GetName() string {
+	s := "GaussianRandomField"
+	return getFormattedToolName(s)
+}
+
+func (this *GaussianRandomField) GetDescription() string {
+	s := "Simulates a Gaussian random field with a given variogram"
+	return getFormattedToolDescription(s)
+}
+
+func (this *GaussianRandomField) GetVersion() string {
+	return "1.0"
+}
+
+func (this *GaussianRandomField) GetHelpDocumentation() string {
+	ret := "This tool simulates a zero-mean Gaussian random field on the grid of the " +
+		"BaseRaster, whose nodata cells are nodata in the output, with a 'spherical' " +
+		"(default), 'exponential' or 'gaussian' variogram Model of the given Range, partial " +
+		"Sill and Nugget (default 0), as used by the Kriging tool; the Semivariogram tool " +
+		"estimates the variogram of a DEM. The field is simulated by FFT moving averages on a " +
+		"periodic grid padded by the range, and the nugget adds independent noise to each " +
+		"cell. If a PointsFile of x,y,z values, e.g. the errors of a DEM at check points, is " +
+		"given, the field is conditioned on them: it honours the values at the points and " +
+		"approaches an unconditional field away from them. Points outside the grid are " +
+		"ignored. If AddToBase is true, the field is added to the BaseRaster, e.g. a DEM, " +
+		"giving one realization of an uncertain surface. Realizations for Monte-Carlo " +
+		"analyses of DEM uncertainty, e.g. of the hydrology tools, are produced by running " +
+		"the tool with different Seeds; the default seed is taken from the clock."
+	return ret
+}
+
+func (this *GaussianRandomField) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *GaussianRandomField) GetArgDescriptions() []ToolArg {
+	numArgs := 9
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "BaseRaster"
+	ret[0].Type = "string"
+	ret[0].Description = "The raster whose grid is used, with directory and extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Model"
+	ret[2].Type = "string"
+	ret[2].Description = "spherical, exponential or gaussian"
+	ret[2].Default = "spherical"
+	ret[2].Choices = variogramModelNames
+
+	ret[3].Name = "Range"
+	ret[3].Type = "float64"
+	ret[3].Description = "The range of the variogram in map units"
+	ret[3].Required = true
+
+	ret[4].Name = "Sill"
+	ret[4].Type = "float64"
+	ret[4].Description = "The partial sill of the variogram"
+	ret[4].Required = true
+
+	ret[5].Name = "Nugget"
+	ret[5].Type = "float64"
+	ret[5].Description = "The nugget of the variogram"
+	ret[5].Default = "0"
+
+	ret[6].Name = "PointsFile"
+	ret[6].Type = "string"
+	ret[6].Description = "The x,y,z text file of conditioning values, with directory and extension"
+	ret[6].Role = ArgInput
+
+	ret[7].Name = "AddToBase"
+	ret[7].Type = "bool"
+	ret[7].Description = "Add the field to the base raster"
+	ret[7].Default = "false"
+
+	ret[8].Name = "Seed"
+	ret[8].Type = "int"
+	ret[8].Description = "The random seed"
+
+	return ret
+}
+
+func (this *GaussianRandomField) EstimateMemory(rows, columns int) int64 {
+	// the base and output rasters and the padded complex grid and its
+	// spectrum, which is at least twice the size of the raster in each
+	// direction for a range of the grid's size
+	n := 1
+	for n < 2*rows || n < 2*columns {
+		n *= 2
+	}
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) + gridBytes(n, n, 16+8)
+}
+
+func (this *GaussianRandomField) ParseArguments(args []string) {
+	if len(args) < 5 {
+		println("The base raster, output file, model, range, and sill must be specified.")
+		return
+	}
+	var ok bool
+	if this.baseFile, ok = this.inputName(args[0]); !ok {
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	this.model = sphericalModel
+	if specified(2) {
+		if this.model, err = parseVariogramModel(args[2]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	if this.vrange, err = strconv.ParseFloat(strings.TrimSpace(args[3]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	if this.sill, err = strconv.ParseFloat(strings.TrimSpace(args[4]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	this.nugget = 0
+	if specified(5) {
+		if this.nugget, err = strconv.ParseFloat(strings.TrimSpace(args[5]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.pointsFile = ""
+	if specified(6) {
+		if this.pointsFile, ok = this.inputName(args[6]); !ok {
+			return
+		}
+	}
+	this.addToBase = false
+	if specified(7) {
+		if this.addToBase, err = strconv.ParseBool(strings.TrimSpace(args[7])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.seed = time.Now().UnixNano()
+	if specified(8) {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(args[8]), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *GaussianRandomField) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the base raster file name
+	print("Enter the base raster file name (incl. file extension): ")
+	baseFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	var ok bool
+	if this.baseFile, ok = this.inputName(baseFile); !ok {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the variogram model
+	print("Variogram model, spherical, exponential or gaussian (blank for spherical): ")
+	model, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.model = sphericalModel
+	if len(strings.TrimSpace(model)) > 0 {
+		if this.model, err = parseVariogramModel(model); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the variogram parameters
+	this.nugget = 0
+	prompts := []string{"Range in map units: ", "Partial sill: ", "Nugget (default 0): "}
+	values := []*float64{&this.vrange, &this.sill, &this.nugget}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if i < 2 || len(strings.TrimSpace(str)) > 0 {
+			if *v, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	// get the points file name
+	print("Conditioning x,y,z text file name (blank for none): ")
+	pointsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.pointsFile = ""
+	if len(strings.TrimSpace(pointsFile)) > 0 {
+		if this.pointsFile, ok = this.inputName(pointsFile); !ok {
+			return
+		}
+	}
+
+	// add the field to the base raster?
+	print("Add the field to the base raster (T or F, default F)? ")
+	addStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.addToBase = false
+	if len(strings.TrimSpace(addStr)) > 0 {
+		if this.addToBase, err = strconv.ParseBool(strings.TrimSpace(addStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the seed
+	this.seed = time.Now().UnixNano()
+	print("Random seed (blank for none): ")
+	seedStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(seedStr)) > 0 {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(seedStr), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+// inputName returns the full name of an input file and whether it exists.
+func (this *GaussianRandomField) inputName(s string) (string, bool) {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", inputFile)
+		return inputFile, false
+	}
+	return inputFile, true
+}
+
+func (this *GaussianRandomField) Run() {
+	start1 := time.Now()
+
+	if this.vrange <= 0 || this.sill < 0 || this.nugget < 0 || this.sill+this.nugget == 0 {
+		println("The range must be greater than zero, the sill and nugget can't be negative, and one of them must be greater than zero.")
+		return
+	}
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.baseFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var xs, ys, zs []float64
+	if this.pointsFile != "" {
+		println("Reading point data...")
+		if xs, ys, zs, err = readXYZFile(this.pointsFile); err != nil {
+			println(err.Error())
+			return
+		}
+		xs, ys, zs = mergeCoincidentPoints(xs, ys, zs)
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+	v := variogram{model: this.model, nugget: this.nugget, sill: this.sill, vrange: this.vrange}
+	printf("Variogram: %s, range %.4f, partial sill %.6f, nugget %.6f\n", v.model, v.vrange, v.sill, v.nugget)
+	printf("Seed: %v\n", this.seed)
+	rng := rand.New(rand.NewSource(this.seed))
+
+	field := make([][]float64, rows)
+	for row := range field {
+		field[row] = make([]float64, columns)
+	}
+
+	// the correlated part of the field
+	if v.sill > 0 {
+		println("Simulating the field...")
+		sim := newFieldSimulator(rows, columns, cellSizeX, cellSizeY, v)
+		printf("FFT grid: %v x %v\n", sim.n, sim.n)
+		sim.simulate(rng, field)
+	}
+	if v.nugget > 0 {
+		sd := math.Sqrt(v.nugget)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				field[row][col] += sd * rng.NormFloat64()
+			}
+		}
+	}
+
+	// conditioning kriges the differences between the point values and the
+	// unconditional field at the points and adds them to the field
+	numPoints := 0
+	if len(xs) > 0 {
+		println("Conditioning the field...")
+		var rx, ry, rz []float64
+		for i := range xs {
+			col := int(math.Floor((xs[i] - rin.West) / cellSizeX))
+			row := int(math.Floor((rin.North - ys[i]) / cellSizeY))
+			if row < 0 || row >= rows || col < 0 || col >= columns {
+				continue
+			}
+			rx, ry, rz = append(rx, xs[i]), append(ry, ys[i]), append(rz, zs[i]-field[row][col])
+		}
+		numPoints = len(rx)
+		printf("Number of conditioning points in the grid: %v\n", numPoints)
+		if numPoints > 0 {
+			k := conditioningNeighbours
+			if k > numPoints {
+				k = numPoints
+			}
+			kr := newOrdinaryKriging(rx, ry, rz, v, k)
+			numCPUs := this.toolManager.numThreads()
+			c1 := make(chan bool, rows)
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			for cpu := 0; cpu < numCPUs; cpu++ {
+				wg.Add(1)
+				go func(cpu int) {
+					defer wg.Done()
+					estimate := kr.estimator()
+					for row := cpu; row < rows; row += numCPUs {
+						if interrupted() {
+							c1 <- true
+							continue
+						}
+						y := rin.North - (float64(row)+0.5)*cellSizeY
+						for col := 0; col < columns; col++ {
+							x := rin.West + (float64(col)+0.5)*cellSizeX
+							if r, _, err := estimate(x, y); err == nil {
+								field[row][col] += r
+							}
+						}
+						c1 <- true
+					}
+				}(cpu)
+			}
+
+			var progress, oldProgress int
+			var eta progressETA
+			oldProgress = -1
+			for row := 0; row < rows; row++ {
+				<-c1
+				progress = int(100.0 * float64(row+1) / float64(rows))
+				if progress != oldProgress {
+					printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+					oldProgress = progress
+				}
+			}
+			printf("\n")
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	var sum, sumSqr, n float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := rin.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			f := field[row][col]
+			sum += f
+			sumSqr += f * f
+			n++
+			if this.addToBase {
+				f += z
+			}
+			rout.SetValue(row, col, f)
+		}
+	}
+	if n > 0 {
+		mean := sum / n
+		printf("Field mean: %.6f, standard deviation: %.6f\n", mean, math.Sqrt(math.Max(sumSqr/n-mean*mean, 0)))
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by GaussianRandomField tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Base raster: %s", this.baseFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Variogram: %s, range %v, partial sill %v, nugget %v",
+		v.model, v.vrange, v.sill, v.nugget))
+	if numPoints > 0 {
+		rout.AddMetadataEntry(fmt.Sprintf("Conditioned on %v points of %s", numPoints, this.pointsFile))
+	}
+	rout.AddMetadataEntry(fmt.Sprintf("Added to base: %v, seed: %v", this.addToBase, this.seed))
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// fieldSimulator simulates the correlated part of Gaussian random fields,
+// with the covariance of the partial sill of a variogram, on a grid: white
+// noise convolved with the square root of the covariance, by FFT on a
+// periodic grid that is padded by the range, so that the field doesn't wrap
+// around. The covariance of the exponential and Gaussian models is small
+// beyond their practical range. Its spectrum is computed once, so that many
+// fields can be simulated, e.g. as error realizations.
+type fieldSimulator struct {
+	rows, columns int
+	n             int         // the size of the periodic grid
+	amplitude     [][]float64 // the square roots of the eigenvalues of the covariance
+}
+
+func newFieldSimulator(rows, columns int, cellSizeX, cellSizeY float64, v variogram) *fieldSimulator {
+	padding := int(math.Ceil(v.vrange / math.Min(cellSizeX, cellSizeY)))
+	n := 1
+	for n < rows+padding || n < columns+padding {
+		n *= 2
+	}
+	wrapped := func(i int) float64 {
+		if i > n/2 {
+			return float64(n - i)
+		}
+		return float64(i)
+	}
+	data := make([][]complex128, n)
+	for i := range data {
+		data[i] = make([]complex128, n)
+		dy := wrapped(i) * cellSizeY
+		for j := range data[i] {
+			dx := wrapped(j) * cellSizeX
+			data[i][j] = complex(v.sill*(1-v.model.shape(math.Hypot(dx, dy), v.vrange)), 0)
+		}
+	}
+	fft2d(data, false)
+	// the eigenvalues of the periodic covariance; the small negative ones of
+	// a truncated covariance are set to zero
+	amplitude := make([][]float64, n)
+	for i := range data {
+		amplitude[i] = make([]float64, n)
+		for j := range data[i] {
+			amplitude[i][j] = math.Sqrt(math.Max(real(data[i][j]), 0))
+		}
+	}
+	return &fieldSimulator{rows: rows, columns: columns, n: n, amplitude: amplitude}
+}
+
+// simulate sets field, of rows x columns, to a realization drawn with rng.
+func (sim *fieldSimulator) simulate(rng *rand.Rand, field [][]float64) {
+	n := sim.n
+	data := make([][]complex128, n)
+	for i := range data {
+		data[i] = make([]complex128, n)
+		for j := range data[i] {
+			data[i][j] = complex(rng.NormFloat64(), 0)
+		}
+	}
+	fft2d(data, false)
+	for i := range data {
+		for j := range data[i] {
+			data[i][j] *= complex(sim.amplitude[i][j], 0)
+		}
+	}
+	fft2d(data, true)
+	for row := 0; row < sim.rows; row++ {
+		for col := 0; col < sim.columns; col++ {
+			field[row][col] = real(data[row][col])
+		}
+	}
+}
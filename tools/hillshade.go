@@ -13,6 +13,7 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,9 +22,13 @@ import (
 )
 
 type Hillshade struct {
-	inputFile   string
-	outputFile  string
-	toolManager *PluginToolManager
+	inputFile        string
+	outputFile       string
+	azimuth          float64
+	altitude         float64
+	zFactor          float64
+	multidirectional bool
+	toolManager      *PluginToolManager
 }
 
 func (this *Hillshade) GetName() string {
@@ -37,8 +42,25 @@ func (this *Hillshade) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *Hillshade) GetVersion() string {
+	return "1.1"
+}
+
 func (this *Hillshade) GetHelpDocumentation() string {
-	ret := ""
+	ret := "This tool calculates the shaded relief of a digital elevation model (DEM), i.e. " +
+		"the brightness of its surface under a light source at the given Azimuth, in " +
+		"degrees clockwise from north (default 315), and Altitude, in degrees above the " +
+		"horizon (default 30). The surface gradient is estimated by the method of Horn " +
+		"(1981), with the elevations multiplied by the ZFactor (default 1), e.g. to " +
+		"exaggerate the relief or to convert elevations in feet to the units of the XY " +
+		"coordinates. The cell sizes of a DEM in geographic coordinates are converted " +
+		"to metres at its mid-latitude, so its elevations should be in metres. If " +
+		"MultiDirectional is true, the surface is lit from the azimuths 225, 270, 315 " +
+		"and 360, with weights of 0.1, 0.4, 0.4 and 0.1, and the Azimuth is ignored, " +
+		"which brings out features of any orientation. The output is an 8-bit raster " +
+		"whose values range from 1, in full shadow, to 255, facing the light, with 0 " +
+		"for the nodata cells of the DEM, and whose display range is trimmed by 1% at " +
+		"each end."
 	return ret
 }
 
@@ -46,20 +68,41 @@ func (this *Hillshade) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *Hillshade) GetArgDescriptions() [][]string {
-	numArgs := 2
-
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM File name, with directory and file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+func (this *Hillshade) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Azimuth"
+	ret[2].Type = "float64"
+	ret[2].Description = "The direction of the light source, in degrees clockwise from north"
+	ret[2].Default = "315"
+
+	ret[3].Name = "Altitude"
+	ret[3].Type = "float64"
+	ret[3].Description = "The elevation of the light source, in degrees above the horizon"
+	ret[3].Default = "30"
+
+	ret[4].Name = "ZFactor"
+	ret[4].Type = "float64"
+	ret[4].Description = "The multiplier of the elevations"
+	ret[4].Default = "1"
+
+	ret[5].Name = "MultiDirectional"
+	ret[5].Type = "bool"
+	ret[5].Description = "Whether to light the surface from several directions"
+	ret[5].Default = "false"
 
 	return ret
 }
@@ -72,7 +115,7 @@ func (this *Hillshade) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -83,10 +126,30 @@ func (this *Hillshade) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	this.azimuth, this.altitude, this.zFactor = 315.0, 30.0, 1.0
+	values := []*float64{&this.azimuth, &this.altitude, &this.zFactor}
+	for i, v := range values {
+		if len(args) > i+2 && len(strings.TrimSpace(args[i+2])) > 0 && args[i+2] != "not specified" {
+			val, err := strconv.ParseFloat(strings.TrimSpace(args[i+2]), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+	this.multidirectional = false
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if this.multidirectional, err = strconv.ParseBool(strings.TrimSpace(args[5])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
 	this.Run()
 }
 
@@ -105,7 +168,7 @@ func (this *Hillshade) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -122,27 +185,95 @@ func (this *Hillshade) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	// get the light source and the z-factor
+	this.azimuth, this.altitude, this.zFactor = 315.0, 30.0, 1.0
+	prompts := []string{"Azimuth of the light source in degrees (default 315): ",
+		"Altitude of the light source in degrees (default 30): ", "Z-factor (default 1): "}
+	values := []*float64{&this.azimuth, &this.altitude, &this.zFactor}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	// get whether the hillshade is multidirectional
+	print("Light from multiple directions (T or F, default F)? ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.multidirectional = false
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.multidirectional, err = strconv.ParseBool(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
 	this.Run()
 }
 
+// The azimuths and weights of the light sources of a multidirectional
+// hillshade.
+var multidirectionalAzimuths = []float64{225, 270, 315, 360}
+var multidirectionalWeights = []float64{0.1, 0.4, 0.4, 0.1}
+
+// hillshade returns the brightness, from 0 to 1, of a surface with the
+// derivatives d lit from the altitude and the azimuths, in radians, with the
+// given weights, which sum to 1.
+func hillshade(d surfaceDerivatives, altitude float64, azimuths, weights []float64) float64 {
+	var value float64
+	norm := math.Sqrt(1 + d.p*d.p + d.q*d.q)
+	for i, azimuth := range azimuths {
+		// the cosine of the angle between the surface normal and the light
+		shade := (math.Sin(altitude) - math.Cos(altitude)*(d.p*math.Sin(azimuth)+d.q*math.Cos(azimuth))) / norm
+		if shade > 0 {
+			value += weights[i] * shade
+		}
+	}
+	return value
+}
+
 func (this *Hillshade) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress int
+	var eta progressETA
 
-	azimuth := (315.0 - 90.0) * DegToRad
-	altitude := 30.0 * DegToRad
-	sinTheta := math.Sin(altitude)
-	cosTheta := math.Cos(altitude)
+	if this.altitude <= 0 || this.altitude > 90 || this.zFactor == 0 {
+		println("The altitude must be between 0 and 90 degrees and the z-factor can't be 0.")
+		return
+	}
+	altitude := this.altitude * DegToRad
+	azimuths := []float64{this.azimuth * DegToRad}
+	weights := []float64{1}
+	if this.multidirectional {
+		azimuths = make([]float64, len(multidirectionalAzimuths))
+		for i, a := range multidirectionalAzimuths {
+			azimuths[i] = a * DegToRad
+		}
+		weights = multidirectionalWeights
+	}
 
 	println("Reading raster data...")
 	rin, err := raster.CreateRasterFromFile(this.inputFile)
 	if err != nil {
 		println(err.Error())
+		return
 	}
 
 	start2 := time.Now()
@@ -150,19 +281,16 @@ func (this *Hillshade) Run() {
 	rows := rin.Rows
 	columns := rin.Columns
 	rowsLessOne := rows - 1
-	nodata := rin.NoDataValue
 	inConfig := rin.GetRasterConfig()
-	gridRes := (rin.GetCellSizeX() + rin.GetCellSizeY()) / 2.0
-	eightGridRes := 8 * gridRes
-	const radToDeg float64 = 180.0 / math.Pi
-	rin.GetRasterConfig()
+	cellSizeX, cellSizeY := metricCellSizes(rin)
 
 	// create the output raster
+	const outNodata = 0.0
 	config := raster.NewDefaultRasterConfig()
 	config.PreferredPalette = "grey.pal"
-	config.DataType = raster.DT_FLOAT32
-	config.NoDataValue = nodata
-	config.InitialValue = nodata
+	config.DataType = raster.DT_UINT8
+	config.NoDataValue = outNodata
+	config.InitialValue = outNodata
 	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
 	config.EPSGCode = inConfig.EPSGCode
 	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
@@ -172,20 +300,12 @@ func (this *Hillshade) Run() {
 		return
 	}
 
-	zConvFactor := 1.0
-	if rin.IsInGeographicCoordinates() {
-		// calculate a new z-conversion factor
-		midLat := (rin.North - rin.South) / 2.0
-		if midLat <= 90 && midLat >= -90 {
-			zConvFactor = 1.0 / (113200 * math.Cos(math.Pi/180.0*midLat))
-		}
-	}
-
-	numCPUs := runtime.NumCPU()
-	c1 := make(chan [256]int)
-	c2 := make(chan int)
+	numCPUs := this.toolManager.numThreads()
+	c1 := make(chan [256]int, rows)
+	c2 := make(chan int, rows)
 	runtime.GOMAXPROCS(numCPUs)
 	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	// calculate hillshade
 	printf("\r                                                    ")
@@ -205,51 +325,27 @@ func (this *Hillshade) Run() {
 		wg.Add(1)
 		go func(rowSt, rowEnd, k int) {
 			defer wg.Done()
-			var z, zN, fy, fx, value, tanSlope, aspect, term1, term2, term3 float64
-			dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
-			dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
-			N := [8]float64{}
 			for row := rowSt; row <= rowEnd; row++ {
+				if interrupted() {
+					c1 <- [256]int{}
+					c2 <- 0
+					continue
+				}
 				rowHisto := [256]int{}
 				rowNumCells := 0
 				floatData := make([]float64, columns)
 				for col := 0; col < columns; col++ {
-					z = rin.Value(row, col)
-					if z != nodata {
-						z *= zConvFactor
-						for n := 0; n < 8; n++ {
-							zN = rin.Value(row+dY[n], col+dX[n])
-							if zN != nodata {
-								N[n] = zN * zConvFactor
-							} else {
-								N[n] = z
-							}
-						}
-
-						fy = (N[6] - N[4] + 2*(N[7]-N[3]) + N[0] - N[2]) / eightGridRes
-						fx = (N[2] - N[4] + 2*(N[1]-N[5]) + N[0] - N[6]) / eightGridRes
-
-						if fx != 0 {
-							tanSlope = math.Sqrt(fx*fx + fy*fy)
-							aspect = (180 - math.Atan(fy/fx)*RadToDeg + 90*(fx/math.Abs(fx))) * DegToRad
-							term1 = tanSlope / math.Sqrt(1+tanSlope*tanSlope)
-							term2 = sinTheta / tanSlope
-							term3 = cosTheta * math.Sin(azimuth-aspect)
-							z = term1 * (term2 - term3)
-						} else {
-							z = 0.5
-						}
-
-						value = math.Floor(z * 255)
-						if value < 0 {
-							value = 0
-						}
-						floatData[col] = value
-						rowHisto[int(value)]++
-						rowNumCells++
-					} else {
-						floatData[col] = nodata
+					d, ok := hornDerivatives(rin, row, col, cellSizeX, cellSizeY)
+					if !ok {
+						floatData[col] = outNodata
+						continue
 					}
+					d.p *= this.zFactor
+					d.q *= this.zFactor
+					value := 1 + math.Floor(hillshade(d, altitude, azimuths, weights)*254)
+					floatData[col] = value
+					rowHisto[int(value)]++
+					rowNumCells++
 				}
 				rout.SetRowValues(row, floatData)
 				c1 <- rowHisto // row completed
@@ -271,7 +367,7 @@ func (this *Hillshade) Run() {
 		numCells += rowNumCells
 		progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
 		if progress != oldProgress {
-			printf("\rProgress: %v%%", progress)
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -311,8 +407,16 @@ func (this *Hillshade) Run() {
 		rout.SetDisplayMaximum(newMax)
 	}
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by Slope"))
-	rout.Save()
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Hillshade tool (%s)", this.toolManager.toolVersion(this)))
+	if this.multidirectional {
+		rout.AddMetadataEntry(fmt.Sprintf("Multidirectional, altitude: %v, z-factor: %v", this.altitude, this.zFactor))
+	} else {
+		rout.AddMetadataEntry(fmt.Sprintf("Azimuth: %v, altitude: %v, z-factor: %v", this.azimuth, this.altitude, this.zFactor))
+	}
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
 
 	println("Operation complete!")
 
@@ -0,0 +1,127 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// interruptRequested is set when the user asks the running tool to stop, and
+// toolsRunning counts the tool runs in progress, including nested ones.
+var interruptRequested, toolsRunning int32
+
+// interruption is the panic with which a tool stops at a safe point, and
+// which the tool manager recovers from.
+type interruption struct {
+	tool  string
+	where string
+}
+
+// InterruptedError is returned by Run and RunWithArguments when a tool is
+// stopped by an interrupt, e.g. Ctrl-C.
+type InterruptedError struct {
+	Tool    string   // the tool that was stopped, e.g. the one run by BatchTiles
+	Where   string   // the point at which it stopped
+	Removed []string // the incomplete outputs that were removed
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("%s was interrupted %s. %s", e.Tool, e.Where, describeRemoved(e.Removed))
+}
+
+func describeRemoved(removed []string) string {
+	if len(removed) == 0 {
+		return "No incomplete outputs were left."
+	}
+	return fmt.Sprintf("Its incomplete outputs were removed: %s.", strings.Join(removed, ", "))
+}
+
+// HandleInterrupts traps interrupts (Ctrl-C) and SIGTERM. The first interrupt
+// of a running tool asks it to stop at its next safe point, i.e. its next
+// progress update, or the next tile of BatchTiles, where the outputs it
+// hasn't finished writing are removed and Run or RunWithArguments returns an
+// *InterruptedError; outputs that were completely written, e.g. those of the
+// earlier tiles of BatchTiles, are kept. A second interrupt removes the
+// incomplete outputs and exits at once, as does an interrupt when no tool is
+// running.
+func (ptm *PluginToolManager) HandleInterrupts() {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range c {
+			if atomic.LoadInt32(&toolsRunning) == 0 {
+				os.Exit(130)
+			}
+			if atomic.CompareAndSwapInt32(&interruptRequested, 0, 1) {
+				printf("\nInterrupted; stopping at the next safe point (interrupt again to stop at once)...\n")
+				continue
+			}
+			removed := raster.RemoveIncompleteOutputs()
+			fmt.Fprintf(os.Stderr, "\nStopped at once. %s\n", describeRemoved(removed))
+			os.Exit(130)
+		}
+	}()
+}
+
+// checkInterrupt stops the running tool if it has been interrupted. It is
+// called at safe points, i.e. where no output is being written, and must be
+// called from the goroutine that runs the tool; where describes the point,
+// e.g. "at 45% of a step".
+func checkInterrupt(where string) {
+	if atomic.LoadInt32(&interruptRequested) != 0 {
+		panic(interruption{where: where})
+	}
+}
+
+// interrupted reports whether the running tool has been asked to stop. Only
+// the goroutine that runs a tool can stop it, so the worker goroutines of the
+// parallel tools check this before each row, or other unit of work, and skip
+// the work once it is set. They still report each unit, on channels buffered
+// to hold every report, so that the tool reaches its next progress update and
+// stops there, and the tool waits for them as it unwinds, so that none is
+// left running or blocked.
+func interrupted() bool {
+	return atomic.LoadInt32(&interruptRequested) != 0
+}
+
+// runTool runs a tool with the tool manager, recovering from an interruption
+// at a safe point. Nested runs, e.g. those of BatchTiles, pass the
+// interruption on, so that the whole run stops, and only the outermost
+// removes the incomplete outputs.
+func (ptm *PluginToolManager) runTool(tool PluginTool, run func()) (err error) {
+	outermost := atomic.AddInt32(&toolsRunning, 1) == 1
+	if outermost {
+		atomic.StoreInt32(&interruptRequested, 0)
+		raster.ForgetIncompleteOutputs()
+	}
+	defer func() {
+		atomic.AddInt32(&toolsRunning, -1)
+		if r := recover(); r != nil {
+			i, ok := r.(interruption)
+			if !ok {
+				panic(r)
+			}
+			if i.tool == "" {
+				i.tool = tool.GetName()
+			}
+			if !outermost {
+				panic(i)
+			}
+			err = &InterruptedError{Tool: i.tool, Where: i.where, Removed: raster.RemoveIncompleteOutputs()}
+		}
+		if outermost && atomic.SwapInt32(&interruptRequested, 0) != 0 && err == nil {
+			printf("\nThe interrupt came as %s finished; its outputs are complete.\n", tool.GetName())
+		}
+	}()
+	run()
+	return nil
+}
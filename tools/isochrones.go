@@ -0,0 +1,494 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Isochrones calculates the travel time of flow from each cell of a DEM
+// along its D8 flowpath to the outlet or to a stream, and optionally the
+// isochrone zones of equal travel time.
+type Isochrones struct {
+	inputFile    string
+	outputFile   string
+	velocity     float64
+	minVelocity  float64
+	velocityFile string // empty for a constant velocity coefficient
+	streamsFile  string // empty for travel times to the outlets
+	interval     float64
+	zonesFile    string // empty if the zones aren't written
+	toolManager  *PluginToolManager
+}
+
+func (this *Isochrones) GetName() string {
+	s := "Isochrones"
+	return getFormattedToolName(s)
+}
+
+func (this *Isochrones) GetDescription() string {
+	s := "Calculates travel times and isochrones along D8 flowpaths"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Isochrones) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Isochrones) GetHelpDocumentation() string {
+	ret := "This tool calculates the time that flow takes to travel from each cell of a " +
+		"digital elevation model (DEM) along its D8 flowpath, the path of steepest descent " +
+		"that D8FlowAccumulation routes flow along, to the outlet at the end of the path, " +
+		"e.g. at the edge of the DEM, or, if a StreamsFile is given, to the first stream " +
+		"cell (a value greater than zero) that it reaches. The velocity over each step is " +
+		"v = k sqrt(S), where S is the gradient down to the next cell, as in the SCS " +
+		"velocity method, and is never less than the MinVelocity (default 0.01), e.g. " +
+		"on gentle slopes. The coefficient k, the velocity at a gradient of 1, is the " +
+		"Velocity (default 1.0), or, if a VelocityFile is given, its value at the cell, " +
+		"e.g. from a land cover map reclassified to the coefficients of overland, shallow " +
+		"concentrated and channel flow; its nodata cells take the Velocity. Travel times are " +
+		"in seconds for velocities in metres per second, with cell sizes in geographic " +
+		"coordinates converted to metres. Cells whose paths end in a pit or flat, or " +
+		"don't reach a stream, are timed to the end of their paths, so the DEM should be breached or " +
+		"filled first. If a ZoneInterval greater than zero and a ZonesFile are given, the " +
+		"isochrone zones, numbered from 1 for the travel times less than the interval, are " +
+		"also written, e.g. for the time-area diagram of a unit hydrograph."
+	return ret
+}
+
+func (this *Isochrones) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Isochrones) GetArgDescriptions() []ToolArg {
+	numArgs := 8
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output travel time filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Velocity"
+	ret[2].Type = "float64"
+	ret[2].Description = "The velocity at a gradient of 1"
+	ret[2].Default = "1.0"
+
+	ret[3].Name = "MinVelocity"
+	ret[3].Type = "float64"
+	ret[3].Description = "The minimum velocity, e.g. on gentle slopes"
+	ret[3].Default = "0.01"
+
+	ret[4].Name = "VelocityFile"
+	ret[4].Type = "string"
+	ret[4].Description = "The optional raster of velocities at a gradient of 1, e.g. from land cover"
+	ret[4].Role = ArgInput
+
+	ret[5].Name = "StreamsFile"
+	ret[5].Type = "string"
+	ret[5].Description = "The optional streams raster that flow is timed to"
+	ret[5].Role = ArgInput
+
+	ret[6].Name = "ZoneInterval"
+	ret[6].Type = "float64"
+	ret[6].Description = "The travel time interval of the isochrone zones"
+	ret[6].Default = "0"
+
+	ret[7].Name = "ZonesFile"
+	ret[7].Type = "string"
+	ret[7].Description = "The optional output isochrone zones filename, with directory and file extension"
+	ret[7].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *Isochrones) EstimateMemory(rows, columns int) int64 {
+	// the DEM, velocity, streams, travel time and zones rasters, the flow
+	// directions, the targets and the travel times
+	return gridBytes(rows, columns, 5*rasterBytesPerCell+1+1+8)
+}
+
+func (this *Isochrones) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	var ok bool
+	if this.inputFile, ok = this.inputName(args[0]); !ok {
+		return
+	}
+	this.outputFile = this.outputName(args[1])
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	var err error
+	this.velocity, this.minVelocity = 1.0, 0.01
+	values := []*float64{&this.velocity, &this.minVelocity}
+	for i, v := range values {
+		if specified(i + 2) {
+			if *v, err = strconv.ParseFloat(strings.TrimSpace(args[i+2]), 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+	this.velocityFile, this.streamsFile = "", ""
+	if specified(4) {
+		if this.velocityFile, ok = this.inputName(args[4]); !ok {
+			return
+		}
+	}
+	if specified(5) {
+		if this.streamsFile, ok = this.inputName(args[5]); !ok {
+			return
+		}
+	}
+	this.interval = 0
+	if specified(6) {
+		if this.interval, err = strconv.ParseFloat(strings.TrimSpace(args[6]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.zonesFile = ""
+	if specified(7) {
+		this.zonesFile = this.outputName(args[7])
+	}
+
+	this.Run()
+}
+
+func (this *Isochrones) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+	readLine := func(prompt string) string {
+		print(prompt)
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		return strings.TrimSpace(str)
+	}
+
+	var ok bool
+	if this.inputFile, ok = this.inputName(readLine("Enter the DEM file name (incl. file extension): ")); !ok {
+		return
+	}
+	this.outputFile = this.outputName(readLine("Enter the output travel time file name (incl. file extension): "))
+
+	// get the velocities
+	var err error
+	this.velocity, this.minVelocity = 1.0, 0.01
+	prompts := []string{"Velocity at a gradient of 1 (default 1.0): ", "Minimum velocity (default 0.01): "}
+	values := []*float64{&this.velocity, &this.minVelocity}
+	for i, v := range values {
+		if str := readLine(prompts[i]); str != "" {
+			if *v, err = strconv.ParseFloat(str, 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	// get the optional velocity and streams files
+	this.velocityFile, this.streamsFile = "", ""
+	if str := readLine("Enter the velocity file name (blank for none): "); str != "" {
+		if this.velocityFile, ok = this.inputName(str); !ok {
+			return
+		}
+	}
+	if str := readLine("Enter the streams file name (blank to time flow to the outlets): "); str != "" {
+		if this.streamsFile, ok = this.inputName(str); !ok {
+			return
+		}
+	}
+
+	// get the zones
+	this.interval, this.zonesFile = 0, ""
+	if str := readLine("Isochrone zone interval (blank for no zones): "); str != "" {
+		if this.interval, err = strconv.ParseFloat(str, 64); err != nil {
+			println(err.Error())
+			return
+		}
+		this.zonesFile = this.outputName(readLine("Enter the output zones file name (incl. file extension): "))
+	}
+
+	this.Run()
+}
+
+func (this *Isochrones) inputName(s string) (string, bool) {
+	fileName := strings.TrimSpace(s)
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	if !raster.FileExists(fileName) {
+		printf("no such file or directory: %s\n", fileName)
+		return fileName, false
+	}
+	return fileName, true
+}
+
+func (this *Isochrones) outputName(s string) string {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	return outputFile
+}
+
+func (this *Isochrones) Run() {
+	start1 := time.Now()
+
+	if this.velocity <= 0 || this.minVelocity <= 0 || this.interval < 0 {
+		println("The velocity and minimum velocity must be greater than zero, and the zone interval can't be negative.")
+		return
+	}
+	if this.zonesFile != "" && this.interval == 0 {
+		println("A zone interval greater than zero must be given for the zones file.")
+		return
+	}
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+	var velocities, streams *raster.Raster
+	if this.velocityFile != "" {
+		if velocities, err = raster.CreateRasterFromFile(this.velocityFile); err != nil {
+			println(err.Error())
+			return
+		}
+		if !onSameGrid(dem, velocities) {
+			println("The DEM and velocity rasters must have the same dimensions.")
+			return
+		}
+	}
+	if this.streamsFile != "" {
+		if streams, err = raster.CreateRasterFromFile(this.streamsFile); err != nil {
+			println(err.Error())
+			return
+		}
+		if !onSameGrid(dem, streams) {
+			println("The DEM and streams rasters must have the same dimensions.")
+			return
+		}
+	}
+
+	start2 := time.Now()
+
+	k := velocityCoefficients(rows, columns, velocities, this.velocity)
+	var isTarget []bool
+	if streams != nil {
+		isTarget = make([]bool, rows*columns)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				if s := streams.Value(row, col); s != streams.NoDataValue && s > 0 {
+					isTarget[row*columns+col] = true
+				}
+			}
+		}
+	}
+	fd := FlowDirectionsFromDEM(dem)
+	times := travelTimes(dem, fd, k, this.minVelocity, isTarget)
+
+	// create the output rasters
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "spectrum.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	var zout *raster.Raster
+	if this.zonesFile != "" {
+		zconfig := raster.NewDefaultRasterConfig()
+		zconfig.PreferredPalette = "spectrum.pal"
+		zconfig.DataType = raster.DT_INT32
+		zconfig.NoDataValue = -32768
+		zconfig.InitialValue = -32768
+		zconfig.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+		zconfig.EPSGCode = inConfig.EPSGCode
+		if zout, err = raster.CreateNewRaster(this.zonesFile, rows, columns,
+			dem.North, dem.South, dem.East, dem.West, zconfig); err != nil {
+			println("Failed to write raster")
+			return
+		}
+	}
+	maxTime := 0.0
+	for i, t := range times {
+		if math.IsNaN(t) {
+			continue
+		}
+		row, col := i/columns, i%columns
+		rout.SetValue(row, col, t)
+		if zout != nil {
+			zout.SetValue(row, col, math.Floor(t/this.interval)+1)
+		}
+		maxTime = math.Max(maxTime, t)
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	elapsed := time.Since(start2)
+	outputs := []*raster.Raster{rout, zout}
+	for _, r := range outputs {
+		if r == nil {
+			continue
+		}
+		r.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		r.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		r.AddMetadataEntry(fmt.Sprintf("Created by Isochrones tool (%s)", this.toolManager.toolVersion(this)))
+		if this.velocityFile != "" {
+			r.AddMetadataEntry(fmt.Sprintf("Velocity file: %s", this.velocityFile))
+		} else {
+			r.AddMetadataEntry(fmt.Sprintf("Velocity: %v", this.velocity))
+		}
+		r.AddMetadataEntry(fmt.Sprintf("Minimum velocity: %v", this.minVelocity))
+		if this.streamsFile != "" {
+			r.AddMetadataEntry(fmt.Sprintf("Timed to the streams of %s", this.streamsFile))
+		}
+		if r == zout {
+			r.AddMetadataEntry(fmt.Sprintf("Zone interval: %v", this.interval))
+		}
+		if err = r.Save(); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	printf("Greatest travel time: %.3f\n", maxTime)
+	if this.interval > 0 {
+		printf("Isochrone zones: %v\n", int(math.Floor(maxTime/this.interval))+1)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// velocityCoefficients returns the velocity coefficient of each cell for
+// travelTimes, its value in the velocities raster, which may be nil, or the
+// velocity where it has none.
+func velocityCoefficients(rows, columns int, velocities *raster.Raster, velocity float64) []float64 {
+	k := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			k[row*columns+col] = velocity
+			if velocities != nil {
+				if v := velocities.Value(row, col); v != velocities.NoDataValue && v > 0 {
+					k[row*columns+col] = v
+				}
+			}
+		}
+	}
+	return k
+}
+
+// travelTimes returns the time that flow takes to travel from each cell of a
+// DEM along its D8 flowpath to the end of the path, or to the first target
+// cell that it reaches if isTarget isn't nil, or NaN at nodata cells. The
+// velocity over each step is k times the square root of the gradient down
+// to the next cell, with k indexed by cell, and no less than minVelocity.
+// Distances are in metres for DEMs in geographic coordinates.
+func travelTimes(dem *raster.Raster, fd *FlowDirections, k []float64, minVelocity float64, isTarget []bool) []float64 {
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	var dist [8]float64
+	for n := 0; n < 8; n++ {
+		dist[n] = math.Hypot(float64(d8DX[n])*cellSizeX, float64(d8DY[n])*cellSizeY)
+	}
+
+	// the time of each step down a flowpath, or -1 at the end of a path
+	step := func(i int) float64 {
+		dir := fd.dir[i]
+		if dir < 0 || (isTarget != nil && isTarget[i]) {
+			return -1
+		}
+		row, col := i/columns, i%columns
+		slope := (dem.Value(row, col) - dem.Value(row+d8DY[dir], col+d8DX[dir])) / dist[dir]
+		v := math.Max(k[i]*math.Sqrt(slope), minVelocity)
+		return dist[dir] / v
+	}
+
+	// the times are accumulated back up each unresolved path from the first
+	// resolved cell that it reaches
+	times := make([]float64, rows*columns)
+	resolved := make([]bool, rows*columns)
+	path := make([]int, 0)
+	oldProgress := -1
+	var eta progressETA
+	for i := range times {
+		if dem.Value(i/columns, i%columns) == nodata {
+			times[i] = math.NaN()
+			resolved[i] = true
+		}
+	}
+	for i := range times {
+		c := i
+		for !resolved[c] {
+			path = append(path, c)
+			if step(c) < 0 {
+				break
+			}
+			dir := fd.dir[c]
+			c += d8DY[dir]*columns + d8DX[dir]
+		}
+		for p := len(path) - 1; p >= 0; p-- {
+			c := path[p]
+			if s := step(c); s < 0 {
+				times[c] = 0
+			} else {
+				dir := fd.dir[c]
+				times[c] = times[c+d8DY[dir]*columns+d8DX[dir]] + s
+			}
+			resolved[c] = true
+		}
+		path = path[:0]
+		progress := int(100.0 * float64(i+1) / float64(rows*columns))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	return times
+}
@@ -0,0 +1,523 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// KMeans clusters the cells of a stack of co-registered attribute rasters
+// into k classes.
+type KMeans struct {
+	stackFile     string
+	outputFile    string
+	numClasses    int
+	maxIterations int
+	standardize   bool
+	seed          int64
+	toolManager   *PluginToolManager
+}
+
+func (this *KMeans) GetName() string {
+	s := "KMeans"
+	return getFormattedToolName(s)
+}
+
+func (this *KMeans) GetDescription() string {
+	s := "K-means clustering of a stack of attribute rasters"
+	return getFormattedToolDescription(s)
+}
+
+func (this *KMeans) GetVersion() string {
+	return "1.0"
+}
+
+func (this *KMeans) GetHelpDocumentation() string {
+	ret := "This tool performs an unsupervised classification of the cells of a stack of " +
+		"co-registered attribute rasters, e.g. slope, DEV and wetness, into NumClasses " +
+		"clusters using the k-means algorithm, e.g. for landform mapping. The stack is " +
+		"described by a text file listing one raster per line, as used by the " +
+		"StackStatistics tool; any layer times are ignored. Only cells that are valid in " +
+		"every layer are classified. If Standardize is true (the default) each layer is " +
+		"scaled to unit variance before clustering, so that layers with different units " +
+		"carry equal weight. The initial cluster centres are chosen with the k-means++ " +
+		"method, using the optional Seed, and the clusters are refined until no cell changes " +
+		"class or MaxIterations (default 50) is reached. The output is a categorical raster " +
+		"of classes 1 to NumClasses, numbered in increasing order of the cluster centre of " +
+		"the first layer; the centre and size of each class are reported, and are also " +
+		"written to the -report file, if there is one."
+	return ret
+}
+
+func (this *KMeans) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *KMeans) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "StackFile"
+	ret[0].Type = "string"
+	ret[0].Description = "Text file listing the attribute rasters"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "NumClasses"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of classes (k)"
+	ret[2].Required = true
+
+	ret[3].Name = "MaxIterations"
+	ret[3].Type = "int"
+	ret[3].Description = "The maximum number of iterations"
+	ret[3].Default = "50"
+
+	ret[4].Name = "Standardize"
+	ret[4].Type = "bool"
+	ret[4].Description = "Scale the layers to unit variance?"
+	ret[4].Default = "true"
+
+	ret[5].Name = "Seed"
+	ret[5].Type = "int"
+	ret[5].Description = "The random seed"
+
+	return ret
+}
+
+func (this *KMeans) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The stack file, output file, and number of classes must be specified.")
+		return
+	}
+	stackFile := strings.TrimSpace(args[0])
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setNumClasses(args[2]) {
+		return
+	}
+	this.maxIterations = 50
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setMaxIterations(args[3]) {
+			return
+		}
+	}
+	this.standardize = true
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.standardize, err = strconv.ParseBool(strings.TrimSpace(args[4])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.seed = time.Now().UnixNano()
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(args[5]), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *KMeans) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the stack file name
+	print("Enter the stack list file name (incl. file extension): ")
+	stackFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	stackFile = strings.TrimSpace(stackFile)
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the number of classes
+	print("Number of classes: ")
+	numClassesStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setNumClasses(numClassesStr) {
+		return
+	}
+
+	// get the maximum number of iterations
+	this.maxIterations = 50
+	print("Maximum number of iterations (default 50): ")
+	maxIterationsStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(maxIterationsStr)) > 0 {
+		if !this.setMaxIterations(maxIterationsStr) {
+			return
+		}
+	}
+
+	// standardize?
+	this.standardize = true
+	print("Standardize the layers (T or F; default T)? ")
+	standardizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(standardizeStr)) > 0 {
+		if this.standardize, err = strconv.ParseBool(strings.TrimSpace(standardizeStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the seed
+	this.seed = time.Now().UnixNano()
+	print("Random seed (blank for none): ")
+	seedStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(seedStr)) > 0 {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(seedStr), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *KMeans) setNumClasses(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 2 || v > 32767 {
+		println("The number of classes must be between 2 and 32767.")
+		return false
+	}
+	this.numClasses = v
+	return true
+}
+
+func (this *KMeans) setMaxIterations(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 1 {
+		println("The maximum number of iterations must be at least 1.")
+		return false
+	}
+	this.maxIterations = v
+	return true
+}
+
+func (this *KMeans) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	stack, err := raster.CreateRasterStackFromListFile(this.stackFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	numLayers := stack.Len()
+	printf("The stack contains %v layers.\n", numLayers)
+
+	start2 := time.Now()
+
+	rows := stack.Rows
+	columns := stack.Columns
+	inConfig := stack.Layer(0).GetRasterConfig()
+
+	// gather the cells that are valid in every layer
+	cells := make([]int32, 0)
+	data := make([]float64, 0)
+	z := make([]float64, numLayers)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			valid := true
+			for k := 0; k < numLayers && valid; k++ {
+				layer := stack.Layer(k)
+				z[k] = layer.Value(row, col)
+				valid = z[k] != layer.NoDataValue
+			}
+			if valid {
+				cells = append(cells, int32(row*columns+col))
+				data = append(data, z...)
+			}
+		}
+	}
+	n := len(cells)
+	k := this.numClasses
+	if n < k {
+		println("There are fewer valid cells than classes.")
+		return
+	}
+
+	// standardize the layers
+	mean := make([]float64, numLayers)
+	scale := make([]float64, numLayers)
+	for j := 0; j < numLayers; j++ {
+		scale[j] = 1.0
+		if !this.standardize {
+			continue
+		}
+		sum, sumSqr := 0.0, 0.0
+		for i := 0; i < n; i++ {
+			v := data[i*numLayers+j]
+			sum += v
+			sumSqr += v * v
+		}
+		mean[j] = sum / float64(n)
+		if variance := sumSqr/float64(n) - mean[j]*mean[j]; variance > 0 {
+			scale[j] = math.Sqrt(variance)
+		}
+		for i := 0; i < n; i++ {
+			data[i*numLayers+j] = (data[i*numLayers+j] - mean[j]) / scale[j]
+		}
+	}
+	point := func(i int) []float64 {
+		return data[i*numLayers : (i+1)*numLayers]
+	}
+	sqrDist := func(a, b []float64) float64 {
+		d := 0.0
+		for j := range a {
+			d += (a[j] - b[j]) * (a[j] - b[j])
+		}
+		return d
+	}
+
+	// choose the initial centres with k-means++
+	rng := rand.New(rand.NewSource(this.seed))
+	centres := make([][]float64, k)
+	centres[0] = append([]float64(nil), point(rng.Intn(n))...)
+	minDist := make([]float64, n)
+	for i := range minDist {
+		minDist[i] = sqrDist(point(i), centres[0])
+	}
+	for c := 1; c < k; c++ {
+		total := 0.0
+		for _, d := range minDist {
+			total += d
+		}
+		next := rng.Intn(n)
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range minDist {
+				if target -= d; target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centres[c] = append([]float64(nil), point(next)...)
+		for i := range minDist {
+			minDist[i] = math.Min(minDist[i], sqrDist(point(i), centres[c]))
+		}
+	}
+
+	// Lloyd's iterations
+	class := make([]int, n)
+	for i := range class {
+		class[i] = -1
+	}
+	counts := make([]int, k)
+	iteration := 0
+	for iteration < this.maxIterations {
+		iteration++
+		numChanged := 0
+		for i := 0; i < n; i++ {
+			p := point(i)
+			best, bestDist := 0, math.Inf(1)
+			for c := 0; c < k; c++ {
+				if d := sqrDist(p, centres[c]); d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			minDist[i] = bestDist
+			if class[i] != best {
+				class[i] = best
+				numChanged++
+			}
+		}
+		for c := 0; c < k; c++ {
+			counts[c] = 0
+			for j := range centres[c] {
+				centres[c][j] = 0
+			}
+		}
+		for i := 0; i < n; i++ {
+			c := class[i]
+			counts[c]++
+			for j, v := range point(i) {
+				centres[c][j] += v
+			}
+		}
+		for c := 0; c < k; c++ {
+			if counts[c] > 0 {
+				for j := range centres[c] {
+					centres[c][j] /= float64(counts[c])
+				}
+				continue
+			}
+			// an empty cluster takes the cell that is furthest from its centre
+			farthest := 0
+			for i := range minDist {
+				if minDist[i] > minDist[farthest] {
+					farthest = i
+				}
+			}
+			copy(centres[c], point(farthest))
+			minDist[farthest] = 0
+			numChanged++
+		}
+		printf("\rIteration %v: %v cells changed class", iteration, numChanged)
+		if numChanged == 0 {
+			break
+		}
+	}
+	println("")
+
+	// number the classes in order of the centre of the first layer
+	order := make([]int, k)
+	for c := range order {
+		order[c] = c
+	}
+	sort.SliceStable(order, func(a, b int) bool { return centres[order[a]][0] < centres[order[b]][0] })
+	label := make([]int, k)
+	for l, c := range order {
+		label[c] = l + 1
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "qual.plt"
+	config.DataType = raster.DT_INT16
+	nodata := -32768.0
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		stack.North, stack.South, stack.East, stack.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for i, cell := range cells {
+		rout.SetValue(int(cell)/columns, int(cell)%columns, float64(label[class[i]]))
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by KMeans tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Classes: %v, layers: %v, iterations: %v, seed: %v", k,
+		numLayers, iteration, this.seed))
+	rout.Save()
+
+	// report the class centres in the units of the layers
+	printf("Cells classified: %v, iterations: %v\n", n, iteration)
+	header := fmt.Sprintf("%8s %10s", "class", "cells")
+	fields := []string{"class", "cells"}
+	for j := 0; j < numLayers; j++ {
+		name := filepath.Base(stack.Layer(j).FileName)
+		fields = append(fields, name)
+		if len(name) > 14 {
+			name = name[:14]
+		}
+		header += fmt.Sprintf(" %14s", name)
+	}
+	println(header)
+	report, err := this.toolManager.openReport(fields...)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	for l, c := range order {
+		line := fmt.Sprintf("%8v %10v", l+1, counts[c])
+		row := []interface{}{l + 1, counts[c]}
+		for j := 0; j < numLayers; j++ {
+			line += fmt.Sprintf(" %14.6g", centres[c][j]*scale[j]+mean[j])
+			row = append(row, centres[c][j]*scale[j]+mean[j])
+		}
+		println(line)
+		report.writeRow(row...)
+	}
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,536 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// Knickpoints locates the knickpoints of a raster stream network, where the
+// normalized channel steepness changes abruptly, as points.
+type Knickpoints struct {
+	demFile     string
+	streamsFile string
+	accumFile   string
+	outputFile  string
+	ksnFile     string
+	reach       int
+	concavity   float64
+	threshold   float64
+	toolManager *PluginToolManager
+}
+
+func (this *Knickpoints) GetName() string {
+	s := "Knickpoints"
+	return getFormattedToolName(s)
+}
+
+func (this *Knickpoints) GetDescription() string {
+	s := "Locates knickpoints along streams by changes in steepness"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Knickpoints) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Knickpoints) GetHelpDocumentation() string {
+	ret := "This tool locates the knickpoints of a raster stream network, e.g. the output of " +
+		"ExtractStreams, where the relation between channel slope, S, and upslope area, A, " +
+		"breaks. Channel steepness is normalized by area as ksn = S A^theta, where theta is " +
+		"the reference Concavity (default 0.45), with A in square metres, so that ksn is " +
+		"constant along a graded channel. Streams are traced downstream as by " +
+		"RasterStreamsToVector, with the flow accumulation raster, in cells, from which " +
+		"they were extracted, and upstream along the main stem, i.e. through the inflowing " +
+		"stream cell of greatest accumulation. At each stream cell, ksn is measured over " +
+		"the ReachLength cells (default 10) upstream and downstream of it, and the cell is " +
+		"a knickpoint if the ratio of the downstream to the upstream ksn, or its inverse, " +
+		"is at least the RatioThreshold (default 2) and is the greatest within a reach " +
+		"length along the stream. The output is a point shapefile, or a GeoJSON file if " +
+		"the output file name has a .geojson or .json extension, with the attributes " +
+		"LINK_ID, the link of RasterStreamsToVector that the point lies on, ELEV, AREA, in " +
+		"square metres, KSN_UP and KSN_DN, the upstream and downstream ksn, and RATIO, " +
+		"their ratio, which is greater than 1 where the channel steepens downstream, e.g. " +
+		"at the lip of a waterfall, and less than 1 where it flattens. If a KsnFile is " +
+		"given, the ksn of each stream cell, measured over the reach centred on it, is " +
+		"also written to it. Cells within a reach length of a channel head or outlet, or " +
+		"whose reaches don't fall, are not measured. Cell sizes in geographic coordinates " +
+		"are converted to metres."
+	return ret
+}
+
+func (this *Knickpoints) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Knickpoints) GetArgDescriptions() []ToolArg {
+	numArgs := 8
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "StreamsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The streams raster name, with directory and file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "AccumulationFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The flow accumulation raster name, in cells, with directory and file extension"
+	ret[2].Role = ArgInput
+	ret[2].Required = true
+
+	ret[3].Name = "OutputFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The output shapefile or GeoJSON file name, with directory"
+	ret[3].Role = ArgOutput
+	ret[3].Required = true
+
+	ret[4].Name = "ReachLength"
+	ret[4].Type = "int"
+	ret[4].Description = "The length of the reaches over which ksn is measured, in cells"
+	ret[4].Default = "10"
+
+	ret[5].Name = "Concavity"
+	ret[5].Type = "float64"
+	ret[5].Description = "The reference concavity index, theta"
+	ret[5].Default = "0.45"
+
+	ret[6].Name = "RatioThreshold"
+	ret[6].Type = "float64"
+	ret[6].Description = "The least change in ksn, as a ratio, at a knickpoint"
+	ret[6].Default = "2"
+
+	ret[7].Name = "KsnFile"
+	ret[7].Type = "string"
+	ret[7].Description = "The optional output ksn raster filename, with directory and file extension"
+	ret[7].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *Knickpoints) EstimateMemory(rows, columns int) int64 {
+	// the DEM, streams, accumulation and ksn rasters, the downstream and
+	// upstream cells, the numbers of inflowing cells, and the ksn and ratios
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+4+4+1+8+8)
+}
+
+func (this *Knickpoints) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The DEM, streams file, accumulation file, and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(&this.demFile, args[0]) || !this.setInputFile(&this.streamsFile, args[1]) ||
+		!this.setInputFile(&this.accumFile, args[2]) {
+		return
+	}
+	this.setOutputFile(args[3])
+
+	this.reach = 10
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		val, err := strconv.Atoi(strings.TrimSpace(args[4]))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.reach = val
+	}
+	this.concavity, this.threshold = 0.45, 2.0
+	values := []*float64{&this.concavity, &this.threshold}
+	for i, v := range values {
+		if len(args) > i+5 && len(strings.TrimSpace(args[i+5])) > 0 && args[i+5] != "not specified" {
+			val, err := strconv.ParseFloat(strings.TrimSpace(args[i+5]), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+	ksnFile := ""
+	if len(args) > 7 && args[7] != "not specified" {
+		ksnFile = args[7]
+	}
+	this.setKsnFile(ksnFile)
+
+	this.Run()
+}
+
+func (this *Knickpoints) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the DEM file name (incl. file extension): ",
+		"Enter the streams file name (incl. file extension): ",
+		"Enter the flow accumulation file name (incl. file extension): "}
+	fileNames := []*string{&this.demFile, &this.streamsFile, &this.accumFile}
+	for i, fileName := range fileNames {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if !this.setInputFile(fileName, str) {
+			return
+		}
+	}
+
+	// get the output file name
+	print("Enter the output shapefile or GeoJSON file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the reach length
+	this.reach = 10
+	print("Reach length in cells (default 10): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.reach, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the concavity and the ratio threshold
+	this.concavity, this.threshold = 0.45, 2.0
+	prompts = []string{"Reference concavity (default 0.45): ", "Ratio threshold (default 2): "}
+	values := []*float64{&this.concavity, &this.threshold}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	// get the ksn file name
+	print("Enter the output ksn file name (blank for none): ")
+	ksnFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setKsnFile(ksnFile)
+
+	this.Run()
+}
+
+func (this *Knickpoints) setInputFile(fileName *string, s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	*fileName = inputFile
+	if !raster.FileExists(inputFile) {
+		printf("no such file or directory: %s\n", inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *Knickpoints) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	this.outputFile = outputFile
+}
+
+// setKsnFile sets the optional ksn raster, which isn't written if s is
+// blank.
+func (this *Knickpoints) setKsnFile(s string) {
+	ksnFile := strings.TrimSpace(s)
+	if ksnFile == "" {
+		this.ksnFile = ""
+		return
+	}
+	if !strings.Contains(ksnFile, pathSep) {
+		ksnFile = this.toolManager.workingDirectory + ksnFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(ksnFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		ksnFile = ksnFile + raster.DefaultExtension // the default output format
+	}
+	this.ksnFile = ksnFile
+}
+
+func (this *Knickpoints) Run() {
+	start1 := time.Now()
+
+	if this.reach < 1 || this.threshold <= 1 {
+		println("The reach length must be at least 1 cell and the ratio threshold greater than 1.")
+		return
+	}
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.demFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	streams, err := raster.CreateRasterFromFile(this.streamsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	accum, err := raster.CreateRasterFromFile(this.accumFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if !onSameGrid(dem, streams) || !onSameGrid(dem, accum) {
+		println("The DEM, streams and accumulation rasters must have the same dimensions.")
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	sn := traceStreamNetwork(streams, accum)
+	ksn, points := findKnickpoints(dem, accum, sn, this.reach, this.concavity, this.threshold)
+	linkIDs := sn.linkIDs()
+
+	// the output points
+	fields := []vector.Field{
+		{Name: "LINK_ID", Type: 'N', Length: 10},
+		{Name: "ELEV", Type: 'N', Length: 14, Decimals: 3},
+		{Name: "AREA", Type: 'N', Length: 18, Decimals: 1},
+		{Name: "KSN_UP", Type: 'N', Length: 16, Decimals: 4},
+		{Name: "KSN_DN", Type: 'N', Length: 16, Decimals: 4},
+		{Name: "RATIO", Type: 'N', Length: 12, Decimals: 4},
+	}
+	output, err := vector.CreateNewShapefile(this.outputFile, vector.ST_Point, fields)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt != "not specified" {
+		output.CoordinateRefSystemWKT = wkt
+	}
+	output.EPSGCode = inConfig.EPSGCode
+	numSteepening := 0
+	for _, p := range points {
+		row, col := p.cell/columns, p.cell%columns
+		x := dem.West + (float64(col)+0.5)*dem.GetCellSizeX()
+		y := dem.North - (float64(row)+0.5)*dem.GetCellSizeY()
+		ratio := p.ksnDown / p.ksnUp
+		if ratio > 1 {
+			numSteepening++
+		}
+		if err = output.AddShape(vector.NewPoint(x, y), int(linkIDs[p.cell]), dem.Value(row, col),
+			p.area, p.ksnUp, p.ksnDown, ratio); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	elapsed := time.Since(start2)
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+	if err = output.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	if this.ksnFile != "" {
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_FLOAT32
+		config.PreferredPalette = "spectrum.pal"
+		config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+		config.EPSGCode = inConfig.EPSGCode
+		rout, err := raster.CreateNewRaster(this.ksnFile, rows, columns,
+			dem.North, dem.South, dem.East, dem.West, config)
+		if err != nil {
+			println("Failed to write raster")
+			return
+		}
+		for i, k := range ksn {
+			if !math.IsNaN(k) {
+				rout.SetValue(i/columns, i%columns, k)
+			}
+		}
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by Knickpoints tool (%s)", this.toolManager.toolVersion(this)))
+		rout.AddMetadataEntry(fmt.Sprintf("Reach length: %v, concavity: %v", this.reach, this.concavity))
+		if err = rout.Save(); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	printf("Knickpoints: %v, of which %v steepen downstream\n", len(points), numSteepening)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// knickpoint is a stream cell at which the normalized channel steepness
+// changes abruptly.
+type knickpoint struct {
+	cell           int
+	area           float64 // the upslope area, in square metres
+	ksnUp, ksnDown float64 // the steepness of the reaches above and below
+}
+
+// findKnickpoints returns the normalized channel steepness, ksn, of each cell
+// of a stream network, measured over a reach of reach cells centred on it,
+// or NaN where it can't be measured, and the knickpoints of the network,
+// where the ratio of the ksn of the reaches below and above a cell, or its
+// inverse, is at least threshold and greatest within a reach along the
+// stream. Upslope areas are the accumulation, in cells, times the area of a
+// cell, in square metres, and ksn is the slope times the area raised to the
+// concavity.
+func findKnickpoints(dem, accum *raster.Raster, sn *streamNetwork, reach int, concavity,
+	threshold float64) ([]float64, []knickpoint) {
+	rows := sn.rows
+	columns := sn.columns
+	nodata := dem.NoDataValue
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	cellArea := cellSizeX * cellSizeY
+
+	// each stream cell is entered from upstream through its inflowing cell
+	// of greatest accumulation, i.e. along the main stem
+	up := make([]int32, rows*columns)
+	for i := range up {
+		up[i] = -1
+	}
+	for i, d := range sn.down {
+		if d >= 0 && (up[d] < 0 || accum.Value(i/columns, i%columns) >
+			accum.Value(int(up[d])/columns, int(up[d])%columns)) {
+			up[d] = int32(i)
+		}
+	}
+
+	elevation := func(i int) float64 {
+		return dem.Value(i/columns, i%columns)
+	}
+	area := func(i int) float64 {
+		return accum.Value(i/columns, i%columns) * cellArea
+	}
+
+	// walk follows next from cell i for n cells, returning the cell that it
+	// reaches and the distance travelled, or false if the stream ends first
+	walk := func(i, n int, next []int32) (int, float64, bool) {
+		dist := 0.0
+		for k := 0; k < n; k++ {
+			j := int(next[i])
+			if j < 0 || elevation(j) == nodata {
+				return i, dist, false
+			}
+			dist += math.Hypot(float64(j%columns-i%columns)*cellSizeX, float64(j/columns-i/columns)*cellSizeY)
+			i = j
+		}
+		return i, dist, true
+	}
+
+	// steepness returns the ksn of the reach from the top cell down to the
+	// bottom one, dist apart, with the area at its mid-point, or false if it
+	// doesn't fall
+	steepness := func(top, bottom, mid int, dist float64) (float64, bool) {
+		drop := elevation(top) - elevation(bottom)
+		if drop <= 0 {
+			return 0, false
+		}
+		return drop / dist * math.Pow(area(mid), concavity), true
+	}
+
+	ksn := make([]float64, rows*columns)
+	logRatio := make([]float64, rows*columns)
+	ksnUp := make([]float64, rows*columns)
+	ksnDown := make([]float64, rows*columns)
+	for i := range ksn {
+		ksn[i] = math.NaN()
+		logRatio[i] = math.NaN()
+		if sn.down[i] < 0 && up[i] < 0 {
+			continue // not in a stream of more than one cell
+		}
+		if elevation(i) == nodata {
+			continue
+		}
+		upper, distUp, okUp := walk(i, reach/2, up)
+		lower, distDown, okDown := walk(i, reach-reach/2, sn.down)
+		if okUp && okDown {
+			if k, ok := steepness(upper, lower, i, distUp+distDown); ok {
+				ksn[i] = k
+			}
+		}
+
+		// the reaches above and below the cell
+		var okAbove, okBelow bool
+		if top, dist, ok := walk(i, reach, up); ok {
+			mid, _, _ := walk(i, reach/2, up)
+			ksnUp[i], okAbove = steepness(top, i, mid, dist)
+		}
+		if bottom, dist, ok := walk(i, reach, sn.down); ok {
+			mid, _, _ := walk(i, reach/2, sn.down)
+			ksnDown[i], okBelow = steepness(i, bottom, mid, dist)
+		}
+		if okAbove && okBelow {
+			logRatio[i] = math.Abs(math.Log(ksnDown[i] / ksnUp[i]))
+		}
+	}
+
+	// knickpoints are the local maxima of the log ratio along the streams;
+	// of equal ratios, the one furthest downstream is taken
+	minLogRatio := math.Log(threshold)
+	points := make([]knickpoint, 0)
+	for i, r := range logRatio {
+		if math.IsNaN(r) || r < minLogRatio {
+			continue
+		}
+		isMax := true
+		for k, j := 0, i; k < reach && isMax; k++ {
+			if j = int(up[j]); j < 0 {
+				break
+			}
+			isMax = math.IsNaN(logRatio[j]) || logRatio[j] <= r
+		}
+		for k, j := 0, i; k < reach && isMax; k++ {
+			if j = int(sn.down[j]); j < 0 {
+				break
+			}
+			isMax = math.IsNaN(logRatio[j]) || logRatio[j] < r
+		}
+		if isMax {
+			points = append(points, knickpoint{cell: i, area: area(i), ksnUp: ksnUp[i], ksnDown: ksnDown[i]})
+		}
+	}
+	return ksn, points
+}
@@ -0,0 +1,864 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Kriging interpolates the points of an x,y,z text file onto a raster by
+// ordinary kriging, with a prediction-variance raster.
+type Kriging struct {
+	inputFile     string
+	outputFile    string
+	varianceFile  string
+	cellSize      float64
+	model         variogramModel
+	vrange        float64 // zero if it is to be fitted
+	sill          float64 // the partial sill; zero if it is to be fitted
+	nugget        float64
+	numNeighbours int
+	epsgCode      int
+	toolManager   *PluginToolManager
+}
+
+func (this *Kriging) GetName() string {
+	s := "Kriging"
+	return getFormattedToolName(s)
+}
+
+func (this *Kriging) GetDescription() string {
+	s := "Interpolates x,y,z points to a raster by ordinary kriging"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Kriging) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Kriging) GetHelpDocumentation() string {
+	ret := "This tool interpolates the points of a text file containing one 'x y z' (or " +
+		"'x,y,z') point per line, as read by the ReadXYZ tool, onto a raster with the " +
+		"specified CellSize by ordinary kriging. Each cell is estimated from its " +
+		"NumNeighbours (default 16) nearest points using a 'spherical' (default), " +
+		"'exponential' or 'gaussian' variogram Model with the given Range, partial Sill and " +
+		"Nugget (default 0); the ranges of the exponential and Gaussian models are their " +
+		"practical ranges, at which 95% of the sill is reached, and the Gaussian model " +
+		"should be given a small nugget for closely spaced points, for which it is otherwise " +
+		"unstable. A Range or Sill that isn't specified is fitted, by weighted least squares, " +
+		"to the experimental semivariogram of the points, which is printed; the Semivariogram " +
+		"tool estimates that of a DEM. Points " +
+		"at the same location are averaged. The kriging (prediction) variance of each cell is " +
+		"written to the optional VarianceFile; it is smallest near the points and approaches " +
+		"the sill plus the nugget far from them. The grid covers the points, which are treated " +
+		"as cell centres as in ReadXYZ, and the optional EPSG code specifies their coordinate " +
+		"reference system."
+	return ret
+}
+
+func (this *Kriging) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Kriging) GetArgDescriptions() []ToolArg {
+	numArgs := 10
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input x,y,z text file name, with directory and extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output grid resolution"
+	ret[2].Required = true
+
+	ret[3].Name = "Model"
+	ret[3].Type = "string"
+	ret[3].Description = "spherical, exponential or gaussian"
+	ret[3].Default = "spherical"
+	ret[3].Choices = variogramModelNames
+
+	ret[4].Name = "Range"
+	ret[4].Type = "float64"
+	ret[4].Description = "The range of the variogram in map units; fitted if not specified"
+
+	ret[5].Name = "Sill"
+	ret[5].Type = "float64"
+	ret[5].Description = "The partial sill of the variogram; fitted if not specified"
+
+	ret[6].Name = "Nugget"
+	ret[6].Type = "float64"
+	ret[6].Description = "The nugget of the variogram"
+	ret[6].Default = "0"
+
+	ret[7].Name = "NumNeighbours"
+	ret[7].Type = "int"
+	ret[7].Description = "The number of nearest points used for each cell"
+	ret[7].Default = "16"
+
+	ret[8].Name = "VarianceFile"
+	ret[8].Type = "string"
+	ret[8].Description = "The output kriging variance raster, with directory and file extension"
+	ret[8].Role = ArgOutputRaster
+
+	ret[9].Name = "EPSG"
+	ret[9].Type = "int"
+	ret[9].Description = "The EPSG code of the points"
+
+	return ret
+}
+
+func (this *Kriging) EstimateMemory(rows, columns int) int64 {
+	// the output and variance rasters
+	return gridBytes(rows, columns, 2*rasterBytesPerCell)
+}
+
+func (this *Kriging) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and cell size must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.outputFile = this.outputRasterName(args[1])
+	if !this.setCellSize(args[2]) {
+		return
+	}
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	this.model = sphericalModel
+	if specified(3) {
+		var err error
+		if this.model, err = parseVariogramModel(args[3]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.vrange, this.sill, this.nugget = 0, 0, 0
+	values := []*float64{&this.vrange, &this.sill, &this.nugget}
+	for i, v := range values {
+		if specified(i + 4) {
+			val, err := strconv.ParseFloat(strings.TrimSpace(args[i+4]), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+	this.numNeighbours = 16
+	if specified(7) {
+		val, err := strconv.Atoi(strings.TrimSpace(args[7]))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.numNeighbours = val
+	}
+	this.varianceFile = ""
+	if specified(8) {
+		this.varianceFile = this.outputRasterName(args[8])
+	}
+	this.epsgCode = 0
+	if specified(9) && !this.setEPSGCode(args[9]) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *Kriging) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the x,y,z text file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.outputFile = this.outputRasterName(outputFile)
+
+	// get the cell size
+	print("Cell size: ")
+	cellSizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setCellSize(cellSizeStr) {
+		return
+	}
+
+	// get the variogram model
+	print("Variogram model, spherical, exponential or gaussian (blank for spherical): ")
+	model, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.model = sphericalModel
+	if len(strings.TrimSpace(model)) > 0 {
+		if this.model, err = parseVariogramModel(model); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the variogram parameters
+	this.vrange, this.sill, this.nugget = 0, 0, 0
+	prompts := []string{"Range in map units (blank to fit): ",
+		"Partial sill (blank to fit): ",
+		"Nugget (default 0): "}
+	values := []*float64{&this.vrange, &this.sill, &this.nugget}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			if *v, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	// get the number of neighbours
+	print("Number of neighbouring points (default 16): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.numNeighbours = 16
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.numNeighbours, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the variance file name
+	print("Kriging variance file name (blank for none): ")
+	varianceFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.varianceFile = ""
+	if len(strings.TrimSpace(varianceFile)) > 0 {
+		this.varianceFile = this.outputRasterName(varianceFile)
+	}
+
+	// get the EPSG code
+	this.epsgCode = 0
+	print("EPSG code of the points (optional): ")
+	epsgStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(epsgStr)) > 0 {
+		if !this.setEPSGCode(epsgStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Kriging) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *Kriging) outputRasterName(s string) string {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	return outputFile
+}
+
+func (this *Kriging) setCellSize(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The cell size must be greater than zero.")
+		return false
+	}
+	this.cellSize = v
+	return true
+}
+
+func (this *Kriging) setEPSGCode(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "EPSG") {
+		s = strings.TrimLeft(s[4:], ":_- ")
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code <= 0 {
+		println("The EPSG code must be a positive integer.")
+		return false
+	}
+	this.epsgCode = code
+	return true
+}
+
+func (this *Kriging) Run() {
+	start1 := time.Now()
+
+	if this.vrange < 0 || this.sill < 0 || this.nugget < 0 || this.numNeighbours < 1 {
+		println("The range, sill and nugget can't be negative and at least one neighbour must be used.")
+		return
+	}
+
+	println("Reading point data...")
+	xs, ys, zs, err := readXYZFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	xs, ys, zs = mergeCoincidentPoints(xs, ys, zs)
+	printf("Number of points: %v\n", len(xs))
+	if len(xs) < 2 {
+		println("At least two points at different locations are needed.")
+		return
+	}
+
+	start2 := time.Now()
+
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+
+	// the variogram, fitted to the points where it isn't specified
+	v := variogram{model: this.model, nugget: this.nugget, sill: this.sill, vrange: this.vrange}
+	if v.vrange == 0 || v.sill == 0 {
+		lags := experimentalVariogram(xs, ys, zs, math.Hypot(maxX-minX, maxY-minY)/2, 15)
+		println("Experimental semivariogram of the points:")
+		printf("%12s %10s %14s\n", "distance", "pairs", "semivariance")
+		for _, l := range lags {
+			printf("%12.4f %10d %14.6f\n", l.distance, l.pairs, l.semivariance)
+		}
+		if err = v.fit(lags, this.vrange == 0, this.sill == 0); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	printf("Variogram: %s, range %.4f, partial sill %.6f, nugget %.6f\n", v.model, v.vrange, v.sill, v.nugget)
+	if v.sill+v.nugget <= 0 {
+		println("The variogram has a sill and nugget of zero, i.e. the points have a constant value.")
+		return
+	}
+
+	cellSize := this.cellSize
+	columns := int(math.Floor((maxX-minX)/cellSize+0.5)) + 1
+	rows := int(math.Floor((maxY-minY)/cellSize+0.5)) + 1
+	west := minX - cellSize/2.0
+	north := maxY + cellSize/2.0
+	east := west + float64(columns)*cellSize
+	south := north - float64(rows)*cellSize
+	printf("Output grid: %v rows x %v columns\n", rows, columns)
+
+	// create the output rasters
+	nodata := -32768.0
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.EPSGCode = this.epsgCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	var rvar *raster.Raster
+	if this.varianceFile != "" {
+		varConfig := raster.NewDefaultRasterConfig()
+		varConfig.DataType = raster.DT_FLOAT32
+		varConfig.NoDataValue = nodata
+		varConfig.InitialValue = nodata
+		varConfig.EPSGCode = this.epsgCode
+		if rvar, err = raster.CreateNewRaster(this.varianceFile, rows, columns,
+			north, south, east, west, varConfig); err != nil {
+			println("Failed to write raster")
+			return
+		}
+	}
+
+	k := this.numNeighbours
+	if k > len(xs) {
+		k = len(xs)
+	}
+	kr := newOrdinaryKriging(xs, ys, zs, v, k)
+	numFailed := 0
+	numCPUs := this.toolManager.numThreads()
+	c1 := make(chan int, rows)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for cpu := 0; cpu < numCPUs; cpu++ {
+		wg.Add(1)
+		go func(cpu int) {
+			defer wg.Done()
+			estimate := kr.estimator()
+			for row := cpu; row < rows; row += numCPUs {
+				if interrupted() {
+					c1 <- 0
+					continue
+				}
+				failed := 0
+				y := north - (float64(row)+0.5)*cellSize
+				for col := 0; col < columns; col++ {
+					x := west + (float64(col)+0.5)*cellSize
+					z, variance, err := estimate(x, y)
+					if err != nil {
+						failed++
+						continue
+					}
+					rout.SetValue(row, col, z)
+					if rvar != nil {
+						rvar.SetValue(row, col, variance)
+					}
+				}
+				c1 <- failed
+			}
+		}(cpu)
+	}
+
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		numFailed += <-c1
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	printf("\n")
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	for _, r := range []*raster.Raster{rout, rvar} {
+		if r == nil {
+			continue
+		}
+		r.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		r.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		r.AddMetadataEntry(fmt.Sprintf("Created by Kriging tool (%s)", this.toolManager.toolVersion(this)))
+		r.AddMetadataEntry(fmt.Sprintf("Input file: %s", this.inputFile))
+		r.AddMetadataEntry(fmt.Sprintf("Variogram: %s, range %v, partial sill %v, nugget %v",
+			v.model, v.vrange, v.sill, v.nugget))
+		r.AddMetadataEntry(fmt.Sprintf("Neighbours: %v", k))
+	}
+	rout.Save()
+	if rvar != nil {
+		rvar.Save()
+	}
+
+	if numFailed > 0 {
+		printf("Warning: %v cells could not be estimated and were assigned nodata.\n", numFailed)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// ordinaryKriging estimates values at arbitrary locations from a set of
+// points by ordinary kriging with a variogram, using the k nearest points.
+type ordinaryKriging struct {
+	xs, ys, zs []float64
+	v          variogram
+	k          int
+	index      *pointIndex
+}
+
+func newOrdinaryKriging(xs, ys, zs []float64, v variogram, k int) *ordinaryKriging {
+	return &ordinaryKriging{xs, ys, zs, v, k, newPointIndex(xs, ys, k)}
+}
+
+// estimator returns a function that estimates the value at x, y and its
+// kriging variance. Its buffers are reused between calls, so each goroutine
+// needs its own estimator. An error is returned if the kriging system is
+// singular.
+func (kr *ordinaryKriging) estimator() func(x, y float64) (float64, float64, error) {
+	xs, ys, zs, v, k := kr.xs, kr.ys, kr.zs, kr.v, kr.k
+	// the variogram is scaled by its total sill, so that the kriging systems
+	// are well conditioned whatever the units of z
+	totalSill := v.sill + v.nugget
+	neighbours := make([]int, 0, k)
+	a := make([][]float64, k+1)
+	for i := range a {
+		a[i] = make([]float64, k+1)
+	}
+	b := make([]float64, k+1)
+	gamma0 := make([]float64, k)
+	return func(x, y float64) (float64, float64, error) {
+		neighbours = kr.index.nearest(x, y, k, neighbours[:0])
+		n := len(neighbours)
+		for i, p := range neighbours {
+			for j := i; j < n; j++ {
+				q := neighbours[j]
+				g := v.gamma(math.Hypot(xs[p]-xs[q], ys[p]-ys[q])) / totalSill
+				a[i][j], a[j][i] = g, g
+			}
+			a[i][n], a[n][i] = 1, 1
+			gamma0[i] = v.gamma(math.Hypot(xs[p]-x, ys[p]-y)) / totalSill
+			b[i] = gamma0[i]
+		}
+		a[n][n] = 0
+		b[n] = 1
+		// solveLinearSystem swaps the rows of a, which are all rewritten for
+		// the next estimate
+		lambda, err := solveLinearSystem(a[:n+1], b[:n+1])
+		if err != nil {
+			return 0, 0, err
+		}
+		z, variance := 0.0, lambda[n]
+		for i, p := range neighbours {
+			z += lambda[i] * zs[p]
+			variance += lambda[i] * gamma0[i]
+		}
+		return z, math.Max(variance, 0) * totalSill, nil
+	}
+}
+
+// variogramModel is the form of a variogram model.
+type variogramModel int
+
+const (
+	sphericalModel variogramModel = iota
+	exponentialModel
+	gaussianModel
+)
+
+var variogramModelNames = []string{"spherical", "exponential", "gaussian"}
+
+var unknownVariogramModelError = errors.New("Unrecognized variogram model; use 'spherical', 'exponential' or 'gaussian'.")
+
+func parseVariogramModel(s string) (variogramModel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range variogramModelNames {
+		if s == name {
+			return variogramModel(i), nil
+		}
+	}
+	return sphericalModel, unknownVariogramModelError
+}
+
+func (m variogramModel) String() string {
+	return variogramModelNames[m]
+}
+
+// shape returns the model's semivariance at distance h with a range of a and
+// a sill of one. The exponential and Gaussian models reach 95% of the sill at
+// their practical range a.
+func (m variogramModel) shape(h, a float64) float64 {
+	switch m {
+	case exponentialModel:
+		return 1 - math.Exp(-3*h/a)
+	case gaussianModel:
+		return 1 - math.Exp(-3*h*h/(a*a))
+	}
+	if h >= a {
+		return 1
+	}
+	r := h / a
+	return 1.5*r - 0.5*r*r*r
+}
+
+// variogram is a variogram model with its parameters.
+type variogram struct {
+	model  variogramModel
+	nugget float64
+	sill   float64 // the partial sill, i.e. excluding the nugget
+	vrange float64
+}
+
+func (v *variogram) gamma(h float64) float64 {
+	if h == 0 {
+		return 0
+	}
+	return v.nugget + v.sill*v.model.shape(h, v.vrange)
+}
+
+// fit fits the range and partial sill, or whichever of them is requested, to
+// an experimental semivariogram by least squares weighted by the numbers of
+// pairs, keeping the nugget. The range is searched for on a fine grid of
+// distances up to twice that of the last lag; for a given range, the best
+// partial sill has a closed form.
+func (v *variogram) fit(lags []variogramLag, fitRange, fitSill bool) error {
+	if len(lags) == 0 {
+		return errors.New("There are too few pairs of points to fit the variogram.")
+	}
+	maxDistance := lags[len(lags)-1].distance
+	bestError := math.Inf(1)
+	bestRange, bestSill := v.vrange, v.sill
+	numSteps := 1
+	if fitRange {
+		numSteps = 200
+	}
+	for i := 1; i <= numSteps; i++ {
+		a := v.vrange
+		if fitRange {
+			a = 2 * maxDistance * float64(i) / float64(numSteps)
+		}
+		c := v.sill
+		if fitSill {
+			var num, den float64
+			for _, l := range lags {
+				f := v.model.shape(l.distance, a)
+				num += float64(l.pairs) * (l.semivariance - v.nugget) * f
+				den += float64(l.pairs) * f * f
+			}
+			c = 0
+			if den > 0 && num > 0 {
+				c = num / den
+			}
+		}
+		sse := 0.0
+		for _, l := range lags {
+			d := l.semivariance - v.nugget - c*v.model.shape(l.distance, a)
+			sse += float64(l.pairs) * d * d
+		}
+		if sse < bestError {
+			bestError, bestRange, bestSill = sse, a, c
+		}
+	}
+	v.vrange, v.sill = bestRange, bestSill
+	return nil
+}
+
+// variogramLag is a lag class of an experimental semivariogram.
+type variogramLag struct {
+	distance     float64 // the mean distance between the pairs
+	pairs        int
+	semivariance float64
+}
+
+// experimentalVariogram calculates the omnidirectional semivariogram of a set
+// of points in numLags classes of distances up to maxDistance, omitting empty
+// classes. Large sets are regularly subsampled to about 3000 points, which
+// give millions of pairs.
+func experimentalVariogram(xs, ys, zs []float64, maxDistance float64, numLags int) []variogramLag {
+	step := 1 + len(xs)/3000
+	width := maxDistance / float64(numLags)
+	distance := make([]float64, numLags)
+	sumSqr := make([]float64, numLags)
+	pairs := make([]int, numLags)
+	for i := 0; i < len(xs); i += step {
+		for j := i + step; j < len(xs); j += step {
+			d := math.Hypot(xs[i]-xs[j], ys[i]-ys[j])
+			lag := int(d / width)
+			if lag >= numLags {
+				continue
+			}
+			distance[lag] += d
+			sumSqr[lag] += (zs[i] - zs[j]) * (zs[i] - zs[j])
+			pairs[lag]++
+		}
+	}
+	lags := make([]variogramLag, 0, numLags)
+	for i := range pairs {
+		if pairs[i] > 0 {
+			n := float64(pairs[i])
+			lags = append(lags, variogramLag{distance[i] / n, pairs[i], sumSqr[i] / (2 * n)})
+		}
+	}
+	return lags
+}
+
+// mergeCoincidentPoints replaces the points at the same location with one
+// point of their mean value, since they would make a kriging system
+// singular.
+func mergeCoincidentPoints(xs, ys, zs []float64) ([]float64, []float64, []float64) {
+	type location struct{ x, y float64 }
+	first := make(map[location]int, len(xs))
+	count := make([]int, 0, len(xs))
+	mx, my, mz := make([]float64, 0, len(xs)), make([]float64, 0, len(xs)), make([]float64, 0, len(xs))
+	for i := range xs {
+		loc := location{xs[i], ys[i]}
+		if j, ok := first[loc]; ok {
+			mz[j] += zs[i]
+			count[j]++
+			continue
+		}
+		first[loc] = len(mx)
+		mx, my, mz = append(mx, xs[i]), append(my, ys[i]), append(mz, zs[i])
+		count = append(count, 1)
+	}
+	for j := range mz {
+		mz[j] /= float64(count[j])
+	}
+	return mx, my, mz
+}
+
+// pointIndex finds the nearest of a set of points by bucketing them in a
+// regular grid. It is safe for concurrent use.
+type pointIndex struct {
+	xs, ys           []float64
+	west, north      float64
+	size             float64 // the width of a bucket
+	columns, rows    int
+	start, neighbour []int // the points of bucket i are neighbour[start[i]:start[i+1]]
+}
+
+// newPointIndex indexes points for queries of about k neighbours, with a
+// few times k points per bucket.
+func newPointIndex(xs, ys []float64, k int) *pointIndex {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+	area := math.Max((maxX-minX)*(maxY-minY), 1e-12)
+	size := math.Sqrt(area * float64(k) / float64(len(xs)))
+	if size <= 0 || math.IsNaN(size) {
+		size = math.Max(maxX-minX, maxY-minY) + 1
+	}
+	idx := &pointIndex{xs: xs, ys: ys, west: minX, north: maxY, size: size}
+	idx.columns = int((maxX-minX)/size) + 1
+	idx.rows = int((maxY-minY)/size) + 1
+	idx.start = make([]int, idx.rows*idx.columns+1)
+	bucket := make([]int, len(xs))
+	for i := range xs {
+		bucket[i] = idx.bucketOf(xs[i], ys[i])
+		idx.start[bucket[i]+1]++
+	}
+	for i := 1; i < len(idx.start); i++ {
+		idx.start[i] += idx.start[i-1]
+	}
+	idx.neighbour = make([]int, len(xs))
+	next := append([]int(nil), idx.start...)
+	for i, b := range bucket {
+		idx.neighbour[next[b]] = i
+		next[b]++
+	}
+	return idx
+}
+
+func (idx *pointIndex) bucketOf(x, y float64) int {
+	col := int((x - idx.west) / idx.size)
+	row := int((idx.north - y) / idx.size)
+	if col >= idx.columns {
+		col = idx.columns - 1
+	}
+	if row >= idx.rows {
+		row = idx.rows - 1
+	}
+	return row*idx.columns + col
+}
+
+// nearest appends the k points nearest to x, y to dst, nearest first, and
+// returns it. Ties are broken by the order of the points.
+func (idx *pointIndex) nearest(x, y float64, k int, dst []int) []int {
+	col := int(math.Floor((x - idx.west) / idx.size))
+	row := int(math.Floor((idx.north - y) / idx.size))
+	type candidate struct {
+		i int
+		d float64
+	}
+	candidates := make([]candidate, 0, 4*k)
+	for ring := 0; ; ring++ {
+		// the points of the buckets on this ring around the query's bucket
+		for r := row - ring; r <= row+ring; r++ {
+			if r < 0 || r >= idx.rows {
+				continue
+			}
+			for c := col - ring; c <= col+ring; c++ {
+				if c < 0 || c >= idx.columns || (r != row-ring && r != row+ring && c != col-ring && c != col+ring) {
+					continue
+				}
+				b := r*idx.columns + c
+				for _, i := range idx.neighbour[idx.start[b]:idx.start[b+1]] {
+					candidates = append(candidates, candidate{i, math.Hypot(idx.xs[i]-x, idx.ys[i]-y)})
+				}
+			}
+		}
+		// points beyond the ring may be nearer than those found outside of
+		// a circle of the ring's inner radius
+		if len(candidates) >= k {
+			sort.Slice(candidates, func(a, b int) bool {
+				if candidates[a].d != candidates[b].d {
+					return candidates[a].d < candidates[b].d
+				}
+				return candidates[a].i < candidates[b].i
+			})
+			if candidates[k-1].d <= float64(ring)*idx.size || len(candidates) == len(idx.xs) {
+				break
+			}
+		}
+		if ring > idx.rows+idx.columns+int(math.Abs(float64(row))+math.Abs(float64(col))) {
+			break // every bucket has been searched
+		}
+	}
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	for _, c := range candidates {
+		dst = append(dst, c.i)
+	}
+	return dst
+}
@@ -0,0 +1,458 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/lidar"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// LidarToDEM interpolates the ground returns of a LAS file onto a raster DEM
+// by inverse distance weighting or within their Delaunay triangulation.
+type LidarToDEM struct {
+	inputFile   string
+	outputFile  string
+	cellSize    float64
+	method      string
+	maxDistance float64 // zero for no limit
+	epsgCode    int     // zero for the coordinate reference system of the file
+	toolManager *PluginToolManager
+}
+
+// the number of points that are weighted in inverse distance weighting, and
+// the power of their distances
+const idwNeighbours = 8
+const idwPower = 2.0
+
+func (this *LidarToDEM) GetName() string {
+	s := "LidarToDEM"
+	return getFormattedToolName(s)
+}
+
+func (this *LidarToDEM) GetDescription() string {
+	s := "Interpolates the ground returns of a LAS file to a raster DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *LidarToDEM) GetVersion() string {
+	return "1.0"
+}
+
+func (this *LidarToDEM) GetHelpDocumentation() string {
+	ret := "This tool interpolates the points of a LiDAR point cloud in a LAS file (versions " +
+		"1.0 to 1.4, with any of the point record formats 0 to 10) that are classified as " +
+		"ground (class 2) onto a raster DEM with the specified CellSize. If the file has no " +
+		"ground points, e.g. because it hasn't been classified, its last returns are used " +
+		"instead, with a warning, which includes the tops of buildings and dense vegetation. " +
+		"Withheld points and noise (classes 7 and 18) are never used. The Method is either " +
+		"'tin' (the default), which interpolates linearly within the triangles of the " +
+		"Delaunay triangulation (TIN) of the points, as the TINGridding tool does, or 'idw', " +
+		"which weights the 8 nearest points by their inverse squared distances. " +
+		"MaxDistance, which is optional, is the longest triangle edge that is interpolated " +
+		"with 'tin', and the furthest distance of the points that are weighted with 'idw', " +
+		"in map units; cells beyond it, e.g. in gaps beneath buildings and water, are " +
+		"nodata. Points at the same location are averaged. The grid covers the points, " +
+		"which are treated as cell centres as in ReadXYZ, and has the coordinate reference " +
+		"system of the file, if it has one, unless an EPSG code is specified. LAZ files " +
+		"must first be decompressed to LAS, e.g. with laszip. The output is a suitable " +
+		"input for the depression breaching and flow tools."
+	return ret
+}
+
+func (this *LidarToDEM) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *LidarToDEM) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input LAS file name, with directory and extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output grid resolution"
+	ret[2].Required = true
+
+	ret[3].Name = "Method"
+	ret[3].Type = "string"
+	ret[3].Description = "The interpolation method, 'tin' (default) or 'idw'"
+
+	ret[4].Name = "MaxDistance"
+	ret[4].Type = "float64"
+	ret[4].Description = "The longest triangle edge or furthest weighted point, in map units"
+
+	ret[5].Name = "EPSG"
+	ret[5].Type = "int"
+	ret[5].Description = "The EPSG code of the points, if not that of the file"
+
+	return ret
+}
+
+func (this *LidarToDEM) EstimateMemory(rows, columns int) int64 {
+	// the output raster
+	return gridBytes(rows, columns, rasterBytesPerCell)
+}
+
+func (this *LidarToDEM) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and cell size must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+	if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	this.method = "tin"
+	if specified(3) {
+		this.method = strings.ToLower(strings.TrimSpace(args[3]))
+	}
+	this.maxDistance = 0
+	if specified(4) {
+		if this.maxDistance, err = strconv.ParseFloat(strings.TrimSpace(args[4]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.epsgCode = 0
+	if specified(5) {
+		if this.epsgCode, err = strconv.Atoi(strings.TrimSpace(args[5])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *LidarToDEM) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the LAS file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the cell size
+	print("Cell size: ")
+	cellSizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(cellSizeStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the method
+	print("Interpolation method, 'tin' or 'idw' (blank for tin): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.method = "tin"
+	if len(strings.TrimSpace(method)) > 0 {
+		this.method = strings.ToLower(strings.TrimSpace(method))
+	}
+
+	// get the maximum distance
+	print("Maximum triangle edge length or point distance (blank for no limit): ")
+	maxDistStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.maxDistance = 0
+	if len(strings.TrimSpace(maxDistStr)) > 0 {
+		if this.maxDistance, err = strconv.ParseFloat(strings.TrimSpace(maxDistStr), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the EPSG code
+	print("EPSG code of the points (blank for that of the file): ")
+	epsgStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.epsgCode = 0
+	if len(strings.TrimSpace(epsgStr)) > 0 {
+		if this.epsgCode, err = strconv.Atoi(strings.TrimSpace(epsgStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *LidarToDEM) Run() {
+	start1 := time.Now()
+
+	if this.cellSize <= 0 || this.maxDistance < 0 || this.epsgCode < 0 {
+		println("The cell size must be greater than zero and the maximum distance and EPSG code can't be negative.")
+		return
+	}
+	if this.method != "tin" && this.method != "idw" {
+		printf("Unrecognized interpolation method '%s'; it must be 'tin' or 'idw'.\n", this.method)
+		return
+	}
+
+	println("Reading point data...")
+	input, err := lidar.CreateFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	defer input.Close()
+	if err = input.ReadPointData(); err != nil {
+		println(err.Error())
+		return
+	}
+	numPoints := int64(input.Header.NumberPoints)
+	printf("Number of points: %v\n", numPoints)
+
+	// the ground points, or failing that the last returns
+	isUsable := func(p lidar.PointData) bool {
+		class := p.ClassField.ClassValue()
+		return !p.ClassField.IsWithheld() && class != 7 && class != 18
+	}
+	var xs, ys, zs []float64
+	for n := int64(0); n < numPoints; n++ {
+		if p := input.GetPointData(n); isUsable(p) && p.ClassField.ClassValue() == 2 {
+			x, y, z := input.GetPointXYZ(n)
+			xs, ys, zs = append(xs, x), append(ys, y), append(zs, z)
+		}
+	}
+	source := "ground points"
+	if len(xs) == 0 {
+		println("Warning: the file has no ground points; its last returns are interpolated instead.")
+		source = "last returns"
+		for n := int64(0); n < numPoints; n++ {
+			if p := input.GetPointData(n); isUsable(p) && p.BitField.IsLastReturn() {
+				x, y, z := input.GetPointXYZ(n)
+				xs, ys, zs = append(xs, x), append(ys, y), append(zs, z)
+			}
+		}
+	}
+	printf("Number of %s: %v\n", source, len(xs))
+
+	start2 := time.Now()
+
+	xs, ys, zs = mergeCoincidentPoints(xs, ys, zs)
+	if this.method == "tin" && len(xs) < 3 {
+		println("At least three points at different locations are needed.")
+		return
+	} else if len(xs) < 1 {
+		println("There are no points to interpolate.")
+		return
+	}
+
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+
+	cellSize := this.cellSize
+	columns := int(math.Floor((maxX-minX)/cellSize+0.5)) + 1
+	rows := int(math.Floor((maxY-minY)/cellSize+0.5)) + 1
+	west := minX - cellSize/2.0
+	north := maxY + cellSize/2.0
+	east := west + float64(columns)*cellSize
+	south := north - float64(rows)*cellSize
+	printf("Output grid: %v rows x %v columns\n", rows, columns)
+
+	// create the output raster
+	nodata := -32768.0
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.EPSGCode = this.epsgCode
+	if this.epsgCode == 0 {
+		if config.EPSGCode = input.GetEPSGCode(); config.EPSGCode == 0 {
+			config.CoordinateRefSystemWKT = input.GetWKT()
+		}
+	}
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	if this.method == "tin" {
+		println("Triangulating...")
+		triangles := delaunayTriangulation(xs, ys)
+		printf("Number of triangles: %v\n", len(triangles))
+		if len(triangles) == 0 {
+			println("The points are collinear and can't be triangulated.")
+			return
+		}
+		numSkipped := interpolateTriangles(rout, xs, ys, zs, triangles, this.maxDistance)
+		if numSkipped > 0 {
+			printf("Triangles longer than the maximum distance: %v\n", numSkipped)
+		}
+	} else {
+		k := idwNeighbours
+		if k > len(xs) {
+			k = len(xs)
+		}
+		index := newPointIndex(xs, ys, k)
+		numCPUs := this.toolManager.numThreads()
+		c1 := make(chan bool, rows)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for cpu := 0; cpu < numCPUs; cpu++ {
+			wg.Add(1)
+			go func(cpu int) {
+				defer wg.Done()
+				neighbours := make([]int, 0, k)
+				for row := cpu; row < rows; row += numCPUs {
+					if interrupted() {
+						c1 <- true
+						continue
+					}
+					y := north - (float64(row)+0.5)*cellSize
+					for col := 0; col < columns; col++ {
+						x := west + (float64(col)+0.5)*cellSize
+						neighbours = index.nearest(x, y, k, neighbours[:0])
+						var sumW, sumZ float64
+						for _, i := range neighbours {
+							d := math.Hypot(xs[i]-x, ys[i]-y)
+							if this.maxDistance > 0 && d > this.maxDistance {
+								break // the neighbours are nearest first
+							}
+							if d == 0 {
+								sumW, sumZ = 1, zs[i]
+								break
+							}
+							w := 1 / math.Pow(d, idwPower)
+							sumW += w
+							sumZ += w * zs[i]
+						}
+						if sumW > 0 {
+							rout.SetValue(row, col, sumZ/sumW)
+						}
+					}
+					c1 <- true
+				}
+			}(cpu)
+		}
+
+		var progress, oldProgress int
+		var eta progressETA
+		oldProgress = -1
+		for row := 0; row < rows; row++ {
+			<-c1
+			progress = int(100.0 * float64(row+1) / float64(rows))
+			if progress != oldProgress {
+				printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+				oldProgress = progress
+			}
+		}
+		printf("\n")
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by LidarToDEM tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Input file: %s", this.inputFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Interpolated %s by %s", source, strings.ToUpper(this.method)))
+	if this.maxDistance > 0 {
+		rout.AddMetadataEntry(fmt.Sprintf("Maximum distance: %v", this.maxDistance))
+	}
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
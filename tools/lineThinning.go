@@ -0,0 +1,232 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// LineThinning skeletonizes the features of a Boolean raster, e.g. a stream
+// or valley-bottom mask, to lines one cell wide.
+type LineThinning struct {
+	inputFile   string
+	outputFile  string
+	toolManager *PluginToolManager
+}
+
+func (this *LineThinning) GetName() string {
+	s := "LineThinning"
+	return getFormattedToolName(s)
+}
+
+func (this *LineThinning) GetDescription() string {
+	s := "Thins the features of a Boolean raster to lines"
+	return getFormattedToolDescription(s)
+}
+
+func (this *LineThinning) GetVersion() string {
+	return "1.0"
+}
+
+func (this *LineThinning) GetHelpDocumentation() string {
+	ret := "This tool thins, or skeletonizes, the features of a Boolean raster, i.e. its " +
+		"cells with values greater than zero, e.g. a mask of wide streams or valley " +
+		"bottoms, to lines one cell wide, so that they can be vectorized as networks, e.g. " +
+		"by RasterStreamsToVector. Cells are removed from the boundaries of the features, " +
+		"alternately from their south-east and north-west sides, by the method of Zhang " +
+		"and Suen (1984), Communications of the ACM, 27(3), 236-239, until only their " +
+		"skeletons remain, keeping the cells that connect their neighbours, so that the " +
+		"connections, junctions and loops of a network are preserved, and the ends of " +
+		"lines. Lines that are already one cell wide are left as they are. Feature cells " +
+		"of the output are assigned 1, other cells 0, and nodata cells of the input nodata."
+	return ret
+}
+
+func (this *LineThinning) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *LineThinning) GetArgDescriptions() []ToolArg {
+	numArgs := 2
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input Boolean raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	return ret
+}
+
+func (this *LineThinning) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters and the feature cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+1)
+}
+
+func (this *LineThinning) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input and output files must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.Run()
+}
+
+func (this *LineThinning) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the Boolean raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	this.Run()
+}
+
+func (this *LineThinning) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *LineThinning) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *LineThinning) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+
+	start2 := time.Now()
+
+	cells := make([]bool, rows*columns)
+	numFeatureCells := 0
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z := rin.Value(row, col); z != nodata && z > 0 {
+				cells[row*columns+col] = true
+				numFeatureCells++
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 1 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	printf("\r                                                           ")
+	printf("\rThinning...")
+	removed := thinCells(cells, rows, columns)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_INT16
+	config.NoDataValue = -32768
+	config.InitialValue = 0
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if rin.Value(row, col) == nodata {
+				rout.SetValue(row, col, config.NoDataValue)
+			} else if cells[row*columns+col] {
+				rout.SetValue(row, col, 1)
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 2 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by LineThinning tool (%s)", this.toolManager.toolVersion(this)))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Feature cells: %v, of which %v were removed by thinning\n", numFeatureCells, removed)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
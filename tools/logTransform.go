@@ -0,0 +1,56 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// logTransform is the method used to log-transform accumulation outputs,
+// which span many orders of magnitude.
+type logTransform int
+
+const (
+	// ln(x); cells of zero or negative x, e.g. zero-area cells of weighted
+	// accumulation, are assigned nodata rather than -Inf or NaN
+	lnTransform logTransform = iota
+	// ln(1 + x), which is zero where x is zero and defined for all x > -1
+	ln1pTransform
+)
+
+var logTransformNames = []string{"ln", "ln1p"}
+
+var unknownLogTransformError = errors.New("Unrecognized log transform; use 'ln' or 'ln1p'.")
+
+func parseLogTransform(s string) (logTransform, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range logTransformNames {
+		if s == name {
+			return logTransform(i), nil
+		}
+	}
+	return lnTransform, unknownLogTransformError
+}
+
+func (t logTransform) String() string {
+	return logTransformNames[t]
+}
+
+// apply returns the transformed value of z and whether it is defined. An
+// undefined value is never -Inf or NaN, and its cell should be nodata.
+func (t logTransform) apply(z float64) (float64, bool) {
+	if t == ln1pTransform {
+		if !(z > -1) || math.IsInf(z, 1) {
+			return 0, false
+		}
+		return math.Log1p(z), true
+	}
+	if !(z > 0) || math.IsInf(z, 1) {
+		return 0, false
+	}
+	return math.Log(z), true
+}
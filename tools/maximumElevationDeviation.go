@@ -41,6 +41,10 @@ func (this *MaximumElevationDeviation) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *MaximumElevationDeviation) GetVersion() string {
+	return "1.0"
+}
+
 func (this *MaximumElevationDeviation) GetHelpDocumentation() string {
 	ret := "This tool is used to remove the sinks (i.e. topographic depressions and flat areas) from digital elevation models (DEMs) using an efficient depression filling method. Note that the BreachDepressions tool is the preferred method of creating a depressionless DEM."
 	return ret
@@ -50,40 +54,52 @@ func (this *MaximumElevationDeviation) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *MaximumElevationDeviation) GetArgDescriptions() [][]string {
+func (this *MaximumElevationDeviation) GetArgDescriptions() []ToolArg {
 	numArgs := 6
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputDEM"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM name, with directory and file extension"
-
-	ret[1][0] = "OutputMagnitudeFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The magnitude output filename, with directory and file extension"
-
-	ret[2][0] = "OutputScaleFile"
-	ret[2][1] = "string"
-	ret[2][2] = "The scale output filename, with directory and file extension"
-
-	ret[3][0] = "MinNeighbourhoodSize"
-	ret[3][1] = "int"
-	ret[3][2] = "The starting radius of the neighbourhood in grid cells"
-
-	ret[4][0] = "MaxNeighbourhoodSize"
-	ret[4][1] = "int"
-	ret[4][2] = "The ending radius of the neighbourhood in grid cells"
-
-	ret[5][0] = "NeighbourhoodStep"
-	ret[5][1] = "int"
-	ret[5][2] = "The neighbourhood step size in grid cells"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputMagnitudeFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The magnitude output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "OutputScaleFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The scale output filename, or the magnitude filename to save both as pages of one GeoTIFF"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "MinNeighbourhoodSize"
+	ret[3].Type = "int"
+	ret[3].Description = "The starting radius of the neighbourhood in grid cells"
+	ret[3].Default = "1"
+
+	ret[4].Name = "MaxNeighbourhoodSize"
+	ret[4].Type = "int"
+	ret[4].Description = "The ending radius of the neighbourhood in grid cells"
+	ret[4].Default = "3"
+
+	ret[5].Name = "NeighbourhoodStep"
+	ret[5].Type = "int"
+	ret[5].Description = "The neighbourhood step size in grid cells"
+	ret[5].Default = "1"
 
 	return ret
 }
 
+func (this *MaximumElevationDeviation) EstimateMemory(rows, columns int) int64 {
+	// the input and two output rasters, the integral images and the
+	// per-cell maximum deviation, scale and z-score grids
+	return gridBytes(rows, columns, 3*rasterBytesPerCell+3*8+3*8)
+}
+
 func (this *MaximumElevationDeviation) ParseArguments(args []string) {
 	inputFile := args[0]
 	inputFile = strings.TrimSpace(inputFile)
@@ -92,7 +108,7 @@ func (this *MaximumElevationDeviation) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -103,7 +119,7 @@ func (this *MaximumElevationDeviation) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.magOutputFile = outputFile
 
@@ -114,7 +130,7 @@ func (this *MaximumElevationDeviation) ParseArguments(args []string) {
 	}
 	rasterType, err = raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.scaleOutputFile = outputFile
 
@@ -169,7 +185,7 @@ func (this *MaximumElevationDeviation) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -186,7 +202,7 @@ func (this *MaximumElevationDeviation) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.magOutputFile = outputFile
 
@@ -202,7 +218,7 @@ func (this *MaximumElevationDeviation) CollectArguments() {
 	}
 	rasterType, err = raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.scaleOutputFile = outputFile
 
@@ -276,6 +292,14 @@ func (this *MaximumElevationDeviation) Run() {
 	// var outValue, v, s, m float64
 	var str string
 
+	// the scale is saved as the second page of the magnitude file if the two
+	// are the same
+	appendScale := this.scaleOutputFile == this.magOutputFile
+	if rt, err := raster.DetermineRasterFormat(this.magOutputFile); appendScale && (err != nil || rt != raster.RT_GeoTiff) {
+		println("The magnitude and scale outputs can only share a file if it is a GeoTIFF.")
+		return
+	}
+
 	fmt.Println("Reading raster data...")
 	rin, err := raster.CreateRasterFromFile(this.inputFile)
 	if err != nil {
@@ -347,7 +371,7 @@ func (this *MaximumElevationDeviation) Run() {
 
 	// fmt.Println("\r                                    ")
 
-	numCPUs := runtime.NumCPU()
+	numCPUs := this.toolManager.numThreads()
 
 	oldProgress = -1
 	loopNum := 1
@@ -562,6 +586,7 @@ func (this *MaximumElevationDeviation) Run() {
 	config2.InitialValue = nodata
 	config2.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
 	config2.EPSGCode = inConfig.EPSGCode
+	config2.AppendPage = appendScale
 	rout2, err := raster.CreateNewRaster(this.scaleOutputFile, rows, columns,
 		rin.North, rin.South, rin.East, rin.West, config2)
 	if err != nil {
@@ -593,14 +618,14 @@ func (this *MaximumElevationDeviation) Run() {
 	rout1.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout1.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout1.AddMetadataEntry(fmt.Sprintf("Created by ElevationPercentile tool"))
+	rout1.AddMetadataEntry(fmt.Sprintf("Created by MaxElevationDeviation tool (%s)", this.toolManager.toolVersion(this)))
 	rout1.AddMetadataEntry(fmt.Sprintf("Min. window size: %v", (this.minNeighbourhood*2 + 1)))
 	rout1.AddMetadataEntry(fmt.Sprintf("Max. window size: %v", (this.maxNeighbourhood*2 + 1)))
 	rout1.AddMetadataEntry(fmt.Sprintf("Step size: %v", this.neighbourhoodStep))
 
 	rout2.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	rout2.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout2.AddMetadataEntry(fmt.Sprintf("Created by ElevationPercentile tool"))
+	rout2.AddMetadataEntry(fmt.Sprintf("Created by MaxElevationDeviation tool (%s)", this.toolManager.toolVersion(this)))
 	rout2.AddMetadataEntry(fmt.Sprintf("Min. window size: %v", (this.minNeighbourhood*2 + 1)))
 	rout2.AddMetadataEntry(fmt.Sprintf("Max. window size: %v", (this.maxNeighbourhood*2 + 1)))
 	rout2.AddMetadataEntry(fmt.Sprintf("Step size: %v", this.neighbourhoodStep))
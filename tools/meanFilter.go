@@ -40,6 +40,10 @@ func (this *MeanFilter) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *MeanFilter) GetVersion() string {
+	return "1.0"
+}
+
 func (this *MeanFilter) GetHelpDocumentation() string {
 	ret := ""
 	return ret
@@ -49,28 +53,30 @@ func (this *MeanFilter) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *MeanFilter) GetArgDescriptions() [][]string {
+func (this *MeanFilter) GetArgDescriptions() []ToolArg {
 	numArgs := 4
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input DEM File name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
-	ret[2][0] = "FilterSizeX"
-	ret[2][1] = "integer"
-	ret[2][2] = "Filter size in the X direction"
+	ret[2].Name = "FilterSizeX"
+	ret[2].Type = "integer"
+	ret[2].Description = "Filter size in the X direction"
+	ret[2].Default = "3"
 
-	ret[3][0] = "FilterSizeY"
-	ret[3][1] = "integer"
-	ret[3][2] = "Filter size in the Y direction"
+	ret[3].Name = "FilterSizeY"
+	ret[3].Type = "integer"
+	ret[3].Description = "Filter size in the Y direction"
 
 	return ret
 }
@@ -86,7 +92,7 @@ func (this *MeanFilter) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -97,7 +103,7 @@ func (this *MeanFilter) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -141,7 +147,7 @@ func (this *MeanFilter) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		fmt.Printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -158,7 +164,7 @@ func (this *MeanFilter) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -217,7 +223,7 @@ func (this *MeanFilter) Run() {
 
 	// create the output raster
 	config := raster.NewDefaultRasterConfig()
-	config.PreferredPalette = inConfig.PreferredPalette
+	config.CopyDisplaySettings(inConfig)
 	config.DataType = raster.DT_FLOAT32
 	config.NoDataValue = nodata
 	config.InitialValue = nodata
@@ -230,7 +236,7 @@ func (this *MeanFilter) Run() {
 		return
 	}
 
-	numCPUs := runtime.NumCPU()
+	numCPUs := this.toolManager.numThreads()
 	c1 := make(chan int)
 	runtime.GOMAXPROCS(numCPUs)
 	var wg sync.WaitGroup
@@ -317,7 +323,7 @@ func (this *MeanFilter) Run() {
 	elapsed := time.Since(start2)
 
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by Slope"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by MeanFilter tool (%s)", this.toolManager.toolVersion(this)))
 	rout.Save()
 
 	fmt.Println("Operation complete!")
@@ -0,0 +1,207 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// MrRTF calculates the multiresolution ridge top flatness index of Gallant
+// and Dowling (2003).
+type MrRTF struct {
+	inputFile      string
+	outputFile     string
+	slopeThreshold float64
+	maxSteps       int
+	toolManager    *PluginToolManager
+}
+
+func (this *MrRTF) GetName() string {
+	s := "MrRTF"
+	return getFormattedToolName(s)
+}
+
+func (this *MrRTF) GetDescription() string {
+	s := "Calculates the multiresolution ridge top flatness index"
+	return getFormattedToolDescription(s)
+}
+
+func (this *MrRTF) GetVersion() string {
+	return "1.0"
+}
+
+func (this *MrRTF) GetHelpDocumentation() string {
+	ret := "This tool calculates the multiresolution ridge top flatness (MrRTF) index of " +
+		"Gallant and Dowling (2003), Water Resources Research, 39(12), 1347, which identifies " +
+		"ridge tops and plateaus, i.e. areas that are both flat and high relative to their " +
+		"surroundings, at a series of increasingly coarse scales. It is calculated as the " +
+		"MrVBF index is, with lowness replaced by highness, the proportion of the cells " +
+		"within the neighbourhood that are higher than the cell; see MrVBF for the steps and " +
+		"the InitialSlopeThreshold and MaxSteps. Values below 0.5 are not ridge tops, and " +
+		"each higher unit indicates ridge tops about 3 times as broad."
+	return ret
+}
+
+func (this *MrRTF) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *MrRTF) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "InitialSlopeThreshold"
+	ret[2].Type = "float64"
+	ret[2].Description = "The slope threshold of the first step, in percent"
+	ret[2].Default = "16"
+
+	ret[3].Name = "MaxSteps"
+	ret[3].Type = "int"
+	ret[3].Description = "The maximum number of steps, or 0 for as many as the DEM allows"
+	ret[3].Default = "0"
+
+	return ret
+}
+
+func (this *MrRTF) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the flatness and lowness of a step, the
+	// index and combined flatness, and the first aggregated DEM
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+8+8) + gridBytes(rows/3+1, columns/3+1, rasterBytesPerCell)
+}
+
+func (this *MrRTF) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.slopeThreshold = 16.0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		val, err := strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.slopeThreshold = val
+	}
+	this.maxSteps = 0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		val, err := strconv.Atoi(strings.TrimSpace(args[3]))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.maxSteps = val
+	}
+
+	this.Run()
+}
+
+func (this *MrRTF) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the initial slope threshold
+	this.slopeThreshold = 16.0
+	print("Initial slope threshold in percent (default 16): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.slopeThreshold = val
+	}
+
+	// get the maximum number of steps
+	this.maxSteps = 0
+	print("Maximum number of steps (blank for as many as the DEM allows): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		val, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.maxSteps = val
+	}
+
+	this.Run()
+}
+
+func (this *MrRTF) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *MrRTF) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *MrRTF) Run() {
+	runMultiresolutionFlatness(this, this.inputFile, this.outputFile, true, this.slopeThreshold,
+		this.maxSteps, this.toolManager)
+}
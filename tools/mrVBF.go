@@ -0,0 +1,498 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// MrVBF calculates the multiresolution valley bottom flatness index of
+// Gallant and Dowling (2003).
+type MrVBF struct {
+	inputFile      string
+	outputFile     string
+	slopeThreshold float64
+	maxSteps       int
+	toolManager    *PluginToolManager
+}
+
+func (this *MrVBF) GetName() string {
+	s := "MrVBF"
+	return getFormattedToolName(s)
+}
+
+func (this *MrVBF) GetDescription() string {
+	s := "Calculates the multiresolution valley bottom flatness index"
+	return getFormattedToolDescription(s)
+}
+
+func (this *MrVBF) GetVersion() string {
+	return "1.0"
+}
+
+func (this *MrVBF) GetHelpDocumentation() string {
+	ret := "This tool calculates the multiresolution valley bottom flatness (MrVBF) index " +
+		"of Gallant and Dowling (2003), Water Resources Research, 39(12), 1347, which " +
+		"identifies valley bottoms, i.e. areas that are both flat and low relative to " +
+		"their surroundings, at a series of increasingly coarse scales, e.g. for mapping " +
+		"floodplains and depositional areas. At each step, flatness is measured by slope, " +
+		"against a threshold that starts at the InitialSlopeThreshold, in percent (default " +
+		"16), and halves at each step, and lowness by the proportion of the cells within a " +
+		"radius of 3 cells (6 cells after the first step) that are lower than the cell, " +
+		"with those of equal elevation counting half. " +
+		"The first two steps use the DEM itself, and each later one a DEM aggregated by a " +
+		"further factor of 3, by the mean, whose results are interpolated back to the " +
+		"cells of the DEM. Steps continue, up to MaxSteps if it is greater than 0, while " +
+		"the aggregated DEM is at least 13 cells in each direction. Values below 0.5 are " +
+		"not valley bottoms; those from 0.5 to 1.5 are the smallest valley bottoms, and " +
+		"each higher unit indicates valley bottoms about 3 times as broad. Cell sizes in " +
+		"geographic coordinates are converted to metres. See MrRTF for the equivalent " +
+		"index of ridge tops."
+	return ret
+}
+
+func (this *MrVBF) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *MrVBF) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "InitialSlopeThreshold"
+	ret[2].Type = "float64"
+	ret[2].Description = "The slope threshold of the first step, in percent"
+	ret[2].Default = "16"
+
+	ret[3].Name = "MaxSteps"
+	ret[3].Type = "int"
+	ret[3].Description = "The maximum number of steps, or 0 for as many as the DEM allows"
+	ret[3].Default = "0"
+
+	return ret
+}
+
+func (this *MrVBF) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the flatness and lowness of a step, the
+	// index and combined flatness, and the first aggregated DEM
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+8+8) + gridBytes(rows/3+1, columns/3+1, rasterBytesPerCell)
+}
+
+func (this *MrVBF) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.slopeThreshold = 16.0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		val, err := strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.slopeThreshold = val
+	}
+	this.maxSteps = 0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		val, err := strconv.Atoi(strings.TrimSpace(args[3]))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.maxSteps = val
+	}
+
+	this.Run()
+}
+
+func (this *MrVBF) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the initial slope threshold
+	this.slopeThreshold = 16.0
+	print("Initial slope threshold in percent (default 16): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.slopeThreshold = val
+	}
+
+	// get the maximum number of steps
+	this.maxSteps = 0
+	print("Maximum number of steps (blank for as many as the DEM allows): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		val, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.maxSteps = val
+	}
+
+	this.Run()
+}
+
+func (this *MrVBF) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *MrVBF) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *MrVBF) Run() {
+	runMultiresolutionFlatness(this, this.inputFile, this.outputFile, false, this.slopeThreshold,
+		this.maxSteps, this.toolManager)
+}
+
+// runMultiresolutionFlatness runs the MrVBF tool, or the MrRTF tool if
+// ridgeTop is true.
+func runMultiresolutionFlatness(tool PluginTool, inputFile, outputFile string, ridgeTop bool,
+	slopeThreshold float64, maxSteps int, tm *PluginToolManager) {
+	start1 := time.Now()
+
+	if slopeThreshold <= 0 || maxSteps < 0 {
+		println("The slope threshold must be positive and the maximum number of steps can't be negative.")
+		return
+	}
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	numSteps := multiresolutionSteps(rows, columns, maxSteps)
+	index, err := multiresolutionFlatness(dem, ridgeTop, slopeThreshold, numSteps)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.PreferredPalette = "blueyellow.pal"
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if dem.Value(row, col) != nodata {
+				rout.SetValue(row, col, index[row*columns+col])
+			}
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by %s tool (%s)", tool.GetName(), tm.toolVersion(tool)))
+	rout.AddMetadataEntry(fmt.Sprintf("Initial slope threshold: %v%%, steps: %v", slopeThreshold, numSteps))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Number of steps: %v\n", numSteps)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// The minimum number of rows and columns of the aggregated DEM of a step,
+// i.e. the diameter of the neighbourhood of lowness.
+const multiresolutionMinCells = 13
+
+// multiresolutionSteps returns the number of steps of the multiresolution
+// flatness indices of a DEM, which continue while the aggregated DEM of each
+// step has enough cells, up to maxSteps if it is greater than 0.
+func multiresolutionSteps(rows, columns, maxSteps int) int {
+	numSteps := 2
+	for factor := 3; (rows+factor-1)/factor >= multiresolutionMinCells &&
+		(columns+factor-1)/factor >= multiresolutionMinCells; factor *= 3 {
+		numSteps++
+	}
+	if maxSteps > 0 && numSteps > maxSteps {
+		numSteps = maxSteps
+	}
+	return numSteps
+}
+
+// multiresolutionTransform is the function b(x, t, p) = 1 / (1 + (x/t)^p) of
+// Gallant and Dowling (2003), which falls from 1 at x = 0 through 0.5 at the
+// threshold t, more steeply for greater shapes p.
+func multiresolutionTransform(x, t, p float64) float64 {
+	return 1.0 / (1.0 + math.Pow(x/t, p))
+}
+
+// multiresolutionFlatness returns the multiresolution valley bottom flatness
+// index of Gallant and Dowling (2003) of each cell of a DEM over numSteps
+// steps, or, if ridgeTop, the multiresolution ridge top flatness index, for
+// which cells are high rather than low. Nodata cells are left zero.
+func multiresolutionFlatness(dem *raster.Raster, ridgeTop bool, slopeThreshold float64, numSteps int) ([]float64, error) {
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+
+	index := make([]float64, rows*columns)
+	combinedFlatness := make([]float64, rows*columns)
+	for i := range combinedFlatness {
+		combinedFlatness[i] = 1
+	}
+
+	level := dem
+	factor := 1
+	var err error
+	for step := 1; step <= numSteps; step++ {
+		printf("\rStep %v of %v", step, numSteps)
+		radius := 6
+		if step == 1 {
+			radius = 3
+		}
+		if step >= 3 {
+			if level, err = aggregateMean(level, 3); err != nil {
+				return nil, err
+			}
+			factor *= 3
+		}
+		threshold := slopeThreshold / math.Pow(2, float64(step-1))
+		flatness, lowness, err := flatnessAndLowness(level, cellSizeX*float64(factor),
+			cellSizeY*float64(factor), radius, threshold, ridgeTop)
+		if err != nil {
+			return nil, err
+		}
+
+		// combine the step with the previous ones at the resolution of the DEM
+		for row := 0; row < rows; row++ {
+			y := dem.North - (float64(row)+0.5)*dem.GetCellSizeY()
+			for col := 0; col < columns; col++ {
+				if dem.Value(row, col) == nodata {
+					continue
+				}
+				var f, l float64
+				if factor == 1 {
+					f, l = flatness.Value(row, col), lowness.Value(row, col)
+				} else {
+					x := dem.West + (float64(col)+0.5)*dem.GetCellSizeX()
+					f, l = flatness.SampleBilinear(x, y), lowness.SampleBilinear(x, y)
+				}
+				if f == nodata || l == nodata {
+					continue
+				}
+				i := row*columns + col
+				combinedFlatness[i] *= f
+				vf := 1.0 - multiresolutionTransform(combinedFlatness[i]*l, 0.3, 4)
+				if step == 1 {
+					index[i] = vf
+				} else {
+					w := 1.0 - multiresolutionTransform(vf, 0.4, 6.68)
+					index[i] = w*(float64(step-1)+vf) + (1-w)*index[i]
+				}
+			}
+		}
+	}
+	return index, nil
+}
+
+// flatnessAndLowness returns the flatness of each cell of a DEM, the
+// transformed percent slope, against the slope threshold, and its lowness,
+// the transformed proportion of the cells within radius cells that are lower,
+// or higher if ridgeTop, as scratch rasters. Cells of equal elevation count
+// as half lower, so that a flat is neither low nor high.
+func flatnessAndLowness(dem *raster.Raster, cellSizeX, cellSizeY float64, radius int,
+	slopeThreshold float64, ridgeTop bool) (flatness, lowness *raster.Raster, err error) {
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	if flatness, err = newScratchRaster(dem, rows, columns, dem.South, dem.East); err != nil {
+		return nil, nil, err
+	}
+	if lowness, err = newScratchRaster(dem, rows, columns, dem.South, dem.East); err != nil {
+		return nil, nil, err
+	}
+
+	// the offsets of the cells of a circular neighbourhood
+	var dx, dy []int
+	for r := -radius; r <= radius; r++ {
+		for c := -radius; c <= radius; c++ {
+			if (r != 0 || c != 0) && r*r+c*c <= radius*radius {
+				dx = append(dx, c)
+				dy = append(dy, r)
+			}
+		}
+	}
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			d, ok := hornDerivatives(dem, row, col, cellSizeX, cellSizeY)
+			if !ok {
+				continue
+			}
+			slope := 100.0 * math.Sqrt(d.p*d.p+d.q*d.q)
+			flatness.SetValue(row, col, multiresolutionTransform(slope, slopeThreshold, 4))
+
+			z := dem.Value(row, col)
+			var n, numLower, numEqual int
+			for k := range dx {
+				zn := dem.Value(row+dy[k], col+dx[k])
+				if zn == nodata {
+					continue
+				}
+				n++
+				if zn == z {
+					numEqual++
+				} else if (zn < z) != ridgeTop {
+					numLower++
+				}
+			}
+			percentile := 0.0
+			if n > 0 {
+				percentile = (float64(numLower) + 0.5*float64(numEqual)) / float64(n)
+			}
+			lowness.SetValue(row, col, multiresolutionTransform(percentile, 0.4, 3))
+		}
+	}
+	return flatness, lowness, nil
+}
+
+// aggregateMean returns a scratch raster of the means of the valid cells of
+// factor x factor blocks of a raster. The extent grows to cover any partial
+// blocks at the southern and eastern edges.
+func aggregateMean(r *raster.Raster, factor int) (*raster.Raster, error) {
+	rows := (r.Rows + factor - 1) / factor
+	columns := (r.Columns + factor - 1) / factor
+	nodata := r.NoDataValue
+	south := r.North - float64(rows)*r.GetCellSizeY()*float64(factor)
+	east := r.West + float64(columns)*r.GetCellSizeX()*float64(factor)
+	ret, err := newScratchRaster(r, rows, columns, south, east)
+	if err != nil {
+		return nil, err
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			var sum float64
+			n := 0
+			for i := row * factor; i < (row+1)*factor && i < r.Rows; i++ {
+				for j := col * factor; j < (col+1)*factor && j < r.Columns; j++ {
+					if z := r.Value(i, j); z != nodata {
+						sum += z
+						n++
+					}
+				}
+			}
+			if n > 0 {
+				ret.SetValue(row, col, sum/float64(n))
+			}
+		}
+	}
+	return ret, nil
+}
+
+// newScratchRaster returns an unsaved raster of nodata cells sharing the
+// north-west corner and nodata value of r, with the given dimensions and
+// southern and eastern edges.
+func newScratchRaster(r *raster.Raster, rows, columns int, south, east float64) (*raster.Raster, error) {
+	config := raster.NewDefaultRasterConfig()
+	config.RasterFormat = raster.RT_GeoTiff
+	config.DataType = raster.DT_FLOAT64
+	config.NoDataValue = r.NoDataValue
+	config.InitialValue = r.NoDataValue
+	return raster.CreateNewRaster("", rows, columns, r.North, south, east, r.West, config)
+}
@@ -0,0 +1,406 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// MultiscaleSignature extracts the deviation from mean (DEV) and elevation
+// percentile of a set of sample points across a range of neighbourhood
+// sizes, i.e. their multiscale signatures, to a CSV file.
+type MultiscaleSignature struct {
+	inputFile         string
+	pointsFile        string
+	outputFile        string
+	minNeighbourhood  int
+	maxNeighbourhood  int
+	neighbourhoodStep int
+	toolManager       *PluginToolManager
+}
+
+func (this *MultiscaleSignature) GetName() string {
+	s := "MultiscaleSignature"
+	return getFormattedToolName(s)
+}
+
+func (this *MultiscaleSignature) GetDescription() string {
+	s := "Extracts multiscale DEV and percentile signatures"
+	return getFormattedToolDescription(s)
+}
+
+func (this *MultiscaleSignature) GetVersion() string {
+	return "1.0"
+}
+
+func (this *MultiscaleSignature) GetHelpDocumentation() string {
+	ret := "This tool extracts the multiscale signatures of a set of sample points, i.e. the " +
+		"deviation from mean elevation (DEV) and the elevation percentile of each point " +
+		"calculated for every neighbourhood radius from MinNeighbourhoodSize to " +
+		"MaxNeighbourhoodSize in steps of NeighbourhoodStep grid cells, without creating a " +
+		"raster for each scale. DEV is calculated as in the MaximumElevationDeviation tool and " +
+		"the percentile is the percentage of the valid cells in the neighbourhood that are " +
+		"lower than the point, with ties counting one half. Neighbourhoods are clipped at the " +
+		"edges of the DEM. The points file contains one 'x y' pair per line, optionally " +
+		"followed by a label, and may begin with a header line. The output is a CSV file " +
+		"with one line per point and scale."
+	return ret
+}
+
+func (this *MultiscaleSignature) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *MultiscaleSignature) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "PointsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "A text file of sample point 'x y [label]' lines"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output CSV filename, with directory and file extension"
+	ret[2].Role = ArgOutput
+	ret[2].Required = true
+
+	ret[3].Name = "MinNeighbourhoodSize"
+	ret[3].Type = "int"
+	ret[3].Description = "The starting radius of the neighbourhood in grid cells"
+	ret[3].Default = "1"
+
+	ret[4].Name = "MaxNeighbourhoodSize"
+	ret[4].Type = "int"
+	ret[4].Description = "The ending radius of the neighbourhood in grid cells"
+	ret[4].Default = "3"
+
+	ret[5].Name = "NeighbourhoodStep"
+	ret[5].Type = "int"
+	ret[5].Description = "The neighbourhood step size in grid cells"
+	ret[5].Default = "1"
+
+	return ret
+}
+
+func (this *MultiscaleSignature) EstimateMemory(rows, columns int) int64 {
+	// only the DEM is held in memory
+	return gridBytes(rows, columns, rasterBytesPerCell)
+}
+
+func (this *MultiscaleSignature) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input DEM, points file, and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	pointsFile := strings.TrimSpace(args[1])
+	if !strings.Contains(pointsFile, pathSep) {
+		pointsFile = this.toolManager.workingDirectory + pointsFile
+	}
+	this.pointsFile = pointsFile
+	if _, err := os.Stat(this.pointsFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.pointsFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[2])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+
+	this.minNeighbourhood = 1
+	this.maxNeighbourhood = 3
+	this.neighbourhoodStep = 1
+	values := []*int{&this.minNeighbourhood, &this.maxNeighbourhood, &this.neighbourhoodStep}
+	for i, v := range values {
+		if len(args) > i+3 && len(strings.TrimSpace(args[i+3])) > 0 && args[i+3] != "not specified" {
+			val, err := strconv.Atoi(strings.TrimSpace(args[i+3]))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	this.Run()
+}
+
+func (this *MultiscaleSignature) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the points file name
+	print("Enter the sample points file name (incl. file extension): ")
+	pointsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	pointsFile = strings.TrimSpace(pointsFile)
+	if !strings.Contains(pointsFile, pathSep) {
+		pointsFile = this.toolManager.workingDirectory + pointsFile
+	}
+	this.pointsFile = pointsFile
+	if _, err := os.Stat(this.pointsFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.pointsFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output CSV file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+
+	// get the neighbourhood sizes
+	this.minNeighbourhood = 1
+	this.maxNeighbourhood = 3
+	this.neighbourhoodStep = 1
+	prompts := []string{"Minimum neighbourhood radius in grid cells (default 1): ",
+		"Maximum neighbourhood radius in grid cells (default 3): ",
+		"Neighbourhood step size in grid cells (default 1): "}
+	values := []*int{&this.minNeighbourhood, &this.maxNeighbourhood, &this.neighbourhoodStep}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.Atoi(strings.TrimSpace(str))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	this.Run()
+}
+
+func (this *MultiscaleSignature) Run() {
+	start1 := time.Now()
+
+	if this.minNeighbourhood < 1 || this.maxNeighbourhood < this.minNeighbourhood || this.neighbourhoodStep < 1 {
+		println("The neighbourhood sizes must satisfy 1 <= min <= max and the step must be at least 1.")
+		return
+	}
+
+	points, err := readPointFile(this.pointsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Reading DEM data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+
+	start2 := time.Now()
+
+	w, err := createTable(this.outputFile, "point", "x", "y", "scale", "n", "dev", "percentile")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	numSkipped := 0
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for p, pt := range points {
+		row := int(math.Floor((rin.North - pt.y) / cellSizeY))
+		col := int(math.Floor((pt.x - rin.West) / cellSizeX))
+		if row < 0 || row >= rows || col < 0 || col >= columns || rin.Value(row, col) == nodata {
+			printf("Warning: point %s is outside of the DEM or on a nodata cell.\n", pt.label)
+			numSkipped++
+			continue
+		}
+
+		z0 := rin.Value(row, col)
+
+		// grow the neighbourhood one ring of cells at a time, accumulating
+		// deviations from the point's elevation for numerical stability
+		var sum, sumSqr, numLess, numEqual float64
+		var n int
+		addCell := func(r, c int) {
+			if r < 0 || r >= rows || c < 0 || c >= columns {
+				return
+			}
+			z := rin.Value(r, c)
+			if z == nodata {
+				return
+			}
+			d := z - z0
+			sum += d
+			sumSqr += d * d
+			n++
+			if z < z0 {
+				numLess++
+			} else if z == z0 {
+				numEqual++
+			}
+		}
+		addCell(row, col)
+		for radius := 1; radius <= this.maxNeighbourhood; radius++ {
+			for c := col - radius; c <= col+radius; c++ {
+				addCell(row-radius, c)
+				addCell(row+radius, c)
+			}
+			for r := row - radius + 1; r <= row+radius-1; r++ {
+				addCell(r, col-radius)
+				addCell(r, col+radius)
+			}
+			if radius < this.minNeighbourhood || (radius-this.minNeighbourhood)%this.neighbourhoodStep != 0 {
+				continue
+			}
+			N := float64(n)
+			mean := sum / N
+			dev := 0.0
+			if v := sumSqr/N - mean*mean; v > 0 {
+				dev = -mean / math.Sqrt(v)
+			}
+			percentile := 100.0 * (numLess + 0.5*(numEqual-1)) / N
+			w.writeRow(pt.label, pt.x, pt.y, radius, n, strconv.FormatFloat(dev, 'f', 4, 64),
+				strconv.FormatFloat(percentile, 'f', 4, 64))
+		}
+
+		progress = int(100.0 * float64(p+1) / float64(len(points)))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	if err = w.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	elapsed := time.Since(start2)
+
+	printf("\nSignatures were extracted for %v of %v points\n", len(points)-numSkipped, len(points))
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// samplePoint is a labelled location read from a points file.
+type samplePoint struct {
+	label string
+	x, y  float64
+}
+
+// readPointFile reads sample points from a text file containing one 'x y'
+// (or 'x,y') pair per line, optionally followed by a label. Points without a
+// label are numbered from 1. A first line that can't be read as a point is
+// treated as a header.
+func readPointFile(fileName string) ([]samplePoint, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]samplePoint, 0)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ','
+		})
+		var x, y float64
+		var err1, err2 error
+		if len(fields) >= 2 {
+			x, err1 = strconv.ParseFloat(fields[0], 64)
+			y, err2 = strconv.ParseFloat(fields[1], 64)
+		}
+		if len(fields) < 2 || err1 != nil || err2 != nil {
+			if i == 0 {
+				continue // a header
+			}
+			return nil, fmt.Errorf("Unable to read the point '%s'.", line)
+		}
+		label := strconv.Itoa(len(points) + 1)
+		if len(fields) > 2 {
+			label = fields[2]
+		}
+		points = append(points, samplePoint{label, x, y})
+	}
+	if len(points) == 0 {
+		return nil, errors.New("The points file does not contain any points.")
+	}
+	return points, nil
+}
@@ -0,0 +1,468 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// NodataMargin trims the unreliable margins of the data of a raster, next to
+// its nodata cells, or extends the data into its nodata margins.
+type NodataMargin struct {
+	inputFile   string
+	outputFile  string
+	operation   string
+	cells       int
+	gridEdges   bool
+	toolManager *PluginToolManager
+}
+
+var nodataMarginOperations = []string{"shrink", "expand"}
+
+func (this *NodataMargin) GetName() string {
+	s := "NodataMargin"
+	return getFormattedToolName(s)
+}
+
+func (this *NodataMargin) GetDescription() string {
+	s := "Shrinks or expands the data at the nodata margins of a raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *NodataMargin) GetVersion() string {
+	return "1.0"
+}
+
+func (this *NodataMargin) GetHelpDocumentation() string {
+	ret := "This tool adjusts the margins between the data and the nodata cells of a raster, " +
+		"e.g. of a DEM interpolated from survey points, whose edge cells would otherwise " +
+		"contaminate slope and accumulation statistics. The 'shrink' operation expands the " +
+		"nodata region inward by the given number of Cells, assigning nodata to every cell " +
+		"within that many cells, including diagonally, of a nodata cell, or also of the edge " +
+		"of the grid if GridEdges is true, to remove unreliable interpolated edges. The " +
+		"'expand' operation fills the nodata cells within that many cells of the data, " +
+		"including small interior holes, one ring of cells at a time, so that neighbourhood " +
+		"operations have values to work with at the edges of the data. Each filled cell is " +
+		"extrapolated linearly from the two cells beyond it in each direction in which both " +
+		"have values, i.e. 2a - b for the nearer cell a and the farther cell b, averaged over " +
+		"those directions, or, if there are none, is the mean of its neighbours with values. " +
+		"Since errors grow with each ring, only a few cells should be extrapolated."
+	return ret
+}
+
+func (this *NodataMargin) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *NodataMargin) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Operation"
+	ret[2].Type = "string"
+	ret[2].Description = "shrink or expand"
+	ret[2].Required = true
+	ret[2].Choices = nodataMarginOperations
+
+	ret[3].Name = "Cells"
+	ret[3].Type = "int"
+	ret[3].Description = "The width of the margin, in cells"
+	ret[3].Required = true
+
+	ret[4].Name = "GridEdges"
+	ret[4].Type = "bool"
+	ret[4].Description = "Shrink the data from the edges of the grid too?"
+	ret[4].Default = "false"
+
+	return ret
+}
+
+func (this *NodataMargin) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters, the values and the queued cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+8+1)
+}
+
+func (this *NodataMargin) ParseArguments(args []string) {
+	if len(args) < 4 {
+		println("The input file, output file, operation, and cells must be specified.")
+		return
+	}
+	inputFile := strings.TrimSpace(args[0])
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setOperation(args[2]) {
+		return
+	}
+	if this.cells, err = strconv.Atoi(strings.TrimSpace(args[3])); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.gridEdges = false
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if this.gridEdges, err = strconv.ParseBool(strings.TrimSpace(args[4])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *NodataMargin) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the operation
+	print("Operation, 'shrink' or 'expand': ")
+	operation, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setOperation(operation) {
+		return
+	}
+
+	// get the margin width
+	print("Width of the margin (cells): ")
+	cellsStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.cells, err = strconv.Atoi(strings.TrimSpace(cellsStr)); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the grid edges argument
+	this.gridEdges = false
+	if this.operation == "shrink" {
+		print("Shrink the data from the edges of the grid too (T or F)? ")
+		gridEdgesStr, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(gridEdgesStr)) > 0 {
+			if this.gridEdges, err = strconv.ParseBool(strings.TrimSpace(gridEdgesStr)); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	this.Run()
+}
+
+func (this *NodataMargin) setOperation(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, op := range nodataMarginOperations {
+		if s == op {
+			this.operation = s
+			return true
+		}
+	}
+	println("Unrecognized operation; use 'shrink' or 'expand'.")
+	return false
+}
+
+func (this *NodataMargin) Run() {
+	start1 := time.Now()
+
+	if this.cells < 1 {
+		println("The margin must be at least one cell wide.")
+		return
+	}
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	z := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z[row*columns+col] = rin.Value(row, col)
+		}
+	}
+
+	var numChanged int
+	if this.operation == "shrink" {
+		numChanged = shrinkData(z, rows, columns, nodata, this.cells, this.gridEdges)
+	} else {
+		numChanged = expandData(z, rows, columns, nodata, this.cells)
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for i, v := range z {
+		if v != nodata {
+			rout.SetValue(i/columns, i%columns, v)
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by NodataMargin tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Operation: %s %v cells", this.operation, this.cells))
+	if this.gridEdges {
+		rout.AddMetadataEntry("Shrunk from the grid edges")
+	}
+	rout.Save()
+
+	if this.operation == "shrink" {
+		printf("Number of cells assigned nodata: %v\n", numChanged)
+	} else {
+		printf("Number of cells filled: %v\n", numChanged)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// shrinkData assigns nodata to the cells of a grid, indexed row*columns+col,
+// that are within the given number of cells, including diagonally, of a
+// nodata cell, or also of the edge of the grid if gridEdges is true, one ring
+// of cells at a time. It returns the number of cells assigned nodata.
+func shrinkData(z []float64, rows, columns int, nodata float64, cells int, gridEdges bool) int {
+	// the first ring borders the nodata cells or the edges
+	ring := make([]int, 0)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if z[i] == nodata {
+				continue
+			}
+			onEdge := row == 0 || row == rows-1 || col == 0 || col == columns-1
+			if gridEdges && onEdge {
+				ring = append(ring, i)
+				continue
+			}
+			for n := 0; n < 8; n++ {
+				r, c := row+d8DY[n], col+d8DX[n]
+				if r >= 0 && r < rows && c >= 0 && c < columns && z[r*columns+c] == nodata {
+					ring = append(ring, i)
+					break
+				}
+			}
+		}
+	}
+
+	numChanged := 0
+	var eta progressETA
+	for k := 1; k <= cells && len(ring) > 0; k++ {
+		for _, i := range ring {
+			z[i] = nodata
+		}
+		numChanged += len(ring)
+		progress := 100 * k / cells
+		printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+		if k == cells {
+			break
+		}
+
+		// the next ring borders this one
+		next := make([]int, 0)
+		for _, i := range ring {
+			row, col := i/columns, i%columns
+			for n := 0; n < 8; n++ {
+				r, c := row+d8DY[n], col+d8DX[n]
+				if r < 0 || r >= rows || c < 0 || c >= columns {
+					continue
+				}
+				if j := r*columns + c; z[j] != nodata {
+					next = append(next, j)
+					z[j] = nodata // marks the cell as taken
+				}
+			}
+		}
+		ring = next
+	}
+	return numChanged
+}
+
+// expandData fills the nodata cells of a grid, indexed row*columns+col, that
+// are within the given number of cells, including diagonally, of the data,
+// one ring of cells at a time, from the cells filled before the ring. Each
+// cell is extrapolated linearly along the directions in which the two cells
+// beyond it have values, or is the mean of its neighbours that have values.
+// It returns the number of cells filled.
+func expandData(z []float64, rows, columns int, nodata float64, cells int) int {
+	value := func(r, c int) float64 {
+		if r < 0 || r >= rows || c < 0 || c >= columns {
+			return nodata
+		}
+		return z[r*columns+c]
+	}
+
+	// the first ring borders the data
+	ring := make([]int, 0)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z[row*columns+col] != nodata {
+				continue
+			}
+			for n := 0; n < 8; n++ {
+				if value(row+d8DY[n], col+d8DX[n]) != nodata {
+					ring = append(ring, row*columns+col)
+					break
+				}
+			}
+		}
+	}
+
+	numChanged := 0
+	queued := make([]bool, rows*columns)
+	var eta progressETA
+	for k := 1; k <= cells && len(ring) > 0; k++ {
+		// the ring's values are all calculated before any are assigned, so
+		// that they only depend on the earlier rings
+		values := make([]float64, len(ring))
+		for m, i := range ring {
+			row, col := i/columns, i%columns
+			linearSum, numLinear := 0.0, 0
+			sum, num := 0.0, 0
+			for n := 0; n < 8; n++ {
+				a := value(row+d8DY[n], col+d8DX[n])
+				if a == nodata {
+					continue
+				}
+				sum += a
+				num++
+				if b := value(row+2*d8DY[n], col+2*d8DX[n]); b != nodata {
+					linearSum += 2*a - b
+					numLinear++
+				}
+			}
+			if numLinear > 0 {
+				values[m] = linearSum / float64(numLinear)
+			} else {
+				values[m] = sum / float64(num)
+			}
+		}
+		for m, i := range ring {
+			z[i] = values[m]
+		}
+		numChanged += len(ring)
+		progress := 100 * k / cells
+		printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+
+		// the next ring borders this one
+		next := make([]int, 0)
+		for _, i := range ring {
+			row, col := i/columns, i%columns
+			for n := 0; n < 8; n++ {
+				r, c := row+d8DY[n], col+d8DX[n]
+				if r < 0 || r >= rows || c < 0 || c >= columns {
+					continue
+				}
+				if j := r*columns + c; z[j] == nodata && !queued[j] {
+					next = append(next, j)
+					queued[j] = true
+				}
+			}
+		}
+		ring = next
+	}
+	return numChanged
+}
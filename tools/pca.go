@@ -0,0 +1,521 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// PCA performs a principal component analysis of a stack of co-registered
+// rasters, e.g. terrain attributes.
+type PCA struct {
+	stackFile     string
+	outputFile    string
+	numComponents int
+	standardize   bool
+	toolManager   *PluginToolManager
+}
+
+func (this *PCA) GetName() string {
+	s := "PCA"
+	return getFormattedToolName(s)
+}
+
+func (this *PCA) GetDescription() string {
+	s := "Principal component analysis of a raster stack"
+	return getFormattedToolDescription(s)
+}
+
+func (this *PCA) GetVersion() string {
+	return "1.0"
+}
+
+func (this *PCA) GetHelpDocumentation() string {
+	ret := "This tool performs a principal component analysis (PCA) of a stack of " +
+		"co-registered rasters, e.g. to reduce a set of correlated terrain attributes to a " +
+		"few uncorrelated components. The stack is described by a text file listing one " +
+		"raster per line, as used by the StackStatistics tool; any layer times are ignored. " +
+		"Only cells that are valid in every layer are analysed. If Standardize is true (the " +
+		"default) the layers are scaled to unit variance, i.e. the correlation matrix is " +
+		"analysed, which is appropriate when the layers have different units; otherwise " +
+		"the covariance matrix is used. The first NumComponents (default all) component " +
+		"scores are written to rasters named after the OutputFile with the suffixes _PC1, " +
+		"_PC2, etc. and the eigenvalues, explained variance, eigenvectors and factor " +
+		"loadings (the correlations between the layers and the components) are written to " +
+		"a CSV file with the suffix _loadings. The sign of each eigenvector is chosen so " +
+		"that its largest element is positive."
+	return ret
+}
+
+func (this *PCA) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *PCA) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "StackFile"
+	ret[0].Type = "string"
+	ret[0].Description = "Text file listing the rasters of the stack"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The base output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "NumComponents"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of component rasters to output"
+
+	ret[3].Name = "Standardize"
+	ret[3].Type = "bool"
+	ret[3].Description = "Analyse the correlation matrix?"
+	ret[3].Default = "true"
+
+	return ret
+}
+
+func (this *PCA) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The stack file and output file must be specified.")
+		return
+	}
+	stackFile := strings.TrimSpace(args[0])
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.numComponents = 0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if !this.setNumComponents(args[2]) {
+			return
+		}
+	}
+	this.standardize = true
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if this.standardize, err = strconv.ParseBool(strings.TrimSpace(args[3])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *PCA) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the stack file name
+	print("Enter the stack list file name (incl. file extension): ")
+	stackFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	stackFile = strings.TrimSpace(stackFile)
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+
+	// get the output file name
+	print("Enter the base output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the number of components
+	this.numComponents = 0
+	print("Number of component rasters to output (blank for all): ")
+	numComponentsStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(numComponentsStr)) > 0 {
+		if !this.setNumComponents(numComponentsStr) {
+			return
+		}
+	}
+
+	// standardize?
+	this.standardize = true
+	print("Standardize the layers (T or F; default T)? ")
+	standardizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(standardizeStr)) > 0 {
+		if this.standardize, err = strconv.ParseBool(strings.TrimSpace(standardizeStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *PCA) setNumComponents(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 1 {
+		println("The number of components must be at least 1.")
+		return false
+	}
+	this.numComponents = v
+	return true
+}
+
+func (this *PCA) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	stack, err := raster.CreateRasterStackFromListFile(this.stackFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	numLayers := stack.Len()
+	if numLayers < 2 {
+		println("The stack must contain at least two layers.")
+		return
+	}
+	printf("The stack contains %v layers.\n", numLayers)
+
+	start2 := time.Now()
+
+	rows := stack.Rows
+	columns := stack.Columns
+	first := stack.Layer(0)
+	nodata := first.NoDataValue
+	inConfig := first.GetRasterConfig()
+
+	// the means, then the covariances, of the cells valid in every layer
+	z := make([]float64, numLayers)
+	cellValues := func(row, col int) bool {
+		for k := 0; k < numLayers; k++ {
+			layer := stack.Layer(k)
+			if z[k] = layer.Value(row, col); z[k] == layer.NoDataValue {
+				return false
+			}
+		}
+		return true
+	}
+	mean := make([]float64, numLayers)
+	n := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if cellValues(row, col) {
+				for k := range z {
+					mean[k] += z[k]
+				}
+				n++
+			}
+		}
+	}
+	if n < 2 {
+		println("There are too few cells that are valid in every layer.")
+		return
+	}
+	for k := range mean {
+		mean[k] /= float64(n)
+	}
+	cov := make([][]float64, numLayers)
+	for k := range cov {
+		cov[k] = make([]float64, numLayers)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if !cellValues(row, col) {
+				continue
+			}
+			for i := range z {
+				di := z[i] - mean[i]
+				for j := 0; j <= i; j++ {
+					cov[i][j] += di * (z[j] - mean[j])
+				}
+			}
+		}
+	}
+	stdev := make([]float64, numLayers)
+	for i := 0; i < numLayers; i++ {
+		for j := 0; j <= i; j++ {
+			cov[i][j] /= float64(n - 1)
+			cov[j][i] = cov[i][j]
+		}
+		stdev[i] = math.Sqrt(cov[i][i])
+		if stdev[i] == 0 {
+			printf("Layer %v is constant and can't be analysed.\n", i+1)
+			return
+		}
+	}
+	scale := make([]float64, numLayers)
+	for i := range scale {
+		scale[i] = 1.0
+		if this.standardize {
+			scale[i] = stdev[i]
+		}
+	}
+	for i := 0; i < numLayers; i++ {
+		for j := 0; j < numLayers; j++ {
+			cov[i][j] /= scale[i] * scale[j]
+		}
+	}
+
+	eigenvalues, eigenvectors := symmetricEigen(cov)
+	totalVariance := 0.0
+	for _, v := range eigenvalues {
+		totalVariance += v
+	}
+
+	numComponents := this.numComponents
+	if numComponents == 0 || numComponents > numLayers {
+		numComponents = numLayers
+	}
+
+	// write the component rasters
+	ext := filepath.Ext(this.outputFile)
+	base := strings.TrimSuffix(this.outputFile, ext)
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "blue_white_red.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	outputs := make([]*raster.Raster, numComponents)
+	for c := range outputs {
+		fileName := fmt.Sprintf("%s_PC%v%s", base, c+1, ext)
+		if outputs[c], err = raster.CreateNewRaster(fileName, rows, columns,
+			stack.North, stack.South, stack.East, stack.West, config); err != nil {
+			println("Failed to write raster")
+			return
+		}
+	}
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if !cellValues(row, col) {
+				continue
+			}
+			for c := 0; c < numComponents; c++ {
+				score := 0.0
+				for k := 0; k < numLayers; k++ {
+					score += eigenvectors[k][c] * (z[k] - mean[k]) / scale[k]
+				}
+				outputs[c].SetValue(row, col, score)
+			}
+		}
+		progress = int(100.0 * row / rows)
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// write the loadings table
+	names := make([]string, numLayers)
+	for k := range names {
+		names[k] = filepath.Base(stack.Layer(k).FileName)
+	}
+	loadingsFile := base + "_loadings.csv"
+	w, err := createTable(loadingsFile, "component", "eigenvalue", "variance (%)", "cumulative (%)")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	cumulative := 0.0
+	for c, v := range eigenvalues {
+		cumulative += v
+		w.writeRow(fmt.Sprintf("PC%v", c+1), strconv.FormatFloat(v, 'g', 6, 64),
+			strconv.FormatFloat(100*v/totalVariance, 'f', 4, 64),
+			strconv.FormatFloat(100*cumulative/totalVariance, 'f', 4, 64))
+	}
+	header := []string{"layer"}
+	for c := range eigenvalues {
+		header = append(header, fmt.Sprintf("PC%v", c+1))
+	}
+	w.writeRow()
+	w.writeRow("eigenvectors")
+	w.writeRecord(header)
+	for k, name := range names {
+		fields := []string{name}
+		for c := range eigenvalues {
+			fields = append(fields, strconv.FormatFloat(eigenvectors[k][c], 'f', 6, 64))
+		}
+		w.writeRecord(fields)
+	}
+	w.writeRow()
+	w.writeRow("factor loadings")
+	w.writeRecord(header)
+	for k, name := range names {
+		fields := []string{name}
+		for c, v := range eigenvalues {
+			fields = append(fields, strconv.FormatFloat(eigenvectors[k][c]*math.Sqrt(math.Max(v, 0))*scale[k]/stdev[k], 'f', 6, 64))
+		}
+		w.writeRecord(fields)
+	}
+	if err = w.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("\nSaving data...")
+	elapsed := time.Since(start2)
+	for c, rout := range outputs {
+		rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+		rout.AddMetadataEntry(fmt.Sprintf("Created by PCA tool (%s)", this.toolManager.toolVersion(this)))
+		rout.AddMetadataEntry(fmt.Sprintf("Component %v of %v layers, %.2f%% of the variance", c+1,
+			numLayers, 100*eigenvalues[c]/totalVariance))
+		rout.Save()
+	}
+
+	printf("Cells analysed: %v\n", n)
+	cumulative = 0.0
+	for c, v := range eigenvalues {
+		cumulative += v
+		printf("PC%v: eigenvalue %.6g, %.2f%% of the variance (cumulative %.2f%%)\n", c+1, v,
+			100*v/totalVariance, 100*cumulative/totalVariance)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// symmetricEigen returns the eigenvalues, in decreasing order, and the
+// corresponding eigenvectors, in the columns of the returned matrix, of the
+// symmetric matrix a, using cyclic Jacobi rotations. The sign of each
+// eigenvector is chosen so that its largest element is positive.
+func symmetricEigen(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+	m := make([][]float64, n)
+	v := make([][]float64, n)
+	for i := range m {
+		m[i] = append([]float64(nil), a[i]...)
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		offDiagonal := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				offDiagonal += m[i][j] * m[i][j]
+			}
+		}
+		if offDiagonal < 1e-30 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p] = c*mkp - s*mkq
+					m[k][q] = s*mkp + c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k] = c*mpk - s*mqk
+					m[q][k] = s*mpk + c*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return m[order[i]][order[i]] > m[order[j]][order[j]] })
+	values := make([]float64, n)
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = make([]float64, n)
+	}
+	for c, i := range order {
+		values[c] = m[i][i]
+		largest := 0
+		for k := 0; k < n; k++ {
+			if math.Abs(v[k][i]) > math.Abs(v[largest][i]) {
+				largest = k
+			}
+		}
+		sign := 1.0
+		if v[largest][i] < 0 {
+			sign = -1.0
+		}
+		for k := 0; k < n; k++ {
+			vectors[k][c] = sign * v[k][i]
+		}
+	}
+	return values, vectors
+}
@@ -0,0 +1,368 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// PennockLandformClass classifies the cells of a DEM into the landform
+// elements of Pennock et al. (1987) by thresholds of slope and of profile and
+// plan curvature.
+type PennockLandformClass struct {
+	inputFile      string
+	outputFile     string
+	tableFile      string
+	slopeThreshold float64
+	profThreshold  float64
+	planThreshold  float64
+	toolManager    *PluginToolManager
+}
+
+// The Pennock landform classes, numbered from 1 in the order of their names.
+var pennockClassNames = []string{"Convergent footslope", "Divergent footslope",
+	"Convergent shoulder", "Divergent shoulder", "Convergent backslope",
+	"Divergent backslope", "Level"}
+
+var pennockClassCodes = []string{"CFS", "DFS", "CSH", "DSH", "CBS", "DBS", "L"}
+
+func (this *PennockLandformClass) GetName() string {
+	s := "PennockLandformClass"
+	return getFormattedToolName(s)
+}
+
+func (this *PennockLandformClass) GetDescription() string {
+	s := "Classifies landform elements by slope and curvature"
+	return getFormattedToolDescription(s)
+}
+
+func (this *PennockLandformClass) GetVersion() string {
+	return "1.0"
+}
+
+func (this *PennockLandformClass) GetHelpDocumentation() string {
+	ret := "This tool classifies each cell of a digital elevation model (DEM) into one of the " +
+		"seven landform elements of Pennock, Zebarth and de Jong (1987), Geoderma, 40, " +
+		"297-315, by rules on its slope gradient and its profile and plan curvatures, which " +
+		"are calculated from the quadratic surface fitted to its 3 x 3 neighbourhood (Evans, " +
+		"1979). Cells whose slope is less than the SlopeThreshold (default 3 degrees) are " +
+		"Level (7). Other cells are shoulders where the profile curvature exceeds the " +
+		"ProfileThreshold (default 0.1 degrees per metre), i.e. the slope steepens " +
+		"downslope, footslopes where it is less than the negative of the threshold, and " +
+		"backslopes otherwise, and each of these is divergent where the plan curvature " +
+		"exceeds the PlanThreshold (default 0 degrees per metre), e.g. on noses, and " +
+		"convergent otherwise, e.g. in hollows. The classes are numbered 1, Convergent " +
+		"footslope (CFS), 2, Divergent footslope (DFS), 3, Convergent shoulder (CSH), 4, " +
+		"Divergent shoulder (DSH), 5, Convergent backslope (CBS), 6, Divergent backslope " +
+		"(DBS), and 7, Level (L). Curvatures are per metre if the XY units are metres or " +
+		"the DEM is in geographic coordinates, with elevations in metres. The attribute " +
+		"table of the classes, listing the value, code and name of each class and its " +
+		"number of cells and percentage of the classified area, is written as CSV to the " +
+		"AttributeTableFile, or alongside the output, with the .csv extension, if none is " +
+		"given. Since curvature is sensitive to noise, a DEM may need smoothing first."
+	return ret
+}
+
+func (this *PennockLandformClass) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *PennockLandformClass) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "SlopeThreshold"
+	ret[2].Type = "float64"
+	ret[2].Description = "The slope below which cells are level, in degrees"
+	ret[2].Default = "3.0"
+
+	ret[3].Name = "ProfileThreshold"
+	ret[3].Type = "float64"
+	ret[3].Description = "The profile curvature threshold, in degrees per metre"
+	ret[3].Default = "0.1"
+
+	ret[4].Name = "PlanThreshold"
+	ret[4].Type = "float64"
+	ret[4].Description = "The plan curvature threshold, in degrees per metre"
+	ret[4].Default = "0.0"
+
+	ret[5].Name = "AttributeTableFile"
+	ret[5].Type = "string"
+	ret[5].Description = "The output attribute table CSV filename, with directory"
+	ret[5].Role = ArgOutput
+
+	return ret
+}
+
+func (this *PennockLandformClass) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters
+	return gridBytes(rows, columns, 2*rasterBytesPerCell)
+}
+
+func (this *PennockLandformClass) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.slopeThreshold, this.profThreshold, this.planThreshold = 3.0, 0.1, 0.0
+	values := []*float64{&this.slopeThreshold, &this.profThreshold, &this.planThreshold}
+	for i, v := range values {
+		if len(args) > i+2 && len(strings.TrimSpace(args[i+2])) > 0 && args[i+2] != "not specified" {
+			val, err := strconv.ParseFloat(strings.TrimSpace(args[i+2]), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	tableFile := ""
+	if len(args) > 5 && args[5] != "not specified" {
+		tableFile = args[5]
+	}
+	this.setTableFile(tableFile)
+
+	this.Run()
+}
+
+func (this *PennockLandformClass) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the thresholds
+	this.slopeThreshold, this.profThreshold, this.planThreshold = 3.0, 0.1, 0.0
+	prompts := []string{"Slope threshold in degrees (default 3.0): ",
+		"Profile curvature threshold in degrees per metre (default 0.1): ",
+		"Plan curvature threshold in degrees per metre (default 0.0): "}
+	values := []*float64{&this.slopeThreshold, &this.profThreshold, &this.planThreshold}
+	for i, v := range values {
+		print(prompts[i])
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(str)) > 0 {
+			val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+
+	// get the attribute table file name
+	print("Enter the attribute table file name (blank for one alongside the output): ")
+	tableFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setTableFile(tableFile)
+
+	this.Run()
+}
+
+func (this *PennockLandformClass) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *PennockLandformClass) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+// setTableFile sets the attribute table file, which is the output file with
+// the .csv extension if s is blank. A name without an extension is given .csv.
+func (this *PennockLandformClass) setTableFile(s string) {
+	tableFile := strings.TrimSpace(s)
+	if tableFile == "" {
+		tableFile = strings.TrimSuffix(this.outputFile, filepath.Ext(this.outputFile))
+	} else if !strings.Contains(tableFile, pathSep) {
+		tableFile = this.toolManager.workingDirectory + tableFile
+	}
+	if filepath.Ext(tableFile) == "" {
+		tableFile += ".csv"
+	}
+	this.tableFile = tableFile
+}
+
+func (this *PennockLandformClass) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	inConfig := dem.GetRasterConfig()
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+
+	start2 := time.Now()
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "qual.plt"
+	config.DataType = raster.DT_INT16
+	config.NoDataValue = -32768
+	config.InitialValue = config.NoDataValue
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	counts := make([]int, len(pennockClassNames))
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			d, ok := evansDerivatives(dem, row, col, cellSizeX, cellSizeY)
+			if !ok {
+				continue
+			}
+			class := this.classify(d)
+			rout.SetValue(row, col, float64(class))
+			counts[class-1]++
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by PennockLandformClass tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Slope threshold: %v, profile threshold: %v, plan threshold: %v",
+		this.slopeThreshold, this.profThreshold, this.planThreshold))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// the attribute table
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	table, err := createTable(this.tableFile, "value", "code", "class", "cells", "percent")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	printf("%6s %5s %-21s %10s %8s\n", "value", "code", "class", "cells", "percent")
+	for i, c := range counts {
+		percent := 0.0
+		if total > 0 {
+			percent = 100.0 * float64(c) / float64(total)
+		}
+		printf("%6v %5s %-21s %10v %8.2f\n", i+1, pennockClassCodes[i], pennockClassNames[i], c, percent)
+		table.writeRow(i+1, pennockClassCodes[i], pennockClassNames[i], c, math.Round(percent*100.0)/100.0)
+	}
+	if err = table.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// classify returns the Pennock class, numbered from 1, of a cell with the
+// surface derivatives d.
+func (this *PennockLandformClass) classify(d surfaceDerivatives) int {
+	if d.slope() < this.slopeThreshold || (d.p == 0 && d.q == 0) {
+		return 7 // level
+	}
+	class := 5 // backslope
+	if prof := d.profileCurvature() * RadToDeg; prof > this.profThreshold {
+		class = 3 // shoulder
+	} else if prof < -this.profThreshold {
+		class = 1 // footslope
+	}
+	if d.planCurvature()*RadToDeg > this.planThreshold {
+		class++ // divergent
+	}
+	return class
+}
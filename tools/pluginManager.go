@@ -13,6 +13,8 @@ import (
 	"os"
 	"runtime"
 	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
 )
 
 //var println = fmt.Println
@@ -25,6 +27,23 @@ type PluginToolManager struct {
 	workingDirectory string
 	mapOfPluginTools map[string]PluginTool
 	BenchMode        bool
+	TauDEMMode       bool
+	Version          string // the GoSpatial version, reported in tool metadata
+	NumThreads       int    // the maximum number of threads used by tools; all CPUs if zero
+	MemoryBudget     int64  // the memory available to tools, in bytes; unlimited if zero
+	ReportFile       string // the CSV file that analysis tools write their tabular results to, if any
+	CPUProfile       string // the file that the CPU profile of each tool run is written to, if any
+	MemProfile       string // the file that the memory profile of each tool run is written to, if any
+	TraceFile        string // the file that the execution trace of each tool run is written to, if any
+	profiling        bool
+}
+
+// numThreads returns the number of threads that parallel tools should use.
+func (ptm *PluginToolManager) numThreads() int {
+	if ptm != nil && ptm.NumThreads > 0 && ptm.NumThreads < runtime.NumCPU() {
+		return ptm.NumThreads
+	}
+	return runtime.NumCPU()
 }
 
 // InitializeTools is a method for initializing a new plugin tool manager.
@@ -82,6 +101,192 @@ func (ptm *PluginToolManager) InitializeTools() {
 
 	mf := new(MeanFilter)
 	ptm.mapOfPluginTools[strings.ToLower(mf.GetName())] = mf
+
+	acrs := new(AssignCRS)
+	ptm.mapOfPluginTools[strings.ToLower(acrs.GetName())] = acrs
+
+	rutm := new(ReprojectToUTM)
+	ptm.mapOfPluginTools[strings.ToLower(rutm.GetName())] = rutm
+
+	coreg := new(CoRegister)
+	ptm.mapOfPluginTools[strings.ToLower(coreg.GetName())] = coreg
+
+	dod := new(DoD)
+	ptm.mapOfPluginTools[strings.ToLower(dod.GetName())] = dod
+
+	ss := new(StackStatistics)
+	ptm.mapOfPluginTools[strings.ToLower(ss.GetName())] = ss
+
+	sar := new(SurfaceAreaRatio)
+	ptm.mapOfPluginTools[strings.ToLower(sar.GetName())] = sar
+
+	bw := new(BurnWalls)
+	ptm.mapOfPluginTools[strings.ToLower(bw.GetName())] = bw
+
+	cp := new(ConvertPointer)
+	ptm.mapOfPluginTools[strings.ToLower(cp.GetName())] = cp
+
+	tr := new(TransformRaster)
+	ptm.mapOfPluginTools[strings.ToLower(tr.GetName())] = tr
+
+	ufa := new(UpdateFlowAccum)
+	ptm.mapOfPluginTools[strings.ToLower(ufa.GetName())] = ufa
+
+	ed := new(EditDEM)
+	ptm.mapOfPluginTools[strings.ToLower(ed.GetName())] = ed
+
+	agg := new(Aggregate)
+	ptm.mapOfPluginTools[strings.ToLower(agg.GetName())] = agg
+
+	disagg := new(Disaggregate)
+	ptm.mapOfPluginTools[strings.ToLower(disagg.GetName())] = disagg
+
+	mss := new(MultiscaleSignature)
+	ptm.mapOfPluginTools[strings.ToLower(mss.GetName())] = mss
+
+	aniso := new(AnisotropicDeviation)
+	ptm.mapOfPluginTools[strings.ToLower(aniso.GetName())] = aniso
+
+	ff := new(FloodFill)
+	ptm.mapOfPluginTools[strings.ToLower(ff.GetName())] = ff
+
+	ci := new(CoastalInundation)
+	ptm.mapOfPluginTools[strings.ToLower(ci.GetName())] = ci
+
+	ftt := new(FrontTravelTime)
+	ptm.mapOfPluginTools[strings.ToLower(ftt.GetName())] = ftt
+
+	fl := new(FlattenLakes)
+	ptm.mapOfPluginTools[strings.ToLower(fl.GetName())] = fl
+
+	rxyz := new(ReadXYZ)
+	ptm.mapOfPluginTools[strings.ToLower(rxyz.GetName())] = rxyz
+
+	wxyz := new(WriteXYZ)
+	ptm.mapOfPluginTools[strings.ToLower(wxyz.GetName())] = wxyz
+
+	sr := new(SampleRaster)
+	ptm.mapOfPluginTools[strings.ToLower(sr.GetName())] = sr
+
+	aa := new(AccuracyAssessment)
+	ptm.mapOfPluginTools[strings.ToLower(aa.GetName())] = aa
+
+	pca := new(PCA)
+	ptm.mapOfPluginTools[strings.ToLower(pca.GetName())] = pca
+
+	km := new(KMeans)
+	ptm.mapOfPluginTools[strings.ToLower(km.GetName())] = km
+
+	ar := new(AlignRasters)
+	ptm.mapOfPluginTools[strings.ToLower(ar.GetName())] = ar
+
+	rfp := new(RasterFootprint)
+	ptm.mapOfPluginTools[strings.ToLower(rfp.GetName())] = rfp
+
+	ti := new(TileIndex)
+	ptm.mapOfPluginTools[strings.ToLower(ti.GetName())] = ti
+
+	bt := new(BatchTiles)
+	ptm.mapOfPluginTools[strings.ToLower(bt.GetName())] = bt
+
+	dqr := new(DEMQualityReport)
+	ptm.mapOfPluginTools[strings.ToLower(dqr.GetName())] = dqr
+
+	ds := new(Despike)
+	ptm.mapOfPluginTools[strings.ToLower(ds.GetName())] = ds
+
+	dst := new(Destripe)
+	ptm.mapOfPluginTools[strings.ToLower(dst.GetName())] = dst
+
+	fps := new(FlowpathSmoothing)
+	ptm.mapOfPluginTools[strings.ToLower(fps.GetName())] = fps
+
+	tds := new(TraceDownslope)
+	ptm.mapOfPluginTools[strings.ToLower(tds.GetName())] = tds
+
+	ua := new(UpslopeArea)
+	ptm.mapOfPluginTools[strings.ToLower(ua.GetName())] = ua
+
+	sv := new(Semivariogram)
+	ptm.mapOfPluginTools[strings.ToLower(sv.GetName())] = sv
+
+	kr := new(Kriging)
+	ptm.mapOfPluginTools[strings.ToLower(kr.GetName())] = kr
+
+	grf := new(GaussianRandomField)
+	ptm.mapOfPluginTools[strings.ToLower(grf.GetName())] = grf
+
+	tg := new(TINGridding)
+	ptm.mapOfPluginTools[strings.ToLower(tg.GetName())] = tg
+
+	shr := new(ShiftRaster)
+	ptm.mapOfPluginTools[strings.ToLower(shr.GetName())] = shr
+
+	flr := new(FlipRaster)
+	ptm.mapOfPluginTools[strings.ToLower(flr.GetName())] = flr
+
+	r90 := new(Rotate90)
+	ptm.mapOfPluginTools[strings.ToLower(r90.GetName())] = r90
+
+	l2d := new(LidarToDEM)
+	ptm.mapOfPluginTools[strings.ToLower(l2d.GetName())] = l2d
+
+	es := new(ExtractStreams)
+	ptm.mapOfPluginTools[strings.ToLower(es.GetName())] = es
+
+	rsv := new(RasterStreamsToVector)
+	ptm.mapOfPluginTools[strings.ToLower(rsv.GetName())] = rsv
+
+	d8p := new(D8Pointer)
+	ptm.mapOfPluginTools[strings.ToLower(d8p.GetName())] = d8p
+
+	fdim := new(FractalDimension)
+	ptm.mapOfPluginTools[strings.ToLower(fdim.GetName())] = fdim
+
+	plc := new(PennockLandformClass)
+	ptm.mapOfPluginTools[strings.ToLower(plc.GetName())] = plc
+
+	lt := new(LineThinning)
+	ptm.mapOfPluginTools[strings.ToLower(lt.GetName())] = lt
+
+	curv := new(Curvature)
+	ptm.mapOfPluginTools[strings.ToLower(curv.GetName())] = curv
+
+	mrvbf := new(MrVBF)
+	ptm.mapOfPluginTools[strings.ToLower(mrvbf.GetName())] = mrvbf
+
+	mrrtf := new(MrRTF)
+	ptm.mapOfPluginTools[strings.ToLower(mrrtf.GetName())] = mrrtf
+
+	kp := new(Knickpoints)
+	ptm.mapOfPluginTools[strings.ToLower(kp.GetName())] = kp
+
+	ca := new(CatchmentAttributes)
+	ptm.mapOfPluginTools[strings.ToLower(ca.GetName())] = ca
+
+	rf := new(ResolveFlats)
+	ptm.mapOfPluginTools[strings.ToLower(rf.GetName())] = rf
+
+	sda := new(StochasticDepressionAnalysis)
+	ptm.mapOfPluginTools[strings.ToLower(sda.GetName())] = sda
+
+	iso := new(Isochrones)
+	ptm.mapOfPluginTools[strings.ToLower(iso.GetName())] = iso
+
+	bs := new(BurnStreams)
+	ptm.mapOfPluginTools[strings.ToLower(bs.GetName())] = bs
+
+	tad := new(TimeAreaDiagram)
+	ptm.mapOfPluginTools[strings.ToLower(tad.GetName())] = tad
+
+	nm := new(NodataMargin)
+	ptm.mapOfPluginTools[strings.ToLower(nm.GetName())] = nm
+
+	rc := new(Reclass)
+	ptm.mapOfPluginTools[strings.ToLower(rc.GetName())] = rc
+
+	rs := new(Resample)
+	ptm.mapOfPluginTools[strings.ToLower(rs.GetName())] = rs
 }
 
 func (ptm *PluginToolManager) GetListOfTools() []PluginTool {
@@ -100,9 +305,12 @@ func (ptm *PluginToolManager) Run(toolName string) error {
 		//do something here
 		println(GetHeaderText(toolName))
 		tool.SetToolManager(ptm)
-		tool.CollectArguments()
-		runtime.GC()
-		return nil
+		if raster.Pool != nil {
+			defer raster.Pool.End(raster.Pool.Begin())
+		}
+		defer ptm.startProfiling()()
+		defer runtime.GC()
+		return ptm.runTool(tool, tool.CollectArguments)
 	}
 	return errors.New("Unrecognized tool name. Type 'listtools' for a list of available tools.\n")
 }
@@ -112,14 +320,32 @@ func (ptm *PluginToolManager) RunWithArguments(toolName string, args []string) e
 	if tool, ok := ptm.mapOfPluginTools[toolName]; ok {
 		//do something here
 		println(GetHeaderText(toolName))
+		// the tool manager's modes can change which arguments are required
 		tool.SetToolManager(ptm)
-		tool.ParseArguments(args)
-		runtime.GC()
-		return nil
+		args, err := resolveArguments(tool.GetArgDescriptions(), args)
+		if err != nil {
+			return err
+		}
+		if raster.Pool != nil {
+			defer raster.Pool.End(raster.Pool.Begin())
+		}
+		defer ptm.startProfiling()()
+		defer runtime.GC()
+		return ptm.runTool(tool, func() { tool.ParseArguments(args) })
 	}
 	return errors.New("Unrecognized tool name. Type 'listtools' for a list of available tools.\n")
 }
 
+// toolVersion describes the versions of a tool and of GoSpatial, for the
+// metadata of the tool's outputs. A tool's version changes whenever a
+// revision of its algorithm changes its outputs.
+func (ptm *PluginToolManager) toolVersion(tool PluginTool) string {
+	if ptm == nil || ptm.Version == "" {
+		return "version " + tool.GetVersion()
+	}
+	return fmt.Sprintf("version %s, GoSpatial %s", tool.GetVersion(), ptm.Version)
+}
+
 func (ptm *PluginToolManager) GetToolArgDescriptions(toolName string) ([]string, error) {
 	trailingSpaces := func(s string, maxLen int) string {
 		strLen := len(s)
@@ -137,11 +363,11 @@ func (ptm *PluginToolManager) GetToolArgDescriptions(toolName string) ([]string,
 		lenToolName := 0
 		lenDataType := 0
 		for _, val := range descEntries {
-			if len(val[0]) > lenToolName {
-				lenToolName = len(val[0])
+			if len(val.Name) > lenToolName {
+				lenToolName = len(val.Name)
 			}
-			if len(val[1]) > lenDataType {
-				lenDataType = len(val[1])
+			if len(val.Type) > lenDataType {
+				lenDataType = len(val.Type)
 			}
 		}
 
@@ -150,7 +376,7 @@ func (ptm *PluginToolManager) GetToolArgDescriptions(toolName string) ([]string,
 
 		ret := make([]string, len(descEntries))
 		for i, val := range descEntries {
-			ret[i] = trailingSpaces(val[0], lenToolName) + trailingSpaces(val[1], lenDataType) + val[2]
+			ret[i] = trailingSpaces(val.Name, lenToolName) + trailingSpaces(val.Type, lenDataType) + val.describe()
 		}
 		return ret, nil
 	}
@@ -167,13 +393,158 @@ func (ptm *PluginToolManager) SetWorkingDirectory(wd string) {
 type PluginTool interface {
 	GetName() string
 	GetDescription() string
+	GetVersion() string
 	GetHelpDocumentation() string
 	CollectArguments()
 	ParseArguments([]string)
-	GetArgDescriptions() [][]string
+	GetArgDescriptions() []ToolArg
 	SetToolManager(*PluginToolManager)
 }
 
+// ToolArg describes one argument of a tool. Optional arguments that are
+// omitted are passed to the tool's ParseArguments as empty strings.
+type ToolArg struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required"`
+	Choices     []string `json:"choices,omitempty"`
+	Role        string   `json:"role,omitempty"` // one of the Arg roles, for file arguments
+}
+
+// The roles of file arguments, used to validate them before a tool is run.
+const (
+	ArgInput        = "input"         // an existing file or directory
+	ArgOutput       = "output"        // a file that the tool creates
+	ArgOutputRaster = "output raster" // an output raster, given the default extension if it lacks one
+)
+
+// describe returns the argument's description, noting whether it is optional.
+func (a ToolArg) describe() string {
+	s := a.Description
+	if !a.Required {
+		if a.Default != "" {
+			s += " (optional; default " + a.Default + ")"
+		} else {
+			s += " (optional)"
+		}
+	}
+	return s
+}
+
+// resolveArguments matches the arguments given for a tool to its argument
+// descriptions. Arguments are given in order, optionally followed by named
+// arguments of the form Name=value, in any order. Omitted optional arguments,
+// and those given as "not specified", are returned as empty strings.
+func resolveArguments(descs []ToolArg, args []string) ([]string, error) {
+	ret := make([]string, len(descs))
+	given := make([]bool, len(descs))
+	named := false
+	n := 0
+	for _, arg := range args {
+		i := -1
+		value := arg
+		if k := strings.Index(arg, "="); k > 0 {
+			name := strings.TrimSpace(arg[:k])
+			for j, d := range descs {
+				if strings.EqualFold(d.Name, name) {
+					i = j
+					value = arg[k+1:]
+					break
+				}
+			}
+		}
+		if i >= 0 {
+			if given[i] {
+				return nil, fmt.Errorf("The %s argument is given more than once.", descs[i].Name)
+			}
+			named = true
+		} else {
+			if named {
+				return nil, fmt.Errorf("The argument '%s' follows a named argument.", arg)
+			}
+			if n >= len(descs) {
+				return nil, fmt.Errorf("Too many arguments; the tool takes %d.", len(descs))
+			}
+			i = n
+			n++
+		}
+		value = strings.TrimSpace(value)
+		if value == "not specified" {
+			value = ""
+		}
+		ret[i] = value
+		given[i] = true
+	}
+	for i, d := range descs {
+		if ret[i] == "" {
+			if d.Required {
+				return nil, fmt.Errorf("The %s argument must be specified.", d.Name)
+			}
+			continue
+		}
+		if len(d.Choices) > 0 {
+			valid := false
+			for _, c := range d.Choices {
+				if strings.EqualFold(c, ret[i]) {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, fmt.Errorf("Invalid %s '%s'; it must be one of %s.", d.Name, ret[i], strings.Join(d.Choices, ", "))
+			}
+		}
+	}
+	return ret, nil
+}
+
+// MemoryEstimator is implemented by tools that can estimate the peak memory,
+// in bytes, that they will require for an input raster of the given size,
+// based on the grids that they allocate. Tools that don't implement it are
+// assumed to hold an input and an output raster in memory.
+type MemoryEstimator interface {
+	EstimateMemory(rows, columns int) int64
+}
+
+// In-memory rasters store their values as float64s, whatever the data type
+// of the file.
+const rasterBytesPerCell = 8
+
+// gridBytes returns the size of a rows x columns grid of bytesPerCell values.
+func gridBytes(rows, columns, bytesPerCell int) int64 {
+	return int64(rows) * int64(columns) * int64(bytesPerCell)
+}
+
+// EstimateToolMemory returns the estimated peak memory, in bytes, of a tool
+// run on a rows x columns raster.
+func (ptm *PluginToolManager) EstimateToolMemory(toolName string, rows, columns int) (int64, error) {
+	toolName = strings.ToLower(getFormattedToolName(toolName))
+	if tool, ok := ptm.mapOfPluginTools[toolName]; ok {
+		if me, ok := tool.(MemoryEstimator); ok {
+			return me.EstimateMemory(rows, columns), nil
+		}
+		return gridBytes(rows, columns, 2*rasterBytesPerCell), nil
+	}
+	return 0, errors.New("Unrecognized tool name. Type 'listtools' for a list of available tools.\n")
+}
+
+// FormatBytes returns a byte count in human-readable units.
+func FormatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
 type PluginToolList []PluginTool
 
 func (ptl PluginToolList) Len() int { return len(ptl) }
@@ -226,16 +597,18 @@ func (ptm *PluginToolManager) GetToolHelp(toolName string) (string, error) {
 		//showToolHelp(tool)
 		ret := tool.GetHelpDocumentation()
 		if ret != "" {
+			ret += "\nVersion: " + tool.GetVersion()
 			args := tool.GetArgDescriptions()
 			for a := 0; a < len(args); a++ {
-				ret += "\nArg Name: " + args[a][0] + ", type: " + args[a][1] + ", Description: " + args[a][2]
+				ret += "\nArg Name: " + args[a].Name + ", type: " + args[a].Type + ", Description: " + args[a].describe()
 			}
 			return ret, nil
 		} else {
 			ret = tool.GetDescription()
+			ret += "\nVersion: " + tool.GetVersion()
 			args := tool.GetArgDescriptions()
 			for a := 0; a < len(args); a++ {
-				ret += "\nArg Name: " + args[a][0] + ", type: " + args[a][1] + ", Description: " + args[a][2]
+				ret += "\nArg Name: " + args[a].Name + ", type: " + args[a].Type + ", Description: " + args[a].describe()
 			}
 			return ret, nil
 		}
@@ -0,0 +1,141 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// pointerEncoding identifies the scheme used to store D8 flow directions
+// in a pointer raster. Elsewhere, flow directions are referred to by the
+// index n of the neighbour in the usual dX and dY offset arrays, i.e.
+// 0 = NE, 1 = E, 2 = SE, 3 = S, 4 = SW, 5 = W, 6 = NW, 7 = N.
+type pointerEncoding int
+
+const (
+	// Whitebox: 1 = NE, 2 = E, 4 = SE, ... 128 = N
+	whiteboxPointer pointerEncoding = iota
+	// Esri (ArcGIS): 1 = E, 2 = SE, 4 = S, ... 128 = NE
+	esriPointer
+	// TauDEM: 1 = E, 2 = NE, 3 = N, ... 8 = SE
+	taudemPointer
+	// GoSpatial backlinks (n + 1): 1 = NE, 2 = E, 3 = SE, ... 8 = N
+	gospatialPointer
+)
+
+var pointerEncodingNames = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+var unknownPointerEncodingError = errors.New("Unrecognized pointer encoding; use 'whitebox', 'esri', 'taudem' or 'gospatial'.")
+
+// TauDEM codes indexed by neighbour index.
+var taudemCodes = [8]int{2, 1, 8, 7, 6, 5, 4, 3}
+
+func parsePointerEncoding(s string) (pointerEncoding, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range pointerEncodingNames {
+		if s == name {
+			return pointerEncoding(i), nil
+		}
+	}
+	if s == "arcgis" {
+		return esriPointer, nil
+	}
+	return whiteboxPointer, unknownPointerEncodingError
+}
+
+func (e pointerEncoding) String() string {
+	return pointerEncodingNames[e]
+}
+
+// encode returns the pointer value of the flow direction to neighbour n.
+func (e pointerEncoding) encode(n int) float64 {
+	switch e {
+	case esriPointer:
+		return float64(int(1) << uint((n+7)%8))
+	case taudemPointer:
+		return float64(taudemCodes[n])
+	case gospatialPointer:
+		return float64(n + 1)
+	}
+	return float64(int(1) << uint(n))
+}
+
+// decode returns the neighbour index of a pointer value, or -1 if the value
+// does not represent a flow direction, e.g. at outlets and pits.
+func (e pointerEncoding) decode(value float64) int {
+	v := int(value)
+	if float64(v) != value || v <= 0 {
+		return -1
+	}
+	if e == gospatialPointer {
+		if v > 8 {
+			return -1
+		}
+		return v - 1
+	}
+	if e == taudemPointer {
+		for n, code := range taudemCodes {
+			if code == v {
+				return n
+			}
+		}
+		return -1
+	}
+	if v&(v-1) != 0 || v > 128 {
+		return -1 // not a single power of two
+	}
+	n := 0
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	if e == esriPointer {
+		n = (n + 1) % 8
+	}
+	return n
+}
+
+// writePointer creates a D8 pointer raster of the flow directions of dem in
+// encoding e, where direction returns the neighbour index of the flow
+// direction of a cell, or -1 if it has none. Cells that are nodata in the DEM
+// are nodata, as are cells without a flow direction, e.g. pits and outlets,
+// in the TauDEM encoding; in other encodings they are assigned 0. The raster
+// is returned unsaved, so that metadata can be added.
+func writePointer(fileName string, dem *raster.Raster, e pointerEncoding, direction func(row, col int) int) (*raster.Raster, error) {
+	rows, columns := dem.Rows, dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_INT16
+	config.NoDataValue = -32768
+	config.InitialValue = config.NoDataValue
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(fileName, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		return nil, err
+	}
+	noFlow := 0.0
+	if e == taudemPointer {
+		noFlow = taudemPointerNodata
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if dem.Value(row, col) == nodata {
+				continue
+			}
+			if n := direction(row, col); n >= 0 {
+				rout.SetValue(row, col, e.encode(n))
+			} else {
+				rout.SetValue(row, col, noFlow)
+			}
+		}
+	}
+	return rout, nil
+}
@@ -30,6 +30,10 @@ func (this *PrintGeoTiffTags) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *PrintGeoTiffTags) GetVersion() string {
+	return "1.0"
+}
+
 func (this *PrintGeoTiffTags) GetHelpDocumentation() string {
 	ret := "This tool prints the tags contained within a GeoTIFF file."
 	return ret
@@ -39,16 +43,15 @@ func (this *PrintGeoTiffTags) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *PrintGeoTiffTags) GetArgDescriptions() [][]string {
+func (this *PrintGeoTiffTags) GetArgDescriptions() []ToolArg {
 	numArgs := 1
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input GeoTiff file name"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input GeoTiff file name"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
 	return ret
 }
@@ -61,7 +64,7 @@ func (this *PrintGeoTiffTags) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -84,7 +87,7 @@ func (this *PrintGeoTiffTags) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -100,7 +103,7 @@ func (this *PrintGeoTiffTags) Run() {
 		return
 	}
 
-	input, err := raster.CreateRasterFromFile(this.inputFile)
+	input, err := raster.CreateRasterFromFile(this.inputFile, raster.RasterConfig{HeaderOnly: true})
 	if err != nil {
 		println(err.Error())
 	}
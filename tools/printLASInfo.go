@@ -32,6 +32,10 @@ func (this *PrintLASInfo) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *PrintLASInfo) GetVersion() string {
+	return "1.0"
+}
+
 func (this *PrintLASInfo) GetHelpDocumentation() string {
 	ret := "This tool prints the metadata associated with a LAS file."
 	return ret
@@ -41,16 +45,15 @@ func (this *PrintLASInfo) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *PrintLASInfo) GetArgDescriptions() [][]string {
+func (this *PrintLASInfo) GetArgDescriptions() []ToolArg {
 	numArgs := 1
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input LAS file name"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input LAS file name"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
 	return ret
 }
@@ -99,6 +102,7 @@ func (this *PrintLASInfo) Run() {
 	input, err := lidar.CreateFromFile(this.inputFile)
 	if err != nil {
 		println(err.Error())
+		return
 	}
 	defer input.Close()
 
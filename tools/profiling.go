@@ -0,0 +1,82 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// startProfiling starts the CPU profile and execution trace of a tool run
+// that were requested with the CPUProfile and TraceFile fields, and returns a
+// function that stops them and writes the memory profile requested with the
+// MemProfile field. Only the outermost of nested tool runs, e.g. those of
+// BatchTiles, is profiled. Profiles that can't be written are reported and
+// skipped; they never stop the tool from running.
+func (ptm *PluginToolManager) startProfiling() func() {
+	if ptm.profiling || (ptm.CPUProfile == "" && ptm.MemProfile == "" && ptm.TraceFile == "") {
+		return func() {}
+	}
+	ptm.profiling = true
+
+	var cpuFile, traceFile *os.File
+	if ptm.CPUProfile != "" {
+		f, err := os.Create(ptm.CPUProfile)
+		if err == nil {
+			if err = pprof.StartCPUProfile(f); err == nil {
+				cpuFile = f
+			} else {
+				f.Close()
+			}
+		}
+		if err != nil {
+			printf("Unable to start the CPU profile: %v\n", err)
+		}
+	}
+	if ptm.TraceFile != "" {
+		f, err := os.Create(ptm.TraceFile)
+		if err == nil {
+			if err = trace.Start(f); err == nil {
+				traceFile = f
+			} else {
+				f.Close()
+			}
+		}
+		if err != nil {
+			printf("Unable to start the execution trace: %v\n", err)
+		}
+	}
+
+	return func() {
+		ptm.profiling = false
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+			printf("CPU profile written to %s\n", ptm.CPUProfile)
+		}
+		if traceFile != nil {
+			trace.Stop()
+			traceFile.Close()
+			printf("Execution trace written to %s\n", ptm.TraceFile)
+		}
+		if ptm.MemProfile != "" {
+			f, err := os.Create(ptm.MemProfile)
+			if err == nil {
+				runtime.GC() // the profile is of the heap at the last collection
+				err = pprof.WriteHeapProfile(f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				printf("Unable to write the memory profile: %v\n", err)
+			} else {
+				printf("Memory profile written to %s\n", ptm.MemProfile)
+			}
+		}
+	}
+}
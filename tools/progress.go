@@ -0,0 +1,83 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"fmt"
+	"time"
+)
+
+// The number of recent progress updates over which the rate is measured.
+const etaWindow = 10
+
+// No estimate is shown until a loop has run for this long, since the early
+// rate of short loops is dominated by noise.
+const etaMinElapsed = 2 * time.Second
+
+// progressETA estimates the time remaining in a loop that reports its
+// progress as a percentage. The rate is measured over the last few updates
+// rather than the whole loop, so that the estimate follows changes in the
+// rate, e.g. as a breaching front moves from steep terrain into flats. A fall
+// in the percentage starts a new estimate, so that a single progressETA
+// follows each of the successive loops of a tool. The zero value is ready to
+// use.
+type progressETA struct {
+	start    time.Time
+	times    []time.Time
+	percents []int
+}
+
+// remaining records the progress of the loop and returns the estimated time
+// remaining, formatted for appending to a progress message. The string has a
+// fixed width, so that it overwrites longer earlier estimates on the line, and
+// is blank when there is no estimate. Progress updates are safe points, at
+// which an interrupted tool stops.
+func (e *progressETA) remaining(progress int) string {
+	checkInterrupt(fmt.Sprintf("at %v%% of its current step", progress))
+	return fmt.Sprintf("%-22s", e.estimate(progress, time.Now()))
+}
+
+// estimate records the progress of the loop at the given time and returns the
+// estimated time remaining, or an empty string when there is no estimate yet.
+func (e *progressETA) estimate(progress int, now time.Time) string {
+	if n := len(e.percents); n == 0 || progress < e.percents[n-1] {
+		e.start = now
+		e.times = e.times[:0]
+		e.percents = e.percents[:0]
+	}
+	e.times = append(e.times, now)
+	e.percents = append(e.percents, progress)
+	if len(e.percents) > etaWindow {
+		e.times = e.times[1:]
+		e.percents = e.percents[1:]
+	}
+
+	n := len(e.percents)
+	if progress >= 100 || n < 2 || now.Sub(e.start) < etaMinElapsed {
+		return ""
+	}
+	done := e.percents[n-1] - e.percents[0]
+	elapsed := e.times[n-1].Sub(e.times[0])
+	if done <= 0 || elapsed <= 0 {
+		return ""
+	}
+	left := time.Duration(float64(elapsed) * float64(100-progress) / float64(done))
+	return fmt.Sprintf(" (%s remaining)", formatETA(left))
+}
+
+// formatETA formats a duration to the nearest second, e.g. 1h02m05s or 45s.
+func formatETA(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%dm%02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
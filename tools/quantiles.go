@@ -37,6 +37,10 @@ func (this *Quantiles) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *Quantiles) GetVersion() string {
+	return "1.0"
+}
+
 func (this *Quantiles) GetHelpDocumentation() string {
 	ret := ""
 	return ret
@@ -46,24 +50,26 @@ func (this *Quantiles) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *Quantiles) GetArgDescriptions() [][]string {
+func (this *Quantiles) GetArgDescriptions() []ToolArg {
 	numArgs := 3
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input File name, with directory and file extension"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
 
-	ret[2][0] = "NumBins"
-	ret[2][1] = "int"
-	ret[2][2] = "The number of bins used to calculate the histogram"
+	ret[2].Name = "NumBins"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of bins used to calculate the histogram"
+	ret[2].Default = "1"
 
 	return ret
 }
@@ -76,7 +82,7 @@ func (this *Quantiles) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -87,7 +93,7 @@ func (this *Quantiles) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -120,7 +126,7 @@ func (this *Quantiles) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -137,7 +143,7 @@ func (this *Quantiles) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
@@ -164,6 +170,7 @@ func (this *Quantiles) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress, col, row, i, bin int
+	var eta progressETA
 	var z float64
 
 	println("Reading raster data...")
@@ -255,7 +262,7 @@ func (this *Quantiles) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rProgress: %v%%", progress)
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -266,7 +273,7 @@ func (this *Quantiles) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by Quantiles with %v bins", this.numBins))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Quantiles tool (%s) with %v bins", this.toolManager.toolVersion(this), this.numBins))
 	rout.Save()
 
 	println("Operation complete!")
@@ -0,0 +1,327 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// RasterFootprint writes the extent of a raster as a polygon, and optionally
+// a coordinate grid as polylines.
+type RasterFootprint struct {
+	inputFile     string
+	outputFile    string
+	spacing       float64
+	graticuleFile string
+	toolManager   *PluginToolManager
+}
+
+func (this *RasterFootprint) GetName() string {
+	s := "RasterFootprint"
+	return getFormattedToolName(s)
+}
+
+func (this *RasterFootprint) GetDescription() string {
+	s := "Writes a raster's extent (and a graticule) as vector data"
+	return getFormattedToolDescription(s)
+}
+
+func (this *RasterFootprint) GetVersion() string {
+	return "1.0"
+}
+
+func (this *RasterFootprint) GetHelpDocumentation() string {
+	ret := "This tool writes the footprint of a raster, i.e. the polygon bounding its " +
+		"extent, e.g. to build index maps of processed tiles, with the attributes NAME, the " +
+		"raster's file name, ROWS and COLUMNS, so that it can be used directly as a polygon " +
+		"by tools such as EditDEM. If a GraticuleSpacing is specified, the lines of a " +
+		"coordinate grid with this spacing, in the units of the raster's coordinate system " +
+		"(i.e. degrees for a geographic raster), are also written within the extent as " +
+		"polylines to the GraticuleFile, by default the OutputFile with '_graticule' added " +
+		"to its name, with the attributes AXIS, x for lines of constant x and y for those of " +
+		"constant y, and VALUE, their coordinate. The outputs are shapefiles, or GeoJSON " +
+		"files if their names have a .geojson or .json extension, in the raster's " +
+		"coordinate reference system."
+	return ret
+}
+
+func (this *RasterFootprint) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *RasterFootprint) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output shapefile or GeoJSON file name, with directory"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	ret[2].Name = "GraticuleSpacing"
+	ret[2].Type = "float64"
+	ret[2].Description = "The spacing of the coordinate grid lines"
+
+	ret[3].Name = "GraticuleFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The output coordinate grid file name"
+	ret[3].Role = ArgOutput
+
+	return ret
+}
+
+func (this *RasterFootprint) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input and output files must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.spacing = 0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if !this.setSpacing(args[2]) {
+			return
+		}
+	}
+	this.setGraticuleFile("")
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		this.setGraticuleFile(args[3])
+	}
+
+	this.Run()
+}
+
+func (this *RasterFootprint) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output shapefile or GeoJSON file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the graticule spacing
+	this.spacing = 0
+	print("Graticule spacing (blank for none): ")
+	spacingStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(spacingStr)) > 0 {
+		if !this.setSpacing(spacingStr) {
+			return
+		}
+	}
+
+	// get the graticule file name
+	this.setGraticuleFile("")
+	if this.spacing > 0 {
+		print("Enter the output graticule file name (blank for the default): ")
+		graticuleFile, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		this.setGraticuleFile(graticuleFile)
+	}
+
+	this.Run()
+}
+
+func (this *RasterFootprint) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	this.outputFile = outputFile
+}
+
+// setGraticuleFile sets the graticule file name, which is derived from the
+// output file name if s is blank.
+func (this *RasterFootprint) setGraticuleFile(s string) {
+	graticuleFile := strings.TrimSpace(s)
+	if graticuleFile == "" {
+		ext := filepath.Ext(this.outputFile)
+		switch strings.ToLower(ext) {
+		case ".shp", ".geojson", ".json":
+		default:
+			ext = ""
+		}
+		graticuleFile = strings.TrimSuffix(this.outputFile, ext) + "_graticule" + ext
+	} else if !strings.Contains(graticuleFile, pathSep) {
+		graticuleFile = this.toolManager.workingDirectory + graticuleFile
+	}
+	this.graticuleFile = graticuleFile
+}
+
+func (this *RasterFootprint) setSpacing(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The graticule spacing must be greater than zero.")
+		return false
+	}
+	this.spacing = v
+	return true
+}
+
+func (this *RasterFootprint) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	north, south, east, west := rin.North, rin.South, rin.East, rin.West
+
+	if this.spacing > 0 && ((east-west)/this.spacing > 10000 || (north-south)/this.spacing > 10000) {
+		println("The graticule spacing is too small for the extent of the raster.")
+		return
+	}
+
+	inConfig := rin.GetRasterConfig()
+	setCRS := func(s *vector.Shapefile) {
+		if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt != "not specified" {
+			s.CoordinateRefSystemWKT = wkt
+		}
+		s.EPSGCode = inConfig.EPSGCode
+	}
+
+	start2 := time.Now()
+
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	name := filepath.Base(this.inputFile)
+	if len(name) > 254 {
+		name = name[:254]
+	}
+	fields := []vector.Field{
+		{Name: "NAME", Type: 'C', Length: len(name)},
+		{Name: "ROWS", Type: 'N', Length: 10},
+		{Name: "COLUMNS", Type: 'N', Length: 10},
+	}
+	output, err := vector.CreateNewShapefile(this.outputFile, vector.ST_Polygon, fields)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	setCRS(output)
+	footprint := vector.NewPolygon([][2]float64{{west, north}, {east, north}, {east, south}, {west, south}})
+	if err = output.AddShape(footprint, name, rin.Rows, rin.Columns); err != nil {
+		println(err.Error())
+		return
+	}
+	if err = output.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	numLines := 0
+	if this.spacing > 0 {
+		fields := []vector.Field{
+			{Name: "AXIS", Type: 'C', Length: 1},
+			{Name: "VALUE", Type: 'N', Length: 20, Decimals: 6},
+		}
+		graticule, err := vector.CreateNewShapefile(this.graticuleFile, vector.ST_PolyLine, fields)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		setCRS(graticule)
+		// lines are placed at multiples of the spacing, strictly within the extent
+		for i := math.Floor(west/this.spacing) + 1; i*this.spacing < east; i++ {
+			x := i * this.spacing
+			if err = graticule.AddShape(vector.NewPolyLine([][2]float64{{x, south}, {x, north}}), "x", x); err != nil {
+				println(err.Error())
+				return
+			}
+			numLines++
+		}
+		for i := math.Floor(south/this.spacing) + 1; i*this.spacing < north; i++ {
+			y := i * this.spacing
+			if err = graticule.AddShape(vector.NewPolyLine([][2]float64{{west, y}, {east, y}}), "y", y); err != nil {
+				println(err.Error())
+				return
+			}
+			numLines++
+		}
+		if err = graticule.Save(); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	elapsed := time.Since(start2)
+	printf("Extent: N %v, S %v, E %v, W %v\n", format(north), format(south), format(east), format(west))
+	if inConfig.EPSGCode != 0 {
+		printf("Coordinate reference system: EPSG:%v\n", inConfig.EPSGCode)
+	} else if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt == "" || wkt == "not specified" {
+		println("Warning: the raster has no coordinate reference system.")
+	}
+	if this.spacing > 0 {
+		printf("Graticule lines written: %v\n", numLines)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,232 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// RasterStreamsToVector writes the links of a raster stream network as
+// polylines with their Strahler orders.
+type RasterStreamsToVector struct {
+	streamsFile string
+	accumFile   string
+	outputFile  string
+	toolManager *PluginToolManager
+}
+
+func (this *RasterStreamsToVector) GetName() string {
+	s := "RasterStreamsToVector"
+	return getFormattedToolName(s)
+}
+
+func (this *RasterStreamsToVector) GetDescription() string {
+	s := "Writes raster streams as polylines with Strahler orders"
+	return getFormattedToolDescription(s)
+}
+
+func (this *RasterStreamsToVector) GetVersion() string {
+	return "1.0"
+}
+
+func (this *RasterStreamsToVector) GetHelpDocumentation() string {
+	ret := "This tool converts a raster stream network, e.g. the output of ExtractStreams, " +
+		"in which stream cells are greater than zero, to polylines, one per link, i.e. per " +
+		"reach between a channel head or junction and the next junction or outlet. Flow " +
+		"directions are taken from the flow accumulation raster from which the streams were " +
+		"extracted, each stream cell draining to its neighbouring stream cell of greatest " +
+		"accumulation, provided that it exceeds its own, so that streams thinned from a " +
+		"dispersive accumulation are followed as well as D8 streams. Lines run downstream " +
+		"through the cell centres, and each ends at the first cell of the link below it. " +
+		"Each line has the attributes LINK_ID, numbering the links from 1, DS_LINK, the " +
+		"link that it drains to (0 at outlets), STRAHLER, its Strahler stream order, and " +
+		"LENGTH, in map units. The output is a shapefile, or a GeoJSON file if the output " +
+		"file name has a .geojson or .json extension, in the coordinate reference system of " +
+		"the streams. Isolated single stream cells are skipped."
+	return ret
+}
+
+func (this *RasterStreamsToVector) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *RasterStreamsToVector) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "StreamsFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The streams raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "AccumulationFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The flow accumulation raster name, with directory and file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output shapefile or GeoJSON file name, with directory"
+	ret[2].Role = ArgOutput
+	ret[2].Required = true
+
+	return ret
+}
+
+func (this *RasterStreamsToVector) EstimateMemory(rows, columns int) int64 {
+	// the streams and accumulation rasters, the downstream cells and the
+	// numbers of inflowing cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+5)
+}
+
+func (this *RasterStreamsToVector) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The streams file, accumulation file, and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(&this.streamsFile, args[0]) || !this.setInputFile(&this.accumFile, args[1]) {
+		return
+	}
+	this.setOutputFile(args[2])
+
+	this.Run()
+}
+
+func (this *RasterStreamsToVector) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the streams file name
+	print("Enter the streams file name (incl. file extension): ")
+	streamsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(&this.streamsFile, streamsFile) {
+		return
+	}
+
+	// get the accumulation file name
+	print("Enter the flow accumulation file name (incl. file extension): ")
+	accumFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(&this.accumFile, accumFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output shapefile or GeoJSON file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	this.Run()
+}
+
+func (this *RasterStreamsToVector) setInputFile(fileName *string, s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	*fileName = inputFile
+	if !raster.FileExists(inputFile) {
+		printf("no such file or directory: %s\n", inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *RasterStreamsToVector) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	this.outputFile = outputFile
+}
+
+func (this *RasterStreamsToVector) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	streams, err := raster.CreateRasterFromFile(this.streamsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	accum, err := raster.CreateRasterFromFile(this.accumFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if !onSameGrid(streams, accum) {
+		println("The streams and accumulation rasters must have the same dimensions.")
+		return
+	}
+	inConfig := streams.GetRasterConfig()
+
+	start2 := time.Now()
+
+	sn := traceStreamNetwork(streams, accum)
+
+	// the output polylines
+	fields := []vector.Field{
+		{Name: "LINK_ID", Type: 'N', Length: 10},
+		{Name: "DS_LINK", Type: 'N', Length: 10},
+		{Name: "STRAHLER", Type: 'N', Length: 4},
+		{Name: "LENGTH", Type: 'N', Length: 18, Decimals: 3},
+	}
+	output, err := vector.CreateNewShapefile(this.outputFile, vector.ST_PolyLine, fields)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt != "not specified" {
+		output.CoordinateRefSystemWKT = wkt
+	}
+	output.EPSGCode = inConfig.EPSGCode
+
+	// links of a single cell, i.e. isolated cells and outlets at junctions,
+	// are not written
+	for _, l := range sn.links {
+		if l.id == 0 {
+			continue
+		}
+		vertices, length := l.vertices(streams)
+		if err = output.AddShape(vector.NewPolyLine(vertices), l.id, sn.downstreamID(l), l.order, length); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	elapsed := time.Since(start2)
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+	if err = output.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Stream links: %v, highest Strahler order: %v\n", sn.numLinks, sn.maxOrder)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,416 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ReadXYZ grids the points of an x,y,z text file into a raster.
+type ReadXYZ struct {
+	inputFile   string
+	outputFile  string
+	cellSize    float64
+	statistic   string
+	epsgCode    int
+	toolManager *PluginToolManager
+}
+
+func (this *ReadXYZ) GetName() string {
+	s := "ReadXYZ"
+	return getFormattedToolName(s)
+}
+
+func (this *ReadXYZ) GetDescription() string {
+	s := "Grids an x,y,z text (CSV) point file to a raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ReadXYZ) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ReadXYZ) GetHelpDocumentation() string {
+	ret := "This tool grids the points of a text file containing one 'x y z' (or 'x,y,z') " +
+		"point per line, optionally preceded by a header line, into a raster with the " +
+		"specified CellSize. Additional fields on each line are ignored. The points are " +
+		"treated as cell centres, so that a file exported by the WriteXYZ tool is read back " +
+		"onto the same grid. Each cell is assigned the mean (default), minimum, maximum, or " +
+		"number ('count') of the z values of the points that it contains, or the z value of " +
+		"the last of them ('last'); cells without points are assigned nodata. The optional " +
+		"EPSG code specifies the coordinate reference system of the points."
+	return ret
+}
+
+func (this *ReadXYZ) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ReadXYZ) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input x,y,z text file name, with directory and extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output grid resolution"
+	ret[2].Required = true
+
+	ret[3].Name = "Statistic"
+	ret[3].Type = "string"
+	ret[3].Description = "mean, min, max, count or last"
+	ret[3].Default = "mean"
+	ret[3].Choices = []string{"mean", "min", "max", "count", "last"}
+
+	ret[4].Name = "EPSG"
+	ret[4].Type = "int"
+	ret[4].Description = "The EPSG code of the points"
+
+	return ret
+}
+
+func (this *ReadXYZ) EstimateMemory(rows, columns int) int64 {
+	// the output raster and the per-cell sums and counts
+	return gridBytes(rows, columns, rasterBytesPerCell+12)
+}
+
+func (this *ReadXYZ) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and cell size must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if !this.setCellSize(args[2]) {
+		return
+	}
+	this.statistic = "mean"
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setStatistic(args[3]) {
+			return
+		}
+	}
+	this.epsgCode = 0
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if !this.setEPSGCode(args[4]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ReadXYZ) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the x,y,z file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the cell size
+	print("Cell size: ")
+	cellSizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setCellSize(cellSizeStr) {
+		return
+	}
+
+	// get the statistic
+	this.statistic = "mean"
+	print("Statistic (mean, min, max, count or last; default mean): ")
+	statistic, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(statistic)) > 0 {
+		if !this.setStatistic(statistic) {
+			return
+		}
+	}
+
+	// get the EPSG code
+	this.epsgCode = 0
+	print("EPSG code of the points (optional): ")
+	epsgStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(epsgStr)) > 0 {
+		if !this.setEPSGCode(epsgStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ReadXYZ) setCellSize(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The cell size must be greater than zero.")
+		return false
+	}
+	this.cellSize = v
+	return true
+}
+
+func (this *ReadXYZ) setStatistic(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "mean", "min", "max", "count", "last":
+		this.statistic = s
+		return true
+	}
+	println("Unrecognized statistic; use 'mean', 'min', 'max', 'count' or 'last'.")
+	return false
+}
+
+func (this *ReadXYZ) setEPSGCode(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "EPSG") {
+		s = strings.TrimLeft(s[4:], ":_- ")
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code <= 0 {
+		println("The EPSG code must be a positive integer.")
+		return false
+	}
+	this.epsgCode = code
+	return true
+}
+
+func (this *ReadXYZ) Run() {
+	start1 := time.Now()
+
+	println("Reading point data...")
+	xs, ys, zs, err := readXYZFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	printf("Number of points: %v\n", len(xs))
+
+	start2 := time.Now()
+
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+	cellSize := this.cellSize
+	columns := int(math.Floor((maxX-minX)/cellSize+0.5)) + 1
+	rows := int(math.Floor((maxY-minY)/cellSize+0.5)) + 1
+	west := minX - cellSize/2.0
+	north := maxY + cellSize/2.0
+	east := west + float64(columns)*cellSize
+	south := north - float64(rows)*cellSize
+	printf("Output grid: %v rows x %v columns\n", rows, columns)
+
+	cellValue := make([]float64, rows*columns)
+	count := make([]int32, rows*columns)
+	for i := range xs {
+		row := int(math.Floor((north - ys[i]) / cellSize))
+		col := int(math.Floor((xs[i] - west) / cellSize))
+		if row >= rows {
+			row = rows - 1
+		}
+		if col >= columns {
+			col = columns - 1
+		}
+		k := row*columns + col
+		z := zs[i]
+		switch {
+		case count[k] == 0 || this.statistic == "last":
+			cellValue[k] = z
+		case this.statistic == "mean":
+			cellValue[k] += z
+		case this.statistic == "min":
+			cellValue[k] = math.Min(cellValue[k], z)
+		case this.statistic == "max":
+			cellValue[k] = math.Max(cellValue[k], z)
+		}
+		count[k]++
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	if this.statistic == "count" {
+		config.DataType = raster.DT_INT32
+	}
+	nodata := -32768.0
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.EPSGCode = this.epsgCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	numFilled := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			k := row*columns + col
+			if count[k] == 0 {
+				continue
+			}
+			numFilled++
+			switch this.statistic {
+			case "mean":
+				rout.SetValue(row, col, cellValue[k]/float64(count[k]))
+			case "count":
+				rout.SetValue(row, col, float64(count[k]))
+			default:
+				rout.SetValue(row, col, cellValue[k])
+			}
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ReadXYZ tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Input file: %s", this.inputFile))
+	rout.AddMetadataEntry(fmt.Sprintf("Statistic: %s", this.statistic))
+	rout.Save()
+
+	printf("Cells containing points: %v of %v\n", numFilled, rows*columns)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// readXYZFile reads the points of a text file containing one 'x y z' (or
+// 'x,y,z') point per line. A first line that can't be read as a point is
+// treated as a header and fields after the third are ignored. A gzipped
+// file, e.g. points.xyz.gz, is decompressed as it is read.
+func readXYZFile(fileName string) (xs, ys, zs []float64, err error) {
+	f, err := raster.OpenDecompressed(fileName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ',' || c == ';'
+		})
+		var v [3]float64
+		ok := len(fields) >= 3
+		for i := 0; ok && i < 3; i++ {
+			if v[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				ok = false
+			}
+		}
+		if !ok {
+			if first {
+				first = false
+				continue // a header
+			}
+			return nil, nil, nil, fmt.Errorf("Unable to read the point '%s'.", line)
+		}
+		first = false
+		xs = append(xs, v[0])
+		ys = append(ys, v[1])
+		zs = append(zs, v[2])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(xs) == 0 {
+		return nil, nil, nil, errors.New("The file does not contain any points.")
+	}
+	return xs, ys, zs, nil
+}
@@ -0,0 +1,454 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Reclass assigns new values to the cells of a raster whose values lie in
+// given ranges, e.g. to derive velocity coefficients from land cover classes.
+type Reclass struct {
+	inputFile    string
+	outputFile   string
+	classes      []reclassClass
+	unclassified string // keep, nodata or a value
+	toolManager  *PluginToolManager
+}
+
+// reclassClass assigns a new value, or nodata, to the cells whose values are
+// from 'from' up to, but not including, 'to', or are equal to 'from' if the
+// two are equal, or that are nodata if fromNodata is true.
+type reclassClass struct {
+	value      float64
+	toNodata   bool
+	from, to   float64
+	fromNodata bool
+}
+
+func (this *Reclass) GetName() string {
+	s := "Reclass"
+	return getFormattedToolName(s)
+}
+
+func (this *Reclass) GetDescription() string {
+	s := "Assigns new values to ranges of raster values"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Reclass) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Reclass) GetHelpDocumentation() string {
+	ret := "This tool reclassifies a raster, assigning a new value to each cell whose value " +
+		"lies in one of a list of ranges. The classes are given as ReclassValues, a list of " +
+		"'new from to' triplets separated by spaces, e.g. '1 0 100 2 100 200', or by commas " +
+		"when entered interactively, or, if ReclassValues isn't specified, in a ClassFile " +
+		"with one triplet on each line and lines beginning with '#' skipped. A class includes " +
+		"the values from 'from' up to, but not including, 'to', or, if 'to' equals 'from', " +
+		"that single value; the first class listed that includes a value is the one that " +
+		"applies. The new value may be 'nodata', to assign nodata to the class, and 'from' " +
+		"and 'to' may both be 'nodata', to assign a value to the nodata cells. The cells whose " +
+		"values lie outside all of the ranges keep their values by default, or are assigned " +
+		"nodata, or a given value, according to Unclassified ('keep', 'nodata' or a number). " +
+		"The output is an integer raster if the input is and every assigned value is a whole " +
+		"number, and a floating-point raster otherwise."
+	return ret
+}
+
+func (this *Reclass) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Reclass) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "ReclassValues"
+	ret[2].Type = "string"
+	ret[2].Description = "The 'new from to' triplets, separated by spaces, e.g. 1 0 100 2 100 200"
+
+	ret[3].Name = "ClassFile"
+	ret[3].Type = "string"
+	ret[3].Description = "A text file of 'new from to' triplets, used if ReclassValues isn't specified"
+	ret[3].Role = ArgInput
+
+	ret[4].Name = "Unclassified"
+	ret[4].Type = "string"
+	ret[4].Description = "The value of cells outside all ranges, keep, nodata or a number"
+	ret[4].Default = "keep"
+
+	return ret
+}
+
+func (this *Reclass) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters
+	return gridBytes(rows, columns, 2*rasterBytesPerCell)
+}
+
+func (this *Reclass) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and reclass values or class file must be specified.")
+		return
+	}
+	inputFile := strings.TrimSpace(args[0])
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	if specified(2) {
+		if this.classes, err = parseReclassClasses(strings.TrimSpace(args[2])); err != nil {
+			println(err.Error())
+			return
+		}
+	} else if specified(3) {
+		if !this.readClassFile(args[3]) {
+			return
+		}
+	} else {
+		println("Either the reclass values or a class file must be specified.")
+		return
+	}
+
+	this.unclassified = "keep"
+	if specified(4) && !this.setUnclassified(args[4]) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *Reclass) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the classes
+	print("Reclass values, 'new from to' triplets (blank to read a class file): ")
+	values, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if values = strings.TrimSpace(values); values != "" {
+		if this.classes, err = parseReclassClasses(values); err != nil {
+			println(err.Error())
+			return
+		}
+	} else {
+		print("Enter the class file name: ")
+		classFile, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if !this.readClassFile(classFile) {
+			return
+		}
+	}
+
+	// get the value of unclassified cells
+	print("Value of cells outside all ranges, keep, nodata or a number (default keep): ")
+	unclassified, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.unclassified = "keep"
+	if len(strings.TrimSpace(unclassified)) > 0 && !this.setUnclassified(unclassified) {
+		return
+	}
+
+	this.Run()
+}
+
+func (this *Reclass) readClassFile(s string) bool {
+	classFile := strings.TrimSpace(s)
+	if !strings.Contains(classFile, pathSep) {
+		classFile = this.toolManager.workingDirectory + classFile
+	}
+	b, err := ioutil.ReadFile(classFile)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	// each line is a triplet, so the lines are simply joined
+	lines := make([]string, 0)
+	for _, line := range strings.Split(strings.Replace(string(b), "\r", "", -1), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if this.classes, err = parseReclassClasses(strings.Join(lines, " ")); err != nil {
+		println(err.Error())
+		return false
+	}
+	return true
+}
+
+func (this *Reclass) setUnclassified(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s != "keep" && s != "nodata" {
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			println("The value of unclassified cells must be 'keep', 'nodata' or a number.")
+			return false
+		}
+	}
+	this.unclassified = s
+	return true
+}
+
+// parseReclassClasses parses a list of 'new from to' triplets, separated by
+// commas or white space, where the new value may be 'nodata', and 'from' and
+// 'to' may both be 'nodata'.
+func parseReclassClasses(s string) ([]reclassClass, error) {
+	fields := strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t' || c == '\n'
+	})
+	if len(fields) == 0 || len(fields)%3 != 0 {
+		return nil, errors.New("The reclass values must be a list of 'new from to' triplets.")
+	}
+	classes := make([]reclassClass, 0, len(fields)/3)
+	for i := 0; i < len(fields); i += 3 {
+		var c reclassClass
+		var err error
+		triplet := strings.Join(fields[i:i+3], " ")
+		if strings.ToLower(fields[i]) == "nodata" {
+			c.toNodata = true
+		} else if c.value, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil, fmt.Errorf("Unable to read the new value of the class '%s'.", triplet)
+		}
+		from, to := strings.ToLower(fields[i+1]), strings.ToLower(fields[i+2])
+		if from == "nodata" || to == "nodata" {
+			if from != to {
+				return nil, fmt.Errorf("The class '%s' must have both its 'from' and 'to' values nodata.", triplet)
+			}
+			c.fromNodata = true
+		} else {
+			c.from, err = strconv.ParseFloat(from, 64)
+			if err == nil {
+				c.to, err = strconv.ParseFloat(to, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read the range of the class '%s'.", triplet)
+			}
+			if c.to < c.from {
+				return nil, fmt.Errorf("The class '%s' ends before it starts.", triplet)
+			}
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// includes returns whether the class includes the value z, which is nodata
+// if isNodata is true.
+func (c reclassClass) includes(z float64, isNodata bool) bool {
+	if c.fromNodata || isNodata {
+		return c.fromNodata && isNodata
+	}
+	if c.from == c.to {
+		return z == c.from
+	}
+	return z >= c.from && z < c.to
+}
+
+// reclassify returns the class of the value z, which is nodata if isNodata is
+// true, the first that includes it, or -1 if none does.
+func reclassify(classes []reclassClass, z float64, isNodata bool) int {
+	for i, c := range classes {
+		if c.includes(z, isNodata) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (this *Reclass) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+
+	// the output is an integer raster only if every value it may hold is a
+	// whole number
+	var unclassifiedValue float64
+	if this.unclassified != "keep" && this.unclassified != "nodata" {
+		unclassifiedValue, _ = strconv.ParseFloat(this.unclassified, 64)
+	}
+	isWhole := func(v float64) bool { return v == math.Trunc(v) && math.Abs(v) < math.MaxInt32 }
+	integer := false
+	switch inConfig.DataType {
+	case raster.DT_INT8, raster.DT_UINT8, raster.DT_INT16, raster.DT_UINT16,
+		raster.DT_INT32, raster.DT_UINT32, raster.DT_INT64, raster.DT_UINT64:
+		integer = isWhole(unclassifiedValue)
+	}
+	for _, c := range this.classes {
+		if !c.toNodata && !isWhole(c.value) {
+			integer = false
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	if integer {
+		config.DataType = raster.DT_INT32
+	}
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	counts := make([]int, len(this.classes))
+	numUnclassified := 0
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z := rin.Value(row, col)
+			if i := reclassify(this.classes, z, z == nodata); i >= 0 {
+				counts[i]++
+				if !this.classes[i].toNodata {
+					rout.SetValue(row, col, this.classes[i].value)
+				}
+			} else if z != nodata {
+				numUnclassified++
+				switch this.unclassified {
+				case "keep":
+					rout.SetValue(row, col, z)
+				case "nodata":
+				default:
+					rout.SetValue(row, col, unclassifiedValue)
+				}
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Reclass tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Classes: %v", len(this.classes)))
+	rout.AddMetadataEntry(fmt.Sprintf("Unclassified cells: %s", this.unclassified))
+	rout.Save()
+
+	for i, c := range this.classes {
+		newValue, from, to := fmt.Sprint(c.value), fmt.Sprint(c.from), fmt.Sprint(c.to)
+		if c.toNodata {
+			newValue = "nodata"
+		}
+		if c.fromNodata {
+			from, to = "nodata", "nodata"
+		}
+		printf("Class %v (%s from %s to %s): %v cells\n", i+1, newValue, from, to, counts[i])
+	}
+	printf("Unclassified cells: %v\n", numUnclassified)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,374 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/epsg"
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ReprojectToUTM reports the UTM zone of a raster and optionally reprojects
+// the raster into it.
+type ReprojectToUTM struct {
+	inputFile   string
+	outputFile  string
+	cellSize    float64
+	bilinear    bool
+	toolManager *PluginToolManager
+}
+
+func (this *ReprojectToUTM) GetName() string {
+	s := "ReprojectToUTM"
+	return getFormattedToolName(s)
+}
+
+func (this *ReprojectToUTM) GetDescription() string {
+	s := "Reprojects a raster into its UTM zone"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ReprojectToUTM) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ReprojectToUTM) GetHelpDocumentation() string {
+	ret := "This tool determines the Universal Transverse Mercator (UTM) zone containing the " +
+		"centre of a raster and reprojects the raster into that zone. The input raster must " +
+		"either be in geographic coordinates or in a coordinate reference system with a known " +
+		"EPSG code. Where the registry contains a UTM system on the same datum as the input it " +
+		"is used, otherwise WGS 84 is assumed; no datum transformation is performed. The output " +
+		"cell size defaults to the input cell size at the raster centre. Resampling is either " +
+		"'nearest' or 'bilinear' (default). If no output file is specified, the UTM EPSG code " +
+		"is reported without reprojecting the raster."
+	return ret
+}
+
+func (this *ReprojectToUTM) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ReprojectToUTM) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename (if not specified the UTM zone is only reported)"
+	ret[1].Role = ArgOutputRaster
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output cell size in metres"
+
+	ret[3].Name = "Resampling"
+	ret[3].Type = "string"
+	ret[3].Description = "The resampling method, 'nearest' or 'bilinear'"
+	ret[3].Default = "bilinear"
+	ret[3].Choices = []string{"nearest", "bilinear"}
+
+	return ret
+}
+
+func (this *ReprojectToUTM) ParseArguments(args []string) {
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	this.outputFile = ""
+	if len(args) > 1 && len(strings.TrimSpace(args[1])) > 0 && args[1] != "not specified" {
+		outputFile := strings.TrimSpace(args[1])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.cellSize = -1.0
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		var err error
+		if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+			this.cellSize = -1.0
+			println(err)
+		}
+	}
+
+	this.bilinear = true
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(args[3])), "n") {
+			this.bilinear = false
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ReprojectToUTM) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension; blank to only report the zone): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputFile = ""
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	// get the cell size
+	print("Output cell size in metres (blank for default): ")
+	cellSizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.cellSize = -1.0
+	if len(strings.TrimSpace(cellSizeStr)) > 0 {
+		if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(cellSizeStr), 64); err != nil {
+			this.cellSize = -1.0
+			println(err)
+		}
+	}
+
+	// get the resampling method
+	print("Resampling method, 'nearest' or 'bilinear' (blank for bilinear): ")
+	resampling, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.bilinear = !strings.HasPrefix(strings.ToLower(strings.TrimSpace(resampling)), "n")
+
+	this.Run()
+}
+
+func (this *ReprojectToUTM) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	inConfig := rin.GetRasterConfig()
+	nodata := rin.NoDataValue
+
+	// what is the coordinate system of the input?
+	var srcCRS epsg.CRS
+	var ok bool
+	if srcCRS, ok = epsg.Lookup(inConfig.EPSGCode); !ok {
+		if !rin.IsInGeographicCoordinates() {
+			println("The coordinate reference system of the input raster is unknown.")
+			return
+		}
+		srcCRS, _ = epsg.Lookup(4326)
+	}
+
+	code, err := rin.GetUTMCode()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	dstCRS, _ := epsg.Lookup(code)
+	printf("UTM coordinate reference system: EPSG:%d %s\n", code, dstCRS.Name)
+
+	if this.outputFile == "" {
+		return
+	}
+
+	if srcCRS.Code == dstCRS.Code {
+		println("The input raster is already in this coordinate reference system.")
+		return
+	}
+
+	transform := func(x, y float64, from, to epsg.CRS) (float64, float64, error) {
+		lon, lat, err := from.Inverse(x, y)
+		if err != nil {
+			return 0, 0, err
+		}
+		return to.Forward(lon, lat)
+	}
+
+	// find the extent of the output by transforming points along the edges of the input
+	minX, minY := math.MaxFloat64, math.MaxFloat64
+	maxX, maxY := -math.MaxFloat64, -math.MaxFloat64
+	numSteps := 100
+	for i := 0; i <= numSteps; i++ {
+		f := float64(i) / float64(numSteps)
+		xs := []float64{rin.West + f*(rin.East-rin.West), rin.West + f*(rin.East-rin.West), rin.West, rin.East}
+		ys := []float64{rin.North, rin.South, rin.South + f*(rin.North-rin.South), rin.South + f*(rin.North-rin.South)}
+		for j := range xs {
+			x, y, err := transform(xs[j], ys[j], srcCRS, dstCRS)
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			minX = math.Min(minX, x)
+			maxX = math.Max(maxX, x)
+			minY = math.Min(minY, y)
+			maxY = math.Max(maxY, y)
+		}
+	}
+
+	// the default cell size preserves the input resolution at the raster centre
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+	if this.cellSize <= 0 {
+		xc := (rin.East + rin.West) / 2.0
+		yc := (rin.North + rin.South) / 2.0
+		x0, y0, err := transform(xc, yc, srcCRS, dstCRS)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		x1, y1, _ := transform(xc+cellSizeX, yc, srcCRS, dstCRS)
+		x2, y2, _ := transform(xc, yc+cellSizeY, srcCRS, dstCRS)
+		this.cellSize = (math.Hypot(x1-x0, y1-y0) + math.Hypot(x2-x0, y2-y0)) / 2.0
+	}
+	if this.cellSize <= 0 {
+		println(errors.New("The output cell size could not be determined.").Error())
+		return
+	}
+
+	columns := int(math.Ceil((maxX - minX) / this.cellSize))
+	rows := int(math.Ceil((maxY - minY) / this.cellSize))
+	west := minX
+	north := maxY
+	east := west + float64(columns)*this.cellSize
+	south := north - float64(rows)*this.cellSize
+	printf("Output grid: %v rows x %v columns, cell size %v m\n", rows, columns, this.cellSize)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	if this.bilinear {
+		config.DataType = raster.DT_FLOAT32
+	}
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = "metres"
+	config.EPSGCode = code
+	config.CoordinateRefSystemWKT = dstCRS.WKT()
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	inRows := float64(rin.Rows)
+	inColumns := float64(rin.Columns)
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		y := north - (float64(row)+0.5)*this.cellSize
+		for col := 0; col < columns; col++ {
+			x := west + (float64(col)+0.5)*this.cellSize
+			sx, sy, err := transform(x, y, dstCRS, srcCRS)
+			if err != nil {
+				continue
+			}
+			// fractional row and column of the source grid
+			c := (sx-rin.West)/cellSizeX - 0.5
+			r := (rin.North-sy)/cellSizeY - 0.5
+			if r < -0.5 || c < -0.5 || r > inRows-0.5 || c > inColumns-0.5 {
+				continue
+			}
+			if this.bilinear {
+				rout.SetValue(row, col, rin.SampleBilinear(sx, sy))
+			} else {
+				rout.SetValue(row, col, rin.Value(int(math.Floor(r+0.5)), int(math.Floor(c+0.5))))
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ReprojectToUTM tool (%s)", this.toolManager.toolVersion(this)))
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Estimates the value of a raster at a fractional row and column using
+// bilinear interpolation; see raster.SampleBilinear.
+func bilinearValue(r *raster.Raster, row, col float64) float64 {
+	return r.SampleBilinear(r.West+(col+0.5)*r.GetCellSizeX(), r.North-(row+0.5)*r.GetCellSizeY())
+}
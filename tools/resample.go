@@ -0,0 +1,418 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Resample changes the cell size of a raster and/or snaps it onto the grid
+// of a base raster, interpolating its values at the new cell centres.
+type Resample struct {
+	inputFile   string
+	outputFile  string
+	cellSize    float64 // zero to keep the cell size of the base raster
+	baseFile    string  // empty for no base raster
+	method      string  // empty for the default of the input's data type
+	toolManager *PluginToolManager
+}
+
+func (this *Resample) GetName() string {
+	s := "Resample"
+	return getFormattedToolName(s)
+}
+
+func (this *Resample) GetDescription() string {
+	s := "Changes the cell size of a raster or snaps it to a grid"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Resample) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Resample) GetHelpDocumentation() string {
+	ret := "This tool resamples a raster onto a new grid, e.g. so that rasters of different " +
+		"resolutions or origins can be used together by tools that require inputs with the " +
+		"same dimensions. Given only a CellSize, in map units, the output covers the extent " +
+		"of the input with cells of that size, starting at its north-west corner. Given only " +
+		"a BaseFile, the output has exactly the rows, columns, extent and coordinate system " +
+		"of the base raster. Given both, the output covers the extent of the input with " +
+		"cells of the CellSize whose edges are snapped to the grid lines of the base raster, " +
+		"i.e. lie a whole number of cells from its north-west corner, so that a raster of the " +
+		"same cell size is aligned with the base without being cropped to it. The value of " +
+		"each output cell is interpolated at its centre by the 'nearest' (nearest-neighbour), " +
+		"'bilinear' or 'cubic' (cubic convolution, which gives a smoother surface and falls " +
+		"back to bilinear interpolation near nodata cells) Method; the default is 'nearest' " +
+		"for integer (e.g. categorical) rasters and 'bilinear' otherwise. The output keeps " +
+		"the data type of the input with nearest-neighbour resampling and is floating-point " +
+		"otherwise. Output cells whose centres lie outside the input are nodata. Values are " +
+		"sampled rather than averaged, so the Aggregate tool is better suited to coarsening " +
+		"a raster by a whole factor."
+	return ret
+}
+
+func (this *Resample) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Resample) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output cell size, in map units (optional given a base raster)"
+
+	ret[3].Name = "BaseFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The optional base raster, whose grid the output is snapped to"
+	ret[3].Role = ArgInput
+
+	ret[4].Name = "Method"
+	ret[4].Type = "string"
+	ret[4].Description = "nearest, bilinear or cubic"
+	ret[4].Choices = []string{"nearest", "bilinear", "cubic"}
+
+	return ret
+}
+
+func (this *Resample) EstimateMemory(rows, columns int) int64 {
+	// the input and output rasters, which are of similar sizes unless the
+	// cell size changes greatly
+	return gridBytes(rows, columns, 2*rasterBytesPerCell)
+}
+
+func (this *Resample) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and cell size or base file must be specified.")
+		return
+	}
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+	inputFile := strings.TrimSpace(args[0])
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.cellSize = 0
+	if specified(2) {
+		if !this.setCellSize(args[2]) {
+			return
+		}
+	}
+	this.baseFile = ""
+	if specified(3) {
+		if !this.setBaseFile(args[3]) {
+			return
+		}
+	}
+	this.method = ""
+	if specified(4) {
+		if !this.setMethod(args[4]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Resample) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+	readLine := func(prompt string) string {
+		print(prompt)
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		return strings.TrimSpace(str)
+	}
+
+	// get the input file name
+	inputFile := readLine("Enter the raster file name (incl. file extension): ")
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	outputFile := readLine("Enter the output file name (incl. file extension): ")
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the cell size and the base file
+	this.cellSize = 0
+	if str := readLine("Output cell size (blank to use that of the base raster): "); str != "" {
+		if !this.setCellSize(str) {
+			return
+		}
+	}
+	this.baseFile = ""
+	if str := readLine("Enter the base raster file name (blank for none): "); str != "" {
+		if !this.setBaseFile(str) {
+			return
+		}
+	}
+
+	// get the resampling method
+	this.method = ""
+	if str := readLine("Resampling method, nearest, bilinear or cubic (blank for default): "); str != "" {
+		if !this.setMethod(str) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Resample) setCellSize(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v <= 0 {
+		println("The cell size must be greater than zero.")
+		return false
+	}
+	this.cellSize = v
+	return true
+}
+
+func (this *Resample) setBaseFile(s string) bool {
+	baseFile := strings.TrimSpace(s)
+	if !strings.Contains(baseFile, pathSep) {
+		baseFile = this.toolManager.workingDirectory + baseFile
+	}
+	if !raster.FileExists(baseFile) {
+		printf("no such file or directory: %s\n", baseFile)
+		return false
+	}
+	this.baseFile = baseFile
+	return true
+}
+
+func (this *Resample) setMethod(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "nearest", "bilinear", "cubic":
+		this.method = s
+		return true
+	case "bicubic", "spline":
+		this.method = "cubic"
+		return true
+	}
+	println("Unrecognized resampling method; use 'nearest', 'bilinear' or 'cubic'.")
+	return false
+}
+
+func (this *Resample) Run() {
+	start1 := time.Now()
+
+	if this.cellSize == 0 && this.baseFile == "" {
+		println("A cell size, a base raster, or both must be specified.")
+		return
+	}
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	method := this.method
+	isFloat := inConfig.DataType == raster.DT_FLOAT32 || inConfig.DataType == raster.DT_FLOAT64
+	if method == "" {
+		method = "bilinear"
+		if !isFloat {
+			method = "nearest"
+		}
+	}
+
+	// the output grid
+	var rows, columns int
+	var grid raster.Bounds
+	epsg, wkt := inConfig.EPSGCode, inConfig.CoordinateRefSystemWKT
+	if this.baseFile != "" {
+		base, err := raster.CreateRasterFromFile(this.baseFile, raster.RasterConfig{HeaderOnly: true})
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		if this.cellSize == 0 {
+			rows, columns, grid = base.Rows, base.Columns, base.Bounds()
+		} else {
+			rows, columns, grid = snapGrid(rin.Bounds(), this.cellSize, base.West, base.North)
+		}
+		baseConfig := base.GetRasterConfig()
+		if baseConfig.EPSGCode != 0 && epsg != 0 && baseConfig.EPSGCode != epsg {
+			printf("Warning: the base (EPSG:%v) and input (EPSG:%v) coordinate systems differ.\n", baseConfig.EPSGCode, epsg)
+		}
+		if baseConfig.EPSGCode != 0 || baseConfig.CoordinateRefSystemWKT != "" {
+			epsg, wkt = baseConfig.EPSGCode, baseConfig.CoordinateRefSystemWKT
+		}
+	} else {
+		rows, columns, grid = snapGrid(rin.Bounds(), this.cellSize, rin.West, rin.North)
+	}
+	if !grid.Overlaps(rin.Bounds()) {
+		println("The output grid does not overlap the input raster.")
+		return
+	}
+
+	start2 := time.Now()
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	if method != "nearest" && !isFloat {
+		config.DataType = raster.DT_FLOAT32
+	}
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = wkt
+	config.EPSGCode = epsg
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		grid.North, grid.South, grid.East, grid.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	cellSizeX := rout.GetCellSizeX()
+	cellSizeY := rout.GetCellSizeY()
+	inCellSizeX := rin.GetCellSizeX()
+	inCellSizeY := rin.GetCellSizeY()
+
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		y := grid.North - (float64(row)+0.5)*cellSizeY
+		inRow := (rin.North-y)/inCellSizeY - 0.5
+		for col := 0; col < columns; col++ {
+			x := grid.West + (float64(col)+0.5)*cellSizeX
+			inCol := (x-rin.West)/inCellSizeX - 0.5
+			if z := resampleValue(rin, method, inRow, inCol); z != nodata {
+				rout.SetValue(row, col, z)
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Resample tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Resampled from %s using %s interpolation", this.inputFile, method))
+	rout.Save()
+
+	printf("Output dimensions: %v rows x %v columns, cell size %v x %v\n", rows, columns, cellSizeX, cellSizeY)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// snapGrid returns the dimensions and extent of the smallest grid of square
+// cells of the given size that covers the bounds b and whose cell edges lie a
+// whole number of cells from the point originX, originY, e.g. the north-west
+// corner of another grid. Edges within a millionth of a cell of the bounds
+// are taken to coincide with them, so that rounding errors don't add a row
+// or column.
+func snapGrid(b raster.Bounds, cellSize, originX, originY float64) (rows, columns int, grid raster.Bounds) {
+	const eps = 1e-6
+	grid.West = originX + math.Floor((b.West-originX)/cellSize+eps)*cellSize
+	grid.North = originY + math.Ceil((b.North-originY)/cellSize-eps)*cellSize
+	columns = int(math.Ceil((b.East-grid.West)/cellSize - eps))
+	rows = int(math.Ceil((grid.North-b.South)/cellSize - eps))
+	if columns < 1 {
+		columns = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	grid.East = grid.West + float64(columns)*cellSize
+	grid.South = grid.North - float64(rows)*cellSize
+	return rows, columns, grid
+}
+
+// resampleValue returns the value of r at the fractional grid position (row,
+// col), by the method 'nearest', 'bilinear' or 'cubic', or nodata if the
+// position is outside the grid.
+func resampleValue(r *raster.Raster, method string, row, col float64) float64 {
+	if row < -0.5 || col < -0.5 || row > float64(r.Rows)-0.5 || col > float64(r.Columns)-0.5 {
+		return r.NoDataValue
+	}
+	switch method {
+	case "nearest":
+		return r.Value(int(math.Floor(row+0.5)), int(math.Floor(col+0.5)))
+	case "bilinear":
+		return bilinearValue(r, row, col)
+	}
+	return bicubicValue(r, row, col)
+}
@@ -0,0 +1,432 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ResolveFlats imposes a small gradient across the flats of a DEM, towards
+// their outlets and away from the higher terrain around them, so that each
+// flat cell has a downslope D8 neighbour.
+type ResolveFlats struct {
+	inputFile   string
+	outputFile  string
+	increment   float64
+	toolManager *PluginToolManager
+}
+
+func (this *ResolveFlats) GetName() string {
+	s := "ResolveFlats"
+	return getFormattedToolName(s)
+}
+
+func (this *ResolveFlats) GetDescription() string {
+	s := "Imposes a gradient across the flats of a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ResolveFlats) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ResolveFlats) GetHelpDocumentation() string {
+	ret := "This tool resolves the flats of a digital elevation model (DEM), e.g. those " +
+		"left by FillDepressions without FixFlats, or the flat bottoms of breach channels, " +
+		"on which D8 flow directions are undefined, by the method of Barnes et al. (2014), " +
+		"which combines the gradient towards lower terrain of Garbrecht and Martz (1997) " +
+		"with a gradient away from higher terrain, so that flow converges through the " +
+		"middle of a flat rather than running along its edge. A flat is a connected " +
+		"region of cells of equal elevation without a downslope neighbour; its outlets " +
+		"are the cells of the same elevation around it that do drain, including cells on " +
+		"the edge of the DEM or next to nodata, which drain off it. Each flat cell is " +
+		"raised by the Increment (default 0.0001) times 2 L + H, where L is its distance " +
+		"in cells from the nearest outlet and H is the greatest distance of any cell of " +
+		"the flat from the higher terrain, less its own, so that every flat cell has a " +
+		"lower neighbour. The increment is reduced on a flat if need be so that no cell " +
+		"rises to the lowest of the higher cells around it. Flats without an outlet, i.e. " +
+		"the bottoms of depressions, are left unchanged, so the DEM should be breached or " +
+		"filled first. The output is written with 64-bit values, which can hold the small " +
+		"increments at any elevation."
+	return ret
+}
+
+func (this *ResolveFlats) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ResolveFlats) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Increment"
+	ret[2].Type = "float64"
+	ret[2].Description = "The elevation increment of the imposed gradient"
+	ret[2].Default = "0.0001"
+
+	return ret
+}
+
+func (this *ResolveFlats) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, the flow directions, the flat labels and
+	// the distances from the outlets and the higher terrain
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+1+4+4+4)
+}
+
+func (this *ResolveFlats) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.increment = 0.0001
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		val, err := strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.increment = val
+	}
+
+	this.Run()
+}
+
+func (this *ResolveFlats) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the increment
+	this.increment = 0.0001
+	print("Elevation increment (default 0.0001): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.increment, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *ResolveFlats) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *ResolveFlats) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *ResolveFlats) Run() {
+	start1 := time.Now()
+
+	if this.increment <= 0 {
+		println("The increment must be greater than zero.")
+		return
+	}
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	z, numFlats, numClosed := resolveFlats(dem, this.increment)
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	config.DataType = raster.DT_FLOAT64
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CopyDisplaySettings(inConfig)
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			rout.SetValue(row, col, z[row*columns+col])
+		}
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ResolveFlats tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Increment: %v", this.increment))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("Flats: %v, of which %v have no outlet and were left unchanged\n", numFlats, numClosed)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// resolveFlats returns the elevations of a DEM with the gradient of Barnes et
+// al. (2014) imposed on its flats, raising each flat cell by the increment
+// times twice its distance from the outlets of the flat plus its distance
+// from the higher terrain, reversed, and the numbers of flats and of flats
+// without an outlet, which are left unchanged.
+func resolveFlats(dem *raster.Raster, increment float64) ([]float64, int, int) {
+	var progress, oldProgress int
+	var eta progressETA
+
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	cellSizeX, cellSizeY := dem.GetCellSizeX(), dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+
+	z := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z[row*columns+col] = dem.Value(row, col)
+		}
+	}
+
+	// a flat cell has no downslope neighbour and doesn't drain off the edge
+	// of the DEM or into nodata
+	isFlat := make([]bool, rows*columns)
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if z[i] == nodata || d8Direction(dem, row, col, dist) >= 0 {
+				continue
+			}
+			isFlat[i] = true
+			for n := 0; n < 8; n++ {
+				if dem.Value(row+d8DY[n], col+d8DX[n]) == nodata {
+					isFlat[i] = false
+					break
+				}
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 1 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// label the flats, connected regions of flat cells of equal elevation,
+	// and find their outlets, the draining cells of the same elevation
+	// around them, and their high edges, the flat cells beside higher ones
+	label := make([]int32, rows*columns)
+	numFlats := 0
+	lowEdges := make([]int, 0)
+	highEdges := make([]int, 0)
+	hasOutlet := []bool{false} // indexed by label, from 1
+	minRise := []float64{0}
+	queue := make([]int, 0)
+	oldProgress = -1
+	for i := range label {
+		if !isFlat[i] || label[i] != 0 {
+			continue
+		}
+		numFlats++
+		l := int32(numFlats)
+		hasOutlet = append(hasOutlet, false)
+		minRise = append(minRise, math.Inf(1))
+		label[i] = l
+		queue = append(queue[:0], i)
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			row, col := c/columns, c%columns
+			isHighEdge := false
+			for n := 0; n < 8; n++ {
+				rowN, colN := row+d8DY[n], col+d8DX[n]
+				if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns {
+					continue
+				}
+				j := rowN*columns + colN
+				switch {
+				case z[j] == nodata:
+				case z[j] > z[c]:
+					isHighEdge = true
+					minRise[l] = math.Min(minRise[l], z[j]-z[c])
+				case z[j] < z[c]:
+				case isFlat[j]:
+					if label[j] == 0 {
+						label[j] = l
+						queue = append(queue, j)
+					}
+				case label[j] != -1:
+					label[j] = -1 // an outlet, which isn't listed twice
+					hasOutlet[l] = true
+					lowEdges = append(lowEdges, j)
+				default:
+					hasOutlet[l] = true
+				}
+			}
+			if isHighEdge {
+				highEdges = append(highEdges, c)
+			}
+		}
+		progress = int(100.0 * float64(i+1) / float64(rows*columns))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 2 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// breadthFirst returns the distances in cells of the flat cells from the
+	// sources, from start, through flat cells of equal elevation, or 0 for
+	// cells that can't be reached
+	breadthFirst := func(sources []int, start int32) []int32 {
+		d := make([]int32, rows*columns)
+		queue := make([]int, 0, len(sources))
+		for _, c := range sources {
+			if isFlat[c] {
+				d[c] = start
+			}
+			queue = append(queue, c)
+		}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			row, col := c/columns, c%columns
+			for n := 0; n < 8; n++ {
+				rowN, colN := row+d8DY[n], col+d8DX[n]
+				if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns {
+					continue
+				}
+				j := rowN*columns + colN
+				if isFlat[j] && d[j] == 0 && z[j] == z[c] {
+					d[j] = d[c] + 1
+					queue = append(queue, j)
+				}
+			}
+		}
+		return d
+	}
+	towardsLower := breadthFirst(lowEdges, 0)
+	awayFromHigher := breadthFirst(highEdges, 1)
+
+	// the gradient away from the higher terrain runs from the greatest
+	// distance from it on each flat down to zero at its edge, and the
+	// gradient towards the outlets, doubled, dominates it
+	maxAway := make([]int32, numFlats+1)
+	maxMask := make([]int32, numFlats+1)
+	for i, l := range label {
+		if l > 0 && awayFromHigher[i] > maxAway[l] {
+			maxAway[l] = awayFromHigher[i]
+		}
+	}
+	mask := make([]int32, rows*columns)
+	for i, l := range label {
+		if l <= 0 || !hasOutlet[l] {
+			continue
+		}
+		mask[i] = 2 * towardsLower[i]
+		if awayFromHigher[i] > 0 {
+			mask[i] += maxAway[l] - awayFromHigher[i]
+		}
+		if mask[i] > maxMask[l] {
+			maxMask[l] = mask[i]
+		}
+	}
+	for i, l := range label {
+		if l > 0 && hasOutlet[l] {
+			// no cell rises to the lowest of the higher cells around its flat
+			inc := math.Min(increment, minRise[l]/float64(maxMask[l]+1))
+			z[i] += float64(mask[i]) * inc
+		}
+	}
+	numClosed := 0
+	for l := 1; l <= numFlats; l++ {
+		if !hasOutlet[l] {
+			numClosed++
+		}
+	}
+	return z, numFlats, numClosed
+}
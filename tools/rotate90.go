@@ -0,0 +1,251 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Rotate90 rotates the grid of a raster by 90 degrees about its north-west
+// corner, e.g. to correct a dataset delivered with its rows and columns
+// transposed.
+type Rotate90 struct {
+	inputFile   string
+	outputFile  string
+	clockwise   bool
+	toolManager *PluginToolManager
+}
+
+func (this *Rotate90) GetName() string {
+	s := "Rotate90"
+	return getFormattedToolName(s)
+}
+
+func (this *Rotate90) GetDescription() string {
+	s := "Rotates a raster by 90 degrees"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Rotate90) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Rotate90) GetHelpDocumentation() string {
+	ret := "This tool rotates the grid of a raster by 90 degrees, clockwise (the default) " +
+		"or counter-clockwise, e.g. to correct a dataset whose rows and columns were " +
+		"swapped. The rotated raster has as many rows as the input has columns, and the " +
+		"reverse, and keeps the north-west corner and the cell sizes, which are swapped " +
+		"between the x and y directions. Directional rasters, e.g. flow pointers and " +
+		"aspect, are not adjusted and should be recalculated from a rotated DEM. If no " +
+		"output file is specified, the input raster is overwritten."
+	return ret
+}
+
+func (this *Rotate90) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Rotate90) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "Clockwise"
+	ret[1].Type = "bool"
+	ret[1].Description = "Rotate clockwise rather than counter-clockwise"
+	ret[1].Default = "true"
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename (overwrites the input if not specified)"
+	ret[2].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *Rotate90) ParseArguments(args []string) {
+	if len(args) < 1 {
+		println("The input file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	this.clockwise = true
+	if len(args) > 1 && len(strings.TrimSpace(args[1])) > 0 && args[1] != "not specified" {
+		var err error
+		if this.clockwise, err = strconv.ParseBool(strings.TrimSpace(args[1])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.outputFile = this.inputFile
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		outputFile := strings.TrimSpace(args[2])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *Rotate90) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the direction
+	print("Rotate clockwise (T or F, default T)? ")
+	clockwiseStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.clockwise = true
+	if len(strings.TrimSpace(clockwiseStr)) > 0 {
+		if this.clockwise, err = strconv.ParseBool(strings.TrimSpace(clockwiseStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension; blank to overwrite input): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputFile = this.inputFile
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *Rotate90) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	data, err := rin.Data()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	// the output has a row for each input column; rotating clockwise, the
+	// first output row is the first input column read from bottom to top
+	rotated := make([]float64, len(data))
+	for row := 0; row < columns; row++ {
+		for col := 0; col < rows; col++ {
+			inRow, inCol := rows-1-col, row
+			if !this.clockwise {
+				inRow, inCol = col, columns-1-row
+			}
+			rotated[row*rows+col] = data[inRow*columns+inCol]
+		}
+	}
+
+	// create the output raster
+	config := copyRasterConfig(rin)
+	south := rin.North - float64(columns)*rin.GetCellSizeX()
+	east := rin.West + float64(rows)*rin.GetCellSizeY()
+	rout, err := raster.CreateNewRaster(this.outputFile, columns, rows,
+		rin.North, south, east, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	rout.SetData(rotated)
+
+	for _, entry := range rin.GetMetadataEntries() {
+		if len(strings.TrimSpace(entry)) > 0 {
+			rout.AddMetadataEntry(entry)
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Rotate90 tool (%s)", this.toolManager.toolVersion(this)))
+	if this.clockwise {
+		rout.AddMetadataEntry("Rotated 90 degrees clockwise")
+	} else {
+		rout.AddMetadataEntry("Rotated 90 degrees counter-clockwise")
+	}
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
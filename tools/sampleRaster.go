@@ -0,0 +1,379 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// SampleRaster draws a random sample of the valid cells of a raster, either
+// from the whole raster or from each class of a class raster, and writes their
+// coordinates and values to a CSV file.
+type SampleRaster struct {
+	inputFile   string
+	outputFile  string
+	numSamples  int
+	classFile   string
+	seed        int64
+	toolManager *PluginToolManager
+}
+
+func (this *SampleRaster) GetName() string {
+	s := "SampleRaster"
+	return getFormattedToolName(s)
+}
+
+func (this *SampleRaster) GetDescription() string {
+	s := "Writes random (or stratified) cell samples to CSV"
+	return getFormattedToolDescription(s)
+}
+
+func (this *SampleRaster) GetVersion() string {
+	return "1.0"
+}
+
+func (this *SampleRaster) GetHelpDocumentation() string {
+	ret := "This tool draws a random sample, without replacement, of the valid (non-nodata) " +
+		"cells of a raster and writes the x and y coordinates of their centres and their " +
+		"values to a CSV file, e.g. to build training and validation datasets from the " +
+		"outputs of other tools. If a ClassFile, a raster with the same dimensions as the " +
+		"input, is specified the sample is stratified: NumSamples cells are drawn from each " +
+		"class, i.e. each distinct value of the class raster, or all of the cells of classes " +
+		"with fewer cells, and the class is written as an additional column. The input raster " +
+		"may also be used as its own class raster. Samples are listed by class and then in " +
+		"row-major order. The optional Seed makes the sample reproducible."
+	return ret
+}
+
+func (this *SampleRaster) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *SampleRaster) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output CSV file name, with directory and extension"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	ret[2].Name = "NumSamples"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of samples (per class, if stratified)"
+	ret[2].Required = true
+
+	ret[3].Name = "ClassFile"
+	ret[3].Type = "string"
+	ret[3].Description = "A class raster for stratified sampling"
+	ret[3].Role = ArgInput
+
+	ret[4].Name = "Seed"
+	ret[4].Type = "int"
+	ret[4].Description = "The random seed"
+
+	return ret
+}
+
+func (this *SampleRaster) EstimateMemory(rows, columns int) int64 {
+	// the input and class rasters and the indices of the candidate cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+4)
+}
+
+func (this *SampleRaster) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and number of samples must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := strings.TrimSpace(args[1])
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+
+	if !this.setNumSamples(args[2]) {
+		return
+	}
+	this.classFile = ""
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if !this.setClassFile(args[3]) {
+			return
+		}
+	}
+	this.seed = time.Now().UnixNano()
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		var err error
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(args[4]), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *SampleRaster) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output CSV file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+
+	// get the number of samples
+	print("Number of samples (per class, if stratified): ")
+	numSamplesStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setNumSamples(numSamplesStr) {
+		return
+	}
+
+	// get the class file name
+	this.classFile = ""
+	print("Class raster for stratified sampling (blank for none): ")
+	classFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(classFile)) > 0 {
+		if !this.setClassFile(classFile) {
+			return
+		}
+	}
+
+	// get the seed
+	this.seed = time.Now().UnixNano()
+	print("Random seed (blank for none): ")
+	seedStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(seedStr)) > 0 {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(seedStr), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *SampleRaster) setNumSamples(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 1 {
+		println("The number of samples must be at least 1.")
+		return false
+	}
+	this.numSamples = v
+	return true
+}
+
+func (this *SampleRaster) setClassFile(s string) bool {
+	classFile := strings.TrimSpace(s)
+	if !strings.Contains(classFile, pathSep) {
+		classFile = this.toolManager.workingDirectory + classFile
+	}
+	if _, err := os.Stat(classFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", classFile)
+		return false
+	}
+	this.classFile = classFile
+	return true
+}
+
+func (this *SampleRaster) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+
+	stratified := this.classFile != ""
+	classes := rin
+	if stratified && this.classFile != this.inputFile {
+		if classes, err = raster.CreateRasterFromFile(this.classFile); err != nil {
+			println(err.Error())
+			return
+		}
+		if classes.Rows != rows || classes.Columns != columns {
+			println("The class raster must have the same dimensions as the input raster.")
+			return
+		}
+	}
+
+	start2 := time.Now()
+
+	// gather the candidate cells of each stratum
+	strata := make(map[float64][]int32)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if rin.Value(row, col) == nodata {
+				continue
+			}
+			class := 0.0
+			if stratified {
+				if class = classes.Value(row, col); class == classes.NoDataValue {
+					continue
+				}
+			}
+			strata[class] = append(strata[class], int32(row*columns+col))
+		}
+	}
+	classValues := make([]float64, 0, len(strata))
+	for class := range strata {
+		classValues = append(classValues, class)
+	}
+	sort.Float64s(classValues)
+
+	// draw the samples with a partial Fisher-Yates shuffle of each stratum
+	rng := rand.New(rand.NewSource(this.seed))
+	for _, class := range classValues {
+		cells := strata[class]
+		n := this.numSamples
+		if n > len(cells) {
+			n = len(cells)
+		}
+		for i := 0; i < n; i++ {
+			j := i + rng.Intn(len(cells)-i)
+			cells[i], cells[j] = cells[j], cells[i]
+		}
+		cells = cells[:n]
+		sort.Slice(cells, func(i, j int) bool { return cells[i] < cells[j] })
+		strata[class] = cells
+	}
+
+	// values are written with the precision of the raster's data type
+	bitSize := 32
+	if rin.GetRasterConfig().DataType == raster.DT_FLOAT64 {
+		bitSize = 64
+	}
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+
+	header := []string{"sample", "x", "y", "value"}
+	if stratified {
+		header = append(header, "class")
+	}
+	w, err := createTable(this.outputFile, header...)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	numWritten, numSmallClasses := 0, 0
+	for _, class := range classValues {
+		cells := strata[class]
+		if len(cells) < this.numSamples {
+			numSmallClasses++
+		}
+		for _, i := range cells {
+			row, col := int(i)/columns, int(i)%columns
+			numWritten++
+			x := rin.West + (float64(col)+0.5)*cellSizeX
+			y := rin.North - (float64(row)+0.5)*cellSizeY
+			value := strconv.FormatFloat(rin.Value(row, col), 'f', -1, bitSize)
+			if stratified {
+				w.writeRow(numWritten, x, y, value, class)
+			} else {
+				w.writeRow(numWritten, x, y, value)
+			}
+		}
+	}
+	if err = w.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	elapsed := time.Since(start2)
+	if stratified {
+		printf("Number of samples written: %v from %v classes\n", numWritten, len(classValues))
+		if numSmallClasses > 0 {
+			printf("Warning: %v classes contain fewer than %v cells and were sampled completely.\n",
+				numSmallClasses, this.numSamples)
+		}
+	} else {
+		printf("Number of samples written: %v\n", numWritten)
+		if numSmallClasses > 0 {
+			println("Warning: the raster contains fewer valid cells than the number of samples.")
+		}
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,616 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// selfTestCase describes a tool run on the synthetic self-test data and the
+// expected checksums of its outputs. File names are relative to the self-test
+// directory.
+type selfTestCase struct {
+	tool      string
+	args      []string
+	outputs   []string
+	checksums []string
+}
+
+// When a tool's output legitimately changes, the checksums below can be
+// updated from the values reported by the failing self-test.
+var selfTestCases = []selfTestCase{
+	{"AccuracyAssessment", []string{"walls.tif", "classes.txt", "accuracy.csv"},
+		[]string{"accuracy.csv"}, []string{"c172636760cd6450"}},
+	{"Aggregate", []string{"dem.tif", "aggregated.tif", "5", "stdev"},
+		[]string{"aggregated.tif"}, []string{"98a38a43a16c22d4"}},
+	{"AlignRasters", []string{"shifted.tif", "dem.tif", "aligned.tif"},
+		[]string{"aligned.tif"}, []string{"f73925d16fcad514"}},
+	{"AnisotropicDeviation", []string{"dem.tif", "amag.tif", "ascale.tif", "aorient.tif", "2", "10", "4", "3", "4"},
+		[]string{"amag.tif", "ascale.tif", "aorient.tif"}, []string{"87908c3fcb48acf6", "f6d35c191a70cb4c", "75240f562dc7b4fe"}},
+	{"Aspect", []string{"dem.tif", "aspect.tif"},
+		[]string{"aspect.tif"}, []string{"ac44e74ee096a3bb"}},
+	{"Aspect", []string{"geo.tif", "geoaspect.tif", "evans"},
+		[]string{"geoaspect.tif"}, []string{"107bfe1b2f83499e"}},
+	{"AssignCRS", []string{"dem.tif", "4326", "crs.tif"},
+		[]string{"crs.tif"}, []string{"fc206de8f78c2a41"}},
+	{"BatchTiles", []string{"tiles.csv", "Slope", "{tile} {name}_slope.tif"},
+		[]string{"tile_0_0_slope.tif", "tile_1_1_slope.tif"}, []string{"0d911d66eac4483e", "ff75a46d70b34950"}},
+	{"BatchTiles", []string{"tiles.csv", "Slope", "{tile} {name}_bslope.tif", "2"},
+		[]string{"tile_0_1_bslope.tif"}, []string{"fd5e7b4302c787fc"}},
+	{"BatchTiles", []string{"tiles.csv", "Slope", "{tile} {index}_{tool}.tif"},
+		[]string{"1_Slope.tif", "4_Slope.tif"}, []string{"0d911d66eac4483e", "ff75a46d70b34950"}},
+	{"BreachDepressions", []string{"dem.tif", "breached.tif", "-1", "-1", "false", "false"},
+		[]string{"breached.tif"}, []string{"775a0bddb802526a"}},
+	{"BreachDepressions", []string{"dem.tif", "hybrid.tif", "0.01", "1", "false", "true", "", "", "modified.tif"},
+		[]string{"hybrid.tif", "modified.tif"}, []string{"657a8a01739f4660", "6bcaee21a1941553"}},
+	{"BurnWalls", []string{"dem.tif", "walls.tif", "walled.tif", "5.0"},
+		[]string{"walled.tif"}, []string{"7ef5508c67e9c5cd"}},
+	{"CoRegister", []string{"dem.tif", "dem2.tif", "coreg.tif", "nuth", "true"},
+		[]string{"coreg.tif"}, []string{"87ebe173f636014d"}},
+	{"CoastalInundation", []string{"dem.tif", "edge", "101", "depth.tif", "extent.tif"},
+		[]string{"depth.tif", "extent.tif"}, []string{"8a4d3225b6c2adb5", "514e51e3cec99063"}},
+	{"ConvertPointer", []string{"pointer.tif", "esri.tif", "whitebox", "esri"},
+		[]string{"esri.tif"}, []string{"aa263364cae55462"}},
+	{"Curvature", []string{"dem.tif", "profc.tif"},
+		[]string{"profc.tif"}, []string{"f618c612562ba56e"}},
+	{"Curvature", []string{"dem.tif", "planc.tif", "plan"},
+		[]string{"planc.tif"}, []string{"6bfd0fc6ada14d13"}},
+	{"Curvature", []string{"dem.tif", "tanc.tif", "tangential"},
+		[]string{"tanc.tif"}, []string{"1dc69c087221bb9e"}},
+	{"D8FlowAccumulation", []string{"dem.tif", "d8.tif", "false"},
+		[]string{"d8.tif"}, []string{"82baaa59d75c909f"}},
+	{"D8FlowAccumulation", []string{"dem.tif", "d8log.tif", "true", "d8", "", "", "ln1p"},
+		[]string{"d8log.tif"}, []string{"ef897a15fafde5e2"}},
+	{"D8FlowAccumulation", []string{"dem.tif", "d8b.tif", "false", "d8", "", "", "", "d8p.tif", "esri"},
+		[]string{"d8b.tif", "d8p.tif"}, []string{"82baaa59d75c909f", "94f5383e37c367aa"}},
+	{"D8Pointer", []string{"dem.tif", "d8pointer.tif", "esri"},
+		[]string{"d8pointer.tif"}, []string{"94f5383e37c367aa"}},
+	{"D8FlowAccumulation", []string{"dem.tif", "d8melt.tif", "false", "d8", "", "", "", "", "", "melt.txt"},
+		[]string{"d8melt.tif"}, []string{"96652e94f51930a3"}},
+	{"D8FlowAccumulation", []string{"d8pointer.tif", "d8fromp.tif", "false", "", "", "esri"},
+		[]string{"d8fromp.tif"}, []string{"82baaa59d75c909f"}},
+	{"DEMQualityReport", []string{"holes.tif", "quality.tif", "quality.csv", "2"},
+		[]string{"quality.tif", "quality.csv"}, []string{"80f5be6fd58773a6", "525f0702646dc3e6"}},
+	{"Despike", []string{"geo.tif", "despiked.tif", "5", "3"},
+		[]string{"despiked.tif"}, []string{"f64b9b80f2e124a9"}},
+	{"Destripe", []string{"dem.tif", "destriped.tif", "rows", "fft", "11"},
+		[]string{"destriped.tif"}, []string{"2110cbf0d9fe84af"}},
+	{"Destripe", []string{"dem.tif", "destriped2.tif", "columns", "median", "7"},
+		[]string{"destriped2.tif"}, []string{"2f787e4082bdd8b1"}},
+	{"DeviationFromMean", []string{"dem.tif", "dev.tif", "5"},
+		[]string{"dev.tif"}, []string{"fc6323b7e110f5a5"}},
+	{"DifferenceFromMean", []string{"dem.tif", "diff.tif", "5"},
+		[]string{"diff.tif"}, []string{"7add0009c0956e9e"}},
+	{"Disaggregate", []string{"dem.tif", "disaggregated.tif", "2", "spline"},
+		[]string{"disaggregated.tif"}, []string{"0f5ff9e95bd27018"}},
+	{"DoD", []string{"dem2.tif", "dem.tif", "dod.tif", "0.1"},
+		[]string{"dod.tif"}, []string{"de51b32493861697"}},
+	{"EditDEM", []string{"dem.tif", "ditch.tif", "gradient", "ditch.shp", "0.01"},
+		[]string{"ditch.tif"}, []string{"86163d343a807fc9"}},
+	{"EditDEM", []string{"dem.tif", "burned.tif", "breakline", "breaks.shp", ""},
+		[]string{"burned.tif"}, []string{"4ddcd8822a876e63"}},
+	{"ElevationPercentile", []string{"dem.tif", "ep.tif", "5", "100"},
+		[]string{"ep.tif"}, []string{"64c0dac0749f47d6"}},
+	{"ExtractStreams", []string{"d8.tif", "streams.tif", "20"},
+		[]string{"streams.tif"}, []string{"437a4783f22a98ce"}},
+	{"ExtractStreams", []string{"d8.tif", "widestreams.tif", "20", "false"},
+		[]string{"widestreams.tif"}, []string{"6c0e771a804c43fb"}},
+	{"BurnStreams", []string{"dem.tif", "streams.tif", "streamburned.tif", "5", "30"},
+		[]string{"streamburned.tif"}, []string{"c06dc23e84f25b26"}},
+	{"FD8FlowAccum", []string{"dem.tif", "fd8.tif", "false", "false"},
+		[]string{"fd8.tif"}, []string{"859531b630833a89"}},
+	{"FD8FlowAccum", []string{"dem.tif", "fd8log.tif", "true", "false", "ln1p"},
+		[]string{"fd8log.tif"}, []string{"e59bbb77de5b6a5a"}},
+	{"FD8FlowAccum", []string{"dem.tif", "fd8melt.tif", "false", "false", "", "melt.txt"},
+		[]string{"fd8melt.tif"}, []string{"37dd5bcc416836c2"}},
+	{"FillDepressions", []string{"dem.tif", "filled.tif", "true"},
+		[]string{"filled.tif"}, []string{"e54dc22a250f53c9"}},
+	{"FillDepressions", []string{"dem.tif", "flatfilled.tif", "false"},
+		[]string{"flatfilled.tif"}, []string{"e54dc22a250f53c9"}},
+	{"FillSmallNodataHoles", []string{"holes.tif", "noholes.tif"},
+		[]string{"noholes.tif"}, []string{"ecde29c46c3468fd"}},
+	{"FlattenLakes", []string{"dem.tif", "lake.shp", "flattened.tif", "0.01"},
+		[]string{"flattened.tif"}, []string{"03ac78f5064a1e18"}},
+	{"FlipRaster", []string{"dem.tif", "both", "flipped.tif"},
+		[]string{"flipped.tif"}, []string{"04f77d7dd57a93f1"}},
+	{"FloodFill", []string{"dem.tif", "points.txt", "flooded.tif", "below", "101.5"},
+		[]string{"flooded.tif"}, []string{"a6658b244800b962"}},
+	{"FlowpathSmoothing", []string{"geo.tif", "fpsmooth.tif", "3", "2"},
+		[]string{"fpsmooth.tif"}, []string{"9375a739e64c6723"}},
+	{"FractalDimension", []string{"dem.tif", "fractal.tif", "5", "4"},
+		[]string{"fractal.tif"}, []string{"1b8d12a89747f9c7"}},
+	{"FrontTravelTime", []string{"dem.tif", "points.txt", "travel.tif", "2.0", "0.05", "true"},
+		[]string{"travel.tif"}, []string{"13c9a45921182800"}},
+	{"GaussianRandomField", []string{"dem.tif", "grf.tif", "exponential", "20", "0.5", "0.01", "points.xyz", "true", "42"},
+		[]string{"grf.tif"}, []string{"1dd936427ebfa41a"}},
+	{"Hillshade", []string{"dem.tif", "hillshade.tif"},
+		[]string{"hillshade.tif"}, []string{"c25d9a703062d62e"}},
+	{"Hillshade", []string{"geo.tif", "mdhillshade.tif", "", "45", "2", "true"},
+		[]string{"mdhillshade.tif"}, []string{"db42844f2771c5aa"}},
+	{"Isochrones", []string{"dem.tif", "isochrones.tif", "0.5", "0.01", "", "streams.tif", "600", "isozones.tif"},
+		[]string{"isochrones.tif", "isozones.tif"}, []string{"7210c48270302b27", "319497217cae1fda"}},
+	{"KMeans", []string{"layers.txt", "kmeans.tif", "4", "50", "true", "42"},
+		[]string{"kmeans.tif"}, []string{"48222b1003a3c52d"}},
+	{"Knickpoints", []string{"dem.tif", "streams.tif", "d8.tif", "knicks.geojson", "4", "0.45", "1.5", "ksn.tif"},
+		[]string{"knicks.geojson", "ksn.tif"}, []string{"b20941cd5e5986a7", "765154ed9e6d4f2c"}},
+	{"Kriging", []string{"points.xyz", "kriged.tif", "2", "exponential", "", "", "0.1", "4", "krigvar.tif"},
+		[]string{"kriged.tif", "krigvar.tif"}, []string{"5d1b8e7868fadcfe", "4a6eab79e33629de"}},
+	{"LidarToDEM", []string{"points.las", "lidartin.tif", "10", "tin", "", ""},
+		[]string{"lidartin.tif"}, []string{"edcebc7588899215"}},
+	{"LidarToDEM", []string{"points.las", "lidaridw.tif", "10", "idw", "25", ""},
+		[]string{"lidaridw.tif"}, []string{"0bebaf8ce4b58896"}},
+	{"LineThinning", []string{"widestreams.tif", "thinned.tif"},
+		[]string{"thinned.tif"}, []string{"437a4783f22a98ce"}},
+	{"MaxElevationDeviation", []string{"dem.tif", "mag.tif", "scale.tif", "2", "10", "2"},
+		[]string{"mag.tif", "scale.tif"}, []string{"171c93aa437b1037", "7f6dbe7464275052"}},
+	{"MaxElevationDeviation", []string{"dem.tif", "medpages.tif", "medpages.tif", "2", "10", "2"},
+		[]string{"medpages.tif"}, []string{"171c93aa437b1037"}},
+	{"MeanFilter", []string{"dem.tif", "mean.tif", "5", "5"},
+		[]string{"mean.tif"}, []string{"a366771943ed3236"}},
+	{"MrRTF", []string{"dem.tif", "mrrtf.tif", "16", "3"},
+		[]string{"mrrtf.tif"}, []string{"08bee8c6f9dba4de"}},
+	{"MrVBF", []string{"dem.tif", "mrvbf.tif"},
+		[]string{"mrvbf.tif"}, []string{"b3edc766b69421cf"}},
+	{"MultiscaleSignature", []string{"dem.tif", "points.txt", "signature.csv", "2", "12", "5"},
+		[]string{"signature.csv"}, []string{"9ca85d8c149f07a1"}},
+	{"NodataMargin", []string{"holes.tif", "shrunk.tif", "shrink", "2", "true"},
+		[]string{"shrunk.tif"}, []string{"9289988bc4f7f43d"}},
+	{"NodataMargin", []string{"shrunk.tif", "expanded.tif", "expand", "2"},
+		[]string{"expanded.tif"}, []string{"53c3e5e4cca36084"}},
+	{"PCA", []string{"layers.txt", "pca.tif", "2", "true"},
+		[]string{"pca_PC1.tif", "pca_PC2.tif", "pca_loadings.csv"}, []string{"6bad8849f86d0047", "1f88887ea780942d", "4d8cb4790675c9b1"}},
+	{"PennockLandformClass", []string{"dem.tif", "pennock.tif", "1", "0.5", "0.0", "pennock.csv"},
+		[]string{"pennock.tif", "pennock.csv"}, []string{"978053d8b2fef959", "63f0a5de158dd044"}},
+	{"PrintGeoTiffTags", []string{"dem.tif"},
+		nil, nil},
+	{"Quantiles", []string{"dem.tif", "quantiles.tif", "10"},
+		[]string{"quantiles.tif"}, []string{"cb2ac61e552dfc00"}},
+	{"ReadXYZ", []string{"points.xyz", "gridded.tif", "10", "mean", "32617"},
+		[]string{"gridded.tif"}, []string{"2d9f52f49cdcb65a"}},
+	{"RasterFootprint", []string{"dem.tif", "footprint.geojson", "200"},
+		[]string{"footprint.geojson", "footprint_graticule.geojson"}, []string{"058d15a9ae96a68d", "7add1e70946fca3a"}},
+	{"RasterStreamsToVector", []string{"streams.tif", "d8.tif", "streams.geojson"},
+		[]string{"streams.geojson"}, []string{"93236c8914bc22a7"}},
+	{"CatchmentAttributes", []string{"dem.tif", "streams.tif", "d8.tif", "catchattr.geojson", "catchments.tif"},
+		[]string{"catchattr.geojson", "catchments.tif"}, []string{"681ba830f24e564a", "0292b33e2a8aac11"}},
+	{"Reclass", []string{"dem.tif", "reclass.tif", "1 0 100 2 100 110 nodata 110 120", "", "4"},
+		[]string{"reclass.tif"}, []string{"302619a601defb01"}},
+	{"Reclass", []string{"isozones.tif", "zoneclass.tif", "", "zones.txt"},
+		[]string{"zoneclass.tif"}, []string{"b0a83af1299a6cf6"}},
+	{"Resample", []string{"dem.tif", "resampled.tif", "15", "", "cubic"},
+		[]string{"resampled.tif"}, []string{"46d18ff9f2994b5c"}},
+	{"Resample", []string{"shifted.tif", "snapped.tif", "10", "dem.tif"},
+		[]string{"snapped.tif"}, []string{"2ca0ddec41a279cc"}},
+	{"ReprojectToUTM", []string{"geo.tif", "utm.tif", "100", "bilinear"},
+		[]string{"utm.tif"}, []string{"be1a33a08c5c85c6"}},
+	{"ResolveFlats", []string{"flatfilled.tif", "resolved.tif", "0.01"},
+		[]string{"resolved.tif"}, []string{"517d2121b6cc8e09"}},
+	{"Rotate90", []string{"dem.tif", "false", "rotated.tif"},
+		[]string{"rotated.tif"}, []string{"e25b3b7b9a819c5f"}},
+	{"SampleRaster", []string{"dem.tif", "samples.csv", "5", "walls.tif", "42"},
+		[]string{"samples.csv"}, []string{"f210aef34cec92ea"}},
+	{"Semivariogram", []string{"dem.tif", "variogram.csv", "8", "", "4", "2"},
+		[]string{"variogram.csv"}, []string{"5210a7ce733bd081"}},
+	{"ShiftRaster", []string{"dem.tif", "5", "-5", "moved.tif"},
+		[]string{"moved.tif"}, []string{"832c90f075a0254b"}},
+	{"ShiftRaster", []string{"dem.tif", "0", "0", "dem.asc"},
+		[]string{"dem.asc"}, []string{"6de97293d629b8b8"}},
+	{"Slope", []string{"dem.tif", "slope.tif"},
+		[]string{"slope.tif"}, []string{"69247919bff374e6"}},
+	{"Slope", []string{"dem.tif", "eslope.tif", "evans", "percent"},
+		[]string{"eslope.tif"}, []string{"78a0395abaa608a0"}},
+	{"Slope", []string{"geo.tif", "geoslope.tif"},
+		[]string{"geoslope.tif"}, []string{"849c141f12aee60f"}},
+	{"StackStatistics", []string{"stack.txt", "trend.tif", "trend"},
+		[]string{"trend.tif"}, []string{"c7db27d179c14646"}},
+	{"StochasticDepressionAnalysis", []string{"dem.tif", "pdep.tif", "0.5", "50", "10", "exponential", "42"},
+		[]string{"pdep.tif"}, []string{"c5313f9491dd2de4"}},
+	{"SurfaceAreaRatio", []string{"dem.tif", "sar.tif"},
+		[]string{"sar.tif"}, []string{"440db447bf1e022a"}},
+	{"TINGridding", []string{"points.xyz", "tin.tif", "10", "breaks.shp", "", "32617"},
+		[]string{"tin.tif"}, []string{"a0e024e4eb918883"}},
+	{"TileIndex", []string{"tiles", "tileindex.csv"},
+		[]string{"tileindex.csv"}, []string{"4fb9b8d1ca3ea6df"}},
+	{"TimeAreaDiagram", []string{"dem.tif", "timearea.csv", "500235", "4819945", "60", "0.5"},
+		[]string{"timearea.csv"}, []string{"3e712fbea790bb41"}},
+	{"TraceDownslope", []string{"dem.tif", "points.txt", "paths.geojson", "", "walls.tif"},
+		[]string{"paths.geojson", "paths.csv"}, []string{"fdcf1405c57e0c8d", "27c6cdb6c340febe"}},
+	{"TransformRaster", []string{"dem.tif", "zscore.tif", "zscore"},
+		[]string{"zscore.tif"}, []string{"b76ea829bfda5d3c"}},
+	{"UpdateFlowAccum", []string{"dem.tif", "pointer.tif", "d8.tif", "walls.tif", "updated.tif"},
+		[]string{"updated.tif"}, []string{"3bf0aeba84e83704"}},
+	{"UpslopeArea", []string{"pointer.tif", "points.txt", "upslope.tif"},
+		[]string{"upslope.tif"}, []string{"2f3d812d6df5db61"}},
+	{"Whitebox2GeoTiff", []string{"dem.dep", "fromwb.tif"},
+		[]string{"fromwb.tif"}, []string{"832c90f075a0254b"}},
+	{"WriteXYZ", []string{"dem.tif", "dem.csv", "4"},
+		[]string{"dem.csv"}, []string{"73eb990984140080"}},
+}
+
+// SelfTest runs each registered tool that has a self-test case on small
+// synthetic datasets and compares checksums of the outputs with the expected
+// values. It returns the number of failed tests.
+func (ptm *PluginToolManager) SelfTest() int {
+	dir, err := ioutil.TempDir("", "gospatial-selftest")
+	if err != nil {
+		println(err.Error())
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if err = createSelfTestData(dir); err != nil {
+		printf("Unable to create the self-test data: %s\n", err.Error())
+		return 1
+	}
+
+	// the tests must run with the default modes
+	workingDirectory, benchMode, taudemMode := ptm.workingDirectory, ptm.BenchMode, ptm.TauDEMMode
+	ptm.SetWorkingDirectory(dir)
+	ptm.BenchMode, ptm.TauDEMMode = false, false
+	defer func() {
+		ptm.workingDirectory, ptm.BenchMode, ptm.TauDEMMode = workingDirectory, benchMode, taudemMode
+	}()
+
+	results := make([]string, 0)
+	numFailed := 0
+	tested := make(map[string]bool)
+	for _, tc := range selfTestCases {
+		tested[strings.ToLower(getFormattedToolName(tc.tool))] = true
+		if err := ptm.runSelfTestCase(dir, tc); err != nil {
+			results = append(results, fmt.Sprintf("FAILED  %s: %s", tc.tool, err.Error()))
+			numFailed++
+		} else {
+			results = append(results, fmt.Sprintf("passed  %s", tc.tool))
+		}
+	}
+	untested := make([]string, 0)
+	for name, tool := range ptm.mapOfPluginTools {
+		if !tested[name] {
+			untested = append(untested, strings.TrimSpace(tool.GetName()))
+		}
+	}
+	sort.Strings(untested)
+
+	println("\nSelf-test results:")
+	for _, s := range results {
+		println(s)
+	}
+	for _, s := range untested {
+		printf("skipped %s: no self-test data\n", s)
+	}
+	printf("%v of %v tests passed\n", len(selfTestCases)-numFailed, len(selfTestCases))
+	return numFailed
+}
+
+func (ptm *PluginToolManager) runSelfTestCase(dir string, tc selfTestCase) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err = ptm.RunWithArguments(tc.tool, tc.args); err != nil {
+		return err
+	}
+	for i, output := range tc.outputs {
+		checksum, err := outputChecksum(filepath.Join(dir, output))
+		if err != nil {
+			return fmt.Errorf("%s could not be read", output)
+		}
+		if checksum != tc.checksums[i] {
+			return fmt.Errorf("checksum of %s is %s, expected %s", output, checksum, tc.checksums[i])
+		}
+	}
+	return nil
+}
+
+// outputChecksum returns the checksum of a raster or, for other outputs such
+// as CSV files, of the file contents.
+func outputChecksum(fileName string) (string, error) {
+	if rt, err := raster.DetermineRasterFormat(fileName); err == nil && rt != raster.RT_UnknownRaster {
+		return rasterChecksum(fileName)
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
+
+// rasterChecksum returns a hash of the dimensions, EPSG code and cell values
+// of a raster. Values are rounded so that the checksum is insensitive to the
+// last bits of floating point results, and metadata, such as timestamps, is
+// not included.
+func rasterChecksum(fileName string) (string, error) {
+	r, err := raster.CreateRasterFromFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v %v %v|", r.Rows, r.Columns, r.GetRasterConfig().EPSGCode)
+	nodata := r.NoDataValue
+	for row := 0; row < r.Rows; row++ {
+		for col := 0; col < r.Columns; col++ {
+			z := r.Value(row, col)
+			if z == nodata || math.IsNaN(z) {
+				h.Write([]byte("n|"))
+			} else {
+				fmt.Fprintf(h, "%.3f|", z)
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
+
+// createSelfTestData writes the synthetic rasters used by the self-tests: a
+// 64 x 64 UTM DEM of sinusoidal hills with a pit, a shifted and raised copy of
+// it, a geographic DEM, a wall raster, a DEM with nodata holes, a D8 pointer,
+// a Whitebox copy of the DEM, a copy of the DEM offset by fractions of a
+// cell, two stack list files, a ditch polyline, breakline and lake polygon
+// shapefiles,
+// sample and reference class point files, an x,y,z point file and four tiles
+// of the DEM with their tile index.
+func createSelfTestData(dir string) error {
+	const rows, columns = 64, 64
+	surface := func(x, y float64) float64 {
+		z := 100.0 + 0.05*y + 4.0*math.Sin(x/80.0)*math.Cos(y/100.0)
+		if d := math.Hypot(x-320.0, y-320.0); d < 40.0 {
+			z -= 3.0 * (1.0 - d/40.0) // a pit in the centre
+		}
+		return z
+	}
+
+	type dataset struct {
+		fileName string
+		epsg     int
+		north    float64
+		west     float64
+		cellSize float64
+		dataType int
+		value    func(row, col int) float64
+	}
+	const nodata = -32768.0
+	datasets := []dataset{
+		{"dem.tif", 32617, 4820000.0, 500000.0, 10.0, raster.DT_FLOAT32, func(row, col int) float64 {
+			return surface(float64(col)*10.0, float64(row)*10.0)
+		}},
+		{"dem2.tif", 32617, 4820000.0, 500000.0, 10.0, raster.DT_FLOAT32, func(row, col int) float64 {
+			return surface(float64(col)*10.0-15.0, float64(row)*10.0+5.0) + 0.5
+		}},
+		{"geo.tif", 4326, 43.6, -80.3, 0.001, raster.DT_FLOAT32, func(row, col int) float64 {
+			return surface(float64(col)*80.0, float64(row)*110.0)
+		}},
+		{"walls.tif", 32617, 4820000.0, 500000.0, 10.0, raster.DT_INT16, func(row, col int) float64 {
+			if row == 40 || row-col == 10 {
+				return 1
+			}
+			return 0
+		}},
+		{"holes.tif", 32617, 4820000.0, 500000.0, 10.0, raster.DT_FLOAT32, func(row, col int) float64 {
+			if (row%16 == 5 && col%16 == 7) || (row == 20 && col >= 30 && col < 32) {
+				return nodata
+			}
+			return surface(float64(col)*10.0, float64(row)*10.0)
+		}},
+		{"pointer.tif", 32617, 4820000.0, 500000.0, 10.0, raster.DT_INT16, func(row, col int) float64 {
+			// whitebox: flow east above the diagonal, south below it
+			if row == rows-1 {
+				return 0
+			}
+			if col > row {
+				return 2
+			}
+			return 8
+		}},
+		{"dem.dep", 32617, 4820000.0, 500000.0, 10.0, raster.DT_FLOAT32, func(row, col int) float64 {
+			return surface(float64(col)*10.0, float64(row)*10.0)
+		}},
+		{"shifted.tif", 32617, 4820025.0, 499965.0, 10.0, raster.DT_FLOAT32, func(row, col int) float64 {
+			return surface(float64(col)*10.0-35.0, float64(row)*10.0-25.0)
+		}},
+	}
+
+	for _, ds := range datasets {
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = ds.dataType
+		config.NoDataValue = nodata
+		config.InitialValue = nodata
+		config.EPSGCode = ds.epsg
+		south := ds.north - float64(rows)*ds.cellSize
+		east := ds.west + float64(columns)*ds.cellSize
+		r, err := raster.CreateNewRaster(filepath.Join(dir, ds.fileName), rows, columns,
+			ds.north, south, east, ds.west, config)
+		if err != nil {
+			return err
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				r.SetValue(row, col, ds.value(row, col))
+			}
+		}
+		r.Save()
+	}
+
+	textFiles := map[string]string{
+		"stack.txt":   "dem.tif 2010\ndem2.tif 2015\n",
+		"layers.txt":  "dem.tif\ndem2.tif\nwalls.tif\n",
+		"classes.txt": "x y class\n500055 4819595 1\n500205 4819695 1\n500105 4819895 0\n500305 4819695 1\n",
+		"points.xyz":  "x,y,z\n500005,4819995,100\n500015,4819995,101\n500012,4819991,103\n500005,4819985,102\n500025,4819975,103.5\n",
+		"points.txt":  "x y name\n500325 4819675 pit\n500105 4819905 hill\n500005 4819365 edge\n",
+		"melt.txt":    "aspect weight\n0 1\n90 0.5\n180 0.1\n270 0.5\n",
+		"zones.txt":   "# new from to\n1 1 3\n2 3 5\nnodata 5 100\n0 nodata nodata\n",
+	}
+	for name, contents := range textFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			return fmt.Errorf("unable to write %s", name)
+		}
+	}
+
+	lineZ := func(vertices ...[3]float64) []vector.Point {
+		line := make([]vector.Point, len(vertices))
+		for i, v := range vertices {
+			line[i] = vector.Point{X: v[0], Y: v[1], Z: v[2], M: vector.NoMeasure}
+		}
+		return line
+	}
+	shapeFiles := []struct {
+		fileName  string
+		shapeType vector.ShapeType
+		shapes    []vector.Shape
+	}{
+		{"ditch.shp", vector.ST_PolyLine, []vector.Shape{
+			vector.NewPolyLine([][2]float64{{500005, 4819995}, {500315, 4819700}, {500635, 4819680}}),
+		}},
+		{"breaks.shp", vector.ST_PolyLineZ, []vector.Shape{
+			{Parts: [][]vector.Point{lineZ([3]float64{500005, 4819995, 100}, [3]float64{500315, 4819700, 90},
+				[3]float64{500635, 4819680, 80})}},
+			{Parts: [][]vector.Point{lineZ([3]float64{500100, 4819400, 95}, [3]float64{500600, 4819400, 95})}},
+		}},
+		{"lake.shp", vector.ST_Polygon, []vector.Shape{
+			vector.NewPolygon([][2]float64{{500280, 4819720}, {500360, 4819720}, {500360, 4819640}, {500280, 4819640}}),
+		}},
+	}
+	for _, sf := range shapeFiles {
+		shp, err := vector.CreateNewShapefile(filepath.Join(dir, sf.fileName), sf.shapeType, nil)
+		if err != nil {
+			return err
+		}
+		shp.EPSGCode = 32617
+		for _, shape := range sf.shapes {
+			if err = shp.AddShape(shape); err != nil {
+				return err
+			}
+		}
+		if err = shp.Save(); err != nil {
+			return err
+		}
+	}
+
+	// the DEM split into four tiles, with a tile index
+	const tileSize = 32
+	tileDir := filepath.Join(dir, "tiles")
+	if err := os.Mkdir(tileDir, 0755); err != nil {
+		return err
+	}
+	tiles := make([]tileRecord, 0)
+	for i := 0; i < rows/tileSize; i++ {
+		for j := 0; j < columns/tileSize; j++ {
+			t := tileRecord{
+				fileName:  filepath.Join(tileDir, fmt.Sprintf("tile_%v_%v.tif", i, j)),
+				rows:      tileSize,
+				columns:   tileSize,
+				north:     4820000.0 - float64(i*tileSize)*10.0,
+				west:      500000.0 + float64(j*tileSize)*10.0,
+				cellSizeX: 10.0,
+				cellSizeY: 10.0,
+				epsg:      32617,
+			}
+			t.south = t.north - tileSize*10.0
+			t.east = t.west + tileSize*10.0
+			config := raster.NewDefaultRasterConfig()
+			config.DataType = raster.DT_FLOAT32
+			config.NoDataValue = nodata
+			config.InitialValue = nodata
+			config.EPSGCode = t.epsg
+			r, err := raster.CreateNewRaster(t.fileName, t.rows, t.columns, t.north, t.south, t.east, t.west, config)
+			if err != nil {
+				return err
+			}
+			for row := 0; row < tileSize; row++ {
+				for col := 0; col < tileSize; col++ {
+					r.SetValue(row, col, surface(float64(j*tileSize+col)*10.0, float64(i*tileSize+row)*10.0))
+				}
+			}
+			r.Save()
+			tiles = append(tiles, t)
+		}
+	}
+	if err := writeTileIndex(filepath.Join(dir, "tiles.csv"), tiles); err != nil {
+		return err
+	}
+
+	return writeSelfTestLAS(filepath.Join(dir, "points.las"), surface)
+}
+
+// writeSelfTestLAS writes a LAS 1.2 file of ground returns on the surface,
+// on a jittered 20 m grid, interspersed with vegetation 8 m above it and a
+// low noise point, in UTM zone 17N.
+func writeSelfTestLAS(fileName string, surface func(x, y float64) float64) error {
+	type point struct {
+		x, y, z        float64
+		returns, class byte
+	}
+	points := make([]point, 0)
+	for i := 0; i < 32; i++ {
+		for j := 0; j < 32; j++ {
+			x := 10.0 + float64(j)*20.0 + float64((i*7+j*3)%5)
+			y := 10.0 + float64(i)*20.0 + float64((i*3+j*5)%7)
+			z := surface(x, y)
+			if (i+j)%4 == 0 {
+				// a first return from vegetation above the ground
+				points = append(points, point{500000.0 + x, 4820000.0 - y, z + 8.0, 1 | 2<<3, 5})
+				points = append(points, point{500000.0 + x, 4820000.0 - y, z, 2 | 2<<3, 2})
+				continue
+			}
+			points = append(points, point{500000.0 + x, 4820000.0 - y, z, 1 | 1<<3, 2})
+		}
+	}
+	points = append(points, point{500325.0, 4819675.0, 50.0, 1 | 1<<3, 7})
+
+	// a GeoTIFF key directory with the projected coordinate reference system
+	geoKeys := []uint16{1, 1, 0, 1, 3072, 0, 1, 32617}
+	const headerSize, vlrSize, recordLength = 227, 54 + 16, 28
+	b := make([]byte, headerSize+vlrSize+len(points)*recordLength)
+	le := binary.LittleEndian
+	copy(b[0:4], "LASF")
+	b[24], b[25] = 1, 2
+	copy(b[26:58], "GoSpatial self-test")
+	le.PutUint16(b[94:96], headerSize)
+	le.PutUint32(b[96:100], headerSize+vlrSize)
+	le.PutUint32(b[100:104], 1)
+	b[104] = 1
+	le.PutUint16(b[105:107], recordLength)
+	le.PutUint32(b[107:111], uint32(len(points)))
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	minZ, maxZ := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		minZ, maxZ = math.Min(minZ, p.z), math.Max(maxZ, p.z)
+	}
+	for i, v := range []float64{0.01, 0.01, 0.01, 500000.0, 4819000.0, 0.0,
+		maxX, minX, maxY, minY, maxZ, minZ} {
+		le.PutUint64(b[131+8*i:], math.Float64bits(v))
+	}
+	vlr := b[headerSize : headerSize+vlrSize]
+	copy(vlr[2:18], "LASF_Projection")
+	le.PutUint16(vlr[18:20], 34735)
+	le.PutUint16(vlr[20:22], 16)
+	for i, v := range geoKeys {
+		le.PutUint16(vlr[54+2*i:], v)
+	}
+	for i, p := range points {
+		rec := b[headerSize+vlrSize+i*recordLength:]
+		le.PutUint32(rec[0:4], uint32(int32(math.Round((p.x-500000.0)/0.01))))
+		le.PutUint32(rec[4:8], uint32(int32(math.Round((p.y-4819000.0)/0.01))))
+		le.PutUint32(rec[8:12], uint32(int32(math.Round(p.z/0.01))))
+		rec[14] = p.returns
+		rec[15] = p.class
+	}
+	return ioutil.WriteFile(fileName, b, 0644)
+}
@@ -0,0 +1,451 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// Semivariogram estimates the experimental semivariogram of a DEM, i.e. half
+// the mean squared difference in elevation between cells as a function of
+// their separation, overall or by direction.
+type Semivariogram struct {
+	inputFile     string
+	outputFile    string
+	numLags       int
+	lagWidth      float64 // in map units; the cell size if zero
+	numDirections int
+	sampleSpacing int
+	toolManager   *PluginToolManager
+}
+
+func (this *Semivariogram) GetName() string {
+	s := "Semivariogram"
+	return getFormattedToolName(s)
+}
+
+func (this *Semivariogram) GetDescription() string {
+	s := "Estimates the experimental semivariogram of a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *Semivariogram) GetVersion() string {
+	return "1.0"
+}
+
+func (this *Semivariogram) GetHelpDocumentation() string {
+	ret := "This tool estimates the experimental semivariogram of a DEM, i.e. the semivariance, " +
+		"half the mean squared difference in elevation, of the pairs of valid cells in each of " +
+		"NumLags lag classes, for use in roughness scaling analyses and in fitting the variogram " +
+		"models used for kriging. Lag class k holds the pairs separated by within half a " +
+		"LagWidth (map units; default the cell size) of k times LagWidth. Every pair of cells " +
+		"is counted once. With Directions greater than 1, the pairs are further divided into " +
+		"that many sectors of direction, whose centres are evenly spaced azimuths from north " +
+		"(0) towards east, e.g. 0, 45, 90 and 135 degrees for 4, so that anisotropy, e.g. of " +
+		"ridge and valley terrain, can be assessed; the default of 1 gives the omnidirectional " +
+		"semivariogram. Pairs are formed from every SampleSpacing'th cell of every " +
+		"SampleSpacing'th row (default 1, i.e. all cells), which reduces the time taken on large " +
+		"DEMs. The output is a CSV file with one line per direction and lag class giving the " +
+		"mean separation of its pairs, their number and the semivariance; classes without " +
+		"pairs are omitted. The mean and standard deviation of elevation are also printed; " +
+		"the variance of elevation is the sill that the semivariogram of a stationary surface " +
+		"levels off at."
+	return ret
+}
+
+func (this *Semivariogram) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *Semivariogram) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output CSV filename, with directory and file extension"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	ret[2].Name = "NumLags"
+	ret[2].Type = "int"
+	ret[2].Description = "The number of lag classes"
+	ret[2].Default = "20"
+
+	ret[3].Name = "LagWidth"
+	ret[3].Type = "float64"
+	ret[3].Description = "The width of the lag classes in map units; the cell size if not specified"
+
+	ret[4].Name = "Directions"
+	ret[4].Type = "int"
+	ret[4].Description = "The number of direction sectors; 1 for the omnidirectional semivariogram"
+	ret[4].Default = "1"
+
+	ret[5].Name = "SampleSpacing"
+	ret[5].Type = "int"
+	ret[5].Description = "The spacing in cells of the cells that pairs are formed from"
+	ret[5].Default = "1"
+
+	return ret
+}
+
+func (this *Semivariogram) EstimateMemory(rows, columns int) int64 {
+	// only the DEM is held in memory
+	return gridBytes(rows, columns, rasterBytesPerCell)
+}
+
+func (this *Semivariogram) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input DEM and output file must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.numLags = 20
+	this.numDirections = 1
+	this.sampleSpacing = 1
+	values := []*int{&this.numLags, &this.numDirections, &this.sampleSpacing}
+	for i, v := range values {
+		j := []int{2, 4, 5}[i]
+		if len(args) > j && len(strings.TrimSpace(args[j])) > 0 && args[j] != "not specified" {
+			val, err := strconv.Atoi(strings.TrimSpace(args[j]))
+			if err != nil {
+				println(err.Error())
+				return
+			}
+			*v = val
+		}
+	}
+	this.lagWidth = 0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		val, err := strconv.ParseFloat(strings.TrimSpace(args[3]), 64)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		this.lagWidth = val
+	}
+
+	this.Run()
+}
+
+func (this *Semivariogram) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output CSV file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the number of lags
+	print("Number of lag classes (default 20): ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.numLags = 20
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.numLags, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the lag width
+	print("Lag width in map units (blank for the cell size): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.lagWidth = 0
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.lagWidth, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the number of directions
+	print("Number of direction sectors (default 1, omnidirectional): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.numDirections = 1
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.numDirections, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the sample spacing
+	print("Sample spacing in cells (default 1): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.sampleSpacing = 1
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.sampleSpacing, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *Semivariogram) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *Semivariogram) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+}
+
+func (this *Semivariogram) Run() {
+	start1 := time.Now()
+
+	if this.numLags < 1 || this.numDirections < 1 || this.sampleSpacing < 1 || this.lagWidth < 0 {
+		println("The number of lags, directions and the sample spacing must be at least 1, and the lag width must be positive.")
+		return
+	}
+
+	println("Reading DEM data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+	lagWidth := this.lagWidth
+	if lagWidth == 0 {
+		lagWidth = cellSizeX
+	}
+
+	start2 := time.Now()
+
+	// the mean and standard deviation of elevation, accumulating deviations
+	// from the first valid value for numerical stability
+	var z0, sum, sumSqr float64
+	n := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z := rin.Value(row, col); z != nodata {
+				if n == 0 {
+					z0 = z
+				}
+				sum += z - z0
+				sumSqr += (z - z0) * (z - z0)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		println("The DEM does not contain any valid cells.")
+		return
+	}
+	mean := sum / float64(n)
+	variance := sumSqr/float64(n) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	mean += z0
+
+	// the cell offsets of the pairs, each pair being counted once, and their
+	// lag classes and direction sectors
+	type offset struct {
+		dr, dc   int
+		distance float64
+		bin      int // direction*numLags + lag - 1
+	}
+	maxDistance := (float64(this.numLags) + 0.5) * lagWidth
+	maxDR := int(maxDistance / cellSizeY)
+	maxDC := int(maxDistance / cellSizeX)
+	sectorWidth := 180.0 / float64(this.numDirections)
+	offsets := make([]offset, 0)
+	for dr := 0; dr <= maxDR; dr++ {
+		for dc := -maxDC; dc <= maxDC; dc++ {
+			if dr == 0 && dc <= 0 {
+				continue
+			}
+			dx, dy := float64(dc)*cellSizeX, float64(dr)*cellSizeY
+			distance := math.Sqrt(dx*dx + dy*dy)
+			lag := int(math.Floor(distance/lagWidth + 0.5))
+			if lag < 1 || lag > this.numLags {
+				continue
+			}
+			// the azimuth from north of the offset, which is towards the south
+			// as rows increase, folded into [0, 180)
+			azimuth := math.Mod(math.Atan2(dx, -dy)*180/math.Pi+360, 180)
+			sector := int(math.Floor(azimuth/sectorWidth+0.5)) % this.numDirections
+			offsets = append(offsets, offset{dr, dc, distance, sector*this.numLags + lag - 1})
+		}
+	}
+
+	// the squared differences of the pairs of each offset, calculated in
+	// parallel and summed in the order of the offsets, so that the output
+	// doesn't depend on the number of threads
+	type offsetSum struct {
+		sumSqrDiff float64
+		numPairs   int
+	}
+	sums := make([]offsetSum, len(offsets))
+	next := make(chan int, len(offsets))
+	done := make(chan bool, len(offsets))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	numCPUs := this.toolManager.numThreads()
+	for cpu := 0; cpu < numCPUs; cpu++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if interrupted() {
+					done <- true
+					continue
+				}
+				o := offsets[i]
+				var s float64
+				var m int
+				for row := 0; row+o.dr < rows; row += this.sampleSpacing {
+					for col := 0; col < columns; col += this.sampleSpacing {
+						c := col + o.dc
+						if c < 0 || c >= columns {
+							continue
+						}
+						z1 := rin.Value(row, col)
+						z2 := rin.Value(row+o.dr, c)
+						if z1 == nodata || z2 == nodata {
+							continue
+						}
+						s += (z1 - z2) * (z1 - z2)
+						m++
+					}
+				}
+				sums[i] = offsetSum{s, m}
+				done <- true
+			}
+		}()
+	}
+	for i := range offsets {
+		next <- i
+	}
+	close(next)
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for i := range offsets {
+		<-done
+		progress = int(100.0 * float64(i+1) / float64(len(offsets)))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	numBins := this.numDirections * this.numLags
+	binSqrDiff := make([]float64, numBins)
+	binDistance := make([]float64, numBins)
+	binPairs := make([]int, numBins)
+	for i, o := range offsets {
+		binSqrDiff[o.bin] += sums[i].sumSqrDiff
+		binDistance[o.bin] += o.distance * float64(sums[i].numPairs)
+		binPairs[o.bin] += sums[i].numPairs
+	}
+
+	w, err := createTable(this.outputFile, "direction", "lag", "distance", "pairs", "semivariance")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	numPairs := 0
+	for sector := 0; sector < this.numDirections; sector++ {
+		direction := "omni"
+		if this.numDirections > 1 {
+			direction = formatFloat(float64(sector)*sectorWidth, 64)
+		}
+		for lag := 1; lag <= this.numLags; lag++ {
+			b := sector*this.numLags + lag - 1
+			if binPairs[b] == 0 {
+				continue
+			}
+			N := float64(binPairs[b])
+			w.writeRow(direction, lag, strconv.FormatFloat(binDistance[b]/N, 'f', 4, 64), binPairs[b],
+				strconv.FormatFloat(binSqrDiff[b]/(2*N), 'f', 6, 64))
+			numPairs += binPairs[b]
+		}
+	}
+	if err = w.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	elapsed := time.Since(start2)
+
+	printf("\nMean elevation: %.4f\n", mean)
+	printf("Standard deviation of elevation: %.4f (variance %.4f)\n", math.Sqrt(variance), variance)
+	printf("Pairs of cells: %v in %v lag classes\n", numPairs, numBins)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
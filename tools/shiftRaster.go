@@ -0,0 +1,260 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// ShiftRaster translates the georeferencing of a raster, e.g. to correct a
+// dataset delivered with the wrong origin.
+type ShiftRaster struct {
+	inputFile   string
+	outputFile  string
+	dx, dy      float64
+	toolManager *PluginToolManager
+}
+
+func (this *ShiftRaster) GetName() string {
+	s := "ShiftRaster"
+	return getFormattedToolName(s)
+}
+
+func (this *ShiftRaster) GetDescription() string {
+	s := "Translates the georeferencing of a raster"
+	return getFormattedToolDescription(s)
+}
+
+func (this *ShiftRaster) GetVersion() string {
+	return "1.0"
+}
+
+func (this *ShiftRaster) GetHelpDocumentation() string {
+	ret := "This tool translates a raster by adding DX to its east and west edges and DY to " +
+		"its north and south edges, in map units, e.g. to correct a dataset whose origin was " +
+		"given as a cell centre rather than a cell corner (a shift of half a cell) or in the " +
+		"wrong false easting. The grid values, cell size and coordinate reference system are " +
+		"not modified. If no output file is specified, the input raster is overwritten."
+	return ret
+}
+
+func (this *ShiftRaster) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *ShiftRaster) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "DX"
+	ret[1].Type = "float64"
+	ret[1].Description = "The shift in the x direction, in map units"
+	ret[1].Required = true
+
+	ret[2].Name = "DY"
+	ret[2].Type = "float64"
+	ret[2].Description = "The shift in the y direction, in map units"
+	ret[2].Required = true
+
+	ret[3].Name = "OutputFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The output filename (overwrites the input if not specified)"
+	ret[3].Role = ArgOutputRaster
+
+	return ret
+}
+
+func (this *ShiftRaster) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, DX, and DY must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	var err error
+	if this.dx, err = strconv.ParseFloat(strings.TrimSpace(args[1]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	if this.dy, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.outputFile = this.inputFile
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		outputFile := strings.TrimSpace(args[3])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *ShiftRaster) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the shifts
+	print("Shift in the x direction (map units): ")
+	dxStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.dx, err = strconv.ParseFloat(strings.TrimSpace(dxStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	print("Shift in the y direction (map units): ")
+	dyStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.dy, err = strconv.ParseFloat(strings.TrimSpace(dyStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension; blank to overwrite input): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputFile = this.inputFile
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputFile = outputFile
+	}
+
+	this.Run()
+}
+
+func (this *ShiftRaster) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	data, err := rin.Data()
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	// create the output raster
+	config := copyRasterConfig(rin)
+	rout, err := raster.CreateNewRaster(this.outputFile, rin.Rows, rin.Columns,
+		rin.North+this.dy, rin.South+this.dy, rin.East+this.dx, rin.West+this.dx, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	rout.SetData(data)
+
+	for _, entry := range rin.GetMetadataEntries() {
+		if len(strings.TrimSpace(entry)) > 0 {
+			rout.AddMetadataEntry(entry)
+		}
+	}
+	printf("North: %v, south: %v, east: %v, west: %v\n", rout.North, rout.South, rout.East, rout.West)
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by ShiftRaster tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Shifted by DX %v, DY %v", this.dx, this.dy))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// copyRasterConfig returns the configuration of a new raster with the data
+// type, nodata value, display settings and coordinate reference system of an
+// existing one, for tools that rearrange its cells without changing them.
+func copyRasterConfig(rin *raster.Raster) *raster.RasterConfig {
+	inConfig := rin.GetRasterConfig()
+	config := raster.NewDefaultRasterConfig()
+	config.CopyDisplaySettings(inConfig)
+	config.DataType = inConfig.DataType
+	config.NoDataValue = rin.NoDataValue
+	config.InitialValue = rin.NoDataValue
+	config.PhotometricInterpretation = inConfig.PhotometricInterpretation
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.PixelIsArea = inConfig.PixelIsArea
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	return config
+}
@@ -23,6 +23,8 @@ import (
 type Slope struct {
 	inputFile   string
 	outputFile  string
+	method      string
+	percent     bool
 	toolManager *PluginToolManager
 }
 
@@ -37,8 +39,19 @@ func (this *Slope) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *Slope) GetVersion() string {
+	return "1.1"
+}
+
 func (this *Slope) GetHelpDocumentation() string {
-	ret := ""
+	ret := "This tool calculates the slope gradient of each cell of a digital elevation " +
+		"model (DEM), in degrees or, if the Units are 'percent', as a percentage, i.e. " +
+		"100 times the rise over the run. The surface gradient is estimated from the 3 x 3 " +
+		"neighbourhood of each cell by the Method of Horn (1981), the default, or by the " +
+		"quadratic surface of Evans (1979), which is less sensitive to noise in the DEM. " +
+		"Nodata neighbours take the elevation of the cell. If the DEM is in geographic " +
+		"coordinates, its cell sizes are converted from degrees to metres at its " +
+		"mid-latitude, so its elevations should be in metres."
 	return ret
 }
 
@@ -46,20 +59,33 @@ func (this *Slope) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *Slope) GetArgDescriptions() [][]string {
-	numArgs := 2
-
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input File name, with directory and file extension"
-
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output filename, with directory and file extension"
+func (this *Slope) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Method"
+	ret[2].Type = "string"
+	ret[2].Description = "The method of estimating the surface gradient: horn or evans"
+	ret[2].Default = "horn"
+	ret[2].Choices = []string{"horn", "evans"}
+
+	ret[3].Name = "Units"
+	ret[3].Type = "string"
+	ret[3].Description = "The units of the output slope: degrees or percent"
+	ret[3].Default = "degrees"
+	ret[3].Choices = []string{"degrees", "percent"}
 
 	return ret
 }
@@ -72,7 +98,7 @@ func (this *Slope) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -83,10 +109,25 @@ func (this *Slope) ParseArguments(args []string) {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	method := ""
+	if len(args) > 2 && args[2] != "not specified" {
+		method = args[2]
+	}
+	if !this.setMethod(method) {
+		return
+	}
+	units := ""
+	if len(args) > 3 && args[3] != "not specified" {
+		units = args[3]
+	}
+	if !this.setUnits(units) {
+		return
+	}
+
 	this.Run()
 }
 
@@ -105,7 +146,7 @@ func (this *Slope) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -122,17 +163,70 @@ func (this *Slope) CollectArguments() {
 	}
 	rasterType, err := raster.DetermineRasterFormat(outputFile)
 	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
-		outputFile = outputFile + ".tif" // default to a geotiff
+		outputFile = outputFile + raster.DefaultExtension // the default output format
 	}
 	this.outputFile = outputFile
 
+	// get the method
+	print("Gradient method, 'horn' or 'evans' (blank for the default): ")
+	method, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setMethod(method) {
+		return
+	}
+
+	// get the units
+	print("Slope units, 'degrees' or 'percent' (blank for the default): ")
+	units, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setUnits(units) {
+		return
+	}
+
 	this.Run()
 }
 
+// setMethod sets the method of estimating the surface gradient, Horn's if s
+// is blank.
+func (this *Slope) setMethod(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "horn":
+		this.method = "horn"
+	case "evans":
+		this.method = "evans"
+	default:
+		printf("unrecognized method: %s\n", s)
+		return false
+	}
+	return true
+}
+
+// setUnits sets whether the slope is output in percent rather than degrees,
+// which is the default if s is blank.
+func (this *Slope) setUnits(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "degrees":
+		this.percent = false
+	case "percent":
+		this.percent = true
+	default:
+		printf("unrecognized units: %s\n", s)
+		return false
+	}
+	return true
+}
+
 func (this *Slope) Run() {
 	start1 := time.Now()
 
 	var progress, oldProgress int
+	var eta progressETA
 
 	println("Reading raster data...")
 	rin, err := raster.CreateRasterFromFile(this.inputFile)
@@ -147,8 +241,6 @@ func (this *Slope) Run() {
 	rowsLessOne := rows - 1
 	nodata := rin.NoDataValue
 	inConfig := rin.GetRasterConfig()
-	gridRes := (rin.GetCellSizeX() + rin.GetCellSizeY()) / 2.0
-	eightGridRes := 8 * gridRes
 
 	// create the output raster
 	config := raster.NewDefaultRasterConfig()
@@ -165,19 +257,17 @@ func (this *Slope) Run() {
 		return
 	}
 
-	zConvFactor := 1.0
-	if rin.IsInGeographicCoordinates() {
-		// calculate a new z-conversion factor
-		midLat := (rin.North - rin.South) / 2.0
-		if midLat <= 90 && midLat >= -90 {
-			zConvFactor = 1.0 / (113200 * math.Cos(math.Pi/180.0*midLat))
-		}
+	cellSizeX, cellSizeY := metricCellSizes(rin)
+	derivatives := hornDerivatives
+	if this.method == "evans" {
+		derivatives = evansDerivatives
 	}
 
-	numCPUs := runtime.NumCPU()
-	c1 := make(chan bool)
+	numCPUs := this.toolManager.numThreads()
+	c1 := make(chan bool, rows)
 	runtime.GOMAXPROCS(numCPUs)
 	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	// calculate slope
 	printf("\r                                                    ")
@@ -195,30 +285,20 @@ func (this *Slope) Run() {
 		wg.Add(1)
 		go func(rowSt, rowEnd, k int) {
 			defer wg.Done()
-			var z, zN, fy, fx, slope float64
-			dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
-			dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
-			N := [8]float64{}
 			for row := rowSt; row <= rowEnd; row++ {
+				if interrupted() {
+					c1 <- true
+					continue
+				}
 				floatData := make([]float64, columns)
 				for col := 0; col < columns; col++ {
-					z = rin.Value(row, col)
-					if z != nodata {
-						z = z * zConvFactor
-						for n := 0; n < 8; n++ {
-							zN = rin.Value(row+dY[n], col+dX[n])
-							if zN != nodata {
-								N[n] = zN * zConvFactor
-							} else {
-								N[n] = z
-							}
-						}
-						fy = (N[6] - N[4] + 2*(N[7]-N[3]) + N[0] - N[2]) / eightGridRes
-						fx = (N[2] - N[4] + 2*(N[1]-N[5]) + N[0] - N[6]) / eightGridRes
-						slope = (math.Atan(math.Sqrt(fx*fx+fy*fy)) * RadToDeg)
-						floatData[col] = slope
-					} else {
+					d, ok := derivatives(rin, row, col, cellSizeX, cellSizeY)
+					if !ok {
 						floatData[col] = nodata
+					} else if this.percent {
+						floatData[col] = 100 * math.Sqrt(d.p*d.p+d.q*d.q)
+					} else {
+						floatData[col] = d.slope()
 					}
 				}
 				rout.SetRowValues(row, floatData)
@@ -235,7 +315,7 @@ func (this *Slope) Run() {
 		<-c1 // a row has successfully completed
 		progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
 		if progress != oldProgress {
-			printf("\rProgress: %v%%", progress)
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
@@ -248,7 +328,7 @@ func (this *Slope) Run() {
 	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
 	elapsed := time.Since(start2)
 	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
-	rout.AddMetadataEntry(fmt.Sprintf("Created by Slope"))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by Slope tool (%s)", this.toolManager.toolVersion(this)))
 	rout.Save()
 
 	println("Operation complete!")
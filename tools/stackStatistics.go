@@ -0,0 +1,330 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+var stackStatisticNames = []string{"mean", "min", "max", "range", "stdev", "count", "trend"}
+
+// StackStatistics calculates a per-cell statistic across a multi-temporal
+// stack of co-registered rasters.
+type StackStatistics struct {
+	stackFile   string
+	outputFile  string
+	statistic   string
+	toolManager *PluginToolManager
+}
+
+func (this *StackStatistics) GetName() string {
+	s := "StackStatistics"
+	return getFormattedToolName(s)
+}
+
+func (this *StackStatistics) GetDescription() string {
+	s := "Per-cell statistics across a stack of rasters"
+	return getFormattedToolDescription(s)
+}
+
+func (this *StackStatistics) GetVersion() string {
+	return "1.0"
+}
+
+func (this *StackStatistics) GetHelpDocumentation() string {
+	ret := "This tool calculates a per-cell statistic across an ordered stack of " +
+		"co-registered rasters, e.g. repeat surveys of snow depth, water level, or lidar " +
+		"DEMs. The stack is described by a text file listing one raster per line, " +
+		"optionally followed by the time of the layer as a number or a date (YYYY-MM-DD, " +
+		"converted to decimal years); if any time is missing, the layers are numbered " +
+		"0, 1, 2, ... Relative file names are resolved against the directory of the list " +
+		"file. The available statistics are mean, min, max, range, stdev, count (the " +
+		"number of valid layers), and trend (the least-squares slope of value against " +
+		"time, in value units per time unit). Nodata layers are ignored at each cell."
+	return ret
+}
+
+func (this *StackStatistics) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *StackStatistics) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "StackFile"
+	ret[0].Type = "string"
+	ret[0].Description = "Text file listing the rasters of the stack in temporal order"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Statistic"
+	ret[2].Type = "string"
+	ret[2].Description = "mean, min, max, range, stdev, count or trend"
+	ret[2].Default = "mean"
+	ret[2].Choices = []string{"mean", "min", "max", "range", "stdev", "count", "trend"}
+
+	return ret
+}
+
+func (this *StackStatistics) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The stack file and output file must be specified.")
+		return
+	}
+	stackFile := strings.TrimSpace(args[0])
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.statistic = "mean"
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if !this.setStatistic(args[2]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *StackStatistics) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the stack file name
+	print("Enter the stack list file name (incl. file extension): ")
+	stackFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	stackFile = strings.TrimSpace(stackFile)
+	if !strings.Contains(stackFile, pathSep) {
+		stackFile = this.toolManager.workingDirectory + stackFile
+	}
+	// see if the file exists
+	if _, err := os.Stat(stackFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", stackFile)
+		return
+	}
+	this.stackFile = stackFile
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the statistic
+	printf("Statistic, %s (blank for mean): ", strings.Join(stackStatisticNames, ", "))
+	statistic, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.statistic = "mean"
+	if len(strings.TrimSpace(statistic)) > 0 {
+		if !this.setStatistic(statistic) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *StackStatistics) setStatistic(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, stat := range stackStatisticNames {
+		if s == stat {
+			this.statistic = stat
+			return true
+		}
+	}
+	printf("Unrecognized statistic: %s\n", s)
+	return false
+}
+
+func (this *StackStatistics) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	stack, err := raster.CreateRasterStackFromListFile(this.stackFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	printf("The stack contains %v layers.\n", stack.Len())
+
+	start2 := time.Now()
+
+	rows := stack.Rows
+	columns := stack.Columns
+	first := stack.Layer(0)
+	nodata := first.NoDataValue
+	inConfig := first.GetRasterConfig()
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	if this.statistic == "trend" {
+		config.PreferredPalette = "blue_white_red.pal"
+	}
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.ZUnits = inConfig.ZUnits
+	config.XYUnits = inConfig.XYUnits
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		stack.North, stack.South, stack.East, stack.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	t := make([]float64, 0, stack.Len())
+	z := make([]float64, 0, stack.Len())
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			t, z = stack.CellSeries(row, col, t, z)
+			if value, ok := cellStatistic(this.statistic, t, z); ok {
+				rout.SetValue(row, col, value)
+			}
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by StackStatistics tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Statistic: %s of %v layers", this.statistic, stack.Len()))
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Calculates a statistic of the series z observed at times t. The second
+// return value is false if the series is too short for the statistic.
+func cellStatistic(statistic string, t, z []float64) (float64, bool) {
+	n := len(z)
+	if statistic == "count" {
+		return float64(n), true
+	}
+	if n == 0 {
+		return 0, false
+	}
+	switch statistic {
+	case "mean":
+		total := 0.0
+		for _, v := range z {
+			total += v
+		}
+		return total / float64(n), true
+	case "min", "max", "range":
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, v := range z {
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+		if statistic == "min" {
+			return min, true
+		} else if statistic == "max" {
+			return max, true
+		}
+		return max - min, true
+	case "stdev":
+		if n < 2 {
+			return 0, false
+		}
+		mean := 0.0
+		for _, v := range z {
+			mean += v
+		}
+		mean /= float64(n)
+		ss := 0.0
+		for _, v := range z {
+			ss += (v - mean) * (v - mean)
+		}
+		return math.Sqrt(ss / float64(n-1)), true
+	case "trend":
+		if n < 2 {
+			return 0, false
+		}
+		var meanT, meanZ float64
+		for i := range z {
+			meanT += t[i]
+			meanZ += z[i]
+		}
+		meanT /= float64(n)
+		meanZ /= float64(n)
+		var stz, stt float64
+		for i := range z {
+			stz += (t[i] - meanT) * (z[i] - meanZ)
+			stt += (t[i] - meanT) * (t[i] - meanT)
+		}
+		if stt == 0 {
+			return 0, false
+		}
+		return stz / stt, true
+	}
+	return 0, false
+}
@@ -0,0 +1,498 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// StochasticDepressionAnalysis maps the probability that each cell of a DEM
+// lies in a depression, given the DEM's error, by filling the depressions of
+// many realizations of the DEM with simulated error fields added.
+type StochasticDepressionAnalysis struct {
+	inputFile   string
+	outputFile  string
+	rmse        float64
+	vrange      float64
+	iterations  int
+	model       variogramModel
+	seed        int64
+	toolManager *PluginToolManager
+}
+
+func (this *StochasticDepressionAnalysis) GetName() string {
+	s := "StochasticDepressionAnalysis"
+	return getFormattedToolName(s)
+}
+
+func (this *StochasticDepressionAnalysis) GetDescription() string {
+	s := "Maps the probability of depressions in an uncertain DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *StochasticDepressionAnalysis) GetVersion() string {
+	return "1.0"
+}
+
+func (this *StochasticDepressionAnalysis) GetHelpDocumentation() string {
+	ret := "This tool performs the stochastic depression analysis of Lindsay and Creed " +
+		"(2006), which maps the probability that each cell of a digital elevation model " +
+		"(DEM) lies in a topographic depression, e.g. to locate wetlands, given the error " +
+		"of the DEM. In each of the Iterations (default 100), a Gaussian random error " +
+		"field with a standard deviation of the DEM's RMSE is added to the DEM, and the " +
+		"depressions of the result are filled by priority flood (Barnes et al., 2014) from " +
+		"the edges of the DEM and its nodata cells; a cell is in a depression if filling " +
+		"raises it. The output is the fraction of the realizations in which each cell is " +
+		"in a depression, from 0 to 1. The error is spatially autocorrelated with a " +
+		"variogram of the given Range, in the units of the DEM's XY coordinates, and " +
+		"Model, 'spherical', 'exponential' or 'gaussian' (default), as simulated by " +
+		"GaussianRandomField; a range of 0, the default, gives uncorrelated error. Small, " +
+		"shallow depressions that are artefacts of the error have low probabilities, and " +
+		"real depressions, deeper than the error, high ones. Each realization is drawn " +
+		"from the Seed plus its number, so results are reproducible for a given seed; the " +
+		"default seed is taken from the clock."
+	return ret
+}
+
+func (this *StochasticDepressionAnalysis) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *StochasticDepressionAnalysis) GetArgDescriptions() []ToolArg {
+	numArgs := 7
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "RMSE"
+	ret[2].Type = "float64"
+	ret[2].Description = "The root-mean-square error of the DEM's elevations"
+	ret[2].Required = true
+
+	ret[3].Name = "Range"
+	ret[3].Type = "float64"
+	ret[3].Description = "The autocorrelation range of the error, in XY units (0 for none)"
+	ret[3].Default = "0"
+
+	ret[4].Name = "Iterations"
+	ret[4].Type = "int"
+	ret[4].Description = "The number of realizations of the DEM"
+	ret[4].Default = "100"
+
+	ret[5].Name = "Model"
+	ret[5].Type = "string"
+	ret[5].Description = "The variogram model of the error: spherical, exponential or gaussian"
+	ret[5].Default = "gaussian"
+	ret[5].Choices = variogramModelNames
+
+	ret[6].Name = "Seed"
+	ret[6].Type = "int"
+	ret[6].Description = "The seed of the random number generator"
+
+	return ret
+}
+
+func (this *StochasticDepressionAnalysis) EstimateMemory(rows, columns int) int64 {
+	// the DEM and output rasters, and for each thread, the realization, its
+	// filled copy, the priority queue and the counts of depressions, and the
+	// padded complex grid of the error simulation
+	n := 1
+	for n < 2*rows || n < 2*columns {
+		n *= 2
+	}
+	numThreads := int64(this.toolManager.numThreads())
+	return gridBytes(rows, columns, 2*rasterBytesPerCell) +
+		numThreads*(gridBytes(rows, columns, 8+8+1+40+4)+gridBytes(n, n, 16)) + gridBytes(n, n, 8)
+}
+
+func (this *StochasticDepressionAnalysis) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input DEM, output file, and RMSE must be specified.")
+		return
+	}
+	if !this.setInputFile(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	var err error
+	if this.rmse, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	this.vrange = 0
+	if specified(3) {
+		if this.vrange, err = strconv.ParseFloat(strings.TrimSpace(args[3]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.iterations = 100
+	if specified(4) {
+		if this.iterations, err = strconv.Atoi(strings.TrimSpace(args[4])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.model = gaussianModel
+	if specified(5) {
+		if this.model, err = parseVariogramModel(args[5]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.seed = time.Now().UnixNano()
+	if specified(6) {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(args[6]), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *StochasticDepressionAnalysis) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputFile(inputFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the RMSE
+	print("RMSE of the DEM: ")
+	str, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.rmse, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the range
+	this.vrange = 0
+	print("Autocorrelation range of the error (default 0): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.vrange, err = strconv.ParseFloat(strings.TrimSpace(str), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the number of iterations
+	this.iterations = 100
+	print("Number of iterations (default 100): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.iterations, err = strconv.Atoi(strings.TrimSpace(str)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the variogram model
+	this.model = gaussianModel
+	print("Variogram model, 'spherical', 'exponential' or 'gaussian' (blank for the default): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.model, err = parseVariogramModel(str); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the seed
+	this.seed = time.Now().UnixNano()
+	print("Random seed (blank for the clock): ")
+	str, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(str)) > 0 {
+		if this.seed, err = strconv.ParseInt(strings.TrimSpace(str), 10, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *StochasticDepressionAnalysis) setInputFile(s string) bool {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return false
+	}
+	return true
+}
+
+func (this *StochasticDepressionAnalysis) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *StochasticDepressionAnalysis) Run() {
+	start1 := time.Now()
+
+	if this.rmse <= 0 || this.vrange < 0 || this.iterations < 1 {
+		println("The RMSE must be greater than zero, the range can't be negative, and there must be at least 1 iteration.")
+		return
+	}
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	z := make([]float64, rows*columns)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z[row*columns+col] = dem.Value(row, col)
+		}
+	}
+
+	// the realizations are shared among the threads, each drawn from its own
+	// seed so that the result doesn't depend on the number of threads
+	var sim *fieldSimulator
+	if this.vrange > 0 {
+		v := variogram{model: this.model, sill: this.rmse * this.rmse, vrange: this.vrange}
+		sim = newFieldSimulator(rows, columns, dem.GetCellSizeX(), dem.GetCellSizeY(), v)
+	}
+	printf("Seed: %v\n", this.seed)
+	numThreads := this.toolManager.numThreads()
+	if numThreads > this.iterations {
+		numThreads = this.iterations
+	}
+	counts := make([][]int32, numThreads)
+	done := make(chan bool, this.iterations)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for t := 0; t < numThreads; t++ {
+		counts[t] = make([]int32, rows*columns)
+		wg.Add(1)
+		go func(t int) {
+			defer wg.Done()
+			field := make([][]float64, rows)
+			for row := range field {
+				field[row] = make([]float64, columns)
+			}
+			realization := make([]float64, rows*columns)
+			for k := t; k < this.iterations; k += numThreads {
+				if interrupted() {
+					done <- true
+					continue
+				}
+				rng := rand.New(rand.NewSource(this.seed + int64(k)))
+				if sim != nil {
+					sim.simulate(rng, field)
+				} else {
+					for row := range field {
+						for col := range field[row] {
+							field[row][col] = this.rmse * rng.NormFloat64()
+						}
+					}
+				}
+				for i, v := range z {
+					if v != nodata {
+						realization[i] = v + field[i/columns][i%columns]
+					} else {
+						realization[i] = nodata
+					}
+				}
+				filled := priorityFlood(realization, rows, columns, nodata)
+				for i, v := range filled {
+					if v > realization[i] {
+						counts[t][i]++
+					}
+				}
+				done <- true
+			}
+		}(t)
+	}
+	oldProgress := -1
+	var eta progressETA
+	for k := 0; k < this.iterations; k++ {
+		<-done
+		progress := int(100.0 * float64(k+1) / float64(this.iterations))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "spectrum.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for i, v := range z {
+		if v == nodata {
+			continue
+		}
+		n := 0
+		for t := range counts {
+			n += int(counts[t][i])
+		}
+		rout.SetValue(i/columns, i%columns, float64(n)/float64(this.iterations))
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by StochasticDepressionAnalysis tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("RMSE: %v, range: %v, model: %s, iterations: %v, seed: %v",
+		this.rmse, this.vrange, this.model, this.iterations, this.seed))
+	if err = rout.Save(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// priorityFlood returns the elevations of a grid with its depressions filled
+// by the priority flood of Barnes et al. (2014): cells are visited from the
+// edges of the grid and its nodata cells inwards, lowest first, and each is
+// raised to the elevation of the cell that it was reached from if it is
+// lower. Filled depressions are left flat.
+func priorityFlood(z []float64, rows, columns int, nodata float64) []float64 {
+	filled := make([]float64, len(z))
+	copy(filled, z)
+	queued := make([]bool, len(z))
+	pq := NewPQueue()
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if z[i] == nodata {
+				continue
+			}
+			for n := 0; n < 8; n++ {
+				rowN, colN := row+d8DY[n], col+d8DX[n]
+				if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns || z[rowN*columns+colN] == nodata {
+					queued[i] = true
+					pq.Push(newGridCell(row, col, i), elevationPriority(z[i]))
+					break
+				}
+			}
+		}
+	}
+	for pq.Len() > 0 {
+		gc := pq.Pop()
+		zc := filled[gc.flatIndex]
+		for n := 0; n < 8; n++ {
+			rowN, colN := gc.row+d8DY[n], gc.column+d8DX[n]
+			if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns {
+				continue
+			}
+			iN := rowN*columns + colN
+			if queued[iN] || z[iN] == nodata {
+				continue
+			}
+			queued[iN] = true
+			if filled[iN] < zc {
+				filled[iN] = zc
+			}
+			pq.Push(newGridCell(rowN, colN, iN), elevationPriority(filled[iN]))
+		}
+	}
+	return filled
+}
+
+// elevationPriority returns a priority queue key that orders elevations,
+// including negative ones, as their values do.
+func elevationPriority(z float64) int64 {
+	key := int64(math.Float64bits(z))
+	if key < 0 {
+		key ^= math.MaxInt64
+	}
+	return key
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"math"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// streamLink is a reach of a raster stream network between a channel head or
+// junction and the next junction or outlet.
+type streamLink struct {
+	cells    []int // the cells, downstream, ending at the first cell of the link below
+	downLink int   // the index of the link below, or -1 at an outlet
+	order    int   // the Strahler order
+	id       int   // the number of the link, from 1, or 0 for a link of a single cell
+}
+
+// streamNetwork is a raster stream network divided into links.
+type streamNetwork struct {
+	rows, columns int
+	down          []int32 // the cell that each stream cell drains to, or -1
+	links         []streamLink
+	numLinks      int // the number of links of more than one cell
+	maxOrder      int
+}
+
+// traceStreamNetwork divides the stream cells of a raster, those greater
+// than zero, into links. Each stream cell drains to its neighbouring stream
+// cell of greatest accumulation, provided that it exceeds its own; since
+// accumulation rises strictly downstream, the network has no loops. Links of
+// a single cell, i.e. isolated cells and outlets at junctions, are not
+// numbered, and the others are numbered in order from 1.
+func traceStreamNetwork(streams, accum *raster.Raster) *streamNetwork {
+	var progress, oldProgress int
+	var eta progressETA
+
+	rows := streams.Rows
+	columns := streams.Columns
+	nodata := streams.NoDataValue
+	accumNodata := accum.NoDataValue
+	isStream := func(row, col int) bool {
+		z := streams.Value(row, col)
+		return z != nodata && z > 0 && accum.Value(row, col) != accumNodata
+	}
+
+	down := make([]int32, rows*columns)
+	inflow := make([]uint8, rows*columns)
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			down[i] = -1
+			if !isStream(row, col) {
+				continue
+			}
+			maxAccum := accum.Value(row, col)
+			for n := 0; n < 8; n++ {
+				rowN, colN := row+d8DY[n], col+d8DX[n]
+				if rowN < 0 || rowN >= rows || colN < 0 || colN >= columns || !isStream(rowN, colN) {
+					continue
+				}
+				if a := accum.Value(rowN, colN); a > maxAccum {
+					maxAccum = a
+					down[i] = int32(rowN*columns + colN)
+				}
+			}
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 1 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	for _, d := range down {
+		if d >= 0 {
+			inflow[d]++
+		}
+	}
+
+	// a link starts at each channel head and junction, i.e. each stream cell
+	// with other than one inflowing cell, and runs down to the next
+	links := make([]streamLink, 0)
+	linkStartingAt := make(map[int]int)
+	oldProgress = -1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			i := row*columns + col
+			if !isStream(row, col) || inflow[i] == 1 {
+				continue
+			}
+			cells := []int{i}
+			for c := i; down[c] >= 0; {
+				c = int(down[c])
+				cells = append(cells, c)
+				if inflow[c] != 1 {
+					break
+				}
+			}
+			linkStartingAt[i] = len(links)
+			links = append(links, streamLink{cells: cells, downLink: -1})
+		}
+		progress = int(100.0 * float64(row+1) / float64(rows))
+		if progress != oldProgress {
+			printf("\rProgress (Loop 2 of 2): %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	// Strahler orders, from the channel heads down
+	numUpstream := make([]int, len(links))
+	for i := range links {
+		last := links[i].cells[len(links[i].cells)-1]
+		if j, ok := linkStartingAt[last]; ok && j != i {
+			links[i].downLink = j
+			numUpstream[j]++
+		}
+	}
+	maxOrders := make([]int, len(links))
+	numAtMax := make([]int, len(links))
+	queue := make([]int, 0)
+	for i := range links {
+		if numUpstream[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		links[i].order = 1
+		if numAtMax[i] > 1 {
+			links[i].order = maxOrders[i] + 1
+		} else if numAtMax[i] == 1 {
+			links[i].order = maxOrders[i]
+		}
+		if j := links[i].downLink; j >= 0 {
+			if links[i].order > maxOrders[j] {
+				maxOrders[j], numAtMax[j] = links[i].order, 1
+			} else if links[i].order == maxOrders[j] {
+				numAtMax[j]++
+			}
+			if numUpstream[j]--; numUpstream[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	sn := &streamNetwork{rows: rows, columns: columns, down: down, links: links}
+	for i := range links {
+		if len(links[i].cells) > 1 {
+			sn.numLinks++
+			links[i].id = sn.numLinks
+			if links[i].order > sn.maxOrder {
+				sn.maxOrder = links[i].order
+			}
+		}
+	}
+	return sn
+}
+
+// linkIDs returns the number of the link that each cell belongs to, or 0 for
+// cells that aren't in a numbered link. The last cell of a link belongs to
+// the link below, unless it is an outlet.
+func (sn *streamNetwork) linkIDs() []int32 {
+	ret := make([]int32, sn.rows*sn.columns)
+	for _, l := range sn.links {
+		if l.id == 0 {
+			continue
+		}
+		for k, c := range l.cells {
+			if k < len(l.cells)-1 || l.downLink < 0 {
+				ret[c] = int32(l.id)
+			}
+		}
+	}
+	return ret
+}
+
+// downstreamID returns the number of the link below a link, or 0 at an
+// outlet.
+func (sn *streamNetwork) downstreamID(l streamLink) int {
+	if l.downLink < 0 {
+		return 0
+	}
+	return sn.links[l.downLink].id
+}
+
+// vertices returns the coordinates of the cell centres of a link on the grid
+// of r, downstream, and the length of the line through them.
+func (l streamLink) vertices(r *raster.Raster) ([][2]float64, float64) {
+	columns := r.Columns
+	cellSizeX, cellSizeY := r.GetCellSizeX(), r.GetCellSizeY()
+	vertices := make([][2]float64, len(l.cells))
+	length := 0.0
+	for k, c := range l.cells {
+		vertices[k][0] = r.West + (float64(c%columns)+0.5)*cellSizeX
+		vertices[k][1] = r.North - (float64(c/columns)+0.5)*cellSizeY
+		if k > 0 {
+			length += math.Hypot(vertices[k][0]-vertices[k-1][0], vertices[k][1]-vertices[k-1][1])
+		}
+	}
+	return vertices, length
+}
@@ -0,0 +1,300 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// SurfaceAreaRatio calculates the ratio of the three-dimensional surface
+// area to the planimetric area of each grid cell of a DEM (Jenness, 2004),
+// a measure of rugosity.
+type SurfaceAreaRatio struct {
+	inputFile   string
+	outputFile  string
+	toolManager *PluginToolManager
+}
+
+func (this *SurfaceAreaRatio) GetName() string {
+	s := "SurfaceAreaRatio"
+	return getFormattedToolName(s)
+}
+
+func (this *SurfaceAreaRatio) GetDescription() string {
+	s := "Calculates the surface area ratio (rugosity) of a DEM"
+	return getFormattedToolDescription(s)
+}
+
+func (this *SurfaceAreaRatio) GetVersion() string {
+	return "1.0"
+}
+
+func (this *SurfaceAreaRatio) GetHelpDocumentation() string {
+	ret := "This tool calculates the ratio of the surface area to the planimetric area " +
+		"of each grid cell in a DEM, a measure of terrain rugosity commonly used in habitat " +
+		"and terrain complexity analyses. The surface area is estimated with the method of " +
+		"Jenness (2004), Wildlife Society Bulletin, 32(3), 829-839, which sums the areas of " +
+		"the portions of the eight 3D triangles that connect the centre of the cell to its " +
+		"neighbours lying within the cell. A flat surface has a ratio of 1. Neighbouring " +
+		"nodata cells and cells beyond the edge of the grid take the elevation of the centre " +
+		"cell. DEMs in geographic coordinates are assumed to have elevations in metres."
+	return ret
+}
+
+func (this *SurfaceAreaRatio) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *SurfaceAreaRatio) GetArgDescriptions() []ToolArg {
+	numArgs := 2
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input File name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	return ret
+}
+
+func (this *SurfaceAreaRatio) ParseArguments(args []string) {
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.Run()
+}
+
+func (this *SurfaceAreaRatio) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.Run()
+}
+
+func (this *SurfaceAreaRatio) Run() {
+	start1 := time.Now()
+
+	var progress, oldProgress int
+	var eta progressETA
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	rowsLessOne := rows - 1
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+	isGeographic := rin.IsInGeographicCoordinates() && rin.North <= 90 && rin.South >= -90
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "spectrum.pal"
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numCPUs := this.toolManager.numThreads()
+	c1 := make(chan bool, rows)
+	runtime.GOMAXPROCS(numCPUs)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// calculate the surface area ratio
+	printf("\r                                                    ")
+	printf("\rProgress: %v%%", 0)
+	startingRow := 0
+	var rowBlockSize int = rows / numCPUs
+
+	for startingRow < rows {
+		endingRow := startingRow + rowBlockSize
+		if endingRow >= rows {
+			endingRow = rows - 1
+		}
+		wg.Add(1)
+		go func(rowSt, rowEnd int) {
+			defer wg.Done()
+			var z, zN, resX, resY, area float64
+			var a, b, c float64
+			dX := [8]int{1, 1, 1, 0, -1, -1, -1, 0}
+			dY := [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
+			N := [8]float64{}
+			// horizontal lengths of the radial and outer triangle edges
+			radial := [8]float64{}
+			outer := [8]float64{}
+			for row := rowSt; row <= rowEnd; row++ {
+				if interrupted() {
+					c1 <- true
+					continue
+				}
+				resX, resY = cellSizeX, cellSizeY
+				if isGeographic {
+					lat := rin.North - (float64(row)+0.5)*cellSizeY
+					resX = cellSizeX * 111320.0 * math.Cos(lat*DegToRad)
+					resY = cellSizeY * 110574.0
+				}
+				for n := 0; n < 8; n++ {
+					m := (n + 1) % 8
+					radial[n] = math.Hypot(float64(dX[n])*resX, float64(dY[n])*resY)
+					outer[n] = math.Hypot(float64(dX[n]-dX[m])*resX, float64(dY[n]-dY[m])*resY)
+				}
+				planimetricArea := resX * resY
+				floatData := make([]float64, columns)
+				for col := 0; col < columns; col++ {
+					z = rin.Value(row, col)
+					if z != nodata {
+						for n := 0; n < 8; n++ {
+							zN = rin.Value(row+dY[n], col+dX[n])
+							if zN != nodata {
+								N[n] = zN
+							} else {
+								N[n] = z
+							}
+						}
+						area = 0
+						for n := 0; n < 8; n++ {
+							m := (n + 1) % 8
+							// the part of each triangle within the cell has half-length edges
+							a = math.Hypot(radial[n], N[n]-z) / 2.0
+							b = math.Hypot(radial[m], N[m]-z) / 2.0
+							c = math.Hypot(outer[n], N[n]-N[m]) / 2.0
+							area += heronArea(a, b, c)
+						}
+						floatData[col] = area / planimetricArea
+					} else {
+						floatData[col] = nodata
+					}
+				}
+				rout.SetRowValues(row, floatData)
+				c1 <- true // row completed
+			}
+
+		}(startingRow, endingRow)
+		startingRow = endingRow + 1
+	}
+
+	oldProgress = 0
+	for rowsCompleted := 0; rowsCompleted < rows; rowsCompleted++ {
+		<-c1 // a row has successfully completed
+		progress = int(100.0 * float64(rowsCompleted) / float64(rowsLessOne))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	wg.Wait()
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by SurfaceAreaRatio tool (%s)", this.toolManager.toolVersion(this)))
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// Returns the area of a triangle from the lengths of its sides.
+func heronArea(a, b, c float64) float64 {
+	s := (a + b + c) / 2.0
+	v := s * (s - a) * (s - b) * (s - c)
+	if v <= 0 {
+		return 0
+	}
+	return math.Sqrt(v)
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tableWriter writes tabular results as CSV. Rows are written as they are
+// produced rather than collected, so that long tables, e.g. one row per
+// sample point, need not be held in memory. Fields are quoted where needed,
+// so that labels and file names may contain commas, quotes or line breaks.
+// The methods of a nil tableWriter do nothing, so that tools can write to an
+// optional report without checking for it at every row.
+type tableWriter struct {
+	w *csv.Writer
+	c io.Closer
+}
+
+// newTableWriter returns a tableWriter that writes to w, having written the
+// header row, if any.
+func newTableWriter(w io.Writer, header ...string) (*tableWriter, error) {
+	t := &tableWriter{w: csv.NewWriter(w)}
+	if len(header) > 0 {
+		if err := t.w.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// createTable creates the CSV file fileName and writes its header row.
+func createTable(fileName string, header ...string) (*tableWriter, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTableWriter(f, header...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.c = f
+	return t, nil
+}
+
+// openReport creates the report file that was requested with the -report
+// flag and writes its header row. It returns nil, and no error, if no report
+// was requested. A report file without a path is placed in the working
+// directory and one without an extension is given .csv.
+func (ptm *PluginToolManager) openReport(header ...string) (*tableWriter, error) {
+	if ptm == nil || ptm.ReportFile == "" {
+		return nil, nil
+	}
+	fileName := ptm.ReportFile
+	if !strings.Contains(fileName, pathSep) {
+		fileName = ptm.workingDirectory + fileName
+	}
+	if filepath.Ext(fileName) == "" {
+		fileName += ".csv"
+	}
+	return createTable(fileName, header...)
+}
+
+// writeRow writes a row of fields, which are formatted by formatField. A row
+// without fields writes a blank line, e.g. between the sections of a report.
+func (t *tableWriter) writeRow(fields ...interface{}) error {
+	if t == nil {
+		return nil
+	}
+	record := make([]string, len(fields))
+	for i, v := range fields {
+		record[i] = formatField(v)
+	}
+	return t.w.Write(record)
+}
+
+// writeRecord writes a row of fields that are already formatted.
+func (t *tableWriter) writeRecord(fields []string) error {
+	if t == nil {
+		return nil
+	}
+	return t.w.Write(fields)
+}
+
+// close flushes the table and closes its file, if the tableWriter created it.
+func (t *tableWriter) close() error {
+	if t == nil {
+		return nil
+	}
+	t.w.Flush()
+	err := t.w.Error()
+	if t.c != nil {
+		if cerr := t.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// formatField formats a value for a table. Floating-point values are written
+// with the fewest digits that represent them exactly, in decimal notation
+// unless they are very small or very large, so that coordinates such as
+// northings are not written with exponents.
+func formatField(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return formatFloat(x, 64)
+	case float32:
+		return formatFloat(float64(x), 32)
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatFloat(v float64, bitSize int) string {
+	if a := math.Abs(v); a != 0 && (a < 1e-4 || a >= 1e21) {
+		return strconv.FormatFloat(v, 'g', -1, bitSize)
+	}
+	return strconv.FormatFloat(v, 'f', -1, bitSize)
+}
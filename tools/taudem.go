@@ -0,0 +1,56 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Conventions of the TauDEM hydrology tools, used for flow-related outputs
+// when the tool manager is in TauDEM mode.
+const (
+	taudemPointerNodata = -32768.0
+	taudemAreaNodata    = -1.0
+)
+
+var taudemElevationNodata = -math.MaxFloat32
+
+// taudemFileName returns the name that TauDEM gives to an output derived from
+// inputFile, e.g. the suffix "ad8" turns dem.tif into demad8.tif. The file is
+// placed in outputDir, or alongside the input if outputDir is empty.
+func taudemFileName(inputFile, outputDir, suffix string) string {
+	base := filepath.Base(inputFile)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if outputDir == "" {
+		outputDir = filepath.Dir(inputFile)
+	}
+	return filepath.Join(outputDir, base+suffix+".tif")
+}
+
+// taudemOutputRequired reports whether the output file argument of a tool
+// that uses taudemOutputFile must be given. In TauDEM mode, it may be omitted.
+func taudemOutputRequired(tm *PluginToolManager) bool {
+	return tm == nil || !tm.TauDEMMode
+}
+
+// taudemOutputFile replaces an output file argument that is blank, "not
+// specified", or a directory with the TauDEM name for the output. Other
+// names are returned unchanged.
+func taudemOutputFile(inputFile, outputFile, suffix string) string {
+	outputFile = strings.TrimSpace(outputFile)
+	if outputFile == "" || outputFile == "not specified" {
+		return taudemFileName(inputFile, "", suffix)
+	}
+	if strings.HasSuffix(outputFile, pathSep) {
+		return taudemFileName(inputFile, outputFile, suffix)
+	}
+	if fi, err := os.Stat(outputFile); err == nil && fi.IsDir() {
+		return taudemFileName(inputFile, outputFile, suffix)
+	}
+	return outputFile
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"math"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// metricCellSizes returns the cell sizes of a raster in the units of its XY
+// coordinates, or in metres, at its mid-latitude, if it is in geographic
+// coordinates, so that gradients of elevations in metres are unitless. Since
+// a raster without a CRS is assumed to be geographic, a raster is only taken
+// to be so if its extent is also within the range of longitudes and latitudes.
+func metricCellSizes(r *raster.Raster) (cellSizeX, cellSizeY float64) {
+	cellSizeX = r.GetCellSizeX()
+	cellSizeY = r.GetCellSizeY()
+	lonLat := r.West >= -180 && r.East <= 360 && r.South >= -90 && r.North <= 90
+	if r.IsInGeographicCoordinates() && lonLat {
+		midLat := (r.North + r.South) / 2.0
+		cellSizeX *= 111320.0 * math.Cos(math.Pi/180.0*midLat)
+		cellSizeY *= 111320.0
+	}
+	return cellSizeX, cellSizeY
+}
+
+// surfaceDerivatives holds the partial derivatives of elevation at a cell,
+// with x increasing to the east and y to the north.
+type surfaceDerivatives struct {
+	p, q    float64 // dz/dx and dz/dy
+	r, s, t float64 // d2z/dx2, d2z/dxdy and d2z/dy2
+}
+
+// hornDerivatives returns the first derivatives at a DEM cell by the
+// third-order finite difference of Horn (1981), which weights the cells to
+// the side of the cell twice as heavily as those on its diagonals, or false
+// if the cell is nodata. The second derivatives are left zero. Nodata
+// neighbours take the value of the cell.
+func hornDerivatives(dem *raster.Raster, row, col int, cellSizeX, cellSizeY float64) (surfaceDerivatives, bool) {
+	N, ok := neighbourhood(dem, row, col)
+	if !ok {
+		return surfaceDerivatives{}, false
+	}
+	var d surfaceDerivatives
+	d.p = (N[2] - N[4] + 2*(N[1]-N[5]) + N[0] - N[6]) / (8 * cellSizeX)
+	d.q = (N[6] - N[4] + 2*(N[7]-N[3]) + N[0] - N[2]) / (8 * cellSizeY)
+	return d, true
+}
+
+// neighbourhood returns the values of the neighbours of a DEM cell, indexed
+// 0 = NE, 1 = E, ... 7 = N, with nodata neighbours taking the value of the
+// cell, or false if the cell is nodata.
+func neighbourhood(dem *raster.Raster, row, col int) ([8]float64, bool) {
+	var N [8]float64
+	nodata := dem.NoDataValue
+	z := dem.Value(row, col)
+	if z == nodata {
+		return N, false
+	}
+	for n := 0; n < 8; n++ {
+		if N[n] = dem.Value(row+d8DY[n], col+d8DX[n]); N[n] == nodata {
+			N[n] = z
+		}
+	}
+	return N, true
+}
+
+// evansDerivatives returns the derivatives at a DEM cell of the quadratic
+// surface fitted by least squares to its 3 x 3 neighbourhood (Evans, 1979),
+// or false if the cell is nodata. Nodata neighbours take the value of the
+// cell.
+func evansDerivatives(dem *raster.Raster, row, col int, cellSizeX, cellSizeY float64) (surfaceDerivatives, bool) {
+	N, ok := neighbourhood(dem, row, col)
+	if !ok {
+		return surfaceDerivatives{}, false
+	}
+	z := dem.Value(row, col)
+	var d surfaceDerivatives
+	d.p = (N[0] + N[1] + N[2] - N[6] - N[5] - N[4]) / (6.0 * cellSizeX)
+	d.q = (N[6] + N[7] + N[0] - N[4] - N[3] - N[2]) / (6.0 * cellSizeY)
+	d.r = (N[6] + N[0] + N[5] + N[1] + N[4] + N[2] - 2.0*(N[7]+z+N[3])) / (3.0 * cellSizeX * cellSizeX)
+	d.t = (N[6] + N[7] + N[0] + N[4] + N[3] + N[2] - 2.0*(N[5]+z+N[1])) / (3.0 * cellSizeY * cellSizeY)
+	d.s = (N[0] + N[4] - N[6] - N[2]) / (4.0 * cellSizeX * cellSizeY)
+	return d, true
+}
+
+// slope returns the slope gradient in degrees.
+func (d surfaceDerivatives) slope() float64 {
+	return math.Atan(math.Sqrt(d.p*d.p+d.q*d.q)) * RadToDeg
+}
+
+// aspect returns the direction that the slope faces, in degrees clockwise
+// from north, or -1 where it is level.
+func (d surfaceDerivatives) aspect() float64 {
+	if d.p == 0 {
+		if d.q < 0 {
+			return 0
+		} else if d.q > 0 {
+			return 180
+		}
+		return -1
+	}
+	// the slope faces down the gradient, to the west where p is positive
+	return 180 - math.Atan(d.q/d.p)*RadToDeg + 90*(d.p/math.Abs(d.p))
+}
+
+// profileCurvature returns the curvature of the surface along the line of
+// steepest slope, in radians per unit of distance, i.e. the rate at which
+// the slope angle changes downslope. It is positive where the slope is
+// convex, steepening downslope, e.g. on shoulders, and negative where it is
+// concave, and zero on level ground.
+func (d surfaceDerivatives) profileCurvature() float64 {
+	g := d.p*d.p + d.q*d.q
+	if g == 0 {
+		return 0
+	}
+	return -(d.p*d.p*d.r + 2.0*d.p*d.q*d.s + d.q*d.q*d.t) / (g * math.Pow(1.0+g, 1.5))
+}
+
+// planCurvature returns the curvature of the contour through the cell, in
+// radians per unit of distance, i.e. the rate at which the aspect changes
+// along the contour. It is positive where flow diverges, e.g. on noses and
+// ridges, and negative where it converges, e.g. in hollows, and zero on
+// level ground.
+func (d surfaceDerivatives) planCurvature() float64 {
+	g := d.p*d.p + d.q*d.q
+	if g == 0 {
+		return 0
+	}
+	return -(d.q*d.q*d.r - 2.0*d.p*d.q*d.s + d.p*d.p*d.t) / math.Pow(g, 1.5)
+}
+
+// tangentialCurvature returns the curvature of the surface in the direction
+// of the contour, in the plane normal to the surface, in radians per unit of
+// distance; it is the plan curvature times the sine of the slope, and has the
+// same sign, positive where flow diverges.
+func (d surfaceDerivatives) tangentialCurvature() float64 {
+	g := d.p*d.p + d.q*d.q
+	if g == 0 {
+		return 0
+	}
+	return -(d.q*d.q*d.r - 2.0*d.p*d.q*d.s + d.p*d.p*d.t) / (g * math.Sqrt(1.0+g))
+}
@@ -0,0 +1,341 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// TileIndex writes the extents of the rasters in a directory to a CSV file.
+type TileIndex struct {
+	inputDirectory string
+	outputFile     string
+	toolManager    *PluginToolManager
+}
+
+func (this *TileIndex) GetName() string {
+	s := "TileIndex"
+	return getFormattedToolName(s)
+}
+
+func (this *TileIndex) GetDescription() string {
+	s := "Writes an index of the raster tiles in a directory"
+	return getFormattedToolDescription(s)
+}
+
+func (this *TileIndex) GetVersion() string {
+	return "1.0"
+}
+
+func (this *TileIndex) GetHelpDocumentation() string {
+	ret := "This tool scans a directory for raster files of the supported formats and writes " +
+		"an index of them to a CSV file, with one row per tile giving the file name, the " +
+		"numbers of rows and columns, the north, south, east and west edges, the cell sizes " +
+		"and EPSG code, and the footprint of the tile as a WKT POLYGON, so that the index can " +
+		"be loaded as a vector layer of tile outlines. Only the files of the directory itself " +
+		"are scanned, in alphabetical order; the data files of formats with separate header " +
+		"files, e.g. Whitebox .tas files, are not listed separately. The directory may instead " +
+		"be a ZIP archive of tiles, which are then read from within it without being extracted, " +
+		"and indexed with names such as tiles.zip/tile_015.asc. The index is the input " +
+		"of the BatchTiles tool, which runs another tool on every tile."
+	return ret
+}
+
+func (this *TileIndex) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *TileIndex) GetArgDescriptions() []ToolArg {
+	numArgs := 2
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDirectory"
+	ret[0].Type = "string"
+	ret[0].Description = "The directory containing the raster tiles"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output CSV file name, with directory and extension"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	return ret
+}
+
+func (this *TileIndex) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input directory and output file must be specified.")
+		return
+	}
+	if !this.setInputDirectory(args[0]) {
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.Run()
+}
+
+func (this *TileIndex) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input directory
+	print("Enter the directory containing the tiles: ")
+	inputDirectory, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setInputDirectory(inputDirectory) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output CSV file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	this.Run()
+}
+
+func (this *TileIndex) setInputDirectory(s string) bool {
+	dir := strings.TrimSpace(s)
+	if dir == "." {
+		dir = this.toolManager.workingDirectory
+	} else if !strings.Contains(dir, pathSep) {
+		dir = this.toolManager.workingDirectory + dir
+	}
+	if fi, err := os.Stat(dir); err != nil || !(fi.IsDir() || isZipArchive(dir)) {
+		printf("no such directory: %s\n", dir)
+		return false
+	}
+	this.inputDirectory = dir
+	return true
+}
+
+// isZipArchive reports whether a file is a ZIP archive, from within which
+// tiles can be read.
+func isZipArchive(fileName string) bool {
+	fi, err := os.Stat(fileName)
+	return err == nil && fi.Mode().IsRegular() && strings.ToLower(filepath.Ext(fileName)) == ".zip"
+}
+
+func (this *TileIndex) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	this.outputFile = outputFile
+}
+
+func (this *TileIndex) Run() {
+	start1 := time.Now()
+
+	var candidates []string
+	var err error
+	if isZipArchive(this.inputDirectory) {
+		// the tiles are read from within the archive
+		if candidates, err = raster.ListZipArchive(this.inputDirectory); err != nil {
+			println(err.Error())
+			return
+		}
+	} else {
+		entries, err := ioutil.ReadDir(this.inputDirectory)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		for _, fi := range entries {
+			if !fi.IsDir() {
+				candidates = append(candidates, fi.Name())
+			}
+		}
+	}
+	names := make([]string, 0)
+	for _, name := range candidates {
+		if tileCompanionExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		if rt, err := raster.DetermineRasterFormat(filepath.Join(this.inputDirectory, name)); err != nil || rt == raster.RT_UnknownRaster {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	println("Reading raster tiles...")
+	tiles := make([]tileRecord, 0, len(names))
+	for i, name := range names {
+		fileName := filepath.Join(this.inputDirectory, name)
+		if fileName == this.outputFile {
+			continue
+		}
+		r, err := raster.CreateRasterFromFile(fileName, raster.RasterConfig{HeaderOnly: true})
+		if err != nil {
+			printf("\nWarning: %s could not be read and is not indexed.\n", name)
+			continue
+		}
+		tiles = append(tiles, tileRecord{
+			fileName:  fileName,
+			rows:      r.Rows,
+			columns:   r.Columns,
+			north:     r.North,
+			south:     r.South,
+			east:      r.East,
+			west:      r.West,
+			cellSizeX: r.GetCellSizeX(),
+			cellSizeY: r.GetCellSizeY(),
+			epsg:      r.GetRasterConfig().EPSGCode,
+		})
+		printf("\rProgress: %v%%", int(100.0*float64(i+1)/float64(len(names))))
+	}
+	println("")
+	if len(tiles) == 0 {
+		println("No raster tiles were found in the directory.")
+		return
+	}
+
+	start2 := time.Now()
+
+	if err = writeTileIndex(this.outputFile, tiles); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// the combined extent of the tiles
+	var extent raster.Bounds
+	epsgCodes := make(map[int]bool)
+	for _, t := range tiles {
+		extent = extent.Union(t.bounds())
+		epsgCodes[t.epsg] = true
+	}
+
+	elapsed := time.Since(start2)
+	printf("Number of tiles indexed: %v\n", len(tiles))
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	printf("Combined extent: N %v, S %v, E %v, W %v\n", format(extent.North), format(extent.South), format(extent.East), format(extent.West))
+	if len(epsgCodes) > 1 {
+		println("Warning: the tiles do not share a single coordinate system.")
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// The extensions of the data files of raster formats that are opened through
+// a separate header file, and which are therefore not indexed.
+var tileCompanionExtensions = map[string]bool{".tas": true, ".hdr": true, ".rdc": true, ".sdat": true}
+
+// tileRecord is the entry for one raster tile in a tile index.
+type tileRecord struct {
+	fileName                 string
+	rows, columns            int
+	north, south, east, west float64
+	cellSizeX, cellSizeY     float64
+	epsg                     int
+}
+
+// bounds returns the extent of the tile.
+func (t tileRecord) bounds() raster.Bounds {
+	return raster.Bounds{North: t.north, South: t.south, East: t.east, West: t.west}
+}
+
+var tileIndexHeader = []string{"file", "rows", "columns", "north", "south", "east", "west",
+	"cell_size_x", "cell_size_y", "epsg", "wkt"}
+
+// writeTileIndex writes a tile index to a CSV file. Tile file names are
+// written relative to the directory of the index where possible.
+func writeTileIndex(fileName string, tiles []tileRecord) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	w := csv.NewWriter(f)
+	w.Write(tileIndexHeader)
+	for _, t := range tiles {
+		wkt := fmt.Sprintf("POLYGON ((%[4]s %[1]s, %[3]s %[1]s, %[3]s %[2]s, %[4]s %[2]s, %[4]s %[1]s))",
+			format(t.north), format(t.south), format(t.east), format(t.west))
+		name := t.fileName
+		if rel, err := filepath.Rel(filepath.Dir(fileName), t.fileName); err == nil {
+			name = rel
+		}
+		w.Write([]string{name, strconv.Itoa(t.rows), strconv.Itoa(t.columns),
+			format(t.north), format(t.south), format(t.east), format(t.west),
+			format(t.cellSizeX), format(t.cellSizeY), strconv.Itoa(t.epsg), wkt})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// readTileIndex reads a tile index written by the TileIndex tool. Relative
+// file names are interpreted relative to the directory of the index.
+func readTileIndex(fileName string) ([]tileRecord, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 || len(records[0]) < 10 || records[0][0] != "file" {
+		return nil, errors.New("The file is not a tile index.")
+	}
+	tiles := make([]tileRecord, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		var t tileRecord
+		var errs [9]error
+		t.fileName = rec[0]
+		if !filepath.IsAbs(t.fileName) {
+			t.fileName = filepath.Join(filepath.Dir(fileName), t.fileName)
+		}
+		t.rows, errs[0] = strconv.Atoi(rec[1])
+		t.columns, errs[1] = strconv.Atoi(rec[2])
+		t.north, errs[2] = strconv.ParseFloat(rec[3], 64)
+		t.south, errs[3] = strconv.ParseFloat(rec[4], 64)
+		t.east, errs[4] = strconv.ParseFloat(rec[5], 64)
+		t.west, errs[5] = strconv.ParseFloat(rec[6], 64)
+		t.cellSizeX, errs[6] = strconv.ParseFloat(rec[7], 64)
+		t.cellSizeY, errs[7] = strconv.ParseFloat(rec[8], 64)
+		t.epsg, errs[8] = strconv.Atoi(rec[9])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read line %v of the tile index.", i+2)
+			}
+		}
+		tiles = append(tiles, t)
+	}
+	return tiles, nil
+}
@@ -0,0 +1,333 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// TimeAreaDiagram writes the time-area histogram of the watershed of an
+// outlet, the areas that drain to it within successive travel times, and
+// the unit hydrograph of the time-area method.
+type TimeAreaDiagram struct {
+	inputFile    string
+	outputFile   string
+	outletX      float64
+	outletY      float64
+	interval     float64
+	velocity     float64
+	minVelocity  float64
+	velocityFile string // empty for a constant velocity coefficient
+	toolManager  *PluginToolManager
+}
+
+func (this *TimeAreaDiagram) GetName() string {
+	s := "TimeAreaDiagram"
+	return getFormattedToolName(s)
+}
+
+func (this *TimeAreaDiagram) GetDescription() string {
+	s := "Writes the time-area histogram of an outlet's watershed"
+	return getFormattedToolDescription(s)
+}
+
+func (this *TimeAreaDiagram) GetVersion() string {
+	return "1.0"
+}
+
+func (this *TimeAreaDiagram) GetHelpDocumentation() string {
+	ret := "This tool writes the time-area histogram of the watershed of an outlet, the " +
+		"DEM cell containing the point OutletX, OutletY, to a CSV file. The watershed is " +
+		"the area that drains to the outlet along D8 flowpaths, and the travel time of " +
+		"each of its cells is the time that flow takes to reach the outlet, calculated as " +
+		"by the Isochrones tool from the Velocity, MinVelocity and optional VelocityFile. " +
+		"The outlet isn't snapped to a stream, so it should be placed on the channel, e.g. " +
+		"in a cell of high flow accumulation; the area of the watershed is printed. Each " +
+		"row of the table is an isochrone zone of the Interval, in seconds for velocities " +
+		"in metres per second, with its START and END times, its CELLS and AREA, in square " +
+		"metres for DEMs in geographic coordinates and squared map units otherwise, the " +
+		"CUM_AREA and CUM_FRACTION of the watershed draining within its END time, and the " +
+		"ordinate Q_PER_MM of the unit hydrograph of the time-area method at its END time, " +
+		"the discharge (AREA x 0.001 / Interval, in cubic map units per second) from 1 mm " +
+		"of rainfall excess over each interval."
+	return ret
+}
+
+func (this *TimeAreaDiagram) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *TimeAreaDiagram) GetArgDescriptions() []ToolArg {
+	numArgs := 8
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output CSV filename, with directory and file extension"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	ret[2].Name = "OutletX"
+	ret[2].Type = "float64"
+	ret[2].Description = "The x coordinate of the outlet"
+	ret[2].Required = true
+
+	ret[3].Name = "OutletY"
+	ret[3].Type = "float64"
+	ret[3].Description = "The y coordinate of the outlet"
+	ret[3].Required = true
+
+	ret[4].Name = "Interval"
+	ret[4].Type = "float64"
+	ret[4].Description = "The travel time interval of the histogram"
+	ret[4].Required = true
+
+	ret[5].Name = "Velocity"
+	ret[5].Type = "float64"
+	ret[5].Description = "The velocity at a gradient of 1"
+	ret[5].Default = "1.0"
+
+	ret[6].Name = "MinVelocity"
+	ret[6].Type = "float64"
+	ret[6].Description = "The minimum velocity, e.g. on gentle slopes"
+	ret[6].Default = "0.01"
+
+	ret[7].Name = "VelocityFile"
+	ret[7].Type = "string"
+	ret[7].Description = "The optional raster of velocities at a gradient of 1, e.g. from land cover"
+	ret[7].Role = ArgInput
+
+	return ret
+}
+
+func (this *TimeAreaDiagram) EstimateMemory(rows, columns int) int64 {
+	// the DEM and velocity rasters, the flow directions, the coefficients, and
+	// the travel times of travelTimes and their resolved flags
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+1+8+8+1)
+}
+
+func (this *TimeAreaDiagram) ParseArguments(args []string) {
+	if len(args) < 5 {
+		println("The input DEM, output file, outlet coordinates, and interval must be specified.")
+		return
+	}
+	var ok bool
+	if this.inputFile, ok = this.inputName(args[0]); !ok {
+		return
+	}
+	this.outputFile = this.outputName(args[1])
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	var err error
+	this.velocity, this.minVelocity = 1.0, 0.01
+	values := []*float64{&this.outletX, &this.outletY, &this.interval, &this.velocity, &this.minVelocity}
+	for i, v := range values {
+		if specified(i + 2) {
+			if *v, err = strconv.ParseFloat(strings.TrimSpace(args[i+2]), 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+	this.velocityFile = ""
+	if specified(7) {
+		if this.velocityFile, ok = this.inputName(args[7]); !ok {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TimeAreaDiagram) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+	readLine := func(prompt string) string {
+		print(prompt)
+		str, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		return strings.TrimSpace(str)
+	}
+
+	var ok bool
+	if this.inputFile, ok = this.inputName(readLine("Enter the DEM file name (incl. file extension): ")); !ok {
+		return
+	}
+	this.outputFile = this.outputName(readLine("Enter the output CSV file name: "))
+
+	// get the outlet, the interval and the velocities
+	var err error
+	this.velocity, this.minVelocity = 1.0, 0.01
+	prompts := []string{"Outlet x coordinate: ", "Outlet y coordinate: ", "Travel time interval: ",
+		"Velocity at a gradient of 1 (default 1.0): ", "Minimum velocity (default 0.01): "}
+	values := []*float64{&this.outletX, &this.outletY, &this.interval, &this.velocity, &this.minVelocity}
+	for i, v := range values {
+		str := readLine(prompts[i])
+		if str == "" && i > 2 {
+			continue
+		}
+		if *v, err = strconv.ParseFloat(str, 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the optional velocity file
+	this.velocityFile = ""
+	if str := readLine("Enter the velocity file name (blank for none): "); str != "" {
+		if this.velocityFile, ok = this.inputName(str); !ok {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TimeAreaDiagram) inputName(s string) (string, bool) {
+	fileName := strings.TrimSpace(s)
+	if !strings.Contains(fileName, pathSep) {
+		fileName = this.toolManager.workingDirectory + fileName
+	}
+	if !raster.FileExists(fileName) {
+		printf("no such file or directory: %s\n", fileName)
+		return fileName, false
+	}
+	return fileName, true
+}
+
+func (this *TimeAreaDiagram) outputName(s string) string {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile += ".csv"
+	}
+	return outputFile
+}
+
+func (this *TimeAreaDiagram) Run() {
+	start1 := time.Now()
+
+	if this.interval <= 0 || this.velocity <= 0 || this.minVelocity <= 0 {
+		println("The interval, velocity and minimum velocity must be greater than zero.")
+		return
+	}
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	var velocities *raster.Raster
+	if this.velocityFile != "" {
+		if velocities, err = raster.CreateRasterFromFile(this.velocityFile); err != nil {
+			println(err.Error())
+			return
+		}
+		if !onSameGrid(dem, velocities) {
+			println("The DEM and velocity rasters must have the same dimensions.")
+			return
+		}
+	}
+
+	start2 := time.Now()
+
+	k := velocityCoefficients(rows, columns, velocities, this.velocity)
+	counts, err := timeAreaHistogram(dem, k, this.minVelocity, this.outletX, this.outletY, this.interval)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	printf("\r                                                           ")
+	printf("\rSaving data...\n")
+	cellSizeX, cellSizeY := metricCellSizes(dem)
+	cellArea := cellSizeX * cellSizeY
+	numCells := 0
+	for _, n := range counts {
+		numCells += n
+	}
+	totalArea := float64(numCells) * cellArea
+	table, err := createTable(this.outputFile, "ZONE", "START", "END", "CELLS", "AREA",
+		"CUM_AREA", "CUM_FRACTION", "Q_PER_MM")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	cumArea := 0.0
+	for i, n := range counts {
+		area := float64(n) * cellArea
+		cumArea += area
+		table.writeRow(i+1, float64(i)*this.interval, float64(i+1)*this.interval,
+			n, area, cumArea, cumArea/totalArea, area*0.001/this.interval)
+	}
+	if err = table.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	elapsed := time.Since(start2)
+
+	printf("Watershed area: %v (%v cells)\n", formatField(totalArea), numCells)
+	printf("Isochrone zones: %v\n", len(counts))
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// timeAreaHistogram returns the numbers of cells of the watershed of the DEM
+// cell containing the point x, y that drain to it within successive intervals
+// of travel time, calculated by travelTimes with the velocity coefficients k.
+func timeAreaHistogram(dem *raster.Raster, k []float64, minVelocity, x, y, interval float64) ([]int, error) {
+	rows := dem.Rows
+	columns := dem.Columns
+	row := int(math.Floor((dem.North - y) / dem.GetCellSizeY()))
+	col := int(math.Floor((x - dem.West) / dem.GetCellSizeX()))
+	if row < 0 || row >= rows || col < 0 || col >= columns || dem.Value(row, col) == dem.NoDataValue {
+		return nil, errors.New("The outlet must be within the DEM, at a cell with a value.")
+	}
+	fd := FlowDirectionsFromDEM(dem)
+	times := travelTimes(dem, fd, k, minVelocity, nil)
+
+	// every path from the watershed passes through the outlet, so the time to
+	// the outlet is the difference of the times to the end of the path
+	outletTime := times[row*columns+col]
+	counts := make([]int, 0)
+	for _, i := range fd.UpslopeCells(row, col, nil) {
+		zone := int(math.Max(times[i]-outletTime, 0) / interval)
+		for len(counts) <= zone {
+			counts = append(counts, 0)
+		}
+		counts[zone]++
+	}
+	return counts, nil
+}
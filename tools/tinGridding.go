@@ -0,0 +1,615 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// TINGridding interpolates the points of an x,y,z text file onto a raster by
+// linear interpolation within their Delaunay triangulation, optionally
+// constrained by 3D breaklines.
+type TINGridding struct {
+	inputFile     string
+	outputFile    string
+	breaklineFile string
+	cellSize      float64
+	maxEdge       float64 // zero for no limit
+	epsgCode      int
+	toolManager   *PluginToolManager
+}
+
+func (this *TINGridding) GetName() string {
+	s := "TINGridding"
+	return getFormattedToolName(s)
+}
+
+func (this *TINGridding) GetDescription() string {
+	s := "Interpolates x,y,z points and breaklines to a raster with a TIN"
+	return getFormattedToolDescription(s)
+}
+
+func (this *TINGridding) GetVersion() string {
+	return "1.0"
+}
+
+func (this *TINGridding) GetHelpDocumentation() string {
+	ret := "This tool interpolates the points of a text file containing one 'x y z' (or " +
+		"'x,y,z') point per line, as read by the ReadXYZ tool, onto a raster with the " +
+		"specified CellSize by linear interpolation within the triangles of their Delaunay " +
+		"triangulation (TIN). The optional BreaklineFile is a PolyLineZ shapefile of 3D " +
+		"breaklines, e.g. the crests and toes of embankments or the banks of channels, " +
+		"each part of a polyline being a breakline with the elevations of its vertices. " +
+		"The breaklines are densified to vertices at half the " +
+		"cell size, which are triangulated with the points, so that the triangles don't cross " +
+		"them, and the cells that they cross are then set to their elevations, interpolated " +
+		"linearly along the lines, as by the 'breakline' operation of the EditDEM tool. " +
+		"Cells outside of the triangulation, or within triangles with an edge longer than the " +
+		"optional MaxEdgeLength, e.g. across gaps in the data, are nodata. Points at the same " +
+		"location are averaged. The grid covers the points and breaklines, which are treated " +
+		"as cell centres as in ReadXYZ, and the optional EPSG code specifies their coordinate " +
+		"reference system."
+	return ret
+}
+
+func (this *TINGridding) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *TINGridding) GetArgDescriptions() []ToolArg {
+	numArgs := 6
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input x,y,z text file name, with directory and extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "CellSize"
+	ret[2].Type = "float64"
+	ret[2].Description = "The output grid resolution"
+	ret[2].Required = true
+
+	ret[3].Name = "BreaklineFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The 3D breakline shapefile name, with directory and extension"
+	ret[3].Role = ArgInput
+
+	ret[4].Name = "MaxEdgeLength"
+	ret[4].Type = "float64"
+	ret[4].Description = "The longest triangle edge that is interpolated, in map units"
+
+	ret[5].Name = "EPSG"
+	ret[5].Type = "int"
+	ret[5].Description = "The EPSG code of the points"
+
+	return ret
+}
+
+func (this *TINGridding) EstimateMemory(rows, columns int) int64 {
+	// the output raster
+	return gridBytes(rows, columns, rasterBytesPerCell)
+}
+
+func (this *TINGridding) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and cell size must be specified.")
+		return
+	}
+	var ok bool
+	if this.inputFile, ok = this.inputName(args[0]); !ok {
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+	if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64); err != nil {
+		println(err.Error())
+		return
+	}
+	specified := func(i int) bool {
+		return len(args) > i && len(strings.TrimSpace(args[i])) > 0 && args[i] != "not specified"
+	}
+
+	this.breaklineFile = ""
+	if specified(3) {
+		if this.breaklineFile, ok = this.inputName(args[3]); !ok {
+			return
+		}
+	}
+	this.maxEdge = 0
+	if specified(4) {
+		if this.maxEdge, err = strconv.ParseFloat(strings.TrimSpace(args[4]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+	this.epsgCode = 0
+	if specified(5) {
+		if this.epsgCode, err = strconv.Atoi(strings.TrimSpace(args[5])); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TINGridding) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the x,y,z text file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	var ok bool
+	if this.inputFile, ok = this.inputName(inputFile); !ok {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the cell size
+	print("Cell size: ")
+	cellSizeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.cellSize, err = strconv.ParseFloat(strings.TrimSpace(cellSizeStr), 64); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// get the breakline file name
+	print("Breakline shapefile name (blank for none): ")
+	breaklineFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.breaklineFile = ""
+	if len(strings.TrimSpace(breaklineFile)) > 0 {
+		if this.breaklineFile, ok = this.inputName(breaklineFile); !ok {
+			return
+		}
+	}
+
+	// get the maximum edge length
+	print("Maximum triangle edge length (blank for no limit): ")
+	maxEdgeStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.maxEdge = 0
+	if len(strings.TrimSpace(maxEdgeStr)) > 0 {
+		if this.maxEdge, err = strconv.ParseFloat(strings.TrimSpace(maxEdgeStr), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	// get the EPSG code
+	print("EPSG code of the points (optional): ")
+	epsgStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.epsgCode = 0
+	if len(strings.TrimSpace(epsgStr)) > 0 {
+		if this.epsgCode, err = strconv.Atoi(strings.TrimSpace(epsgStr)); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+// inputName returns the full name of an input file and whether it exists.
+func (this *TINGridding) inputName(s string) (string, bool) {
+	inputFile := strings.TrimSpace(s)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", inputFile)
+		return inputFile, false
+	}
+	return inputFile, true
+}
+
+func (this *TINGridding) Run() {
+	start1 := time.Now()
+
+	if this.cellSize <= 0 || this.maxEdge < 0 || this.epsgCode < 0 {
+		println("The cell size must be greater than zero and the maximum edge length and EPSG code can't be negative.")
+		return
+	}
+
+	println("Reading point data...")
+	xs, ys, zs, err := readXYZFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var breaklines [][][3]float64
+	if this.breaklineFile != "" {
+		println("Reading breaklines...")
+		if breaklines, err = readBreaklines(this.breaklineFile); err != nil {
+			println(err.Error())
+			return
+		}
+		printf("Number of breaklines: %v\n", len(breaklines))
+	}
+
+	start2 := time.Now()
+
+	// the breaklines are densified so that their segments are edges of the
+	// triangulation, unless points lie very close to them
+	spacing := this.cellSize / 2
+	for _, line := range breaklines {
+		for i := 1; i < len(line); i++ {
+			x0, y0, z0 := line[i-1][0], line[i-1][1], line[i-1][2]
+			dx, dy, dz := line[i][0]-x0, line[i][1]-y0, line[i][2]-z0
+			n := int(math.Ceil(math.Hypot(dx, dy) / spacing))
+			if i == 1 {
+				xs, ys, zs = append(xs, x0), append(ys, y0), append(zs, z0)
+			}
+			for k := 1; k <= n; k++ {
+				t := float64(k) / float64(n)
+				xs, ys, zs = append(xs, x0+t*dx), append(ys, y0+t*dy), append(zs, z0+t*dz)
+			}
+		}
+	}
+	xs, ys, zs = mergeCoincidentPoints(xs, ys, zs)
+	printf("Number of vertices: %v\n", len(xs))
+	if len(xs) < 3 {
+		println("At least three points at different locations are needed.")
+		return
+	}
+
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+
+	println("Triangulating...")
+	triangles := delaunayTriangulation(xs, ys)
+	printf("Number of triangles: %v\n", len(triangles))
+	if len(triangles) == 0 {
+		println("The points are collinear and can't be triangulated.")
+		return
+	}
+
+	cellSize := this.cellSize
+	columns := int(math.Floor((maxX-minX)/cellSize+0.5)) + 1
+	rows := int(math.Floor((maxY-minY)/cellSize+0.5)) + 1
+	west := minX - cellSize/2.0
+	north := maxY + cellSize/2.0
+	east := west + float64(columns)*cellSize
+	south := north - float64(rows)*cellSize
+	printf("Output grid: %v rows x %v columns\n", rows, columns)
+
+	// create the output raster
+	nodata := -32768.0
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.EPSGCode = this.epsgCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		north, south, east, west, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	// each triangle is interpolated at the cell centres within it
+	numSkipped := interpolateTriangles(rout, xs, ys, zs, triangles, this.maxEdge)
+	if numSkipped > 0 {
+		printf("Triangles longer than the maximum edge length: %v\n", numSkipped)
+	}
+
+	// the breaklines are burned in at their interpolated elevations
+	for _, line := range breaklines {
+		cells, lineZs := rasterizeBreakline(line, rout)
+		for i, cell := range cells {
+			rout.SetValue(cell[0], cell[1], lineZs[i])
+		}
+	}
+
+	println("Saving data...")
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by TINGridding tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Input file: %s", this.inputFile))
+	if this.breaklineFile != "" {
+		rout.AddMetadataEntry(fmt.Sprintf("Breakline file: %s", this.breaklineFile))
+	}
+	if this.maxEdge > 0 {
+		rout.AddMetadataEntry(fmt.Sprintf("Maximum edge length: %v", this.maxEdge))
+	}
+	rout.Save()
+
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// interpolateTriangles sets the cells of a raster whose centres lie within
+// the triangles of a triangulation by linear interpolation of the elevations
+// of their vertices, skipping triangles with an edge longer than maxEdge, if
+// it isn't zero, and returns the number skipped.
+func interpolateTriangles(rout *raster.Raster, xs, ys, zs []float64, triangles [][3]int, maxEdge float64) int {
+	north, west := rout.North, rout.West
+	cellSize := rout.GetCellSizeX()
+	numSkipped := 0
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	for t, tri := range triangles {
+		a, b, c := tri[0], tri[1], tri[2]
+		if maxEdge > 0 && (math.Hypot(xs[a]-xs[b], ys[a]-ys[b]) > maxEdge ||
+			math.Hypot(xs[b]-xs[c], ys[b]-ys[c]) > maxEdge ||
+			math.Hypot(xs[c]-xs[a], ys[c]-ys[a]) > maxEdge) {
+			numSkipped++
+			continue
+		}
+		det := (ys[b]-ys[c])*(xs[a]-xs[c]) + (xs[c]-xs[b])*(ys[a]-ys[c])
+		if det == 0 {
+			continue
+		}
+		tMinX := math.Min(xs[a], math.Min(xs[b], xs[c]))
+		tMaxX := math.Max(xs[a], math.Max(xs[b], xs[c]))
+		tMinY := math.Min(ys[a], math.Min(ys[b], ys[c]))
+		tMaxY := math.Max(ys[a], math.Max(ys[b], ys[c]))
+		row0 := int(math.Ceil((north-tMaxY)/cellSize - 0.5))
+		row1 := int(math.Floor((north-tMinY)/cellSize - 0.5))
+		col0 := int(math.Ceil((tMinX-west)/cellSize - 0.5))
+		col1 := int(math.Floor((tMaxX-west)/cellSize - 0.5))
+		for row := row0; row <= row1; row++ {
+			y := north - (float64(row)+0.5)*cellSize
+			for col := col0; col <= col1; col++ {
+				x := west + (float64(col)+0.5)*cellSize
+				// the barycentric coordinates of the cell centre
+				wa := ((ys[b]-ys[c])*(x-xs[c]) + (xs[c]-xs[b])*(y-ys[c])) / det
+				wb := ((ys[c]-ys[a])*(x-xs[c]) + (xs[a]-xs[c])*(y-ys[c])) / det
+				wc := 1 - wa - wb
+				if wa < -1e-9 || wb < -1e-9 || wc < -1e-9 {
+					continue
+				}
+				rout.SetValue(row, col, wa*zs[a]+wb*zs[b]+wc*zs[c])
+			}
+		}
+		progress = int(100.0 * float64(t+1) / float64(len(triangles)))
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	printf("\n")
+	return numSkipped
+}
+
+// delaunayTriangulation returns the Delaunay triangles of a set of distinct
+// points as counter-clockwise triples of point indices. It inserts the
+// points one at a time into a triangulation of an enclosing triangle
+// (Bowyer-Watson), in a spatially coherent order so that the walk from the
+// last new triangle to the triangle containing the next point is short.
+func delaunayTriangulation(xs, ys []float64) [][3]int {
+	n := len(xs)
+	// coordinates relative to the centre of the points, for precision
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i := range xs {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+	cx, cy := (minX+maxX)/2, (minY+maxY)/2
+	size := math.Max(maxX-minX, maxY-minY)
+	if size == 0 {
+		return nil
+	}
+	px := make([]float64, n+3)
+	py := make([]float64, n+3)
+	for i := range xs {
+		px[i], py[i] = xs[i]-cx, ys[i]-cy
+	}
+	// the enclosing triangle's vertices are n, n+1 and n+2
+	px[n], py[n] = -100*size, -100*size
+	px[n+1], py[n+1] = 100*size, -100*size
+	px[n+2], py[n+2] = 0, 100*size
+
+	orient := func(a, b int, x, y float64) float64 {
+		return (px[b]-px[a])*(y-py[a]) - (py[b]-py[a])*(x-px[a])
+	}
+	inCircle := func(a, b, c int, x, y float64) bool {
+		adx, ady := px[a]-x, py[a]-y
+		bdx, bdy := px[b]-x, py[b]-y
+		cdx, cdy := px[c]-x, py[c]-y
+		det := (adx*adx+ady*ady)*(bdx*cdy-cdx*bdy) -
+			(bdx*bdx+bdy*bdy)*(adx*cdy-cdx*ady) +
+			(cdx*cdx+cdy*cdy)*(adx*bdy-bdx*ady)
+		return det > 0
+	}
+
+	// the vertices of triangle t are v[t], counter-clockwise, and nbr[t][i]
+	// is the triangle across the edge opposite v[t][i], or -1
+	v := [][3]int{{n, n + 1, n + 2}}
+	nbr := [][3]int{{-1, -1, -1}}
+	dead := []bool{false}
+	free := make([]int, 0)
+
+	// the insertion order sorts the points by rows of buckets, alternating
+	// the direction of the rows
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	bucketSize := size / math.Max(1, math.Sqrt(float64(n)/4))
+	bucketRow := func(i int) int { return int((py[i] + size) / bucketSize) }
+	sort.Slice(order, func(a, b int) bool {
+		ra, rb := bucketRow(order[a]), bucketRow(order[b])
+		if ra != rb {
+			return ra < rb
+		}
+		if ra%2 == 1 {
+			return px[order[a]] > px[order[b]]
+		}
+		return px[order[a]] < px[order[b]]
+	})
+
+	last := 0
+	bad := make([]int, 0)
+	isBad := make(map[int]bool)
+	type edge struct{ a, b, outside int }
+	boundary := make([]edge, 0)
+	for _, p := range order {
+		x, y := px[p], py[p]
+
+		// walk to the triangle containing the point
+		t := last
+		for steps := 0; ; steps++ {
+			moved := false
+			for i := 0; i < 3; i++ {
+				a, b := v[t][(i+1)%3], v[t][(i+2)%3]
+				if orient(a, b, x, y) < 0 && nbr[t][i] >= 0 {
+					t = nbr[t][i]
+					moved = true
+					break
+				}
+			}
+			if !moved {
+				break
+			}
+			if steps > len(v) {
+				// the walk is cycling; search every triangle instead
+				for s := range v {
+					if !dead[s] && orient(v[s][0], v[s][1], x, y) >= 0 &&
+						orient(v[s][1], v[s][2], x, y) >= 0 && orient(v[s][2], v[s][0], x, y) >= 0 {
+						t = s
+						break
+					}
+				}
+				break
+			}
+		}
+
+		// the cavity of triangles whose circumcircles contain the point
+		bad = append(bad[:0], t)
+		isBad[t] = true
+		for k := 0; k < len(bad); k++ {
+			for _, s := range nbr[bad[k]] {
+				if s >= 0 && !isBad[s] && inCircle(v[s][0], v[s][1], v[s][2], x, y) {
+					isBad[s] = true
+					bad = append(bad, s)
+				}
+			}
+		}
+		boundary = boundary[:0]
+		for _, s := range bad {
+			for i := 0; i < 3; i++ {
+				if o := nbr[s][i]; o < 0 || !isBad[o] {
+					boundary = append(boundary, edge{v[s][(i+1)%3], v[s][(i+2)%3], o})
+				}
+			}
+		}
+
+		// the cavity is replaced by a fan of triangles around the point
+		startAt := make(map[int]int, len(boundary))
+		endAt := make(map[int]int, len(boundary))
+		for _, s := range bad {
+			dead[s] = true
+			delete(isBad, s)
+			free = append(free, s)
+		}
+		for _, e := range boundary {
+			var s int
+			if len(free) > 0 {
+				s = free[len(free)-1]
+				free = free[:len(free)-1]
+				v[s], nbr[s], dead[s] = [3]int{e.a, e.b, p}, [3]int{-1, -1, e.outside}, false
+			} else {
+				s = len(v)
+				v = append(v, [3]int{e.a, e.b, p})
+				nbr = append(nbr, [3]int{-1, -1, e.outside})
+				dead = append(dead, false)
+			}
+			if e.outside >= 0 {
+				for i := 0; i < 3; i++ {
+					if o := v[e.outside]; o[(i+1)%3] == e.b && o[(i+2)%3] == e.a {
+						nbr[e.outside][i] = s
+					}
+				}
+			}
+			startAt[e.a] = s
+			endAt[e.b] = s
+			last = s
+		}
+		for _, e := range boundary {
+			s := startAt[e.a]
+			nbr[s][0] = startAt[e.b] // across the edge from b to p
+			nbr[s][1] = endAt[e.a]   // across the edge from p to a
+		}
+	}
+
+	triangles := make([][3]int, 0, 2*n)
+	for t := range v {
+		if !dead[t] && v[t][0] < n && v[t][1] < n && v[t][2] < n {
+			triangles = append(triangles, v[t])
+		}
+	}
+	return triangles
+}
@@ -1,6 +1,24 @@
 package tools
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
 
 var testFD8FA = false
 var testDevFromMean = false
@@ -78,3 +96,3233 @@ func TestWhiteboxRaster2GeoTiff(t *testing.T) {
 		t.SkipNow()
 	}
 }
+
+func TestPointerEncodings(t *testing.T) {
+	// the east neighbour in each encoding
+	east := map[pointerEncoding]float64{whiteboxPointer: 2, esriPointer: 1, taudemPointer: 1, gospatialPointer: 2}
+	for e, v := range east {
+		if e.encode(1) != v {
+			t.Errorf("%s: east encoded as %v, expected %v", e, e.encode(1), v)
+		}
+		for n := 0; n < 8; n++ {
+			if m := e.decode(e.encode(n)); m != n {
+				t.Errorf("%s: direction %v decoded as %v", e, n, m)
+			}
+		}
+		if e.decode(0) != -1 || e.decode(3.5) != -1 {
+			t.Errorf("%s: invalid pointer values were decoded", e)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	if numFailed := ptm.SelfTest(); numFailed > 0 {
+		t.Errorf("%v self-tests failed", numFailed)
+	}
+}
+
+func TestEstimateToolMemory(t *testing.T) {
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	// tools without an estimator hold an input and an output raster
+	if mem, err := ptm.EstimateToolMemory("Slope", 100, 200); err != nil || mem != 100*200*16 {
+		t.Errorf("Slope: estimated %v bytes (%v), expected %v", mem, err, 100*200*16)
+	}
+	if mem, err := ptm.EstimateToolMemory("FillDepressions", 100, 200); err != nil || mem != 100*200*16+102*202 {
+		t.Errorf("FillDepressions: estimated %v bytes (%v), expected %v", mem, err, 100*200*16+102*202)
+	}
+	if _, err := ptm.EstimateToolMemory("NoSuchTool", 100, 200); err == nil {
+		t.Error("an unrecognized tool was estimated")
+	}
+	if s := FormatBytes(3 * 1024 * 1024); s != "3.0 MB" {
+		t.Errorf("FormatBytes: got %s, expected 3.0 MB", s)
+	}
+}
+
+func TestGetToolsMetadata(t *testing.T) {
+	ptm := PluginToolManager{Version: "1.2.3"}
+	ptm.InitializeTools()
+	md := ptm.GetToolsMetadata()
+	if len(md) != len(ptm.mapOfPluginTools) {
+		t.Fatalf("metadata for %v tools, expected %v", len(md), len(ptm.mapOfPluginTools))
+	}
+	for i := 1; i < len(md); i++ {
+		if strings.ToLower(md[i-1].Name) > strings.ToLower(md[i].Name) {
+			t.Errorf("tools are not sorted: %s before %s", md[i-1].Name, md[i].Name)
+		}
+	}
+	d, err := ptm.GetToolMetadata("Despike")
+	if err != nil || d.Version != "1.2.3" || d.ToolVersion != "1.0" || len(d.Args) != 4 {
+		t.Fatalf("Despike: unexpected metadata %+v (%v)", d, err)
+	}
+	if !d.Args[0].Required || d.Args[2].Required || d.Args[2].Default != "5" {
+		t.Errorf("Despike: unexpected argument metadata %+v", d.Args)
+	}
+	if _, err := ptm.GetToolMetadata("NoSuchTool"); err == nil {
+		t.Error("metadata was returned for an unrecognized tool")
+	}
+}
+
+func TestResolveArguments(t *testing.T) {
+	descs := []ToolArg{
+		{Name: "InputDEM", Required: true},
+		{Name: "OutputFile", Required: true},
+		{Name: "Method", Choices: []string{"fft", "median"}},
+		{Name: "FilterSize"},
+	}
+	args, err := resolveArguments(descs, []string{"dem.tif", "out.tif", "filtersize=7"})
+	if err != nil || strings.Join(args, ";") != "dem.tif;out.tif;;7" {
+		t.Errorf("named argument: got %q (%v)", args, err)
+	}
+	args, err = resolveArguments(descs, []string{"dem.tif", "out.tif", "not specified", "9"})
+	if err != nil || strings.Join(args, ";") != "dem.tif;out.tif;;9" {
+		t.Errorf("not specified argument: got %q (%v)", args, err)
+	}
+	bad := [][]string{
+		{"dem.tif"},
+		{"dem.tif", "out.tif", "Method=mean"},
+		{"dem.tif", "out.tif", "fft", "7", "extra"},
+		{"dem.tif", "FilterSize=7", "out.tif"},
+	}
+	for _, b := range bad {
+		if _, err := resolveArguments(descs, b); err == nil {
+			t.Errorf("no error for the arguments %q", b)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	ptm.SetWorkingDirectory(t.TempDir())
+	err := ptm.DryRun("Despike", []string{"missing.tif", "out.tif", "abc"})
+	if err == nil {
+		t.Fatal("no error for a missing input and an invalid threshold")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "InputDEM") || !strings.Contains(msg, "Threshold") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ptm.DryRun("NoSuchTool", nil); err == nil {
+		t.Error("no error for an unrecognized tool")
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	var eta progressETA
+	start := time.Now()
+	if s := eta.estimate(0, start); s != "" {
+		t.Errorf("an estimate at 0%%: %q", s)
+	}
+	// 1% per minute, slowing to 1% per 2 minutes
+	for p := 1; p <= 10; p++ {
+		eta.estimate(p, start.Add(time.Duration(p)*time.Minute))
+	}
+	now := start.Add(10 * time.Minute)
+	for p := 11; p <= 30; p++ {
+		now = now.Add(2 * time.Minute)
+		eta.estimate(p, now)
+	}
+	if s := eta.estimate(31, now.Add(2*time.Minute)); s != " (2h18m00s remaining)" {
+		t.Errorf("unexpected estimate %q", s)
+	}
+	// a new loop starts a new estimate
+	if s := eta.estimate(0, now.Add(3*time.Minute)); s != "" {
+		t.Errorf("an estimate at the start of a new loop: %q", s)
+	}
+}
+
+func TestTableWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newTableWriter(&buf, "label", "x", "count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.writeRow("a, \"b\"", 0.25, 3)
+	w.writeRow()
+	w.writeRow("nan", math.NaN(), int64(-1))
+	if err = w.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "label,x,count\n\"a, \"\"b\"\"\",0.25,3\n\nnan,NaN,-1\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	// without a report file, reports are discarded
+	var ptm *PluginToolManager
+	report, err := ptm.openReport("a", "b")
+	if report != nil || err != nil {
+		t.Errorf("a report was opened without a report file: %v", err)
+	}
+	if err = report.writeRow(1, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpslopeCells(t *testing.T) {
+	// a 3 x 3 grid draining to the centre, except the NE corner, which flows
+	// north off the grid, and the centre, which flows east to a cell that
+	// flows east off the grid
+	fd := newFlowDirections(3, 3)
+	copy(fd.dir, []int8{2, 3, 7, 1, 1, 1, 0, 7, 6})
+	if cells := fd.UpslopeCells(1, 1, nil); len(cells) != 7 {
+		t.Errorf("the centre has %v upslope cells, expected 7", len(cells))
+	}
+	if cells := fd.UpslopeCells(1, 2, nil); len(cells) != 8 {
+		t.Errorf("the east cell has %v upslope cells, expected 8", len(cells))
+	}
+	// the query cell is entered, but the traversal stops at others
+	stop := func(row, col int) bool { return row == 1 && col == 1 }
+	if cells := fd.UpslopeCells(1, 2, stop); len(cells) != 1 {
+		t.Errorf("the east cell has %v upslope cells beyond the centre, expected 1", len(cells))
+	}
+	if cells := fd.UpslopeCells(1, 1, stop); len(cells) != 7 {
+		t.Errorf("a repeated query found %v upslope cells, expected 7", len(cells))
+	}
+	if cells := fd.UpslopeCells(3, 0, nil); len(cells) != 0 {
+		t.Error("upslope cells were found outside of the grid")
+	}
+}
+
+func TestLogTransform(t *testing.T) {
+	for _, c := range []struct {
+		method logTransform
+		z      float64
+		want   float64
+		ok     bool
+	}{
+		{lnTransform, math.E, 1, true},
+		{lnTransform, 0, 0, false},
+		{lnTransform, -1, 0, false},
+		{lnTransform, math.NaN(), 0, false},
+		{ln1pTransform, 0, 0, true},
+		{ln1pTransform, math.E - 1, 1, true},
+		{ln1pTransform, -1, 0, false},
+		{ln1pTransform, math.Inf(1), 0, false},
+	} {
+		z, ok := c.method.apply(c.z)
+		if ok != c.ok || (ok && math.Abs(z-c.want) > 1e-12) {
+			t.Errorf("%s(%v) = %v, %v; expected %v, %v", c.method, c.z, z, ok, c.want, c.ok)
+		}
+	}
+	if _, err := parseLogTransform("log2"); err == nil {
+		t.Error("an unknown log transform was accepted")
+	}
+}
+
+func TestPointIndexNearest(t *testing.T) {
+	// a clustered set of points, so that many buckets are empty
+	var xs, ys []float64
+	seed := uint32(7)
+	next := func() float64 {
+		seed = seed*1664525 + 1013904223
+		return float64(seed>>8) / float64(1<<24)
+	}
+	for i := 0; i < 300; i++ {
+		if i%3 == 0 {
+			xs, ys = append(xs, 100*next()), append(ys, 100*next())
+		} else {
+			xs, ys = append(xs, 10*next()), append(ys, 90+10*next())
+		}
+	}
+	const k = 8
+	idx := newPointIndex(xs, ys, k)
+	for _, q := range [][2]float64{{5, 95}, {50, 50}, {99, 1}, {-20, 130}, {200, -50}} {
+		got := idx.nearest(q[0], q[1], k, nil)
+		dist := make([]float64, len(xs))
+		for i := range xs {
+			dist[i] = math.Hypot(xs[i]-q[0], ys[i]-q[1])
+		}
+		sorted := append([]float64(nil), dist...)
+		sort.Float64s(sorted)
+		if len(got) != k {
+			t.Fatalf("%v: %d neighbours found, expected %d", q, len(got), k)
+		}
+		for i, p := range got {
+			if dist[p] != sorted[i] {
+				t.Errorf("%v: neighbour %d is at %v, expected %v", q, i, dist[p], sorted[i])
+			}
+		}
+	}
+}
+
+func TestDelaunayTriangulation(t *testing.T) {
+	var xs, ys []float64
+	seed := uint32(11)
+	next := func() float64 {
+		seed = seed*1664525 + 1013904223
+		return float64(seed>>8) / float64(1<<24)
+	}
+	for i := 0; i < 400; i++ {
+		xs, ys = append(xs, 500000+1000*next()), append(ys, 4820000+1000*next())
+	}
+	triangles := delaunayTriangulation(xs, ys)
+	used := make([]bool, len(xs))
+	for _, tri := range triangles {
+		a, b, c := tri[0], tri[1], tri[2]
+		area := (xs[b]-xs[a])*(ys[c]-ys[a]) - (ys[b]-ys[a])*(xs[c]-xs[a])
+		if area <= 0 {
+			t.Fatalf("triangle %v is not counter-clockwise", tri)
+		}
+		used[a], used[b], used[c] = true, true, true
+		// no point lies within the circumcircle of a Delaunay triangle
+		d := 2 * ((xs[a]-xs[c])*(ys[b]-ys[c]) - (xs[b]-xs[c])*(ys[a]-ys[c]))
+		la := (xs[a]-xs[c])*(xs[a]+xs[c]) + (ys[a]-ys[c])*(ys[a]+ys[c])
+		lb := (xs[b]-xs[c])*(xs[b]+xs[c]) + (ys[b]-ys[c])*(ys[b]+ys[c])
+		ux := (la*(ys[b]-ys[c]) - lb*(ys[a]-ys[c])) / d
+		uy := (lb*(xs[a]-xs[c]) - la*(xs[b]-xs[c])) / d
+		radius := math.Hypot(xs[a]-ux, ys[a]-uy)
+		for i := range xs {
+			if i != a && i != b && i != c && math.Hypot(xs[i]-ux, ys[i]-uy) < radius*(1-1e-9) {
+				t.Fatalf("point %d is within the circumcircle of triangle %v", i, tri)
+			}
+		}
+	}
+	for i, u := range used {
+		if !u {
+			t.Errorf("point %d is not a vertex of any triangle", i)
+		}
+	}
+}
+
+func TestArgTemplate(t *testing.T) {
+	template := []string{"{tile}", "{dir}/{name}_{tool}_{date}.tif", "mask.tif"}
+	args := expandArgTemplate(template, map[string]string{
+		"tile": "/data/a/t1.tif", "name": "t1", "dir": "/data/a", "tool": "Slope", "date": "20151201",
+	})
+	if args[0] != "/data/a/t1.tif" || args[1] != "/data/a/t1_Slope_20151201.tif" || args[2] != "mask.tif" {
+		t.Errorf("unexpected arguments %v", args)
+	}
+
+	// tiles of the same name in different directories collide on {name}, but
+	// a shared input doesn't
+	runs := [][]string{
+		{"/data/a/t1.tif", "t1_out.tif", "mask.tif"},
+		{"/data/a/t2.tif", "t2_out.tif", "mask.tif"},
+		{"/data/b/t1.tif", "t1_out.tif", "mask.tif"},
+	}
+	outputs := []bool{false, true, false}
+	if a, b, arg, ok := findArgCollision(runs, outputs); !ok || a != 0 || b != 2 || arg != "t1_out.tif" {
+		t.Errorf("unexpected collision %v %v %q %v", a, b, arg, ok)
+	}
+	runs[2][1] = "t1_out_3.tif"
+	if _, _, _, ok := findArgCollision(runs, outputs); ok {
+		t.Error("distinct outputs were reported as a collision")
+	}
+
+	// an output that is the same for every tile collides
+	for i := range runs {
+		runs[i][1] = "out.tif"
+	}
+	if a, b, arg, ok := findArgCollision(runs, outputs); !ok || a != 0 || b != 1 || arg != "out.tif" {
+		t.Errorf("unexpected collision %v %v %q %v", a, b, arg, ok)
+	}
+	if _, _, _, ok := findArgCollision(runs, []bool{false, false, false}); ok {
+		t.Error("a constant input was reported as a collision")
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	dir := t.TempDir()
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	done := filepath.Join(dir, "done.asc")
+	partial := filepath.Join(dir, "partial.tif")
+
+	// an interrupt stops a tool run by another at its next progress update,
+	// and only the output that wasn't completely written is removed
+	err := ptm.runTool(new(BatchTiles), func() {
+		ptm.runTool(new(FillDepressions), func() {
+			r, err := raster.CreateNewRaster(done, 2, 2, 2, 0, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = r.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = raster.CreateNewRaster(partial, 2, 2, 2, 0, 2, 0); err != nil {
+				t.Fatal(err)
+			}
+			os.WriteFile(partial, []byte("half-written"), 0644)
+			atomic.StoreInt32(&interruptRequested, 1)
+			var eta progressETA
+			eta.remaining(45)
+			t.Error("the tool wasn't stopped")
+		})
+		t.Error("the outer tool wasn't stopped")
+	})
+	ie, ok := err.(*InterruptedError)
+	if !ok {
+		t.Fatalf("runTool returned %v", err)
+	}
+	if ie.Tool != "FillDepressions" || !strings.Contains(ie.Where, "45%") || len(ie.Removed) != 1 || ie.Removed[0] != partial {
+		t.Errorf("unexpected error: %v", ie)
+	}
+	if _, err = os.Stat(partial); err == nil {
+		t.Error("the incomplete output wasn't removed")
+	}
+	if _, err = os.Stat(done); err != nil {
+		t.Error("the complete output was removed")
+	}
+	if atomic.LoadInt32(&interruptRequested) != 0 || atomic.LoadInt32(&toolsRunning) != 0 {
+		t.Error("the interrupt wasn't cleared")
+	}
+	if err = ptm.runTool(new(FillDepressions), func() {}); err != nil {
+		t.Errorf("a later run returned %v", err)
+	}
+}
+
+func TestThinCells(t *testing.T) {
+	// a band three cells wide, from which a line branches diagonally
+	grid := []string{
+		"..........",
+		".#######..",
+		".########.",
+		".#######.#",
+		"..........",
+	}
+	rows, columns := len(grid), len(grid[0])
+	cells := make([]bool, rows*columns)
+	for row, s := range grid {
+		for col, c := range s {
+			cells[row*columns+col] = c == '#'
+		}
+	}
+	thinCells(cells, rows, columns)
+	// the band is one cell wide, and still reaches the branch
+	for col := 2; col < 7; col++ {
+		n := 0
+		for row := 0; row < rows; row++ {
+			if cells[row*columns+col] {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("column %v has %v cells, expected 1", col, n)
+		}
+	}
+	if !cells[3*columns+9] || !cells[2*columns+8] {
+		t.Error("the branch was eroded")
+	}
+
+	// a line one cell wide with a turn at its head is left as it is
+	line := []bool{
+		true, true, false,
+		false, true, false,
+		false, false, true,
+	}
+	if removed := thinCells(line, 3, 3); removed != 0 {
+		t.Errorf("%v cells of a line one cell wide were removed", removed)
+	}
+}
+
+func TestVariogramFractalDimension(t *testing.T) {
+	dir := t.TempDir()
+	rows, columns := 60, 60
+
+	// the semivariance of a plane rises as the square of the lag, and that
+	// of uncorrelated noise doesn't change with the lag
+	plane, err := raster.CreateNewRaster(filepath.Join(dir, "plane.tif"), rows, columns, 60, 0, 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise, err := raster.CreateNewRaster(filepath.Join(dir, "noise.tif"), rows, columns, 60, 0, 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			plane.SetValue(row, col, 2.0*float64(col)-2.0*float64(row))
+			noise.SetValue(row, col, rng.NormFloat64())
+		}
+	}
+	plane.SetValue(0, 0, plane.NoDataValue)
+
+	dims := variogramFractalDimension(plane, 10, 5)
+	if !math.IsNaN(dims[0]) {
+		t.Error("a nodata cell has a fractal dimension")
+	}
+	for _, i := range []int{1, 30*columns + 30, rows*columns - 1} {
+		if math.Abs(dims[i]-2.0) > 1e-9 {
+			t.Errorf("the fractal dimension of a plane is %v, expected 2", dims[i])
+		}
+	}
+	dims = variogramFractalDimension(noise, 20, 5)
+	if d := dims[30*columns+30]; math.Abs(d-3.0) > 0.05 {
+		t.Errorf("the fractal dimension of noise is %v, expected about 3", d)
+	}
+}
+
+func TestPennockClassify(t *testing.T) {
+	dir := t.TempDir()
+	rows, columns := 21, 21
+	tool := &PennockLandformClass{slopeThreshold: 3.0, profThreshold: 0.1, planThreshold: 0.0}
+
+	// a cell east of the centre of a dome is on a divergent shoulder, and one
+	// in a bowl on a convergent footslope, and a plane is a backslope
+	surfaces := []struct {
+		z     func(x, y float64) float64
+		class int
+	}{
+		{func(x, y float64) float64 { return -0.01 * (x*x + y*y) }, 4},
+		{func(x, y float64) float64 { return 0.01 * (x*x + y*y) }, 1},
+		{func(x, y float64) float64 { return 0.25*x + 0.5*y }, 5},
+		{func(x, y float64) float64 { return 0.01 * x }, 7},
+	}
+	for i, s := range surfaces {
+		dem, err := raster.CreateNewRaster(filepath.Join(dir, "dem.tif"), rows, columns, 10.5, -10.5, 10.5, -10.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				dem.SetValue(row, col, s.z(float64(col-10), float64(10-row)))
+			}
+		}
+		d, ok := evansDerivatives(dem, 10, 15, 1, 1)
+		if !ok {
+			t.Fatal("a valid cell has no derivatives")
+		}
+		if class := tool.classify(d); class != s.class {
+			t.Errorf("surface %v is class %v, expected %v", i, class, s.class)
+		}
+	}
+}
+
+func TestSurfaceDerivatives(t *testing.T) {
+	dir := t.TempDir()
+	rows, columns := 5, 5
+	newDEM := func(z func(x, y float64) float64) *raster.Raster {
+		dem, err := raster.CreateNewRaster(filepath.Join(dir, "dem.tif"), rows, columns, 2.5, -2.5, 2.5, -2.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				dem.SetValue(row, col, z(float64(col-2), float64(2-row)))
+			}
+		}
+		return dem
+	}
+
+	// planes facing each of the cardinal directions, and a level plane
+	planes := []struct {
+		z      func(x, y float64) float64
+		aspect float64
+	}{
+		{func(x, y float64) float64 { return -0.5 * y }, 0},
+		{func(x, y float64) float64 { return -0.5 * x }, 90},
+		{func(x, y float64) float64 { return 0.5 * y }, 180},
+		{func(x, y float64) float64 { return 0.5 * x }, 270},
+		{func(x, y float64) float64 { return 1 }, -1},
+	}
+	for i, p := range planes {
+		dem := newDEM(p.z)
+		for _, derivatives := range []func(*raster.Raster, int, int, float64, float64) (surfaceDerivatives, bool){
+			hornDerivatives, evansDerivatives} {
+			d, ok := derivatives(dem, 2, 2, 1, 1)
+			if !ok {
+				t.Fatal("a valid cell has no derivatives")
+			}
+			if aspect := d.aspect(); math.Abs(aspect-p.aspect) > 1e-9 {
+				t.Errorf("plane %v has an aspect of %v, expected %v", i, aspect, p.aspect)
+			}
+			expected := math.Atan(0.5) * RadToDeg
+			if p.aspect < 0 {
+				expected = 0
+			}
+			if slope := d.slope(); math.Abs(slope-expected) > 1e-9 {
+				t.Errorf("plane %v has a slope of %v, expected %v", i, slope, expected)
+			}
+		}
+	}
+
+	// the tangential curvature of a dome is its plan curvature times the sine
+	// of the slope
+	dem := newDEM(func(x, y float64) float64 { return -0.125 * (x*x + y*y) })
+	d, ok := evansDerivatives(dem, 2, 3, 1, 1)
+	if !ok {
+		t.Fatal("a valid cell has no derivatives")
+	}
+	plan, tangential := d.planCurvature(), d.tangentialCurvature()
+	if plan <= 0 || d.profileCurvature() <= 0 {
+		t.Errorf("a dome has plan and profile curvatures of %v and %v, expected positive", plan, d.profileCurvature())
+	}
+	if expected := plan * math.Sin(d.slope()*DegToRad); math.Abs(tangential-expected) > 1e-9 {
+		t.Errorf("the tangential curvature is %v, expected %v", tangential, expected)
+	}
+}
+
+func TestMultiresolutionFlatness(t *testing.T) {
+	rows, columns := 41, 41
+	// the extent is outside of the range of latitudes, so that the cell
+	// sizes are in metres
+	dem, err := raster.CreateNewRaster(filepath.Join(t.TempDir(), "dem.tif"), rows, columns, 1041, 1000, 1041, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valley with a flat floor 11 cells wide and sides of 50% slope
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, 0.5*math.Max(0, math.Abs(float64(col-20))-5))
+		}
+	}
+	if numSteps := multiresolutionSteps(rows, columns, 0); numSteps != 3 {
+		t.Errorf("a DEM of %v x %v cells has %v steps, expected 3", rows, columns, numSteps)
+	}
+	vbf, err := multiresolutionFlatness(dem, false, 16, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floor, side := 20*columns+20, 20*columns+30
+	if vbf[floor] < 1.5 {
+		t.Errorf("the valley floor has an MrVBF of %v, expected at least 1.5", vbf[floor])
+	}
+	if vbf[side] > 0.5 {
+		t.Errorf("the valley side has an MrVBF of %v, expected less than 0.5", vbf[side])
+	}
+
+	// the ridge top flatness of the inverted valley, a plateau, is the valley
+	// bottom flatness of the valley
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, -dem.Value(row, col))
+		}
+	}
+	rtf, err := multiresolutionFlatness(dem, true, 16, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range vbf {
+		if math.Abs(rtf[i]-vbf[i]) > 1e-12 {
+			t.Fatalf("cell %v of the plateau has an MrRTF of %v, expected %v", i, rtf[i], vbf[i])
+		}
+	}
+}
+
+func TestHillshade(t *testing.T) {
+	altitude := 30 * DegToRad
+	west := []float64{270 * DegToRad}
+	one := []float64{1}
+
+	// level ground is lit by the sine of the altitude, a slope facing the
+	// light at its zenith angle fully, and one facing away from it not at all
+	tests := []struct {
+		d        surfaceDerivatives
+		expected float64
+	}{
+		{surfaceDerivatives{}, 0.5},
+		{surfaceDerivatives{p: math.Tan(60 * DegToRad)}, 1},
+		{surfaceDerivatives{p: -math.Tan(60 * DegToRad)}, 0},
+	}
+	for i, test := range tests {
+		if shade := hillshade(test.d, altitude, west, one); math.Abs(shade-test.expected) > 1e-9 {
+			t.Errorf("surface %v has a shade of %v, expected %v", i, shade, test.expected)
+		}
+	}
+
+	// the weights of a multidirectional hillshade sum to 1, so that level
+	// ground is lit as by a single light
+	azimuths := make([]float64, len(multidirectionalAzimuths))
+	for i, a := range multidirectionalAzimuths {
+		azimuths[i] = a * DegToRad
+	}
+	if shade := hillshade(surfaceDerivatives{}, altitude, azimuths, multidirectionalWeights); math.Abs(shade-0.5) > 1e-9 {
+		t.Errorf("level ground has a multidirectional shade of %v, expected 0.5", shade)
+	}
+}
+
+func TestFindKnickpoints(t *testing.T) {
+	rows, columns := 3, 60
+	dir := t.TempDir()
+	newRaster := func(name string) *raster.Raster {
+		r, err := raster.CreateNewRaster(filepath.Join(dir, name), rows, columns, 1030, 1000, 1600, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	dem, streams, accum := newRaster("dem.tif"), newRaster("streams.tif"), newRaster("accum.tif")
+
+	// a channel flowing east along the middle row, with a slope of 1% that
+	// steepens to 10% at column 30
+	for col := 0; col < columns; col++ {
+		z := 100 - 0.1*math.Min(float64(col), 30) - math.Max(0, float64(col-30))
+		for row := 0; row < rows; row++ {
+			dem.SetValue(row, col, z)
+			accum.SetValue(row, col, 0)
+			streams.SetValue(row, col, 0)
+		}
+		accum.SetValue(1, col, float64(col+1))
+		streams.SetValue(1, col, 1)
+	}
+	sn := traceStreamNetwork(streams, accum)
+	if sn.numLinks != 1 {
+		t.Fatalf("the channel has %v links, expected 1", sn.numLinks)
+	}
+
+	// with a concavity of zero, ksn is the slope
+	ksn, points := findKnickpoints(dem, accum, sn, 5, 0, 2)
+	for col, expected := range map[int]float64{10: 0.01, 50: 0.1} {
+		if k := ksn[columns+col]; math.Abs(k-expected) > 1e-6 {
+			t.Errorf("column %v has a ksn of %v, expected %v", col, k, expected)
+		}
+	}
+	if len(points) != 1 {
+		t.Fatalf("found %v knickpoints, expected 1", len(points))
+	}
+	p := points[0]
+	if p.cell != columns+30 {
+		t.Errorf("the knickpoint is in column %v, expected 30", p.cell-columns)
+	}
+	if ratio := p.ksnDown / p.ksnUp; math.Abs(ratio-10) > 1e-6 {
+		t.Errorf("the knickpoint has a ratio of %v, expected 10", ratio)
+	}
+}
+
+func TestLinkCatchmentStats(t *testing.T) {
+	rows, columns := 15, 21
+	dir := t.TempDir()
+	newRaster := func(name string) *raster.Raster {
+		r, err := raster.CreateNewRaster(filepath.Join(dir, name), rows, columns, 1150, 1000, 1210, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	dem, streams, accum := newRaster("dem.tif"), newRaster("streams.tif"), newRaster("accum.tif")
+
+	// a valley draining south down its centre column, with a tributary
+	// along row 5 from the west edge
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, math.Abs(float64(col-10))+0.1*float64(rows-row))
+			streams.SetValue(row, col, 0)
+			accum.SetValue(row, col, 1)
+		}
+		streams.SetValue(row, 10, 1)
+		accum.SetValue(row, 10, float64(100*(row+1)))
+	}
+	for col := 0; col < 10; col++ {
+		streams.SetValue(5, col, 1)
+		accum.SetValue(5, col, float64(col+1))
+	}
+	sn := traceStreamNetwork(streams, accum)
+	if sn.numLinks != 3 {
+		t.Fatalf("the network has %v links, expected 3", sn.numLinks)
+	}
+	stats, catchments := linkCatchmentStats(dem, accum, sn)
+
+	cellArea := 100.0
+	var outlet, tributary int
+	for i, l := range sn.links {
+		if l.downLink < 0 && l.id > 0 {
+			outlet = i
+		}
+		if l.cells[0] == 5*columns {
+			tributary = i
+		}
+	}
+	if a := stats[tributary].localArea; a != 10*cellArea {
+		t.Errorf("the tributary has a local catchment of %v, expected %v", a, 10*cellArea)
+	}
+	if a := stats[outlet].area; a != float64(rows*columns)*cellArea {
+		t.Errorf("the outlet link has a catchment of %v, expected %v", a, float64(rows*columns)*cellArea)
+	}
+	sumLocal := 0.0
+	for _, s := range stats {
+		sumLocal += s.localArea
+	}
+	if sumLocal != stats[outlet].area {
+		t.Errorf("the local catchments sum to %v, expected %v", sumLocal, stats[outlet].area)
+	}
+	for i, c := range catchments {
+		if c < 0 {
+			t.Fatalf("cell %v isn't in a catchment", i)
+		}
+	}
+
+	// the side slopes are 10%, or 5.7 degrees, and the flatter channel cells
+	// lower the mean
+	if s := stats[outlet].meanSlope; s < 4 || s > 5.8 {
+		t.Errorf("the outlet link has a mean slope of %v, expected 4 to 5.8", s)
+	}
+	if w := stats[outlet].meanWetness; math.IsNaN(w) || w <= 0 {
+		t.Errorf("the outlet link has a mean wetness index of %v, expected a positive value", w)
+	}
+}
+
+func TestResolveFlats(t *testing.T) {
+	rows, columns := 9, 9
+	dem, err := raster.CreateNewRaster(filepath.Join(t.TempDir(), "dem.tif"), rows, columns, 1090, 1000, 1090, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flat walled by higher ground, except for an outlet on the east edge
+	setDEM := func(outlet float64) {
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				z := 10.0
+				if row == 0 || row == rows-1 || col == 0 || col == columns-1 {
+					z = 20
+				}
+				dem.SetValue(row, col, z)
+			}
+		}
+		dem.SetValue(4, columns-1, outlet)
+	}
+	setDEM(10)
+	z, numFlats, numClosed := resolveFlats(dem, 0.001)
+	if numFlats != 1 || numClosed != 0 {
+		t.Fatalf("found %v flats, %v without an outlet, expected 1 and 0", numFlats, numClosed)
+	}
+	for i, v := range z {
+		dem.SetValue(i/columns, i%columns, v)
+	}
+	dist := [8]float64{math.Sqrt2, 1, math.Sqrt2, 1, math.Sqrt2, 1, math.Sqrt2, 1}
+	for row := 1; row < rows-1; row++ {
+		for col := 1; col < columns-1; col++ {
+			if v := dem.Value(row, col); v <= 10 || v >= 20 {
+				t.Errorf("cell %v, %v has an elevation of %v, expected between 10 and 20", row, col, v)
+			}
+			if d8Direction(dem, row, col, dist) < 0 {
+				t.Errorf("cell %v, %v has no downslope neighbour", row, col)
+			}
+		}
+	}
+
+	// the flow converges away from the walls, along the middle row
+	if dem.Value(4, 4) >= dem.Value(2, 4) {
+		t.Errorf("the middle of the flat, %v, isn't lower than its side, %v", dem.Value(4, 4), dem.Value(2, 4))
+	}
+
+	// a flat without an outlet is left unchanged
+	setDEM(20)
+	z, numFlats, numClosed = resolveFlats(dem, 0.001)
+	if numFlats != 1 || numClosed != 1 {
+		t.Fatalf("found %v flats, %v without an outlet, expected 1 and 1", numFlats, numClosed)
+	}
+	if z[4*columns+4] != 10 {
+		t.Errorf("the closed flat was raised to %v", z[4*columns+4])
+	}
+}
+
+func TestPriorityFlood(t *testing.T) {
+	// elevations below sea level are ordered as their values
+	values := []float64{-1e6, -2, -1, -0.5, 0, 0.5, 1, 1e6}
+	for i := 1; i < len(values); i++ {
+		if elevationPriority(values[i-1]) >= elevationPriority(values[i]) {
+			t.Errorf("%v doesn't have a lower priority than %v", values[i-1], values[i])
+		}
+	}
+
+	// a plane sloping down to the east, below sea level, with a pit and a
+	// nodata cell, beside which a lower cell drains into it and isn't filled
+	rows, columns := 5, 7
+	nodata := -32768.0
+	z := make([]float64, rows*columns)
+	for i := range z {
+		z[i] = -10 - float64(i%columns)
+	}
+	z[2*columns+2] = -14
+	z[2*columns+5] = nodata
+	z[2*columns+4] = -16
+	filled := priorityFlood(z, rows, columns, nodata)
+	for i := range z {
+		expected := z[i]
+		if i == 2*columns+2 {
+			expected = -13 // the lowest of its neighbours
+		}
+		if filled[i] != expected {
+			t.Errorf("cell %v, %v was filled to %v, expected %v", i/columns, i%columns, filled[i], expected)
+		}
+	}
+}
+
+func TestTravelTimes(t *testing.T) {
+	rows, columns := 5, 10
+	dem, err := raster.CreateNewRaster(filepath.Join(t.TempDir(), "dem.tif"), rows, columns, 1005, 1000, 1010, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a plane sloping down to the east at a gradient of 0.01, which flows at
+	// 0.1 m/s, 10 s per cell, with a velocity coefficient of 1
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, 100-0.01*float64(col))
+		}
+	}
+	fd := FlowDirectionsFromDEM(dem)
+	k := make([]float64, rows*columns)
+	for i := range k {
+		k[i] = 1
+	}
+	check := func(times []float64, expected func(col int) float64) {
+		t.Helper()
+		for i, v := range times {
+			if e := expected(i % columns); math.Abs(v-e) > 1e-9 {
+				t.Errorf("cell %v, %v has a travel time of %v, expected %v", i/columns, i%columns, v, e)
+			}
+		}
+	}
+	check(travelTimes(dem, fd, k, 0.01, nil), func(col int) float64 { return 10 * float64(columns-1-col) })
+
+	// a minimum velocity of 0.2 m/s halves the times
+	check(travelTimes(dem, fd, k, 0.2, nil), func(col int) float64 { return 5 * float64(columns-1-col) })
+
+	// flow is timed to a stream in column 5, and doubling the coefficient
+	// upstream of column 3 halves the times of the steps from there
+	isTarget := make([]bool, rows*columns)
+	for i := range k {
+		if i%columns < 3 {
+			k[i] = 2
+		}
+		isTarget[i] = i%columns == 5
+	}
+	check(travelTimes(dem, fd, k, 0.01, isTarget), func(col int) float64 {
+		switch {
+		case col < 3:
+			return 20 + 5*float64(3-col)
+		case col <= 5:
+			return 10 * float64(5-col)
+		}
+		return 10 * float64(columns-1-col)
+	})
+
+	// the watershed of a cell on the east edge is its row, which drains to it
+	// in 10 s per cell
+	for i := range k {
+		k[i] = 1
+	}
+	counts, err := timeAreaHistogram(dem, k, 0.01, 1009.5, 1002.5, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{2, 2, 2, 1, 2, 1}; fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Errorf("the time-area histogram is %v, expected %v", counts, expected)
+	}
+	if _, err = timeAreaHistogram(dem, k, 0.01, 1020, 1002.5, 17); err == nil {
+		t.Error("an outlet outside of the DEM was accepted")
+	}
+}
+
+func TestBurnStreams(t *testing.T) {
+	rows, columns := 10, 10
+	dir := t.TempDir()
+	dem, err := raster.CreateNewRaster(filepath.Join(dir, "dem.tif"), rows, columns, 1010, 1000, 1010, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, 100)
+		}
+	}
+
+	// a stream along the middle of row 4
+	shp, err := vector.CreateNewShapefile(filepath.Join(dir, "streams.shp"), vector.ST_PolyLine, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = shp.AddShape(vector.NewPolyLine([][2]float64{{1000.5, 1005.5}, {1009.5, 1005.5}})); err != nil {
+		t.Fatal(err)
+	}
+	if err = shp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	streams, err := readStreamGrid(filepath.Join(dir, "streams.shp"), dem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if streams[row+1][col+1] != (row == 4) {
+				t.Errorf("cell %v, %v is a stream cell: %v, expected %v", row, col, streams[row+1][col+1], row == 4)
+			}
+		}
+	}
+
+	// the banks fall by 0.8 per cell towards the stream, which is lowered by 2
+	drops := burnDrops(dem, streams, 2, 2.5)
+	expected := []float64{0, 0, 0.4, 1.2, 2, 1.2, 0.4, 0, 0, 0}
+	for i, d := range drops {
+		if e := expected[i/columns]; math.Abs(d-e) > 1e-9 {
+			t.Errorf("cell %v, %v was lowered by %v, expected %v", i/columns, i%columns, d, e)
+		}
+	}
+}
+
+func TestWeightTable(t *testing.T) {
+	dir := t.TempDir()
+	read := func(contents string) (*weightTable, error) {
+		fileName := filepath.Join(dir, "weights.txt")
+		if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return readWeightTable(fileName)
+	}
+
+	// aspects wrap around from the last entry to the first
+	table, err := read("# snowmelt\naspect weight\n90, 0.5\n0, 1\n180, 0\n270, 0.5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aspects := map[float64]float64{0: 1, 45: 0.75, 135: 0.25, 180: 0, 315: 0.75, 360: 1}
+	for a, expected := range aspects {
+		if w := table.weight(a); math.Abs(w-expected) > 1e-9 {
+			t.Errorf("the weight at an aspect of %v is %v, expected %v", a, w, expected)
+		}
+	}
+
+	// elevations beyond the ends of the table take the weights of the ends
+	if table, err = read("elevation weight\n100 0\n200 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	elevations := map[float64]float64{50: 0, 100: 0, 125: 0.5, 200: 2, 300: 2}
+	for z, expected := range elevations {
+		if w := table.weight(z); math.Abs(w-expected) > 1e-9 {
+			t.Errorf("the weight at an elevation of %v is %v, expected %v", z, w, expected)
+		}
+	}
+
+	bad := []string{"100 0\n200 2\n", "elevation\n100 -1\n", "aspect\n400 1\n", "elevation\n100 1\n100 2\n", "aspect\n"}
+	for _, contents := range bad {
+		if _, err = read(contents); err == nil {
+			t.Errorf("the weight table %q was accepted", contents)
+		}
+	}
+}
+
+func TestNodataMargin(t *testing.T) {
+	// a plane with a nodata cell in the middle
+	rows, columns := 7, 7
+	nodata := -32768.0
+	plane := func(i int) float64 { return float64(i/columns) + 2*float64(i%columns) }
+	z := make([]float64, rows*columns)
+	for i := range z {
+		z[i] = plane(i)
+	}
+	z[3*columns+3] = nodata
+
+	// shrinking by a cell grows the hole to 3 x 3 cells
+	if n := shrinkData(z, rows, columns, nodata, 1, false); n != 8 {
+		t.Errorf("%v cells were assigned nodata, expected 8", n)
+	}
+	for i, v := range z {
+		row, col := i/columns, i%columns
+		if inHole := row >= 2 && row <= 4 && col >= 2 && col <= 4; (v == nodata) != inHole {
+			t.Errorf("cell %v, %v has a value of %v", row, col, v)
+		}
+	}
+
+	// expanding by two cells fills the hole, exactly on a plane
+	if n := expandData(z, rows, columns, nodata, 2); n != 9 {
+		t.Errorf("%v cells were filled, expected 9", n)
+	}
+	for i, v := range z {
+		if math.Abs(v-plane(i)) > 1e-9 {
+			t.Errorf("cell %v, %v was filled with %v, expected %v", i/columns, i%columns, v, plane(i))
+		}
+	}
+
+	// the grid edges are shrunk too if requested
+	if n := shrinkData(z, rows, columns, nodata, 2, true); n != rows*columns-9 {
+		t.Errorf("%v cells were assigned nodata, expected %v", n, rows*columns-9)
+	}
+}
+
+func TestReclass(t *testing.T) {
+	classes, err := parseReclassClasses("1, 0, 10 2 10 20\tnodata 20 30 5 25 25 -1 nodata nodata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 5 || !classes[2].toNodata || !classes[4].fromNodata {
+		t.Fatalf("unexpected classes %v", classes)
+	}
+	for _, c := range []struct {
+		z        float64
+		isNodata bool
+		class    int
+	}{
+		{0, false, 0},
+		{9.99, false, 0},
+		{10, false, 1}, // the upper bound isn't included
+		{25, false, 2}, // the first class that includes a value applies
+		{30, false, -1},
+		{-5, false, -1},
+		{0, true, 4},
+	} {
+		if i := reclassify(classes, c.z, c.isNodata); i != c.class {
+			t.Errorf("%v (nodata %v) is in class %v, expected %v", c.z, c.isNodata, i, c.class)
+		}
+	}
+	// a single value class
+	if classes, _ = parseReclassClasses("7 3 3"); reclassify(classes, 3, false) != 0 || reclassify(classes, 3.5, false) != -1 {
+		t.Errorf("a class with equal bounds doesn't include just its value")
+	}
+
+	for _, s := range []string{"", "1 0", "1 0 10 2", "x 0 10", "1 10 0", "1 nodata 10"} {
+		if _, err := parseReclassClasses(s); err == nil {
+			t.Errorf("the reclass values '%s' were accepted", s)
+		}
+	}
+}
+
+func TestSnapGrid(t *testing.T) {
+	b := raster.Bounds{North: 100, South: 0, East: 95, West: 5}
+	// the grid starts at the corner of the bounds
+	rows, columns, grid := snapGrid(b, 30, b.West, b.North)
+	if rows != 4 || columns != 3 || grid != (raster.Bounds{North: 100, South: -20, East: 95, West: 5}) {
+		t.Errorf("%v rows, %v columns, %v", rows, columns, grid)
+	}
+	// cell edges on the lines of a grid with its corner at 0, 110
+	rows, columns, grid = snapGrid(b, 10, 0, 110)
+	if rows != 10 || columns != 10 || grid != (raster.Bounds{North: 100, South: 0, East: 100, West: 0}) {
+		t.Errorf("%v rows, %v columns, %v", rows, columns, grid)
+	}
+	// rounding errors don't add a row or column
+	rows, columns, _ = snapGrid(raster.Bounds{North: 0.3, South: 0, East: 0.3, West: 0}, 0.1, 0, 0.3)
+	if rows != 3 || columns != 3 {
+		t.Errorf("%v rows, %v columns", rows, columns)
+	}
+}
+
+func TestFD8StreamedDEM(t *testing.T) {
+	// a plane falling 1 m per cell to the east, in strips of two rows, so
+	// that the DEM is read in several blocks
+	rows, columns := 12, 5
+	dir := t.TempDir()
+	demFile := filepath.Join(dir, "plane.tif")
+	defer func() { raster.RowsPerStrip = 0 }()
+	raster.RowsPerStrip = 2
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	dem, err := raster.CreateNewRaster(demFile, rows, columns, 12, 0, 5, 0, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, float64(100-col))
+		}
+	}
+	if err = dem.Save(); err != nil {
+		t.Fatal(err)
+	}
+	br, err := raster.OpenStreaming(demFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.BlockRows() != 2 {
+		t.Errorf("the DEM has blocks of %v rows, expected 2", br.BlockRows())
+	}
+	br.Close()
+
+	g, err := readDEMGrid(demFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Rows != rows || g.Columns != columns || g.North != 12 || g.East != 5 {
+		t.Fatalf("the grid is %v x %v, north %v, east %v", g.Rows, g.Columns, g.North, g.East)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if z := g.Value(row, col); z != float64(100-col) {
+				t.Fatalf("cell %v, %v = %v, expected %v", row, col, z, 100-col)
+			}
+		}
+	}
+	if g.Value(-1, 0) != g.NoDataValue || g.Value(0, columns) != g.NoDataValue {
+		t.Error("cells outside the grid are not nodata")
+	}
+
+	// each cell shares its flow equally between its three eastern neighbours,
+	// so away from the edges the accumulation is the number of cells upslope
+	outFile := filepath.Join(dir, "fd8.tif")
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	if err = ptm.RunWithArguments("FD8FlowAccum", []string{demFile, outFile, "false", "false"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := raster.CreateRasterFromFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []int{5, 6} {
+		for col := 0; col < columns; col++ {
+			if a := out.Value(row, col); math.Abs(a-float64(col+1)) > 1e-5 {
+				t.Errorf("cell %v, %v accumulates %v, expected %v", row, col, a, col+1)
+			}
+		}
+	}
+}
+
+func TestInterruptParallelTool(t *testing.T) {
+	dir := t.TempDir()
+	demFile := filepath.Join(dir, "dem.tif")
+	rows, columns := 400, 400
+	dem, err := raster.CreateNewRaster(demFile, rows, columns, float64(rows), 0, float64(columns), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, math.Sin(float64(row)/20)*math.Cos(float64(col)/30)*50)
+		}
+	}
+	if err = dem.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the interrupt comes after the first progress update of the parallel
+	// step, while the workers are busy; the tool stops at the next one, and
+	// its workers skip their remaining rows and finish before it returns
+	defer func(p func(string, ...interface{}) (int, error)) { printf = p }(printf)
+	printf = func(format string, a ...interface{}) (int, error) {
+		if format == "\rProgress: %v%%%s" {
+			atomic.StoreInt32(&interruptRequested, 1)
+		}
+		return 0, nil
+	}
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	for _, tool := range []string{"Slope", "Aspect", "Hillshade"} {
+		baseline := runtime.NumGoroutine()
+		err = ptm.RunWithArguments(tool, []string{demFile, filepath.Join(dir, tool+".tif")})
+		ie, ok := err.(*InterruptedError)
+		if !ok {
+			t.Errorf("%v returned %v", tool, err)
+			continue
+		}
+		if ie.Tool != tool || strings.Contains(ie.Where, "100%") {
+			t.Errorf("%v: unexpected error: %v", tool, ie)
+		}
+		if n := runtime.NumGoroutine(); n > baseline {
+			t.Errorf("%v left %v goroutines running", tool, n-baseline)
+		}
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { raster.DeterministicOutput = false }()
+	raster.DeterministicOutput = true
+
+	// a stream along row 1, accumulating flow eastward, on a plane rising
+	// to the north
+	rows, columns := 3, 5
+	dem, err := raster.CreateNewRaster(filepath.Join(dir, "dem.tif"), rows, columns, 3, 0, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams, err := raster.CreateNewRaster(filepath.Join(dir, "streams.tif"), rows, columns, 3, 0, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accum, err := raster.CreateNewRaster(filepath.Join(dir, "accum.tif"), rows, columns, 3, 0, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			dem.SetValue(row, col, float64(10*(rows-row)+col))
+			accum.SetValue(row, col, float64(col+1))
+			if row == 1 {
+				streams.SetValue(row, col, 1)
+			}
+		}
+	}
+	for _, r := range []*raster.Raster{dem, streams, accum} {
+		if err = r.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each output is written twice, at different times, and compared
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	runs := []struct {
+		tool    string
+		args    func(run string) []string
+		outputs []string
+	}{
+		{"Slope", func(run string) []string {
+			return []string{filepath.Join(dir, "dem.tif"), filepath.Join(dir, run+"slope.tif")}
+		}, []string{"slope.tif"}},
+		{"RasterStreamsToVector", func(run string) []string {
+			return []string{filepath.Join(dir, "streams.tif"), filepath.Join(dir, "accum.tif"), filepath.Join(dir, run+"streams.shp")}
+		}, []string{"streams.shp", "streams.shx", "streams.dbf"}},
+	}
+	for _, r := range runs {
+		for _, run := range []string{"first", "second"} {
+			if err = ptm.RunWithArguments(r.tool, r.args(run)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, output := range r.outputs {
+			first, err := os.ReadFile(filepath.Join(dir, "first"+output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := os.ReadFile(filepath.Join(dir, "second"+output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Errorf("the two %v outputs of %v differ", output, r.tool)
+			}
+			// the dBASE header records a fixed date, 1 January 2000
+			if filepath.Ext(output) == ".dbf" && !bytes.Equal(first[1:4], []byte{100, 1, 1}) {
+				t.Errorf("the attribute table records the date %v", first[1:4])
+			}
+		}
+	}
+}
+
+// writeTestGrid writes a float64 raster of 1 x 1 m cells, with its south-west
+// corner at 0, 0 in UTM zone 17N, holding the values row by row, with NaN for
+// nodata. Without a projection the cells would be taken as degrees.
+func writeTestGrid(t *testing.T, fileName string, rows, columns int, values ...float64) {
+	t.Helper()
+	writeTestGridAt(t, fileName, rows, columns, 0, 0, values...)
+}
+
+// writeTestGridAt writes a raster like writeTestGrid, with its south-west
+// corner at west, south.
+func writeTestGridAt(t *testing.T, fileName string, rows, columns int, west, south float64, values ...float64) {
+	t.Helper()
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT64
+	config.EPSGCode = 32617
+	r, err := raster.CreateNewRaster(fileName, rows, columns, south+float64(rows), south, west+float64(columns), west, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, z := range values {
+		if math.IsNaN(z) {
+			z = r.NoDataValue
+		}
+		r.SetValue(i/columns, i%columns, z)
+	}
+	if err = r.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestGrid reads the values of a raster row by row, with NaN for nodata.
+func readTestGrid(t *testing.T, fileName string) []float64 {
+	t.Helper()
+	r, err := raster.CreateRasterFromFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]float64, r.Rows*r.Columns)
+	for i := range values {
+		if values[i] = r.Value(i/r.Columns, i%r.Columns); values[i] == r.NoDataValue {
+			values[i] = math.NaN()
+		}
+	}
+	return values
+}
+
+// writeTestShapes writes a shapefile of the shapes, without attributes, in
+// UTM zone 17N like writeTestGrid.
+func writeTestShapes(t *testing.T, fileName string, shapeType vector.ShapeType, shapes ...vector.Shape) string {
+	t.Helper()
+	shp, err := vector.CreateNewShapefile(fileName, shapeType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shp.EPSGCode = 32617
+	for _, shape := range shapes {
+		if err = shp.AddShape(shape); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = shp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// runTestTool runs a tool with the arguments, failing the test on an error.
+func runTestTool(t *testing.T, tool string, args ...string) {
+	t.Helper()
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	if err := ptm.RunWithArguments(tool, args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkTestGrid compares the values of a raster with those expected, row by
+// row, to within a tolerance, with NaN for nodata.
+func checkTestGrid(t *testing.T, fileName string, tolerance float64, expected ...float64) {
+	t.Helper()
+	values := readTestGrid(t, fileName)
+	if len(values) != len(expected) {
+		t.Fatalf("%v has %v cells, expected %v", filepath.Base(fileName), len(values), len(expected))
+	}
+	for i, v := range values {
+		if e := expected[i]; math.IsNaN(v) != math.IsNaN(e) || math.Abs(v-e) > tolerance {
+			t.Errorf("%v: cell %v has a value of %v, expected %v", filepath.Base(fileName), i, v, e)
+		}
+	}
+}
+
+func TestAssignCRS(t *testing.T) {
+	dir := t.TempDir()
+	local := `PROJCS["Local_Grid",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137,298.257223563]],` +
+		`PRIMEM["Greenwich",0],UNIT["Degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],` +
+		`PARAMETER["Central_Meridian",-80.5],PARAMETER["Scale_Factor",1],UNIT["Meter",1]]`
+	os.WriteFile(filepath.Join(dir, "local.prj"), []byte(local+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "utm.prj"), []byte("EPSG:32617\n"), 0644)
+
+	a := AssignCRS{toolManager: &PluginToolManager{workingDirectory: dir + pathSep}}
+	for _, c := range []struct {
+		crs  string
+		wkt  string
+		code int
+		ok   bool
+	}{
+		{"26917", "", 26917, true},
+		{"EPSG:4326", "", 4326, true},
+		{"epsg_3857", "", 3857, true},
+		{"0", "", 0, false},
+		{"local.prj", local, 0, true},
+		{"utm.prj", "", 32617, true},
+		{"missing.prj", "", 0, false},
+	} {
+		a.crs = c.crs
+		wkt, code, err := a.parseCRS()
+		if (err == nil) != c.ok || wkt != c.wkt || code != c.code {
+			t.Errorf("the CRS %v was read as %.20q, %v (%v)", c.crs, wkt, code, err)
+		}
+	}
+
+	// the CRS is assigned and the values left as they are
+	input := filepath.Join(dir, "grid.tif")
+	writeTestGrid(t, input, 2, 2, 1, 2, math.NaN(), 4)
+	for _, c := range []struct {
+		crs, output string
+		code        int
+		wkt         string
+	}{
+		{"26917", "utm.tif", 26917, ""},
+		{"local.prj", "local.tif", 0, local},
+		{"utm.prj", "utm.asc", 32617, ""},
+	} {
+		crs := c.crs
+		if strings.HasSuffix(crs, ".prj") {
+			crs = filepath.Join(dir, crs)
+		}
+		output := filepath.Join(dir, c.output)
+		runTestTool(t, "AssignCRS", input, crs, output)
+		checkTestGrid(t, output, 0, 1, 2, math.NaN(), 4)
+		r, err := raster.CreateRasterFromFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config := r.GetRasterConfig(); config.EPSGCode != c.code || (c.wkt != "" && config.CoordinateRefSystemWKT != c.wkt) {
+			t.Errorf("%v has the EPSG code %v and WKT %.20q", c.output, config.EPSGCode, config.CoordinateRefSystemWKT)
+		}
+	}
+}
+
+func TestReprojectToUTM(t *testing.T) {
+	// a geographic grid of 4 x 4 cells of 0.005 degrees across the central
+	// meridian of UTM zone 17, 81 degrees W, with the values 1 to its west
+	// and 2 to its east
+	dir := t.TempDir()
+	input := filepath.Join(dir, "geo.tif")
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.EPSGCode = 4326
+	rin, err := raster.CreateNewRaster(input, 4, 4, 43.02, 43, -80.99, -81.01, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			rin.SetValue(row, col, float64(1+col/2))
+		}
+	}
+	if err = rin.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "utm.tif")
+	runTestTool(t, "ReprojectToUTM", input, output, "100", "nearest")
+	rout, err := raster.CreateRasterFromFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := rout.GetRasterConfig().EPSGCode; code != 32617 {
+		t.Errorf("the output has the EPSG code %v, expected 32617", code)
+	}
+	// the grid spans 0.02 degrees, about 2224 m north-south and 1627 m east-
+	// west at 43 degrees N, centred on the central meridian's easting
+	if rout.GetCellSizeX() != 100 || rout.Rows != 23 || rout.Columns != 17 ||
+		math.Abs((rout.East+rout.West)/2-500000) > 50 {
+		t.Errorf("the output is %v x %v cells of %v m, from %v to %v", rout.Rows, rout.Columns,
+			rout.GetCellSizeX(), rout.West, rout.East)
+	}
+	n := 0
+	for row := 0; row < rout.Rows; row++ {
+		for col := 0; col < rout.Columns; col++ {
+			z := rout.Value(row, col)
+			if z == rout.NoDataValue {
+				continue
+			}
+			n++
+			x := rout.West + (float64(col)+0.5)*rout.GetCellSizeX()
+			expected := 1.0
+			if x > 500000 {
+				expected = 2
+			}
+			if z != expected {
+				t.Errorf("cell %v, %v, at easting %v, has a value of %v, expected %v", row, col, x, z, expected)
+			}
+		}
+	}
+	if n < rout.Rows*rout.Columns*3/4 {
+		t.Errorf("only %v cells of the output have values", n)
+	}
+}
+
+func TestCoRegister(t *testing.T) {
+	if median, nmad := medianAndNMAD([]float64{5, 1, 3, 2, 4}); median != 3 || math.Abs(nmad-1.4826) > 1e-12 {
+		t.Errorf("the median and NMAD are %v and %v, expected 3 and 1.4826", median, nmad)
+	}
+	// 2x + y = 5 and x - y = 1, with the first pivot swapped
+	x, err := solveLinearSystem([][]float64{{1, -1}, {2, 1}}, []float64{1, 5})
+	if err != nil || math.Abs(x[0]-2) > 1e-12 || math.Abs(x[1]-1) > 1e-12 {
+		t.Errorf("the solution is %v (%v), expected [2 1]", x, err)
+	}
+	if _, err = solveLinearSystem([][]float64{{1, 2}, {2, 4}}, []float64{1, 2}); err == nil {
+		t.Error("a singular system was solved")
+	}
+	// the transform of an impulse is flat, and the inverse restores it
+	data := []complex128{1, 0, 0, 0, 0, 0, 0, 0}
+	fft(data, false)
+	for i, v := range data {
+		if v != 1 {
+			t.Errorf("frequency %v of an impulse is %v, expected 1", i, v)
+		}
+	}
+	fft(data, true)
+	if data[0] != 1 || data[1] != 0 || data[7] != 0 {
+		t.Errorf("the inverse transform of an impulse is %v", data)
+	}
+
+	// a smooth surface, and a copy shifted by 1.5 m east, 0.75 m south and
+	// 2 m up, which the tool moves back onto the reference
+	dir := t.TempDir()
+	rows, columns := 40, 40
+	surface := func(x, y float64) float64 { return 20*math.Sin(x/7)*math.Cos(y/9) + 0.5*x }
+	ref := make([]float64, rows*columns)
+	target := make([]float64, rows*columns)
+	for i := range ref {
+		x, y := float64(i%columns)+0.5, float64(rows-i/columns)-0.5
+		ref[i] = surface(x, y)
+		target[i] = surface(x-1.5, y+0.75) + 2
+	}
+	writeTestGrid(t, filepath.Join(dir, "ref.tif"), rows, columns, ref...)
+	writeTestGrid(t, filepath.Join(dir, "target.tif"), rows, columns, target...)
+	for _, method := range []string{"nuth", "phase"} {
+		output := filepath.Join(dir, method+".tif")
+		runTestTool(t, "CoRegister", filepath.Join(dir, "ref.tif"), filepath.Join(dir, "target.tif"), output, method, "true")
+		values := readTestGrid(t, output)
+		for row := 5; row < rows-5; row++ {
+			for col := 5; col < columns-5; col++ {
+				if z := values[row*columns+col]; math.Abs(z-ref[row*columns+col]) > 0.1 {
+					t.Errorf("%v: cell %v, %v has a value of %v, expected %v", method, row, col, z, ref[row*columns+col])
+				}
+			}
+		}
+	}
+}
+
+func TestDoD(t *testing.T) {
+	dir := t.TempDir()
+	newDEM, oldDEM := filepath.Join(dir, "new.tif"), filepath.Join(dir, "old.tif")
+	writeTestGrid(t, newDEM, 2, 3, 5, 3, 1.2, 2, math.NaN(), 0)
+	writeTestGrid(t, oldDEM, 2, 3, 2, 3, 1, 4, 1, math.NaN())
+
+	// changes smaller than a uniform LoD of 0.5 are zeroed, and the volumes
+	// of the others, with an uncertainty of the LoD per cell, reported
+	var out strings.Builder
+	defer func(p func(string, ...interface{}) (int, error)) { printf = p }(printf)
+	printf = func(format string, a ...interface{}) (int, error) { return fmt.Fprintf(&out, format, a...) }
+	runTestTool(t, "DoD", newDEM, oldDEM, filepath.Join(dir, "dod.tif"), "0.5")
+	checkTestGrid(t, filepath.Join(dir, "dod.tif"), 1e-6, 3, 0, 0, -2, math.NaN(), math.NaN())
+	for _, line := range []string{"Volume of surface lowering: 2 ± 0.5", "Volume of surface raising: 3 ± 0.5",
+		"Net volume change: 1 ± 1"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("the statistics don't include %q", line)
+		}
+	}
+
+	// a LoD raster varies the threshold by cell
+	lod := filepath.Join(dir, "lod.tif")
+	writeTestGrid(t, lod, 2, 3, 4, 1, 0.1, 1, 1, 1)
+	runTestTool(t, "DoD", newDEM, oldDEM, filepath.Join(dir, "doddist.tif"), lod)
+	checkTestGrid(t, filepath.Join(dir, "doddist.tif"), 1e-6, 0, 0, 0.2, -2, math.NaN(), math.NaN())
+}
+
+func TestStackStatistics(t *testing.T) {
+	dir := t.TempDir()
+	// the second cell has no first layer
+	writeTestGrid(t, filepath.Join(dir, "a.tif"), 1, 2, 1, math.NaN())
+	writeTestGrid(t, filepath.Join(dir, "b.tif"), 1, 2, 3, 4)
+	writeTestGrid(t, filepath.Join(dir, "c.tif"), 1, 2, 8, 6)
+	// relative names are resolved against the directory of the list
+	list := filepath.Join(dir, "stack.txt")
+	if err := os.WriteFile(list, []byte("# layer time\na.tif 0\nb.tif 1\n\nc.tif, 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		statistic string
+		expected  []float64
+	}{
+		{"mean", []float64{4, 5}},
+		{"min", []float64{1, 4}},
+		{"max", []float64{8, 6}},
+		{"range", []float64{7, 2}},
+		{"stdev", []float64{math.Sqrt(13), math.Sqrt(2)}},
+		{"count", []float64{3, 2}},
+		{"trend", []float64{3.5, 2}},
+	} {
+		out := filepath.Join(dir, c.statistic+".tif")
+		runTestTool(t, "StackStatistics", list, out, c.statistic)
+		checkTestGrid(t, out, 1e-5, c.expected...)
+	}
+}
+
+func TestSurfaceAreaRatio(t *testing.T) {
+	dir := t.TempDir()
+	// a flat surface, with missing neighbours taken as flat too
+	flat := filepath.Join(dir, "flat.tif")
+	writeTestGrid(t, flat, 2, 3, 5, 5, 5, 5, 5, math.NaN())
+	runTestTool(t, "SurfaceAreaRatio", flat, filepath.Join(dir, "flatsar.tif"))
+	checkTestGrid(t, filepath.Join(dir, "flatsar.tif"), 1e-6, 1, 1, 1, 1, 1, math.NaN())
+
+	// a 45° plane has sqrt(2) times its planimetric area
+	plane := filepath.Join(dir, "plane.tif")
+	writeTestGrid(t, plane, 3, 3, 0, 1, 2, 0, 1, 2, 0, 1, 2)
+	runTestTool(t, "SurfaceAreaRatio", plane, filepath.Join(dir, "planesar.tif"))
+	if sar := readTestGrid(t, filepath.Join(dir, "planesar.tif"))[4]; math.Abs(sar-math.Sqrt2) > 1e-6 {
+		t.Errorf("the surface area ratio of a 45° plane is %v, expected %v", sar, math.Sqrt2)
+	}
+}
+
+func TestRho8(t *testing.T) {
+	dir := t.TempDir()
+	// the centre cell drops 1 to the east and 1.3 to the south-east, so D8
+	// always picks east, while Rho8 picks south-east when the random
+	// diagonal distance, 2 - p, is less than 1.3, i.e. with probability 0.3
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 3, 12, 12, 12, 12, 10, 9, 12, 12, 8.7)
+	pointer := filepath.Join(dir, "pointer.tif")
+	centre := func(method, seed string) float64 {
+		runTestTool(t, "D8FlowAccumulation", dem, filepath.Join(dir, "fa.tif"), "false", method, seed,
+			"not specified", "not specified", pointer, "whitebox")
+		return readTestGrid(t, pointer)[4]
+	}
+	if p := centre("d8", "not specified"); p != 2 {
+		t.Errorf("D8 points the centre cell to %v, expected 2 (east)", p)
+	}
+	east, southEast := 0, 0
+	for seed := 1; seed <= 40; seed++ {
+		switch centre("rho8", strconv.Itoa(seed)) {
+		case 2:
+			east++
+		case 4:
+			southEast++
+		default:
+			t.Fatalf("Rho8 points the centre cell away from its downslope neighbours with seed %v", seed)
+		}
+	}
+	if east == 0 || southEast == 0 {
+		t.Errorf("Rho8 points the centre cell east %v times and south-east %v times", east, southEast)
+	}
+	// the seed makes the directions repeatable
+	for seed := 1; seed <= 5; seed++ {
+		if a, b := centre("rho8", strconv.Itoa(seed)), centre("rho8", strconv.Itoa(seed)); a != b {
+			t.Errorf("Rho8 with seed %v points the centre cell to %v and then %v", seed, a, b)
+		}
+	}
+}
+
+func TestBreachBarriers(t *testing.T) {
+	dir := t.TempDir()
+	// a depression between a low ridge to the west and a higher one to the east
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 7,
+		10, 10, 10, 10, 10, 10, 10,
+		0, 5, 1, 1, 1, 6, 0,
+		10, 10, 10, 10, 10, 10, 10)
+	// the western ridge is an embankment, with a culvert through it
+	constraint := []float64{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	barrier, culvert := filepath.Join(dir, "barrier.tif"), filepath.Join(dir, "culvert.tif")
+	writeTestGrid(t, barrier, 3, 7, constraint...)
+	writeTestGrid(t, culvert, 3, 7, constraint...)
+
+	for _, c := range []struct {
+		barrier, culvert string
+		west             bool
+	}{
+		{"not specified", "not specified", true},
+		{barrier, "not specified", false},
+		{barrier, culvert, true},
+	} {
+		out := filepath.Join(dir, "breached.tif")
+		runTestTool(t, "BreachDepressions", dem, out, "-1", "-1", "false", "false", c.barrier, c.culvert, "not specified")
+		z := readTestGrid(t, out)
+		// the breach channel lowers one ridge below the depression
+		if west := z[8] < 1 && z[12] == 6; west != c.west || (!west && (z[8] != 5 || z[12] >= 1)) {
+			t.Errorf("barrier %v, culvert %v: the ridges were breached to %v and %v",
+				filepath.Base(c.barrier), filepath.Base(c.culvert), z[8], z[12])
+		}
+	}
+}
+
+func TestBurnWalls(t *testing.T) {
+	dir := t.TempDir()
+	dem, walls, gaps := filepath.Join(dir, "dem.tif"), filepath.Join(dir, "walls.tif"), filepath.Join(dir, "gaps.tif")
+	writeTestGrid(t, dem, 3, 3, 1, 2, 3, 4, 5, 6, math.NaN(), 8, 9)
+	// a diagonal wall, with a culvert at its centre
+	writeTestGrid(t, walls, 3, 3, 1, 0, 0, 0, 1, 0, 0, 0, 1)
+	writeTestGrid(t, gaps, 3, 3, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+	out := filepath.Join(dir, "walled.tif")
+	runTestTool(t, "BurnWalls", dem, walls, out, "10", gaps)
+	// the lower of the orthogonal cells at each diagonal step is also raised
+	checkTestGrid(t, out, 1e-6, 11, 12, 3, 4, 5, 16, math.NaN(), 8, 19)
+}
+
+func TestD8FlowAccumulationFromPointer(t *testing.T) {
+	dir := t.TempDir()
+	// Esri pointers flowing east along each row into the last column, which
+	// flows south to an outlet
+	pointer := filepath.Join(dir, "pointer.tif")
+	writeTestGrid(t, pointer, 2, 3, 1, 1, 4, 1, 1, 0)
+	out := filepath.Join(dir, "fa.tif")
+	runTestTool(t, "D8FlowAccumulation", pointer, out, "false", "d8", "not specified", "esri")
+	checkTestGrid(t, out, 0, 1, 2, 3, 1, 2, 6)
+}
+
+func TestConvertPointer(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "whitebox.tif")
+	// NE, E, SE, S, an outlet, an invalid value and nodata
+	writeTestGrid(t, in, 1, 7, 1, 2, 4, 8, 0, 3, math.NaN())
+	runTestTool(t, "ConvertPointer", in, filepath.Join(dir, "esri.tif"), "whitebox", "esri")
+	checkTestGrid(t, filepath.Join(dir, "esri.tif"), 0, 128, 1, 2, 4, 0, 0, math.NaN())
+	// TauDEM has no code for cells without a flow direction
+	runTestTool(t, "ConvertPointer", in, filepath.Join(dir, "taudem.tif"), "whitebox", "taudem")
+	checkTestGrid(t, filepath.Join(dir, "taudem.tif"), 0, 2, 1, 8, 7, math.NaN(), math.NaN(), math.NaN())
+}
+
+func TestTauDEMMode(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	for _, c := range []struct{ output, expected string }{
+		{"", filepath.Join(dir, "demad8.tif")},
+		{"not specified", filepath.Join(dir, "demad8.tif")},
+		{dir + pathSep, filepath.Join(dir, "demad8.tif")},
+		{os.TempDir(), filepath.Join(os.TempDir(), "demad8.tif")},
+		{"acc.tif", "acc.tif"},
+	} {
+		if f := taudemOutputFile(dem, c.output, "ad8"); f != c.expected {
+			t.Errorf("the TauDEM output for %q is %v, expected %v", c.output, f, c.expected)
+		}
+	}
+
+	// the accumulation and the pointer are named after the DEM, with TauDEM
+	// codes and nodata values
+	writeTestGrid(t, dem, 2, 4, 3, 2, 1, math.NaN(), 3, 2, 1, math.NaN())
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	ptm.TauDEMMode = true
+	if err := ptm.RunWithArguments("D8FlowAccumulation", []string{dem, dir + pathSep, "false"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		suffix string
+		nodata float64
+	}{{"ad8", taudemAreaNodata}, {"p", taudemPointerNodata}} {
+		r, err := raster.CreateRasterFromFile(filepath.Join(dir, "dem"+c.suffix+".tif"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.NoDataValue != c.nodata {
+			t.Errorf("the nodata value of the %s output is %v, expected %v", c.suffix, r.NoDataValue, c.nodata)
+		}
+	}
+	checkTestGrid(t, filepath.Join(dir, "demad8.tif"), 0, 1, 2, 3, math.NaN(), 1, 2, 3, math.NaN())
+	checkTestGrid(t, filepath.Join(dir, "demp.tif"), 0, 1, 1, math.NaN(), math.NaN(), 1, 1, math.NaN(), math.NaN())
+
+	// the output may be omitted only in TauDEM mode
+	os.Remove(filepath.Join(dir, "demad8.tif"))
+	if err := ptm.RunWithArguments("D8FlowAccumulation", []string{dem, "not specified", "false"}); err != nil {
+		t.Fatal(err)
+	}
+	checkTestGrid(t, filepath.Join(dir, "demad8.tif"), 0, 1, 2, 3, math.NaN(), 1, 2, 3, math.NaN())
+	ptm.TauDEMMode = false
+	if err := ptm.RunWithArguments("D8FlowAccumulation", []string{dem, "", "false"}); err == nil {
+		t.Errorf("the output of D8FlowAccumulation was omitted outside TauDEM mode")
+	}
+}
+
+func TestSelfTestChecksums(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.tif"), filepath.Join(dir, "b.tif")
+	writeTestGrid(t, a, 2, 2, 1, 2, math.NaN(), 4)
+	// differences below the rounding of the checksum are ignored
+	writeTestGrid(t, b, 2, 2, 1.0000001, 2, math.NaN(), 4)
+	ca, err := outputChecksum(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb, _ := outputChecksum(b); cb != ca {
+		t.Errorf("the checksums of nearly equal rasters differ: %v and %v", ca, cb)
+	}
+	writeTestGrid(t, b, 2, 2, 1, 2, 3, 4)
+	if cb, _ := outputChecksum(b); cb == ca {
+		t.Errorf("a nodata cell doesn't change the checksum")
+	}
+
+	ptm := PluginToolManager{}
+	ptm.InitializeTools()
+	ptm.SetWorkingDirectory(dir)
+	tc := selfTestCase{"FlipRaster", []string{"a.tif", "vertical", "flipped.tif"}, []string{"flipped.tif"}, nil}
+	writeTestGrid(t, b, 2, 2, math.NaN(), 4, 1, 2)
+	cb, _ := outputChecksum(b)
+	tc.checksums = []string{cb}
+	if err := ptm.runSelfTestCase(dir, tc); err != nil {
+		t.Errorf("the self-test of a flip failed: %v", err)
+	}
+	tc.checksums = []string{ca}
+	if err := ptm.runSelfTestCase(dir, tc); err == nil || !strings.Contains(err.Error(), "checksum of flipped.tif") {
+		t.Errorf("a wrong checksum was reported as %v", err)
+	}
+	tc.outputs = []string{"missing.tif"}
+	if err := ptm.runSelfTestCase(dir, tc); err == nil || !strings.Contains(err.Error(), "could not be read") {
+		t.Errorf("a missing output was reported as %v", err)
+	}
+}
+
+func TestTransformRaster(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 2, 3, 1, 4, 9, math.NaN(), 0, -1)
+	// mean 2.6, population standard deviation sqrt(13.04)
+	sd := math.Sqrt(13.04)
+	for _, c := range []struct {
+		transform, exponent string
+		expected            []float64
+	}{
+		{"ln", "", []float64{0, math.Log(4), math.Log(9), math.NaN(), math.NaN(), math.NaN()}},
+		{"log10", "", []float64{0, math.Log10(4), math.Log10(9), math.NaN(), math.NaN(), math.NaN()}},
+		{"sqrt", "", []float64{1, 2, 3, math.NaN(), 0, math.NaN()}},
+		{"power", "", []float64{1, 16, 81, math.NaN(), 0, 1}},
+		{"power", "0.5", []float64{1, 2, 3, math.NaN(), 0, math.NaN()}},
+		{"zscore", "", []float64{-1.6 / sd, 1.4 / sd, 6.4 / sd, math.NaN(), -2.6 / sd, -3.6 / sd}},
+		{"normalize", "", []float64{0.2, 0.5, 1, math.NaN(), 0.1, 0}},
+	} {
+		out := filepath.Join(dir, c.transform+c.exponent+".tif")
+		runTestTool(t, "TransformRaster", in, out, c.transform, c.exponent)
+		checkTestGrid(t, out, 1e-6, c.expected...)
+	}
+}
+
+func TestUpdateFlowAccum(t *testing.T) {
+	dir := t.TempDir()
+	dem, edited, mask := filepath.Join(dir, "dem.tif"), filepath.Join(dir, "edited.tif"), filepath.Join(dir, "mask.tif")
+	writeTestGrid(t, dem, 3, 4, 9, 8, 7, 6, 8, 7, 6, 5, 7, 6, 5, 4)
+	// lowering one cell turns the flow of its northern neighbours into it
+	writeTestGrid(t, edited, 3, 4, 9, 8, 7, 6, 8, 7, 4.5, 5, 7, 6, 5, 4)
+	writeTestGrid(t, mask, 3, 4, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0)
+	pointer, fa := filepath.Join(dir, "pointer.tif"), filepath.Join(dir, "fa.tif")
+	runTestTool(t, "D8FlowAccumulation", dem, fa, "false", "d8", "", "", "", pointer, "esri")
+
+	out, outPointer := filepath.Join(dir, "updated.tif"), filepath.Join(dir, "updatedpointer.tif")
+	runTestTool(t, "UpdateFlowAccum", edited, pointer, fa, mask, out, outPointer, "esri")
+	// the update matches a full recalculation on the edited DEM
+	full, fullPointer := filepath.Join(dir, "full.tif"), filepath.Join(dir, "fullpointer.tif")
+	runTestTool(t, "D8FlowAccumulation", edited, full, "false", "d8", "", "", "", fullPointer, "esri")
+	checkTestGrid(t, out, 1e-6, readTestGrid(t, full)...)
+	checkTestGrid(t, outPointer, 0, readTestGrid(t, fullPointer)...)
+	checkTestGrid(t, out, 1e-6, 1, 1, 1, 1, 1, 2, 9, 1, 1, 3, 1, 12)
+}
+
+func TestEditDEM(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 4, 10, 10, 10, 10, 10, 10, 12, 9, 10, 10, 10, 10)
+	// the polygon contains the centres of the cells in rows 1-2 and columns 1-2
+	polygon := writeTestShapes(t, filepath.Join(dir, "polygon.shp"), vector.ST_Polygon,
+		vector.NewPolygon([][2]float64{{1, 0}, {3, 0}, {3, 2}, {1, 2}}))
+	// a ditch along row 1 and a breakline along row 2, from west to east
+	ditch := writeTestShapes(t, filepath.Join(dir, "ditch.shp"), vector.ST_PolyLine,
+		vector.NewPolyLine([][2]float64{{0.5, 1.5}, {3.5, 1.5}}))
+	breakline := writeTestShapes(t, filepath.Join(dir, "breakline.shp"), vector.ST_PolyLineZ,
+		vector.Shape{Parts: [][]vector.Point{{
+			{X: 0.5, Y: 0.5, Z: 3, M: vector.NoMeasure},
+			{X: 3.5, Y: 0.5, Z: 6, M: vector.NoMeasure},
+		}}})
+	mask := filepath.Join(dir, "mask.tif")
+	writeTestGrid(t, mask, 3, 4, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0)
+
+	for _, c := range []struct {
+		operation, geometry, value string
+		expected                   []float64
+	}{
+		{"set", polygon, "5", []float64{10, 10, 10, 10, 10, 5, 5, 9, 10, 5, 5, 10}},
+		{"offset", polygon, "2", []float64{10, 10, 10, 10, 10, 12, 14, 9, 10, 12, 12, 10}},
+		// cells are lowered only where they drop by less than the value
+		{"gradient", ditch, "1", []float64{10, 10, 10, 10, 10, 9, 8, 7, 10, 10, 10, 10}},
+		{"breakline", breakline, "", []float64{10, 10, 10, 10, 10, 10, 12, 9, 3, 4, 5, 6}},
+		// the mean of the 3 x 3 window
+		{"smooth", mask, "1", []float64{10, 10, 10, 10, 10, 10, 91.0 / 9.0, 9, 10, 10, 10, 10}},
+	} {
+		out := filepath.Join(dir, c.operation+".tif")
+		runTestTool(t, "EditDEM", dem, out, c.operation, c.geometry, c.value)
+		checkTestGrid(t, out, 1e-5, c.expected...)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 3, 3, 1, 2, 3, 4, math.NaN(), 6, 7, 8, math.NaN())
+	// 2 x 2 blocks, partial at the last row and column; the last block has
+	// no valid cells
+	for _, c := range []struct {
+		statistic string
+		expected  []float64
+	}{
+		{"mean", []float64{7.0 / 3.0, 4.5, 7.5, math.NaN()}},
+		{"min", []float64{1, 3, 7, math.NaN()}},
+		{"max", []float64{4, 6, 8, math.NaN()}},
+		{"range", []float64{3, 3, 1, math.NaN()}},
+		{"stdev", []float64{math.Sqrt(7.0 / 3.0), math.Sqrt(4.5), math.Sqrt(0.5), math.NaN()}},
+		{"count", []float64{3, 2, 2, 0}},
+	} {
+		out := filepath.Join(dir, c.statistic+".tif")
+		runTestTool(t, "Aggregate", in, out, "2", c.statistic)
+		checkTestGrid(t, out, 1e-6, c.expected...)
+	}
+	// the extent grows to whole blocks
+	r, err := raster.CreateRasterFromFile(filepath.Join(dir, "mean.tif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.North != 3 || r.South != -1 || r.East != 4 || r.West != 0 {
+		t.Errorf("the aggregated extent is %v, %v, %v, %v", r.North, r.South, r.East, r.West)
+	}
+}
+
+func TestDisaggregate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	// a plane, z = 1 + (x - 0.5) + 2(1.5 - y)
+	writeTestGrid(t, in, 2, 2, 1, 2, 3, 4)
+	nearest := filepath.Join(dir, "nearest.tif")
+	runTestTool(t, "Disaggregate", in, nearest, "2", "nearest")
+	checkTestGrid(t, nearest, 0, 1, 1, 2, 2, 1, 1, 2, 2, 3, 3, 4, 4, 3, 3, 4, 4)
+
+	// a template raster gives the same grid as the factor
+	for _, template := range []string{"2", nearest} {
+		for _, method := range []string{"bilinear", "spline"} {
+			out := filepath.Join(dir, method+".tif")
+			runTestTool(t, "Disaggregate", in, out, template, method)
+			z := readTestGrid(t, out)
+			if len(z) != 16 {
+				t.Fatalf("%s onto %s: %v cells", method, filepath.Base(template), len(z))
+			}
+			// the plane is reproduced between the input cell centres
+			for i, expected := range map[int]float64{5: 1.75, 6: 2.25, 9: 2.75, 10: 3.25} {
+				if math.Abs(z[i]-expected) > 1e-6 {
+					t.Errorf("%s onto %s: cell %v is %v, expected %v", method, filepath.Base(template), i, z[i], expected)
+				}
+			}
+		}
+	}
+}
+
+func TestElevationPercentile(t *testing.T) {
+	dir := t.TempDir()
+	rows, columns := 6, 5
+	values := make([]float64, rows*columns)
+	for i, v := range rand.New(rand.NewSource(1)).Perm(len(values)) {
+		values[i] = float64(v + 1)
+	}
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, rows, columns, values...)
+
+	// with a bin for each value, the percentile of a cell is the percentage of
+	// its 3 x 3 neighbourhood that is no higher than it
+	runTestTool(t, "ElevationPercentile", dem, filepath.Join(dir, "ep.tif"), "1", "30")
+	ep := readTestGrid(t, filepath.Join(dir, "ep.tif"))
+	// the integral histogram excludes the first row and column from the
+	// neighbourhoods of the second
+	for row := 2; row < rows; row++ {
+		for col := 2; col < columns; col++ {
+			n, numNotHigher := 0, 0
+			for r := row - 1; r <= row+1 && r < rows; r++ {
+				for c := col - 1; c <= col+1 && c < columns; c++ {
+					n++
+					if values[r*columns+c] <= values[row*columns+col] {
+						numNotHigher++
+					}
+				}
+			}
+			if expected := 100 * float64(numNotHigher) / float64(n); math.Abs(ep[row*columns+col]-expected) > 1e-4 {
+				t.Errorf("the percentile of cell %v, %v is %v, expected %v", row, col, ep[row*columns+col], expected)
+			}
+		}
+	}
+
+	// blocks of one row give the same percentiles as a single block
+	defer func(b int) { elevationPercentileBlockBytes = b }(elevationPercentileBlockBytes)
+	elevationPercentileBlockBytes = columns * 30 * 4 * 4
+	runTestTool(t, "ElevationPercentile", dem, filepath.Join(dir, "epblocks.tif"), "1", "30")
+	checkTestGrid(t, filepath.Join(dir, "epblocks.tif"), 0, ep...)
+}
+
+func TestMultiscaleSignature(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 3, 1, 2, 3, 4, 9, 6, 7, 8, 5)
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("x y name\n1.5 1.5 peak\n0.5 2.5 corner\n10 10 outside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "signatures.csv")
+	runTestTool(t, "MultiscaleSignature", dem, points, out, "1", "2", "1")
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// neighbourhoods are clipped at the edges, and the points outside the DEM skipped
+	expected := "point,x,y,scale,n,dev,percentile\n" +
+		"peak,1.5,1.5,1,9,1.5492,88.8889\n" +
+		"peak,1.5,1.5,2,9,1.5492,88.8889\n" +
+		"corner,0.5,2.5,1,4,-0.9733,0.0000\n" +
+		"corner,0.5,2.5,2,9,-1.5492,0.0000\n"
+	if string(b) != expected {
+		t.Errorf("the signatures are\n%s\nexpected\n%s", b, expected)
+	}
+}
+
+func TestAnisotropicDeviation(t *testing.T) {
+	dir := t.TempDir()
+	// a north-south ridge one cell wide on a flat plain
+	const size = 11
+	values := make([]float64, size*size)
+	for row := 0; row < size; row++ {
+		values[row*size+size/2] = 1
+	}
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, size, size, values...)
+	mag, scale, orient := filepath.Join(dir, "mag.tif"), filepath.Join(dir, "scale.tif"), filepath.Join(dir, "orient.tif")
+	runTestTool(t, "AnisotropicDeviation", dem, mag, scale, orient, "1", "3", "1", "3", "2")
+
+	// at the centre of the ridge, the 3 x 7 window across it, with 3 ridge
+	// cells, has a DEV of (1 - 1/7) / sqrt(1/7 * 6/7) = sqrt(6), greater than
+	// the sqrt(2) of the 7 x 3 window along it
+	centre := size*(size/2) + size/2
+	if z := readTestGrid(t, mag)[centre]; math.Abs(z-math.Sqrt(6)) > 1e-5 {
+		t.Errorf("the DEV of the ridge is %v, expected %v", z, math.Sqrt(6))
+	}
+	if z := readTestGrid(t, scale)[centre]; z != 3 {
+		t.Errorf("the scale of the ridge is %v, expected 3", z)
+	}
+	if z := readTestGrid(t, orient)[centre]; z != 90 {
+		t.Errorf("the orientation of the ridge is %v, expected 90", z)
+	}
+}
+
+func TestFloodFill(t *testing.T) {
+	dir := t.TempDir()
+	// low and high cells that connect only diagonally
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 3, 4, 1, 9, 1, 9, 9, 1, 9, 1, 1, 9, 9, 1)
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("0.5 2.5\n1.5 2.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seeds := filepath.Join(dir, "seeds.tif")
+	writeTestGrid(t, seeds, 3, 4, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3)
+
+	for _, c := range []struct {
+		seeds, predicate, threshold, connectivity string
+		expected                                  []float64
+	}{
+		{points, "below", "2", "8", []float64{1, 0, 1, 0, 0, 1, 0, 1, 1, 0, 0, 1}},
+		{points, "below", "2", "4", []float64{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		// the second point seeds the high cells
+		{points, "above", "5", "8", []float64{0, 2, 0, 2, 2, 0, 2, 0, 0, 2, 2, 0}},
+		{points, "tolerance", "0.5", "8", []float64{1, 2, 1, 2, 2, 1, 2, 1, 1, 2, 2, 1}},
+		{seeds, "below", "2", "4", []float64{7, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 3}},
+	} {
+		out := filepath.Join(dir, "flooded.tif")
+		runTestTool(t, "FloodFill", in, c.seeds, out, c.predicate, c.threshold, c.connectivity)
+		checkTestGrid(t, out, 0, c.expected...)
+	}
+}
+
+func TestCoastalInundation(t *testing.T) {
+	dir := t.TempDir()
+	// the sea is nodata to the west; the low cell at row 1, column 3 is cut off
+	// from it by higher ground
+	nd := math.NaN()
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 4, 5,
+		nd, 2, 2, 2, 2,
+		nd, 1, 2, 0.5, 2,
+		nd, 0.5, 2, 2, 2,
+		nd, 2, 2, 2, 2)
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("3.5 2.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		seeds         string
+		depth, extent []float64
+	}{
+		{"edge",
+			[]float64{nd, 0, 0, 0, 0, nd, 0.5, 0, 0, 0, nd, 1, 0, 0, 0, nd, 0, 0, 0, 0},
+			[]float64{nd, 0, 0, 0, 0, nd, 1, 0, 0, 0, nd, 1, 0, 0, 0, nd, 0, 0, 0, 0}},
+		{points,
+			[]float64{nd, 0, 0, 0, 0, nd, 0, 0, 1, 0, nd, 0, 0, 0, 0, nd, 0, 0, 0, 0},
+			[]float64{nd, 0, 0, 0, 0, nd, 0, 0, 1, 0, nd, 0, 0, 0, 0, nd, 0, 0, 0, 0}},
+	} {
+		depth, extent := filepath.Join(dir, "depth.tif"), filepath.Join(dir, "extent.tif")
+		runTestTool(t, "CoastalInundation", dem, c.seeds, "1.5", depth, extent)
+		checkTestGrid(t, depth, 1e-6, c.depth...)
+		checkTestGrid(t, extent, 0, c.extent...)
+	}
+}
+
+func TestFrontTravelTime(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 2, 3, 4, 3, 3, 4, 0, 5)
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("0.5 1.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the diagonal drop of 4 has a gradient of 2 sqrt(2), and so a celerity of
+	// its square root; flats are crossed at the minimum celerity of 0.5
+	diagonal := math.Sqrt2 / math.Sqrt(2*math.Sqrt2)
+	out := filepath.Join(dir, "time.tif")
+	runTestTool(t, "FrontTravelTime", dem, points, out, "1", "0.5", "false")
+	checkTestGrid(t, out, 1e-5, 0, 1, 3, 2, diagonal, math.NaN())
+	// uphill moves proceed at the minimum celerity
+	runTestTool(t, "FrontTravelTime", dem, points, out, "1", "0.5", "true")
+	checkTestGrid(t, out, 1e-5, 0, 1, 3, 2, diagonal, diagonal+2)
+}
+
+func TestFlattenLakes(t *testing.T) {
+	dir := t.TempDir()
+	// a lake in row 1 that drains west over the shoreline cell at 5
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 5, 10, 10, 10, 10, 10, 5, 7, 3, 8, 10, 10, 10, 10, 10, 10)
+	mask := filepath.Join(dir, "lake.tif")
+	writeTestGrid(t, mask, 3, 5, 0, 0, 0, 0, 0, 0, 1, 1, 1, 0, 0, 0, 0, 0, 0)
+	polygon := writeTestShapes(t, filepath.Join(dir, "lake.shp"), vector.ST_Polygon,
+		vector.NewPolygon([][2]float64{{1, 1}, {4, 1}, {4, 2}, {1, 2}}))
+	for _, waterbody := range []string{mask, polygon} {
+		out := filepath.Join(dir, "flattened.tif")
+		runTestTool(t, "FlattenLakes", dem, waterbody, out, "0.1")
+		// the surface rises by the increment per cell from the outlet
+		checkTestGrid(t, out, 1e-5, 10, 10, 10, 10, 10, 5, 5.1, 5.2, 5.3, 10, 10, 10, 10, 10, 10)
+	}
+}
+
+func TestXYZ(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 2, 3, 1, 2, 3, 4, math.NaN(), 6)
+	read := func(fileName string) string {
+		b, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	// the centres of the valid cells, from the north-west corner
+	csv := filepath.Join(dir, "in.csv")
+	runTestTool(t, "WriteXYZ", in, csv)
+	if s := read(csv); s != "x,y,z\n0.5,1.5,1\n1.5,1.5,2\n2.5,1.5,3\n0.5,0.5,4\n2.5,0.5,6\n" {
+		t.Errorf("the exported cells are\n%s", s)
+	}
+	runTestTool(t, "WriteXYZ", in, filepath.Join(dir, "decimated.xyz"), "2")
+	if s := read(filepath.Join(dir, "decimated.xyz")); s != "0.5 1.5 1\n2.5 1.5 3\n" {
+		t.Errorf("the decimated cells are\n%s", s)
+	}
+	// the exported cells are read back onto the same grid
+	runTestTool(t, "ReadXYZ", csv, filepath.Join(dir, "roundtrip.tif"), "1")
+	checkTestGrid(t, filepath.Join(dir, "roundtrip.tif"), 0, 1, 2, 3, 4, math.NaN(), 6)
+
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("x y z\n0.5 0.5 1\n0.5 0.5 3\n1.5 0.5 5\n0.5 1.5 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		statistic string
+		expected  []float64
+	}{
+		{"mean", []float64{7, math.NaN(), 2, 5}},
+		{"min", []float64{7, math.NaN(), 1, 5}},
+		{"max", []float64{7, math.NaN(), 3, 5}},
+		{"count", []float64{1, math.NaN(), 2, 1}},
+		{"last", []float64{7, math.NaN(), 3, 5}},
+	} {
+		out := filepath.Join(dir, c.statistic+".tif")
+		runTestTool(t, "ReadXYZ", points, out, "1", c.statistic)
+		checkTestGrid(t, out, 1e-6, c.expected...)
+	}
+}
+
+func TestSampleRaster(t *testing.T) {
+	dir := t.TempDir()
+	// the value of each cell is its row-major index plus one
+	in, classes := filepath.Join(dir, "in.tif"), filepath.Join(dir, "classes.tif")
+	writeTestGrid(t, in, 3, 3, 1, 2, 3, 4, math.NaN(), 6, 7, 8, 9)
+	writeTestGrid(t, classes, 3, 3, 1, 1, 1, 2, 2, 2, 2, 2, 2)
+	sample := func(numSamples, classFile, seed string) [][]string {
+		out := filepath.Join(dir, "sample.csv")
+		runTestTool(t, "SampleRaster", in, out, numSamples, classFile, seed)
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		rows := make([][]string, 0)
+		for i, line := range lines[1:] {
+			fields := strings.Split(line, ",")
+			x, _ := strconv.ParseFloat(fields[1], 64)
+			y, _ := strconv.ParseFloat(fields[2], 64)
+			if expected := strconv.Itoa(int(3-y)*3 + int(x) + 1); fields[0] != strconv.Itoa(i+1) || fields[3] != expected {
+				t.Errorf("sample %q isn't the cell at its coordinates, with value %v", line, expected)
+			}
+			rows = append(rows, fields)
+		}
+		return rows
+	}
+	values := func(rows [][]string) string {
+		s := make([]string, len(rows))
+		for i, fields := range rows {
+			s[i] = strings.Join(fields[3:], ":")
+		}
+		return strings.Join(s, " ")
+	}
+
+	// a sample of distinct valid cells in row-major order, repeatable by its seed
+	a := values(sample("4", "", "1"))
+	if len(strings.Fields(a)) != 4 || a != values(sample("4", "", "1")) {
+		t.Errorf("samples %v with the same seed differ or have the wrong size", a)
+	}
+	fields := strings.Fields(a)
+	for i := 1; i < len(fields); i++ {
+		previous, _ := strconv.Atoi(fields[i-1])
+		if v, _ := strconv.Atoi(fields[i]); v <= previous {
+			t.Errorf("the sample %v isn't in row-major order", a)
+		}
+	}
+	// a sample larger than the valid cells takes all of them
+	if s := values(sample("20", "", "1")); s != "1 2 3 4 6 7 8 9" {
+		t.Errorf("the sample of all cells is %v", s)
+	}
+	// a stratified sample takes up to two cells of each class
+	s := values(sample("2", classes, "1"))
+	if strat := strings.Fields(s); len(strat) != 4 || !strings.HasSuffix(strat[0], ":1") ||
+		!strings.HasSuffix(strat[1], ":1") || !strings.HasSuffix(strat[2], ":2") || !strings.HasSuffix(strat[3], ":2") {
+		t.Errorf("the stratified sample is %v", s)
+	}
+}
+
+func TestAccuracyAssessment(t *testing.T) {
+	dir := t.TempDir()
+	classified, reference := filepath.Join(dir, "classified.tif"), filepath.Join(dir, "reference.tif")
+	writeTestGrid(t, classified, 2, 3, 1, 1, 2, 2, 2, math.NaN())
+	writeTestGrid(t, reference, 2, 3, 1, 2, 2, 2, 1, 1)
+	out := filepath.Join(dir, "accuracy.csv")
+	runTestTool(t, "AccuracyAssessment", classified, reference, out)
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 of the 5 cells agree, and 13/25 would by chance, so kappa is
+	// (0.6 - 0.52) / (1 - 0.52)
+	for _, line := range []string{"1,1,1,2,0.5000\n", "2,1,2,3,0.6667\n", "total,2,3,5\n",
+		"producer's,0.5000,0.6667\n", "overall accuracy,0.6000\n", "kappa,0.1667\n"} {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("the report doesn't contain %q:\n%s", line, b)
+		}
+	}
+
+	// reference points off the raster or on nodata cells are ignored
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("0.5 1.5 1\n1.5 1.5 1\n2.5 1.5 1\n2.5 0.5 2\n10 10 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runTestTool(t, "AccuracyAssessment", classified, points, out)
+	if b, _ = os.ReadFile(out); !strings.Contains(string(b), "total,3,0,3\n") || !strings.Contains(string(b), "overall accuracy,0.6667\n") {
+		t.Errorf("the report of the points is\n%s", b)
+	}
+}
+
+func TestPCA(t *testing.T) {
+	dir := t.TempDir()
+	// the second layer is twice the first, except for a nodata cell
+	writeTestGrid(t, filepath.Join(dir, "a.tif"), 2, 3, 1, 2, 3, 4, 9, 0)
+	writeTestGrid(t, filepath.Join(dir, "b.tif"), 2, 3, 2, 4, 6, 8, math.NaN(), 0)
+	list := filepath.Join(dir, "stack.txt")
+	if err := os.WriteFile(list, []byte("a.tif\nb.tif\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the valid cells of a have a mean of 2 and a sample variance of 2.5
+	for _, c := range []struct {
+		standardize string
+		scale       float64 // of the scores of PC1 relative to a - 2
+	}{
+		{"true", math.Sqrt2 / math.Sqrt(2.5)},
+		{"false", math.Sqrt(5)},
+	} {
+		runTestTool(t, "PCA", list, filepath.Join(dir, "pca.tif"), "1", c.standardize)
+		expected := []float64{-1, 0, 1, 2, math.NaN(), -2}
+		for i := range expected {
+			expected[i] *= c.scale
+		}
+		checkTestGrid(t, filepath.Join(dir, "pca_PC1.tif"), 1e-5, expected...)
+		b, err := os.ReadFile(filepath.Join(dir, "pca_loadings.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// all of the variance is in the first component
+		if !strings.Contains(string(b), ",100.0000,100.0000\n") || !strings.Contains(string(b), "a.tif,1.000000,") {
+			t.Errorf("standardize %v: the loadings are\n%s", c.standardize, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pca_PC2.tif")); err == nil {
+		t.Errorf("a second component was written")
+	}
+}
+
+func TestKMeans(t *testing.T) {
+	dir := t.TempDir()
+	// three well separated clusters, at a = 1, 5 and 10
+	writeTestGrid(t, filepath.Join(dir, "a.tif"), 3, 3, 1, 1.2, 5, 5.2, 10, 10.1, 0.8, 4.9, math.NaN())
+	writeTestGrid(t, filepath.Join(dir, "b.tif"), 3, 3, 10, 10.1, 0, 0.2, 5, 5.1, 9.9, 0.1, 3)
+	list := filepath.Join(dir, "stack.txt")
+	if err := os.WriteFile(list, []byte("a.tif\nb.tif\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the classes are numbered by the centres of the first layer, whatever
+	// the initial centres
+	out := filepath.Join(dir, "kmeans.tif")
+	for _, standardize := range []string{"true", "false"} {
+		for seed := 1; seed <= 5; seed++ {
+			runTestTool(t, "KMeans", list, out, "3", "50", standardize, strconv.Itoa(seed))
+			checkTestGrid(t, out, 0, 1, 1, 2, 2, 3, 3, 1, 2, math.NaN())
+		}
+	}
+}
+
+func TestAlignRasters(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.tif")
+	writeTestGrid(t, base, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	// the input is offset by one and a half cells to the east of the base
+	writeInput := func(west, cellSize float64) string {
+		config := raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_FLOAT64
+		config.EPSGCode = 32617
+		input := filepath.Join(dir, "input.tif")
+		r, err := raster.CreateNewRaster(input, 3, 3, 3*cellSize, 0, west+3*cellSize, west, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 9; i++ {
+			r.SetValue(i/3, i%3, float64(i+1))
+		}
+		if err = r.Save(); err != nil {
+			t.Fatal(err)
+		}
+		return input
+	}
+	input := writeInput(1.5, 1)
+	nan := math.NaN()
+	out := filepath.Join(dir, "aligned.tif")
+	runTestTool(t, "AlignRasters", input, base, out, "nearest")
+	checkTestGrid(t, out, 0, nan, 1, 2, nan, 4, 5, nan, 7, 8)
+	// bilinear is the default for a float raster; the input's first column
+	// lies half off its own edge and is not blended with nodata
+	runTestTool(t, "AlignRasters", input, base, out)
+	checkTestGrid(t, out, 1e-9, nan, 1, 1.5, nan, 4, 4.5, nan, 7, 7.5)
+
+	// an input of a different resolution is not resampled
+	os.Remove(out)
+	input = writeInput(0, 1.5)
+	runTestTool(t, "AlignRasters", input, base, out)
+	if _, err := os.Stat(out); err == nil {
+		t.Errorf("AlignRasters wrote an input of a different cell size")
+	}
+}
+
+func TestRasterFootprint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "tile.tif")
+	writeTestGrid(t, input, 3, 4, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+	runTestTool(t, "RasterFootprint", input, filepath.Join(dir, "footprint"), "2")
+	readShapes := func(fileName string, fields ...string) []string {
+		t.Helper()
+		shp, err := vector.CreateShapefileFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shp.EPSGCode != 32617 {
+			t.Errorf("%v is in EPSG %v, expected 32617", filepath.Base(fileName), shp.EPSGCode)
+		}
+		var shapes []string
+		for i := 0; i < shp.NumShapes(); i++ {
+			var vertices []string
+			for _, p := range shp.GetShape(i).Points() {
+				vertices = append(vertices, fmt.Sprintf("%v %v", p.X, p.Y))
+			}
+			s := strings.Join(vertices, ", ")
+			for _, f := range fields {
+				s += fmt.Sprintf("; %v", shp.GetAttribute(i, f))
+			}
+			shapes = append(shapes, s)
+		}
+		return shapes
+	}
+	// the footprint is a clockwise ring, and the graticule lines lie
+	// strictly within the extent
+	for _, c := range []struct {
+		fileName string
+		fields   []string
+		expected []string
+	}{
+		{"footprint.shp", []string{"NAME", "ROWS", "COLUMNS"}, []string{"0 3, 4 3, 4 0, 0 0, 0 3; tile.tif; 3; 4"}},
+		{"footprint_graticule.shp", []string{"AXIS", "VALUE"}, []string{"2 0, 2 3; x; 2", "0 2, 4 2; y; 2"}},
+	} {
+		shapes := readShapes(filepath.Join(dir, c.fileName), c.fields...)
+		if strings.Join(shapes, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("%v:\n%s\nexpected:\n%s", c.fileName, strings.Join(shapes, "\n"), strings.Join(c.expected, "\n"))
+		}
+	}
+
+	// the graticule can be named, and without a spacing isn't written
+	runTestTool(t, "RasterFootprint", input, filepath.Join(dir, "fp.shp"), "", filepath.Join(dir, "grid.shp"))
+	if _, err := os.Stat(filepath.Join(dir, "grid.shp")); err == nil {
+		t.Error("a graticule was written without a spacing")
+	}
+	runTestTool(t, "RasterFootprint", input, filepath.Join(dir, "fp.shp"), "3", filepath.Join(dir, "grid.shp"))
+	if shapes := readShapes(filepath.Join(dir, "grid.shp"), "AXIS"); len(shapes) != 1 || shapes[0] != "3 0, 3 3; x" {
+		t.Errorf("the named graticule is %v, expected the line x = 3", shapes)
+	}
+}
+
+func TestTileIndex(t *testing.T) {
+	dir := t.TempDir()
+	tiles := filepath.Join(dir, "tiles")
+	if err := os.Mkdir(tiles, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestGridAt(t, filepath.Join(tiles, "b.tif"), 1, 2, 2, 0, 3, 5)
+	writeTestGridAt(t, filepath.Join(tiles, "a.tif"), 1, 2, 0, 0, 1, 2)
+	if err := os.WriteFile(filepath.Join(tiles, "notes.txt"), []byte("not a raster"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(dir, "index.csv")
+	runTestTool(t, "TileIndex", tiles, index)
+	b, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "file,rows,columns,north,south,east,west,cell_size_x,cell_size_y,epsg,wkt\n" +
+		"tiles/a.tif,1,2,1,0,2,0,1,1,32617,\"POLYGON ((0 1, 2 1, 2 0, 0 0, 0 1))\"\n" +
+		"tiles/b.tif,1,2,1,0,4,2,1,1,32617,\"POLYGON ((2 1, 4 1, 4 0, 2 0, 2 1))\"\n"
+	if string(b) != expected {
+		t.Errorf("tile index:\n%s\nexpected:\n%s", b, expected)
+	}
+
+	// each tile is normalized on its own
+	runTestTool(t, "BatchTiles", index, "TransformRaster", "{tile} {dir}/{name}_{index}.tif normalize", "0")
+	checkTestGrid(t, filepath.Join(tiles, "a_1.tif"), 1e-9, 0, 1)
+	checkTestGrid(t, filepath.Join(tiles, "b_2.tif"), 1e-9, 0, 1)
+
+	// an output that would be overwritten by every tile isn't run
+	runTestTool(t, "BatchTiles", index, "TransformRaster", "{tile} "+filepath.Join(dir, "out.tif")+" normalize", "0")
+	if _, err := os.Stat(filepath.Join(dir, "out.tif")); err == nil {
+		t.Error("a constant output was written")
+	}
+}
+
+func TestBufferedTiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGridAt(t, filepath.Join(dir, "a.tif"), 1, 2, 0, 0, 1, 2)
+	writeTestGridAt(t, filepath.Join(dir, "b.tif"), 1, 2, 2, 0, 3, 5)
+	index := filepath.Join(dir, "index.csv")
+	runTestTool(t, "TileIndex", dir, index)
+
+	// with a buffer of one cell, each tile is normalized by the range of its
+	// own cells and its neighbour's adjacent cell, and the output is trimmed
+	// back to the tile
+	runTestTool(t, "BatchTiles", index, "TransformRaster", "{tile} {dir}/{name}_norm.tif normalize", "1")
+	checkTestGrid(t, filepath.Join(dir, "a_norm.tif"), 1e-6, 0, 0.5)
+	checkTestGrid(t, filepath.Join(dir, "b_norm.tif"), 1e-6, 1.0/3, 1)
+	r, err := raster.CreateRasterFromFile(filepath.Join(dir, "b_norm.tif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.North != 1 || r.South != 0 || r.East != 4 || r.West != 2 {
+		t.Errorf("the trimmed output has the extent N %v, S %v, E %v, W %v", r.North, r.South, r.East, r.West)
+	}
+}
+
+func TestDEMQualityReport(t *testing.T) {
+	dir := t.TempDir()
+	nan := math.NaN()
+	// an enclosed void, a spike, a well and nodata at the edge
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 5, 5,
+		10, 10, 10, 10, nan,
+		10, nan, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 0, 10, 30, 10,
+		10, 10, 10, 10, 10)
+	out := filepath.Join(dir, "flags.tif")
+	report := filepath.Join(dir, "report.csv")
+	runTestTool(t, "DEMQualityReport", dem, out, report)
+	checkTestGrid(t, out, 0,
+		0, 0, 0, 0, nan,
+		0, 1, 0, 0, 0,
+		0, 0, 0, 0, 0,
+		0, 3, 0, 2, 0,
+		0, 0, 0, 0, 0)
+	b, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "issue,cells,area,x,y,magnitude\n" +
+		"void,1,1.00,1.5,3.5,0\n" +
+		"well,1,1.00,1.5,1.5,-10\n" +
+		"spike,1,1.00,3.5,1.5,20\n" +
+		"stripe_rows,,,,,0.0000\n" +
+		"stripe_columns,,,,,0.0000\n"
+	if string(b) != expected {
+		t.Errorf("report:\n%s\nexpected:\n%s", b, expected)
+	}
+
+	// noise with every eighth row offset; the high-pass filter also shifts the
+	// rows either side of a stripe, which may be flagged, but no others
+	rows, columns := 32, 32
+	rnd := rand.New(rand.NewSource(1))
+	values := make([]float64, rows*columns)
+	for i := range values {
+		values[i] = 100 + rnd.Float64()
+		if (i/columns)%8 == 3 {
+			values[i] += 2
+		}
+	}
+	writeTestGrid(t, dem, rows, columns, values...)
+	runTestTool(t, "DEMQualityReport", dem, out, report)
+	flags := readTestGrid(t, out)
+	for row := 0; row < rows; row++ {
+		flagged := flags[row*columns] == 4
+		for col := 1; col < columns; col++ {
+			if (flags[row*columns+col] == 4) != flagged {
+				t.Fatalf("row %v is only partly flagged", row)
+			}
+		}
+		if stripe := row%8 == 3; stripe && !flagged || !stripe && flagged && row%8 != 2 && row%8 != 4 {
+			t.Errorf("row %v: flagged %v", row, flagged)
+		}
+	}
+}
+
+func TestDespike(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	out := filepath.Join(dir, "despiked.tif")
+	// a corner spike with only three neighbours, a spike, a well and a small
+	// dip
+	writeTestGrid(t, dem, 5, 5,
+		50, 10, 10, 10, 10,
+		10, 10, 10, 30, 10,
+		10, 10, 10, 10, 10,
+		10, 7, 10, 0, 10,
+		10, 10, 10, 10, 10)
+	runTestTool(t, "Despike", dem, out)
+	checkTestGrid(t, out, 0,
+		50, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 7, 10, 10, 10,
+		10, 10, 10, 10, 10)
+	runTestTool(t, "Despike", dem, out, "2")
+	checkTestGrid(t, out, 0,
+		50, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10)
+
+	// a 3 x 3 cluster is removed from its corners inwards
+	values := make([]float64, 49)
+	for i := range values {
+		values[i] = 10
+		if row, col := i/7, i%7; row >= 2 && row <= 4 && col >= 2 && col <= 4 {
+			values[i] = 40
+		}
+	}
+	writeTestGrid(t, dem, 7, 7, values...)
+	for _, c := range []struct {
+		iterations      string
+		corner, edge, z float64
+	}{
+		{"1", 10, 40, 40},
+		// the centre is then the median of four corners and four edges
+		{"2", 10, 10, 25},
+		{"10", 10, 10, 10},
+	} {
+		runTestTool(t, "Despike", dem, out, "5", c.iterations)
+		v := readTestGrid(t, out)
+		if v[2*7+2] != c.corner || v[2*7+3] != c.edge || v[3*7+3] != c.z || v[0] != 10 {
+			t.Errorf("after %v iterations, the corner, edge and centre are %v, %v and %v", c.iterations, v[2*7+2], v[2*7+3], v[3*7+3])
+		}
+	}
+}
+
+func TestDestripe(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	out := filepath.Join(dir, "destriped.tif")
+	// a plane with every other row raised by 1
+	rows, columns := 24, 24
+	plane := func(row, col int) float64 { return 100 + 0.5*float64(col) + 0.3*float64(row) }
+	values := make([]float64, rows*columns)
+	for i := range values {
+		values[i] = plane(i/columns, i%columns)
+		if (i/columns)%2 == 1 {
+			values[i]++
+		}
+	}
+	writeTestGrid(t, dem, rows, columns, values...)
+	for _, c := range []struct {
+		direction, method string
+		maxStep           float64
+	}{
+		{"rows", "fft", 0.05},
+		{"auto", "fft", 0.05},
+		// the medians across the stripes are offset by the slope of the plane
+		{"rows", "median", 0.3},
+		// stripes along the rows are unchanged by the columns filter
+		{"columns", "fft", 1},
+	} {
+		runTestTool(t, "Destripe", dem, out, c.direction, c.method)
+		v := readTestGrid(t, out)
+		// the difference from the plane is the same along each row, and
+		// steps between rows by no more than maxStep
+		maxStep := 0.0
+		for row := 0; row < rows; row++ {
+			d := v[row*columns] - plane(row, 0)
+			for col := 1; col < columns; col++ {
+				if math.Abs(v[row*columns+col]-plane(row, col)-d) > 1e-6 {
+					t.Fatalf("%v %v: row %v varies along its length", c.direction, c.method, row)
+				}
+			}
+			if row > 0 {
+				maxStep = math.Max(maxStep, math.Abs(d-(v[(row-1)*columns]-plane(row-1, 0))))
+			}
+		}
+		if maxStep > c.maxStep+1e-6 || c.maxStep == 1 && maxStep < 1-1e-6 {
+			t.Errorf("%v %v: the rows step by up to %v", c.direction, c.method, maxStep)
+		}
+	}
+}
+
+func TestFlowpathSmoothing(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	out := filepath.Join(dir, "smoothed.tif")
+	// a uniform slope, with a bump at the third cell, draining east
+	writeTestGrid(t, dem, 1, 7, 7, 6, 5.4, 4, 3, 2, 1)
+	// the divide and the outlet are unchanged, and the path is shortened
+	// near them to keep it symmetric
+	runTestTool(t, "FlowpathSmoothing", dem, out, "1")
+	checkTestGrid(t, out, 1e-6, 7, 18.4/3, 15.4/3, 12.4/3, 3, 2, 1)
+	runTestTool(t, "FlowpathSmoothing", dem, out, "3")
+	checkTestGrid(t, out, 1e-6, 7, 18.4/3, 25.4/5, 28.4/7, 15.4/5, 2, 1)
+
+	// a uniform slope is unchanged
+	writeTestGrid(t, dem, 1, 7, 7, 6, 5, 4, 3, 2, 1)
+	runTestTool(t, "FlowpathSmoothing", dem, out, "3", "2")
+	checkTestGrid(t, out, 1e-6, 7, 6, 5, 4, 3, 2, 1)
+}
+
+func TestTraceDownslope(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	// a valley draining east off the DEM, with a pit at its western end
+	writeTestGrid(t, dem, 3, 5,
+		10, 10, 10, 10, 10,
+		10, 6, 7, 8, 4,
+		10, 10, 10, 10, 10)
+	streams := filepath.Join(dir, "streams.tif")
+	writeTestGrid(t, streams, 3, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0)
+	points := filepath.Join(dir, "points.txt")
+	if err := os.WriteFile(points, []byte("3.5 1.5 east\n2.5 1.5 west\n10 10 outside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	defer func(p func(string, ...interface{}) (int, error)) { printf = p }(printf)
+	printf = func(format string, a ...interface{}) (int, error) { return fmt.Fprintf(&out, format, a...) }
+
+	paths := filepath.Join(dir, "paths.shp")
+	for _, c := range []struct {
+		streams, eastEnd string
+	}{
+		{"", "end edge at (4.5, 1.5)"},
+		{streams, "end stream at (4.5, 1.5)"},
+	} {
+		out.Reset()
+		runTestTool(t, "TraceDownslope", dem, points, paths, "", c.streams)
+		for _, s := range []string{"east: 2 cells, length 1, drop 4, " + c.eastEnd,
+			"west: 2 cells, length 1, drop 1, end pit at (1.5, 1.5)", "Paths traced: 2 of 3 points"} {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("the output does not include %q:\n%s", s, out.String())
+			}
+		}
+	}
+	expected := "point,cell,x,y,distance,elevation\n" +
+		"east,1,3.5,1.5,0,8\neast,2,4.5,1.5,1,4\nwest,1,2.5,1.5,0,7\nwest,2,1.5,1.5,1,6\n"
+	if b, err := os.ReadFile(filepath.Join(dir, "paths.csv")); err != nil || string(b) != expected {
+		t.Errorf("paths.csv:\n%s\nexpected:\n%s (%v)", b, expected, err)
+	}
+	checkPaths := func(expected ...string) {
+		t.Helper()
+		shp, err := vector.CreateShapefileFromFile(paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shp.NumShapes() != len(expected) || shp.EPSGCode != 32617 {
+			t.Fatalf("%v paths in EPSG %v, expected %v in 32617", shp.NumShapes(), shp.EPSGCode, len(expected))
+		}
+		for i, e := range expected {
+			var vertices []string
+			for _, p := range shp.GetShape(i).Points() {
+				vertices = append(vertices, fmt.Sprintf("{%v %v}", p.X, p.Y))
+			}
+			path := fmt.Sprintf("[%s] %v %v %v %v %v", strings.Join(vertices, " "), shp.GetAttribute(i, "POINT"),
+				shp.GetAttribute(i, "CELLS"), shp.GetAttribute(i, "LENGTH"), shp.GetAttribute(i, "DROP"),
+				shp.GetAttribute(i, "END"))
+			if path != e {
+				t.Errorf("path %v is %v, expected %v", i+1, path, e)
+			}
+		}
+	}
+	checkPaths("[{3.5 1.5} {4.5 1.5}] east 2 1 4 stream", "[{2.5 1.5} {1.5 1.5}] west 2 1 1 pit")
+
+	// a path that starts on a flat is a line of zero length
+	writeTestGrid(t, dem, 3, 4,
+		10, 10, 10, 10,
+		10, 6, 6, 10,
+		10, 10, 10, 10)
+	if err := os.WriteFile(points, []byte("1.5 1.5 flat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runTestTool(t, "TraceDownslope", dem, points, paths)
+	checkPaths("[{1.5 1.5} {1.5 1.5}] flat 1 0 0 flat")
+}
+
+func TestBreachModifications(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	writeTestGrid(t, dem, 3, 7,
+		10, 10, 10, 10, 10, 10, 10,
+		0, 5, 1, 1, 1, 6, 0,
+		10, 10, 10, 10, 10, 10, 10)
+	var out bytes.Buffer
+	defer func(p func(string, ...interface{}) (int, error)) { printf = p }(printf)
+	printf = func(format string, a ...interface{}) (int, error) { return fmt.Fprintf(&out, format, a...) }
+
+	// the breach channel through the western ridge descends by 0.01 per
+	// cell; with a maximum depth of 2 it is cut to 3 and the depression is
+	// then filled
+	mod := filepath.Join(dir, "mod.tif")
+	for _, c := range []struct {
+		maxDepth          string
+		changes           []float64
+		breached, filled  int
+		excavated, added  float64
+		maxBreach, maxAdd float64
+	}{
+		{"-1", []float64{-4.04, -0.03, -0.02, -0.01}, 4, 0, 4.1, 0, 4.04, 0},
+		{"2", []float64{-1.99, 2.02, 2.03, 2.04}, 4, 4, 2.03, 6.13, 2, 2.05},
+	} {
+		out.Reset()
+		runTestTool(t, "BreachDepressions", dem, filepath.Join(dir, "breached.tif"), c.maxDepth, "-1", "true", "true", "", "", mod)
+		changes := readTestGrid(t, mod)
+		for i, e := range c.changes {
+			if math.Abs(changes[8+i]-e) > 1e-4 {
+				t.Errorf("max. depth %v: cell %v changed by %v, expected %v", c.maxDepth, 8+i, changes[8+i], e)
+			}
+		}
+		for _, m := range []struct {
+			label           string
+			cells           int
+			volume, maxDiff float64
+		}{
+			{"Lowered by breaching", c.breached, c.excavated, c.maxBreach},
+			{"Raised by filling", c.filled, c.added, c.maxAdd},
+		} {
+			i := strings.Index(out.String(), m.label)
+			var cells int
+			var volume, mean, maxDiff float64
+			if i < 0 {
+				t.Fatalf("max. depth %v: the output does not include %q", c.maxDepth, m.label)
+			}
+			if _, err := fmt.Sscanf(out.String()[i+len(m.label):], ": %d cells, volume %g, mean depth %g, max. depth %g",
+				&cells, &volume, &mean, &maxDiff); err != nil {
+				t.Fatalf("max. depth %v: %v (%v)", c.maxDepth, m.label, err)
+			}
+			if cells != m.cells || math.Abs(volume-m.volume) > 1e-6 || math.Abs(maxDiff-m.maxDiff) > 1e-6 {
+				t.Errorf("max. depth %v: %v %v cells, volume %v, max. depth %v", c.maxDepth, m.label, cells, volume, maxDiff)
+			}
+		}
+	}
+}
+
+func TestSemivariogram(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	// rising by 1 to the east and 10 to the south
+	writeTestGrid(t, dem, 2, 3, 0, 1, 2, 10, 11, 12)
+	out := filepath.Join(dir, "variogram.csv")
+	for _, c := range []struct {
+		directions, expected string
+	}{
+		{"1", "direction,lag,distance,pairs,semivariance\n" +
+			"omni,1,1.1506,11,32.181818\n" +
+			"omni,2,2.1180,4,27.000000\n"},
+		// the pairs two columns and one row apart are in the diagonal
+		// sectors; the north-south sector has none at the second lag
+		{"4", "direction,lag,distance,pairs,semivariance\n" +
+			"0,1,1.0000,3,50.000000\n" +
+			"45,1,1.4142,2,40.500000\n" +
+			"45,2,2.2361,1,32.000000\n" +
+			"90,1,1.0000,4,0.500000\n" +
+			"90,2,2.0000,2,2.000000\n" +
+			"135,1,1.4142,2,60.500000\n" +
+			"135,2,2.2361,1,72.000000\n"},
+	} {
+		runTestTool(t, "Semivariogram", dem, out, "2", "", c.directions)
+		if b, err := os.ReadFile(out); err != nil || string(b) != c.expected {
+			t.Errorf("%v directions:\n%s\nexpected:\n%s (%v)", c.directions, b, c.expected, err)
+		}
+	}
+}
+
+func TestKriging(t *testing.T) {
+	dir := t.TempDir()
+	// the corners of a 3 x 3 grid, with two points at the first, which are
+	// averaged
+	points := filepath.Join(dir, "points.xyz")
+	if err := os.WriteFile(points, []byte("0 0 0\n0 0 2\n2 0 3\n0 2 5\n2 2 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "kriged.tif")
+	variance := filepath.Join(dir, "variance.tif")
+
+	// by symmetry, the midpoint of the southern edge weights its two nearest
+	// points by a and the others by b, with a + b = 1/2; subtracting the
+	// kriging equations of a near and a far point gives b - a
+	spherical := func(h float64) float64 { return 1.5*h/10 - 0.5*math.Pow(h/10, 3) }
+	side, diag, near, far := spherical(2), spherical(math.Sqrt(8)), spherical(1), spherical(math.Sqrt(5))
+	a := (0.5 - (near-far)/diag) / 2
+	b := 0.5 - a
+	mu := near - a*side - b*(side+diag)
+	south := 4*a + 12*b
+	southVariance := 2*a*near + 2*b*far + mu
+	for _, model := range []string{"spherical", "exponential", "gaussian"} {
+		nugget := "0"
+		if model == "gaussian" {
+			nugget = "0.001"
+		}
+		runTestTool(t, "Kriging", points, out, "1", model, "10", "1", nugget, "16", variance, "32617")
+		z := readTestGrid(t, out)
+		v := readTestGrid(t, variance)
+		// the points are honoured, and the estimates are symmetric about
+		// the centre, whose estimate is the mean
+		for _, i := range []int{0, 2, 6, 8} {
+			if v[i] > 1e-6 {
+				t.Errorf("%v: the variance at a point is %v", model, v[i])
+			}
+		}
+		if z[6] != 1 || z[8] != 3 || z[0] != 5 || z[2] != 7 || math.Abs(z[4]-4) > 1e-5 ||
+			math.Abs(z[1]+z[7]-8) > 1e-5 || math.Abs(z[3]+z[5]-8) > 1e-5 || v[4] <= v[7] {
+			t.Errorf("%v: %v, variance %v", model, z, v)
+		}
+		if model == "spherical" && (math.Abs(z[7]-south) > 1e-5 || math.Abs(v[7]-southVariance) > 1e-5) {
+			t.Errorf("the southern midpoint is %v (variance %v), expected %v (%v)", z[7], v[7], south, southVariance)
+		}
+	}
+}
+
+func TestGaussianRandomField(t *testing.T) {
+	dir := t.TempDir()
+	rows, columns := 64, 64
+	values := make([]float64, rows*columns)
+	for i := range values {
+		values[i] = 100
+	}
+	values[0] = math.NaN()
+	base := filepath.Join(dir, "base.tif")
+	writeTestGrid(t, base, rows, columns, values...)
+	out := filepath.Join(dir, "field.tif")
+	stats := func(z []float64, lag int) (mean, variance, semivariance float64) {
+		n, m := 0, 0
+		for i, v := range z {
+			if math.IsNaN(v) {
+				continue
+			}
+			mean += v
+			variance += v * v
+			n++
+			if col := i % columns; col+lag < columns && !math.IsNaN(z[i+lag]) {
+				d := z[i+lag] - v
+				semivariance += d * d / 2
+				m++
+			}
+		}
+		mean /= float64(n)
+		return mean, variance/float64(n) - mean*mean, semivariance / float64(m)
+	}
+	// the semivariances at short and long lags match the variogram, up to
+	// sampling error, as does the pure nugget of independent noise
+	spherical := 4 * (1.5*1/10 - 0.5*math.Pow(1.0/10, 3))
+	for _, seed := range []string{"1", "2"} {
+		runTestTool(t, "GaussianRandomField", base, out, "spherical", "10", "4", "0", "", "false", seed)
+		z := readTestGrid(t, out)
+		if !math.IsNaN(z[0]) {
+			t.Error("the nodata cell of the base raster has a value")
+		}
+		mean, variance, short := stats(z, 1)
+		_, _, long := stats(z, 20)
+		if math.Abs(mean) > 0.5 || math.Abs(variance-4) > 1 || math.Abs(short-spherical) > 0.1*spherical || math.Abs(long-4) > 1 {
+			t.Errorf("seed %v: mean %v, variance %v, semivariances %v and %v", seed, mean, variance, short, long)
+		}
+		runTestTool(t, "GaussianRandomField", base, out, "spherical", "10", "0", "1", "", "false", seed)
+		mean, variance, short = stats(readTestGrid(t, out), 1)
+		if math.Abs(mean) > 0.1 || math.Abs(variance-1) > 0.1 || math.Abs(short-1) > 0.1 {
+			t.Errorf("seed %v: the nugget has mean %v, variance %v and semivariance %v", seed, mean, variance, short)
+		}
+	}
+
+	// a seed gives the same field, which is conditioned on the points and
+	// can be added to the base
+	points := filepath.Join(dir, "points.xyz")
+	if err := os.WriteFile(points, []byte("10.5,20.5,3\n40.5,50.5,-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runTestTool(t, "GaussianRandomField", base, out, "exponential", "10", "4", "0", points, "false", "7")
+	field := readTestGrid(t, out)
+	if field[43*columns+10] != 3 || field[13*columns+40] != -2 {
+		t.Errorf("the field at the points is %v and %v", field[43*columns+10], field[13*columns+40])
+	}
+	runTestTool(t, "GaussianRandomField", base, out, "exponential", "10", "4", "0", points, "true", "7")
+	for i, z := range readTestGrid(t, out) {
+		if i > 0 && math.Abs(z-100-field[i]) > 1e-4 {
+			t.Fatalf("cell %v: %v is not the base plus %v", i, z, field[i])
+		}
+	}
+}
+
+func TestTINGridding(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fileName
+	}
+	// the corners and one interior point of the plane z = x + 2y, and a
+	// ridge along y = 1
+	points := write("points.xyz", "0 0 0\n3 0 3\n0 3 6\n3 3 9\n1 2 5\n")
+	line := vector.NewPolyLine([][2]float64{{0, 1}, {3, 1}})
+	line.Parts[0][0].Z, line.Parts[0][1].Z = 10, 10
+	breakline := writeTestShapes(t, filepath.Join(dir, "breakline.shp"), vector.ST_PolyLineZ, line)
+	out := filepath.Join(dir, "tin.tif")
+	nan := math.NaN()
+	for _, c := range []struct {
+		breakline, maxEdgeLength string
+		expected                 []float64
+	}{
+		{"", "", []float64{6, 7, 8, 9, 4, 5, 6, 7, 2, 3, 4, 5, 0, 1, 2, 3}},
+		// no triangle crosses the ridge; the cell at 2, 2 lies in the triangle
+		// of the interior point, the ridge vertex at 2, 1 and the corner 3, 3
+		{breakline, "", []float64{6, 7, 8, 9, 8, 5, 8, 9.5, 10, 10, 10, 10, 0, 1, 2, 3}},
+		{breakline, "2.5", []float64{6, nan, nan, 9, 8, 5, 8, 9.5, 10, 10, 10, 10, 0, nan, nan, 3}},
+		// every triangle has an edge of the hull, of length 3
+		{"", "2.9", []float64{nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan, nan}},
+	} {
+		runTestTool(t, "TINGridding", points, out, "1", c.breakline, c.maxEdgeLength, "32617")
+		checkTestGrid(t, out, 1e-5, c.expected...)
+	}
+}
+
+func TestShiftFlipRotate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 2, 3, 1, 2, 3, 4, 5, 6)
+	out := filepath.Join(dir, "out.tif")
+	checkExtent := func(fileName string, north, south, east, west float64) {
+		t.Helper()
+		r, err := raster.CreateRasterFromFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.North != north || r.South != south || r.East != east || r.West != west {
+			t.Errorf("the extent is N %v, S %v, E %v, W %v", r.North, r.South, r.East, r.West)
+		}
+	}
+
+	for _, c := range []struct {
+		direction string
+		expected  []float64
+	}{
+		{"vertical", []float64{4, 5, 6, 1, 2, 3}},
+		{"horizontal", []float64{3, 2, 1, 6, 5, 4}},
+		{"both", []float64{6, 5, 4, 3, 2, 1}},
+	} {
+		runTestTool(t, "FlipRaster", in, c.direction, out)
+		checkTestGrid(t, out, 0, c.expected...)
+		checkExtent(out, 2, 0, 3, 0)
+	}
+
+	// the north-west corner is kept
+	runTestTool(t, "Rotate90", in, "true", out)
+	checkTestGrid(t, out, 0, 4, 1, 5, 2, 6, 3)
+	checkExtent(out, 2, -1, 2, 0)
+	runTestTool(t, "Rotate90", in, "false", out)
+	checkTestGrid(t, out, 0, 3, 6, 2, 5, 1, 4)
+
+	// without an output file, the input is overwritten
+	runTestTool(t, "ShiftRaster", in, "0.5", "-1")
+	checkTestGrid(t, in, 0, 1, 2, 3, 4, 5, 6)
+	checkExtent(in, 1, -1, 3.5, 0.5)
+}
+
+func TestLidarToDEM(t *testing.T) {
+	dir := t.TempDir()
+	// the points of TestTINGridding, with a building point (a first return),
+	// a withheld point and a noise point
+	type point struct {
+		x, y, z             float64
+		class, ret, returns byte
+		withheld            bool
+	}
+	points := []point{
+		{0, 0, 0, 2, 1, 1, false},
+		{3, 0, 3, 2, 1, 1, false},
+		{0, 3, 6, 2, 1, 1, false},
+		{3, 3, 9, 2, 1, 1, false},
+		{1, 2, 5, 2, 2, 2, false},
+		{2, 1, 50, 6, 1, 2, false},
+		{2, 2, 100, 2, 1, 1, true},
+		{1, 1, -50, 7, 1, 1, false},
+	}
+	// writes a LAS 1.2 file of points of format 0, with a scale of 0.01
+	writeLAS := func(fileName string, points []point) {
+		const headerSize, recordLength = 227, 20
+		b := make([]byte, headerSize+len(points)*recordLength)
+		le := binary.LittleEndian
+		copy(b[0:4], "LASF")
+		b[24], b[25] = 1, 2
+		le.PutUint16(b[94:96], headerSize)
+		le.PutUint32(b[96:100], headerSize)
+		le.PutUint16(b[105:107], recordLength)
+		le.PutUint32(b[107:111], uint32(len(points)))
+		for i, v := range []float64{0.01, 0.01, 0.01, 500000, 4800000, 0, 500003, 500000, 4800003, 4800000, 100, -50} {
+			le.PutUint64(b[131+8*i:], math.Float64bits(v))
+		}
+		for i, p := range points {
+			rec := b[headerSize+i*recordLength:]
+			le.PutUint32(rec[0:4], uint32(int32(math.Round(p.x*100))))
+			le.PutUint32(rec[4:8], uint32(int32(math.Round(p.y*100))))
+			le.PutUint32(rec[8:12], uint32(int32(math.Round(p.z*100))))
+			rec[14] = p.ret | p.returns<<3
+			rec[15] = p.class
+			if p.withheld {
+				rec[15] |= 1 << 7
+			}
+		}
+		if err := os.WriteFile(fileName, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	las := filepath.Join(dir, "points.las")
+	writeLAS(las, points)
+	out := filepath.Join(dir, "dem.tif")
+	plane := []float64{6, 7, 8, 9, 4, 5, 6, 7, 2, 3, 4, 5, 0, 1, 2, 3}
+	runTestTool(t, "LidarToDEM", las, out, "1", "tin", "", "32617")
+	checkTestGrid(t, out, 1e-5, plane...)
+	// the cell at 1, 0 weights the five ground points by their inverse
+	// squared distances of 1, 4, 10, 13 and 4; the points are honoured
+	runTestTool(t, "LidarToDEM", las, out, "1", "idw", "", "32617")
+	idw := (3.0/4 + 6.0/10 + 9.0/13 + 5.0/4) / (1 + 1.0/4 + 1.0/10 + 1.0/13 + 1.0/4)
+	if z := readTestGrid(t, out); math.Abs(z[13]-idw) > 1e-5 || z[0] != 6 || z[5] != 5 || z[12] != 0 {
+		t.Errorf("idw: %v", z)
+	}
+
+	// without ground points, the last returns are used
+	for i := range points {
+		if points[i].class == 2 {
+			points[i].class = 1
+		}
+	}
+	writeLAS(las, points)
+	runTestTool(t, "LidarToDEM", las, out, "1", "tin", "", "32617")
+	checkTestGrid(t, out, 1e-5, plane...)
+}
+
+func TestExtractStreams(t *testing.T) {
+	dir := t.TempDir()
+	// two tributaries joining above an outlet on the southern edge
+	accum := filepath.Join(dir, "accum.tif")
+	writeTestGrid(t, accum, 4, 5,
+		3, 1, 1, 1, 3,
+		1, 4, 1, 4, 1,
+		1, 1, 10, 1, 1,
+		1, 1, 11, 1, 1)
+	streams := filepath.Join(dir, "streams.tif")
+	runTestTool(t, "ExtractStreams", accum, streams, "3")
+	checkTestGrid(t, streams, 0, 1, 0, 0, 0, 1, 0, 1, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0)
+
+	out := filepath.Join(dir, "links.shp")
+	runTestTool(t, "RasterStreamsToVector", streams, accum, out)
+	shp, err := vector.CreateShapefileFromFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shp.NumShapes() != 3 {
+		t.Fatalf("%v links, expected 3", shp.NumShapes())
+	}
+	for i, expected := range []struct {
+		vertices   string
+		attributes string
+	}{
+		{"[{0.5 3.5} {1.5 2.5} {2.5 1.5}]", "1 3 1 2.828"},
+		{"[{4.5 3.5} {3.5 2.5} {2.5 1.5}]", "2 3 1 2.828"},
+		{"[{2.5 1.5} {2.5 0.5}]", "3 0 2 1.000"},
+	} {
+		var vertices []string
+		for _, p := range shp.GetShape(i).Points() {
+			vertices = append(vertices, fmt.Sprintf("{%v %v}", p.X, p.Y))
+		}
+		attributes := fmt.Sprintf("%v %v %v %.3f", shp.GetAttribute(i, "LINK_ID"), shp.GetAttribute(i, "DS_LINK"),
+			shp.GetAttribute(i, "STRAHLER"), shp.GetAttribute(i, "LENGTH"))
+		if v := "[" + strings.Join(vertices, " ") + "]"; v != expected.vertices || attributes != expected.attributes {
+			t.Errorf("link %v: %v, %v; expected %v, %v", i+1, v, attributes, expected.vertices, expected.attributes)
+		}
+	}
+
+	// a stream two cells wide is thinned to one, which, as in any Zhang-Suen
+	// thinning, shortens its ends by about half of its width
+	writeTestGrid(t, accum, 4, 4, 1, 1, 1, 1, 5, 6, 7, 8, 5, 6, 7, 8, 1, 1, 1, 1)
+	runTestTool(t, "ExtractStreams", accum, streams, "3", "false")
+	checkTestGrid(t, streams, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0)
+	runTestTool(t, "ExtractStreams", accum, streams, "3")
+	checkTestGrid(t, streams, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func TestD8Pointer(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	// the steepest descents are S, SE, S / SE, E, none / E, NE, N
+	writeTestGrid(t, dem, 3, 3, 8, 6, 7, 5, 9, 1, 7, 4, 3)
+	nan := math.NaN()
+	for _, c := range []struct {
+		encoding string
+		expected []float64
+	}{
+		{"whitebox", []float64{8, 4, 8, 4, 2, 0, 2, 1, 128}},
+		{"esri", []float64{4, 2, 4, 2, 1, 0, 1, 128, 64}},
+		{"taudem", []float64{7, 8, 7, 8, 1, nan, 1, 2, 3}},
+		{"gospatial", []float64{4, 3, 4, 3, 2, 0, 2, 1, 8}},
+	} {
+		out := filepath.Join(dir, c.encoding+".tif")
+		runTestTool(t, "D8Pointer", dem, out, c.encoding)
+		checkTestGrid(t, out, 0, c.expected...)
+	}
+}
+
+func TestStochasticDepressionAnalysis(t *testing.T) {
+	dir := t.TempDir()
+	dem := filepath.Join(dir, "dem.tif")
+	out := filepath.Join(dir, "probability.tif")
+	// with uncorrelated error on a flat DEM, the centre is in a depression
+	// when its error is the least of the nine cells, with probability 1/9;
+	// the edge cells drain off the DEM
+	writeTestGrid(t, dem, 3, 3, 10, 10, 10, 10, 10, 10, 10, 10, 10)
+	runTestTool(t, "StochasticDepressionAnalysis", dem, out, "0.5", "0", "2000", "gaussian", "1")
+	p := readTestGrid(t, out)
+	for i, v := range p {
+		if i != 4 && v != 0 {
+			t.Errorf("the edge cell %v has a probability of %v", i, v)
+		}
+	}
+	if math.Abs(p[4]-1.0/9) > 0.03 {
+		t.Errorf("the centre has a probability of %v, expected about %v", p[4], 1.0/9)
+	}
+	// a pit much deeper than the error is always a depression
+	writeTestGrid(t, dem, 3, 3, 10, 10, 10, 10, 0, 10, 10, 10, 10)
+	runTestTool(t, "StochasticDepressionAnalysis", dem, out, "0.5", "2", "50", "spherical", "1")
+	checkTestGrid(t, out, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+}
+
+func TestResample(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.tif")
+	writeTestGrid(t, in, 2, 2, 1, 2, 3, 4)
+	out := filepath.Join(dir, "out.tif")
+	// halving the cell size; bilinear interpolation of 1 + x + 2y, in cells,
+	// is exact between the cell centres and constant beyond them
+	runTestTool(t, "Resample", in, out, "0.5", "", "nearest")
+	checkTestGrid(t, out, 0, 1, 1, 2, 2, 1, 1, 2, 2, 3, 3, 4, 4, 3, 3, 4, 4)
+	runTestTool(t, "Resample", in, out, "0.5")
+	checkTestGrid(t, out, 1e-6,
+		1, 1.25, 1.75, 2,
+		1.5, 1.75, 2.25, 2.5,
+		2.5, 2.75, 3.25, 3.5,
+		3, 3.25, 3.75, 4)
+
+	// onto the grid of a base raster offset by half a cell to the east
+	base := filepath.Join(dir, "base.tif")
+	writeTestGridAt(t, base, 2, 2, 0.5, 0, 0, 0, 0, 0)
+	runTestTool(t, "Resample", in, out, "", base, "bilinear")
+	checkTestGrid(t, out, 1e-6, 1.5, 2, 3.5, 4)
+	r, err := raster.CreateRasterFromFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.West != 0.5 || r.East != 2.5 || r.North != 2 || r.South != 0 {
+		t.Errorf("the extent is N %v, S %v, E %v, W %v", r.North, r.South, r.East, r.West)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ToolMetadata describes a registered tool and its arguments, e.g. for the
+// automatic generation of tool dialogs.
+type ToolMetadata struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Help        string    `json:"help"`
+	Version     string    `json:"version"`     // the GoSpatial version
+	ToolVersion string    `json:"toolVersion"` // the version of the tool's algorithm
+	Args        []ToolArg `json:"args"`
+}
+
+// GetToolsMetadata returns the metadata of all registered tools, sorted by
+// name.
+func (ptm *PluginToolManager) GetToolsMetadata() []ToolMetadata {
+	names := make([]string, 0, len(ptm.mapOfPluginTools))
+	for name := range ptm.mapOfPluginTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]ToolMetadata, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, ptm.toolMetadata(ptm.mapOfPluginTools[name]))
+	}
+	return ret
+}
+
+// GetToolMetadata returns the metadata of a single tool.
+func (ptm *PluginToolManager) GetToolMetadata(toolName string) (ToolMetadata, error) {
+	toolName = strings.ToLower(getFormattedToolName(toolName))
+	if tool, ok := ptm.mapOfPluginTools[toolName]; ok {
+		return ptm.toolMetadata(tool), nil
+	}
+	return ToolMetadata{}, errors.New("Unrecognized tool name. Type 'listtools' for a list of available tools.\n")
+}
+
+// GetToolsMetadataJSON returns the metadata of all registered tools as
+// indented JSON.
+func (ptm *PluginToolManager) GetToolsMetadataJSON() (string, error) {
+	b, err := json.MarshalIndent(ptm.GetToolsMetadata(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (ptm *PluginToolManager) toolMetadata(tool PluginTool) ToolMetadata {
+	md := ToolMetadata{
+		Name:        tool.GetName(),
+		Description: tool.GetDescription(),
+		Help:        tool.GetHelpDocumentation(),
+		Version:     ptm.Version,
+		ToolVersion: tool.GetVersion(),
+	}
+	md.Args = tool.GetArgDescriptions()
+	return md
+}
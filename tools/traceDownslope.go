@@ -0,0 +1,392 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+	"github.com/jblindsay/go-spatial/geospatialfiles/vector"
+)
+
+// TraceDownslope traces the D8 flowpaths downslope of a set of points and
+// writes them as polylines, along with their elevation profiles.
+type TraceDownslope struct {
+	inputFile   string
+	pointsFile  string
+	outputFile  string
+	profileFile string
+	streamsFile string
+	toolManager *PluginToolManager
+}
+
+func (this *TraceDownslope) GetName() string {
+	s := "TraceDownslope"
+	return getFormattedToolName(s)
+}
+
+func (this *TraceDownslope) GetDescription() string {
+	s := "Traces the downslope flowpaths from points"
+	return getFormattedToolDescription(s)
+}
+
+func (this *TraceDownslope) GetVersion() string {
+	return "1.0"
+}
+
+func (this *TraceDownslope) GetHelpDocumentation() string {
+	ret := "This tool traces the D8 flowpath downslope of each of a set of 'x y [label]' " +
+		"points, from each cell to its steepest downslope neighbour, e.g. to check that " +
+		"breaching has connected a wetland to the stream network. A path ends where it " +
+		"leaves the DEM, at its edge or a nodata cell ('edge'), at a cell without a lower " +
+		"neighbour, which is either on a flat, i.e. has a neighbour of the same elevation " +
+		"('flat'), or in a pit ('pit'), or, if a StreamsFile is specified, at the first " +
+		"stream cell, i.e. a cell that is neither zero nor nodata, after its start " +
+		"('stream'). The paths are written to the OutputFile as polylines through the cell " +
+		"centres, in the order of the points, with the attributes POINT, the point's label, " +
+		"CELLS, LENGTH, DROP and END. A path of a single cell is a line of zero length. The " +
+		"output is a shapefile, or a GeoJSON file if the output file name has a .geojson or " +
+		".json extension, in the coordinate reference system of the DEM. The ProfileFile, " +
+		"by default the OutputFile with a .csv extension, lists " +
+		"the coordinates, elevation and distance along the path of every cell of each path. " +
+		"The length, drop and end of each path are printed and are also written to the " +
+		"-report file, if there is one. Points outside of the DEM or on nodata cells are " +
+		"skipped."
+	return ret
+}
+
+func (this *TraceDownslope) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *TraceDownslope) GetArgDescriptions() []ToolArg {
+	numArgs := 5
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The input DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "PointsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "A text file of 'x y [label]' start points"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output shapefile or GeoJSON file name, with directory"
+	ret[2].Role = ArgOutput
+	ret[2].Required = true
+
+	ret[3].Name = "ProfileFile"
+	ret[3].Type = "string"
+	ret[3].Description = "The output CSV profile file name"
+	ret[3].Role = ArgOutput
+
+	ret[4].Name = "StreamsFile"
+	ret[4].Type = "string"
+	ret[4].Description = "A raster of stream cells at which the paths end"
+	ret[4].Role = ArgInput
+
+	return ret
+}
+
+func (this *TraceDownslope) EstimateMemory(rows, columns int) int64 {
+	// the DEM and the stream grid
+	return gridBytes(rows, columns, rasterBytesPerCell+1)
+}
+
+func (this *TraceDownslope) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input DEM, points file, and output file must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	if !this.setPointsFile(args[1]) {
+		return
+	}
+	this.setOutputFile(args[2])
+
+	this.profileFile = ""
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		this.setProfileFile(args[3])
+	}
+	this.streamsFile = ""
+	if len(args) > 4 && len(strings.TrimSpace(args[4])) > 0 && args[4] != "not specified" {
+		if !this.setStreamsFile(args[4]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TraceDownslope) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the DEM file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the points file name
+	print("Enter the start points file name (incl. file extension): ")
+	pointsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setPointsFile(pointsFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output shapefile or GeoJSON file name: ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the profile file name
+	this.profileFile = ""
+	print("Enter the output CSV profile file name (blank for the default): ")
+	profileFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(profileFile)) > 0 {
+		this.setProfileFile(profileFile)
+	}
+
+	// get the streams file name
+	this.streamsFile = ""
+	print("Enter the streams raster file name (blank for none): ")
+	streamsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(streamsFile)) > 0 {
+		if !this.setStreamsFile(streamsFile) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TraceDownslope) setPointsFile(s string) bool {
+	pointsFile := strings.TrimSpace(s)
+	if !strings.Contains(pointsFile, pathSep) {
+		pointsFile = this.toolManager.workingDirectory + pointsFile
+	}
+	this.pointsFile = pointsFile
+	if _, err := os.Stat(this.pointsFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.pointsFile)
+		return false
+	}
+	return true
+}
+
+func (this *TraceDownslope) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	this.outputFile = outputFile
+}
+
+func (this *TraceDownslope) setProfileFile(s string) {
+	profileFile := strings.TrimSpace(s)
+	if !strings.Contains(profileFile, pathSep) {
+		profileFile = this.toolManager.workingDirectory + profileFile
+	}
+	if filepath.Ext(profileFile) == "" {
+		profileFile += ".csv"
+	}
+	this.profileFile = profileFile
+}
+
+func (this *TraceDownslope) setStreamsFile(s string) bool {
+	streamsFile := strings.TrimSpace(s)
+	if !strings.Contains(streamsFile, pathSep) {
+		streamsFile = this.toolManager.workingDirectory + streamsFile
+	}
+	this.streamsFile = streamsFile
+	if _, err := os.Stat(this.streamsFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.streamsFile)
+		return false
+	}
+	return true
+}
+
+func (this *TraceDownslope) Run() {
+	start1 := time.Now()
+
+	points, err := readPointFile(this.pointsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Reading DEM data...")
+	dem, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	var stop func(row, col int) bool
+	if this.streamsFile != "" {
+		streams, err := readConstraintGrid(this.streamsFile, dem)
+		if err != nil {
+			println(err.Error())
+			return
+		}
+		stop = func(row, col int) bool { return streams[row+1][col+1] }
+	}
+	profileFile := this.profileFile
+	if profileFile == "" {
+		profileFile = strings.TrimSuffix(this.outputFile, filepath.Ext(this.outputFile)) + ".csv"
+	}
+	inConfig := dem.GetRasterConfig()
+
+	start2 := time.Now()
+
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	labelLength := 1
+	for _, pt := range points {
+		if len(pt.label) > labelLength {
+			labelLength = len(pt.label)
+		}
+	}
+	if labelLength > 254 {
+		labelLength = 254
+	}
+	fields := []vector.Field{
+		{Name: "POINT", Type: 'C', Length: labelLength},
+		{Name: "CELLS", Type: 'N', Length: 10},
+		{Name: "LENGTH", Type: 'N', Length: 18, Decimals: 3},
+		{Name: "DROP", Type: 'N', Length: 18, Decimals: 3},
+		{Name: "END", Type: 'C', Length: 8},
+	}
+	output, err := vector.CreateNewShapefile(this.outputFile, vector.ST_PolyLine, fields)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if wkt := strings.TrimSpace(inConfig.CoordinateRefSystemWKT); wkt != "not specified" {
+		output.CoordinateRefSystemWKT = wkt
+	}
+	output.EPSGCode = inConfig.EPSGCode
+	profile, err := createTable(profileFile, "point", "cell", "x", "y", "distance", "elevation")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	report, err := this.toolManager.openReport("point", "x", "y", "cells", "length", "drop", "end", "end_x", "end_y")
+	if err != nil {
+		profile.close()
+		println(err.Error())
+		return
+	}
+
+	numTraced := 0
+	for _, pt := range points {
+		row := int(math.Floor((dem.North - pt.y) / dem.GetCellSizeY()))
+		col := int(math.Floor((pt.x - dem.West) / dem.GetCellSizeX()))
+		path, end := TraceFlowpath(dem, row, col, stop)
+		if len(path) == 0 {
+			printf("%s: outside of the DEM or on a nodata cell; skipped\n", pt.label)
+			continue
+		}
+		numTraced++
+		if end == FlowpathStop {
+			end = "stream"
+		}
+
+		vertices := make([][2]float64, len(path))
+		for i, c := range path {
+			vertices[i] = [2]float64{c.X, c.Y}
+			profile.writeRow(pt.label, i+1, c.X, c.Y, c.Distance, c.Z)
+		}
+		if len(path) == 1 {
+			vertices = append(vertices, vertices[0])
+		}
+
+		last := path[len(path)-1]
+		drop := path[0].Z - last.Z
+		label := pt.label
+		if len(label) > labelLength {
+			label = label[:labelLength]
+		}
+		if err = output.AddShape(vector.NewPolyLine(vertices), label, len(path), last.Distance, drop, string(end)); err != nil {
+			profile.close()
+			report.close()
+			println(err.Error())
+			return
+		}
+		printf("%s: %v cells, length %s, drop %s, end %s at (%s, %s)\n", pt.label, len(path),
+			format(last.Distance), format(drop), end, format(last.X), format(last.Y))
+		report.writeRow(pt.label, pt.x, pt.y, len(path), last.Distance, drop, string(end), last.X, last.Y)
+	}
+	if err = output.Save(); err != nil {
+		profile.close()
+		report.close()
+		println(err.Error())
+		return
+	}
+	if err = profile.close(); err != nil {
+		println(err.Error())
+		return
+	}
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	elapsed := time.Since(start2)
+	printf("Paths traced: %v of %v points\n", numTraced, len(points))
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,345 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// TransformRaster applies a mathematical transformation, e.g. a log
+// transform or a z-score standardization, to each cell of a raster.
+type TransformRaster struct {
+	inputFile   string
+	outputFile  string
+	transform   string
+	exponent    float64
+	toolManager *PluginToolManager
+}
+
+var rasterTransforms = []string{"ln", "log10", "sqrt", "power", "zscore", "normalize"}
+
+var unknownRasterTransformError = errors.New("Unrecognized transform; use 'ln', 'log10', 'sqrt', 'power', 'zscore' or 'normalize'.")
+
+func parseRasterTransform(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.Replace(s, "-", "", -1)
+	for _, t := range rasterTransforms {
+		if s == t {
+			return s, nil
+		}
+	}
+	if s == "log" {
+		return "ln", nil
+	}
+	return "", unknownRasterTransformError
+}
+
+func (this *TransformRaster) GetName() string {
+	s := "TransformRaster"
+	return getFormattedToolName(s)
+}
+
+func (this *TransformRaster) GetDescription() string {
+	s := "Applies a log, power or statistical transform"
+	return getFormattedToolDescription(s)
+}
+
+func (this *TransformRaster) GetVersion() string {
+	return "1.0"
+}
+
+func (this *TransformRaster) GetHelpDocumentation() string {
+	ret := "This tool applies a transformation to each cell of a raster, so that any output, " +
+		"e.g. a flow accumulation raster, can be transformed without rerunning the tool that " +
+		"produced it. The transforms are 'ln' (natural logarithm), 'log10', 'sqrt', 'power' " +
+		"(raising each value to the specified Exponent), 'zscore' (subtracting the mean and " +
+		"dividing by the standard deviation of the raster), and 'normalize' (rescaling the " +
+		"values to the range 0-1). Cells for which the transform is undefined, e.g. the " +
+		"logarithm of a non-positive value, are assigned nodata and reported."
+	return ret
+}
+
+func (this *TransformRaster) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *TransformRaster) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output filename, with directory and file extension"
+	ret[1].Role = ArgOutputRaster
+	ret[1].Required = true
+
+	ret[2].Name = "Transform"
+	ret[2].Type = "string"
+	ret[2].Description = "ln, log10, sqrt, power, zscore or normalize"
+	ret[2].Required = true
+	ret[2].Choices = []string{"ln", "log10", "sqrt", "power", "zscore", "normalize"}
+
+	ret[3].Name = "Exponent"
+	ret[3].Type = "float64"
+	ret[3].Description = "The exponent of the power transform"
+	ret[3].Default = "2"
+
+	return ret
+}
+
+func (this *TransformRaster) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The input file, output file, and transform must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	outputFile := args[1]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	if this.transform, err = parseRasterTransform(args[2]); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.exponent = 2.0
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		if this.exponent, err = strconv.ParseFloat(strings.TrimSpace(args[3]), 64); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TransformRaster) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	// get the transform
+	print("Transform (ln, log10, sqrt, power, zscore or normalize): ")
+	transform, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if this.transform, err = parseRasterTransform(transform); err != nil {
+		println(err.Error())
+		return
+	}
+
+	this.exponent = 2.0
+	if this.transform == "power" {
+		print("Exponent (default 2): ")
+		exponentStr, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		if len(strings.TrimSpace(exponentStr)) > 0 {
+			if this.exponent, err = strconv.ParseFloat(strings.TrimSpace(exponentStr), 64); err != nil {
+				println(err.Error())
+				return
+			}
+		}
+	}
+
+	this.Run()
+}
+
+func (this *TransformRaster) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	inConfig := rin.GetRasterConfig()
+
+	// the statistical transforms need the distribution of the values
+	var offset, scale float64 = 0, 1
+	if this.transform == "zscore" || this.transform == "normalize" {
+		var n, sum, sumSqr float64
+		min, max := math.Inf(1), math.Inf(-1)
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				z := rin.Value(row, col)
+				if z != nodata {
+					n++
+					sum += z
+					sumSqr += z * z
+					min = math.Min(min, z)
+					max = math.Max(max, z)
+				}
+			}
+		}
+		if n == 0 {
+			println("The input raster does not contain any valid cells.")
+			return
+		}
+		if this.transform == "zscore" {
+			mean := sum / n
+			stdev := math.Sqrt(math.Max(sumSqr/n-mean*mean, 0))
+			printf("Mean: %v, standard deviation: %v\n", mean, stdev)
+			offset, scale = mean, stdev
+		} else {
+			printf("Minimum: %v, maximum: %v\n", min, max)
+			offset, scale = min, max-min
+		}
+		if scale == 0 {
+			println("The input raster has no variation; the transform is undefined.")
+			return
+		}
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = inConfig.PreferredPalette
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = nodata
+	config.InitialValue = nodata
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		rin.North, rin.South, rin.East, rin.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+
+	numUndefined := 0
+	rowsLessOne := rows - 1
+	var progress, oldProgress int
+	var eta progressETA
+	oldProgress = -1
+	var z float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			z = rin.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			switch this.transform {
+			case "ln":
+				z = math.Log(z)
+			case "log10":
+				z = math.Log10(z)
+			case "sqrt":
+				z = math.Sqrt(z)
+			case "power":
+				z = math.Pow(z, this.exponent)
+			default:
+				z = (z - offset) / scale
+			}
+			if math.IsNaN(z) || math.IsInf(z, 0) {
+				numUndefined++
+				continue
+			}
+			rout.SetValue(row, col, z)
+		}
+		if rowsLessOne > 0 {
+			progress = int(100.0 * row / rowsLessOne)
+		}
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+
+	println("\nSaving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by TransformRaster tool (%s)", this.toolManager.toolVersion(this)))
+	if this.transform == "power" {
+		rout.AddMetadataEntry(fmt.Sprintf("Transform: power (exponent %v)", this.exponent))
+	} else {
+		rout.AddMetadataEntry(fmt.Sprintf("Transform: %s", this.transform))
+	}
+	rout.Save()
+
+	if numUndefined > 0 {
+		printf("Warning: the transform was undefined for %v cells, which were assigned nodata.\n", numUndefined)
+	}
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
@@ -0,0 +1,548 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// UpdateFlowAccum updates an existing D8 pointer and flow accumulation pair
+// after a small region of the DEM has been edited, recalculating only the
+// cells that the edit can affect.
+type UpdateFlowAccum struct {
+	demFile           string
+	pointerFile       string
+	accumFile         string
+	editFile          string
+	outputFile        string
+	outputPointerFile string
+	encoding          pointerEncoding
+	toolManager       *PluginToolManager
+}
+
+func (this *UpdateFlowAccum) GetName() string {
+	s := "UpdateFlowAccum"
+	return getFormattedToolName(s)
+}
+
+func (this *UpdateFlowAccum) GetDescription() string {
+	s := "Updates D8 flow accumulation after DEM edits"
+	return getFormattedToolDescription(s)
+}
+
+func (this *UpdateFlowAccum) GetVersion() string {
+	return "1.0"
+}
+
+func (this *UpdateFlowAccum) GetHelpDocumentation() string {
+	ret := "This tool updates a previously calculated D8 pointer and flow accumulation " +
+		"(in numbers of cells, not log-transformed) after a small region of the DEM has been " +
+		"edited, e.g. while designing ditches interactively. The edited cells are the non-zero " +
+		"cells of the EditMask raster. Flow directions are recalculated from the edited DEM " +
+		"for the edited cells and their neighbours, and the accumulation is then recalculated " +
+		"only for the cells downstream of a cell whose flow direction changed, along both its " +
+		"old and new flow paths. All other cells keep their previous values, so the update is " +
+		"much faster than rerunning D8FlowAccumulation on a large DEM. The pointer is read, " +
+		"and the optional updated pointer is written, in the PointerEncoding (default " +
+		"'whitebox')."
+	return ret
+}
+
+func (this *UpdateFlowAccum) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *UpdateFlowAccum) GetArgDescriptions() []ToolArg {
+	numArgs := 7
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputDEM"
+	ret[0].Type = "string"
+	ret[0].Description = "The edited DEM name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "PointerFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The D8 pointer of the original DEM, with file extension"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "AccumulationFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The D8 flow accumulation of the original DEM"
+	ret[2].Role = ArgInput
+	ret[2].Required = true
+
+	ret[3].Name = "EditMask"
+	ret[3].Type = "string"
+	ret[3].Description = "Raster of the edited cells (non-zero cells)"
+	ret[3].Role = ArgInput
+	ret[3].Required = true
+
+	ret[4].Name = "OutputFile"
+	ret[4].Type = "string"
+	ret[4].Description = "The output accumulation filename, with file extension"
+	ret[4].Role = ArgOutputRaster
+	ret[4].Required = true
+
+	ret[5].Name = "OutputPointer"
+	ret[5].Type = "string"
+	ret[5].Description = "The updated pointer filename"
+	ret[5].Role = ArgOutputRaster
+
+	ret[6].Name = "PointerEncoding"
+	ret[6].Type = "string"
+	ret[6].Description = "'whitebox', 'esri', 'taudem' or 'gospatial'"
+	ret[6].Default = "whitebox"
+	ret[6].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	return ret
+}
+
+func (this *UpdateFlowAccum) EstimateMemory(rows, columns int) int64 {
+	// the DEM, pointer, accumulation and output rasters, the flow directions
+	// and accumulated values, and the padded edit mask
+	return gridBytes(rows, columns, 4*rasterBytesPerCell+1+8) + gridBytes(rows+2, columns+2, 1)
+}
+
+func (this *UpdateFlowAccum) ParseArguments(args []string) {
+	if len(args) < 5 {
+		println("The DEM, pointer, accumulation, edit mask and output files must be specified.")
+		return
+	}
+	for i, fileName := range args[0:4] {
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		switch i {
+		case 0:
+			this.demFile = fileName
+		case 1:
+			this.pointerFile = fileName
+		case 2:
+			this.accumFile = fileName
+		case 3:
+			this.editFile = fileName
+		}
+	}
+	outputFile := args[4]
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	this.outputPointerFile = ""
+	if len(args) > 5 && len(strings.TrimSpace(args[5])) > 0 && args[5] != "not specified" {
+		outputFile = strings.TrimSpace(args[5])
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputPointerFile = outputFile
+	}
+
+	this.encoding = whiteboxPointer
+	if len(args) > 6 && len(strings.TrimSpace(args[6])) > 0 && args[6] != "not specified" {
+		if this.encoding, err = parsePointerEncoding(args[6]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *UpdateFlowAccum) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file names
+	prompts := []string{"Enter the edited DEM file name (incl. file extension): ",
+		"Enter the D8 pointer file name (incl. file extension): ",
+		"Enter the flow accumulation file name (incl. file extension): ",
+		"Enter the edit mask file name (incl. file extension): "}
+	for i, prompt := range prompts {
+		print(prompt)
+		fileName, err := consolereader.ReadString('\n')
+		if err != nil {
+			println(err)
+		}
+		fileName = strings.TrimSpace(fileName)
+		if !strings.Contains(fileName, pathSep) {
+			fileName = this.toolManager.workingDirectory + fileName
+		}
+		// see if the file exists
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			printf("no such file or directory: %s\n", fileName)
+			return
+		}
+		switch i {
+		case 0:
+			this.demFile = fileName
+		case 1:
+			this.pointerFile = fileName
+		case 2:
+			this.accumFile = fileName
+		case 3:
+			this.editFile = fileName
+		}
+	}
+
+	// get the output file names
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+
+	print("Enter the updated pointer file name (blank for none): ")
+	outputFile, err = consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	outputFile = strings.TrimSpace(outputFile)
+	this.outputPointerFile = ""
+	if len(outputFile) > 0 {
+		if !strings.Contains(outputFile, pathSep) {
+			outputFile = this.toolManager.workingDirectory + outputFile
+		}
+		rasterType, err := raster.DetermineRasterFormat(outputFile)
+		if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+			outputFile = outputFile + raster.DefaultExtension // the default output format
+		}
+		this.outputPointerFile = outputFile
+	}
+
+	// get the pointer encoding
+	print("Pointer encoding (whitebox, esri, taudem or gospatial; default whitebox): ")
+	encoding, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.encoding = whiteboxPointer
+	if len(strings.TrimSpace(encoding)) > 0 {
+		if this.encoding, err = parsePointerEncoding(encoding); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *UpdateFlowAccum) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	dem, err := raster.CreateRasterFromFile(this.demFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := dem.Rows
+	columns := dem.Columns
+	nodata := dem.NoDataValue
+
+	pntr, err := raster.CreateRasterFromFile(this.pointerFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	accum, err := raster.CreateRasterFromFile(this.accumFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	if pntr.Rows != rows || pntr.Columns != columns || accum.Rows != rows || accum.Columns != columns {
+		println("The DEM, pointer and accumulation rasters must have the same dimensions.")
+		return
+	}
+	edited, err := readConstraintGrid(this.editFile, dem)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	start2 := time.Now()
+
+	// read the pointer; pointers that lead off the grid or into nodata are outlets
+	flowdir := make([][]int8, rows)
+	acc := make([][]float64, rows)
+	pntrNodata := pntr.NoDataValue
+	accNodata := accum.NoDataValue
+	for row := 0; row < rows; row++ {
+		flowdir[row] = make([]int8, columns)
+		acc[row] = make([]float64, columns)
+		for col := 0; col < columns; col++ {
+			flowdir[row][col] = -1
+			if z := pntr.Value(row, col); z != pntrNodata {
+				if n := this.encoding.decode(z); n >= 0 && dem.Value(row+d8DY[n], col+d8DX[n]) != nodata {
+					flowdir[row][col] = int8(n)
+				}
+			}
+			acc[row][col] = accum.Value(row, col)
+		}
+	}
+
+	println("Updating the flow accumulation...")
+	numChanged, numUpdated, complete := updateD8Accumulation(dem, flowdir, acc, edited)
+	if !complete {
+		println("Warning: the flow directions contain a loop; the pointer may not match the DEM.")
+	}
+
+	// create the output files
+	accConfig := accum.GetRasterConfig()
+	config := raster.NewDefaultRasterConfig()
+	config.DataType = raster.DT_FLOAT32
+	config.NoDataValue = accNodata
+	config.InitialValue = accNodata
+	config.PreferredPalette = accConfig.PreferredPalette
+	config.DisplayClipPercent = 2.0
+	config.DisplayClipSampleSize = 1000000
+	config.CoordinateRefSystemWKT = accConfig.CoordinateRefSystemWKT
+	config.EPSGCode = accConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		dem.North, dem.South, dem.East, dem.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if dem.Value(row, col) != nodata {
+				rout.SetValue(row, col, acc[row][col])
+			}
+		}
+	}
+
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	elapsed := time.Since(start2)
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by UpdateFlowAccum tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Updated from %s", this.accumFile))
+	rout.Save()
+
+	if this.outputPointerFile != "" {
+		pntrConfig := pntr.GetRasterConfig()
+		config = raster.NewDefaultRasterConfig()
+		config.DataType = raster.DT_INT16
+		config.NoDataValue = -32768
+		config.InitialValue = -32768
+		config.PreferredPalette = pntrConfig.PreferredPalette
+		config.CoordinateRefSystemWKT = pntrConfig.CoordinateRefSystemWKT
+		config.EPSGCode = pntrConfig.EPSGCode
+		pout, err := raster.CreateNewRaster(this.outputPointerFile, rows, columns,
+			dem.North, dem.South, dem.East, dem.West, config)
+		if err != nil {
+			println("Failed to write raster")
+			return
+		}
+		noFlow := 0.0
+		if this.encoding == taudemPointer {
+			noFlow = config.NoDataValue
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < columns; col++ {
+				if dem.Value(row, col) == nodata {
+					continue
+				}
+				if n := flowdir[row][col]; n >= 0 {
+					pout.SetValue(row, col, this.encoding.encode(int(n)))
+				} else {
+					pout.SetValue(row, col, noFlow)
+				}
+			}
+		}
+		pout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+		pout.AddMetadataEntry(fmt.Sprintf("Created by UpdateFlowAccum tool (%s)", this.toolManager.toolVersion(this)))
+		pout.AddMetadataEntry(fmt.Sprintf("Pointer encoding: %s", this.encoding))
+		pout.Save()
+	}
+
+	printf("Flow directions changed: %v\n", numChanged)
+	printf("Accumulation values recalculated: %v of %v cells\n", numUpdated, rows*columns)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
+
+// D8 neighbour offsets, indexed 0 = NE, 1 = E, ... 7 = N
+var d8DX = [8]int{1, 1, 1, 0, -1, -1, -1, 0}
+var d8DY = [8]int{-1, 0, 1, 1, 1, 0, -1, -1}
+
+// d8Direction returns the index of the steepest downslope neighbour of a DEM
+// cell, or -1 if the cell is nodata or has no downslope neighbour.
+func d8Direction(dem *raster.Raster, row, col int, dist [8]float64) int8 {
+	nodata := dem.NoDataValue
+	z := dem.Value(row, col)
+	if z == nodata {
+		return -1
+	}
+	var dir int8 = -1
+	maxSlope := 0.0
+	for n := 0; n < 8; n++ {
+		if zN := dem.Value(row+d8DY[n], col+d8DX[n]); zN != nodata {
+			if slope := (z - zN) / dist[n]; slope > maxSlope {
+				maxSlope = slope
+				dir = int8(n)
+			}
+		}
+	}
+	return dir
+}
+
+// updateD8Accumulation updates D8 flow directions (flowdir, neighbour indices
+// or -1) and accumulation (acc, in cells) in place after the cells marked in
+// edited, which is padded by one cell on each side, have been changed in the
+// DEM. Directions are recalculated for the edited cells and their neighbours.
+// Accumulation can then only change downstream of a cell whose direction
+// changed, along either its old or its new flow path, and it is recalculated
+// for those cells alone; all other cells drain unchanged areas. It returns the
+// numbers of changed directions and recalculated cells, and false if the
+// directions contain a loop.
+func updateD8Accumulation(dem *raster.Raster, flowdir [][]int8, acc [][]float64, edited [][]bool) (numChanged, numUpdated int, complete bool) {
+	rows, columns := dem.Rows, dem.Columns
+	cellSizeX, cellSizeY := dem.GetCellSizeX(), dem.GetCellSizeY()
+	diagDist := math.Sqrt(cellSizeX*cellSizeX + cellSizeY*cellSizeY)
+	dist := [8]float64{diagDist, cellSizeX, diagDist, cellSizeY, diagDist, cellSizeX, diagDist, cellSizeY}
+
+	// recalculate the flow directions around the edits
+	type cell struct {
+		row, col int
+		oldDir   int8
+	}
+	changed := make([]cell, 0)
+	checked := make(map[int]bool)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if !edited[row+1][col+1] {
+				continue
+			}
+			for dr := -1; dr <= 1; dr++ {
+				for dc := -1; dc <= 1; dc++ {
+					r, c := row+dr, col+dc
+					if r < 0 || r >= rows || c < 0 || c >= columns || checked[r*columns+c] {
+						continue
+					}
+					checked[r*columns+c] = true
+					if dir := d8Direction(dem, r, c, dist); dir != flowdir[r][c] {
+						changed = append(changed, cell{r, c, flowdir[r][c]})
+						flowdir[r][c] = dir
+					}
+				}
+			}
+		}
+	}
+	numChanged = len(changed)
+
+	// mark the affected cells, i.e. the changed cells and the cells on their
+	// old and new flow paths. Unchanged cells have the same old and new
+	// directions, so a trace can stop at a cell that has already been marked.
+	affected := make(map[int]bool)
+	oldDirs := make(map[int]int8)
+	for _, ch := range changed {
+		oldDirs[ch.row*columns+ch.col] = ch.oldDir
+	}
+	trace := func(row, col int, useOld bool) {
+		for row >= 0 && row < rows && col >= 0 && col < columns && !affected[row*columns+col] {
+			affected[row*columns+col] = true
+			dir := flowdir[row][col]
+			if d, ok := oldDirs[row*columns+col]; ok && useOld {
+				dir = d
+			}
+			if dir < 0 {
+				return
+			}
+			row, col = row+d8DY[dir], col+d8DX[dir]
+		}
+	}
+	for _, ch := range changed {
+		affected[ch.row*columns+ch.col] = true
+		for _, dir := range []int8{ch.oldDir, flowdir[ch.row][ch.col]} {
+			if dir >= 0 {
+				trace(ch.row+d8DY[dir], ch.col+d8DX[dir], dir == ch.oldDir)
+			}
+		}
+	}
+	numUpdated = len(affected)
+
+	// recalculate the accumulation of the affected cells in downstream order;
+	// unaffected inflowing neighbours contribute their previous values
+	numInflowing := make(map[int]int)
+	fq := newFlowQueue()
+	for key := range affected {
+		row, col := key/columns, key%columns
+		acc[row][col] = 1
+		for n := 0; n < 8; n++ {
+			r, c := row-d8DY[n], col-d8DX[n] // the neighbour that would flow in direction n
+			if r < 0 || r >= rows || c < 0 || c >= columns || flowdir[r][c] != int8(n) {
+				continue
+			}
+			if affected[r*columns+c] {
+				numInflowing[key]++
+			} else {
+				acc[row][col] += acc[r][c]
+			}
+		}
+		if numInflowing[key] == 0 {
+			fq.push(row, col)
+		}
+	}
+	numSolved := 0
+	for fq.count > 0 {
+		row, col := fq.pop()
+		numSolved++
+		dir := flowdir[row][col]
+		if dir < 0 {
+			continue
+		}
+		r, c := row+d8DY[dir], col+d8DX[dir]
+		if r < 0 || r >= rows || c < 0 || c >= columns {
+			continue
+		}
+		acc[r][c] += acc[row][col]
+		numInflowing[r*columns+c]--
+		if numInflowing[r*columns+c] == 0 {
+			fq.push(r, c)
+		}
+	}
+	return numChanged, numUpdated, numSolved == numUpdated
+}
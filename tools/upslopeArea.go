@@ -0,0 +1,322 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// UpslopeArea delineates the areas that drain to a set of query points by
+// traversing a D8 pointer upslope from each of them.
+type UpslopeArea struct {
+	pointerFile string
+	pointsFile  string
+	outputFile  string
+	encoding    pointerEncoding
+	toolManager *PluginToolManager
+}
+
+func (this *UpslopeArea) GetName() string {
+	s := "UpslopeArea"
+	return getFormattedToolName(s)
+}
+
+func (this *UpslopeArea) GetDescription() string {
+	s := "Delineates the areas draining to query points"
+	return getFormattedToolDescription(s)
+}
+
+func (this *UpslopeArea) GetVersion() string {
+	return "1.0"
+}
+
+func (this *UpslopeArea) GetHelpDocumentation() string {
+	ret := "This tool delineates the upslope contributing areas, i.e. catchments, of the " +
+		"cells containing a set of 'x y [label]' query points, using a D8 pointer in the " +
+		"PointerEncoding (default 'whitebox'). Rather than computing the watersheds of the " +
+		"whole grid, the pointer is traversed in reverse from each query cell, so that the " +
+		"time taken depends only on the sizes of the catchments; the same queries are " +
+		"available to programs through the FlowDirections type, e.g. for interactive " +
+		"catchment queries on a pointer loaded once. Each cell of the output is assigned " +
+		"the number, from 1 in the order of the points, of the nearest query point that it " +
+		"drains to, or zero if it drains to none, so that nested catchments are separated. " +
+		"The full contributing area of each point, including those of the points upslope of " +
+		"it, is printed in cells and squared map units, and is also written to the -report " +
+		"file, if there is one. Points are not snapped to streams; points outside of the " +
+		"grid are skipped, as is a point in the same cell as an earlier point."
+	return ret
+}
+
+func (this *UpslopeArea) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *UpslopeArea) GetArgDescriptions() []ToolArg {
+	numArgs := 4
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "PointerFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The D8 pointer raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "PointsFile"
+	ret[1].Type = "string"
+	ret[1].Description = "A text file of 'x y [label]' query points"
+	ret[1].Role = ArgInput
+	ret[1].Required = true
+
+	ret[2].Name = "OutputFile"
+	ret[2].Type = "string"
+	ret[2].Description = "The output filename, with directory and file extension"
+	ret[2].Role = ArgOutputRaster
+	ret[2].Required = true
+
+	ret[3].Name = "PointerEncoding"
+	ret[3].Type = "string"
+	ret[3].Description = "'whitebox', 'esri', 'taudem' or 'gospatial'"
+	ret[3].Default = "whitebox"
+	ret[3].Choices = []string{"whitebox", "esri", "taudem", "gospatial"}
+
+	return ret
+}
+
+func (this *UpslopeArea) EstimateMemory(rows, columns int) int64 {
+	// the pointer and output rasters, the flow directions and the query cells
+	return gridBytes(rows, columns, 2*rasterBytesPerCell+2)
+}
+
+func (this *UpslopeArea) ParseArguments(args []string) {
+	if len(args) < 3 {
+		println("The pointer file, points file, and output file must be specified.")
+		return
+	}
+	if !this.setPointerFile(args[0]) || !this.setPointsFile(args[1]) {
+		return
+	}
+	this.setOutputFile(args[2])
+
+	this.encoding = whiteboxPointer
+	if len(args) > 3 && len(strings.TrimSpace(args[3])) > 0 && args[3] != "not specified" {
+		var err error
+		if this.encoding, err = parsePointerEncoding(args[3]); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *UpslopeArea) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the pointer file name
+	print("Enter the D8 pointer file name (incl. file extension): ")
+	pointerFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setPointerFile(pointerFile) {
+		return
+	}
+
+	// get the points file name
+	print("Enter the query points file name (incl. file extension): ")
+	pointsFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if !this.setPointsFile(pointsFile) {
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the pointer encoding
+	print("Pointer encoding, whitebox, esri, taudem or gospatial (blank for whitebox): ")
+	encoding, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.encoding = whiteboxPointer
+	if len(strings.TrimSpace(encoding)) > 0 {
+		if this.encoding, err = parsePointerEncoding(encoding); err != nil {
+			println(err.Error())
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *UpslopeArea) setPointerFile(s string) bool {
+	pointerFile := strings.TrimSpace(s)
+	if !strings.Contains(pointerFile, pathSep) {
+		pointerFile = this.toolManager.workingDirectory + pointerFile
+	}
+	this.pointerFile = pointerFile
+	if _, err := os.Stat(this.pointerFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.pointerFile)
+		return false
+	}
+	return true
+}
+
+func (this *UpslopeArea) setPointsFile(s string) bool {
+	pointsFile := strings.TrimSpace(s)
+	if !strings.Contains(pointsFile, pathSep) {
+		pointsFile = this.toolManager.workingDirectory + pointsFile
+	}
+	this.pointsFile = pointsFile
+	if _, err := os.Stat(this.pointsFile); os.IsNotExist(err) {
+		printf("no such file or directory: %s\n", this.pointsFile)
+		return false
+	}
+	return true
+}
+
+func (this *UpslopeArea) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	rasterType, err := raster.DetermineRasterFormat(outputFile)
+	if rasterType == raster.RT_UnknownRaster || err == raster.UnsupportedRasterFormatError {
+		outputFile = outputFile + raster.DefaultExtension // the default output format
+	}
+	this.outputFile = outputFile
+}
+
+func (this *UpslopeArea) Run() {
+	start1 := time.Now()
+
+	points, err := readPointFile(this.pointsFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	println("Reading pointer data...")
+	pntr, err := raster.CreateRasterFromFile(this.pointerFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := pntr.Rows
+	columns := pntr.Columns
+	nodata := pntr.NoDataValue
+	inConfig := pntr.GetRasterConfig()
+	cellArea := pntr.GetCellSizeX() * pntr.GetCellSizeY()
+
+	start2 := time.Now()
+
+	fd, err := NewFlowDirections(pntr, this.encoding.String())
+	if err != nil {
+		println(err.Error())
+		return
+	}
+
+	// the query cells, numbered from 1 in the order of the points
+	type query struct {
+		label    string
+		x, y     float64
+		row, col int
+		number   int
+	}
+	queries := make([]query, 0, len(points))
+	number := make([]int32, rows*columns)
+	for i, pt := range points {
+		row := int(math.Floor((pntr.North - pt.y) / pntr.GetCellSizeY()))
+		col := int(math.Floor((pt.x - pntr.West) / pntr.GetCellSizeX()))
+		if row < 0 || row >= rows || col < 0 || col >= columns {
+			printf("%s: outside of the pointer grid; skipped\n", pt.label)
+			continue
+		}
+		if number[row*columns+col] != 0 {
+			printf("%s: in the same cell as an earlier point; skipped\n", pt.label)
+			continue
+		}
+		number[row*columns+col] = int32(i + 1)
+		queries = append(queries, query{pt.label, pt.x, pt.y, row, col, i + 1})
+	}
+	isQuery := func(row, col int) bool { return number[row*columns+col] != 0 }
+
+	report, err := this.toolManager.openReport("point", "x", "y", "number", "cells", "area")
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	regions := make([][]int, len(queries))
+	for i, q := range queries {
+		regions[i] = fd.UpslopeCells(q.row, q.col, isQuery)
+		n := len(fd.UpslopeCells(q.row, q.col, nil))
+		printf("%s: %v cells, area %v\n", q.label, n, float64(n)*cellArea)
+		report.writeRow(q.label, q.x, q.y, q.number, n, float64(n)*cellArea)
+	}
+	if err = report.close(); err != nil {
+		println(err.Error())
+		return
+	}
+
+	// create the output raster
+	config := raster.NewDefaultRasterConfig()
+	config.PreferredPalette = "qual.plt"
+	config.DataType = raster.DT_INT32
+	config.NoDataValue = nodata
+	config.InitialValue = 0
+	config.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	config.EPSGCode = inConfig.EPSGCode
+	rout, err := raster.CreateNewRaster(this.outputFile, rows, columns,
+		pntr.North, pntr.South, pntr.East, pntr.West, config)
+	if err != nil {
+		println("Failed to write raster")
+		return
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < columns; col++ {
+			if pntr.Value(row, col) == nodata {
+				rout.SetValue(row, col, nodata)
+			}
+		}
+	}
+	for i, cells := range regions {
+		for _, j := range cells {
+			rout.SetValue(j/columns, j%columns, float64(queries[i].number))
+		}
+	}
+
+	elapsed := time.Since(start2)
+	println("Saving data...")
+	rout.AddMetadataEntry(fmt.Sprintf("Created on %s", time.Now().Local()))
+	rout.AddMetadataEntry(fmt.Sprintf("Elapsed Time: %v", elapsed))
+	rout.AddMetadataEntry(fmt.Sprintf("Created by UpslopeArea tool (%s)", this.toolManager.toolVersion(this)))
+	rout.AddMetadataEntry(fmt.Sprintf("Pointer encoding: %s", this.encoding))
+	rout.Save()
+
+	printf("Catchments delineated: %v of %v points\n", len(queries), len(points))
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}
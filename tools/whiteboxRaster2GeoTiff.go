@@ -31,8 +31,15 @@ func (this *Whitebox2GeoTiff) GetDescription() string {
 	return getFormattedToolDescription(s)
 }
 
+func (this *Whitebox2GeoTiff) GetVersion() string {
+	return "1.0"
+}
+
 func (this *Whitebox2GeoTiff) GetHelpDocumentation() string {
-	ret := "This tool converts a Whitebox GAT raster to a GeoTiff format."
+	ret := "This tool converts a Whitebox GAT raster to a GeoTiff format. The raster's " +
+		"preferred palette, display range and palette nonlinearity, for which GeoTIFFs " +
+		"have no place, are written to a sidecar file with the extension .display, e.g. " +
+		"DEM.tif.display, which GoSpatial reads with the GeoTIFF."
 	return ret
 }
 
@@ -40,20 +47,21 @@ func (this *Whitebox2GeoTiff) SetToolManager(tm *PluginToolManager) {
 	this.toolManager = tm
 }
 
-func (this *Whitebox2GeoTiff) GetArgDescriptions() [][]string {
+func (this *Whitebox2GeoTiff) GetArgDescriptions() []ToolArg {
 	numArgs := 2
 
-	ret := make([][]string, numArgs)
-	for i := range ret {
-		ret[i] = make([]string, 3)
-	}
-	ret[0][0] = "InputFile"
-	ret[0][1] = "string"
-	ret[0][2] = "The input Whitebox GAT file name"
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input Whitebox GAT file name"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
 
-	ret[1][0] = "OutputFile"
-	ret[1][1] = "string"
-	ret[1][2] = "The output GeoTiff file name"
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output GeoTiff file name"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
 
 	return ret
 }
@@ -66,7 +74,7 @@ func (this *Whitebox2GeoTiff) ParseArguments(args []string) {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -96,7 +104,7 @@ func (this *Whitebox2GeoTiff) CollectArguments() {
 	}
 	this.inputFile = inputFile
 	// see if the file exists
-	if _, err := os.Stat(this.inputFile); os.IsNotExist(err) {
+	if !raster.FileExists(this.inputFile) {
 		printf("no such file or directory: %s\n", this.inputFile)
 		return
 	}
@@ -155,6 +163,7 @@ func (this *Whitebox2GeoTiff) Run() {
 	outConfig.EPSGCode = inConfig.EPSGCode
 	//outConfig.NoDataValue = inConfig.NoDataValue
 	outConfig.CoordinateRefSystemWKT = inConfig.CoordinateRefSystemWKT
+	outConfig.CopyDisplaySettings(inConfig)
 	output, err := raster.CreateNewRaster(this.outputFile, input.Rows, input.Columns,
 		input.North, input.South, input.East, input.West, outConfig)
 	outNodata := output.NoDataValue
@@ -163,6 +172,7 @@ func (this *Whitebox2GeoTiff) Run() {
 	}
 
 	var progress, oldProgress int
+	var eta progressETA
 	var z float64
 	oldProgress = -1
 	for row := 0; row < rows; row++ {
@@ -176,7 +186,7 @@ func (this *Whitebox2GeoTiff) Run() {
 		}
 		progress = int(100.0 * row / rowsLessOne)
 		if progress != oldProgress {
-			printf("\rProgress: %v%%", progress)
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
 			oldProgress = progress
 		}
 	}
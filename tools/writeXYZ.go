@@ -0,0 +1,250 @@
+// Copyright 2015 the GoSpatial Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// licence that can be found in the LICENCE.txt file.
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jblindsay/go-spatial/geospatialfiles/raster"
+)
+
+// WriteXYZ exports the cell centres and values of a raster to an x,y,z text
+// file.
+type WriteXYZ struct {
+	inputFile   string
+	outputFile  string
+	decimation  int
+	toolManager *PluginToolManager
+}
+
+func (this *WriteXYZ) GetName() string {
+	s := "WriteXYZ"
+	return getFormattedToolName(s)
+}
+
+func (this *WriteXYZ) GetDescription() string {
+	s := "Exports a raster to an x,y,z text (CSV) file"
+	return getFormattedToolDescription(s)
+}
+
+func (this *WriteXYZ) GetVersion() string {
+	return "1.0"
+}
+
+func (this *WriteXYZ) GetHelpDocumentation() string {
+	ret := "This tool exports a raster to a text file containing the x and y coordinates " +
+		"of the centre and the value of each valid (non-nodata) cell, one cell per line, in " +
+		"row-major order from the north-west corner. If the output file has a .csv extension " +
+		"(the default) the fields are separated by commas and preceded by an 'x,y,z' header, " +
+		"otherwise they are separated by spaces. The optional Decimation factor (default 1) " +
+		"thins the output to every nth row and column, e.g. to produce a quick preview of a " +
+		"large raster. Files written by this tool can be gridded again with the ReadXYZ tool."
+	return ret
+}
+
+func (this *WriteXYZ) SetToolManager(tm *PluginToolManager) {
+	this.toolManager = tm
+}
+
+func (this *WriteXYZ) GetArgDescriptions() []ToolArg {
+	numArgs := 3
+
+	ret := make([]ToolArg, numArgs)
+	ret[0].Name = "InputFile"
+	ret[0].Type = "string"
+	ret[0].Description = "The input raster name, with directory and file extension"
+	ret[0].Role = ArgInput
+	ret[0].Required = true
+
+	ret[1].Name = "OutputFile"
+	ret[1].Type = "string"
+	ret[1].Description = "The output text file name, with directory and extension"
+	ret[1].Role = ArgOutput
+	ret[1].Required = true
+
+	ret[2].Name = "Decimation"
+	ret[2].Type = "int"
+	ret[2].Description = "Output every nth row and column"
+	ret[2].Default = "1"
+
+	return ret
+}
+
+func (this *WriteXYZ) ParseArguments(args []string) {
+	if len(args) < 2 {
+		println("The input and output files must be specified.")
+		return
+	}
+	inputFile := args[0]
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+	this.setOutputFile(args[1])
+
+	this.decimation = 1
+	if len(args) > 2 && len(strings.TrimSpace(args[2])) > 0 && args[2] != "not specified" {
+		if !this.setDecimation(args[2]) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *WriteXYZ) CollectArguments() {
+	consolereader := bufio.NewReader(os.Stdin)
+
+	// get the input file name
+	print("Enter the raster file name (incl. file extension): ")
+	inputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	inputFile = strings.TrimSpace(inputFile)
+	if !strings.Contains(inputFile, pathSep) {
+		inputFile = this.toolManager.workingDirectory + inputFile
+	}
+	this.inputFile = inputFile
+	// see if the file exists
+	if !raster.FileExists(this.inputFile) {
+		printf("no such file or directory: %s\n", this.inputFile)
+		return
+	}
+
+	// get the output file name
+	print("Enter the output file name (incl. file extension): ")
+	outputFile, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	this.setOutputFile(outputFile)
+
+	// get the decimation factor
+	this.decimation = 1
+	print("Decimation factor (default 1): ")
+	decimationStr, err := consolereader.ReadString('\n')
+	if err != nil {
+		println(err)
+	}
+	if len(strings.TrimSpace(decimationStr)) > 0 {
+		if !this.setDecimation(decimationStr) {
+			return
+		}
+	}
+
+	this.Run()
+}
+
+func (this *WriteXYZ) setOutputFile(s string) {
+	outputFile := strings.TrimSpace(s)
+	if !strings.Contains(outputFile, pathSep) {
+		outputFile = this.toolManager.workingDirectory + outputFile
+	}
+	if filepath.Ext(outputFile) == "" {
+		outputFile = outputFile + ".csv"
+	}
+	this.outputFile = outputFile
+}
+
+func (this *WriteXYZ) setDecimation(s string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if v < 1 {
+		println("The decimation factor must be at least 1.")
+		return false
+	}
+	this.decimation = v
+	return true
+}
+
+func (this *WriteXYZ) Run() {
+	start1 := time.Now()
+
+	println("Reading raster data...")
+	rin, err := raster.CreateRasterFromFile(this.inputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	rows := rin.Rows
+	columns := rin.Columns
+	nodata := rin.NoDataValue
+	cellSizeX := rin.GetCellSizeX()
+	cellSizeY := rin.GetCellSizeY()
+
+	// values are written with the precision of the raster's data type
+	bitSize := 32
+	if rin.GetRasterConfig().DataType == raster.DT_FLOAT64 {
+		bitSize = 64
+	}
+
+	f, err := os.Create(this.outputFile)
+	if err != nil {
+		println(err.Error())
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	sep := " "
+	if strings.ToLower(filepath.Ext(this.outputFile)) == ".csv" {
+		sep = ","
+		w.WriteString("x,y,z\n")
+	}
+
+	start2 := time.Now()
+
+	numPoints := 0
+	oldProgress := -1
+	var eta progressETA
+	for row := 0; row < rows; row += this.decimation {
+		y := strconv.FormatFloat(rin.North-(float64(row)+0.5)*cellSizeY, 'f', -1, 64)
+		for col := 0; col < columns; col += this.decimation {
+			z := rin.Value(row, col)
+			if z == nodata {
+				continue
+			}
+			x := strconv.FormatFloat(rin.West+(float64(col)+0.5)*cellSizeX, 'f', -1, 64)
+			w.WriteString(x + sep + y + sep + strconv.FormatFloat(z, 'f', -1, bitSize) + "\n")
+			numPoints++
+		}
+		progress := int(100.0 * row / rows)
+		if progress != oldProgress {
+			printf("\rProgress: %v%%%s", progress, eta.remaining(progress))
+			oldProgress = progress
+		}
+	}
+	if err = w.Flush(); err != nil {
+		println(err.Error())
+		return
+	}
+	println("")
+
+	elapsed := time.Since(start2)
+	printf("Number of points written: %v\n", numPoints)
+	println("Operation complete!")
+
+	value := fmt.Sprintf("Elapsed time (excluding file I/O): %s", elapsed)
+	println(value)
+
+	overallTime := time.Since(start1)
+	value = fmt.Sprintf("Elapsed time (total): %s", overallTime)
+	println(value)
+}